@@ -0,0 +1,186 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spiffe/spire/pkg/agent/plugin/keymanager"
+	"github.com/spiffe/spire/pkg/common/catalog"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	pluginName = "barbican"
+
+	secretNamePrefix = "spire-agent-key-"
+)
+
+// BarbicanKeyManagerPlugin implements the keymanager Plugin interface.
+// The private key of the agent is stored in a Barbican secret instead of the local disk.
+type BarbicanKeyManagerPlugin struct {
+	logger     hclog.Logger
+	config     *BarbicanKeyManagerPluginConfig
+	secrets    openstack.SecretStoreClient
+	secretName string
+
+	mtx *sync.RWMutex
+
+	getSecretStoreHandler func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.SecretStoreClient, error)
+	getMetadataHandler    func(context.Context) (*openstack.Metadata, error)
+}
+
+type BarbicanKeyManagerPluginConfig struct {
+	// Name of cloud entry in clouds.yaml to use
+	CloudName string `hcl:"cloud_name"`
+	// Authentication options overriding the cloud entry
+	Auth *openstack.AuthConfig `hcl:"auth"`
+	// Name of the secret. Defaults to "spire-agent-key-<instance UUID>".
+	SecretName string `hcl:"secret_name"`
+}
+
+// BuiltIn constructs a catalog Plugin using a new instance of this plugin.
+func BuiltIn() catalog.Plugin {
+	return builtin(New())
+}
+
+func builtin(p *BarbicanKeyManagerPlugin) catalog.Plugin {
+	return catalog.MakePlugin(pluginName, keymanager.PluginServer(p))
+}
+
+func New() *BarbicanKeyManagerPlugin {
+	return &BarbicanKeyManagerPlugin{
+		mtx:                   &sync.RWMutex{},
+		getSecretStoreHandler: getOpenStackSecretStore,
+		getMetadataHandler:    openstack.GetMetadataFromMetadataService,
+	}
+}
+
+func (p *BarbicanKeyManagerPlugin) Configure(ctx context.Context, req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
+	config := &BarbicanKeyManagerPluginConfig{}
+	if err := common.DecodeConfig(config, req.Configuration); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
+	}
+	if config.CloudName == "" && config.Auth == nil {
+		return nil, errors.New("cloud_name or auth is required")
+	}
+
+	secretName := config.SecretName
+	if secretName == "" {
+		meta, err := p.getMetadataHandler(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve openstack metadata: %v", err)
+		}
+		secretName = secretNamePrefix + meta.UUID
+	}
+
+	secrets, err := p.getSecretStoreHandler(ctx, config.CloudName, config.Auth, p.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare OpenStack Key Manager Client: %v", err)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.secrets = secrets
+	p.secretName = secretName
+	p.config = config
+
+	return &spi.ConfigureResponse{}, nil
+}
+
+func (p *BarbicanKeyManagerPlugin) GetPluginInfo(context.Context, *spi.GetPluginInfoRequest) (*spi.GetPluginInfoResponse, error) {
+	return &spi.GetPluginInfoResponse{}, nil
+}
+
+// GenerateKeyPair returns a new ECDSA P-256 key pair. The key is persisted only by StorePrivateKey.
+func (p *BarbicanKeyManagerPlugin) GenerateKeyPair(context.Context, *keymanager.GenerateKeyPairRequest) (*keymanager.GenerateKeyPairResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	priv, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &keymanager.GenerateKeyPairResponse{
+		PublicKey:  pub,
+		PrivateKey: priv,
+	}, nil
+}
+
+func (p *BarbicanKeyManagerPlugin) StorePrivateKey(ctx context.Context, req *keymanager.StorePrivateKeyRequest) (*keymanager.StorePrivateKeyResponse, error) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	if p.config == nil {
+		return nil, errors.New("plugin not configured")
+	}
+	if _, err := x509.ParseECPrivateKey(req.PrivateKey); err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	if err := p.secrets.StoreSecret(ctx, p.secretName, req.PrivateKey); err != nil {
+		return nil, fmt.Errorf("failed to store private key to Barbican: %v", err)
+	}
+	p.logger.Info("Stored private key to Barbican", "secret_name", p.secretName)
+	return &keymanager.StorePrivateKeyResponse{}, nil
+}
+
+func (p *BarbicanKeyManagerPlugin) FetchPrivateKey(ctx context.Context, req *keymanager.FetchPrivateKeyRequest) (*keymanager.FetchPrivateKeyResponse, error) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	if p.config == nil {
+		return nil, errors.New("plugin not configured")
+	}
+
+	key, ok, err := p.secrets.GetSecretByName(ctx, p.secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch private key from Barbican: %v", err)
+	}
+	if !ok {
+		// the agent generates a new key
+		return &keymanager.FetchPrivateKeyResponse{}, nil
+	}
+	return &keymanager.FetchPrivateKeyResponse{
+		PrivateKey: key,
+	}, nil
+}
+
+// getOpenStackSecretStore returns authenticated openstack key manager client.
+func getOpenStackSecretStore(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.SecretStoreClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewSecretStore(provider, openstack.GetRegion(cloud), logger)
+}
+
+func (p *BarbicanKeyManagerPlugin) SetLogger(log hclog.Logger) {
+	p.logger = log
+}
+
+func main() {
+	catalog.PluginMain(BuiltIn())
+}
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spiffe/spire/pkg/agent/plugin/keymanager"
+	"github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func newTestPlugin(store *fake.SecretStore) *BarbicanKeyManagerPlugin {
+	return &BarbicanKeyManagerPlugin{
+		mtx:    &sync.RWMutex{},
+		logger: testutil.TestLogger(),
+		getSecretStoreHandler: func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.SecretStoreClient, error) {
+			return store, nil
+		},
+		getMetadataHandler: func(context.Context) (*openstack.Metadata, error) {
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		},
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	p := newTestPlugin(fake.NewSecretStore())
+	ctx := context.Background()
+
+	if _, err := p.Configure(ctx, &plugin.ConfigureRequest{}); err == nil {
+		t.Error("expected error without cloud_name")
+	}
+
+	if _, err := p.Configure(ctx, &plugin.ConfigureRequest{Configuration: `cloud_name = "test"`}); err != nil {
+		t.Fatalf("unexpected error from Configure(): %v", err)
+	}
+	if p.secretName != "spire-agent-key-alpha" {
+		t.Errorf("unexpected secret name: %v", p.secretName)
+	}
+
+	if _, err := p.Configure(ctx, &plugin.ConfigureRequest{Configuration: `cloud_name = "test"
+secret_name = "bravo"`}); err != nil {
+		t.Fatalf("unexpected error from Configure(): %v", err)
+	}
+	if p.secretName != "bravo" {
+		t.Errorf("unexpected secret name: %v", p.secretName)
+	}
+
+	p.getMetadataHandler = func(context.Context) (*openstack.Metadata, error) {
+		return nil, errors.New("unavailable")
+	}
+	if _, err := p.Configure(ctx, &plugin.ConfigureRequest{Configuration: `cloud_name = "test"`}); err == nil {
+		t.Error("expected error without metadata")
+	}
+}
+
+func TestStoreAndFetchPrivateKey(t *testing.T) {
+	store := fake.NewSecretStore()
+	p := newTestPlugin(store)
+	ctx := context.Background()
+
+	if _, err := p.FetchPrivateKey(ctx, &keymanager.FetchPrivateKeyRequest{}); err == nil {
+		t.Error("expected error before Configure()")
+	}
+	if _, err := p.Configure(ctx, &plugin.ConfigureRequest{Configuration: `cloud_name = "test"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p.FetchPrivateKey(ctx, &keymanager.FetchPrivateKeyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.PrivateKey) != 0 {
+		t.Error("expected no private key before StorePrivateKey()")
+	}
+
+	kp, err := p.GenerateKeyPair(ctx, &keymanager.GenerateKeyPairRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.StorePrivateKey(ctx, &keymanager.StorePrivateKeyRequest{PrivateKey: kp.PrivateKey}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(store.Secrets["spire-agent-key-alpha"], kp.PrivateKey) {
+		t.Error("private key is not stored in the secret")
+	}
+
+	resp, err = p.FetchPrivateKey(ctx, &keymanager.FetchPrivateKeyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.PrivateKey, kp.PrivateKey) {
+		t.Error("unexpected private key")
+	}
+
+	if _, err := p.StorePrivateKey(ctx, &keymanager.StorePrivateKeyRequest{PrivateKey: []byte("invalid")}); err == nil {
+		t.Error("expected error for invalid private key")
+	}
+}
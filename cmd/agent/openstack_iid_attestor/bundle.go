@@ -0,0 +1,106 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+const defaultBundleTarget = "spire_bundle"
+
+// bundleMain writes the trust bundle served by the vendordata signer to a file, which the agent bootstraps with as
+// its trust_bundle_path
+func bundleMain(args []string) int {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	target := fs.String("target", defaultBundleTarget, "name of the Nova dynamic vendordata target serving the trust bundle")
+	configDrive := fs.Bool("config-drive", false, "read the vendordata of the creation of the instance on the config drive instead of the metadata service")
+	out := fs.String("o", "bootstrap.crt", "file to write the trust bundle to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor bundle [-target <name>] [-config-drive] [-o <file>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	get := openstack.GetVendorDataFromMetadataService
+	if *configDrive {
+		get = func(_ context.Context, target string) (json.RawMessage, error) {
+			return openstack.GetVendorDataFromConfigDrive(target)
+		}
+	}
+	n, err := writeBundle(context.Background(), get, *target, *out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "trust bundle of %d certificates is written to %v\n", n, *out)
+	return 0
+}
+
+// writeBundle writes the trust bundle in the vendordata of target to path as PEM, and returns the number of its
+// certificates. The file isn't written unless the bundle holds valid certificates.
+func writeBundle(ctx context.Context, get func(context.Context, string) (json.RawMessage, error), target, path string) (int, error) {
+	raw, err := get(ctx, target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get vendordata of %v: %v", target, err)
+	}
+	b := &vendordata.TrustBundle{}
+	if err := json.Unmarshal(raw, b); err != nil {
+		return 0, fmt.Errorf("malformed trust bundle in vendordata of %v: %v", target, err)
+	}
+	certs, err := b.Certificates()
+	if err != nil {
+		return 0, fmt.Errorf("invalid trust bundle in vendordata of %v: %v", target, err)
+	}
+	// the bundle is public, but is written as re-encoded so that nothing but the certificates ends up in the file
+	if err := ioutil.WriteFile(path, []byte(vendordata.NewTrustBundle(certs).Bundle), 0644); err != nil {
+		return 0, err
+	}
+	return len(certs), nil
+}
+
+// bundleFingerprint returns the fingerprint of the trust bundle CA in the metadata of the instance. The trust bundle
+// the agent bootstraps with must hold the CA if trust_bundle_path is set, so that an agent bootstrapped with a stale
+// or rogue bundle fails on the instance instead of attesting.
+func bundleFingerprint(c *BundleFingerprintConfig, meta *openstack.Metadata, source string) (string, error) {
+	s, ok := meta.Meta[c.MetadataKey]
+	if !ok {
+		return "", fmt.Errorf("bundle fingerprint is not provisioned to the instance: no %q in the metadata from %v", c.MetadataKey, source)
+	}
+	f, err := vendordata.NormalizeFingerprint(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid bundle fingerprint in %q of the metadata: %v", c.MetadataKey, err)
+	}
+	if c.TrustBundlePath == "" {
+		return f, nil
+	}
+
+	certs, err := vendordata.LoadCertificates(c.TrustBundlePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load trust_bundle_path of bundle_fingerprint: %v", err)
+	}
+	for _, cert := range certs {
+		if vendordata.CertificateFingerprint(cert) == f {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("trust bundle %v holds no CA of fingerprint %v in the metadata", c.TrustBundlePath, f)
+}
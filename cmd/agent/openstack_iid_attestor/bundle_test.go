@@ -0,0 +1,166 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+func newTestRoot(t *testing.T) *x509.Certificate {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestWriteBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := newTestRoot(t)
+	bundle, err := json.Marshal(vendordata.NewTrustBundle([]*x509.Certificate{root}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		vendorData string
+		err        error
+		wantErr    bool
+	}{
+		// 0: the bundle is written
+		{vendorData: string(bundle)},
+		// 1: vendordata unavailable
+		{err: errors.New("not found"), wantErr: true},
+		// 2: no certificates in the bundle
+		{vendorData: `{"bundle": "none"}`, wantErr: true},
+		// 3: malformed vendordata
+		{vendorData: `"bundle"`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		path := filepath.Join(dir, "bootstrap.crt")
+		os.Remove(path)
+		get := func(_ context.Context, target string) (json.RawMessage, error) {
+			if target != defaultBundleTarget {
+				t.Errorf("#%v: unexpected target: %v", i, target)
+			}
+			return json.RawMessage(c.vendorData), c.err
+		}
+
+		n, err := writeBundle(context.Background(), get, defaultBundleTarget, path)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("#%v: bundle is written despite the error", i)
+			}
+			continue
+		}
+		if err != nil || n != 1 {
+			t.Errorf("#%v: unexpected result from writeBundle(): %v, %v", i, n, err)
+			continue
+		}
+		certs, err := vendordata.LoadCertificates(path)
+		if err != nil || len(certs) != 1 || !certs[0].Equal(root) {
+			t.Errorf("#%v: unexpected bundle file: %v, %v", i, certs, err)
+		}
+	}
+}
+
+func TestBundleFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, rogue := newTestRoot(t), newTestRoot(t)
+	fingerprint := vendordata.CertificateFingerprint(root)
+	path := filepath.Join(dir, "bootstrap.crt")
+	if err := ioutil.WriteFile(path, []byte(vendordata.NewTrustBundle([]*x509.Certificate{rogue, root}).Bundle), 0644); err != nil {
+		t.Fatal(err)
+	}
+	roguePath := filepath.Join(dir, "rogue.crt")
+	if err := ioutil.WriteFile(roguePath, []byte(vendordata.NewTrustBundle([]*x509.Certificate{rogue}).Bundle), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		meta    map[string]string
+		path    string
+		wantErr bool
+	}{
+		// 0: fingerprint in the metadata
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: fingerprint}},
+		// 1: fingerprint in the form of openssl
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: "SHA256 Fingerprint=" + strings.ToUpper(fingerprint)}},
+		// 2: the bootstrap bundle holds the CA among others
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: fingerprint}, path: path},
+		// 3: the bootstrap bundle lacks the CA
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: fingerprint}, path: roguePath, wantErr: true},
+		// 4: no bootstrap bundle
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: fingerprint}, path: filepath.Join(dir, "none.crt"), wantErr: true},
+		// 5: not provisioned
+		{meta: map[string]string{}, wantErr: true},
+		// 6: malformed fingerprint
+		{meta: map[string]string{defaultBundleFingerprintMetadataKey: "abc"}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		config := &BundleFingerprintConfig{MetadataKey: defaultBundleFingerprintMetadataKey, TrustBundlePath: c.path}
+		got, err := bundleFingerprint(config, &openstack.Metadata{Meta: c.meta}, metadataSourceService)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil || got != fingerprint {
+			t.Errorf("#%v: got %v, %v, want %v", i, got, err, fingerprint)
+		}
+	}
+}
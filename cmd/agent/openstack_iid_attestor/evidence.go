@@ -0,0 +1,102 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// evidenceMain collects the evidence the agent attests with and writes it to a file, which the server plugin binary
+// verifies offline. It returns 1 if the evidence is incomplete.
+func evidenceMain(args []string) int {
+	fs := flag.NewFlagSet("evidence", flag.ContinueOnError)
+	configFile := fs.String("config", "", "file of the plugin_data of the agent plugin (default: empty configuration)")
+	out := fs.String("o", "evidence.json", "file to write the evidence to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor evidence [-config <file>] [-o <file>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	var conf []byte
+	if *configFile != "" {
+		var err error
+		conf, err = ioutil.ReadFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	p := New()
+	p.SetLogger(hclog.New(&hclog.LoggerOptions{
+		Name:   "evidence",
+		Output: os.Stderr,
+		Level:  hclog.Warn,
+	}))
+	e := collectEvidence(context.Background(), p, string(conf))
+	if err := e.WriteFile(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for item, err := range e.Errors {
+		fmt.Fprintf(os.Stderr, "failed to collect %v: %v\n", item, err)
+	}
+	fmt.Fprintf(os.Stderr, "evidence is written to %v\n", *out)
+	if len(e.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// collectEvidence configures the plugin, which must not be configured yet, and collects the evidence.
+// Items which can't be collected are recorded as errors, so that the evidence helps troubleshooting anyway.
+func collectEvidence(ctx context.Context, p *IIDAttestorPlugin, conf string) *openstack.Evidence {
+	e := &openstack.Evidence{CollectedAt: time.Now().UTC()}
+	_, err := p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: conf,
+		GlobalConfig:  &spi.ConfigureRequest_GlobalConfig{TrustDomain: commandTrustDomain},
+	})
+	if err != nil {
+		e.AddError("configuration", err)
+		return e
+	}
+
+	if data, err := p.getNetworkDataHandler(ctx); err != nil {
+		e.AddError("network_data", err)
+	} else {
+		e.NetworkData = data
+	}
+	meta, source, err := p.metadata(ctx)
+	if err != nil {
+		e.AddError("metadata", err)
+		return e
+	}
+	e.Metadata, e.MetadataSource = meta, source
+	if data, err := p.attestationData(ctx); err != nil {
+		e.AddError("attestation_data", err)
+	} else {
+		e.AttestationData = data
+	}
+	return e
+}
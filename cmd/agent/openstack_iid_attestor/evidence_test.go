@@ -0,0 +1,81 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestCollectEvidence(t *testing.T) {
+	tCase := []struct {
+		conf           string
+		metadataErr    error
+		networkDataErr error
+		wantErrors     []string
+	}{
+		// 0: complete evidence with the signed identity
+		{conf: `payload_format = "json"
+signed_identity_target = "spire"`},
+		// 1: network data unavailable
+		{networkDataErr: errors.New("not found"), wantErrors: []string{"network_data"}},
+		// 2: metadata unavailable
+		{metadataErr: errors.New("connection refused"), wantErrors: []string{"metadata"}},
+	}
+
+	for i, c := range tCase {
+		p := New()
+		p.SetLogger(testutil.TestLogger())
+		p.probeOpenStackHandler = probeOpenStack
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			if c.metadataErr != nil {
+				return nil, c.metadataErr
+			}
+			return &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}, nil
+		}
+		p.getNetworkDataHandler = func(context.Context) (json.RawMessage, error) {
+			if c.networkDataErr != nil {
+				return nil, c.networkDataErr
+			}
+			return json.RawMessage(`{"links": [], "networks": [], "services": []}`), nil
+		}
+		p.getVendorDataHandler = func(context.Context, string) (json.RawMessage, error) {
+			return json.RawMessage(`{"kid": "k1", "alg": "EdDSA", "document": "e30", "signature": "c2ln"}`), nil
+		}
+
+		e := collectEvidence(context.Background(), p, c.conf)
+		if len(e.Errors) != len(c.wantErrors) {
+			t.Errorf("#%v: got errors %v, want errors of %v", i, e.Errors, c.wantErrors)
+			continue
+		}
+		for _, item := range c.wantErrors {
+			if _, ok := e.Errors[item]; !ok {
+				t.Errorf("#%v: got errors %v, want errors of %v", i, e.Errors, c.wantErrors)
+			}
+		}
+		if len(c.wantErrors) > 0 && c.wantErrors[0] == "metadata" {
+			continue
+		}
+		if e.MetadataSource != metadataSourceService || e.Metadata.UUID != "alpha" {
+			t.Errorf("#%v: unexpected metadata: %v %+v", i, e.MetadataSource, e.Metadata)
+		}
+		payload, err := common.ParseAttestationPayload(e.AttestationData)
+		if err != nil || payload.InstanceID != "alpha" {
+			t.Errorf("#%v: unexpected attestation data: %s", i, e.AttestationData)
+		}
+		if c.conf != "" && (payload == nil || payload.SignedIdentity == nil) {
+			t.Errorf("#%v: attestation data lacks the signed identity: %s", i, e.AttestationData)
+		}
+	}
+}
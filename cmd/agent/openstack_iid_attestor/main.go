@@ -9,12 +9,18 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/hcl"
 	"github.com/spiffe/spire/pkg/agent/plugin/nodeattestor"
 	"github.com/spiffe/spire/pkg/common/catalog"
 	spc "github.com/spiffe/spire/proto/spire/common"
@@ -22,23 +28,238 @@ import (
 
 	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
 )
 
 // IIDAttestorPlugin implements the nodeattestor Plugin interface
 type IIDAttestorPlugin struct {
-	logger   hclog.Logger
-	config   *IIDAttestorPluginConfig
-	metaData *openstack.Metadata
+	logger hclog.Logger
+	config *IIDAttestorPluginConfig
 
 	mtx *sync.RWMutex
 
-	getMetadataHandler func() (*openstack.Metadata, error)
+	// metaData is the metadata cached once retrieved at fetchedAt, and source is the metadata source it was retrieved
+	// from. They are guarded by metaMtx, since they are retrieved while mtx is read locked or not locked at all by
+	// background refreshes. metaGeneration counts the resets of the cache on configuration, which discard the
+	// refreshes started before.
+	metaData       *openstack.Metadata
+	source         string
+	fetchedAt      time.Time
+	refreshing     bool
+	metaGeneration uint64
+	metaMtx        sync.Mutex
+	// metaFlight collapses concurrent retrievals of the metadata, see fetchMetadata
+	metaFlight common.Flight
+
+	// drain tracks the attestations in flight, which hold the read lock, for Shutdown
+	drain    common.Drain
+	stopOnce sync.Once
+
+	getMetadataHandler               func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error)
+	getConfigDriveMetadataHandler    func() (*openstack.Metadata, error)
+	getConfigDriveVendorDataHandler  func(string) (json.RawMessage, error)
+	getConfigDriveNetworkDataHandler func() (json.RawMessage, error)
+	getCloudInitMetadataHandler      func() (*openstack.Metadata, error)
+	getVendorDataHandler             func(context.Context, string) (json.RawMessage, error)
+	getNetworkDataHandler            func(context.Context) (json.RawMessage, error)
+	getDMIUUIDHandler                func() (string, error)
+	getBootTimeHandler               func() (time.Time, error)
+	writeConsoleHandler              func(device, line string) error
+	probeOpenStackHandler            func(context.Context, *openstack.MetadataService) (string, error)
 }
 
 type IIDAttestorPluginConfig struct {
 	trustDomain string
+	// sources are the metadata sources to try in order
+	sources []string
+
+	// Where to get metadata from, "metadata_service" or "config_drive". Defaults to "metadata_service".
+	MetadataSource string `hcl:"metadata_source" default:"metadata_service"`
+	// Metadata sources tried in order until one succeeds, e.g. ["metadata_service", "config_drive"].
+	// Exclusive with metadata_source.
+	MetadataSources []string `hcl:"metadata_sources"`
+	// If true, the instance ID cloud-init recorded at boot is used as the last resort when the other sources are
+	// unavailable, e.g. once the metadata service is firewalled.
+	CloudInitFallback bool `hcl:"cloud_init_fallback"`
+	// Retries of requests to the metadata service which failed transiently. A single attempt is made if not set.
+	MetadataRetry *MetadataRetryConfig `hcl:"metadata_retry"`
+	// Deadline of each request to the metadata service, e.g. "5s". Defaults to 10 seconds.
+	MetadataTimeout string `hcl:"metadata_timeout" default:"10s"`
+	// If true, the metadata service is reached at its IPv6 link-local address, for instances on IPv6-only networks
+	MetadataIPv6 bool `hcl:"metadata_ipv6"`
+	// Network interface the metadata service is reached on, e.g. "eth1", for instances whose default route doesn't
+	// reach it. With metadata_ipv6, defaults to the first interface which is up and has an IPv6 link-local address.
+	MetadataInterface string `hcl:"metadata_interface"`
+	// Local address requests to the metadata service are sent from, e.g. of a NIC routed by source policy
+	MetadataSourceAddress string `hcl:"metadata_source_address"`
+	// Local proxy the metadata service is reached through, "unix:///path/to/socket" or "http://host:port", for
+	// images routing metadata through a sidecar. Empty to reach the metadata service itself.
+	MetadataTransport string `hcl:"metadata_transport"`
+	// Session token requests to the metadata service carry, for proxies requiring one in the style of AWS IMDSv2.
+	// Disabled if not set.
+	MetadataSessionToken *MetadataSessionTokenConfig `hcl:"metadata_session_token"`
+	metadataService      *openstack.MetadataService
+	// Age after which the cached metadata is retrieved again, e.g. "1h", so that changes of the instance such as a
+	// new name reach the payload. The metadata is cached for the lifetime of the plugin if not set.
+	MetadataCacheTTL string `hcl:"metadata_cache_ttl"`
+	metadataCacheTTL time.Duration
+	// If true, metadata older than metadata_cache_ttl is refreshed in the background while attestations use the
+	// cached metadata, instead of being retrieved again by the attestation. Requires metadata_cache_ttl.
+	MetadataBackgroundRefresh bool `hcl:"metadata_background_refresh"`
+
+	// If false, the plugin is disabled instead of failing to configure on hosts found not to be OpenStack instances,
+	// so that a single agent configuration serves mixed fleets. Defaults to true.
+	RequireOpenStack *bool `hcl:"require_openstack" default:"true"`
+	// disabled tells why the plugin is disabled, if it is
+	disabled error
+	// Duration Configure probes the host again for, e.g. "2m", while it isn't found to be an OpenStack instance, so
+	// that the agent needn't be restarted when the network comes up after it. Configure doesn't wait if not set.
+	MetadataWait string `hcl:"metadata_wait"`
+	metadataWait time.Duration
+
+	// Deadline of an attestation, e.g. "30s". Defaults to 30 seconds.
+	AttestationTimeout string `hcl:"attestation_timeout" default:"30s"`
+	attestationTimeout time.Duration
+
+	// If true, the instance UUID from the metadata must match the product UUID of the DMI table set by the
+	// hypervisor, so that a spoofed metadata service can't make the agent attest as another instance. Linux only.
+	VerifyDMIUUID bool `hcl:"verify_dmi_uuid"`
+
+	// Format of the attestation data, "raw" for the bare instance ID or "json". Defaults to "raw",
+	// which servers of any version accept. Use "json" once all servers understand it.
+	PayloadFormat string `hcl:"payload_format" default:"raw"`
+	// Version of the JSON payload, 2 to include the metadata of the instance or 1 for the legacy payload, which
+	// servers predating version 2 log as unknown fields otherwise. Defaults to 2.
+	PayloadVersion int `hcl:"payload_version" default:"2"`
+	// If true, the fixed IPs and MAC addresses of network_data.json of the metadata source are sent in the metadata
+	// of the payload, so that servers can cross-check them against the Neutron ports with verify_payload_network.
+	// Requires payload_version 2.
+	PayloadNetwork bool `hcl:"payload_network"`
+	// If true, the payload lists the versions the agent can send, up to payload_version, and servers supporting
+	// negotiation pick the version in a version challenge, which the agent sends its attestation data again in.
+	// Servers predating negotiation attest the payload as sent. Requires payload_format "json".
+	NegotiatePayloadVersion bool `hcl:"negotiate_payload_version"`
+	// payloadVersions are the versions listed in the payload with negotiate_payload_version
+	payloadVersions []int
+
+	// Name of the Nova dynamic vendordata target serving the signed identity of the instance.
+	// The signed identity is read from the metadata service and sent along with the instance ID if set.
+	// Requires payload_format "json".
+	SignedIdentityTarget string `hcl:"signed_identity_target"`
+
+	// Name of the logical node of this agent, e.g. "containerd", so that several agents on the instance get distinct
+	// agent IDs. The server must allow it in logical_node_pattern. Requires payload_format "json".
+	LogicalNode string `hcl:"logical_node"`
+
+	// Compression of the attestation data, "gzip", "auto" to compress only attestation data which would exceed the
+	// size limit of the server otherwise, or empty for none. Requires payload_format "json".
+	PayloadCompression string `hcl:"payload_compression"`
+
+	// Authenticates the attestation data with a secret provisioned to the instance on the config drive.
+	// Requires payload_format "json".
+	PayloadHMAC *PayloadHMACConfig `hcl:"payload_hmac"`
+
+	// Encrypts the attestation data to a public key of the server, so that intermediaries between the agent and
+	// the server don't see the instance ID and the metadata.
+	PayloadEncryption *PayloadEncryptionConfig `hcl:"payload_encryption"`
+
+	// Sends the fingerprint of the trust bundle CA placed in the instance metadata by the provisioning pipeline, which
+	// the server verifies against its current trust bundle. Requires payload_format "json".
+	BundleFingerprint *BundleFingerprintConfig `hcl:"bundle_fingerprint"`
+
+	// If true, console beacon challenges of the server are answered by writing the beacon to console_device.
+	// Enable it before console_beacon of the server.
+	ConsoleBeacon bool `hcl:"console_beacon"`
+	// Serial console device the beacon is written to. Defaults to "/dev/ttyS0".
+	ConsoleDevice string `hcl:"console_device" default:"/dev/ttyS0"`
 }
 
+// MetadataRetryConfig configures the retries of requests to the metadata service with exponential backoff
+type MetadataRetryConfig struct {
+	// Number of attempts including the first one. Defaults to 5.
+	MaxAttempts int `hcl:"max_attempts" default:"5"`
+	// Delay before the first retry, doubled for every retry, e.g. "1s". Defaults to 1 second.
+	BaseDelay string `hcl:"base_delay" default:"1s"`
+	// Maximum delay between retries, e.g. "10s". Defaults to 10 seconds.
+	MaxDelay string `hcl:"max_delay" default:"10s"`
+	// Fraction of each delay randomized, from 0 to 1. Defaults to 0.
+	Jitter float64 `hcl:"jitter"`
+
+	policy *openstack.RetryPolicy
+}
+
+// MetadataSessionTokenConfig configures the session token of metadata proxies requiring one, which is issued for a
+// PUT request and sent in a header of each request for metadata
+type MetadataSessionTokenConfig struct {
+	// Path of the token endpoint. Defaults to "/latest/api/token".
+	Path string `hcl:"path" default:"/latest/api/token"`
+	// Lifetime of the tokens requested, e.g. "1h". Defaults to 6 hours.
+	TTL string `hcl:"ttl" default:"6h"`
+	// Header of token requests stating the lifetime in seconds. Defaults to "X-aws-ec2-metadata-token-ttl-seconds".
+	TTLHeader string `hcl:"ttl_header" default:"X-aws-ec2-metadata-token-ttl-seconds"`
+	// Header of requests for metadata carrying the token. Defaults to "X-aws-ec2-metadata-token".
+	Header string `hcl:"header" default:"X-aws-ec2-metadata-token"`
+}
+
+// PayloadEncryptionConfig configures the encryption of the attestation data
+type PayloadEncryptionConfig struct {
+	// ID of the key, matching a payload_encryption_key of the server
+	KeyID string `hcl:"key_id"`
+	// Path to the PEM encoded RSA public key of the server
+	PublicKeyFile string `hcl:"public_key_file"`
+
+	publicKey *rsa.PublicKey
+}
+
+// PayloadHMACConfig configures the authentication of the attestation data with HMAC
+type PayloadHMACConfig struct {
+	// Key of the instance metadata on the config drive holding the secret as "<key ID>:<base64 encoded secret>".
+	// Defaults to "spire_hmac_secret" unless vendordata_target is set.
+	MetadataKey string `hcl:"metadata_key" default:"spire_hmac_secret"`
+	// Target of the dynamic vendordata on the config drive holding the secret of the project provisioned by the
+	// vendordata signer, as "hmac_secret". Exclusive with metadata_key.
+	VendorDataTarget string `hcl:"vendordata_target"`
+}
+
+// BundleFingerprintConfig configures the fingerprint of the trust bundle sent to the server
+type BundleFingerprintConfig struct {
+	// Key of the instance metadata holding the SHA-256 fingerprint of the trust bundle CA, in hex optionally separated
+	// by colons. Defaults to "spire_bundle_fingerprint".
+	MetadataKey string `hcl:"metadata_key" default:"spire_bundle_fingerprint"`
+	// Path of the trust bundle the SPIRE Agent bootstraps with, i.e. its trust_bundle_path. If set, attestation fails
+	// unless the bundle holds the CA of the fingerprint. Optional.
+	TrustBundlePath string `hcl:"trust_bundle_path"`
+}
+
+const (
+	metadataSourceService     = "metadata_service"
+	metadataSourceConfigDrive = "config_drive"
+	// metadataSourceCloudInit is appended to the sources by cloud_init_fallback, and can't be listed in them
+	metadataSourceCloudInit = "cloud_init"
+
+	defaultAttestationTimeout = 30 * time.Second
+	defaultMetadataTimeout    = 10 * time.Second
+
+	// metadataWaitInterval is the interval of the probes of Configure within metadata_wait
+	metadataWaitInterval = 2 * time.Second
+
+	payloadFormatRaw  = "raw"
+	payloadFormatJSON = "json"
+
+	payloadCompressionGzip = "gzip"
+	payloadCompressionAuto = "auto"
+
+	defaultConsoleDevice = "/dev/ttyS0"
+
+	defaultHMACMetadataKey = "spire_hmac_secret"
+
+	defaultBundleFingerprintMetadataKey = "spire_bundle_fingerprint"
+
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 10 * time.Second
+)
+
 // BuiltIn constructs a catalog Plugin using a new instance of this plugin.
 func BuiltIn() catalog.Plugin {
 	return builtin(New())
@@ -49,15 +270,32 @@ func builtin(p *IIDAttestorPlugin) catalog.Plugin {
 }
 
 func New() *IIDAttestorPlugin {
-	return &IIDAttestorPlugin{
-		mtx:                &sync.RWMutex{},
-		getMetadataHandler: openstack.GetMetadataFromMetadataService,
+	p := &IIDAttestorPlugin{
+		mtx:                              &sync.RWMutex{},
+		getConfigDriveMetadataHandler:    openstack.GetMetadataFromConfigDrive,
+		getConfigDriveVendorDataHandler:  openstack.GetVendorDataFromConfigDrive,
+		getConfigDriveNetworkDataHandler: openstack.GetNetworkDataFromConfigDrive,
+		getCloudInitMetadataHandler:      openstack.GetMetadataFromCloudInit,
+		getDMIUUIDHandler:                openstack.GetDMIProductUUID,
+		getBootTimeHandler:               openstack.GetBootTime,
+		writeConsoleHandler:              writeConsole,
+		probeOpenStackHandler:            openstack.ProbeInstance,
+	}
+	p.getMetadataHandler = func(ctx context.Context, m *openstack.MetadataService) (*openstack.Metadata, error) {
+		return m.GetMetadata(ctx)
+	}
+	p.getVendorDataHandler = func(ctx context.Context, target string) (json.RawMessage, error) {
+		return p.config.getMetadataService().GetVendorData(ctx, target)
+	}
+	p.getNetworkDataHandler = func(ctx context.Context) (json.RawMessage, error) {
+		return p.config.getMetadataService().GetNetworkData(ctx)
 	}
+	return p
 }
 
 func (p *IIDAttestorPlugin) Configure(ctx context.Context, req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
 	config := &IIDAttestorPluginConfig{}
-	if err := hcl.Decode(config, req.Configuration); err != nil {
+	if err := common.DecodeConfig(config, req.Configuration); err != nil {
 		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
 	}
 
@@ -68,21 +306,226 @@ func (p *IIDAttestorPlugin) Configure(ctx context.Context, req *spi.ConfigureReq
 		return nil, errors.New("trust_domain is required")
 	}
 
-	p.mtx.Lock()
-	defer p.mtx.Unlock()
+	sources, err := metadataSources(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMetadataRetry(config.MetadataRetry); err != nil {
+		return nil, err
+	}
+
+	switch config.PayloadFormat {
+	case "":
+		config.PayloadFormat = payloadFormatRaw
+	case payloadFormatRaw, payloadFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown payload_format: %q", config.PayloadFormat)
+	}
+	switch {
+	case config.PayloadFormat != payloadFormatJSON:
+		if config.PayloadVersion != 0 {
+			return nil, errors.New("payload_version requires payload_format \"json\"")
+		}
+	case config.PayloadVersion == 0:
+		config.PayloadVersion = common.PayloadVersion2
+	case config.PayloadVersion != common.PayloadVersion1 && config.PayloadVersion != common.PayloadVersion2:
+		return nil, fmt.Errorf("unknown payload_version: %v", config.PayloadVersion)
+	}
+	if config.PayloadNetwork && config.PayloadVersion < common.PayloadVersion2 {
+		return nil, fmt.Errorf("payload_network requires payload_version %v", common.PayloadVersion2)
+	}
+	if config.NegotiatePayloadVersion {
+		if config.PayloadFormat != payloadFormatJSON {
+			return nil, errors.New("negotiate_payload_version requires payload_format \"json\"")
+		}
+		for _, v := range common.SupportedPayloadVersions() {
+			// network data is sent in version 2 only
+			if v <= config.PayloadVersion && (!config.PayloadNetwork || v >= common.PayloadVersion2) {
+				config.payloadVersions = append(config.payloadVersions, v)
+			}
+		}
+	}
+	if config.SignedIdentityTarget != "" && config.PayloadFormat != payloadFormatJSON {
+		return nil, errors.New("signed_identity_target requires payload_format \"json\"")
+	}
+	if config.LogicalNode != "" {
+		if config.PayloadFormat != payloadFormatJSON {
+			return nil, errors.New("logical_node requires payload_format \"json\"")
+		}
+		if err := common.ValidateLogicalNode(config.LogicalNode); err != nil {
+			return nil, err
+		}
+	}
+	switch config.PayloadCompression {
+	case "":
+	case payloadCompressionGzip, payloadCompressionAuto:
+		if config.PayloadFormat != payloadFormatJSON {
+			return nil, errors.New("payload_compression requires payload_format \"json\"")
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload_compression: %q", config.PayloadCompression)
+	}
+
+	if config.PayloadHMAC != nil {
+		if config.PayloadFormat != payloadFormatJSON {
+			return nil, errors.New("payload_hmac requires payload_format \"json\"")
+		}
+		switch c := config.PayloadHMAC; {
+		case c.MetadataKey != "" && c.VendorDataTarget != "":
+			return nil, errors.New("metadata_key and vendordata_target of payload_hmac are mutually exclusive")
+		case c.MetadataKey == "" && c.VendorDataTarget == "":
+			c.MetadataKey = defaultHMACMetadataKey
+		}
+	}
+
+	if c := config.BundleFingerprint; c != nil {
+		if config.PayloadFormat != payloadFormatJSON {
+			return nil, errors.New("bundle_fingerprint requires payload_format \"json\"")
+		}
+		if c.MetadataKey == "" {
+			c.MetadataKey = defaultBundleFingerprintMetadataKey
+		}
+	}
+
+	if c := config.PayloadEncryption; c != nil {
+		if c.KeyID == "" || c.PublicKeyFile == "" {
+			return nil, errors.New("payload_encryption requires key_id and public_key_file")
+		}
+		pub, err := vendordata.LoadPublicKey(c.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public_key_file of payload_encryption: %v", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public_key_file of payload_encryption is not an RSA key: %T", pub)
+		}
+		c.publicKey = rsaPub
+	}
 
-	meta, err := p.getMetadataHandler()
+	if config.ConsoleBeacon && config.ConsoleDevice == "" {
+		config.ConsoleDevice = defaultConsoleDevice
+	}
+
+	config.attestationTimeout = defaultAttestationTimeout
+	if config.AttestationTimeout != "" {
+		d, err := time.ParseDuration(config.AttestationTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid attestation_timeout: %q", config.AttestationTimeout)
+		}
+		config.attestationTimeout = d
+	}
+	metadataTimeout := defaultMetadataTimeout
+	if config.MetadataTimeout != "" {
+		d, err := time.ParseDuration(config.MetadataTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid metadata_timeout: %q", config.MetadataTimeout)
+		}
+		metadataTimeout = d
+	}
+	if config.MetadataCacheTTL != "" {
+		d, err := time.ParseDuration(config.MetadataCacheTTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid metadata_cache_ttl: %q", config.MetadataCacheTTL)
+		}
+		config.metadataCacheTTL = d
+	}
+	if config.MetadataWait != "" {
+		d, err := time.ParseDuration(config.MetadataWait)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid metadata_wait: %q", config.MetadataWait)
+		}
+		config.metadataWait = d
+	}
+	if config.MetadataBackgroundRefresh && config.metadataCacheTTL == 0 {
+		return nil, errors.New("metadata_background_refresh requires metadata_cache_ttl")
+	}
+	if a := config.MetadataSourceAddress; a != "" {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid metadata_source_address: %q", a)
+		}
+		if (ip.To4() == nil) != config.MetadataIPv6 {
+			return nil, errors.New("metadata_source_address must be an IPv6 address with metadata_ipv6, and an IPv4 address otherwise")
+		}
+	}
+	if err := openstack.ValidateMetadataTransport(config.MetadataTransport); err != nil {
+		return nil, err
+	}
+	if config.MetadataTransport != "" && (config.MetadataIPv6 || config.MetadataInterface != "" || config.MetadataSourceAddress != "") {
+		return nil, errors.New("metadata_transport is mutually exclusive with metadata_ipv6, metadata_interface and metadata_source_address")
+	}
+	sessionToken, err := metadataSessionToken(config.MetadataSessionToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve openstack metadta: %v", err)
+		return nil, err
+	}
+	config.metadataService = &openstack.MetadataService{
+		Timeout:       metadataTimeout,
+		IPv6:          config.MetadataIPv6,
+		Interface:     config.MetadataInterface,
+		SourceAddress: config.MetadataSourceAddress,
+		Transport:     config.MetadataTransport,
+		SessionToken:  sessionToken,
+	}
+	if config.MetadataRetry != nil {
+		config.metadataService.Retry = config.MetadataRetry.policy
 	}
 
-	p.metaData = meta
+	// Hosts off OpenStack are told apart here, rather than by a timeout of the metadata service on attestation
+	found, err := p.probeOpenStack(ctx, config.metadataService, config.metadataWait)
+	switch {
+	case err == nil:
+		p.logger.Debug("Found an OpenStack instance", "probe", found)
+	case config.RequireOpenStack == nil || *config.RequireOpenStack:
+		return nil, err
+	default:
+		p.logger.Warn("Plugin disabled since require_openstack is false", "reason", err)
+		config.disabled = err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// The metadata is retrieved again on the next attestation, from the sources of the new configuration
+	p.metaMtx.Lock()
+	p.metaData = nil
+	p.source = ""
+	p.refreshing = false
+	p.metaGeneration++
+	p.metaMtx.Unlock()
+
+	config.sources = sources
 	config.trustDomain = req.GlobalConfig.TrustDomain
 	p.config = config
 
 	return &spi.ConfigureResponse{}, nil
 }
 
+// probeOpenStack probes whether the host is an OpenStack instance, probing again every metadataWaitInterval for up
+// to wait while it isn't found to be one, e.g. while the network isn't up yet to reach the metadata service.
+func (p *IIDAttestorPlugin) probeOpenStack(ctx context.Context, m *openstack.MetadataService, wait time.Duration) (string, error) {
+	deadline := time.Now().Add(wait)
+	for attempt := 1; ; attempt++ {
+		found, err := p.probeOpenStackHandler(ctx, m)
+		if err == nil {
+			return found, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return "", err
+		}
+		delay := metadataWaitInterval
+		if delay > remaining {
+			delay = remaining
+		}
+		p.logger.Info("Waiting for the metadata service", "attempt", attempt, "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(delay):
+		}
+	}
+}
+
 func (p *IIDAttestorPlugin) GetPluginInfo(context.Context, *spi.GetPluginInfoRequest) (*spi.GetPluginInfoResponse, error) {
 	return &spi.GetPluginInfoResponse{}, nil
 }
@@ -90,19 +533,616 @@ func (p *IIDAttestorPlugin) GetPluginInfo(context.Context, *spi.GetPluginInfoReq
 func (p *IIDAttestorPlugin) FetchAttestationData(stream nodeattestor.NodeAttestor_FetchAttestationDataServer) error {
 	p.logger.Info("Prepare Attestation Request")
 
+	streamCtx, done, ok := p.drain.Enter(stream.Context())
+	if !ok {
+		return errShuttingDown
+	}
+	defer done()
+
 	p.mtx.RLock()
 	defer p.mtx.RUnlock()
 
-	if p.config == nil || p.metaData == nil {
+	if p.config == nil {
 		return errors.New("plugin not configured")
 	}
+	if p.config.disabled != nil {
+		return fmt.Errorf("plugin disabled: %v", p.config.disabled)
+	}
 
-	return stream.Send(&nodeattestor.FetchAttestationDataResponse{
-		AttestationData: &spc.AttestationData{
-			Type: common.PluginName,
-			Data: []byte(p.metaData.UUID),
-		},
+	// The deadline keeps a stalled metadata service or server from pinning the stream and the read lock
+	ctx, cancel := context.WithTimeout(streamCtx, p.config.attestationTimeout)
+	defer cancel()
+
+	data, err := p.attestationData(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = common.CallWithContext(ctx, func() error {
+		return stream.Send(&nodeattestor.FetchAttestationDataResponse{
+			AttestationData: &spc.AttestationData{
+				Type: common.PluginName,
+				Data: data,
+			},
+		})
 	})
+	if err != nil || (!p.config.ConsoleBeacon && p.config.PayloadHMAC == nil && !p.config.NegotiatePayloadVersion) {
+		return err
+	}
+	return p.answerChallenges(ctx, stream)
+}
+
+// answerChallenges answers the challenges of the server until it closes the stream
+func (p *IIDAttestorPlugin) answerChallenges(ctx context.Context, stream nodeattestor.NodeAttestor_FetchAttestationDataServer) error {
+	for {
+		var req *nodeattestor.FetchAttestationDataRequest
+		err := common.CallWithContext(ctx, func() (err error) {
+			req, err = stream.Recv()
+			return err
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(req.Challenge) == 0 {
+			continue
+		}
+
+		challenge, err := common.ParseChallenge(req.Challenge)
+		if err != nil {
+			return err
+		}
+		answer, err := p.answerChallenge(ctx, challenge)
+		if err != nil {
+			return err
+		}
+		resp, err := answer.Marshal()
+		if err != nil {
+			return err
+		}
+		err = common.CallWithContext(ctx, func() error {
+			return stream.Send(&nodeattestor.FetchAttestationDataResponse{Response: resp})
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// answerChallenge carries out a challenge of the types enabled by the configuration
+func (p *IIDAttestorPlugin) answerChallenge(ctx context.Context, challenge *common.Challenge) (*common.ChallengeResponse, error) {
+	resp := &common.ChallengeResponse{Type: challenge.Type, Nonce: challenge.Nonce}
+	switch {
+	case challenge.Type == common.ChallengeConsoleBeacon && p.config.ConsoleBeacon:
+		p.logger.Info("Writing console beacon", "device", p.config.ConsoleDevice)
+		if err := p.writeConsoleHandler(p.config.ConsoleDevice, common.ConsoleBeaconLine(challenge.Nonce)); err != nil {
+			return nil, fmt.Errorf("failed to write console beacon: %v", err)
+		}
+	case challenge.Type == common.ChallengeHMAC && p.config.PayloadHMAC != nil:
+		// The secret is read again, so that a secret rotated since the attestation data is used
+		keyID, secret, err := p.hmacSecret(p.config.PayloadHMAC)
+		if err != nil {
+			return nil, err
+		}
+		p.logger.Info("Answering hmac challenge", "key_id", keyID)
+		resp.SignChallenge(keyID, secret, challenge.Nonce)
+	case challenge.Type == common.ChallengeVersion && p.config.NegotiatePayloadVersion:
+		if err := p.answerVersionChallenge(ctx, challenge, resp); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported challenge: %q", challenge.Type)
+	}
+	return resp, nil
+}
+
+// answerVersionChallenge picks the highest payload version the server advertised and the agent can send, and attaches
+// the attestation data in that version unless it was sent in it already
+func (p *IIDAttestorPlugin) answerVersionChallenge(ctx context.Context, challenge *common.Challenge, resp *common.ChallengeResponse) error {
+	version, err := common.NegotiatePayloadVersion(p.config.payloadVersions, challenge.Versions)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("Answering version challenge", "version", version, "advertised", challenge.Versions)
+	resp.Version = version
+	if version == p.config.PayloadVersion {
+		return nil
+	}
+	payload, err := p.payloadOfVersion(ctx, version, nil)
+	if err != nil {
+		return err
+	}
+	resp.AttestationData, err = p.encodeAttestationData(payload)
+	return err
+}
+
+// writeConsole writes a line to the console device. The line starts on a new line, since the console may be in the
+// middle of another line.
+func writeConsole(device, line string) error {
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "\r\n%v\r\n", line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// attestationData encodes the attestation data in the configured format, see encodeAttestationData
+func (p *IIDAttestorPlugin) attestationData(ctx context.Context) ([]byte, error) {
+	payload, err := p.attestationPayload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.encodeAttestationData(payload)
+}
+
+// attestationPayload prepares the attestation payload of payload_version, see payloadOfVersion
+func (p *IIDAttestorPlugin) attestationPayload(ctx context.Context) (*common.AttestationPayload, error) {
+	return p.payloadOfVersion(ctx, p.config.PayloadVersion, p.config.payloadVersions)
+}
+
+// payloadOfVersion prepares the attestation payload of the version from the metadata, listing the versions to
+// negotiate if any, signed with the HMAC if configured
+func (p *IIDAttestorPlugin) payloadOfVersion(ctx context.Context, version int, versions []int) (*common.AttestationPayload, error) {
+	meta, source, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payload := &common.AttestationPayload{InstanceID: meta.UUID}
+	if err := payload.Validate(); err != nil {
+		return nil, fmt.Errorf("metadata from %v has unusable uuid: %v", source, err)
+	}
+	if p.config.VerifyDMIUUID {
+		if err := p.checkDMIUUID(meta.UUID); err != nil {
+			return nil, fmt.Errorf("metadata from %v is not of this instance: %v", source, err)
+		}
+	}
+	// The project hints the server which scope to look the instance up in, when it is project scoped
+	payload.ProjectID = meta.ProjectID
+	payload.LogicalNode = p.config.LogicalNode
+	payload.Versions = versions
+	if version >= common.PayloadVersion2 {
+		payload.Version = common.PayloadVersion2
+		payload.Metadata = &common.PayloadMetadata{
+			Name:             meta.Name,
+			Hostname:         meta.Hostname,
+			AvailabilityZone: meta.AvailabilityZone,
+			Source:           source,
+		}
+		// The launch time is left out where the boot time is unknown, and the server doesn't compare it then
+		if t, err := p.getBootTimeHandler(); err != nil {
+			p.logger.Debug("Sending no launch time", "error", err)
+		} else {
+			payload.Metadata.LaunchedAt = t.UTC().Format(time.RFC3339)
+		}
+		if p.config.PayloadNetwork {
+			if err := p.addNetworkData(ctx, payload.Metadata); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if target := p.config.SignedIdentityTarget; target != "" {
+		raw, err := p.getVendorDataHandler(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve signed identity: %v", err)
+		}
+		payload.SignedIdentity = &vendordata.SignedIdentity{}
+		if err := json.Unmarshal(raw, payload.SignedIdentity); err != nil {
+			return nil, fmt.Errorf("malformed signed identity in vendordata of %v: %v", target, err)
+		}
+	}
+
+	if c := p.config.BundleFingerprint; c != nil {
+		payload.BundleFingerprint, err = bundleFingerprint(c, meta, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c := p.config.PayloadHMAC; c != nil {
+		keyID, secret, err := p.hmacSecret(c)
+		if err != nil {
+			return nil, err
+		}
+		// The nonce makes the server accept the payload only once
+		nonce, err := common.NewHMACNonce()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate hmac nonce: %v", err)
+		}
+		payload.SignHMAC(keyID, secret, nonce, time.Now())
+	}
+	return payload, nil
+}
+
+// encodeAttestationData encodes the payload in the configured format. It is checked against the schema and size
+// limits of the server plugin, so that malformed data fails here with an actionable error instead of an opaque
+// rejection by the server.
+func (p *IIDAttestorPlugin) encodeAttestationData(payload *common.AttestationPayload) ([]byte, error) {
+	data := []byte(payload.InstanceID)
+	if p.config.PayloadFormat == payloadFormatJSON {
+		var err error
+		data, err = payload.Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode attestation payload: %v", err)
+		}
+	}
+	if p.compress(data) {
+		var err error
+		data, err = common.CompressPayload(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress attestation payload: %v", err)
+		}
+	}
+	if _, err := common.ParseAttestationPayload(data); err != nil {
+		return nil, fmt.Errorf("attestation data would be rejected by the server: %v", err)
+	}
+	if c := p.config.PayloadEncryption; c != nil {
+		var err error
+		data, err = common.EncryptPayload(data, c.KeyID, c.publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt attestation payload: %v", err)
+		}
+	}
+
+	if len(data) > common.MaxAttestationPayloadSize {
+		return nil, fmt.Errorf("attestation data is %d bytes, exceeding the limit of %d bytes of the server", len(data), common.MaxAttestationPayloadSize)
+	}
+	return data, nil
+}
+
+// compress returns true if the encoded payload is to be compressed, which in auto mode is only if the attestation data
+// would exceed the size limit of the server otherwise, so that servers predating compression accept smaller payloads
+func (p *IIDAttestorPlugin) compress(data []byte) bool {
+	switch p.config.PayloadCompression {
+	case payloadCompressionGzip:
+		return true
+	case payloadCompressionAuto:
+		size := len(data)
+		if c := p.config.PayloadEncryption; c != nil {
+			size += common.EncryptionOverhead(c.KeyID, c.publicKey)
+		}
+		if size <= common.MaxAttestationPayloadSize {
+			return false
+		}
+		p.logger.Debug("Compressing attestation data exceeding the size limit", "size", size)
+		return true
+	}
+	return false
+}
+
+// addNetworkData adds the fixed IPs and MAC addresses of the instance to the metadata of the payload, read from the
+// metadata source the metadata came from. Instances of the cloud-init fallback send none, since cloud-init keeps no
+// copy of network_data.json.
+func (p *IIDAttestorPlugin) addNetworkData(ctx context.Context, m *common.PayloadMetadata) error {
+	var raw json.RawMessage
+	var err error
+	switch m.Source {
+	case metadataSourceService:
+		raw, err = p.getNetworkDataHandler(ctx)
+	case metadataSourceConfigDrive:
+		raw, err = p.getConfigDriveNetworkDataHandler()
+	default:
+		p.logger.Warn("Network data is not sent, since it is unavailable from the metadata source", "source", m.Source)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve network data from %v: %v", m.Source, err)
+	}
+	n, err := openstack.ParseNetworkData(raw)
+	if err != nil {
+		return fmt.Errorf("network data from %v: %v", m.Source, err)
+	}
+	m.FixedIPs = n.FixedIPs()
+	m.MACAddresses = n.MACAddresses()
+	return nil
+}
+
+// checkDMIUUID verifies that the instance UUID is the product UUID of the DMI table, which is read on every
+// attestation since the metadata may be cached
+func (p *IIDAttestorPlugin) checkDMIUUID(instanceUUID string) error {
+	dmiUUID, err := p.getDMIUUIDHandler()
+	if err != nil {
+		return err
+	}
+	if !openstack.MatchDMIUUID(dmiUUID, instanceUUID) {
+		return fmt.Errorf("instance uuid %v differs from DMI product uuid %v", instanceUUID, dmiUUID)
+	}
+	return nil
+}
+
+// hmacSecret returns the key ID and the secret authenticating the attestation data, which are read from the instance
+// metadata or the vendordata on the config drive whichever source the metadata came from, so that the secret never
+// crosses the network.
+func (p *IIDAttestorPlugin) hmacSecret(c *PayloadHMACConfig) (string, []byte, error) {
+	if target := c.VendorDataTarget; target != "" {
+		raw, err := p.getConfigDriveVendorDataHandler(target)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to retrieve hmac secret: %v", err)
+		}
+		var data struct {
+			HMACSecret string `json:"hmac_secret"`
+		}
+		if err := json.Unmarshal(raw, &data); err != nil || data.HMACSecret == "" {
+			return "", nil, fmt.Errorf("hmac secret is not provisioned to the instance: no hmac_secret in the vendordata of %v on the config drive", target)
+		}
+		keyID, secret, err := common.ParseHMACSecret(data.HMACSecret)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid hmac secret in the vendordata of %v: %v", target, err)
+		}
+		return keyID, secret, nil
+	}
+
+	metadataKey := c.MetadataKey
+	meta, err := p.getConfigDriveMetadataHandler()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to retrieve hmac secret: %v", err)
+	}
+	s, ok := meta.Meta[metadataKey]
+	if !ok {
+		return "", nil, fmt.Errorf("hmac secret is not provisioned to the instance: no %q in the metadata on the config drive", metadataKey)
+	}
+	keyID, secret, err := common.ParseHMACSecret(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid hmac secret in %q of the metadata: %v", metadataKey, err)
+	}
+	return keyID, secret, nil
+}
+
+// metadata returns the metadata of the instance and the source it was retrieved from. The metadata is retrieved on
+// first use rather than on configuration, so that the agent can be configured before the network is up, and cached
+// once retrieved, for metadata_cache_ttl if set. Failures are not cached, so that the next attestation tries again.
+func (p *IIDAttestorPlugin) metadata(ctx context.Context) (*openstack.Metadata, string, error) {
+	p.metaMtx.Lock()
+	if p.metaData != nil {
+		ttl := p.config.metadataCacheTTL
+		switch {
+		case ttl == 0 || time.Since(p.fetchedAt) < ttl:
+		case p.config.MetadataBackgroundRefresh:
+			if !p.refreshing {
+				p.refreshing = true
+				go p.refreshMetadata(p.metaGeneration)
+			}
+		default:
+			// The instance doesn't change, so the expired metadata is used while the sources are unavailable
+			age := time.Since(p.fetchedAt)
+			p.metaMtx.Unlock()
+			if _, _, err := p.fetchMetadata(ctx); err != nil {
+				p.logger.Warn("Using expired metadata, which failed to be retrieved again", "age", age.Round(time.Second), "error", err)
+			}
+			p.metaMtx.Lock()
+		}
+		defer p.metaMtx.Unlock()
+		return p.metaData, p.metadataSource(), nil
+	}
+	p.metaMtx.Unlock()
+
+	meta, source, err := p.fetchMetadata(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to retrieve openstack metadta: %v", err)
+	}
+	return meta, source, nil
+}
+
+// fetchedMetadata is the result of fetchMetadata shared by concurrent attestations
+type fetchedMetadata struct {
+	meta   *openstack.Metadata
+	source string
+}
+
+// fetchMetadata retrieves the metadata and caches it. Concurrent retrievals, e.g. of attestations the agent retries
+// while the first one is still waiting for the metadata service, are collapsed into one whose result they share, so
+// that they don't burst the metadata service. The retrieval is bound to the context of the attestation making it,
+// whose failure is shared as well.
+func (p *IIDAttestorPlugin) fetchMetadata(ctx context.Context) (*openstack.Metadata, string, error) {
+	v, shared, err := p.metaFlight.Do(ctx, func() (interface{}, error) {
+		sources := p.config.sources
+		meta, source, err := p.getMetadata(ctx, p.config.getMetadataService(), sources)
+		if err != nil {
+			return nil, err
+		}
+		if source != sources[0] {
+			p.logger.Warn("Using fallback metadata source", "source", source)
+		}
+
+		p.metaMtx.Lock()
+		defer p.metaMtx.Unlock()
+		p.storeMetadata(meta, source)
+		return &fetchedMetadata{meta: p.metaData, source: p.metadataSource()}, nil
+	})
+	if shared {
+		p.logger.Debug("Shared the metadata retrieved by a concurrent attestation")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	f := v.(*fetchedMetadata)
+	return f.meta, f.source, nil
+}
+
+// refreshMetadata retrieves the metadata again in the background for metadata_background_refresh. The configuration
+// is taken under the read lock, but the retrieval doesn't hold it, so that a slow metadata service doesn't block
+// Configure. The refresh is discarded if the plugin was configured again since it started.
+func (p *IIDAttestorPlugin) refreshMetadata(generation uint64) {
+	p.mtx.RLock()
+	config := p.config
+	p.mtx.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.attestationTimeout)
+	defer cancel()
+	meta, source, err := p.getMetadata(ctx, config.getMetadataService(), config.sources)
+
+	p.metaMtx.Lock()
+	defer p.metaMtx.Unlock()
+	if generation != p.metaGeneration {
+		return
+	}
+	p.refreshing = false
+	if err != nil {
+		p.logger.Warn("Failed to refresh metadata, using the cached metadata", "error", err)
+		return
+	}
+	p.storeMetadata(meta, source)
+	p.logger.Debug("Refreshed metadata", "source", source)
+}
+
+// storeMetadata caches the metadata retrieved, unless it is of another instance than the cached metadata, which only
+// a spoofed metadata service serves. metaMtx must be locked.
+func (p *IIDAttestorPlugin) storeMetadata(meta *openstack.Metadata, source string) {
+	if p.metaData != nil && meta.UUID != p.metaData.UUID {
+		p.logger.Warn("Retrieved metadata of another instance, keeping the cached metadata", "uuid", meta.UUID, "cached_uuid", p.metaData.UUID, "source", source)
+		return
+	}
+	p.metaData = meta
+	p.source = source
+	p.fetchedAt = time.Now()
+}
+
+// metadataSource returns the metadata source the cached metadata was retrieved from
+func (p *IIDAttestorPlugin) metadataSource() string {
+	if p.source == "" {
+		return metadataSourceService
+	}
+	return p.source
+}
+
+// validateMetadataRetry validates metadata_retry and prepares its retry policy
+func validateMetadataRetry(c *MetadataRetryConfig) error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxAttempts < 0 {
+		return errors.New("max_attempts of metadata_retry must not be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("jitter of metadata_retry must be between 0 and 1: %v", c.Jitter)
+	}
+	policy := &openstack.RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		BaseDelay:   defaultRetryBaseDelay,
+		MaxDelay:    defaultRetryMaxDelay,
+		Jitter:      c.Jitter,
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"base_delay", c.BaseDelay, &policy.BaseDelay},
+		{"max_delay", c.MaxDelay, &policy.MaxDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid %v of metadata_retry: %q", d.name, d.value)
+		}
+		*d.to = v
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		return errors.New("max_delay of metadata_retry must not be shorter than base_delay")
+	}
+	c.policy = policy
+	return nil
+}
+
+// metadataSessionToken validates metadata_session_token and returns the session token of the metadata service, which
+// is nil if it is not set
+func metadataSessionToken(c *MetadataSessionTokenConfig) (*openstack.MetadataSessionToken, error) {
+	if c == nil {
+		return nil, nil
+	}
+	t := &openstack.MetadataSessionToken{
+		Path:      c.Path,
+		TTLHeader: c.TTLHeader,
+		Header:    c.Header,
+	}
+	if c.TTL != "" {
+		d, err := time.ParseDuration(c.TTL)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid ttl of metadata_session_token: %q", c.TTL)
+		}
+		t.TTL = d
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return nil, fmt.Errorf("path of metadata_session_token must be absolute: %q", c.Path)
+	}
+	return t, nil
+}
+
+// getMetadataService returns the client of the metadata service with the configured timeout and retries.
+// The plugin may not be configured yet, e.g. in the self-test.
+func (c *IIDAttestorPluginConfig) getMetadataService() *openstack.MetadataService {
+	if c == nil || c.metadataService == nil {
+		return &openstack.MetadataService{}
+	}
+	return c.metadataService
+}
+
+// metadataSources returns the metadata sources to try in order
+func metadataSources(c *IIDAttestorPluginConfig) ([]string, error) {
+	if len(c.MetadataSources) > 0 && c.MetadataSource != "" {
+		return nil, errors.New("metadata_source and metadata_sources are exclusive")
+	}
+	name, sources := "metadata_sources", c.MetadataSources
+	if len(sources) == 0 {
+		name, sources = "metadata_source", []string{c.MetadataSource}
+		if c.MetadataSource == "" {
+			sources = []string{metadataSourceService}
+		}
+	}
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		switch source {
+		case metadataSourceService, metadataSourceConfigDrive:
+		default:
+			return nil, fmt.Errorf("unknown %v: %q", name, source)
+		}
+		if seen[source] {
+			return nil, fmt.Errorf("duplicate source in %v: %q", name, source)
+		}
+		seen[source] = true
+	}
+	if c.CloudInitFallback {
+		sources = append(sources, metadataSourceCloudInit)
+	}
+	return sources, nil
+}
+
+// getMetadata retrieves the metadata from the first of the sources which succeeds, m being the metadata service, and
+// returns the source
+func (p *IIDAttestorPlugin) getMetadata(ctx context.Context, m *openstack.MetadataService, sources []string) (*openstack.Metadata, string, error) {
+	var errs []string
+	for _, source := range sources {
+		var meta *openstack.Metadata
+		var err error
+		switch source {
+		case metadataSourceConfigDrive:
+			meta, err = p.getConfigDriveMetadataHandler()
+		case metadataSourceCloudInit:
+			meta, err = p.getCloudInitMetadataHandler()
+		default:
+			meta, err = p.getMetadataHandler(ctx, m)
+		}
+		if err == nil {
+			return meta, source, nil
+		}
+		if len(sources) == 1 {
+			return nil, "", err
+		}
+		p.logger.Debug("Metadata source is unavailable", "source", source, "error", err)
+		errs = append(errs, fmt.Sprintf("%v: %v", source, err))
+	}
+	return nil, "", errors.New(strings.Join(errs, "; "))
 }
 
 func (p *IIDAttestorPlugin) SetLogger(log hclog.Logger) {
@@ -110,5 +1150,21 @@ func (p *IIDAttestorPlugin) SetLogger(log hclog.Logger) {
 }
 
 func main() {
-	catalog.PluginMain(BuiltIn())
+	if len(os.Args) > 1 && os.Args[1] == "self-test" {
+		os.Exit(selfTestMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "evidence" {
+		os.Exit(evidenceMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(schemaMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bundle" {
+		os.Exit(bundleMain(os.Args[2:]))
+	}
+	p := New()
+	p.watchShutdownSignals()
+	catalog.PluginMain(builtin(p))
+	// PluginMain returns once SPIRE stops the plugin
+	p.stop()
 }
@@ -9,14 +9,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spiffe/spire/proto/spire/common/plugin"
 
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
@@ -25,13 +37,28 @@ import (
 func newTestPlugin() *IIDAttestorPlugin {
 	return &IIDAttestorPlugin{
 		config: &IIDAttestorPluginConfig{
-			trustDomain: "example.com",
+			trustDomain:        "example.com",
+			sources:            []string{metadataSourceService},
+			attestationTimeout: defaultAttestationTimeout,
 		},
-		mtx:    &sync.RWMutex{},
-		logger: testutil.TestLogger(),
+		mtx:                   &sync.RWMutex{},
+		logger:                testutil.TestLogger(),
+		probeOpenStackHandler: probeOpenStack,
+		getBootTimeHandler:    bootTime,
 	}
 }
 
+// testBootTime is the time the instances of the tests booted at
+var testBootTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func bootTime() (time.Time, error) {
+	return testBootTime, nil
+}
+
+func probeOpenStack(context.Context, *openstack.MetadataService) (string, error) {
+	return openstack.ProbeDMI, nil
+}
+
 func newConfigureRequest() *plugin.ConfigureRequest {
 	return &plugin.ConfigureRequest{
 		GlobalConfig: &plugin.ConfigureRequest_GlobalConfig{
@@ -42,7 +69,7 @@ func newConfigureRequest() *plugin.ConfigureRequest {
 
 func TestConfigure(t *testing.T) {
 	p := newTestPlugin()
-	p.getMetadataHandler = func() (*openstack.Metadata, error) {
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
 		return &openstack.Metadata{
 			UUID:      "alpha",
 			Name:      "bravo",
@@ -59,9 +86,389 @@ func TestConfigure(t *testing.T) {
 	}
 }
 
+func TestConfigureJSON(t *testing.T) {
+	for i, conf := range []string{
+		// 0: HCL
+		`payload_format = "json"
+		payload_version = 1
+		metadata_retry {
+			max_attempts = 3
+		}`,
+		// 1: JSON
+		`{"payload_format": "json", "payload_version": 1, "metadata_retry": {"max_attempts": 3}}`,
+		// 2: JSON after whitespace, as indented in agent.conf
+		`
+		 {
+			"payload_format": "json",
+			"payload_version": 1,
+			"metadata_retry": {"max_attempts": 3}
+		}`,
+	} {
+		p := newTestPlugin()
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		}
+
+		cReq := newConfigureRequest()
+		cReq.Configuration = conf
+		if _, err := p.Configure(context.Background(), cReq); err != nil {
+			t.Errorf("#%v: unexpected error from Configure(): %v", i, err)
+			continue
+		}
+		if p.config.PayloadFormat != payloadFormatJSON || p.config.PayloadVersion != common.PayloadVersion1 {
+			t.Errorf("#%v: unexpected payload format %q of version %v", i, p.config.PayloadFormat, p.config.PayloadVersion)
+		}
+		if r := p.config.MetadataRetry; r == nil || r.MaxAttempts != 3 {
+			t.Errorf("#%v: unexpected metadata_retry: %+v", i, r)
+		}
+	}
+}
+
+func TestConfigureConfigDrive(t *testing.T) {
+	p := newTestPlugin()
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+		return nil, errors.New("metadata service is unavailable")
+	}
+	p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+		return &openstack.Metadata{
+			UUID: "alpha",
+		}, nil
+	}
+
+	ctx := context.Background()
+	cReq := newConfigureRequest()
+	cReq.Configuration = `metadata_source = "config_drive"`
+
+	if _, err := p.Configure(ctx, cReq); err != nil {
+		t.Errorf("unexpected error from Configure(): %v", err)
+	}
+	meta, _, err := p.metadata(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from metadata(): %v", err)
+	}
+	if meta.UUID != "alpha" {
+		t.Errorf("got %v, want %v", meta.UUID, "alpha")
+	}
+}
+
+func TestConfigureMetadataSources(t *testing.T) {
+	serviceErr, driveErr := errors.New("metadata service is unavailable"), errors.New("no config drive")
+	tCase := []struct {
+		config     string
+		serviceErr error
+		driveErr   error
+		initErr    error
+		wantSource string
+		// wantErr is set if Configure fails, and wantFetchErr if the metadata can't be retrieved
+		wantErr      bool
+		wantFetchErr bool
+	}{
+		// 0: metadata service by default
+		{wantSource: metadataSourceService},
+		// 1: falls back to the config drive
+		{config: `metadata_sources = ["metadata_service", "config_drive"]`, serviceErr: serviceErr, wantSource: metadataSourceConfigDrive},
+		// 2: the first source which succeeds is used
+		{config: `metadata_sources = ["config_drive", "metadata_service"]`, wantSource: metadataSourceConfigDrive},
+		// 3: all sources failed
+		{config: `metadata_sources = ["config_drive", "metadata_service"]`, serviceErr: serviceErr, driveErr: driveErr, wantFetchErr: true},
+		// 4: unknown source
+		{config: `metadata_sources = ["metadata_service", "user_data"]`, wantErr: true},
+		// 5: duplicate source
+		{config: `metadata_sources = ["config_drive", "config_drive"]`, wantErr: true},
+		// 6: exclusive with metadata_source
+		{config: `metadata_source = "config_drive"
+metadata_sources = ["config_drive"]`, wantErr: true},
+		// 7: falls back to cloud-init as the last resort
+		{config: `metadata_sources = ["metadata_service", "config_drive"]
+cloud_init_fallback = true`, serviceErr: serviceErr, driveErr: driveErr, wantSource: metadataSourceCloudInit},
+		// 8: cloud-init is not used while another source succeeds
+		{config: `cloud_init_fallback = true`, wantSource: metadataSourceService},
+		// 9: all sources failed, including cloud-init
+		{config: `cloud_init_fallback = true`, serviceErr: serviceErr, initErr: errors.New("no instance id"), wantFetchErr: true},
+		// 10: cloud-init can't be listed
+		{config: `metadata_sources = ["cloud_init"]`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			if c.serviceErr != nil {
+				return nil, c.serviceErr
+			}
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		}
+		p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+			if c.driveErr != nil {
+				return nil, c.driveErr
+			}
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		}
+		p.getCloudInitMetadataHandler = func() (*openstack.Metadata, error) {
+			if c.initErr != nil {
+				return nil, c.initErr
+			}
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		}
+
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		_, source, err := p.metadata(context.Background())
+		if c.wantFetchErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if source != c.wantSource {
+			t.Errorf("#%v: got source %v, want %v", i, source, c.wantSource)
+		}
+	}
+}
+
+func TestConfigureMetadataRetry(t *testing.T) {
+	tCase := []struct {
+		config     string
+		wantPolicy *openstack.RetryPolicy
+		wantErr    bool
+	}{
+		// 0: single attempt by default
+		{},
+		// 1: defaults
+		{
+			config:     `metadata_retry {}`,
+			wantPolicy: &openstack.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second},
+		},
+		// 2: all knobs
+		{
+			config: `metadata_retry {
+	max_attempts = 3
+	base_delay = "500ms"
+	max_delay = "2s"
+	jitter = 0.2
+}`,
+			wantPolicy: &openstack.RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.2},
+		},
+		// 3: max_delay shorter than base_delay
+		{config: `metadata_retry {
+	base_delay = "5s"
+	max_delay = "1s"
+}`, wantErr: true},
+		// 4: invalid jitter
+		{config: `metadata_retry { jitter = 1.5 }`, wantErr: true},
+		// 5: invalid delay
+		{config: `metadata_retry { base_delay = "0s" }`, wantErr: true},
+		// 6: negative attempts
+		{config: `metadata_retry { max_attempts = -1 }`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		got := p.config.getMetadataService().Retry
+		want := c.wantPolicy
+		if (got == nil) != (want == nil) || (got != nil && (got.MaxAttempts != want.MaxAttempts || got.BaseDelay != want.BaseDelay || got.MaxDelay != want.MaxDelay || got.Jitter != want.Jitter)) {
+			t.Errorf("#%v: got %+v, want %+v", i, got, c.wantPolicy)
+		}
+	}
+}
+
+func TestConfigureMetadataTimeout(t *testing.T) {
+	tCase := []struct {
+		config      string
+		wantTimeout time.Duration
+		wantErr     bool
+	}{
+		// 0: default
+		{config: "", wantTimeout: defaultMetadataTimeout},
+		// 1: configured
+		{config: `metadata_timeout = "3s"`, wantTimeout: 3 * time.Second},
+		// 2: invalid duration
+		{config: `metadata_timeout = "soon"`, wantErr: true},
+		// 3: zero
+		{config: `metadata_timeout = "0s"`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if got := p.config.getMetadataService().Timeout; got != c.wantTimeout {
+			t.Errorf("#%v: got timeout %v, want %v", i, got, c.wantTimeout)
+		}
+	}
+}
+
+func TestConfigureMetadataIPv6(t *testing.T) {
+	tCase := []struct {
+		config        string
+		wantIPv6      bool
+		wantInterface string
+		wantSource    string
+		wantErr       bool
+	}{
+		// 0: IPv4 by default
+		{},
+		// 1: IPv6 on the interface found
+		{config: `metadata_ipv6 = true`, wantIPv6: true},
+		// 2: IPv6 on the interface configured
+		{config: "metadata_ipv6 = true\nmetadata_interface = \"eth1\"", wantIPv6: true, wantInterface: "eth1"},
+		// 3: IPv4 through the interface configured
+		{config: `metadata_interface = "eth1"`, wantInterface: "eth1"},
+		// 4: IPv4 from the source address
+		{config: `metadata_source_address = "10.0.1.5"`, wantSource: "10.0.1.5"},
+		// 5: IPv6 from the source address
+		{config: "metadata_ipv6 = true\nmetadata_source_address = \"fe80::1\"", wantIPv6: true, wantSource: "fe80::1"},
+		// 6: source address of the other family
+		{config: "metadata_ipv6 = true\nmetadata_source_address = \"10.0.1.5\"", wantErr: true},
+		// 7: not an address
+		{config: `metadata_source_address = "eth1"`, wantErr: true},
+		// 8: through a proxy
+		{config: "metadata_transport = \"http://127.0.0.1:8775\"\nmetadata_interface = \"eth1\"", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		m := p.config.getMetadataService()
+		if m.IPv6 != c.wantIPv6 || m.Interface != c.wantInterface || m.SourceAddress != c.wantSource {
+			t.Errorf("#%v: got IPv6 %v on %q from %q, want %v on %q from %q", i, m.IPv6, m.Interface, m.SourceAddress, c.wantIPv6, c.wantInterface, c.wantSource)
+		}
+	}
+}
+
+func TestConfigureMetadataTransport(t *testing.T) {
+	tCase := []struct {
+		config        string
+		wantTransport string
+		wantErr       bool
+	}{
+		// 0: the metadata service itself by default
+		{},
+		// 1: Unix socket
+		{config: `metadata_transport = "unix:///run/metadata.sock"`, wantTransport: "unix:///run/metadata.sock"},
+		// 2: local proxy
+		{config: `metadata_transport = "http://127.0.0.1:8775"`, wantTransport: "http://127.0.0.1:8775"},
+		// 3: unsupported transport
+		{config: `metadata_transport = "socks5://127.0.0.1:1080"`, wantErr: true},
+		// 4: proxy with IPv6
+		{config: "metadata_transport = \"http://127.0.0.1:8775\"\nmetadata_ipv6 = true", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if m := p.config.getMetadataService(); m.Transport != c.wantTransport {
+			t.Errorf("#%v: got transport %q, want %q", i, m.Transport, c.wantTransport)
+		}
+	}
+}
+
+func TestConfigureMetadataSessionToken(t *testing.T) {
+	tCase := []struct {
+		config  string
+		wantTTL time.Duration
+		wantErr bool
+	}{
+		// 0: disabled by default
+		{},
+		// 1: lifetime configured
+		{config: "metadata_session_token {\n ttl = \"1h\"\n}", wantTTL: time.Hour},
+		// 2: lifetime shorter than a second
+		{config: "metadata_session_token {\n ttl = \"500ms\"\n}", wantErr: true},
+		// 3: relative path
+		{config: "metadata_session_token {\n path = \"latest/api/token\"\n}", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		token := p.config.getMetadataService().SessionToken
+		switch {
+		case c.config == "" && token != nil:
+			t.Errorf("#%v: unexpected session token: %+v", i, token)
+		case c.config != "" && (token == nil || token.TTL != c.wantTTL):
+			t.Errorf("#%v: got session token %+v, want ttl %v", i, token, c.wantTTL)
+		}
+	}
+}
+
 func TestConfigureInvalidConfig(t *testing.T) {
 	p := newTestPlugin()
-	p.getMetadataHandler = func() (*openstack.Metadata, error) {
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
 		return &openstack.Metadata{
 			UUID:      "alpha",
 			Name:      "bravo",
@@ -79,20 +486,241 @@ func TestConfigureInvalidConfig(t *testing.T) {
 	}
 }
 
-func TestConfigureMetadataFailed(t *testing.T) {
+func TestConfigureMetadataUnavailable(t *testing.T) {
 	p := newTestPlugin()
-	errMsg := "fake error"
-	p.getMetadataHandler = func() (*openstack.Metadata, error) {
-		return nil, errors.New(errMsg)
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+		t.Error("metadata retrieved on configuration")
+		return nil, errors.New("fake error")
+	}
+
+	if _, err := p.Configure(context.Background(), newConfigureRequest()); err != nil {
+		t.Errorf("unexpected error from Configure(): %v", err)
+	}
+}
+
+func TestConfigureResetsMetadata(t *testing.T) {
+	p := newTestPlugin()
+	p.metaData = &openstack.Metadata{UUID: "alpha"}
+	p.source = metadataSourceService
+	p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+		return &openstack.Metadata{UUID: "bravo"}, nil
 	}
 
-	ctx := context.Background()
 	cReq := newConfigureRequest()
+	cReq.Configuration = `metadata_source = "config_drive"`
+	if _, err := p.Configure(context.Background(), cReq); err != nil {
+		t.Fatalf("unexpected error from Configure(): %v", err)
+	}
+	meta, source, err := p.metadata(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from metadata(): %v", err)
+	}
+	if meta.UUID != "bravo" || source != metadataSourceConfigDrive {
+		t.Errorf("got %v from %v, want bravo from %v", meta.UUID, source, metadataSourceConfigDrive)
+	}
+}
 
-	_, err := p.Configure(ctx, cReq)
-	wantErr := fmt.Sprintf("failed to retrieve openstack metadta: %v", errMsg)
-	if err.Error() != wantErr {
-		t.Errorf("got %v, want %v", err.Error(), wantErr)
+func TestConfigureMetadataCache(t *testing.T) {
+	tCase := []struct {
+		config  string
+		wantTTL time.Duration
+		wantErr bool
+	}{
+		// 0: cached for the lifetime of the plugin by default
+		{},
+		// 1: TTL
+		{config: `metadata_cache_ttl = "1h"`, wantTTL: time.Hour},
+		// 2: background refresh
+		{config: "metadata_cache_ttl = \"10m\"\nmetadata_background_refresh = true", wantTTL: 10 * time.Minute},
+		// 3: invalid TTL
+		{config: `metadata_cache_ttl = "forever"`, wantErr: true},
+		// 4: negative TTL
+		{config: `metadata_cache_ttl = "-1h"`, wantErr: true},
+		// 5: background refresh without TTL
+		{config: `metadata_background_refresh = true`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if p.config.metadataCacheTTL != c.wantTTL {
+			t.Errorf("#%v: got TTL %v, want %v", i, p.config.metadataCacheTTL, c.wantTTL)
+		}
+	}
+}
+
+func TestMetadataCacheTTL(t *testing.T) {
+	tCase := []struct {
+		age      time.Duration
+		fetched  *openstack.Metadata
+		fetchErr error
+		wantName string
+		wantCall bool
+	}{
+		// 0: fresh metadata
+		{age: time.Minute, wantName: "alpha"},
+		// 1: expired metadata retrieved again
+		{age: 2 * time.Hour, fetched: &openstack.Metadata{UUID: "uuid", Name: "bravo"}, wantName: "bravo", wantCall: true},
+		// 2: expired metadata used while the metadata service is unavailable
+		{age: 2 * time.Hour, fetchErr: errors.New("fake error"), wantName: "alpha", wantCall: true},
+		// 3: metadata of another instance
+		{age: 2 * time.Hour, fetched: &openstack.Metadata{UUID: "other", Name: "charlie"}, wantName: "alpha", wantCall: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.config.metadataCacheTTL = time.Hour
+		p.metaData = &openstack.Metadata{UUID: "uuid", Name: "alpha"}
+		p.source = metadataSourceService
+		p.fetchedAt = time.Now().Add(-c.age)
+		var called bool
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			called = true
+			return c.fetched, c.fetchErr
+		}
+
+		meta, _, err := p.metadata(context.Background())
+		if err != nil {
+			t.Errorf("#%v: unexpected error from metadata(): %v", i, err)
+			continue
+		}
+		if meta.Name != c.wantName {
+			t.Errorf("#%v: got name %v, want %v", i, meta.Name, c.wantName)
+		}
+		if called != c.wantCall {
+			t.Errorf("#%v: metadata retrieved: %v, want %v", i, called, c.wantCall)
+		}
+	}
+}
+
+func TestMetadataBackgroundRefresh(t *testing.T) {
+	p := newTestPlugin()
+	p.config.metadataCacheTTL = time.Hour
+	p.config.MetadataBackgroundRefresh = true
+	p.metaData = &openstack.Metadata{UUID: "uuid", Name: "alpha"}
+	p.source = metadataSourceService
+	p.fetchedAt = time.Now().Add(-2 * time.Hour)
+	release := make(chan struct{})
+	calls := make(chan struct{}, 2)
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+		calls <- struct{}{}
+		<-release
+		return &openstack.Metadata{UUID: "uuid", Name: "bravo"}, nil
+	}
+
+	// Attestations use the expired metadata while it is refreshed once
+	for i := 0; i < 2; i++ {
+		meta, _, err := p.metadata(context.Background())
+		if err != nil {
+			t.Fatalf("#%v: unexpected error from metadata(): %v", i, err)
+		}
+		if meta.Name != "alpha" {
+			t.Errorf("#%v: got name %v, want alpha", i, meta.Name)
+		}
+	}
+	<-calls
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		meta, _, err := p.metadata(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error from metadata(): %v", err)
+		}
+		if meta.Name == "bravo" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("metadata not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(calls) != 0 {
+		t.Errorf("metadata refreshed %v more times, want once", len(calls))
+	}
+}
+
+func TestMetadataBackgroundRefreshUnlocked(t *testing.T) {
+	p := newTestPlugin()
+	p.config.metadataCacheTTL = time.Hour
+	p.config.MetadataBackgroundRefresh = true
+	p.metaData = &openstack.Metadata{UUID: "uuid", Name: "alpha"}
+	p.fetchedAt = time.Now().Add(-2 * time.Hour)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+		close(started)
+		<-release
+		return &openstack.Metadata{UUID: "uuid", Name: "bravo"}, nil
+	}
+
+	if _, _, err := p.metadata(context.Background()); err != nil {
+		t.Fatalf("unexpected error from metadata(): %v", err)
+	}
+	<-started
+
+	// Configure takes the write lock while the refresh waits for the metadata service
+	locked := make(chan struct{})
+	go func() {
+		p.mtx.Lock()
+		p.mtx.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("refresh holds the lock while retrieving the metadata")
+	}
+}
+
+func TestFetchAttestationDataConcurrent(t *testing.T) {
+	for _, fetchErr := range []error{nil, errors.New("fake error")} {
+		p := newTestPlugin()
+		var calls int32
+		release := make(chan struct{})
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			return &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}, nil
+		}
+
+		// Attestations retried concurrently share the retrieval of the metadata, even when it fails
+		const attestations = 5
+		errs := make(chan error, attestations)
+		for i := 0; i < attestations; i++ {
+			go func() {
+				errs <- p.FetchAttestationData(fake.NewFakeFetchAttestationStream())
+			}()
+		}
+		for atomic.LoadInt32(&calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		for i := 0; i < attestations; i++ {
+			if err := <-errs; (err != nil) != (fetchErr != nil) {
+				t.Errorf("fetch error %v: unexpected error from FetchAttestationData(): %v", fetchErr, err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("fetch error %v: metadata retrieved %v times, want once", fetchErr, n)
+		}
 	}
 }
 
@@ -113,6 +741,827 @@ func TestFetchAttestationData(t *testing.T) {
 	}
 }
 
+func TestFetchAttestationDataMetadataDeadline(t *testing.T) {
+	p := newTestPlugin()
+	p.config.attestationTimeout = 10 * time.Millisecond
+	p.getMetadataHandler = func(ctx context.Context, _ *openstack.MetadataService) (*openstack.Metadata, error) {
+		// A metadata service which blackholes traffic answers only when the request is abandoned
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+	err := p.FetchAttestationData(f)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("unexpected error from FetchAttestationData(): %v", err)
+	}
+}
+
+func TestFetchAttestationDataJSON(t *testing.T) {
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Errorf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.InstanceID != "alpha" || payload.ProjectID != "bravo" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestFetchAttestationDataPayloadVersion(t *testing.T) {
+	tCase := []struct {
+		version      int
+		bootTimeErr  error
+		wantVersion  int
+		wantMetadata *common.PayloadMetadata
+	}{
+		// 0: metadata in version 2
+		{
+			version:      common.PayloadVersion2,
+			wantVersion:  common.PayloadVersion2,
+			wantMetadata: &common.PayloadMetadata{Name: "web-1", Hostname: "web-1.novalocal", AvailabilityZone: "nova", Source: metadataSourceService, LaunchedAt: "2020-01-01T00:00:00Z"},
+		},
+		// 1: legacy payload
+		{version: common.PayloadVersion1, wantVersion: common.PayloadVersion1},
+		// 2: no launch time where the boot time is unknown
+		{
+			version:      common.PayloadVersion2,
+			bootTimeErr:  errors.New("boot time is supported only on Linux"),
+			wantVersion:  common.PayloadVersion2,
+			wantMetadata: &common.PayloadMetadata{Name: "web-1", Hostname: "web-1.novalocal", AvailabilityZone: "nova", Source: metadataSourceService},
+		},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.config.PayloadFormat = payloadFormatJSON
+		p.config.PayloadVersion = c.version
+		if c.bootTimeErr != nil {
+			p.getBootTimeHandler = func() (time.Time, error) {
+				return time.Time{}, c.bootTimeErr
+			}
+		}
+		p.metaData = &openstack.Metadata{
+			UUID:             "alpha",
+			ProjectID:        "bravo",
+			Name:             "web-1",
+			Hostname:         "web-1.novalocal",
+			AvailabilityZone: "nova",
+			Meta:             map[string]string{"spire_hmac_secret": "never sent"},
+		}
+
+		f := fake.NewFakeFetchAttestationStream()
+		if err := p.FetchAttestationData(f); err != nil {
+			t.Errorf("#%v: unexpected error from FetchAttestationData(): %v", i, err)
+			continue
+		}
+		data := f.Response().AttestationData.Data
+		if strings.Contains(string(data), "never sent") {
+			t.Errorf("#%v: user metadata sent: %s", i, data)
+		}
+		payload, err := common.ParseAttestationPayload(data)
+		if err != nil {
+			t.Errorf("#%v: unexpected error from ParseAttestationPayload(): %v", i, err)
+			continue
+		}
+		if payload.PayloadVersion() != c.wantVersion || !reflect.DeepEqual(payload.Metadata, c.wantMetadata) {
+			t.Errorf("#%v: got version %v with %+v, want version %v with %+v", i, payload.PayloadVersion(), payload.Metadata, c.wantVersion, c.wantMetadata)
+		}
+	}
+}
+
+func TestConfigurePayloadVersion(t *testing.T) {
+	tCase := []struct {
+		config      string
+		wantVersion int
+		wantErr     bool
+	}{
+		// 0: raw payload has no version
+		{},
+		// 1: version 2 by default
+		{config: `payload_format = "json"`, wantVersion: common.PayloadVersion2},
+		// 2: legacy payload
+		{config: `payload_format = "json"
+payload_version = 1`, wantVersion: common.PayloadVersion1},
+		// 3: unknown version
+		{config: `payload_format = "json"
+payload_version = 3`, wantErr: true},
+		// 4: raw payload has no version
+		{config: `payload_version = 2`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if p.config.PayloadVersion != c.wantVersion {
+			t.Errorf("#%v: got version %v, want %v", i, p.config.PayloadVersion, c.wantVersion)
+		}
+	}
+}
+
+func TestConfigureLogicalNode(t *testing.T) {
+	tCase := []struct {
+		config  string
+		wantErr bool
+	}{
+		// 0: logical node in the JSON payload
+		{config: `payload_format = "json"
+logical_node = "containerd"`},
+		// 1: raw payload can't carry the logical node
+		{config: `logical_node = "containerd"`, wantErr: true},
+		// 2: logical node must be a segment of the agent ID
+		{config: `payload_format = "json"
+logical_node = "k8s/containerd"`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestFetchAttestationDataLogicalNode(t *testing.T) {
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.LogicalNode = "containerd"
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.LogicalNode != "containerd" {
+		t.Errorf("got logical node %q, want %q", payload.LogicalNode, "containerd")
+	}
+}
+
+func TestConfigurePayloadNetwork(t *testing.T) {
+	tCase := []struct {
+		config  string
+		wantErr bool
+	}{
+		// 0: network data in the metadata of payload version 2
+		{config: `payload_format = "json"
+payload_network = true`},
+		// 1: legacy payload has no metadata
+		{config: `payload_format = "json"
+payload_version = 1
+payload_network = true`, wantErr: true},
+		// 2: raw payload has no metadata
+		{config: `payload_network = true`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestFetchAttestationDataNetwork(t *testing.T) {
+	serviceData := json.RawMessage(`{"links": [{"id": "tap0", "ethernet_mac_address": "FA:16:3E:00:00:01"}], "networks": [{"link": "tap0", "ip_address": "192.0.2.10"}]}`)
+	configDriveData := json.RawMessage(`{"links": [{"id": "tap0", "ethernet_mac_address": "fa:16:3e:00:00:02"}], "networks": [{"link": "tap0", "type": "ipv4_dhcp"}]}`)
+
+	tCase := []struct {
+		source     string
+		serviceErr error
+		wantIPs    []string
+		wantMACs   []string
+		wantErr    bool
+	}{
+		// 0: from the metadata service
+		{source: metadataSourceService, wantIPs: []string{"192.0.2.10"}, wantMACs: []string{"fa:16:3e:00:00:01"}},
+		// 1: from the config drive, without fixed IPs under DHCP
+		{source: metadataSourceConfigDrive, wantMACs: []string{"fa:16:3e:00:00:02"}},
+		// 2: cloud-init has no network data
+		{source: metadataSourceCloudInit},
+		// 3: failures fail the attestation
+		{source: metadataSourceService, serviceErr: errors.New("unavailable"), wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.config.PayloadFormat = payloadFormatJSON
+		p.config.PayloadVersion = common.PayloadVersion2
+		p.config.PayloadNetwork = true
+		p.metaData = &openstack.Metadata{
+			UUID:      "alpha",
+			ProjectID: "bravo",
+		}
+		p.source = c.source
+		p.getNetworkDataHandler = func(context.Context) (json.RawMessage, error) {
+			return serviceData, c.serviceErr
+		}
+		p.getConfigDriveNetworkDataHandler = func() (json.RawMessage, error) {
+			return configDriveData, nil
+		}
+
+		f := fake.NewFakeFetchAttestationStream()
+		err := p.FetchAttestationData(f)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error from FetchAttestationData(): %v", i, err)
+			continue
+		}
+		payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+		if err != nil {
+			t.Errorf("#%v: unexpected error from ParseAttestationPayload(): %v", i, err)
+			continue
+		}
+		m := payload.Metadata
+		if !reflect.DeepEqual(m.FixedIPs, c.wantIPs) || !reflect.DeepEqual(m.MACAddresses, c.wantMACs) {
+			t.Errorf("#%v: got fixed IPs %v and MAC addresses %v, want %v and %v", i, m.FixedIPs, m.MACAddresses, c.wantIPs, c.wantMACs)
+		}
+	}
+}
+
+func TestFetchAttestationDataCompressed(t *testing.T) {
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.PayloadCompression = payloadCompressionGzip
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	data := f.Response().AttestationData.Data
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Errorf("attestation data is not compressed: %q", data)
+	}
+	payload, err := common.ParseAttestationPayload(data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.InstanceID != "alpha" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestFetchAttestationDataCompressedAuto(t *testing.T) {
+	for i, c := range []struct {
+		name           string
+		wantCompressed bool
+	}{
+		{
+			// 0: small payloads are sent as is
+			name:           "charlie",
+			wantCompressed: false,
+		},
+		{
+			// 1: payloads exceeding the size limit are compressed
+			name:           strings.Repeat("charlie", common.MaxAttestationPayloadSize/7),
+			wantCompressed: true,
+		},
+	} {
+		p := newTestPlugin()
+		p.config.PayloadFormat = payloadFormatJSON
+		p.config.PayloadVersion = common.PayloadVersion2
+		p.config.PayloadCompression = payloadCompressionAuto
+		p.metaData = &openstack.Metadata{
+			UUID:      "alpha",
+			Name:      c.name,
+			ProjectID: "bravo",
+		}
+
+		f := fake.NewFakeFetchAttestationStream()
+
+		if err := p.FetchAttestationData(f); err != nil {
+			t.Errorf("#%v: unexpected error from FetchAttestationData(): %v", i, err)
+			continue
+		}
+		data := f.Response().AttestationData.Data
+		if compressed := len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b; compressed != c.wantCompressed {
+			t.Errorf("#%v: got compressed %v, want %v", i, compressed, c.wantCompressed)
+		}
+		payload, err := common.ParseAttestationPayload(data)
+		if err != nil {
+			t.Errorf("#%v: unexpected error from ParseAttestationPayload(): %v", i, err)
+			continue
+		}
+		if payload.Metadata == nil || payload.Metadata.Name != c.name {
+			t.Errorf("#%v: unexpected payload: %+v", i, payload)
+		}
+	}
+}
+
+func TestConfigurePayloadEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "server.pub")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		config  string
+		wantErr bool
+	}{
+		// 0: RSA public key
+		{config: fmt.Sprintf("payload_encryption {\nkey_id = \"2020-01\"\npublic_key_file = %q\n}", path)},
+		// 1: no key ID
+		{config: fmt.Sprintf("payload_encryption {\npublic_key_file = %q\n}", path), wantErr: true},
+		// 2: missing key
+		{config: fmt.Sprintf("payload_encryption {\nkey_id = \"2020-01\"\npublic_key_file = %q\n}", filepath.Join(dir, "missing.pub")), wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if p.config.PayloadEncryption.publicKey == nil {
+			t.Errorf("#%v: public key is not loaded", i)
+		}
+	}
+}
+
+func TestFetchAttestationDataEncrypted(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.PayloadCompression = payloadCompressionGzip
+	p.config.PayloadEncryption = &PayloadEncryptionConfig{KeyID: "2020-01", publicKey: &key.PublicKey}
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	data := f.Response().AttestationData.Data
+	if !common.IsEncryptedPayload(data) {
+		t.Fatalf("attestation data is not encrypted: %q", data)
+	}
+	decrypted, err := common.DecryptPayload(data, func(keyID string) *rsa.PrivateKey {
+		if keyID == "2020-01" {
+			return key
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from DecryptPayload(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(decrypted)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.InstanceID != "alpha" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestFetchAttestationDataSignedIdentity(t *testing.T) {
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.SignedIdentityTarget = "spire"
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+	p.getVendorDataHandler = func(_ context.Context, target string) (json.RawMessage, error) {
+		if target != "spire" {
+			return nil, fmt.Errorf("unexpected target: %v", target)
+		}
+		return json.RawMessage(`{"kid": "k1", "alg": "EdDSA", "document": "e30", "signature": "c2ln"}`), nil
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.SignedIdentity == nil || payload.SignedIdentity.KeyID != "k1" {
+		t.Errorf("unexpected signed identity: %+v", payload.SignedIdentity)
+	}
+
+	// Incomplete signed identities are rejected before reaching the server
+	p.getVendorDataHandler = func(_ context.Context, target string) (json.RawMessage, error) {
+		return json.RawMessage(`{"kid": "k1"}`), nil
+	}
+	f = fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestFetchAttestationDataHMAC(t *testing.T) {
+	secrets := map[string]string{"spire_hmac_secret": "2019-01:MDEyMzQ1Njc4OWFiY2RlZg=="}
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.PayloadHMAC = &PayloadHMACConfig{MetadataKey: defaultHMACMetadataKey}
+	p.metaData = &openstack.Metadata{
+		UUID:      "alpha",
+		ProjectID: "bravo",
+	}
+	p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+		return &openstack.Metadata{UUID: "alpha", Meta: secrets}, nil
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	err = payload.VerifyHMAC(func(keyID string) []byte {
+		if keyID != "2019-01" {
+			return nil
+		}
+		return []byte("0123456789abcdef")
+	}, time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("unexpected error from VerifyHMAC(): %v", err)
+	}
+
+	// Attestation fails without the secret, instead of being denied by the server
+	delete(secrets, "spire_hmac_secret")
+	f = fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestFetchAttestationDataHMACVendorData(t *testing.T) {
+	vendorData := map[string]string{"spire_hmac": `{"hmac_secret": "2020-01:MDEyMzQ1Njc4OWFiY2RlZg=="}`}
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.PayloadHMAC = &PayloadHMACConfig{VendorDataTarget: "spire_hmac"}
+	p.metaData = &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}
+	p.getConfigDriveVendorDataHandler = func(target string) (json.RawMessage, error) {
+		data, ok := vendorData[target]
+		if !ok {
+			return nil, fmt.Errorf("no vendordata of target %q", target)
+		}
+		return json.RawMessage(data), nil
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	err = payload.VerifyHMAC(func(keyID string) []byte {
+		if keyID != "2020-01" {
+			return nil
+		}
+		return []byte("0123456789abcdef")
+	}, time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("unexpected error from VerifyHMAC(): %v", err)
+	}
+
+	// Vendordata of the instance created before the signer provisioned secrets
+	vendorData["spire_hmac"] = `{}`
+	f = fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestFetchAttestationDataBundleFingerprint(t *testing.T) {
+	fingerprint := strings.Repeat("0a", 32)
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.BundleFingerprint = &BundleFingerprintConfig{MetadataKey: defaultBundleFingerprintMetadataKey}
+	p.metaData = &openstack.Metadata{
+		UUID: "alpha",
+		Meta: map[string]string{defaultBundleFingerprintMetadataKey: strings.ToUpper(fingerprint)},
+	}
+
+	f := fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+	if err != nil {
+		t.Fatalf("unexpected error from ParseAttestationPayload(): %v", err)
+	}
+	if payload.BundleFingerprint != fingerprint {
+		t.Errorf("got bundle fingerprint %q, want %q", payload.BundleFingerprint, fingerprint)
+	}
+
+	// Attestation fails without the fingerprint, instead of being denied by the server
+	p.metaData.Meta = nil
+	f = fake.NewFakeFetchAttestationStream()
+	if err := p.FetchAttestationData(f); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestFetchAttestationDataHMACChallenge(t *testing.T) {
+	challenge, err := (&common.Challenge{Type: common.ChallengeHMAC, Nonce: "n0nce"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]string{"spire_hmac_secret": "2019-01:MDEyMzQ1Njc4OWFiY2RlZg=="}
+	p := newTestPlugin()
+	p.config.PayloadFormat = payloadFormatJSON
+	p.config.PayloadHMAC = &PayloadHMACConfig{MetadataKey: defaultHMACMetadataKey}
+	p.metaData = &openstack.Metadata{UUID: "alpha"}
+	p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+		return &openstack.Metadata{UUID: "alpha", Meta: secrets}, nil
+	}
+
+	f := fake.NewFakeFetchAttestationStreamWithChallenges(challenge)
+	if err := p.FetchAttestationData(f); err != nil {
+		t.Fatalf("unexpected error from FetchAttestationData(): %v", err)
+	}
+	if len(f.ChallengeResponses()) != 1 {
+		t.Fatalf("got %v responses, want 1", len(f.ChallengeResponses()))
+	}
+	resp, err := common.ParseChallengeResponse(f.ChallengeResponses()[0])
+	if err != nil {
+		t.Fatalf("unexpected error from ParseChallengeResponse(): %v", err)
+	}
+	err = resp.VerifyChallenge(func(keyID string) []byte {
+		if keyID != "2019-01" {
+			return nil
+		}
+		return []byte("0123456789abcdef")
+	}, "n0nce")
+	if err != nil {
+		t.Errorf("unexpected error from VerifyChallenge(): %v", err)
+	}
+}
+
+func TestFetchAttestationDataVersionChallenge(t *testing.T) {
+	tCase := []struct {
+		advertised  []int
+		wantVersion int
+		wantResent  bool
+		wantErr     bool
+	}{
+		// 0: the version sent is supported
+		{advertised: []int{1, 2}, wantVersion: 2},
+		// 1: servers predating version 2 get the data again in version 1
+		{advertised: []int{1}, wantVersion: 1, wantResent: true},
+		// 2: no version in common
+		{advertised: []int{3}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		challenge, err := (&common.Challenge{Type: common.ChallengeVersion, Nonce: "n0nce", Versions: c.advertised}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := newTestPlugin()
+		p.config.PayloadFormat = payloadFormatJSON
+		p.config.PayloadVersion = common.PayloadVersion2
+		p.config.NegotiatePayloadVersion = true
+		p.config.payloadVersions = []int{common.PayloadVersion1, common.PayloadVersion2}
+		p.metaData = &openstack.Metadata{UUID: "alpha", Name: "web"}
+
+		f := fake.NewFakeFetchAttestationStreamWithChallenges(challenge)
+		err = p.FetchAttestationData(f)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		sent, err := common.ParseAttestationPayload(f.Response().AttestationData.Data)
+		if err != nil || len(sent.Versions) != 2 {
+			t.Errorf("#%v: versions are not listed: %+v, %v", i, sent, err)
+		}
+		if len(f.ChallengeResponses()) != 1 {
+			t.Fatalf("#%v: got %v responses, want 1", i, len(f.ChallengeResponses()))
+		}
+		resp, err := common.ParseChallengeResponse(f.ChallengeResponses()[0])
+		if err != nil {
+			t.Fatalf("#%v: unexpected error from ParseChallengeResponse(): %v", i, err)
+		}
+		if resp.Version != c.wantVersion || (len(resp.AttestationData) > 0) != c.wantResent {
+			t.Errorf("#%v: got version %v, resent %v", i, resp.Version, len(resp.AttestationData) > 0)
+		}
+		if c.wantResent {
+			resent, err := common.ParseAttestationPayload(resp.AttestationData)
+			if err != nil || resent.PayloadVersion() != c.wantVersion || resent.Metadata != nil || resent.Versions != nil {
+				t.Errorf("#%v: unexpected payload sent again: %+v, %v", i, resent, err)
+			}
+		}
+	}
+}
+
+func TestFetchAttestationDataDMIUUID(t *testing.T) {
+	const instanceUUID = "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+	tCase := []struct {
+		dmiUUID string
+		dmiErr  error
+		wantErr bool
+	}{
+		// 0: metadata of the instance
+		{dmiUUID: "0A1B2C3D-4E5F-6A7B-8C9D-0E1F2A3B4C5D"},
+		// 1: metadata of another instance
+		{dmiUUID: "5d4c3b2a-1f0e-9d8c-7b6a-5f4e3d2c1b0a", wantErr: true},
+		// 2: DMI table unreadable
+		{dmiErr: errors.New("permission denied"), wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.config.VerifyDMIUUID = true
+		p.metaData = &openstack.Metadata{UUID: instanceUUID}
+		p.getDMIUUIDHandler = func() (string, error) {
+			return c.dmiUUID, c.dmiErr
+		}
+
+		err := p.FetchAttestationData(fake.NewFakeFetchAttestationStream())
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestFetchAttestationDataConsoleBeacon(t *testing.T) {
+	beacon, err := (&common.Challenge{Type: common.ChallengeConsoleBeacon, Nonce: "n0nce"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := (&common.Challenge{Type: "keypair", Nonce: "n0nce"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacChallenge, err := (&common.Challenge{Type: common.ChallengeHMAC, Nonce: "n0nce"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		challenges [][]byte
+		writeErr   error
+		written    []string
+		wantErr    bool
+	}{
+		// 0: no challenge from servers without console_beacon
+		{},
+		// 1: beacon challenge
+		{challenges: [][]byte{beacon}, written: []string{"/dev/ttyS0: SPIRE-OPENSTACK-BEACON n0nce"}},
+		// 2: console not writable
+		{challenges: [][]byte{beacon}, writeErr: errors.New("permission denied"), wantErr: true},
+		// 3: challenge unknown to this version
+		{challenges: [][]byte{unknown}, wantErr: true},
+		// 4: malformed challenge
+		{challenges: [][]byte{[]byte("n0nce")}, wantErr: true},
+		// 5: hmac challenge without payload_hmac
+		{challenges: [][]byte{hmacChallenge}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.config.ConsoleBeacon = true
+		p.config.ConsoleDevice = defaultConsoleDevice
+		p.metaData = &openstack.Metadata{UUID: "alpha"}
+		var written []string
+		p.writeConsoleHandler = func(device, line string) error {
+			if c.writeErr != nil {
+				return c.writeErr
+			}
+			written = append(written, device+": "+line)
+			return nil
+		}
+
+		f := fake.NewFakeFetchAttestationStreamWithChallenges(c.challenges...)
+		err := p.FetchAttestationData(f)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if fmt.Sprint(written) != fmt.Sprint(c.written) {
+			t.Errorf("#%v: got written %v, want %v", i, written, c.written)
+		}
+		if len(f.ChallengeResponses()) != len(c.challenges) {
+			t.Errorf("#%v: got %v responses, want %v", i, len(f.ChallengeResponses()), len(c.challenges))
+		}
+		for _, b := range f.ChallengeResponses() {
+			resp, err := common.ParseChallengeResponse(b)
+			if err != nil || resp.Type != common.ChallengeConsoleBeacon || resp.Nonce != "n0nce" {
+				t.Errorf("#%v: unexpected response: %s", i, b)
+			}
+		}
+	}
+}
+
+func TestFetchAttestationDataInvalidPayload(t *testing.T) {
+	for i, uuid := range []string{"", "alpha bravo", strings.Repeat("a", 5000)} {
+		p := newTestPlugin()
+		p.metaData = &openstack.Metadata{
+			UUID:      uuid,
+			ProjectID: "bravo",
+		}
+
+		f := fake.NewFakeFetchAttestationStream()
+		if err := p.FetchAttestationData(f); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+		if f.Response() != nil {
+			t.Errorf("#%v: invalid attestation data was sent", i)
+		}
+	}
+}
+
 func TestFetchAttestationDataNoConfigure(t *testing.T) {
 	p := newTestPlugin()
 	p.config = nil
@@ -130,18 +1579,147 @@ func TestFetchAttestationDataNoConfigure(t *testing.T) {
 	}
 }
 
+func TestConfigureRequireOpenStack(t *testing.T) {
+	tCase := []struct {
+		config       string
+		probeErr     error
+		wantErr      bool
+		wantFetchErr bool
+	}{
+		// 0: OpenStack instance
+		{},
+		// 1: not an OpenStack instance
+		{probeErr: openstack.ErrNotOpenStack, wantErr: true},
+		// 2: required explicitly
+		{config: `require_openstack = true`, probeErr: openstack.ErrNotOpenStack, wantErr: true},
+		// 3: disabled off OpenStack
+		{config: `require_openstack = false`, probeErr: openstack.ErrNotOpenStack, wantFetchErr: true},
+		// 4: not disabled on OpenStack
+		{config: `require_openstack = false`},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			return &openstack.Metadata{UUID: "alpha"}, nil
+		}
+		p.probeOpenStackHandler = func(context.Context, *openstack.MetadataService) (string, error) {
+			if c.probeErr != nil {
+				return "", c.probeErr
+			}
+			return openstack.ProbeMetadataService, nil
+		}
+
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(context.Background(), cReq)
+		if c.wantErr {
+			if !errors.Is(err, openstack.ErrNotOpenStack) {
+				t.Errorf("#%v: got %v, want %v", i, err, openstack.ErrNotOpenStack)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+
+		f := fake.NewFakeFetchAttestationStream()
+		err = p.FetchAttestationData(f)
+		if c.wantFetchErr {
+			if err == nil || !strings.Contains(err.Error(), "plugin disabled") {
+				t.Errorf("#%v: got %v, want the plugin disabled", i, err)
+			}
+			if f.Response() != nil {
+				t.Errorf("#%v: attestation data was sent by the disabled plugin", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error from FetchAttestationData(): %v", i, err)
+		}
+	}
+}
+
+func TestConfigureMetadataWait(t *testing.T) {
+	tCase := []struct {
+		config    string
+		failures  int
+		canceled  bool
+		wantErr   bool
+		wantCalls int
+	}{
+		// 0: no wait
+		{failures: 1, wantErr: true, wantCalls: 1},
+		// 1: the metadata service comes up within the wait
+		{config: `metadata_wait = "10s"`, failures: 1, wantCalls: 2},
+		// 2: the metadata service never comes up
+		{config: `metadata_wait = "10ms"`, failures: -1, wantErr: true, wantCalls: 2},
+		// 3: disabled once the wait is over
+		{config: "metadata_wait = \"10ms\"\nrequire_openstack = false", failures: -1, wantCalls: 2},
+		// 4: the wait is abandoned with the request
+		{config: `metadata_wait = "1h"`, failures: -1, canceled: true, wantErr: true, wantCalls: 1},
+		// 5: invalid wait
+		{config: `metadata_wait = "forever"`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		calls := 0
+		p.probeOpenStackHandler = func(context.Context, *openstack.MetadataService) (string, error) {
+			calls++
+			if c.failures < 0 || calls <= c.failures {
+				return "", openstack.ErrNotOpenStack
+			}
+			return openstack.ProbeMetadataService, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.canceled {
+			cancel()
+		}
+		cReq := newConfigureRequest()
+		cReq.Configuration = c.config
+		_, err := p.Configure(ctx, cReq)
+		cancel()
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+		if calls != c.wantCalls {
+			t.Errorf("#%v: probed %v times, want %v", i, calls, c.wantCalls)
+		}
+	}
+}
+
 func TestFetchAttestationDataMetadataError(t *testing.T) {
 	p := newTestPlugin()
-
-	errMsg := "plugin not configured"
+	errMsg := "fake error"
+	calls := 0
+	p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New(errMsg)
+		}
+		return &openstack.Metadata{UUID: "alpha"}, nil
+	}
 
 	f := fake.NewFakeFetchAttestationStream()
-
 	err := p.FetchAttestationData(f)
-	if err == nil {
-		t.Error("expected an error is occurred but got nil")
+	wantErr := fmt.Sprintf("failed to retrieve openstack metadta: %v", errMsg)
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
 	}
-	if err.Error() != errMsg {
-		t.Errorf("got %v, want %v", err.Error(), errMsg)
+
+	// The failure is not cached, and the metadata is once retrieved
+	for i := 0; i < 2; i++ {
+		f = fake.NewFakeFetchAttestationStream()
+		if err := p.FetchAttestationData(f); err != nil {
+			t.Errorf("#%v: unexpected error from FetchAttestationData(): %v", i, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("metadata retrieved %v times, want 2", calls)
 	}
 }
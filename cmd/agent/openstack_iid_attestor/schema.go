@@ -0,0 +1,46 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// schemaMain prints the schema of the configuration of the plugin as JSON
+func schemaMain(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor schema")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s, err := configSchema()
+	if err == nil {
+		err = s.WriteJSON(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// configSchema returns the schema of IIDAttestorPluginConfig
+func configSchema() (*common.ConfigSchema, error) {
+	return common.NewConfigSchema("agent", "NodeAttestor", common.PluginName, &IIDAttestorPluginConfig{})
+}
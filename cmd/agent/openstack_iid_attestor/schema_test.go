@@ -0,0 +1,68 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// schemaDefault returns the default of the option at the path of option names
+func schemaDefault(t *testing.T, options []*common.ConfigOption, path ...string) string {
+	for _, o := range options {
+		if o.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return o.Default
+		}
+		return schemaDefault(t, o.Options, path[1:]...)
+	}
+	t.Fatalf("option %v not found", path)
+	return ""
+}
+
+func TestConfigSchema(t *testing.T) {
+	s, err := configSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The defaults of the schema must match the defaults applied by Configure
+	for _, c := range []struct {
+		path []string
+		want time.Duration
+	}{
+		{path: []string{"attestation_timeout"}, want: defaultAttestationTimeout},
+		{path: []string{"metadata_timeout"}, want: defaultMetadataTimeout},
+		{path: []string{"metadata_retry", "base_delay"}, want: defaultRetryBaseDelay},
+		{path: []string{"metadata_retry", "max_delay"}, want: defaultRetryMaxDelay},
+	} {
+		if d, err := time.ParseDuration(schemaDefault(t, s.Options, c.path...)); err != nil || d != c.want {
+			t.Errorf("got default %v of %v, want %v", d, c.path, c.want)
+		}
+	}
+	if got := schemaDefault(t, s.Options, "metadata_retry", "max_attempts"); got != strconv.Itoa(defaultRetryMaxAttempts) {
+		t.Errorf("got default max_attempts %v, want %v", got, defaultRetryMaxAttempts)
+	}
+	if got := schemaDefault(t, s.Options, "payload_hmac", "metadata_key"); got != defaultHMACMetadataKey {
+		t.Errorf("got default metadata_key %v, want %v", got, defaultHMACMetadataKey)
+	}
+	if got := schemaDefault(t, s.Options, "bundle_fingerprint", "metadata_key"); got != defaultBundleFingerprintMetadataKey {
+		t.Errorf("got default metadata_key %v, want %v", got, defaultBundleFingerprintMetadataKey)
+	}
+	if got := schemaDefault(t, s.Options, "console_device"); got != defaultConsoleDevice {
+		t.Errorf("got default console_device %v, want %v", got, defaultConsoleDevice)
+	}
+	if got := schemaDefault(t, s.Options, "metadata_source"); got != metadataSourceService {
+		t.Errorf("got default metadata_source %v, want %v", got, metadataSourceService)
+	}
+}
@@ -0,0 +1,208 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// commandTrustDomain is passed to Configure by the self-test and evidence commands. Configure requires a trust
+// domain, which the agent plugin doesn't use.
+const commandTrustDomain = "agent.invalid"
+
+// selfTestMain retrieves the metadata and prepares the attestation data as configured, printing a report of the
+// steps, and the attestation payload with -print-payload. It returns 1 if any step failed.
+func selfTestMain(args []string) int {
+	fs := flag.NewFlagSet("self-test", flag.ContinueOnError)
+	configFile := fs.String("config", "", "file of the plugin_data of the agent plugin (default: empty configuration)")
+	verbose := fs.Bool("v", false, "log at debug level")
+	printPayload := fs.Bool("print-payload", false, "print the attestation payload the agent would send")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor self-test [-config <file>] [-print-payload]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	var conf []byte
+	if *configFile != "" {
+		var err error
+		conf, err = ioutil.ReadFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	level := hclog.Warn
+	if *verbose {
+		level = hclog.Debug
+	}
+	p := New()
+	p.SetLogger(hclog.New(&hclog.LoggerOptions{
+		Name:   "self-test",
+		Output: os.Stderr,
+		Level:  level,
+	}))
+
+	var payloadOut io.Writer
+	if *printPayload {
+		payloadOut = os.Stdout
+	}
+	r := selftest.NewReport(os.Stdout)
+	selfTest(context.Background(), p, string(conf), r, payloadOut)
+	return r.Summary()
+}
+
+// selfTest runs the steps of the self-test with the plugin, which must not be configured yet. The attestation payload
+// is printed to payloadOut unless it is nil.
+func selfTest(ctx context.Context, p *IIDAttestorPlugin, conf string, r *selftest.Report, payloadOut io.Writer) {
+	config := &IIDAttestorPluginConfig{}
+	if err := common.DecodeConfig(config, conf); err != nil {
+		r.Fail("configuration", fmt.Errorf("failed to decode configuration file: %v", err))
+		return
+	}
+	sources, err := metadataSources(config)
+	if err != nil {
+		r.Fail("configuration", err)
+		return
+	}
+
+	// Each source is tried on its own, so that unavailable fallbacks are reported before they are needed
+	available := 0
+	var failures []error
+	for _, source := range sources {
+		meta, _, err := p.getMetadata(ctx, config.getMetadataService(), []string{source})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%v: %v", source, err))
+			continue
+		}
+		available++
+		r.Pass("metadata from "+source, "instance %v in project %v", meta.UUID, meta.ProjectID)
+	}
+	for _, err := range failures {
+		if available > 0 {
+			r.Warn("metadata", err)
+		} else {
+			r.Fail("metadata", err)
+		}
+	}
+
+	_, err = p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: conf,
+		GlobalConfig:  &spi.ConfigureRequest_GlobalConfig{TrustDomain: commandTrustDomain},
+	})
+	if err != nil {
+		r.Fail("configuration", err)
+		return
+	}
+	r.Pass("configuration", "metadata sources %v", strings.Join(p.config.sources, ", "))
+
+	if target := p.config.SignedIdentityTarget; target == "" {
+		r.Skip("signed identity", "signed_identity_target is not set")
+	} else if err := checkSignedIdentity(ctx, p, target); err != nil {
+		r.Fail("signed identity", err)
+	} else {
+		r.Pass("signed identity", "served by vendordata target %v", target)
+	}
+
+	if !p.config.VerifyDMIUUID {
+		r.Skip("dmi uuid", "verify_dmi_uuid is not enabled")
+	} else if meta, _, err := p.metadata(ctx); err != nil {
+		r.Fail("dmi uuid", err)
+	} else if err := p.checkDMIUUID(meta.UUID); err != nil {
+		r.Fail("dmi uuid", err)
+	} else {
+		r.Pass("dmi uuid", "instance %v matches the DMI table", meta.UUID)
+	}
+
+	payload, err := p.attestationPayload(ctx)
+	var data []byte
+	if err == nil {
+		data, err = p.encodeAttestationData(payload)
+	}
+	if err != nil {
+		r.Fail("attestation data", err)
+	} else {
+		r.Pass("attestation data", "%d bytes in the %v format from the metadata of %v", len(data), p.config.PayloadFormat, p.metadataSource())
+	}
+	// the payload is printed even if it fails to encode, e.g. exceeding the size limit, to show what is in it
+	if payloadOut != nil && payload != nil {
+		if err := printAttestationPayload(payloadOut, p.config.PayloadFormat, payload); err != nil {
+			r.Fail("attestation payload", err)
+		}
+	}
+
+	if !p.config.ConsoleBeacon {
+		r.Skip("console device", "console_beacon is not enabled")
+	} else if err := checkConsole(p.config.ConsoleDevice); err != nil {
+		r.Fail("console device", err)
+	} else {
+		r.Pass("console device", "%v is writable", p.config.ConsoleDevice)
+	}
+}
+
+// printAttestationPayload prints the payload as the server decodes it, i.e. before compression and encryption
+func printAttestationPayload(w io.Writer, format string, payload *common.AttestationPayload) error {
+	if format != payloadFormatJSON {
+		_, err := fmt.Fprintln(w, payload.InstanceID)
+		return err
+	}
+	b, err := payload.Marshal()
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// checkSignedIdentity verifies that the vendordata target serves a well-formed signed identity
+func checkSignedIdentity(ctx context.Context, p *IIDAttestorPlugin, target string) error {
+	raw, err := p.getVendorDataHandler(ctx, target)
+	if err != nil {
+		return err
+	}
+	signed := &vendordata.SignedIdentity{}
+	if err := json.Unmarshal(raw, signed); err != nil {
+		return fmt.Errorf("malformed signed identity in vendordata of %v: %v", target, err)
+	}
+	return signed.Validate()
+}
+
+// checkConsole verifies that the console device can be opened for writing, without writing to it
+func checkConsole(device string) error {
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
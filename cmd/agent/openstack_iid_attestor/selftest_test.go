@@ -0,0 +1,150 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestSelfTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selftest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	console := filepath.Join(dir, "ttyS0")
+	if err := ioutil.WriteFile(console, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		conf       string
+		serviceErr error
+		dmiUUID    string
+		want       []string
+	}{
+		// 0: default configuration
+		{
+			want: []string{"PASS  metadata from metadata_service", "PASS  configuration", "SKIP  signed identity", "SKIP  dmi uuid", "PASS  attestation data", "SKIP  console device"},
+		},
+		// 1: fallback to the config drive
+		{
+			conf:       `metadata_sources = ["metadata_service", "config_drive"]`,
+			serviceErr: errors.New("connection refused"),
+			want:       []string{"PASS  metadata from config_drive", "WARN  metadata: metadata_service", "PASS  configuration", "SKIP  signed identity", "SKIP  dmi uuid", "PASS  attestation data", "SKIP  console device"},
+		},
+		// 2: no metadata
+		{
+			serviceErr: errors.New("connection refused"),
+			want:       []string{"FAIL  metadata: metadata_service", "PASS  configuration", "SKIP  signed identity", "SKIP  dmi uuid", "FAIL  attestation data", "SKIP  console device"},
+		},
+		// 3: signed identity and console beacon
+		{
+			conf: `payload_format = "json"
+signed_identity_target = "spire"
+console_beacon = true
+console_device = "` + console + `"`,
+			want: []string{"PASS  metadata from metadata_service", "PASS  configuration", "PASS  signed identity", "SKIP  dmi uuid", "PASS  attestation data", "PASS  console device"},
+		},
+		// 4: console device missing
+		{
+			conf: `console_beacon = true
+console_device = "` + filepath.Join(dir, "ttyS1") + `"`,
+			want: []string{"PASS  metadata from metadata_service", "PASS  configuration", "SKIP  signed identity", "SKIP  dmi uuid", "PASS  attestation data", "FAIL  console device"},
+		},
+		// 5: metadata of another instance
+		{
+			conf:    `verify_dmi_uuid = true`,
+			dmiUUID: "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+			want:    []string{"PASS  metadata from metadata_service", "PASS  configuration", "SKIP  signed identity", "FAIL  dmi uuid", "FAIL  attestation data", "SKIP  console device"},
+		},
+	}
+
+	for i, c := range tCase {
+		p := New()
+		p.SetLogger(testutil.TestLogger())
+		p.probeOpenStackHandler = probeOpenStack
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			if c.serviceErr != nil {
+				return nil, c.serviceErr
+			}
+			return &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}, nil
+		}
+		p.getConfigDriveMetadataHandler = func() (*openstack.Metadata, error) {
+			return &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}, nil
+		}
+		p.getVendorDataHandler = func(context.Context, string) (json.RawMessage, error) {
+			return json.RawMessage(`{"kid": "k1", "alg": "EdDSA", "document": "e30", "signature": "c2ln"}`), nil
+		}
+		p.getDMIUUIDHandler = func() (string, error) {
+			return c.dmiUUID, nil
+		}
+		p.getDMIUUIDHandler = func() (string, error) {
+			return c.dmiUUID, nil
+		}
+
+		var b bytes.Buffer
+		selfTest(context.Background(), p, c.conf, selftest.NewReport(&b), nil)
+
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != len(c.want) {
+			t.Errorf("#%v: unexpected report: %v", i, b.String())
+			continue
+		}
+		for j, want := range c.want {
+			if !strings.HasPrefix(lines[j], want) {
+				t.Errorf("#%v: got %q, want %q", i, lines[j], want)
+			}
+		}
+	}
+}
+
+func TestSelfTestPrintPayload(t *testing.T) {
+	tCase := []struct {
+		conf string
+		want string
+	}{
+		// 0: raw format
+		{
+			want: "alpha\n",
+		},
+		// 1: json format, printed as decoded by the server despite compression
+		{
+			conf: `payload_format = "json"
+payload_compression = "gzip"`,
+			want: "{\n  \"instance_id\": \"alpha\",\n",
+		},
+	}
+
+	for i, c := range tCase {
+		p := New()
+		p.SetLogger(testutil.TestLogger())
+		p.probeOpenStackHandler = probeOpenStack
+		p.getMetadataHandler = func(context.Context, *openstack.MetadataService) (*openstack.Metadata, error) {
+			return &openstack.Metadata{UUID: "alpha", ProjectID: "bravo"}, nil
+		}
+
+		var report, payload bytes.Buffer
+		selfTest(context.Background(), p, c.conf, selftest.NewReport(&report), &payload)
+		if !strings.HasPrefix(payload.String(), c.want) {
+			t.Errorf("#%v: got payload %q, want %q", i, payload.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+var errShuttingDown = errors.New("agent is shutting down")
+
+// Shutdown stops fetching attestation data and waits for the attestations in flight until ctx is done,
+// cancelling the rest. The plugin can't be used afterwards.
+func (p *IIDAttestorPlugin) Shutdown(ctx context.Context) error {
+	return p.drain.Close(ctx)
+}
+
+// stop shuts the plugin down within attestation_timeout, once whether SPIRE stopped the plugin or the process is
+// terminated
+func (p *IIDAttestorPlugin) stop() {
+	p.stopOnce.Do(func() {
+		logger := p.logger
+		if logger == nil {
+			logger = hclog.Default()
+		}
+		timeout := defaultAttestationTimeout
+		p.mtx.RLock()
+		if p.config != nil {
+			timeout = p.config.attestationTimeout
+		}
+		p.mtx.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := p.Shutdown(ctx); err != nil {
+			logger.Warn("Plugin didn't shut down cleanly", "error", err)
+			return
+		}
+		logger.Info("Plugin shut down")
+	})
+}
+
+// watchShutdownSignals shuts the plugin down and exits on SIGTERM, instead of dropping the attestation in flight
+func (p *IIDAttestorPlugin) watchShutdownSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	go func() {
+		<-sig
+		p.stop()
+		os.Exit(0)
+	}()
+}
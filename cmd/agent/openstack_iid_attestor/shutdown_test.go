@@ -0,0 +1,48 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestShutdown(t *testing.T) {
+	p := newTestPlugin()
+	fetching := make(chan struct{})
+	p.getMetadataHandler = func(ctx context.Context, _ *openstack.MetadataService) (*openstack.Metadata, error) {
+		close(fetching)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.FetchAttestationData(fake.NewFakeFetchAttestationStream())
+	}()
+	<-fetching
+
+	// the attestation in flight is cancelled at the deadline of the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err == nil {
+		t.Error("expected error for the cancelled attestation, got nil")
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("unexpected error from FetchAttestationData(): %v", err)
+	}
+
+	if err := p.FetchAttestationData(fake.NewFakeFetchAttestationStream()); err != errShuttingDown {
+		t.Errorf("unexpected error: got %v, want %v", err, errShuttingDown)
+	}
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+// Evidence verified by attestations, which contributes to the assurance level of the instance
+const (
+	// the instance ID is of an instance of Nova
+	evidenceInstanceLookup = "instance_lookup"
+	// the metadata sent by the agent matches Nova
+	evidencePayloadMetadata = "payload_metadata"
+	// the payload is authenticated with a secret provisioned to the project
+	evidencePayloadHMAC = "payload_hmac"
+	// the agent sent an identity signed by a vendordata service
+	evidenceSignedIdentity = "signed_identity"
+	// the agent authenticated a nonce with a secret provisioned to the project
+	evidenceHMACChallenge = "hmac_challenge"
+	// the agent wrote a nonce to the serial console of the instance
+	evidenceConsoleBeacon = "console_beacon"
+)
+
+// defaultAssuranceWeights are the weights of evidence, which grow with the effort of forging it
+var defaultAssuranceWeights = map[string]int{
+	evidenceInstanceLookup:  1,
+	evidencePayloadMetadata: 1,
+	evidencePayloadHMAC:     2,
+	evidenceSignedIdentity:  2,
+	evidenceHMACChallenge:   3,
+	evidenceConsoleBeacon:   3,
+}
+
+var assuranceLevels = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "attestation_assurance_levels_total",
+	Help:      "Number of attestations which passed the checks other than min_assurance_level, by assurance level.",
+}, []string{"level"})
+
+func init() {
+	telemetry.Registry.MustRegister(assuranceLevels)
+}
+
+// validateAssuranceConfig validates the weights of evidence, and that the enabled checks can reach
+// min_assurance_level
+func validateAssuranceConfig(c *IIDAttestorPluginConfig) error {
+	weights := make(map[string]int)
+	for evidence, w := range defaultAssuranceWeights {
+		weights[evidence] = w
+	}
+	for evidence, w := range c.AssuranceWeights {
+		if _, ok := defaultAssuranceWeights[evidence]; !ok {
+			return fmt.Errorf("unknown evidence in assurance_weights: %q", evidence)
+		}
+		if w < 0 {
+			return fmt.Errorf("weight of %v in assurance_weights must not be negative", evidence)
+		}
+		weights[evidence] = w
+	}
+	c.assuranceWeights = weights
+
+	if c.MinAssuranceLevel < 0 {
+		return errors.New("min_assurance_level must not be negative")
+	}
+	if max := maxAssuranceLevel(c); max < c.MinAssuranceLevel {
+		return fmt.Errorf("min_assurance_level of %v is unreachable, since the enabled checks reach %v", c.MinAssuranceLevel, max)
+	}
+	return nil
+}
+
+// maxAssuranceLevel returns the level of instances passing all checks enabled by the configuration
+func maxAssuranceLevel(c *IIDAttestorPluginConfig) int {
+	enabled := map[string]bool{
+		evidenceInstanceLookup:  true,
+		evidencePayloadMetadata: c.VerifyPayloadMetadata,
+		evidencePayloadHMAC:     len(c.PayloadHMACKeys) > 0,
+		evidenceSignedIdentity:  len(c.SignedIdentityKeys) > 0 || c.SignedIdentityCAFile != "",
+		evidenceHMACChallenge:   c.ChallengeHMAC,
+		evidenceConsoleBeacon:   c.ConsoleBeacon != nil,
+	}
+	level := 0
+	for evidence, ok := range enabled {
+		if ok {
+			level += c.assuranceWeights[evidence]
+		}
+	}
+	return level
+}
+
+// addEvidence records evidence verified by the attestation
+func (a *attestation) addEvidence(evidence string) {
+	for _, e := range a.evidence {
+		if e == evidence {
+			return
+		}
+	}
+	a.evidence = append(a.evidence, evidence)
+}
+
+// assuranceLevel returns the sum of the weights of the evidence verified by the attestation
+func (a *attestation) assuranceLevel(c *IIDAttestorPluginConfig) int {
+	level := 0
+	for _, e := range a.evidence {
+		level += c.assuranceWeights[e]
+	}
+	return level
+}
+
+// checkAssurance denies instances whose verified evidence falls short of min_assurance_level. It is checked once
+// all evidence, including the answers to challenges, is verified.
+func (p *IIDAttestorPlugin) checkAssurance(a *attestation) error {
+	level := a.assuranceLevel(p.config)
+	evidence := append([]string(nil), a.evidence...)
+	sort.Strings(evidence)
+	a.logger.Debug("Assessed assurance level", "instance_id", a.instanceID, "level", level, "evidence", strings.Join(evidence, ","))
+
+	assuranceLevels.WithLabelValues(strconv.Itoa(level)).Inc()
+	if level < p.config.MinAssuranceLevel {
+		return deny(reasonAssuranceTooLow, fmt.Errorf("assurance level of instance %v is %v with evidence %v, below %v", a.instanceID, level, strings.Join(evidence, ","), p.config.MinAssuranceLevel))
+	}
+	return nil
+}
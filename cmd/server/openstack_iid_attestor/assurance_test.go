@@ -0,0 +1,73 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestValidateAssuranceConfig(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: disabled
+		{config: &IIDAttestorPluginConfig{}},
+		// 1: instance lookup only
+		{config: &IIDAttestorPluginConfig{MinAssuranceLevel: 1}},
+		// 2: unreachable without other checks
+		{config: &IIDAttestorPluginConfig{MinAssuranceLevel: 2}, wantErr: true},
+		// 3: reachable with the HMAC challenge
+		{config: &IIDAttestorPluginConfig{MinAssuranceLevel: 6, PayloadHMACKeys: []*PayloadHMACKeyConfig{{}}, ChallengeHMAC: true}},
+		// 4: weights override the defaults
+		{config: &IIDAttestorPluginConfig{MinAssuranceLevel: 3, AssuranceWeights: map[string]int{evidenceInstanceLookup: 3}}},
+		// 5: unknown evidence
+		{config: &IIDAttestorPluginConfig{AssuranceWeights: map[string]int{"keypair": 1}}, wantErr: true},
+		// 6: negative weight
+		{config: &IIDAttestorPluginConfig{AssuranceWeights: map[string]int{evidenceInstanceLookup: -1}}, wantErr: true},
+		// 7: negative level
+		{config: &IIDAttestorPluginConfig{MinAssuranceLevel: -1}, wantErr: true},
+	} {
+		err := validateAssuranceConfig(c.config)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestAttestAssurance(t *testing.T) {
+	for i, c := range []struct {
+		minLevel int
+		wantCode string
+	}{
+		// 0: the instance lookup suffices
+		{minLevel: 1},
+		// 1: the instance lookup falls short
+		{minLevel: 2, wantCode: reasonAssuranceTooLow},
+	} {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.MinAssuranceLevel = c.minLevel
+		p.config.assuranceWeights = defaultAssuranceWeights
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		err := p.Attest(fake.NewAttestStream(testUUID))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
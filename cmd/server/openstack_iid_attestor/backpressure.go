@@ -0,0 +1,318 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	defaultBusyRetryAfter          = time.Second
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 30 * time.Second
+)
+
+var circuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "openstack_circuit_open",
+	Help:      "1 if the circuit breaker stopped Nova lookups, 0 otherwise.",
+})
+
+func init() {
+	telemetry.Registry.MustRegister(circuitOpen)
+}
+
+// ProjectRateLimitConfig is the configuration of the rate limit of attestations per project
+type ProjectRateLimitConfig struct {
+	// Attestations per second each project may sustain, e.g. 0.5.
+	Rate float64 `hcl:"rate"`
+	// Attestations each project may make at once before being limited to rate. Defaults to rate rounded up.
+	Burst int `hcl:"burst"`
+}
+
+// CircuitBreakerConfig is the configuration of the circuit breaker of Nova lookups
+type CircuitBreakerConfig struct {
+	// Number of consecutive failures of Nova which opens the circuit. Defaults to 5.
+	FailureThreshold int `hcl:"failure_threshold" default:"5"`
+	// Duration the circuit stays open before a trial lookup, e.g. "1m". Defaults to 30 seconds.
+	OpenDuration string `hcl:"open_duration" default:"30s"`
+	openDuration time.Duration
+}
+
+func validateBackpressureConfig(c *IIDAttestorPluginConfig) error {
+	if c.MaxConcurrentAttestations < 0 {
+		return errors.New("max_concurrent_attestations must not be negative")
+	}
+	c.busyRetryAfter = defaultBusyRetryAfter
+	if c.BusyRetryAfter != "" {
+		d, err := time.ParseDuration(c.BusyRetryAfter)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid busy_retry_after: %q", c.BusyRetryAfter)
+		}
+		c.busyRetryAfter = d
+	}
+
+	if rc := c.ProjectRateLimit; rc != nil {
+		if rc.Rate <= 0 {
+			return errors.New("rate of project_rate_limit must be positive")
+		}
+		if rc.Burst < 0 {
+			return errors.New("burst of project_rate_limit must not be negative")
+		}
+		if rc.Burst == 0 {
+			rc.Burst = int(math.Ceil(rc.Rate))
+		}
+	}
+
+	bc := c.CircuitBreaker
+	if bc == nil {
+		return nil
+	}
+	if bc.FailureThreshold < 0 {
+		return errors.New("failure_threshold of circuit_breaker must not be negative")
+	}
+	if bc.FailureThreshold == 0 {
+		bc.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	bc.openDuration = defaultBreakerOpenDuration
+	if bc.OpenDuration != "" {
+		d, err := time.ParseDuration(bc.OpenDuration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid open_duration of circuit_breaker: %q", bc.OpenDuration)
+		}
+		bc.openDuration = d
+	}
+	return nil
+}
+
+// backpressureError rejects an attestation the server can't process now, hinting the agent when to retry.
+// It doesn't tell anything about the instance, and is never cached as a denial.
+type backpressureError struct {
+	code       string
+	grpcCode   codes.Code
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *backpressureError) Error() string {
+	return e.err.Error()
+}
+
+// GRPCStatus returns the status sent to the agent, carrying the reason code and the delay to retry after in the details
+func (e *backpressureError) GRPCStatus() *status.Status {
+	st := reasonStatus(e.grpcCode, e.code, e.err.Error())
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(e.retryAfter),
+	})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+// limiter bounds the number of attestations processed concurrently. A nil limiter is unlimited.
+type limiter struct {
+	slots      chan struct{}
+	retryAfter time.Duration
+}
+
+func newLimiter(max int, retryAfter time.Duration) *limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &limiter{
+		slots:      make(chan struct{}, max),
+		retryAfter: retryAfter,
+	}
+}
+
+// acquire takes a slot, which must be released after the attestation.
+// Attestations are rejected with ResourceExhausted instead of queued when no slot is free.
+func (l *limiter) acquire() (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	default:
+		return nil, &backpressureError{
+			code:       reasonServerBusy,
+			grpcCode:   codes.ResourceExhausted,
+			retryAfter: l.retryAfter,
+			err:        fmt.Errorf("server is busy with %d attestations", cap(l.slots)),
+		}
+	}
+}
+
+// projectRateLimiter limits the rate of attestations of each project with a token bucket per project, so that agents
+// of a project retrying in a loop can't starve the attestations of other projects. Projects are those Nova reports,
+// since agents could claim any project. A nil limiter is unlimited.
+type projectRateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mtx sync.Mutex
+	// Tokens are taken before the policies, so buckets are of any project of the cloud. Those which have filled up
+	// again are evicted, since a full bucket is what a new one would be.
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// tokenBucket holds the attestations a project may make now, as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newProjectRateLimiter(c *ProjectRateLimitConfig) *projectRateLimiter {
+	if c == nil {
+		return nil
+	}
+	return &projectRateLimiter{
+		rate:    c.Rate,
+		burst:   float64(c.Burst),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token of the project, failing with ResourceExhausted if the project has none left
+func (l *projectRateLimiter) allow(projectID string) error {
+	if l == nil {
+		return nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[projectID]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[projectID] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return &backpressureError{
+			code:       reasonProjectRateLimited,
+			grpcCode:   codes.ResourceExhausted,
+			retryAfter: time.Duration((1 - b.tokens) / l.rate * float64(time.Second)),
+			err:        fmt.Errorf("project %v exceeds the rate of %v attestations per second", projectID, l.rate),
+		}
+	}
+	b.tokens--
+	return nil
+}
+
+// sweep evicts the buckets which are full by now, once in the time a bucket takes to fill up
+func (l *projectRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept).Seconds()*l.rate < l.burst {
+		return
+	}
+	l.swept = now
+	for projectID, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, projectID)
+		}
+	}
+}
+
+// circuitBreaker stops Nova lookups after consecutive failures, so that agents are told to back off
+// instead of piling up on an unavailable Nova. A nil breaker is disabled.
+type circuitBreaker struct {
+	logger       hclog.Logger
+	threshold    int
+	openDuration time.Duration
+	now          func() time.Time
+
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(c *CircuitBreakerConfig, logger hclog.Logger) *circuitBreaker {
+	if c == nil {
+		return nil
+	}
+	circuitOpen.Set(0)
+	return &circuitBreaker{
+		logger:       logger,
+		threshold:    c.FailureThreshold,
+		openDuration: c.openDuration,
+		now:          time.Now,
+	}
+}
+
+// allow fails with Unavailable while the circuit is open. Once open_duration elapses, a single lookup is
+// let through to probe Nova, while the others keep failing until its result closes or reopens the circuit.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	now := b.now()
+	if now.Before(b.openUntil) {
+		return &backpressureError{
+			code:       reasonCircuitOpen,
+			grpcCode:   codes.Unavailable,
+			retryAfter: b.openUntil.Sub(now),
+			err:        fmt.Errorf("nova lookups are suspended after %d consecutive failures", b.failures),
+		}
+	}
+	b.openUntil = now.Add(b.openDuration)
+	return nil
+}
+
+// record records the result of a lookup. Instances not found count as successes, since Nova answered.
+func (b *circuitBreaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if ok {
+		if b.failures >= b.threshold {
+			b.logger.Info("Closing circuit of Nova lookups")
+			circuitOpen.Set(0)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			b.logger.Warn("Opening circuit of Nova lookups", "failures", b.failures, "open_duration", b.openDuration)
+			circuitOpen.Set(1)
+		}
+		b.openUntil = b.now().Add(b.openDuration)
+	}
+}
@@ -0,0 +1,181 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+// retryAfter returns the status code of err and the delay hinted in its details
+func retryAfter(t *testing.T, err error) (codes.Code, time.Duration) {
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			delay, err := ptypes.Duration(ri.RetryDelay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st.Code(), delay
+		}
+	}
+	return st.Code(), 0
+}
+
+func TestAttestBusy(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.limiter = newLimiter(1, 3*time.Second)
+
+	// an attestation in progress takes the only slot
+	release, err := p.limiter.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Attest(fake.NewAttestStream(testUUID))
+	if code := reasonCode(err); code != reasonServerBusy {
+		t.Errorf("got reason code %v, want %v", code, reasonServerBusy)
+	}
+	if c, delay := retryAfter(t, err); c != codes.ResourceExhausted || delay != 3*time.Second {
+		t.Errorf("unexpected status: %v, retry after %v", c, delay)
+	}
+
+	release()
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAttestCircuitBreaker(t *testing.T) {
+	now := time.Unix(100, 0)
+	p := newTestPlugin()
+	p.instance = fake.NewErrorInstance("nova: connection refused")
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.breaker = newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 2, openDuration: time.Minute}, testutil.TestLogger())
+	p.breaker.now = func() time.Time { return now }
+
+	attest := func() error {
+		return p.Attest(fake.NewAttestStream(testUUID))
+	}
+
+	// 1: consecutive failures open the circuit
+	for i := 0; i < 2; i++ {
+		if code := reasonCode(attest()); code != reasonOpenStackUnavailable {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, reasonOpenStackUnavailable)
+		}
+	}
+	now = now.Add(10 * time.Second)
+	err := attest()
+	if code := reasonCode(err); code != reasonCircuitOpen {
+		t.Errorf("got reason code %v, want %v", code, reasonCircuitOpen)
+	}
+	if c, delay := retryAfter(t, err); c != codes.Unavailable || delay != 50*time.Second {
+		t.Errorf("unexpected status: %v, retry after %v", c, delay)
+	}
+
+	// 2: a failed trial reopens the circuit
+	now = now.Add(time.Minute)
+	if code := reasonCode(attest()); code != reasonOpenStackUnavailable {
+		t.Errorf("got reason code %v, want %v", code, reasonOpenStackUnavailable)
+	}
+	if code := reasonCode(attest()); code != reasonCircuitOpen {
+		t.Errorf("got reason code %v, want %v", code, reasonCircuitOpen)
+	}
+
+	// 3: a successful trial closes the circuit
+	now = now.Add(time.Minute)
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	for i := 0; i < 2; i++ {
+		if err := attest(); err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestAttestProjectRateLimit(t *testing.T) {
+	now := time.Unix(100, 0)
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.projectRates = newProjectRateLimiter(&ProjectRateLimitConfig{Rate: 0.5, Burst: 2})
+	p.projectRates.now = func() time.Time { return now }
+
+	// 1: the burst is admitted at once
+	for i := 0; i < 2; i++ {
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+	err := p.Attest(fake.NewAttestStream(testUUID))
+	if code := reasonCode(err); code != reasonProjectRateLimited {
+		t.Errorf("got reason code %v, want %v", code, reasonProjectRateLimited)
+	}
+	if c, delay := retryAfter(t, err); c != codes.ResourceExhausted || delay != 2*time.Second {
+		t.Errorf("unexpected status: %v, retry after %v", c, delay)
+	}
+
+	// 2: other projects have their own buckets
+	if err := p.projectRates.allow("xyz"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// 3: tokens are refilled at the rate
+	now = now.Add(2 * time.Second)
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := p.projectRates.allow(testProjectID); err == nil {
+		t.Errorf("an error expected, got nil")
+	}
+
+	// 4: buckets which filled up again are evicted
+	now = now.Add(10 * time.Second)
+	if err := p.projectRates.allow("abc"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := len(p.projectRates.buckets); n != 1 {
+		t.Errorf("got %v buckets, want 1", n)
+	}
+}
+
+func TestValidateBackpressureConfig(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: defaults
+		{config: &IIDAttestorPluginConfig{MaxConcurrentAttestations: 10, CircuitBreaker: &CircuitBreakerConfig{}}},
+		// 1: negative limit
+		{config: &IIDAttestorPluginConfig{MaxConcurrentAttestations: -1}, wantErr: true},
+		// 2: invalid retry delay
+		{config: &IIDAttestorPluginConfig{BusyRetryAfter: "0s"}, wantErr: true},
+		// 3: invalid open duration
+		{config: &IIDAttestorPluginConfig{CircuitBreaker: &CircuitBreakerConfig{OpenDuration: "soon"}}, wantErr: true},
+		// 4: project rate limit with the default burst
+		{config: &IIDAttestorPluginConfig{ProjectRateLimit: &ProjectRateLimitConfig{Rate: 0.1}}},
+		// 5: no rate
+		{config: &IIDAttestorPluginConfig{ProjectRateLimit: &ProjectRateLimitConfig{Burst: 10}}, wantErr: true},
+	} {
+		err := validateBackpressureConfig(c.config)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spiffe/spire/proto/spire/server/nodeattestor"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	defaultBeaconTimeout      = 20 * time.Second
+	defaultBeaconPollInterval = 2 * time.Second
+	defaultBeaconLines        = 50
+
+	// beaconPurpose is the purpose of the nonces of console beacons
+	beaconPurpose = "console_beacon"
+)
+
+// ConsoleBeaconConfig configures the verification of console beacons
+type ConsoleBeaconConfig struct {
+	// Time to wait for the beacon to appear in the console log once the agent answered, e.g. "20s".
+	// Defaults to 20 seconds, and must be shorter than attestation_timeout.
+	Timeout string `hcl:"timeout" default:"20s"`
+	timeout time.Duration
+	// Interval of reading the console log, e.g. "2s". Defaults to 2 seconds.
+	PollInterval string `hcl:"poll_interval" default:"2s"`
+	pollInterval time.Duration
+	// Number of the last lines of the console log searched for the beacon. Defaults to 50.
+	Lines int `hcl:"lines" default:"50"`
+}
+
+var consoleBeacons = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "console_beacons_total",
+	Help:      "Number of console beacon challenges, by result.",
+}, []string{"result"})
+
+func init() {
+	telemetry.Registry.MustRegister(consoleBeacons)
+}
+
+// validateConsoleBeaconConfig validates console_beacon. It must be called after parseDurations.
+func validateConsoleBeaconConfig(c *IIDAttestorPluginConfig) error {
+	bc := c.ConsoleBeacon
+	if bc == nil {
+		return nil
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+		def   time.Duration
+	}{
+		{"timeout", bc.Timeout, &bc.timeout, defaultBeaconTimeout},
+		{"poll_interval", bc.PollInterval, &bc.pollInterval, defaultBeaconPollInterval},
+	} {
+		*d.to = d.def
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid %v of console_beacon: %q", d.name, d.value)
+		}
+		*d.to = v
+	}
+	if bc.timeout >= c.attestationTimeout {
+		return fmt.Errorf("timeout of console_beacon must be shorter than attestation_timeout of %v", c.attestationTimeout)
+	}
+	if bc.Lines < 0 {
+		return errors.New("lines of console_beacon must not be negative")
+	}
+	if bc.Lines == 0 {
+		bc.Lines = defaultBeaconLines
+	}
+	return nil
+}
+
+// verifyConsoleBeacon challenges the agent to write a nonce to the serial console of the instance and waits for it
+// to appear in the console log read from Nova. The console log is written by the hypervisor, so that the beacon
+// binds the agent to the instance through a channel independent of the instance ID it sent.
+func (p *IIDAttestorPlugin) verifyConsoleBeacon(ctx context.Context, stream nodeattestor.NodeAttestor_AttestServer, a *attestation) error {
+	iid := a.instanceID
+	nonce, err := p.nonces.Issue(beaconPurpose, iid)
+	if err != nil {
+		consoleBeacons.WithLabelValues("error").Inc()
+		return &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	// The nonce is consumed whatever the result, so that it can't be answered twice
+	defer func() {
+		if err := p.nonces.Consume(nonce, beaconPurpose, iid); err != nil {
+			a.logger.Debug("Failed to consume the nonce of the console beacon", "instance_id", iid, "error", err)
+		}
+	}()
+
+	challenge, err := (&common.Challenge{Type: common.ChallengeConsoleBeacon, Nonce: nonce}).Marshal()
+	if err != nil {
+		return err
+	}
+	var req *nodeattestor.AttestRequest
+	err = common.CallWithContext(ctx, func() error {
+		if err := stream.Send(&nodeattestor.AttestResponse{Challenge: challenge}); err != nil {
+			return err
+		}
+		req, err = stream.Recv()
+		return err
+	})
+	if err != nil {
+		consoleBeacons.WithLabelValues("unanswered").Inc()
+		return deny(reasonConsoleBeaconFailed, fmt.Errorf("agent of instance %v didn't answer the console beacon challenge: %v", iid, err))
+	}
+	resp, err := common.ParseChallengeResponse(req.Response)
+	if err == nil && (resp.Type != common.ChallengeConsoleBeacon || resp.Nonce != nonce) {
+		err = errors.New("the response is not of the challenge")
+	}
+	if err != nil {
+		consoleBeacons.WithLabelValues("invalid").Inc()
+		return deny(reasonConsoleBeaconFailed, fmt.Errorf("invalid console beacon response from instance %v: %v", iid, err))
+	}
+
+	bc := p.config.ConsoleBeacon
+	ctx, cancel := context.WithTimeout(ctx, bc.timeout)
+	defer cancel()
+	ticker := time.NewTicker(bc.pollInterval)
+	defer ticker.Stop()
+	for {
+		output, err := p.console.GetConsoleOutput(ctx, iid, bc.Lines)
+		switch {
+		case err == nil && common.HasConsoleBeacon(output, nonce):
+			consoleBeacons.WithLabelValues("verified").Inc()
+			a.logger.Debug("Console beacon verified", "instance_id", iid)
+			a.addEvidence(evidenceConsoleBeacon)
+			return nil
+		case err != nil && ctx.Err() == nil:
+			consoleBeacons.WithLabelValues("error").Inc()
+			return transient(fmt.Errorf("failed to read the console log of instance %v: %v", iid, err))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			consoleBeacons.WithLabelValues("missing").Inc()
+			return deny(reasonConsoleBeaconFailed, fmt.Errorf("console beacon of instance %v didn't appear in the console log within %v", iid, bc.timeout))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/nonce"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestConsoleBeacon(t *testing.T) {
+	tCase := []struct {
+		// answer writes the beacon to the console and returns the response to the challenge, or nil to hang up
+		answer   func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse
+		console  *fake.Console
+		wantCode string
+	}{
+		// 0: beacon written to the console
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				console.Write(testUUID, "login: ")
+				console.Write(testUUID, common.ConsoleBeaconLine(c.Nonce)+"\r")
+				return &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+			},
+		},
+		// 1: answered without writing the beacon
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				return &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+			},
+			wantCode: reasonConsoleBeaconFailed,
+		},
+		// 2: beacon of another nonce
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				console.Write(testUUID, common.ConsoleBeaconLine("other"))
+				return &common.ChallengeResponse{Type: c.Type, Nonce: "other"}
+			},
+			wantCode: reasonConsoleBeaconFailed,
+		},
+		// 3: beacon echoed within another line
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				console.Write(testUUID, "$ echo "+common.ConsoleBeaconLine(c.Nonce))
+				return &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+			},
+			wantCode: reasonConsoleBeaconFailed,
+		},
+		// 4: agents without console_beacon don't answer
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				return nil
+			},
+			wantCode: reasonConsoleBeaconFailed,
+		},
+		// 5: console log unavailable
+		{
+			answer: func(console *fake.Console, c *common.Challenge) *common.ChallengeResponse {
+				return &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+			},
+			console:  fake.NewErrorConsole(errors.New("nova: connection refused")),
+			wantCode: reasonOpenStackUnavailable,
+		},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.ConsoleBeacon = &ConsoleBeaconConfig{
+			timeout:      50 * time.Millisecond,
+			pollInterval: 10 * time.Millisecond,
+			Lines:        defaultBeaconLines,
+		}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.nonces = nonce.NewManager(cache.NewMemory(), time.Minute)
+		console := c.console
+		if console == nil {
+			console = fake.NewConsole()
+		}
+		p.console = console
+
+		stream := fake.NewAttestStream(testUUID)
+		stream.Respond = func(b []byte) []byte {
+			challenge, err := common.ParseChallenge(b)
+			if err != nil {
+				t.Errorf("#%v: unexpected challenge: %v", i, err)
+				return nil
+			}
+			resp := c.answer(console, challenge)
+			if resp == nil {
+				return nil
+			}
+			data, _ := resp.Marshal()
+			return data
+		}
+
+		err := p.Attest(stream)
+		if len(stream.Challenges) != 1 {
+			t.Errorf("#%v: got %v challenges, want 1", i, len(stream.Challenges))
+		}
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			} else if stream.Response() == nil || stream.Response().AgentId == "" {
+				t.Errorf("#%v: agent is not admitted: %v", i, stream.Response())
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+		if stream.Response() != nil {
+			t.Errorf("#%v: unexpected response: %v", i, stream.Response())
+		}
+	}
+}
+
+func TestValidateConsoleBeaconConfig(t *testing.T) {
+	tCase := []struct {
+		config  *ConsoleBeaconConfig
+		wantErr bool
+	}{
+		// 0: defaults
+		{config: &ConsoleBeaconConfig{}},
+		// 1: timeout as long as the attestation
+		{config: &ConsoleBeaconConfig{Timeout: "30s"}, wantErr: true},
+		// 2: invalid poll interval
+		{config: &ConsoleBeaconConfig{PollInterval: "0s"}, wantErr: true},
+		// 3: negative lines
+		{config: &ConsoleBeaconConfig{Lines: -1}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		config := &IIDAttestorPluginConfig{
+			attestationTimeout: defaultAttestationTimeout,
+			ConsoleBeacon:      c.config,
+		}
+		err := validateConsoleBeaconConfig(config)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if c.config.timeout != defaultBeaconTimeout || c.config.pollInterval != defaultBeaconPollInterval || c.config.Lines != defaultBeaconLines {
+			t.Errorf("#%v: unexpected defaults: %+v", i, c.config)
+		}
+	}
+}
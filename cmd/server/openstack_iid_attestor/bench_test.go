@@ -0,0 +1,75 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/perf"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+// newBenchmarkPlugin returns a plugin admitting the test instance with metadata and port selectors, whose
+// OpenStack clients cost nothing. It logs at info level, as servers usually do.
+func newBenchmarkPlugin() *IIDAttestorPlugin {
+	p := newTestPlugin()
+	p.logger = hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard, Name: common.PluginName, Level: hclog.Info})
+	p.instance = fake.NewInstance(testProjectID, map[string]string{"env": "prod"}, nil)
+	p.network = fake.NewNetwork([]ports.Port{
+		{ID: "port-1", DeviceID: testUUID, DeviceOwner: "compute:nova"},
+	})
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.AllowedPortDeviceOwners = []string{"compute:*"}
+	p.config.PortDeviceOwnerMode = portOwnerModeDeny
+	p.config.SelectorNamespace = common.SelectorNamespaceProject
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	return p
+}
+
+// BenchmarkAttest measures the overhead of the plugin per attestation, from the payload to the response
+func BenchmarkAttest(b *testing.B) {
+	b.ReportAllocs()
+	p := newBenchmarkPlugin()
+	for i := 0; i < b.N; i++ {
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEvaluate measures the evaluation of the policy of an instance already looked up
+func BenchmarkEvaluate(b *testing.B) {
+	b.ReportAllocs()
+	p := newBenchmarkPlugin()
+	ctx := context.Background()
+	server, err := p.instance.Get(ctx, testUUID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a := p.newAttestation("req-bench", &common.AttestationPayload{InstanceID: testUUID})
+		a.server = server
+		if _, err := p.evaluate(ctx, p.config, a, false, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPerformanceBudget(t *testing.T) {
+	perf.Check(t, []perf.Benchmark{
+		{Name: "Attest", F: BenchmarkAttest, Budget: perf.Budget{NsPerOp: 200000}},
+		{Name: "Evaluate", F: BenchmarkEvaluate, Budget: perf.Budget{NsPerOp: 50000}},
+	})
+}
@@ -0,0 +1,94 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	// boot security attributes of instances
+	bootSecurityTrustedCertificates = "trusted_image_certificates"
+	bootSecuritySecureBoot          = "secure_boot"
+	bootSecurityUEFI                = "uefi"
+)
+
+// bootSecurityAttributes are the boot security attributes in the order of their selectors
+var bootSecurityAttributes = []string{bootSecurityTrustedCertificates, bootSecuritySecureBoot, bootSecurityUEFI}
+
+func validateBootSecurityConfig(c *IIDAttestorPluginConfig) error {
+	for _, attr := range c.RequiredBootSecurity {
+		if !contains(bootSecurityAttributes, attr) {
+			return fmt.Errorf("unknown attribute in required_boot_security: %q", attr)
+		}
+	}
+	return nil
+}
+
+// needsBootSecurity returns true if the boot security attributes of instances are checked or surfaced
+func needsBootSecurity(c *IIDAttestorPluginConfig) bool {
+	return len(c.RequiredBootSecurity) > 0 || c.BootSecuritySelectors
+}
+
+// checkBootSecurity denies instances lacking the attributes of required_boot_security, and returns the
+// "boot:<attribute>" selectors of the attributes the instance has if boot_security_selectors is set. The attributes
+// are retrieved once per attestation, for the candidate configuration as well.
+func (p *IIDAttestorPlugin) checkBootSecurity(ctx context.Context, c *IIDAttestorPluginConfig, a *attestation) ([]*spc.Selector, error) {
+	if !needsBootSecurity(c) {
+		return nil, nil
+	}
+	if a.bootSecurity == nil {
+		bs, err := p.bootSecurity.GetBootSecurity(ctx, a.server)
+		if err != nil {
+			return nil, transient(fmt.Errorf("failed to get boot security attributes: %v", err))
+		}
+		a.bootSecurity = bs
+	}
+
+	compliant := bootSecurityCompliance(a.bootSecurity)
+	var missing []string
+	for _, attr := range c.RequiredBootSecurity {
+		if !compliant[attr] {
+			missing = append(missing, attr)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, deny(reasonBootSecurityMissing, fmt.Errorf("instance %v lacks required boot security: %v", a.instanceID, strings.Join(missing, ", ")))
+	}
+
+	if !c.BootSecuritySelectors {
+		return nil, nil
+	}
+	var selectors []*spc.Selector
+	for _, attr := range bootSecurityAttributes {
+		if compliant[attr] {
+			selectors = append(selectors, &spc.Selector{
+				Type:  common.PluginName,
+				Value: fmt.Sprintf("boot:%s", attr),
+			})
+		}
+	}
+	return selectors, nil
+}
+
+// bootSecurityCompliance returns the boot security attributes the instance has. Secure boot is only counted if the
+// image requires it, since Nova boots instances of images where it is optional without it on hosts lacking support.
+func bootSecurityCompliance(bs *openstack.BootSecurity) map[string]bool {
+	return map[string]bool{
+		bootSecurityTrustedCertificates: len(bs.TrustedImageCertificates) > 0,
+		bootSecuritySecureBoot:          bs.SecureBoot == openstack.SecureBootRequired,
+		bootSecurityUEFI:                bs.FirmwareType == openstack.FirmwareTypeUEFI,
+	}
+}
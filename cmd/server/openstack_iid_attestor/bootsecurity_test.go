@@ -0,0 +1,103 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestBootSecurity(t *testing.T) {
+	compliant := &openstack.BootSecurity{
+		TrustedImageCertificates: []string{"cert"},
+		SecureBoot:               openstack.SecureBootRequired,
+		FirmwareType:             openstack.FirmwareTypeUEFI,
+	}
+
+	tCase := []struct {
+		attributes    *openstack.BootSecurity
+		required      []string
+		selectors     bool
+		err           error
+		wantErr       bool
+		wantSelectors []string
+	}{
+		// 0: instance has all the attributes
+		{attributes: compliant, required: bootSecurityAttributes},
+		// 1: image where secure boot is optional
+		{attributes: &openstack.BootSecurity{SecureBoot: "optional"}, required: []string{bootSecuritySecureBoot}, wantErr: true},
+		// 2: instance without attributes
+		{required: []string{bootSecurityTrustedCertificates}, wantErr: true},
+		// 3: selectors of the attributes of the instance
+		{
+			attributes:    &openstack.BootSecurity{FirmwareType: openstack.FirmwareTypeUEFI},
+			selectors:     true,
+			wantSelectors: []string{"boot:uefi"},
+		},
+		// 4: selectors of all the attributes
+		{
+			attributes:    compliant,
+			selectors:     true,
+			wantSelectors: []string{"boot:trusted_image_certificates", "boot:secure_boot", "boot:uefi"},
+		},
+		// 5: lookup failure
+		{required: []string{bootSecurityUEFI}, err: errors.New("unavailable"), wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		if c.err != nil {
+			p.bootSecurity = fake.NewErrorBootSecurity(c.err)
+		} else {
+			p.bootSecurity = fake.NewBootSecurity(map[string]*openstack.BootSecurity{testUUID: c.attributes})
+		}
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequiredBootSecurity = c.required
+		p.config.BootSecuritySelectors = c.selectors
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		stream := fake.NewAttestStream(testUUID)
+		err := p.Attest(stream)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range stream.Response().Selectors {
+			if strings.HasPrefix(s.Value, "boot:") {
+				got = append(got, s.Value)
+			}
+		}
+		if !reflect.DeepEqual(got, c.wantSelectors) {
+			t.Errorf("#%v: got selectors %v, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
+
+func TestValidateBootSecurityConfig(t *testing.T) {
+	c := &IIDAttestorPluginConfig{RequiredBootSecurity: []string{bootSecuritySecureBoot, "measured_boot"}}
+	if err := validateBootSecurityConfig(c); err == nil {
+		t.Errorf("an error expected for unknown attributes, got nil")
+	}
+	c.RequiredBootSecurity = bootSecurityAttributes
+	if err := validateBootSecurityConfig(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
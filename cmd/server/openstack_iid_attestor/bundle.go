@@ -0,0 +1,213 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spiffe/spire/proto/spire/api/registration"
+	spc "github.com/spiffe/spire/proto/spire/common"
+	"google.golang.org/grpc"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+const (
+	defaultRegistrationSocket    = "/tmp/spire-registration.sock"
+	defaultBundleRefreshInterval = time.Minute
+)
+
+// BundleFingerprintConfig configures the verification of the trust bundle fingerprints sent by agents
+type BundleFingerprintConfig struct {
+	// Path of the registration API socket of the SPIRE Server the current trust bundle is fetched from.
+	// Defaults to "/tmp/spire-registration.sock".
+	RegistrationSocket string `hcl:"registration_socket" default:"/tmp/spire-registration.sock"`
+	// Interval of fetching the current trust bundle again, e.g. "1m". Defaults to 1 minute.
+	RefreshInterval string `hcl:"refresh_interval" default:"1m"`
+	refreshInterval time.Duration
+	// If true, agents must send a fingerprint. Otherwise fingerprints are verified if sent, so that agents can enable
+	// bundle_fingerprint one by one.
+	Required bool `hcl:"required"`
+}
+
+var bundleFingerprints = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "bundle_fingerprints_total",
+	Help:      "Number of trust bundle fingerprints sent by agents, by result.",
+}, []string{"result"})
+
+func init() {
+	telemetry.Registry.MustRegister(bundleFingerprints)
+}
+
+func validateBundleFingerprintConfig(c *IIDAttestorPluginConfig) error {
+	bc := c.BundleFingerprint
+	if bc == nil {
+		return nil
+	}
+	if bc.RegistrationSocket == "" {
+		bc.RegistrationSocket = defaultRegistrationSocket
+	}
+	bc.refreshInterval = defaultBundleRefreshInterval
+	if bc.RefreshInterval != "" {
+		d, err := time.ParseDuration(bc.RefreshInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid refresh_interval of bundle_fingerprint: %q", bc.RefreshInterval)
+		}
+		bc.refreshInterval = d
+	}
+	return nil
+}
+
+// bundleSource keeps the fingerprints of the CAs of the current trust bundle, which are fetched from the registration
+// API of the SPIRE Server on first use and again once refresh_interval passed
+type bundleSource struct {
+	logger   hclog.Logger
+	conn     *grpc.ClientConn
+	fetch    func(context.Context) ([]*x509.Certificate, error)
+	interval time.Duration
+	// flight shares a fetch among the attestations needing the bundle at once
+	flight common.Flight
+
+	mtx          sync.Mutex
+	fingerprints map[string]bool
+	fetchedAt    time.Time
+}
+
+func newBundleSource(c *BundleFingerprintConfig, logger hclog.Logger) (*bundleSource, error) {
+	conn, err := grpc.Dial(c.RegistrationSocket, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to registration API: %v", err)
+	}
+	client := registration.NewRegistrationClient(conn)
+	return &bundleSource{
+		logger: logger,
+		conn:   conn,
+		fetch: func(ctx context.Context) ([]*x509.Certificate, error) {
+			resp, err := client.FetchBundle(ctx, &spc.Empty{})
+			if err != nil {
+				return nil, err
+			}
+			var certs []*x509.Certificate
+			for _, ca := range resp.GetBundle().GetRootCas() {
+				cert, err := x509.ParseCertificate(ca.DerBytes)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse CA of the trust bundle: %v", err)
+				}
+				certs = append(certs, cert)
+			}
+			return certs, nil
+		},
+		interval: c.refreshInterval,
+	}, nil
+}
+
+// contains returns true if the current trust bundle holds the CA of the fingerprint. The last bundle fetched is used
+// while the registration API is unavailable, since CAs are added to the bundle well before they sign.
+func (s *bundleSource) contains(ctx context.Context, fingerprint string) (bool, error) {
+	s.mtx.Lock()
+	fingerprints, fetchedAt := s.fingerprints, s.fetchedAt
+	s.mtx.Unlock()
+
+	if fingerprints == nil || time.Since(fetchedAt) >= s.interval {
+		v, _, err := s.flight.Do(ctx, func() (interface{}, error) {
+			return s.refresh(ctx)
+		})
+		switch {
+		case err == nil:
+			fingerprints = v.(map[string]bool)
+		case fingerprints == nil:
+			return false, err
+		default:
+			s.logger.Warn("Using the last trust bundle, which failed to be fetched again", "age", time.Since(fetchedAt).Round(time.Second), "error", err)
+		}
+	}
+	return fingerprints[fingerprint], nil
+}
+
+// refresh fetches the current trust bundle and keeps the fingerprints of its CAs. The lock isn't held during the
+// fetch, and attestations sharing it through flight stop waiting once their context is done.
+func (s *bundleSource) refresh(ctx context.Context) (map[string]bool, error) {
+	certs, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := make(map[string]bool)
+	for _, cert := range certs {
+		fingerprints[vendordata.CertificateFingerprint(cert)] = true
+	}
+
+	s.mtx.Lock()
+	s.fingerprints = fingerprints
+	s.fetchedAt = time.Now()
+	s.mtx.Unlock()
+	return fingerprints, nil
+}
+
+func (s *bundleSource) close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// closeBundleSource closes s unless it is nil
+func closeBundleSource(s *bundleSource) {
+	if s != nil {
+		s.close()
+	}
+}
+
+// checkBundleFingerprint verifies the fingerprint of the trust bundle CA the agent sent from the instance metadata
+// against the current trust bundle if bundle_fingerprint is set, so that agents of instances provisioned with a stale
+// or rogue bundle are denied. Attestations are denied if bundle_fingerprint is set but there is no bundle source.
+func (p *IIDAttestorPlugin) checkBundleFingerprint(ctx context.Context, a *attestation) error {
+	c := p.config.BundleFingerprint
+	if c == nil {
+		return nil
+	}
+	if p.bundles == nil {
+		bundleFingerprints.WithLabelValues("error").Inc()
+		return &transientError{code: reasonRegistrationUnavailable, err: errors.New("no trust bundle source to verify the fingerprint with")}
+	}
+	var fingerprint string
+	if a.payload != nil {
+		fingerprint = a.payload.BundleFingerprint
+	}
+	if fingerprint == "" {
+		if !c.Required {
+			return nil
+		}
+		bundleFingerprints.WithLabelValues("missing").Inc()
+		return deny(reasonBundleFingerprintMissing, fmt.Errorf("agent of instance %v sent no trust bundle fingerprint", a.instanceID))
+	}
+
+	ok, err := p.bundles.contains(ctx, fingerprint)
+	if err != nil {
+		bundleFingerprints.WithLabelValues("error").Inc()
+		return &transientError{code: reasonRegistrationUnavailable, err: fmt.Errorf("failed to fetch trust bundle: %v", err)}
+	}
+	if !ok {
+		bundleFingerprints.WithLabelValues("mismatched").Inc()
+		return deny(reasonBundleFingerprintMismatch, fmt.Errorf("trust bundle fingerprint %v of instance %v is of no CA of the current trust bundle", fingerprint, a.instanceID))
+	}
+	bundleFingerprints.WithLabelValues("matched").Inc()
+	return nil
+}
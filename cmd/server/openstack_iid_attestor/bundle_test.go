@@ -0,0 +1,185 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+func TestAttestBundleFingerprint(t *testing.T) {
+	// Fingerprints are of the DER bytes of CAs alone
+	current := &x509.Certificate{Raw: []byte("current")}
+	sent := func(f string) []byte {
+		b, err := (&common.AttestationPayload{InstanceID: testUUID, BundleFingerprint: f}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tCase := []struct {
+		data      []byte
+		required  bool
+		noSource  bool
+		fetchErr  error
+		wantCode  string
+		transient bool
+	}{
+		// 0: fingerprint of the current CA
+		{data: sent(vendordata.CertificateFingerprint(current))},
+		// 1: fingerprint of another CA
+		{data: sent(strings.Repeat("0a", 32)), wantCode: reasonBundleFingerprintMismatch},
+		// 2: no fingerprint is verified unless required
+		{data: sent("")},
+		// 3: no fingerprint
+		{data: sent(""), required: true, wantCode: reasonBundleFingerprintMissing},
+		// 4: registration API unavailable
+		{data: sent(vendordata.CertificateFingerprint(current)), fetchErr: errors.New("unavailable"), wantCode: reasonRegistrationUnavailable, transient: true},
+		// 5: no bundle source, e.g. after a failed configuration, fails closed
+		{data: sent(vendordata.CertificateFingerprint(current)), noSource: true, wantCode: reasonRegistrationUnavailable, transient: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.BundleFingerprint = &BundleFingerprintConfig{Required: c.required}
+		p.bundles = &bundleSource{
+			logger: testutil.TestLogger(),
+			fetch: func(context.Context) ([]*x509.Certificate, error) {
+				if c.fetchErr != nil {
+					return nil, c.fetchErr
+				}
+				return []*x509.Certificate{current}, nil
+			},
+			interval: time.Minute,
+		}
+		if c.noSource {
+			p.bundles = nil
+		}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		err := p.Attest(fake.NewAttestStreamWithData(c.data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+		if isTransient(err) != c.transient {
+			t.Errorf("#%v: got transient %v, want %v", i, isTransient(err), c.transient)
+		}
+		if isCacheable(err) {
+			t.Errorf("#%v: denial should not be cached: %v", i, err)
+		}
+	}
+}
+
+func TestBundleSourceRefresh(t *testing.T) {
+	first := &x509.Certificate{Raw: []byte("first")}
+	second := &x509.Certificate{Raw: []byte("second")}
+	bundle := []*x509.Certificate{first}
+	var fetchErr error
+	fetched := 0
+	s := &bundleSource{
+		logger: testutil.TestLogger(),
+		fetch: func(context.Context) ([]*x509.Certificate, error) {
+			fetched++
+			return bundle, fetchErr
+		},
+		interval: time.Hour,
+	}
+	ctx := context.Background()
+
+	if ok, err := s.contains(ctx, vendordata.CertificateFingerprint(first)); !ok || err != nil {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	}
+	// The bundle is kept until refresh_interval passes
+	bundle = []*x509.Certificate{first, second}
+	if ok, _ := s.contains(ctx, vendordata.CertificateFingerprint(second)); ok || fetched != 1 {
+		t.Errorf("bundle is fetched again within refresh_interval: %v, %v", ok, fetched)
+	}
+	s.fetchedAt = time.Now().Add(-time.Hour)
+	if ok, _ := s.contains(ctx, vendordata.CertificateFingerprint(second)); !ok || fetched != 2 {
+		t.Errorf("bundle is not refreshed: %v, %v", ok, fetched)
+	}
+	// The last bundle is used while the registration API is unavailable
+	s.fetchedAt = time.Now().Add(-time.Hour)
+	fetchErr = errors.New("unavailable")
+	if ok, err := s.contains(ctx, vendordata.CertificateFingerprint(second)); !ok || err != nil {
+		t.Errorf("unexpected result: %v, %v", ok, err)
+	}
+}
+
+func TestBundleSourceSharedFetch(t *testing.T) {
+	ca := &x509.Certificate{Raw: []byte("ca")}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetched int32
+	s := &bundleSource{
+		logger: testutil.TestLogger(),
+		fetch: func(context.Context) ([]*x509.Certificate, error) {
+			if atomic.AddInt32(&fetched, 1) == 1 {
+				close(started)
+			}
+			<-release
+			return []*x509.Certificate{ca}, nil
+		},
+		interval: time.Hour,
+	}
+
+	result := make(chan bool)
+	go func() {
+		ok, _ := s.contains(context.Background(), vendordata.CertificateFingerprint(ca))
+		result <- ok
+	}()
+	<-started
+
+	// Another attestation waits for the fetch in flight until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.contains(ctx, vendordata.CertificateFingerprint(ca)); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	if ok := <-result; !ok {
+		t.Error("fingerprint of the bundle is not contained")
+	}
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("bundle is fetched %v times, want 1", n)
+	}
+}
+
+func TestValidateBundleFingerprintConfig(t *testing.T) {
+	c := &IIDAttestorPluginConfig{BundleFingerprint: &BundleFingerprintConfig{}}
+	if err := validateBundleFingerprintConfig(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bc := c.BundleFingerprint; bc.RegistrationSocket != defaultRegistrationSocket || bc.refreshInterval != defaultBundleRefreshInterval {
+		t.Errorf("defaults are not applied: %+v", bc)
+	}
+	c.BundleFingerprint.RefreshInterval = "-1m"
+	if err := validateBundleFingerprintConfig(c); err == nil {
+		t.Error("an error expected for a negative refresh_interval")
+	}
+}
@@ -0,0 +1,73 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+var candidateEvaluations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "candidate_evaluations_total",
+	Help:      "Number of attestations evaluated with the candidate configuration, by whether it was enforced and agreed.",
+}, []string{"enforced", "result"})
+
+func init() {
+	telemetry.Registry.MustRegister(candidateEvaluations)
+}
+
+// CanaryConfig enforces the candidate configuration on part of the attestations
+type CanaryConfig struct {
+	// Percentage of instances whose attestations the candidate decides, from 1 to 100. Defaults to 100.
+	Percentage int `hcl:"percentage"`
+	// Time from which the candidate is enforced in RFC 3339, e.g. "2020-04-01T00:00:00Z". Defaults to immediately.
+	Start string `hcl:"start"`
+	start time.Time
+}
+
+func validateCanaryConfig(c *IIDAttestorPluginConfig) error {
+	if c.Canary != nil {
+		return errors.New("canary is an option of the candidate configuration")
+	}
+	if c.Candidate == nil || c.Candidate.Canary == nil {
+		return nil
+	}
+	cc := c.Candidate.Canary
+	if cc.Percentage < 0 || cc.Percentage > 100 {
+		return fmt.Errorf("percentage of canary must be from 1 to 100: %v", cc.Percentage)
+	}
+	if cc.Percentage == 0 {
+		cc.Percentage = 100
+	}
+	if cc.Start != "" {
+		t, err := time.Parse(time.RFC3339, cc.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start of canary: %v", err)
+		}
+		cc.start = t
+	}
+	return nil
+}
+
+// covers returns true if the candidate decides the attestation of the instance at now. Instances are assigned
+// to the canary by a hash of their IDs, so that the same instances are decided by the candidate on every attestation.
+func (c *CanaryConfig) covers(instanceID string, now time.Time) bool {
+	if c == nil || now.Before(c.start) {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(instanceID))
+	return int(h.Sum32()%100) < c.Percentage
+}
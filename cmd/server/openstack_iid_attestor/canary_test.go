@@ -0,0 +1,140 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestCanaryCovers(t *testing.T) {
+	start := time.Date(2020, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	// "bravo" hashes to bucket 7 and "alpha" to bucket 67 of 100
+	tCase := []struct {
+		canary     *CanaryConfig
+		instanceID string
+		now        time.Time
+		want       bool
+	}{
+		// 0: no canary
+		{instanceID: "bravo", now: start},
+		// 1: 0% covers no bucket
+		{canary: &CanaryConfig{}, instanceID: "bravo", now: start},
+		// 2: 100% covers every bucket
+		{canary: &CanaryConfig{Percentage: 100}, instanceID: "alpha", now: start, want: true},
+		// 3: last bucket covered
+		{canary: &CanaryConfig{Percentage: 8}, instanceID: "bravo", now: start, want: true},
+		// 4: first bucket not covered
+		{canary: &CanaryConfig{Percentage: 7}, instanceID: "bravo", now: start},
+		// 5: another instance outside of the same percentage
+		{canary: &CanaryConfig{Percentage: 8}, instanceID: "alpha", now: start},
+		// 6: before the start
+		{canary: &CanaryConfig{Percentage: 100, start: start}, instanceID: "alpha", now: start.Add(-time.Second)},
+		// 7: at the start
+		{canary: &CanaryConfig{Percentage: 100, start: start}, instanceID: "alpha", now: start, want: true},
+		// 8: after the start
+		{canary: &CanaryConfig{Percentage: 100, start: start}, instanceID: "alpha", now: start.Add(time.Hour), want: true},
+	}
+
+	for i, c := range tCase {
+		if got := c.canary.covers(c.instanceID, c.now); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestConfigureCanary(t *testing.T) {
+	tCase := []struct {
+		conf           string
+		wantErr        bool
+		wantPercentage int
+		wantStart      time.Time
+	}{
+		// 0: percentage and start
+		{
+			conf: `candidate {
+				projectid_whitelist = ["alpha"]
+				canary {
+					percentage = 10
+					start = "2020-04-01T09:00:00+09:00"
+				}
+			}`,
+			wantPercentage: 10,
+			wantStart:      time.Date(2020, time.April, 1, 0, 0, 0, 0, time.UTC),
+		},
+		// 1: defaults to 100% immediately
+		{
+			conf: `candidate {
+				projectid_whitelist = ["alpha"]
+				canary {}
+			}`,
+			wantPercentage: 100,
+		},
+		// 2: start not in RFC 3339
+		{
+			conf: `candidate {
+				projectid_whitelist = ["alpha"]
+				canary {
+					start = "2020-04-01 00:00:00"
+				}
+			}`,
+			wantErr: true,
+		},
+		// 3: percentage over 100
+		{
+			conf: `candidate {
+				projectid_whitelist = ["alpha"]
+				canary {
+					percentage = 101
+				}
+			}`,
+			wantErr: true,
+		},
+		// 4: canary outside of the candidate
+		{
+			conf: `canary {
+				percentage = 10
+			}`,
+			wantErr: true,
+		},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+
+		conf := `
+		cloud_name = "test"
+		projectid_whitelist = ["alpha", "bravo"]
+		` + c.conf
+		_, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: expected error from Configure()", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error from Configure(): %v", i, err)
+			continue
+		}
+		cc := p.config.Candidate.Canary
+		if cc.Percentage != c.wantPercentage || !cc.start.Equal(c.wantStart) {
+			t.Errorf("#%v: got canary of %v%% from %v, want %v%% from %v", i, cc.Percentage, cc.start, c.wantPercentage, c.wantStart)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	capabilityCheckWarn    = "warn"
+	capabilityCheckEnforce = "enforce"
+)
+
+// validateCapabilityCheck validates capability_check of the configuration
+func validateCapabilityCheck(c *IIDAttestorPluginConfig) error {
+	switch c.CapabilityCheck {
+	case "", capabilityCheckWarn, capabilityCheckEnforce:
+		return nil
+	default:
+		return fmt.Errorf("unknown capability_check: %v", c.CapabilityCheck)
+	}
+}
+
+// requiredCapabilities returns the capabilities the features enabled by the configuration, or its candidate, need
+func requiredCapabilities(c *IIDAttestorPluginConfig) []string {
+	required := []string{openstack.CapabilityGetServer}
+	if c.needs(func(c *IIDAttestorPluginConfig) bool { return c.DNSZone != "" }) {
+		required = append(required, openstack.CapabilityListDNSZones)
+	}
+	if c.needs(needsPorts) {
+		required = append(required, openstack.CapabilityListPorts)
+	}
+	if c.needs(func(c *IIDAttestorPluginConfig) bool { return len(c.RequiredRoles) > 0 }) {
+		required = append(required, openstack.CapabilityListRoleAssignments)
+	}
+	return required
+}
+
+// checkCapabilities verifies that the credentials of the configuration have the least privilege the enabled features need.
+// Violations fail the configuration in enforce mode and are logged otherwise.
+func (p *IIDAttestorPlugin) checkCapabilities(ctx context.Context, c *IIDAttestorPluginConfig) error {
+	if c.CapabilityCheck == "" {
+		return nil
+	}
+
+	report, err := p.checkCapabilitiesHandler(ctx, c.CloudName, c.Auth, requiredCapabilities(c))
+	if err != nil {
+		if c.CapabilityCheck == capabilityCheckEnforce {
+			return fmt.Errorf("failed to check capabilities: %v", err)
+		}
+		p.logger.Warn("Failed to check capabilities", "cloud", c.CloudName, "error", err)
+		return nil
+	}
+	p.logger.Info("Capabilities of the credentials", "report", report.String())
+
+	violations := report.Violations(c.AdminMode)
+	if len(violations) == 0 {
+		return nil
+	}
+	if c.CapabilityCheck == capabilityCheckEnforce {
+		return fmt.Errorf("credentials violate least privilege: %v", strings.Join(violations, "; "))
+	}
+	for _, v := range violations {
+		p.logger.Warn("Credentials violate least privilege", "cloud", c.CloudName, "violation", v)
+	}
+	return nil
+}
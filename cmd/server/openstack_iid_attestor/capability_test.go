@@ -0,0 +1,104 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestConfigureCapabilityCheck(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		conf      string
+		allowed   map[string]bool
+		wantError string
+	}{
+		{
+			name:    "least privilege",
+			conf:    `capability_check = "enforce"`,
+			allowed: map[string]bool{openstack.CapabilityGetServer: true},
+		},
+		{
+			name:      "missing",
+			conf:      "capability_check = \"enforce\"\ndns_zone = \"example.com.\"",
+			allowed:   map[string]bool{openstack.CapabilityGetServer: true},
+			wantError: "credentials violate least privilege: dns:list_zones is required but not allowed",
+		},
+		{
+			name:      "excess",
+			conf:      `capability_check = "enforce"`,
+			allowed:   map[string]bool{openstack.CapabilityGetServer: true, openstack.CapabilityAdminRole: true},
+			wantError: "credentials violate least privilege: identity:admin_role is allowed but requires admin mode",
+		},
+		{
+			name:    "admin mode",
+			conf:    "capability_check = \"enforce\"\nadmin_mode = true",
+			allowed: map[string]bool{openstack.CapabilityGetServer: true, openstack.CapabilityAdminRole: true},
+		},
+		{
+			name:    "warn",
+			conf:    `capability_check = "warn"`,
+			allowed: map[string]bool{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := newTestPlugin()
+			p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+				return fake.NewInstance(testProjectID, nil, nil), nil
+			}
+			p.getDNSHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.DNSClient, error) {
+				return fake.NewDNS(nil), nil
+			}
+			p.checkCapabilitiesHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, required []string) (*openstack.CapabilityReport, error) {
+				report := &openstack.CapabilityReport{Cloud: n}
+				isRequired := make(map[string]bool)
+				for _, name := range required {
+					isRequired[name] = true
+				}
+				for _, name := range []string{openstack.CapabilityGetServer, openstack.CapabilityListDNSZones, openstack.CapabilityAdminRole} {
+					report.Capabilities = append(report.Capabilities, openstack.Capability{
+						Name:     name,
+						Required: isRequired[name],
+						Admin:    name == openstack.CapabilityAdminRole,
+						Allowed:  c.allowed[name],
+					})
+				}
+				return report, nil
+			}
+
+			req := fake.NewFakeConfigureRequest(globalConfig, pluginConfig+c.conf)
+			_, err := p.Configure(context.Background(), req)
+			switch {
+			case c.wantError == "" && err != nil:
+				t.Errorf("error from Configure(): %v", err)
+			case c.wantError != "" && (err == nil || !strings.HasPrefix(err.Error(), c.wantError)):
+				t.Errorf("got %v, wantPrefix %v", err, c.wantError)
+			}
+		})
+	}
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	c := &IIDAttestorPluginConfig{
+		Candidate: &IIDAttestorPluginConfig{
+			AllowedPortDeviceOwners: []string{"compute:*"},
+		},
+	}
+	expected := []string{openstack.CapabilityGetServer, openstack.CapabilityListPorts}
+	if r := requiredCapabilities(c); !reflect.DeepEqual(r, expected) {
+		t.Errorf("unexpected capabilities: %v", r)
+	}
+}
@@ -0,0 +1,250 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	defaultFailBackInterval = 5 * time.Minute
+
+	// primarySite is the site of the cloud entry, and fallback sites are named "fallback<n>" counted from 1
+	primarySite = "primary"
+
+	// failoverDirection and failBackDirection label switches to a fallback site and back to the primary site
+	failoverDirection = "failover"
+	failBackDirection = "fail_back"
+)
+
+var (
+	cloudUsable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "cloud_usable",
+		Help:      "Whether the client of the cloud was prepared on configuration (1) or not (0), by cloud.",
+	}, []string{"cloud"})
+	cloudActiveSite = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "cloud_active_site",
+		Help:      "Whether lookups of the cloud go to the site (1) or not (0), by cloud and site.",
+	}, []string{"cloud", "site"})
+	cloudFailoversTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "cloud_failovers_total",
+		Help:      "Number of switches of the site lookups of the cloud go to, by cloud and direction.",
+	}, []string{"cloud", "direction"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(cloudUsable, cloudActiveSite, cloudFailoversTotal)
+}
+
+// FailoverConfig configures the fallback sites of a cloud, which lookups fail over to while the cloud entry is
+// unavailable, e.g. the Keystone endpoint of another region of the deployment
+type FailoverConfig struct {
+	// Name of the cloud entry, either cloud_name or one of additional_clouds
+	Cloud string `hcl:",key"`
+	// Keystone endpoints and regions failed over to in order
+	Fallbacks []*openstack.Endpoint `hcl:"fallback"`
+	// Interval of trying the cloud entry again once failed over, e.g. "5m". Defaults to 5 minutes.
+	FailBackInterval string `hcl:"fail_back_interval" default:"5m"`
+	failBackInterval time.Duration
+}
+
+// sites returns the names of the sites of the cloud, the primary one first
+func (f *FailoverConfig) sites() []string {
+	sites := []string{primarySite}
+	for n := range f.Fallbacks {
+		sites = append(sites, fmt.Sprintf("fallback%d", n+1))
+	}
+	return sites
+}
+
+// endpoint returns the endpoint of the site, or nil for the primary site
+func (f *FailoverConfig) endpoint(site string) *openstack.Endpoint {
+	for n, s := range f.sites() {
+		if s == site && n > 0 {
+			return f.Fallbacks[n-1]
+		}
+	}
+	return nil
+}
+
+// clouds returns the clouds instances are looked up in, in order
+func (c *IIDAttestorPluginConfig) clouds() []string {
+	return append([]string{c.CloudName}, c.AdditionalClouds...)
+}
+
+// validateClouds validates additional_clouds, which can't be combined with features bound to a single cloud
+func validateClouds(c *IIDAttestorPluginConfig) error {
+	if len(c.AdditionalClouds) == 0 {
+		return nil
+	}
+	seen := map[string]bool{c.CloudName: true}
+	for _, cloud := range c.AdditionalClouds {
+		if cloud == "" {
+			return errors.New("additional_clouds must not contain empty names")
+		}
+		if seen[cloud] {
+			return fmt.Errorf("duplicate cloud in additional_clouds: %v", cloud)
+		}
+		seen[cloud] = true
+	}
+
+	switch {
+	case c.ProjectScoped:
+		return errors.New("additional_clouds can't be combined with project_scoped")
+	case c.ConsoleBeacon != nil:
+		return errors.New("additional_clouds can't be combined with console_beacon, which reads console logs from cloud_name only")
+	case c.needs(func(c *IIDAttestorPluginConfig) bool { return c.SelectorNamespace == common.SelectorNamespaceCloud }):
+		return errors.New("additional_clouds can't be combined with the cloud selector namespace, which names a single cloud")
+	case c.needs(func(c *IIDAttestorPluginConfig) bool { return c.DNSZone != "" || needsPorts(c) }):
+		return errors.New("additional_clouds can't be combined with dns_zone, allowed_port_device_owners or verify_payload_network, which query cloud_name only")
+	case c.needs(needsBootSecurity):
+		return errors.New("additional_clouds can't be combined with required_boot_security or boot_security_selectors, which query cloud_name only")
+	}
+	return nil
+}
+
+// validateFailover validates failover, which fails over the instance lookups of a cloud. Other clients, e.g. of DNS,
+// always use the cloud entry.
+func validateFailover(c *IIDAttestorPluginConfig) error {
+	if len(c.Failover) == 0 {
+		return nil
+	}
+	if c.ProjectScoped {
+		return errors.New("failover can't be combined with project_scoped")
+	}
+	clouds := make(map[string]bool)
+	for _, cloud := range c.clouds() {
+		clouds[cloud] = true
+	}
+	seen := make(map[string]bool)
+	for _, f := range c.Failover {
+		if !clouds[f.Cloud] {
+			return fmt.Errorf("failover of %q, which is neither cloud_name nor one of additional_clouds", f.Cloud)
+		}
+		if seen[f.Cloud] {
+			return fmt.Errorf("duplicate failover of %q", f.Cloud)
+		}
+		seen[f.Cloud] = true
+		if len(f.Fallbacks) == 0 {
+			return fmt.Errorf("failover of %q requires fallback", f.Cloud)
+		}
+		for _, e := range f.Fallbacks {
+			if e.AuthURL == "" && e.Region == "" {
+				return fmt.Errorf("fallback of %q requires auth_url or region", f.Cloud)
+			}
+		}
+		f.failBackInterval = defaultFailBackInterval
+		if f.FailBackInterval != "" {
+			d, err := time.ParseDuration(f.FailBackInterval)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid fail_back_interval of %q: %q", f.Cloud, f.FailBackInterval)
+			}
+			f.failBackInterval = d
+		}
+	}
+	return nil
+}
+
+// failover returns the failover of the cloud, or nil if it has none
+func (c *IIDAttestorPluginConfig) failover(cloud string) *FailoverConfig {
+	for _, f := range c.Failover {
+		if f.Cloud == cloud {
+			return f
+		}
+	}
+	return nil
+}
+
+// prepareFailoverInstance prepares the instance client of a cloud with fallback sites, whose lookups go to the first
+// available site. Switches of the site are reported by the cloud_active_site and cloud_failovers_total metrics.
+func (p *IIDAttestorPlugin) prepareFailoverInstance(ctx context.Context, config *IIDAttestorPluginConfig, f *FailoverConfig) (openstack.InstanceClient, error) {
+	cloud, auth, timeout := f.Cloud, config.Auth, config.cloudTimeout
+	getInstance, getInstanceAt := p.getInstanceHandler, p.getInstanceAtHandler
+	newClient := func(ctx context.Context, site string) (openstack.InstanceClient, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if e := f.endpoint(site); e != nil {
+			return getInstanceAt(ctx, cloud, auth, e, p.logger)
+		}
+		return getInstance(ctx, cloud, auth, p.logger)
+	}
+	onSwitch := func(from, to string) {
+		direction := failoverDirection
+		if to == primarySite {
+			direction = failBackDirection
+		}
+		cloudFailoversTotal.WithLabelValues(cloud, direction).Inc()
+		cloudActiveSite.WithLabelValues(cloud, from).Set(0)
+		cloudActiveSite.WithLabelValues(cloud, to).Set(1)
+	}
+
+	sites := f.sites()
+	for _, site := range sites {
+		cloudActiveSite.WithLabelValues(cloud, site).Set(0)
+	}
+	ic := openstack.NewFailoverInstance(sites, newClient, timeout, f.failBackInterval, onSwitch, p.logger.With("cloud", cloud))
+	if err := ic.Prepare(ctx); err != nil {
+		return nil, err
+	}
+	cloudActiveSite.WithLabelValues(cloud, ic.Active()).Set(1)
+	return ic, nil
+}
+
+// prepareInstances prepares the instance client of each cloud, each within cloud_timeout, so that a cloud which is
+// down doesn't fail the configuration while another one is usable. The status of each cloud is logged and reported
+// by the cloud_usable metric.
+func (p *IIDAttestorPlugin) prepareInstances(ctx context.Context, config *IIDAttestorPluginConfig) (openstack.InstanceClient, error) {
+	auth, timeout, getInstance := config.Auth, config.cloudTimeout, p.getInstanceHandler
+	newClient := func(ctx context.Context, cloud string) (openstack.InstanceClient, error) {
+		// Each site of a failover is given its own timeout
+		if f := config.failover(cloud); f != nil {
+			return p.prepareFailoverInstance(ctx, config, f)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return getInstance(ctx, cloud, auth, p.logger)
+	}
+
+	cloudUsable.Reset()
+	cloudActiveSite.Reset()
+	clouds := config.clouds()
+	clients := make(map[string]openstack.InstanceClient)
+	var failures []string
+	for _, cloud := range clouds {
+		ic, err := newClient(ctx, cloud)
+		if err != nil {
+			p.logger.Warn("Cloud is unavailable", "cloud", cloud, "error", err)
+			cloudUsable.WithLabelValues(cloud).Set(0)
+			failures = append(failures, fmt.Sprintf("%v: %v", cloud, err))
+			continue
+		}
+		p.logger.Info("Cloud is usable", "cloud", cloud)
+		cloudUsable.WithLabelValues(cloud).Set(1)
+		clients[cloud] = ic
+	}
+	if len(clients) == 0 || (config.RequireAllClouds && len(failures) > 0) {
+		return nil, fmt.Errorf("failed to prepare OpenStack Client: %v", strings.Join(failures, "; "))
+	}
+	if len(clouds) == 1 {
+		return clients[config.CloudName], nil
+	}
+	return openstack.NewMultiCloudInstance(clouds, clients, newClient, p.logger), nil
+}
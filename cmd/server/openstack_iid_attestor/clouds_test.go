@@ -0,0 +1,200 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestConfigureAdditionalClouds(t *testing.T) {
+	tCase := []struct {
+		down       map[string]bool
+		requireAll bool
+		wantErr    bool
+	}{
+		// 0: all clouds are usable
+		{},
+		// 1: one usable cloud is enough
+		{down: map[string]bool{"test": true}},
+		// 2: all clouds are required
+		{down: map[string]bool{"west": true}, requireAll: true, wantErr: true},
+		// 3: no cloud is usable
+		{down: map[string]bool{"test": true, "west": true}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+			if c.down[n] {
+				return nil, errors.New("keystone is down")
+			}
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		conf := `
+		cloud_name = "test"
+		projectid_whitelist = ["` + testProjectID + `"]
+		additional_clouds = ["west"]
+		cloud_timeout = "1s"
+		`
+		if c.requireAll {
+			conf += "require_all_clouds = true\n"
+		}
+		_, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: error from Configure(): %v", i, err)
+			continue
+		}
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Errorf("#%v: attestation error: %v", i, err)
+		}
+	}
+}
+
+func TestValidateClouds(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: a single cloud
+		{config: &IIDAttestorPluginConfig{CloudName: "test"}},
+		// 1: additional clouds
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"west", "north"}}},
+		// 2: duplicate of cloud_name
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"test"}}, wantErr: true},
+		// 3: project scoped tokens are of cloud_name
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"west"}, ProjectScoped: true}, wantErr: true},
+		// 4: the cloud selector namespace names cloud_name
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"west"}, SelectorNamespace: "cloud"}, wantErr: true},
+		// 5: regional services of the candidate
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"west"}, Candidate: &IIDAttestorPluginConfig{DNSZone: "example.com."}}, wantErr: true},
+	} {
+		err := validateClouds(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestConfigureFailover(t *testing.T) {
+	tCase := []struct {
+		primaryDown bool
+		fallbackErr bool
+		wantErr     bool
+	}{
+		// 0: the primary site is usable
+		{},
+		// 1: failover to the fallback site on configuration
+		{primaryDown: true},
+		// 2: no site is usable
+		{primaryDown: true, fallbackErr: true, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		var endpoints []*openstack.Endpoint
+		p := newTestPlugin()
+		p.getInstanceHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error) {
+			if c.primaryDown {
+				return nil, errors.New("keystone is down")
+			}
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+		p.getInstanceAtHandler = func(_ context.Context, _ string, _ *openstack.AuthConfig, e *openstack.Endpoint, _ hclog.Logger) (openstack.InstanceClient, error) {
+			endpoints = append(endpoints, e)
+			if c.fallbackErr {
+				return nil, errors.New("keystone is down")
+			}
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		conf := `
+		cloud_name = "test"
+		projectid_whitelist = ["` + testProjectID + `"]
+		cloud_timeout = "1s"
+		failover "test" {
+			fallback {
+				auth_url = "https://keystone.west.example.com/v3"
+				region = "west"
+			}
+		}
+		`
+		_, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: error from Configure(): %v", i, err)
+			continue
+		}
+		if c.primaryDown && (len(endpoints) != 1 || endpoints[0].Region != "west") {
+			t.Errorf("#%v: got fallback endpoints %v, want the one of west", i, endpoints)
+		}
+		if !c.primaryDown && len(endpoints) > 0 {
+			t.Errorf("#%v: fallback prepared while the primary site is usable", i)
+		}
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Errorf("#%v: attestation error: %v", i, err)
+		}
+	}
+}
+
+func TestValidateFailover(t *testing.T) {
+	west := &openstack.Endpoint{Region: "west"}
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: no failover
+		{config: &IIDAttestorPluginConfig{CloudName: "test"}},
+		// 1: failover of cloud_name
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "test", Fallbacks: []*openstack.Endpoint{west}}}}},
+		// 2: failover of an additional cloud
+		{config: &IIDAttestorPluginConfig{CloudName: "test", AdditionalClouds: []string{"north"}, Failover: []*FailoverConfig{{Cloud: "north", Fallbacks: []*openstack.Endpoint{west}}}}},
+		// 3: unknown cloud
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "north", Fallbacks: []*openstack.Endpoint{west}}}}, wantErr: true},
+		// 4: duplicate failover
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "test", Fallbacks: []*openstack.Endpoint{west}}, {Cloud: "test", Fallbacks: []*openstack.Endpoint{west}}}}, wantErr: true},
+		// 5: no fallback
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "test"}}}, wantErr: true},
+		// 6: fallback overriding nothing
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "test", Fallbacks: []*openstack.Endpoint{{}}}}}, wantErr: true},
+		// 7: invalid fail_back_interval
+		{config: &IIDAttestorPluginConfig{CloudName: "test", Failover: []*FailoverConfig{{Cloud: "test", Fallbacks: []*openstack.Endpoint{west}, FailBackInterval: "0s"}}}, wantErr: true},
+		// 8: project scoped tokens are of the cloud entry
+		{config: &IIDAttestorPluginConfig{CloudName: "test", ProjectScoped: true, Failover: []*FailoverConfig{{Cloud: "test", Fallbacks: []*openstack.Endpoint{west}}}}, wantErr: true},
+	} {
+		err := validateFailover(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
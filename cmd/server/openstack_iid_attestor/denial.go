@@ -0,0 +1,129 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+)
+
+const denialKeyPrefix = "denial:"
+
+// transientError is a failure which doesn't tell anything about the instance, e.g. an OpenStack API outage.
+// Transient failures are not cached as denials.
+type transientError struct {
+	code string
+	err  error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// GRPCStatus returns the status sent to the agent, which may retry later
+func (e *transientError) GRPCStatus() *status.Status {
+	return reasonStatus(codes.Unavailable, e.code, e.err.Error())
+}
+
+// transient marks err as a failure of OpenStack APIs
+func transient(err error) error {
+	return &transientError{code: reasonOpenStackUnavailable, err: err}
+}
+
+func isTransient(err error) bool {
+	_, ok := err.(*transientError)
+	return ok
+}
+
+// isCacheable returns true if err is a denial of the instance itself. Transient failures, backpressure, states the
+// instance passes through, e.g. a reboot, and denials of what the agent sent, e.g. an invalid signed identity, must
+// not deny the instance to other agents.
+func isCacheable(err error) bool {
+	if _, ok := err.(*backpressureError); ok || isTransient(err) {
+		return false
+	}
+	switch reasonCode(err) {
+	case reasonSignedIdentityMissing, reasonSignedIdentityInvalid, reasonHMACChallengeFailed, reasonConsoleBeaconFailed,
+		reasonVersionNegotiationFailed, reasonReattestRequired, reasonAssuranceTooLow, reasonObserveOnly,
+		reasonInstanceStateNotAllowed, reasonBundleFingerprintMissing, reasonBundleFingerprintMismatch,
+		reasonPayloadHMACReplayed:
+		return false
+	}
+	return true
+}
+
+// denialCache remembers recent denials per instance, so that agents retrying in a loop are rejected
+// consistently without querying OpenStack again. Keys include a digest of the configuration, which
+// invalidates all denials immediately when the configuration changes.
+type denialCache struct {
+	logger     hclog.Logger
+	cache      cache.Cache
+	ttl        time.Duration
+	generation string
+}
+
+func newDenialCache(c cache.Cache, ttl time.Duration, configuration string, logger hclog.Logger) *denialCache {
+	sum := sha256.Sum256([]byte(configuration))
+	return &denialCache{
+		logger:     logger,
+		cache:      c,
+		ttl:        ttl,
+		generation: hex.EncodeToString(sum[:8]),
+	}
+}
+
+// cachedDenial is the serialized form of a denial in the cache
+type cachedDenial struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// lookup returns the cached denial of the instance, if any, with its original reason code
+func (d *denialCache) lookup(instanceID string) (error, bool) {
+	v, ok, err := d.cache.Get(d.key(instanceID))
+	if err != nil {
+		d.logger.Warn("Failed to lookup denial cache", "instance_id", instanceID, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var cd cachedDenial
+	if err := json.Unmarshal(v, &cd); err != nil {
+		d.logger.Warn("Failed to decode cached denial", "instance_id", instanceID, "error", err)
+		return nil, false
+	}
+	return deny(cd.Code, errors.New(cd.Reason)), true
+}
+
+func (d *denialCache) store(instanceID string, denial error) {
+	v, err := json.Marshal(&cachedDenial{
+		Code:   reasonCode(denial),
+		Reason: denial.Error(),
+	})
+	if err != nil {
+		d.logger.Warn("Failed to encode denial", "instance_id", instanceID, "error", err)
+		return
+	}
+	if err := d.cache.Set(d.key(instanceID), v, d.ttl); err != nil {
+		d.logger.Warn("Failed to store denial cache", "instance_id", instanceID, "error", err)
+	}
+}
+
+func (d *denialCache) key(instanceID string) string {
+	return denialKeyPrefix + d.generation + ":" + instanceID
+}
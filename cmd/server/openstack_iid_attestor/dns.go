@@ -0,0 +1,95 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// regexpHostname matches a DNS label usable as a hostname (RFC 1123)
+var regexpHostname = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// hostnameSelectors returns the "hostname:<name>" selector if hostname hints are enabled and the Nova name
+// of the instance is a valid hostname. Names which aren't, e.g. containing spaces, or violate
+// selector_sanitization are skipped. The name is chosen by the tenant, so the selector must be given only once
+// checkDNS verified the name in Designate.
+func hostnameSelectors(c *IIDAttestorPluginConfig, s *openstack.Server) []*spc.Selector {
+	if !c.HostnameHints {
+		return nil
+	}
+	name := strings.ToLower(s.Name)
+	if !regexpHostname.MatchString(name) {
+		return nil
+	}
+	name, ok := c.SelectorSanitization.Sanitize(name)
+	if !ok {
+		return nil
+	}
+	return []*spc.Selector{
+		{
+			Type:  common.PluginName,
+			Value: fmt.Sprintf("hostname:%s", name),
+		},
+	}
+}
+
+// checkDNS verifies that the DNS record of the instance in Designate resolves to one of its fixed IPs.
+// It returns the "dns:<fqdn>" selector for the verified record, unless the name violates selector_sanitization,
+// and the hostname selector of the verified name if hostname_hints is set.
+func (p *IIDAttestorPlugin) checkDNS(ctx context.Context, c *IIDAttestorPluginConfig, a *attestation) ([]*spc.Selector, error) {
+	if c.DNSZone == "" {
+		return nil, nil
+	}
+	s := a.server
+
+	zone := dnsName(c.DNSZone)
+	fqdn := dnsName(fmt.Sprintf("%s.%s", s.Name, zone))
+
+	addrs, err := p.dns.LookupAddresses(ctx, zone, fqdn)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to lookup DNS record %v: %v", fqdn, err))
+	}
+
+	fixedIPs := openstack.FixedIPs(s)
+	for _, addr := range addrs {
+		if !contains(fixedIPs, addr) {
+			continue
+		}
+		hostname := hostnameSelectors(c, s)
+		name, ok := c.SelectorSanitization.Sanitize(strings.TrimSuffix(fqdn, "."))
+		if !ok {
+			a.logger.Debug("Dropping DNS selector violating selector_sanitization", "fqdn", fqdn)
+			return hostname, nil
+		}
+		return append([]*spc.Selector{
+			{
+				Type:  common.PluginName,
+				Value: fmt.Sprintf("dns:%s", name),
+			},
+		}, hostname...), nil
+	}
+
+	return nil, deny(reasonDNSMismatch, fmt.Errorf("DNS record %v doesn't resolve to fixed IPs of the instance", fqdn))
+}
+
+// dnsName returns the lower-cased, fully qualified form of the name
+func dnsName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
@@ -0,0 +1,100 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// PayloadEncryptionKeyConfig is a private key agents encrypt attestation data to
+type PayloadEncryptionKeyConfig struct {
+	KeyID string `hcl:",key"`
+	// Path to the PEM encoded PKCS #8 RSA private key
+	PrivateKeyFile string `hcl:"private_key_file"`
+}
+
+// payloadEncryptionKeys are the private keys decrypting attestation data by key ID
+type payloadEncryptionKeys map[string]*rsa.PrivateKey
+
+var payloadDecryptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "payload_decryptions_total",
+	Help:      "Number of attestation data received, by result of decryption.",
+}, []string{"result"})
+
+func init() {
+	telemetry.Registry.MustRegister(payloadDecryptions)
+}
+
+// newPayloadEncryptionKeys loads the configured private keys, or returns nil if none is configured
+func newPayloadEncryptionKeys(c *IIDAttestorPluginConfig) (payloadEncryptionKeys, error) {
+	if len(c.PayloadEncryptionKeys) == 0 {
+		if c.RequirePayloadEncryption {
+			return nil, errors.New("require_payload_encryption requires at least one payload_encryption_key")
+		}
+		return nil, nil
+	}
+
+	keys := make(payloadEncryptionKeys)
+	for _, kc := range c.PayloadEncryptionKeys {
+		if kc.KeyID == "" {
+			return nil, errors.New("payload_encryption_key requires a key ID")
+		}
+		if _, ok := keys[kc.KeyID]; ok {
+			return nil, fmt.Errorf("duplicate payload_encryption_key: %v", kc.KeyID)
+		}
+		if kc.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("payload_encryption_key %v requires private_key_file", kc.KeyID)
+		}
+		signer, err := vendordata.LoadPrivateKey(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload_encryption_key %v: %v", kc.KeyID, err)
+		}
+		key, ok := signer.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("payload_encryption_key %v is not an RSA key: %T", kc.KeyID, signer)
+		}
+		keys[kc.KeyID] = key
+	}
+	return keys, nil
+}
+
+// decryptPayload returns the attestation data decrypted with the key it is encrypted to. Plaintext is returned as is
+// unless require_payload_encryption is set.
+func (p *IIDAttestorPlugin) decryptPayload(data []byte) ([]byte, error) {
+	if !common.IsEncryptedPayload(data) {
+		if p.config.RequirePayloadEncryption {
+			payloadDecryptions.WithLabelValues("plaintext").Inc()
+			return nil, deny(reasonPayloadDecryptionFailed, errors.New("attestation data is not encrypted, which require_payload_encryption requires"))
+		}
+		return data, nil
+	}
+
+	plaintext, err := common.DecryptPayload(data, func(keyID string) *rsa.PrivateKey {
+		return p.encryptionKeys[keyID]
+	})
+	switch {
+	case err == common.ErrUnknownPayloadKey:
+		keyID, _ := common.PayloadKeyID(data)
+		payloadDecryptions.WithLabelValues("unknown_key").Inc()
+		return nil, deny(reasonPayloadDecryptionFailed, fmt.Errorf("attestation data is encrypted to an unknown key: %q", keyID))
+	case err != nil:
+		payloadDecryptions.WithLabelValues("failed").Inc()
+		return nil, deny(reasonPayloadDecryptionFailed, err)
+	}
+	payloadDecryptions.WithLabelValues("decrypted").Inc()
+	return plaintext, nil
+}
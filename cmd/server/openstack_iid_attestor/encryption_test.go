@@ -0,0 +1,134 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestPayloadEncryption(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: testProjectID}
+	plaintext, err := payload.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(keyID string, pub *rsa.PublicKey) []byte {
+		data, err := common.EncryptPayload(plaintext, keyID, pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tCase := []struct {
+		data     []byte
+		require  bool
+		wantCode string
+	}{
+		// 0: encrypted to a configured key
+		{data: encrypt("2020-01", &key.PublicKey), require: true},
+		// 1: plaintext from agents not encrypting yet
+		{data: plaintext},
+		// 2: plaintext while required
+		{data: plaintext, require: true, wantCode: reasonPayloadDecryptionFailed},
+		// 3: encrypted to an unknown key
+		{data: encrypt("2019-01", &key.PublicKey), wantCode: reasonPayloadDecryptionFailed},
+		// 4: encrypted to another key under the ID of a configured one
+		{data: encrypt("2020-01", &other.PublicKey), wantCode: reasonPayloadDecryptionFailed},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequirePayloadEncryption = c.require
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.encryptionKeys = payloadEncryptionKeys{"2020-01": key}
+
+		err := p.Attest(fake.NewAttestStreamWithData(c.data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
+
+func TestConfigurePayloadEncryptionKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "2020-01.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: none
+		{config: &IIDAttestorPluginConfig{}},
+		// 1: a key
+		{config: &IIDAttestorPluginConfig{PayloadEncryptionKeys: []*PayloadEncryptionKeyConfig{{KeyID: "2020-01", PrivateKeyFile: path}}, RequirePayloadEncryption: true}},
+		// 2: required without keys
+		{config: &IIDAttestorPluginConfig{RequirePayloadEncryption: true}, wantErr: true},
+		// 3: duplicate key
+		{config: &IIDAttestorPluginConfig{PayloadEncryptionKeys: []*PayloadEncryptionKeyConfig{{KeyID: "2020-01", PrivateKeyFile: path}, {KeyID: "2020-01", PrivateKeyFile: path}}}, wantErr: true},
+		// 4: missing file
+		{config: &IIDAttestorPluginConfig{PayloadEncryptionKeys: []*PayloadEncryptionKeyConfig{{KeyID: "2020-01", PrivateKeyFile: filepath.Join(dir, "missing.key")}}}, wantErr: true},
+	} {
+		keys, err := newPayloadEncryptionKeys(c.config)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if len(keys) != len(c.config.PayloadEncryptionKeys) {
+			t.Errorf("#%v: got %v keys, want %v", i, len(keys), len(c.config.PayloadEncryptionKeys))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	enrichmentModeDeny   = "deny"
+	enrichmentModeReduce = "reduce"
+	enrichmentModeWarn   = "warn"
+
+	// services enriching the Nova server document
+	enrichmentDesignate = "designate"
+	enrichmentNeutron   = "neutron"
+	enrichmentKeystone  = "keystone"
+)
+
+// enrichmentServices are the services enriching the Nova server document, in the order their failures are handled
+var enrichmentServices = []string{enrichmentDesignate, enrichmentNeutron, enrichmentKeystone}
+
+var (
+	enrichmentDegraded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "enrichment_degraded_total",
+		Help:      "Number of attestations admitted without the enrichment of an unavailable service.",
+	}, []string{"service"})
+	enrichmentDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "enrichment_duration_seconds",
+		Help:      "Duration of the queries of the enrichment services.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(enrichmentDegraded, enrichmentDuration)
+}
+
+func validateEnrichmentConfig(c *IIDAttestorPluginConfig) error {
+	switch c.EnrichmentFailureMode {
+	case "":
+		c.EnrichmentFailureMode = enrichmentModeDeny
+	case enrichmentModeDeny, enrichmentModeReduce, enrichmentModeWarn:
+	default:
+		return fmt.Errorf("unknown enrichment_failure_mode: %q", c.EnrichmentFailureMode)
+	}
+
+	c.enrichmentTimeouts = make(map[string]time.Duration)
+	for service, value := range c.EnrichmentTimeouts {
+		if !contains(enrichmentServices, service) {
+			return fmt.Errorf("unknown service in enrichment_timeouts: %q", service)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid enrichment_timeouts of %v: %q", service, value)
+		}
+		c.enrichmentTimeouts[service] = d
+	}
+	return nil
+}
+
+// enrich queries the enrichment services concurrently, each within its deadline of enrichment_timeouts, and returns
+// their selectors by service. Failures are handled in the order of enrichmentServices once all services answered,
+// so that the decision doesn't depend on which one answered first. degraded is true if a failure was tolerated.
+func (p *IIDAttestorPlugin) enrich(ctx context.Context, c *IIDAttestorPluginConfig, a *attestation, enforce bool) (map[string][]*spc.Selector, bool, error) {
+	s := a.server
+	queries := map[string]func(context.Context) ([]*spc.Selector, error){
+		enrichmentDesignate: func(ctx context.Context) ([]*spc.Selector, error) {
+			return p.checkDNS(ctx, c, a)
+		},
+		enrichmentNeutron: func(ctx context.Context) ([]*spc.Selector, error) {
+			return p.checkPorts(ctx, c, a)
+		},
+		enrichmentKeystone: func(ctx context.Context) ([]*spc.Selector, error) {
+			return nil, p.checkRoles(ctx, c, s)
+		},
+	}
+
+	results := make([][]*spc.Selector, len(enrichmentServices))
+	errs := make([]error, len(enrichmentServices))
+	var wg sync.WaitGroup
+	for i, service := range enrichmentServices {
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			ctx := ctx
+			if d, ok := c.enrichmentTimeouts[service]; ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, d)
+				defer cancel()
+			}
+			start := time.Now()
+			results[i], errs[i] = queries[service](ctx)
+			enrichmentDuration.WithLabelValues(service).Observe(time.Since(start).Seconds())
+		}(i, service)
+	}
+	wg.Wait()
+
+	enriched := make(map[string][]*spc.Selector)
+	var degraded bool
+	for i, service := range enrichmentServices {
+		if err := errs[i]; err != nil {
+			if !p.degrade(c, a, service, err, enforce) {
+				return nil, false, err
+			}
+			degraded = true
+			continue
+		}
+		enriched[service] = results[i]
+	}
+	return enriched, degraded, nil
+}
+
+// degrade returns true if the attestation may go on without the enrichment of the service which failed with err.
+// Only failures of the service itself are tolerated; denials based on its answer are never.
+func (p *IIDAttestorPlugin) degrade(c *IIDAttestorPluginConfig, a *attestation, service string, err error, enforce bool) bool {
+	tolerated := c.EnrichmentFailureMode == enrichmentModeReduce || c.EnrichmentFailureMode == enrichmentModeWarn
+	if !tolerated || !isTransient(err) {
+		return false
+	}
+	if enforce {
+		a.logger.Warn("Skipping enrichment of unavailable service", "instance_id", a.instanceID, "service", service, "error", err)
+		enrichmentDegraded.WithLabelValues(service).Inc()
+		a.degraded = append(a.degraded, service)
+	}
+	return true
+}
+
+// degradedSelectors returns the "enrichment:degraded" selector in warn mode
+func degradedSelectors(c *IIDAttestorPluginConfig) []*spc.Selector {
+	if c.EnrichmentFailureMode != enrichmentModeWarn {
+		return nil
+	}
+	return []*spc.Selector{
+		{
+			Type:  common.PluginName,
+			Value: "enrichment:degraded",
+		},
+	}
+}
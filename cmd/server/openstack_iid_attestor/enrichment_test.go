@@ -0,0 +1,172 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestEnrichmentFailure(t *testing.T) {
+	addresses := map[string]interface{}{
+		"private": []interface{}{
+			map[string]interface{}{
+				"addr":            "10.0.0.5",
+				"OS-EXT-IPS:type": "fixed",
+			},
+		},
+	}
+
+	tCase := []struct {
+		mode      string
+		records   map[string][]string
+		wantCode  string
+		selectors []string
+	}{
+		// 0: Designate failures fail the attestation by default
+		{mode: enrichmentModeDeny, wantCode: reasonOpenStackUnavailable},
+		// 1: admitted without the DNS selector
+		{mode: enrichmentModeReduce},
+		// 2: admitted with the degraded selector
+		{mode: enrichmentModeWarn, selectors: []string{"enrichment:degraded"}},
+		// 3: denials based on the answer of Designate are kept
+		{mode: enrichmentModeWarn, records: map[string][]string{"bravo.example.com.": {"10.0.0.6"}}, wantCode: reasonDNSMismatch},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithAddresses(testProjectID, addresses)
+		p.dns = fake.NewDNS(c.records)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.DNSZone = "example.com"
+		p.config.EnrichmentFailureMode = c.mode
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStream(testUUID)
+		err := p.Attest(fs)
+		if c.wantCode != "" {
+			if code := reasonCode(err); code != c.wantCode {
+				t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if len(got) != len(c.selectors) || (len(got) > 0 && got[0] != c.selectors[0]) {
+			t.Errorf("#%v: got selectors %v, want %v", i, got, c.selectors)
+		}
+	}
+}
+
+func TestAttestEnrichmentTimeout(t *testing.T) {
+	addresses := map[string]interface{}{
+		"private": []interface{}{
+			map[string]interface{}{
+				"addr":            "10.0.0.5",
+				"OS-EXT-IPS:type": "fixed",
+			},
+		},
+	}
+	const neutronDelay = time.Second
+
+	tCase := []struct {
+		mode      string
+		wantCode  string
+		selectors []string
+	}{
+		// 0: admitted with the selectors of the services which answered in time
+		{mode: enrichmentModeReduce, selectors: []string{"dns:bravo.example.com"}},
+		// 1: timeouts fail the attestation by default
+		{mode: enrichmentModeDeny, wantCode: reasonOpenStackUnavailable},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithAddresses(testProjectID, addresses)
+		p.dns = fake.NewDNS(map[string][]string{"bravo.example.com.": {"10.0.0.5"}})
+		p.network = fake.NewSlowNetwork([]ports.Port{{ID: "port1", DeviceOwner: "compute:nova"}}, neutronDelay)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.DNSZone = "example.com"
+		p.config.AllowedPortDeviceOwners = []string{"compute:*"}
+		p.config.EnrichmentFailureMode = c.mode
+		p.config.enrichmentTimeouts = map[string]time.Duration{enrichmentNeutron: 20 * time.Millisecond}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		start := time.Now()
+		fs := fake.NewAttestStream(testUUID)
+		err := p.Attest(fs)
+		if elapsed := time.Since(start); elapsed >= neutronDelay {
+			t.Errorf("#%v: attestation took %v, beyond the timeout of neutron", i, elapsed)
+		}
+		if c.wantCode != "" {
+			if code := reasonCode(err); code != c.wantCode {
+				t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.selectors) {
+			t.Errorf("#%v: got selectors %v, want %v", i, got, c.selectors)
+		}
+	}
+}
+
+func TestValidateEnrichmentConfig(t *testing.T) {
+	c := &IIDAttestorPluginConfig{}
+	if err := validateEnrichmentConfig(c); err != nil || c.EnrichmentFailureMode != enrichmentModeDeny {
+		t.Errorf("unexpected default: %v, %v", c.EnrichmentFailureMode, err)
+	}
+	c.EnrichmentFailureMode = "ignore"
+	if err := validateEnrichmentConfig(c); err == nil {
+		t.Error("an error expected, got nil")
+	}
+
+	for i, tc := range []struct {
+		timeouts map[string]string
+		wantErr  bool
+	}{
+		// 0: timeouts of services
+		{timeouts: map[string]string{"designate": "2s", "neutron": "5s"}},
+		// 1: unknown service
+		{timeouts: map[string]string{"glance": "2s"}, wantErr: true},
+		// 2: invalid duration
+		{timeouts: map[string]string{"keystone": "0s"}, wantErr: true},
+	} {
+		c := &IIDAttestorPluginConfig{EnrichmentTimeouts: tc.timeouts}
+		err := validateEnrichmentConfig(c)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		} else if len(c.enrichmentTimeouts) != len(tc.timeouts) {
+			t.Errorf("#%v: got timeouts %v", i, c.enrichmentTimeouts)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+)
+
+// newDecision returns the decision of the attestation, which failed with err if not nil
+func newDecision(a *attestation, err error) *hooks.Decision {
+	d := &hooks.Decision{
+		AttestationID: a.id,
+		InstanceID:    a.instanceID,
+		AgentID:       a.agentID,
+		Admitted:      err == nil,
+		Changes:       a.changes,
+		Degraded:      a.degraded,
+	}
+	if a.server != nil {
+		d.ProjectID = a.server.TenantID
+	}
+	for _, s := range a.selectors {
+		d.Selectors = append(d.Selectors, s.Value)
+	}
+
+	if err != nil {
+		d.Reason = err.Error()
+		d.ReasonCode = reasonCode(err)
+	}
+	return d
+}
+
+// notifyDecision passes the attestation decision to the configured hooks
+func (p *IIDAttestorPlugin) notifyDecision(a *attestation, err error) {
+	if len(p.hooks) == 0 {
+		return
+	}
+
+	d := newDecision(a, err)
+	d.Observed = p.config.ObserveOnly
+	if err == nil {
+		p.hooks.OnAttested(d)
+		return
+	}
+	p.hooks.OnDenied(d)
+
+	// Only denials of the instance itself evict an agent, not failures which may pass on retry or observed denials.
+	// A refused re-attestation leaves the agent with the identity it holds, unless the instance requires a new agent.
+	if !d.Observed && a.attestedAgentID != "" && (isCacheable(err) || d.ReasonCode == reasonReattestRequired) && d.ReasonCode != reasonAlreadyAttested {
+		d.AgentID = a.attestedAgentID
+		p.hooks.OnEvicted(d)
+	}
+}
@@ -0,0 +1,186 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+// recordingHook records the names of the notifications it received
+type recordingHook struct {
+	calls     []string
+	decisions []*hooks.Decision
+	closed    bool
+}
+
+func (h *recordingHook) OnAttested(d *hooks.Decision) {
+	h.calls = append(h.calls, "attested:"+d.AgentID)
+	h.decisions = append(h.decisions, d)
+}
+func (h *recordingHook) OnDenied(d *hooks.Decision) {
+	h.calls = append(h.calls, "denied:"+d.ReasonCode)
+	h.decisions = append(h.decisions, d)
+}
+func (h *recordingHook) OnEvicted(d *hooks.Decision) { h.calls = append(h.calls, "evicted:"+d.AgentID) }
+func (h *recordingHook) Close()                      { h.closed = true }
+
+func TestAttestHooks(t *testing.T) {
+	agentID := "spiffe://example.com/spire/agent/openstack_iid/" + testProjectID + "/" + testUUID
+	datastoreDown := func(p *IIDAttestorPlugin, ctx context.Context, agentID string) (bool, error) {
+		return false, errors.New("datastore is down")
+	}
+
+	tCase := []struct {
+		whitelist      []string
+		attestedBefore func(p *IIDAttestorPlugin, ctx context.Context, agentID string) (bool, error)
+		canReattest    bool
+		want           []string
+	}{
+		// 0: admitted
+		{whitelist: []string{testProjectID}, attestedBefore: notAttestedBeforeHandler, canReattest: true, want: []string{"attested:" + agentID}},
+		// 1: denied on first attestation
+		{whitelist: []string{"xyz"}, attestedBefore: notAttestedBeforeHandler, canReattest: true, want: []string{"denied:" + reasonProjectNotAllowed}},
+		// 2: denied on re-attestation, which evicts the agent
+		{whitelist: []string{"xyz"}, attestedBefore: onceAttestedBeforeHandler, canReattest: true, want: []string{"denied:" + reasonProjectNotAllowed, "evicted:" + agentID}},
+		// 3: transient failures don't evict agents
+		{whitelist: []string{"xyz"}, attestedBefore: datastoreDown, canReattest: true, want: []string{"denied:" + reasonDatastoreUnavailable}},
+		// 4: refused re-attestation
+		{whitelist: []string{testProjectID}, attestedBefore: onceAttestedBeforeHandler, canReattest: false, want: []string{"denied:" + reasonAlreadyAttested}},
+	}
+
+	for i, c := range tCase {
+		h := &recordingHook{}
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = c.whitelist
+		p.config.CanReattest = c.canReattest
+		p.attestedBeforeHandler = c.attestedBefore
+		p.hooks = hooks.Hooks{h}
+
+		p.Attest(fake.NewAttestStream(testUUID))
+		if len(h.calls) != len(c.want) {
+			t.Errorf("#%v: got %v, want %v", i, h.calls, c.want)
+			continue
+		}
+		for j := range c.want {
+			if h.calls[j] != c.want[j] {
+				t.Errorf("#%v: got %v, want %v", i, h.calls, c.want)
+				break
+			}
+		}
+	}
+}
+
+// requestIDInstance records the global request IDs of the lookups
+type requestIDInstance struct {
+	openstack.InstanceClient
+	requestIDs []string
+}
+
+func (i *requestIDInstance) Get(ctx context.Context, uuid string) (*openstack.Server, error) {
+	i.requestIDs = append(i.requestIDs, openstack.RequestID(ctx))
+	return i.InstanceClient.Get(ctx, uuid)
+}
+
+func TestAttestationID(t *testing.T) {
+	h := &recordingHook{}
+	instance := &requestIDInstance{InstanceClient: fake.NewInstance(testProjectID, nil, nil)}
+	p := newTestPlugin()
+	p.instance = instance
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.hooks = hooks.Hooks{h}
+
+	for i := 0; i < 2; i++ {
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Fatalf("#%v: unexpected error: %v", i, err)
+		}
+	}
+	if len(h.decisions) != 2 || len(instance.requestIDs) != 2 {
+		t.Fatalf("unexpected decisions %v and lookups %v", h.calls, instance.requestIDs)
+	}
+	for i, d := range h.decisions {
+		// the decision names the ID the lookup was made with
+		if !strings.HasPrefix(d.AttestationID, "req-") || d.AttestationID != instance.requestIDs[i] {
+			t.Errorf("#%v: got attestation ID %q, lookup with %q", i, d.AttestationID, instance.requestIDs[i])
+		}
+	}
+	if h.decisions[0].AttestationID == h.decisions[1].AttestationID {
+		t.Errorf("attestations share the ID %q", h.decisions[0].AttestationID)
+	}
+}
+
+func TestConfigureKeepsComponentsOnFailure(t *testing.T) {
+	p := newTestPlugin()
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+		return fake.NewInstance(testProjectID, nil, nil), nil
+	}
+	configure := func(metricsAddress string) error {
+		conf := fmt.Sprintf(`
+		cloud_name = "test"
+		projectid_whitelist = ["alpha"]
+		metrics_address = %q
+		hook "log" {}
+		`, metricsAddress)
+		_, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf))
+		return err
+	}
+
+	if err := configure("127.0.0.1:0"); err != nil {
+		t.Fatalf("unexpected error from Configure(): %v", err)
+	}
+	defer p.metrics.Close()
+	rec := &recordingHook{}
+	p.hooks = hooks.Hooks{rec}
+	metrics := p.metrics
+
+	// The metrics server fails to listen on an address in use, which leaves the running components as they were
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := configure(l.Addr().String()); err == nil {
+		t.Fatal("an error expected, got nil")
+	}
+	if rec.closed || len(p.hooks) != 1 || p.hooks[0] != rec {
+		t.Errorf("hooks are replaced by a failed configuration: %v, %v", rec.closed, p.hooks)
+	}
+	if p.metrics != metrics {
+		t.Error("metrics server is replaced by a failed configuration")
+	}
+	resp, err := http.Get("http://" + metrics.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics server is not serving: %v", err)
+	}
+	resp.Body.Close()
+
+	// The metrics server of the same address is kept, while the hooks are replaced
+	if err := configure("127.0.0.1:0"); err != nil {
+		t.Fatalf("unexpected error from Configure(): %v", err)
+	}
+	if !rec.closed {
+		t.Error("replaced hooks are not closed")
+	}
+	if p.metrics != metrics {
+		t.Error("metrics server of the same address is replaced")
+	}
+}
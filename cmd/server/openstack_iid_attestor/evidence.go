@@ -0,0 +1,133 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+)
+
+// verifyEvidenceMain verifies an evidence bundle written by the evidence command of the agent plugin against the
+// clouds, printing a report of the steps. It returns 1 if any step failed.
+func verifyEvidenceMain(args []string) int {
+	fs := flag.NewFlagSet("verify-evidence", flag.ContinueOnError)
+	configFile := fs.String("config", "", "file of the plugin_data of the server plugin")
+	trustDomain := fs.String("trust-domain", "", "trust domain of the server")
+	verbose := fs.Bool("v", false, "log at debug level")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor verify-evidence -config <file> -trust-domain <domain> <evidence file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" || *trustDomain == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	conf, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	e, err := openstack.ReadEvidence(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	level := hclog.Warn
+	if *verbose {
+		level = hclog.Debug
+	}
+	p := New()
+	p.SetLogger(hclog.New(&hclog.LoggerOptions{
+		Name:   "verify-evidence",
+		Output: os.Stderr,
+		Level:  level,
+	}))
+
+	r := selftest.NewReport(os.Stdout)
+	verifyEvidence(context.Background(), p, string(conf), *trustDomain, e, r)
+	return r.Summary()
+}
+
+// verifyEvidence runs the steps of verifying the evidence with the plugin, which must not be configured yet. The
+// attestation data of the evidence is attested as sent by the agent, including its signed identity.
+func verifyEvidence(ctx context.Context, p *IIDAttestorPlugin, conf, trustDomain string, e *openstack.Evidence, r *selftest.Report) {
+	if len(e.Errors) > 0 {
+		var items []string
+		for item, err := range e.Errors {
+			items = append(items, fmt.Sprintf("%v: %v", item, err))
+		}
+		sort.Strings(items)
+		r.Warn("evidence", fmt.Errorf("collected at %v with errors: %v", e.CollectedAt, strings.Join(items, "; ")))
+	} else {
+		r.Pass("evidence", "collected at %v from %v", e.CollectedAt, e.MetadataSource)
+	}
+
+	if !configureOffline(ctx, p, conf, trustDomain, r) {
+		return
+	}
+
+	var payload *common.AttestationPayload
+	data, err := p.decryptPayload(e.AttestationData)
+	if err == nil {
+		payload, err = common.ParseAttestationPayload(data)
+	}
+	if err != nil {
+		r.Fail("attestation data", err)
+		r.Skip("nova lookup", "the attestation data is invalid")
+		r.Skip("attestation", "the attestation data is invalid")
+		return
+	}
+	r.Pass("attestation data", "instance %v, signed identity %v", payload.InstanceID, payload.SignedIdentity != nil)
+
+	switch {
+	case e.Metadata == nil:
+		r.Skip("metadata", "the evidence lacks the metadata")
+	case e.Metadata.UUID != payload.InstanceID:
+		r.Fail("metadata", fmt.Errorf("instance %v of the metadata doesn't match the attestation data", e.Metadata.UUID))
+	default:
+		r.Pass("metadata", "instance %v in project %v", e.Metadata.UUID, e.Metadata.ProjectID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.attestationTimeout)
+	defer cancel()
+	s, err := p.instance.Get(ctx, payload.InstanceID)
+	switch {
+	case err != nil:
+		r.Fail("nova lookup", err)
+	case e.Metadata != nil && (s.TenantID != e.Metadata.ProjectID || s.Name != e.Metadata.Name):
+		r.Fail("nova lookup", fmt.Errorf("instance %v in project %v doesn't match the metadata", s.Name, s.TenantID))
+	default:
+		r.Pass("nova lookup", "instance %v in project %v is %v", s.Name, s.TenantID, s.Status)
+	}
+	if err != nil {
+		r.Skip("attestation", "the instance can't be looked up")
+		return
+	}
+
+	a := p.newAttestation(openstack.NewRequestID(), payload)
+	if err := p.attest(openstack.WithRequestID(ctx, a.id), a); err != nil {
+		r.Fail("attestation", fmt.Errorf("%v: %v", reasonCode(err), err))
+		return
+	}
+	r.Pass("attestation", "admitted as %v with selectors %v", a.agentID, selectorList(a))
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestVerifyEvidence(t *testing.T) {
+	metadata := &openstack.Metadata{UUID: testUUID, Name: "bravo", ProjectID: testProjectID}
+	tCase := []struct {
+		conf     string
+		evidence *openstack.Evidence
+		want     []string
+	}{
+		// 0: admitted instance
+		{
+			conf: `projectid_whitelist = ["` + testProjectID + `"]`,
+			evidence: &openstack.Evidence{
+				Metadata:        metadata,
+				AttestationData: []byte(`{"instance_id": "` + testUUID + `"}`),
+			},
+			want: []string{"PASS  evidence", "PASS  configuration", "PASS  attestation data", "PASS  metadata", "PASS  nova lookup", "PASS  attestation: admitted as spiffe://example.com/spire/agent/openstack_iid/abc/123"},
+		},
+		// 1: partial evidence of an instance denied by the policy
+		{
+			conf: `projectid_whitelist = ["xyz"]`,
+			evidence: &openstack.Evidence{
+				Metadata:        metadata,
+				AttestationData: []byte(`{"instance_id": "` + testUUID + `"}`),
+				Errors:          map[string]string{"network_data": "not found"},
+			},
+			want: []string{"WARN  evidence", "PASS  configuration", "PASS  attestation data", "PASS  metadata", "PASS  nova lookup", "FAIL  attestation: POLICY_PROJECT_NOT_ALLOWED"},
+		},
+		// 2: metadata of another instance
+		{
+			conf: `projectid_whitelist = ["` + testProjectID + `"]`,
+			evidence: &openstack.Evidence{
+				Metadata:        &openstack.Metadata{UUID: "other", Name: "bravo", ProjectID: testProjectID},
+				AttestationData: []byte(`{"instance_id": "` + testUUID + `"}`),
+			},
+			want: []string{"PASS  evidence", "PASS  configuration", "PASS  attestation data", "FAIL  metadata", "PASS  nova lookup", "PASS  attestation"},
+		},
+		// 3: metadata of another project than Nova's
+		{
+			conf: `projectid_whitelist = ["` + testProjectID + `"]`,
+			evidence: &openstack.Evidence{
+				Metadata:        &openstack.Metadata{UUID: testUUID, Name: "bravo", ProjectID: "xyz"},
+				AttestationData: []byte(`{"instance_id": "` + testUUID + `"}`),
+			},
+			want: []string{"PASS  evidence", "PASS  configuration", "PASS  attestation data", "PASS  metadata", "FAIL  nova lookup", "PASS  attestation"},
+		},
+		// 4: invalid attestation data
+		{
+			conf: `projectid_whitelist = ["` + testProjectID + `"]`,
+			evidence: &openstack.Evidence{
+				AttestationData: []byte(`{}`),
+			},
+			want: []string{"PASS  evidence", "PASS  configuration", "FAIL  attestation data", "SKIP  nova lookup", "SKIP  attestation"},
+		},
+	}
+
+	for i, c := range tCase {
+		p := New()
+		p.SetLogger(testutil.TestLogger())
+		p.getInstanceHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error) {
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+		c.evidence.CollectedAt = time.Now()
+
+		var b bytes.Buffer
+		verifyEvidence(context.Background(), p, `cloud_name = "test"`+"\n"+c.conf, "example.com", c.evidence, selftest.NewReport(&b))
+
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != len(c.want) {
+			t.Errorf("#%v: unexpected report: %v", i, b.String())
+			continue
+		}
+		for j, want := range c.want {
+			if !strings.HasPrefix(lines[j], want) {
+				t.Errorf("#%v: got %q, want %q", i, lines[j], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	fingerprintKeyPrefix = "fingerprint:"
+
+	instanceChangeModeDeny = "deny"
+	instanceChangeModeFlag = "flag"
+)
+
+func validateInstanceChangeMode(c *IIDAttestorPluginConfig) error {
+	switch c.InstanceChangeMode {
+	case "", instanceChangeModeDeny, instanceChangeModeFlag:
+		return nil
+	default:
+		return fmt.Errorf("unknown instance_change_mode: %q", c.InstanceChangeMode)
+	}
+}
+
+// fingerprint holds the security-relevant attributes of an instance
+type fingerprint struct {
+	Hash     string   `json:"hash"`
+	Image    string   `json:"image"`
+	Flavor   string   `json:"flavor"`
+	Networks []string `json:"networks"`
+	// Host is the hostId of Nova, which is unique to the compute host within the project. It is not part of the
+	// hash, so that fingerprints recorded without it don't differ from current ones.
+	Host string `json:"host,omitempty"`
+}
+
+// instanceChange is a change of an attribute of an instance, and the lifecycle event it indicates if any
+type instanceChange struct {
+	event       string
+	description string
+}
+
+func newFingerprint(s *openstack.Server) *fingerprint {
+	f := &fingerprint{
+		Image:    attributeID(s.Image),
+		Flavor:   attributeID(s.Flavor),
+		Networks: []string{},
+		Host:     s.HostID,
+	}
+	for network := range s.Addresses {
+		f.Networks = append(f.Networks, network)
+	}
+	sort.Strings(f.Networks)
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{f.Image, f.Flavor, strings.Join(f.Networks, ",")}, "\n")))
+	f.Hash = hex.EncodeToString(sum[:])
+	return f
+}
+
+// attributeID returns the ID of the image or flavor, or the name of the flavor embedded by newer microversions
+func attributeID(m map[string]interface{}) string {
+	for _, k := range []string{"id", "original_name"} {
+		if v, ok := m[k].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// changes returns the changes from f to g. A new image indicates a rebuild, a new flavor a resize and a new host a
+// migration.
+func (f *fingerprint) changes(g *fingerprint) []instanceChange {
+	var changes []instanceChange
+	if f.Hash != g.Hash {
+		if f.Image != g.Image {
+			changes = append(changes, instanceChange{lifecycleRebuild, fmt.Sprintf("image: %v -> %v", f.Image, g.Image)})
+		}
+		if f.Flavor != g.Flavor {
+			changes = append(changes, instanceChange{lifecycleResize, fmt.Sprintf("flavor: %v -> %v", f.Flavor, g.Flavor)})
+		}
+		if old, new := strings.Join(f.Networks, ","), strings.Join(g.Networks, ","); old != new {
+			changes = append(changes, instanceChange{"", fmt.Sprintf("networks: [%v] -> [%v]", old, new)})
+		}
+	}
+	// Fingerprints recorded before the host, and servers not showing it, tell nothing of migrations
+	if f.Host != "" && g.Host != "" && f.Host != g.Host {
+		changes = append(changes, instanceChange{lifecycleMigration, fmt.Sprintf("host: %v -> %v", f.Host, g.Host)})
+	}
+	return changes
+}
+
+// diff returns the changes from f to g in the form of "<attribute>: <old> -> <new>"
+func (f *fingerprint) diff(g *fingerprint) []string {
+	var changes []string
+	for _, c := range f.changes(g) {
+		changes = append(changes, c.description)
+	}
+	return changes
+}
+
+// fingerprintStore records the fingerprints of attested instances. They don't expire since
+// agents may re-attest at any time during the lifetime of the instance.
+type fingerprintStore struct {
+	logger hclog.Logger
+	cache  cache.Cache
+}
+
+func newFingerprintStore(c cache.Cache, logger hclog.Logger) *fingerprintStore {
+	return &fingerprintStore{
+		logger: logger,
+		cache:  c,
+	}
+}
+
+func (fs *fingerprintStore) lookup(instanceID string) (*fingerprint, bool) {
+	b, ok, err := fs.cache.Get(fingerprintKeyPrefix + instanceID)
+	if err != nil {
+		fs.logger.Warn("Failed to lookup instance fingerprint", "instance_id", instanceID, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	f := &fingerprint{}
+	if err := json.Unmarshal(b, f); err != nil {
+		fs.logger.Warn("Failed to decode instance fingerprint", "instance_id", instanceID, "error", err)
+		return nil, false
+	}
+	return f, true
+}
+
+func (fs *fingerprintStore) store(instanceID string, f *fingerprint) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		fs.logger.Warn("Failed to encode instance fingerprint", "instance_id", instanceID, "error", err)
+		return
+	}
+	if err := fs.cache.Set(fingerprintKeyPrefix+instanceID, b, 0); err != nil {
+		fs.logger.Warn("Failed to store instance fingerprint", "instance_id", instanceID, "error", err)
+	}
+}
+
+// checkInstanceChanges compares the instance with its fingerprint recorded at the previous attestation.
+// Changes are recorded in the attestation for the audit log. Rebuilds, resizes and migrations are handled as
+// configured by instance_lifecycle_policy, and other changes by instance_change_mode: in deny mode they fail the
+// attestation, and in flag mode the agent gets the "instance:changed" selector.
+func (p *IIDAttestorPlugin) checkInstanceChanges(a *attestation, attested bool) ([]*spc.Selector, error) {
+	if p.fingerprints == nil {
+		return nil, nil
+	}
+
+	current := newFingerprint(a.server)
+	previous, ok := p.fingerprints.lookup(a.instanceID)
+	if !ok {
+		p.fingerprints.store(a.instanceID, current)
+		return nil, nil
+	}
+	changes := previous.changes(current)
+	lifecycle := p.config.InstanceLifecycle
+
+	if !attested {
+		// New agents of the instance, e.g. after the agent was evicted, start over unless an event is denied
+		for _, c := range changes {
+			if lifecycle.action(c.event) == lifecycleActionDeny {
+				instanceLifecycleEvents.WithLabelValues(c.event, lifecycleActionDeny).Inc()
+				a.changes = previous.diff(current)
+				return nil, deny(reasonInstanceChanged, fmt.Errorf("instance changed by %v since the previous attestation: %v", c.event, c.description))
+			}
+		}
+		p.fingerprints.store(a.instanceID, current)
+		return nil, nil
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	a.changes = previous.diff(current)
+	a.logger.Warn("Instance changed since the previous attestation", "instance_id", a.instanceID, "changes", strings.Join(a.changes, "; "))
+
+	var denied, reattest *instanceChange
+	var others []string
+	for i, c := range changes {
+		action := lifecycle.action(c.event)
+		if action == "" {
+			// instance_change_mode predates the host, and covers the image, flavor and networks only
+			if c.event != lifecycleMigration {
+				others = append(others, c.description)
+			}
+			continue
+		}
+		instanceLifecycleEvents.WithLabelValues(c.event, action).Inc()
+		switch {
+		case action == lifecycleActionDeny && denied == nil:
+			denied = &changes[i]
+		case action == lifecycleActionReattest && reattest == nil:
+			reattest = &changes[i]
+		}
+	}
+	switch {
+	case denied != nil:
+		return nil, deny(reasonInstanceChanged, fmt.Errorf("instance changed by %v since the previous attestation: %v", denied.event, denied.description))
+	case reattest != nil:
+		// The fingerprint is kept, so that the agent is refused until it is evicted and attests as a new agent
+		return nil, deny(reasonReattestRequired, fmt.Errorf("instance changed by %v since the previous attestation, and its agent must be evicted to attest again: %v", reattest.event, reattest.description))
+	case len(others) > 0 && p.config.InstanceChangeMode == instanceChangeModeDeny:
+		return nil, deny(reasonInstanceChanged, fmt.Errorf("instance changed since the previous attestation: %v", strings.Join(others, "; ")))
+	}
+
+	p.fingerprints.store(a.instanceID, current)
+	if len(others) == 0 || p.config.InstanceChangeMode != instanceChangeModeFlag {
+		return nil, nil
+	}
+	return []*spc.Selector{
+		{
+			Type:  common.PluginName,
+			Value: "instance:changed",
+		},
+	}, nil
+}
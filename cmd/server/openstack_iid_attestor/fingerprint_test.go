@@ -0,0 +1,98 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestFingerprintDiff(t *testing.T) {
+	f := newFingerprint(&openstack.Server{Server: servers.Server{
+		Image:     map[string]interface{}{"id": "image-1"},
+		Flavor:    map[string]interface{}{"id": "m1.small"},
+		Addresses: map[string]interface{}{"private": nil},
+	}})
+	g := newFingerprint(&openstack.Server{Server: servers.Server{
+		Image:     map[string]interface{}{"id": "image-2"},
+		Flavor:    map[string]interface{}{"original_name": "m1.small"},
+		Addresses: map[string]interface{}{"private": nil, "public": nil},
+	}})
+
+	if changes := f.diff(f); changes != nil {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+	expected := []string{"image: image-1 -> image-2", "networks: [private] -> [private,public]"}
+	if changes := f.diff(g); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, want %v", changes, expected)
+	}
+}
+
+func TestAttestInstanceChanges(t *testing.T) {
+	tCase := []struct {
+		mode          string
+		wantErr       bool
+		wantSelectors []string
+	}{
+		// 0: changed instance is denied
+		{mode: instanceChangeModeDeny, wantErr: true},
+		// 1: changed instance is flagged
+		{mode: instanceChangeModeFlag, wantSelectors: []string{"instance:changed"}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.fingerprints = newFingerprintStore(cache.NewMemory(), p.logger)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.CanReattest = true
+		p.config.InstanceChangeMode = c.mode
+
+		p.instance = fake.NewInstanceWithAddresses(testProjectID, map[string]interface{}{"private": nil})
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Fatalf("#%v: Attestation error: %v", i, err)
+		}
+
+		// unchanged instance re-attests as usual
+		p.attestedBeforeHandler = onceAttestedBeforeHandler
+		fs := fake.NewAttestStream(testUUID)
+		if err := p.Attest(fs); err != nil {
+			t.Fatalf("#%v: Attestation error: %v", i, err)
+		}
+		if n := len(fs.Response().Selectors); n != 0 {
+			t.Errorf("#%v: unexpected selectors: %v", i, fs.Response().Selectors)
+		}
+
+		p.instance = fake.NewInstanceWithAddresses(testProjectID, map[string]interface{}{"public": nil})
+		fs = fake.NewAttestStream(testUUID)
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if !reflect.DeepEqual(got, c.wantSelectors) {
+			t.Errorf("#%v: got %v, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// attestationFixture is a recorded attestation, which the replay mode feeds back through the verification offline
+type attestationFixture struct {
+	RecordedAt     time.Time          `json:"recorded_at"`
+	TrustDomain    string             `json:"trust_domain"`
+	Payload        []byte             `json:"payload"`
+	AttestedBefore bool               `json:"attested_before"`
+	OpenStack      *openstack.Fixture `json:"openstack"`
+	Decision       *hooks.Decision    `json:"decision"`
+}
+
+// validateRecordFixtures verifies that the directory to record fixtures to exists
+func validateRecordFixtures(c *IIDAttestorPluginConfig) error {
+	if c.RecordFixtures == "" {
+		return nil
+	}
+	dir := common.ExpandEnv(c.RecordFixtures)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid record_fixtures: %v", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("record_fixtures is not a directory: %v", dir)
+	}
+	return nil
+}
+
+// startFixture returns a context recording the responses of OpenStack into a new fixture if record_fixtures is set.
+// The fixture is nil otherwise.
+func (p *IIDAttestorPlugin) startFixture(ctx context.Context, data []byte) (context.Context, *attestationFixture) {
+	if p.config.RecordFixtures == "" {
+		return ctx, nil
+	}
+	f := &attestationFixture{
+		RecordedAt:  time.Now().UTC(),
+		TrustDomain: p.config.trustDomain,
+		Payload:     data,
+		OpenStack:   &openstack.Fixture{},
+	}
+	return openstack.WithFixture(ctx, f.OpenStack), f
+}
+
+// saveFixture writes the fixture with the decision of the attestation to the record_fixtures directory
+func (p *IIDAttestorPlugin) saveFixture(f *attestationFixture, a *attestation, err error) {
+	if f == nil {
+		return
+	}
+	f.AttestedBefore = a.attestedAgentID != ""
+	f.Decision = newDecision(a, err)
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err == nil {
+		name := fmt.Sprintf("%s-%d.json", a.instanceID, f.RecordedAt.UnixNano())
+		// Payloads may carry signed identities, so fixtures are readable only by the server
+		err = ioutil.WriteFile(filepath.Join(common.ExpandEnv(p.config.RecordFixtures), name), b, 0600)
+	}
+	if err != nil {
+		a.logger.Warn("Failed to record fixture", "instance_id", a.instanceID, "error", err)
+	}
+}
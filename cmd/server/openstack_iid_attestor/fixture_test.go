@@ -0,0 +1,90 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestRecordAndReplayFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newTestPlugin()
+	p.instance = openstack.RecordInstance(fake.NewInstance(testProjectID, nil, nil))
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.RecordFixtures = dir
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Fatalf("attestation error: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, testUUID+"-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("unexpected fixtures: %v, %v", files, err)
+	}
+
+	// 1: the same configuration admits the agent again
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["` + testProjectID + `"]
+	metrics_address = ":9988"
+	`
+	r, err := replayFile(files[0], conf, "", testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if !r.Recorded.Admitted || !r.Replayed.Admitted || r.Diverged {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	// 2: a stricter configuration denies the agent
+	conf = `
+	cloud_name = "test"
+	projectid_whitelist = ["xyz"]
+	`
+	r, err = replayFile(files[0], conf, "", testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if r.Replayed.ReasonCode != reasonProjectNotAllowed || !r.Diverged {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestReplayUnrecordedRequest(t *testing.T) {
+	f := &attestationFixture{
+		Payload:   []byte(testUUID),
+		OpenStack: &openstack.Fixture{},
+	}
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["` + testProjectID + `"]
+	`
+	d, err := replay(context.Background(), f, conf, "example.com", testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	// Requests missing in the fixture fail like an unavailable OpenStack
+	if d.Admitted || d.ReasonCode != reasonOpenStackUnavailable {
+		b, _ := json.Marshal(d)
+		t.Errorf("unexpected decision: %s", b)
+	}
+}
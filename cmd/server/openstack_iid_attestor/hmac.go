@@ -0,0 +1,262 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spiffe/spire/proto/spire/server/nodeattestor"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/nonce"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	defaultPayloadHMACMaxAge = 5 * time.Minute
+
+	// hmacChallengePurpose is the purpose of the nonces of HMAC challenges
+	hmacChallengePurpose = "hmac_challenge"
+	// payloadHMACPurpose is the purpose of the nonces agents choose for payload HMACs
+	payloadHMACPurpose = "payload_hmac"
+)
+
+// PayloadHMACKeyConfig is a secret trusted to authenticate attestation payloads
+type PayloadHMACKeyConfig struct {
+	KeyID string `hcl:",key"`
+	// Base64 encoded secret of at least 16 bytes
+	Secret     string `hcl:"secret"`
+	SecretFile string `hcl:"secret_file"`
+	// Projects whose instances are provisioned with the secret. Any project if empty.
+	Projects []string `hcl:"projects"`
+	// If true, the secret is the master secret of the vendordata signer, and instances are provisioned with the
+	// secret of their project derived from it
+	PerProject bool `hcl:"per_project"`
+}
+
+// payloadHMACKey is a trusted secret and the projects it authenticates, or nil for any project
+type payloadHMACKey struct {
+	secret     []byte
+	projects   map[string]bool
+	perProject bool
+}
+
+// secretOf returns the secret instances of the project are provisioned with
+func (k *payloadHMACKey) secretOf(projectID string) []byte {
+	if k.perProject {
+		return common.DeriveProjectHMACSecret(k.secret, projectID)
+	}
+	return k.secret
+}
+
+// payloadHMACKeys are the trusted secrets by key ID
+type payloadHMACKeys struct {
+	keys   map[string]*payloadHMACKey
+	maxAge time.Duration
+}
+
+var payloadHMACs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "payload_hmacs_total",
+	Help:      "Number of attestation payloads authenticated with HMAC presented by agents, by key ID and result.",
+}, []string{"key_id", "result"})
+
+var hmacChallenges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "hmac_challenges_total",
+	Help:      "Number of HMAC challenges, by result.",
+}, []string{"result"})
+
+func init() {
+	telemetry.Registry.MustRegister(payloadHMACs, hmacChallenges)
+}
+
+// newPayloadHMACKeys returns the configured secrets, or nil if none is configured
+func newPayloadHMACKeys(c *IIDAttestorPluginConfig) (*payloadHMACKeys, error) {
+	if len(c.PayloadHMACKeys) == 0 {
+		if c.RequirePayloadHMAC {
+			return nil, errors.New("require_payload_hmac requires at least one payload_hmac_key")
+		}
+		if c.ChallengeHMAC {
+			return nil, errors.New("challenge_hmac requires at least one payload_hmac_key")
+		}
+		return nil, nil
+	}
+
+	k := &payloadHMACKeys{
+		keys:   make(map[string]*payloadHMACKey),
+		maxAge: defaultPayloadHMACMaxAge,
+	}
+	if c.PayloadHMACMaxAge != "" {
+		d, err := time.ParseDuration(c.PayloadHMACMaxAge)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid payload_hmac_max_age: %q", c.PayloadHMACMaxAge)
+		}
+		k.maxAge = d
+	}
+	for _, kc := range c.PayloadHMACKeys {
+		if kc.KeyID == "" {
+			return nil, errors.New("payload_hmac_key requires a key ID")
+		}
+		if _, ok := k.keys[kc.KeyID]; ok {
+			return nil, fmt.Errorf("duplicate payload_hmac_key: %v", kc.KeyID)
+		}
+		s, err := common.ResolveSecret("secret", kc.Secret, kc.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload_hmac_key %v: %v", kc.KeyID, err)
+		}
+		secret, err := common.DecodeHMACSecret(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload_hmac_key %v: %v", kc.KeyID, err)
+		}
+		key := &payloadHMACKey{secret: secret, perProject: kc.PerProject}
+		if len(kc.Projects) > 0 {
+			key.projects = make(map[string]bool)
+			for _, project := range kc.Projects {
+				key.projects[project] = true
+			}
+		}
+		k.keys[kc.KeyID] = key
+	}
+	return k, nil
+}
+
+// checkPayloadHMAC verifies the MAC of the attestation payload with the secret of its key ID, which must be provisioned
+// to the project of the instance. Secrets of per_project keys are derived for the project of the instance in Nova,
+// so that a secret of another project doesn't verify. MACs are verified if secrets are configured, and required only if
+// require_payload_hmac is set.
+func (p *IIDAttestorPlugin) checkPayloadHMAC(a *attestation) error {
+	if a.payload == nil || a.payload.HMAC == nil {
+		if p.config.RequirePayloadHMAC {
+			return deny(reasonPayloadHMACMissing, fmt.Errorf("instance %v sent no hmac", a.instanceID))
+		}
+		return nil
+	}
+	keyID := a.payload.HMAC.KeyID
+	if p.hmacKeys == nil {
+		a.logger.Debug("Ignoring hmac without trusted secrets", "instance_id", a.instanceID, "key_id", keyID)
+		return nil
+	}
+
+	key := p.hmacKeys.keys[keyID]
+	err := a.payload.VerifyHMAC(func(string) []byte {
+		if key == nil {
+			return nil
+		}
+		return key.secretOf(a.server.TenantID)
+	}, p.hmacKeys.maxAge, time.Now())
+	if err == common.ErrUnknownHMACKey {
+		payloadHMACs.WithLabelValues(untrustedKeyLabel, "invalid").Inc()
+		return deny(reasonPayloadHMACInvalid, fmt.Errorf("hmac of instance %v is of untrusted key %q", a.instanceID, keyID))
+	}
+	if err == nil && key.projects != nil && !key.projects[a.server.TenantID] {
+		err = fmt.Errorf("key is not provisioned to project %v", a.server.TenantID)
+	}
+	if err == nil && a.payload.HMAC.Nonce == "" && p.config.RequirePayloadHMACNonce {
+		err = errors.New("hmac has no nonce")
+	}
+	if err != nil {
+		payloadHMACs.WithLabelValues(keyID, "invalid").Inc()
+		return deny(reasonPayloadHMACInvalid, fmt.Errorf("hmac of instance %v is invalid: %v", a.instanceID, err))
+	}
+	if err := p.usePayloadHMACNonce(a); err != nil {
+		return err
+	}
+
+	payloadHMACs.WithLabelValues(keyID, "verified").Inc()
+	a.addEvidence(evidencePayloadHMAC)
+	return nil
+}
+
+// usePayloadHMACNonce accepts the nonce of the verified MAC only once, so that a captured payload can't be replayed
+// within payload_hmac_max_age. Nonces are kept for twice the max age, the span a MAC is accepted in. The denial of a
+// replay isn't cached, since it is of the payload rather than of the instance.
+func (p *IIDAttestorPlugin) usePayloadHMACNonce(a *attestation) error {
+	n := a.payload.HMAC.Nonce
+	if n == "" {
+		return nil
+	}
+	err := p.nonces.Use(n, payloadHMACPurpose, a.instanceID, 2*p.hmacKeys.maxAge)
+	switch {
+	case err == nonce.ErrReplayed:
+		payloadHMACs.WithLabelValues(a.payload.HMAC.KeyID, "replayed").Inc()
+		return deny(reasonPayloadHMACReplayed, fmt.Errorf("hmac of instance %v was presented before", a.instanceID))
+	case err != nil:
+		return &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	return nil
+}
+
+// verifyHMACChallenge challenges the agent to authenticate a nonce with its secret, which must be trusted and
+// provisioned to the project of the instance. Unlike the MAC of the payload, the answer can't be replayed within
+// payload_hmac_max_age, so that it proves the agent holds the secret at the time of the attestation.
+func (p *IIDAttestorPlugin) verifyHMACChallenge(ctx context.Context, stream nodeattestor.NodeAttestor_AttestServer, a *attestation) error {
+	iid := a.instanceID
+	nonce, err := p.nonces.Issue(hmacChallengePurpose, iid)
+	if err != nil {
+		hmacChallenges.WithLabelValues("error").Inc()
+		return &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	// The nonce is consumed whatever the result, so that it can't be answered twice
+	defer func() {
+		if err := p.nonces.Consume(nonce, hmacChallengePurpose, iid); err != nil {
+			a.logger.Debug("Failed to consume the nonce of the hmac challenge", "instance_id", iid, "error", err)
+		}
+	}()
+
+	challenge, err := (&common.Challenge{Type: common.ChallengeHMAC, Nonce: nonce}).Marshal()
+	if err != nil {
+		return err
+	}
+	var req *nodeattestor.AttestRequest
+	err = common.CallWithContext(ctx, func() error {
+		if err := stream.Send(&nodeattestor.AttestResponse{Challenge: challenge}); err != nil {
+			return err
+		}
+		req, err = stream.Recv()
+		return err
+	})
+	if err != nil {
+		hmacChallenges.WithLabelValues("unanswered").Inc()
+		return deny(reasonHMACChallengeFailed, fmt.Errorf("agent of instance %v didn't answer the hmac challenge: %v", iid, err))
+	}
+
+	resp, err := common.ParseChallengeResponse(req.Response)
+	if err == nil && (resp.Type != common.ChallengeHMAC || resp.Nonce != nonce) {
+		err = errors.New("the response is not of the challenge")
+	}
+	var key *payloadHMACKey
+	if err == nil {
+		key = p.hmacKeys.keys[resp.KeyID]
+		err = resp.VerifyChallenge(func(string) []byte {
+			if key == nil {
+				return nil
+			}
+			return key.secretOf(a.server.TenantID)
+		}, nonce)
+	}
+	if err == common.ErrUnknownHMACKey {
+		err = fmt.Errorf("untrusted key %q", resp.KeyID)
+	}
+	if err == nil && key.projects != nil && !key.projects[a.server.TenantID] {
+		err = fmt.Errorf("key %v is not provisioned to project %v", resp.KeyID, a.server.TenantID)
+	}
+	if err != nil {
+		hmacChallenges.WithLabelValues("invalid").Inc()
+		return deny(reasonHMACChallengeFailed, fmt.Errorf("invalid hmac challenge response from instance %v: %v", iid, err))
+	}
+
+	hmacChallenges.WithLabelValues("verified").Inc()
+	a.logger.Debug("HMAC challenge verified", "instance_id", iid, "key_id", resp.KeyID)
+	a.addEvidence(evidenceHMACChallenge)
+	return nil
+}
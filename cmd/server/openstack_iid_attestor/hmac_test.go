@@ -0,0 +1,280 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/nonce"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestPayloadHMAC(t *testing.T) {
+	// both secrets are trusted while rotating from the old secret to the new secret, which is of the project only
+	keys, err := newPayloadHMACKeys(&IIDAttestorPluginConfig{
+		PayloadHMACKeys: []*PayloadHMACKeyConfig{
+			{KeyID: "2019-01", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="},
+			{KeyID: "2019-07", Secret: "ZmVkY2JhOTg3NjU0MzIxMA==", Projects: []string{testProjectID}},
+			{KeyID: "other", Secret: "b3RoZXIgcHJvamVjdCBzZWNyZXQ=", Projects: []string{"def"}},
+			{KeyID: "vendordata", Secret: "MDEyMzQ1Njc4OWFiY2RlZg==", PerProject: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := []byte("0123456789abcdef")
+
+	tCase := []struct {
+		keyID        string
+		secret       string
+		nonce        string
+		issuedAt     time.Time
+		require      bool
+		requireNonce bool
+		wantCode     string
+	}{
+		// 0: authenticated with the old secret
+		{keyID: "2019-01", secret: "0123456789abcdef", issuedAt: time.Now()},
+		// 1: authenticated with the new secret
+		{keyID: "2019-07", secret: "fedcba9876543210", issuedAt: time.Now(), require: true},
+		// 2: authenticated with an untrusted secret
+		{keyID: "2018-07", secret: "0123456789abcdef", issuedAt: time.Now(), wantCode: reasonPayloadHMACInvalid},
+		// 3: forged with a wrong secret
+		{keyID: "2019-07", secret: "0123456789abcdef", issuedAt: time.Now(), wantCode: reasonPayloadHMACInvalid},
+		// 4: secret of another project
+		{keyID: "other", secret: "other project secret", issuedAt: time.Now(), wantCode: reasonPayloadHMACInvalid},
+		// 5: replayed after the window
+		{keyID: "2019-07", secret: "fedcba9876543210", issuedAt: time.Now().Add(-time.Hour), wantCode: reasonPayloadHMACInvalid},
+		// 6: no hmac from older agents
+		{},
+		// 7: no hmac while required
+		{require: true, wantCode: reasonPayloadHMACMissing},
+		// 8: secret of the project provisioned by the vendordata signer
+		{keyID: "vendordata", secret: string(common.DeriveProjectHMACSecret(master, testProjectID)), issuedAt: time.Now(), require: true},
+		// 9: secret of another project provisioned by the vendordata signer
+		{keyID: "vendordata", secret: string(common.DeriveProjectHMACSecret(master, "def")), issuedAt: time.Now(), wantCode: reasonPayloadHMACInvalid},
+		// 10: master secret of the vendordata signer
+		{keyID: "vendordata", secret: string(master), issuedAt: time.Now(), wantCode: reasonPayloadHMACInvalid},
+		// 11: with a nonce
+		{keyID: "2019-07", secret: "fedcba9876543210", nonce: "alpha", issuedAt: time.Now(), requireNonce: true},
+		// 12: no nonce while required
+		{keyID: "2019-07", secret: "fedcba9876543210", issuedAt: time.Now(), requireNonce: true, wantCode: reasonPayloadHMACInvalid},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequirePayloadHMAC = c.require
+		p.config.RequirePayloadHMACNonce = c.requireNonce
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.hmacKeys = keys
+		p.nonces = nonce.NewManager(cache.NewMemory(), time.Minute)
+
+		payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: testProjectID}
+		if c.keyID != "" {
+			payload.SignHMAC(c.keyID, []byte(c.secret), c.nonce, c.issuedAt)
+		}
+		data, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
+
+func TestAttestPayloadHMACReplay(t *testing.T) {
+	keys, err := newPayloadHMACKeys(&IIDAttestorPluginConfig{
+		PayloadHMACKeys: []*PayloadHMACKeyConfig{
+			{KeyID: "2019-07", Secret: "ZmVkY2JhOTg3NjU0MzIxMA=="},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.hmacKeys = keys
+	p.nonces = nonce.NewManager(cache.NewMemory(), time.Minute)
+
+	payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: testProjectID}
+	payload.SignHMAC("2019-07", []byte("fedcba9876543210"), "alpha", time.Now())
+	data, err := payload.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Attest(fake.NewAttestStreamWithData(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The same payload is accepted only once
+	err = p.Attest(fake.NewAttestStreamWithData(data))
+	if code := reasonCode(err); code != reasonPayloadHMACReplayed {
+		t.Errorf("got reason code %v, want %v", code, reasonPayloadHMACReplayed)
+	}
+	if isCacheable(err) {
+		t.Errorf("denial of a replay should not be cached: %v", err)
+	}
+}
+
+func TestConfigurePayloadHMACKeys(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: none
+		{config: &IIDAttestorPluginConfig{}},
+		// 1: a secret
+		{config: &IIDAttestorPluginConfig{PayloadHMACKeys: []*PayloadHMACKeyConfig{{KeyID: "alpha", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}}, PayloadHMACMaxAge: "1m"}},
+		// 2: required without secrets
+		{config: &IIDAttestorPluginConfig{RequirePayloadHMAC: true}, wantErr: true},
+		// 3: duplicate key
+		{config: &IIDAttestorPluginConfig{PayloadHMACKeys: []*PayloadHMACKeyConfig{{KeyID: "alpha", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}, {KeyID: "alpha", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}}}, wantErr: true},
+		// 4: short secret
+		{config: &IIDAttestorPluginConfig{PayloadHMACKeys: []*PayloadHMACKeyConfig{{KeyID: "alpha", Secret: "MDEyMw=="}}}, wantErr: true},
+		// 5: invalid window
+		{config: &IIDAttestorPluginConfig{PayloadHMACKeys: []*PayloadHMACKeyConfig{{KeyID: "alpha", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}}, PayloadHMACMaxAge: "0s"}, wantErr: true},
+		// 6: challenges without secrets
+		{config: &IIDAttestorPluginConfig{ChallengeHMAC: true}, wantErr: true},
+	} {
+		_, err := newPayloadHMACKeys(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: expected error", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestAttestHMACChallenge(t *testing.T) {
+	keys, err := newPayloadHMACKeys(&IIDAttestorPluginConfig{
+		ChallengeHMAC: true,
+		PayloadHMACKeys: []*PayloadHMACKeyConfig{
+			{KeyID: "2019-07", Secret: "ZmVkY2JhOTg3NjU0MzIxMA==", Projects: []string{testProjectID}},
+			{KeyID: "other", Secret: "b3RoZXIgcHJvamVjdCBzZWNyZXQ=", Projects: []string{"def"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		// answer returns the response to the challenge, or nil to hang up
+		answer   func(c *common.Challenge) *common.ChallengeResponse
+		wantCode string
+	}{
+		// 0: authenticated with a trusted secret
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				r := &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+				r.SignChallenge("2019-07", []byte("fedcba9876543210"), c.Nonce)
+				return r
+			},
+		},
+		// 1: forged with a wrong secret
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				r := &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+				r.SignChallenge("2019-07", []byte("0123456789abcdef"), c.Nonce)
+				return r
+			},
+			wantCode: reasonHMACChallengeFailed,
+		},
+		// 2: replayed answer of another nonce
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				r := &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+				r.SignChallenge("2019-07", []byte("fedcba9876543210"), "other")
+				return r
+			},
+			wantCode: reasonHMACChallengeFailed,
+		},
+		// 3: secret of another project
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				r := &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+				r.SignChallenge("other", []byte("other project secret"), c.Nonce)
+				return r
+			},
+			wantCode: reasonHMACChallengeFailed,
+		},
+		// 4: untrusted secret
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				r := &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce}
+				r.SignChallenge("2018-07", []byte("fedcba9876543210"), c.Nonce)
+				return r
+			},
+			wantCode: reasonHMACChallengeFailed,
+		},
+		// 5: agents without payload_hmac don't answer
+		{
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				return nil
+			},
+			wantCode: reasonHMACChallengeFailed,
+		},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.ChallengeHMAC = true
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.hmacKeys = keys
+		p.nonces = nonce.NewManager(cache.NewMemory(), time.Minute)
+
+		stream := fake.NewAttestStream(testUUID)
+		stream.Respond = func(b []byte) []byte {
+			challenge, err := common.ParseChallenge(b)
+			if err != nil {
+				t.Errorf("#%v: unexpected challenge: %v", i, err)
+				return nil
+			}
+			resp := c.answer(challenge)
+			if resp == nil {
+				return nil
+			}
+			data, _ := resp.Marshal()
+			return data
+		}
+
+		err := p.Attest(stream)
+		if len(stream.Challenges) != 1 {
+			t.Errorf("#%v: got %v challenges, want 1", i, len(stream.Challenges))
+		}
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			} else if stream.Response() == nil || stream.Response().AgentId == "" {
+				t.Errorf("#%v: agent is not admitted: %v", i, stream.Response())
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// SignedIdentityKeyConfig is a public key trusted to verify signed identities
+type SignedIdentityKeyConfig struct {
+	KeyID         string `hcl:",key"`
+	PublicKeyFile string `hcl:"public_key_file"`
+}
+
+const (
+	// untrustedKeyLabel replaces key IDs not trusted in metrics, which agents could otherwise choose freely
+	untrustedKeyLabel = "untrusted"
+
+	defaultSignedIdentityMaxAge = 5 * time.Minute
+)
+
+var signedIdentities = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "signed_identities_total",
+	Help:      "Number of signed identities presented by agents, by key ID and result.",
+}, []string{"key_id", "result"})
+
+func init() {
+	telemetry.Registry.MustRegister(signedIdentities)
+}
+
+// newSignedIdentityVerifier returns a verifier trusting the configured keys and CAs, or nil if none is configured
+func newSignedIdentityVerifier(c *IIDAttestorPluginConfig) (*vendordata.Verifier, error) {
+	if len(c.SignedIdentityKeys) == 0 && c.SignedIdentityCAFile == "" {
+		if c.RequireSignedIdentity {
+			return nil, errors.New("require_signed_identity requires at least one signed_identity_key or signed_identity_ca_file")
+		}
+		return nil, nil
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range c.SignedIdentityKeys {
+		if k.KeyID == "" {
+			return nil, errors.New("signed_identity_key requires a key ID")
+		}
+		if _, ok := keys[k.KeyID]; ok {
+			return nil, fmt.Errorf("duplicate signed_identity_key: %v", k.KeyID)
+		}
+		key, err := vendordata.LoadPublicKey(k.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signed_identity_key %v: %v", k.KeyID, err)
+		}
+		keys[k.KeyID] = key
+	}
+	algorithms := c.SignedIdentityAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = vendordata.Algorithms()
+	}
+	var roots *x509.CertPool
+	if c.SignedIdentityCAFile != "" {
+		cas, err := vendordata.LoadCertificates(c.SignedIdentityCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signed_identity_ca_file: %v", err)
+		}
+		roots = x509.NewCertPool()
+		for _, ca := range cas {
+			roots.AddCert(ca)
+		}
+	}
+	v, err := vendordata.NewVerifierWithRoots(keys, roots, algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed_identity_key: %v", err)
+	}
+	return v, nil
+}
+
+// checkSignedIdentity verifies the identity signed by the vendordata signing service against the instance.
+// Identities are verified if keys are configured, and required only if require_signed_identity is set. Identities
+// signed more than signed_identity_max_age before or after the time of the server are rejected, so that a captured
+// identity can't be presented as a bearer document for long; agents fetch the identity on each attestation.
+func (p *IIDAttestorPlugin) checkSignedIdentity(a *attestation) error {
+	var signed *vendordata.SignedIdentity
+	if a.payload != nil {
+		signed = a.payload.SignedIdentity
+	}
+	if signed == nil {
+		if p.config.RequireSignedIdentity {
+			return deny(reasonSignedIdentityMissing, fmt.Errorf("instance %v sent no signed identity", a.instanceID))
+		}
+		return nil
+	}
+	if p.verifier == nil {
+		a.logger.Debug("Ignoring signed identity without trusted keys", "instance_id", a.instanceID, "key_id", signed.KeyID)
+		return nil
+	}
+
+	id, err := p.verifier.Verify(signed)
+	if errors.Is(err, vendordata.ErrUnknownKey) {
+		signedIdentities.WithLabelValues(untrustedKeyLabel, "invalid").Inc()
+		return deny(reasonSignedIdentityInvalid, fmt.Errorf("signed identity of instance %v is signed with untrusted key %q: %v", a.instanceID, signed.KeyID, err))
+	}
+	if err == nil {
+		switch {
+		case id.InstanceID != a.instanceID:
+			err = fmt.Errorf("identity is of instance %v", id.InstanceID)
+		case id.ProjectID != a.server.TenantID:
+			err = fmt.Errorf("identity is of project %v", id.ProjectID)
+		default:
+			err = checkIssuedAt(time.Unix(id.IssuedAt, 0), p.config.signedIdentityMaxAge)
+		}
+	}
+	if err != nil {
+		signedIdentities.WithLabelValues(signed.KeyID, "invalid").Inc()
+		return deny(reasonSignedIdentityInvalid, fmt.Errorf("signed identity of instance %v is invalid: %v", a.instanceID, err))
+	}
+
+	signedIdentities.WithLabelValues(signed.KeyID, "verified").Inc()
+	a.addEvidence(evidenceSignedIdentity)
+	return nil
+}
+
+// checkIssuedAt returns an error if issuedAt is more than maxAge before or after now
+func checkIssuedAt(issuedAt time.Time, maxAge time.Duration) error {
+	age := time.Since(issuedAt)
+	switch {
+	case age > maxAge:
+		return fmt.Errorf("identity was signed at %v, more than %v ago", issuedAt.UTC().Format(time.RFC3339), maxAge)
+	case -age > maxAge:
+		return fmt.Errorf("identity was signed at %v, more than %v ahead", issuedAt.UTC().Format(time.RFC3339), maxAge)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+func newTestSigner(t *testing.T, kid string) *vendordata.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := vendordata.NewSigner(kid, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAttestSignedIdentity(t *testing.T) {
+	oldSigner, newSigner := newTestSigner(t, "2019-01"), newTestSigner(t, "2019-07")
+	retiredSigner := newTestSigner(t, "2018-07")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSigner, err := vendordata.NewSigner("2020-01", ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both keys are trusted while rotating from the old key to the new key
+	verifier, err := vendordata.NewVerifier(map[string]crypto.PublicKey{
+		oldSigner.KeyID(): oldSigner.Public(),
+		newSigner.KeyID(): newSigner.Public(),
+		ecSigner.KeyID():  ecSigner.Public(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		signer     *vendordata.Signer
+		instanceID string
+		projectID  string
+		issuedAt   time.Time
+		require    bool
+		wantCode   string
+	}{
+		// 0: signed with the old key
+		{signer: oldSigner, instanceID: testUUID, projectID: testProjectID},
+		// 1: signed with the new key
+		{signer: newSigner, instanceID: testUUID, projectID: testProjectID, require: true},
+		// 2: signed with a retired key
+		{signer: retiredSigner, instanceID: testUUID, projectID: testProjectID, wantCode: reasonSignedIdentityInvalid},
+		// 3: identity of another instance
+		{signer: newSigner, instanceID: "456", projectID: testProjectID, wantCode: reasonSignedIdentityInvalid},
+		// 4: identity of another project
+		{signer: newSigner, instanceID: testUUID, projectID: "def", wantCode: reasonSignedIdentityInvalid},
+		// 5: no identity from older agents
+		{},
+		// 6: no identity while required
+		{require: true, wantCode: reasonSignedIdentityMissing},
+		// 7: signed with an ECDSA key, while the other keys are Ed25519
+		{signer: ecSigner, instanceID: testUUID, projectID: testProjectID, require: true},
+		// 8: signed within signed_identity_max_age
+		{signer: newSigner, instanceID: testUUID, projectID: testProjectID, issuedAt: time.Now().Add(-time.Minute)},
+		// 9: signed before signed_identity_max_age, e.g. replayed
+		{signer: newSigner, instanceID: testUUID, projectID: testProjectID, issuedAt: time.Now().Add(-10 * time.Minute), wantCode: reasonSignedIdentityInvalid},
+		// 10: signed too far in the future
+		{signer: newSigner, instanceID: testUUID, projectID: testProjectID, issuedAt: time.Now().Add(10 * time.Minute), wantCode: reasonSignedIdentityInvalid},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequireSignedIdentity = c.require
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.verifier = verifier
+
+		payload := &common.AttestationPayload{InstanceID: testUUID}
+		if c.signer != nil {
+			id := &vendordata.Identity{
+				InstanceID: c.instanceID,
+				ProjectID:  c.projectID,
+			}
+			if !c.issuedAt.IsZero() {
+				id.IssuedAt = c.issuedAt.Unix()
+			}
+			payload.SignedIdentity, err = c.signer.Sign(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
+
+func TestConfigureRequireSignedIdentityWithoutKeys(t *testing.T) {
+	c := &IIDAttestorPluginConfig{RequireSignedIdentity: true}
+	if _, err := newSignedIdentityVerifier(c); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestParseSignedIdentityMaxAge(t *testing.T) {
+	c := &IIDAttestorPluginConfig{}
+	if err := parseDurations(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.signedIdentityMaxAge != defaultSignedIdentityMaxAge {
+		t.Errorf("got %v, want %v", c.signedIdentityMaxAge, defaultSignedIdentityMaxAge)
+	}
+	c.SignedIdentityMaxAge = "1m"
+	if err := parseDurations(c); err != nil || c.signedIdentityMaxAge != time.Minute {
+		t.Errorf("unexpected result: %v, %v", c.signedIdentityMaxAge, err)
+	}
+	c.SignedIdentityMaxAge = "soon"
+	if err := parseDurations(c); err == nil {
+		t.Error("an error expected for an invalid signed_identity_max_age")
+	}
+}
+
+func TestConfigureSignedIdentityAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "2020-01.pub")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		algorithms []string
+		wantErr    bool
+	}{
+		// 0: all algorithms by default
+		{},
+		// 1: the algorithm of the key accepted
+		{algorithms: []string{vendordata.AlgorithmES256}},
+		// 2: the algorithm of the key not accepted
+		{algorithms: []string{vendordata.AlgorithmEdDSA}, wantErr: true},
+		// 3: unsupported algorithm
+		{algorithms: []string{"HS256"}, wantErr: true},
+	} {
+		_, err := newSignedIdentityVerifier(&IIDAttestorPluginConfig{
+			SignedIdentityKeys:       []*SignedIdentityKeyConfig{{KeyID: "2020-01", PublicKeyFile: path}},
+			SignedIdentityAlgorithms: c.algorithms,
+		})
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: expected error", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestAttestSignedIdentityCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vendordata CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "vendordata signer"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certified, err := vendordata.NewSignerWithChain("2020-06", key, []*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncertified, err := vendordata.NewSigner("2020-06", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CA alone satisfies require_signed_identity
+	verifier, err := newSignedIdentityVerifier(&IIDAttestorPluginConfig{
+		RequireSignedIdentity: true,
+		SignedIdentityCAFile:  caPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tCase := []struct {
+		signer   *vendordata.Signer
+		wantCode string
+	}{
+		// 0: signed with a key certified by the CA
+		{signer: certified},
+		// 1: signed with the same key, without the certificate chain
+		{signer: uncertified, wantCode: reasonSignedIdentityInvalid},
+		// 2: signed with a key certified by another CA
+		{signer: newTestSigner(t, "2020-06"), wantCode: reasonSignedIdentityInvalid},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequireSignedIdentity = true
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.verifier = verifier
+
+		signed, err := c.signer.Sign(&vendordata.Identity{InstanceID: testUUID, ProjectID: testProjectID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := (&common.AttestationPayload{InstanceID: testUUID, SignedIdentity: signed}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+
+	// a missing CA file
+	if _, err := newSignedIdentityVerifier(&IIDAttestorPluginConfig{SignedIdentityCAFile: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// instanceLogFields are the fields of the Nova server document which can be logged.
+// Metadata values, user data and the admin password are deliberately not available.
+var instanceLogFields = map[string]func(s *openstack.Server) interface{}{
+	"id":                func(s *openstack.Server) interface{} { return s.ID },
+	"name":              func(s *openstack.Server) interface{} { return s.Name },
+	"project_id":        func(s *openstack.Server) interface{} { return s.TenantID },
+	"user_id":           func(s *openstack.Server) interface{} { return s.UserID },
+	"status":            func(s *openstack.Server) interface{} { return s.Status },
+	"created":           func(s *openstack.Server) interface{} { return s.Created },
+	"availability_zone": func(s *openstack.Server) interface{} { return s.AvailabilityZone },
+	"region":            func(s *openstack.Server) interface{} { return s.Region },
+	"image_id":          func(s *openstack.Server) interface{} { return s.Image["id"] },
+	"flavor_id":         func(s *openstack.Server) interface{} { return s.Flavor["id"] },
+	"fixed_ips":         func(s *openstack.Server) interface{} { return openstack.FixedIPs(s) },
+	"security_groups": func(s *openstack.Server) interface{} {
+		var names []interface{}
+		for _, sg := range s.SecurityGroups {
+			names = append(names, sg["name"])
+		}
+		return names
+	},
+	"metadata_keys": func(s *openstack.Server) interface{} {
+		var keys []string
+		for k := range s.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	},
+}
+
+func validateInstanceLogFields(fields []string) error {
+	for _, f := range fields {
+		if _, ok := instanceLogFields[f]; !ok {
+			return fmt.Errorf("unknown field in log_instance_fields: %q", f)
+		}
+	}
+	return nil
+}
+
+// instanceDocument returns the selected fields of the server
+func instanceDocument(s *openstack.Server, fields []string) map[string]interface{} {
+	doc := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		doc[f] = instanceLogFields[f](s)
+	}
+	return doc
+}
+
+// logDecision logs the attestation decision with the selected fields of the verified server at debug level
+func (p *IIDAttestorPlugin) logDecision(a *attestation, err error) {
+	if len(p.config.LogInstanceFields) == 0 || !p.logger.IsDebug() {
+		return
+	}
+
+	args := []interface{}{"instance_id", a.instanceID, "admitted", err == nil}
+	if err != nil {
+		args = append(args, "reason", err.Error(), "reason_code", reasonCode(err))
+	}
+	if a.server != nil {
+		args = append(args, "instance", instanceDocument(a.server, p.config.LogInstanceFields))
+	}
+	a.logger.Debug("Attestation decision", args...)
+}
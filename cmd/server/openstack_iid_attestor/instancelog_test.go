@@ -0,0 +1,50 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestInstanceDocument(t *testing.T) {
+	meta := map[string]string{"role": "web", "token": "secret"}
+	sg := []map[string]interface{}{{"name": "default"}}
+	s, err := fake.NewInstance(testProjectID, meta, sg).Get(context.Background(), testUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := instanceDocument(s, []string{"id", "project_id", "security_groups", "metadata_keys"})
+	want := map[string]interface{}{
+		"id":              testUUID,
+		"project_id":      testProjectID,
+		"security_groups": []interface{}{"default"},
+		"metadata_keys":   []string{"role", "token"},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("got %v, want %v", doc, want)
+	}
+	if strings.Contains(fmt.Sprint(doc), "secret") {
+		t.Error("metadata values must not be logged")
+	}
+}
+
+func TestValidateInstanceLogFields(t *testing.T) {
+	if err := validateInstanceLogFields([]string{"id", "fixed_ips"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateInstanceLogFields([]string{"metadata"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
@@ -0,0 +1,268 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+const defaultInventoryExportInterval = time.Minute
+
+// InventoryConfig is the configuration of the inventory of attested instances
+type InventoryConfig struct {
+	// Serves the inventory at /attested of metrics_address if true.
+	Serve bool `hcl:"serve"`
+	// File the inventory is exported to and restored from on startup. Disabled if empty.
+	File string `hcl:"file"`
+	// Interval to export the inventory to the file if it changed, e.g. "1m". Defaults to 1 minute.
+	ExportInterval string `hcl:"export_interval" default:"1m"`
+	exportInterval time.Duration
+}
+
+func validateInventoryConfig(c *IIDAttestorPluginConfig) error {
+	ic := c.Inventory
+	if ic == nil {
+		return nil
+	}
+	if !ic.Serve && ic.File == "" {
+		return errors.New("inventory requires serve or file")
+	}
+	if ic.Serve && c.MetricsAddress == "" {
+		return errors.New("inventory requires metrics_address to serve")
+	}
+	ic.exportInterval = defaultInventoryExportInterval
+	if ic.ExportInterval != "" {
+		d, err := time.ParseDuration(ic.ExportInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid export_interval of inventory: %q", ic.ExportInterval)
+		}
+		ic.exportInterval = d
+	}
+	return nil
+}
+
+// inventoryRecord is an attested instance in the inventory
+type inventoryRecord struct {
+	AgentID         string    `json:"agent_id"`
+	InstanceID      string    `json:"instance_id"`
+	ProjectID       string    `json:"project_id"`
+	Selectors       []string  `json:"selectors"`
+	FirstAttestedAt time.Time `json:"first_attested_at"`
+	LastAttestedAt  time.Time `json:"last_attested_at"`
+	// ID of the last attestation, which correlates the record with the logs
+	LastAttestationID string `json:"last_attestation_id,omitempty"`
+}
+
+// inventoryDocument is the exported form of the inventory
+type inventoryDocument struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Agents      []*inventoryRecord `json:"agents"`
+}
+
+// inventory records the instances admitted by this server for inventory systems.
+// Records are kept across reconfiguration and, with a file, across restarts.
+type inventory struct {
+	logger hclog.Logger
+	file   string
+
+	mtx     sync.Mutex
+	records map[string]*inventoryRecord
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newInventory returns an inventory taking over the records of previous, if any.
+// Without previous, records are restored from the file.
+func newInventory(c *InventoryConfig, previous *inventory, logger hclog.Logger) *inventory {
+	inv := &inventory{
+		logger:  logger,
+		file:    common.ExpandEnv(c.File),
+		records: make(map[string]*inventoryRecord),
+	}
+	if previous != nil {
+		previous.mtx.Lock()
+		inv.records = previous.records
+		inv.dirty = previous.dirty || previous.file != inv.file
+		previous.mtx.Unlock()
+		return inv
+	}
+	if inv.file != "" {
+		if err := inv.load(); err != nil {
+			logger.Warn("Failed to restore inventory", "file", inv.file, "error", err)
+		}
+	}
+	return inv
+}
+
+// record adds or updates the admitted instance of the attestation
+func (inv *inventory) record(a *attestation, now time.Time) {
+	var selectors []string
+	for _, s := range a.selectors {
+		selectors = append(selectors, s.Value)
+	}
+
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+
+	r, ok := inv.records[a.agentID]
+	if !ok {
+		r = &inventoryRecord{
+			AgentID:         a.agentID,
+			InstanceID:      a.instanceID,
+			ProjectID:       a.server.TenantID,
+			FirstAttestedAt: now,
+		}
+		inv.records[a.agentID] = r
+	}
+	r.Selectors = selectors
+	r.LastAttestedAt = now
+	r.LastAttestationID = a.id
+	inv.dirty = true
+}
+
+// document returns the inventory sorted by agent ID
+func (inv *inventory) document(now time.Time) *inventoryDocument {
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+
+	doc := &inventoryDocument{
+		GeneratedAt: now,
+		Agents:      []*inventoryRecord{},
+	}
+	for _, r := range inv.records {
+		copied := *r
+		doc.Agents = append(doc.Agents, &copied)
+	}
+	sort.Slice(doc.Agents, func(i, j int) bool {
+		return doc.Agents[i].AgentID < doc.Agents[j].AgentID
+	})
+	return doc
+}
+
+func (inv *inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv.document(time.Now()))
+}
+
+func (inv *inventory) load() error {
+	b, err := ioutil.ReadFile(inv.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc inventoryDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+	for _, r := range doc.Agents {
+		inv.records[r.AgentID] = r
+	}
+	return nil
+}
+
+// export writes the inventory to the file if it changed since the last export
+func (inv *inventory) export() error {
+	inv.mtx.Lock()
+	dirty := inv.dirty
+	inv.dirty = false
+	inv.mtx.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(inv.document(time.Now()))
+	if err == nil {
+		err = writeFileAtomic(inv.file, b)
+	}
+	if err != nil {
+		inv.mtx.Lock()
+		inv.dirty = true
+		inv.mtx.Unlock()
+	}
+	return err
+}
+
+// start exports the inventory to the file every interval in the background
+func (inv *inventory) start(interval time.Duration) {
+	if inv.file == "" {
+		return
+	}
+	inv.stop = make(chan struct{})
+	inv.done = make(chan struct{})
+
+	go func() {
+		defer close(inv.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			stopped := false
+			select {
+			case <-t.C:
+			case <-inv.stop:
+				stopped = true
+			}
+			if err := inv.export(); err != nil {
+				inv.logger.Warn("Failed to export inventory", "file", inv.file, "error", err)
+			}
+			if stopped {
+				return
+			}
+		}
+	}()
+}
+
+// close stops exporting after a final export
+func (inv *inventory) close() {
+	if inv.stop == nil {
+		return
+	}
+	close(inv.stop)
+	<-inv.done
+	inv.stop = nil
+}
+
+// writeFileAtomic replaces the file atomically so that readers never see a truncated file
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestInventory(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.inventory = newInventory(&InventoryConfig{Serve: true}, nil, testutil.TestLogger())
+
+	for i := 0; i < 2; i++ {
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Fatalf("#%v: unexpected error: %v", i, err)
+		}
+	}
+	p.instance = fake.NewInstance("invalid-project-id", nil, nil)
+	if err := p.Attest(fake.NewAttestStream("456")); err == nil {
+		t.Fatal("an error expected, got nil")
+	}
+
+	rec := httptest.NewRecorder()
+	p.inventory.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/attested", nil))
+	var doc inventoryDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// denied instances are not recorded, and re-attestations update the record
+	if len(doc.Agents) != 1 {
+		t.Fatalf("unexpected agents: %+v", doc.Agents)
+	}
+	r := doc.Agents[0]
+	if r.AgentID != "spiffe://example.com/spire/agent/openstack_iid/abc/123" || r.InstanceID != testUUID || r.ProjectID != testProjectID {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r.FirstAttestedAt.IsZero() || r.LastAttestedAt.Before(r.FirstAttestedAt) {
+		t.Errorf("unexpected timestamps: %+v", r)
+	}
+}
+
+func TestInventoryExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &InventoryConfig{File: filepath.Join(dir, "attested.json")}
+	inv := newInventory(c, nil, testutil.TestLogger())
+	inv.start(time.Hour)
+	inv.record(&attestation{
+		instanceID: "alpha",
+		agentID:    "spiffe://example.com/spire/agent/openstack_iid/bravo/alpha",
+		server:     &openstack.Server{Server: servers.Server{TenantID: "bravo"}},
+	}, time.Unix(100, 0).UTC())
+	// the final export on close persists records not exported yet
+	inv.close()
+
+	restored := newInventory(c, nil, testutil.TestLogger())
+	want := inv.document(time.Time{}).Agents
+	if got := restored.document(time.Time{}).Agents; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateInventoryConfig(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: served at metrics_address
+		{config: &IIDAttestorPluginConfig{MetricsAddress: ":9988", Inventory: &InventoryConfig{Serve: true}}},
+		// 1: exported to a file
+		{config: &IIDAttestorPluginConfig{Inventory: &InventoryConfig{File: "attested.json", ExportInterval: "10s"}}},
+		// 2: nowhere to serve
+		{config: &IIDAttestorPluginConfig{Inventory: &InventoryConfig{Serve: true}}, wantErr: true},
+		// 3: neither served nor exported
+		{config: &IIDAttestorPluginConfig{Inventory: &InventoryConfig{}}, wantErr: true},
+		// 4: invalid interval
+		{config: &IIDAttestorPluginConfig{Inventory: &InventoryConfig{File: "attested.json", ExportInterval: "0s"}}, wantErr: true},
+	} {
+		err := validateInventoryConfig(c.config)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	lifecycleRebuild   = "rebuild"
+	lifecycleResize    = "resize"
+	lifecycleMigration = "migration"
+
+	lifecycleActionAllow    = "allow"
+	lifecycleActionReattest = "reattest"
+	lifecycleActionDeny     = "deny"
+)
+
+// InstanceLifecycleConfig configures how instances rebuilt, resized or migrated since the previous attestation are
+// handled, each "allow", "reattest" or "deny". Events not configured are handled by instance_change_mode.
+type InstanceLifecycleConfig struct {
+	// Rebuild replaces the image of the instance under the same UUID
+	Rebuild string `hcl:"rebuild"`
+	// Resize changes the flavor of the instance
+	Resize string `hcl:"resize"`
+	// Migration moves the instance to another compute host, including live migrations and evacuations
+	Migration string `hcl:"migration"`
+}
+
+var instanceLifecycleEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "instance_lifecycle_events_total",
+	Help:      "Number of rebuilds, resizes and migrations detected on attestation, by event and action.",
+}, []string{"event", "action"})
+
+func init() {
+	telemetry.Registry.MustRegister(instanceLifecycleEvents)
+}
+
+// validateInstanceLifecycle validates instance_lifecycle_policy
+func validateInstanceLifecycle(c *IIDAttestorPluginConfig) error {
+	lc := c.InstanceLifecycle
+	if lc == nil {
+		return nil
+	}
+	for _, e := range []struct{ event, action string }{
+		{lifecycleRebuild, lc.Rebuild},
+		{lifecycleResize, lc.Resize},
+		{lifecycleMigration, lc.Migration},
+	} {
+		switch e.action {
+		case "", lifecycleActionAllow, lifecycleActionReattest, lifecycleActionDeny:
+		default:
+			return fmt.Errorf("unknown %v action of instance_lifecycle_policy: %q", e.event, e.action)
+		}
+	}
+	return nil
+}
+
+// action returns the action of the event, or empty if the event is left to instance_change_mode
+func (lc *InstanceLifecycleConfig) action(event string) string {
+	if lc == nil {
+		return ""
+	}
+	switch event {
+	case lifecycleRebuild:
+		return lc.Rebuild
+	case lifecycleResize:
+		return lc.Resize
+	case lifecycleMigration:
+		return lc.Migration
+	}
+	return ""
+}
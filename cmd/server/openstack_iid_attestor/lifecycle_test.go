@@ -0,0 +1,137 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestInstanceLifecycle(t *testing.T) {
+	agentID := "spiffe://example.com/spire/agent/openstack_iid/" + testProjectID + "/" + testUUID
+
+	h := &recordingHook{}
+	p := newTestPlugin()
+	p.fingerprints = newFingerprintStore(cache.NewMemory(), p.logger)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.config.InstanceLifecycle = &InstanceLifecycleConfig{
+		Rebuild:   lifecycleActionDeny,
+		Resize:    lifecycleActionAllow,
+		Migration: lifecycleActionReattest,
+	}
+	p.hooks = hooks.Hooks{h}
+
+	p.instance = fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.small", "host-1")
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Fatalf("Attestation error: %v", err)
+	}
+
+	// The cases run in order on the same instance, whose fingerprint is updated by admitted attestations
+	tCase := []struct {
+		instance       openstack.InstanceClient
+		attestedBefore func(*IIDAttestorPlugin, context.Context, string) (bool, error)
+		wantCode       string
+		wantEvicted    bool
+	}{
+		// 0: resized
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.large", "host-1"), attestedBefore: onceAttestedBeforeHandler},
+		// 1: migrated, which requires a new agent
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.large", "host-2"), attestedBefore: onceAttestedBeforeHandler, wantCode: reasonReattestRequired, wantEvicted: true},
+		// 2: refused again until the agent is evicted
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.large", "host-2"), attestedBefore: onceAttestedBeforeHandler, wantCode: reasonReattestRequired, wantEvicted: true},
+		// 3: migrated, after the agent was evicted
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.large", "host-2"), attestedBefore: notAttestedBeforeHandler},
+		// 4: migrated agent re-attesting
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.large", "host-2"), attestedBefore: onceAttestedBeforeHandler},
+		// 5: rebuilt
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-2", "m1.large", "host-2"), attestedBefore: onceAttestedBeforeHandler, wantCode: reasonInstanceChanged, wantEvicted: true},
+		// 6: rebuilt, after the agent was evicted
+		{instance: fake.NewInstanceWithPlacement(testProjectID, "image-2", "m1.large", "host-2"), attestedBefore: notAttestedBeforeHandler, wantCode: reasonInstanceChanged},
+	}
+
+	for i, c := range tCase {
+		h.calls = nil
+		p.instance = c.instance
+		p.attestedBeforeHandler = c.attestedBefore
+		fs := fake.NewAttestStream(testUUID)
+		err := p.Attest(fs)
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: Attestation error: %v", i, err)
+				continue
+			}
+			if n := len(fs.Response().Selectors); n != 0 {
+				t.Errorf("#%v: unexpected selectors: %v", i, fs.Response().Selectors)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+		evicted := len(h.calls) == 2 && h.calls[1] == "evicted:"+agentID
+		if evicted != c.wantEvicted {
+			t.Errorf("#%v: got notifications %v, want eviction %v", i, h.calls, c.wantEvicted)
+		}
+	}
+}
+
+func TestAttestInstanceChangeModeIgnoresMigration(t *testing.T) {
+	p := newTestPlugin()
+	p.fingerprints = newFingerprintStore(cache.NewMemory(), p.logger)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.config.InstanceChangeMode = instanceChangeModeDeny
+
+	p.instance = fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.small", "host-1")
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Fatalf("Attestation error: %v", err)
+	}
+
+	// instance_change_mode doesn't cover the host
+	p.instance = fake.NewInstanceWithPlacement(testProjectID, "image-1", "m1.small", "host-2")
+	p.attestedBeforeHandler = onceAttestedBeforeHandler
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+}
+
+func TestValidateInstanceLifecycle(t *testing.T) {
+	tCase := []struct {
+		lifecycle *InstanceLifecycleConfig
+		wantErr   bool
+	}{
+		// 0: none
+		{},
+		// 1: all actions
+		{lifecycle: &InstanceLifecycleConfig{Rebuild: lifecycleActionDeny, Resize: lifecycleActionAllow, Migration: lifecycleActionReattest}},
+		// 2: events left to instance_change_mode
+		{lifecycle: &InstanceLifecycleConfig{Rebuild: lifecycleActionDeny}},
+		// 3: unknown action
+		{lifecycle: &InstanceLifecycleConfig{Migration: "flag"}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		err := validateInstanceLifecycle(&IIDAttestorPluginConfig{InstanceLifecycle: c.lifecycle})
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+
+	var lc *InstanceLifecycleConfig
+	if got := lc.action(lifecycleRebuild); got != "" {
+		t.Errorf("got action %q without instance_lifecycle_policy, want none", got)
+	}
+}
@@ -0,0 +1,74 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+const (
+	localityModeDeny      = "deny"
+	localityModeDownscope = "downscope"
+
+	localityHome    = "home"
+	localityForeign = "foreign"
+)
+
+func validateLocalityConfig(c *IIDAttestorPluginConfig) error {
+	switch c.LocalityMode {
+	case "":
+		c.LocalityMode = localityModeDeny
+	case localityModeDeny, localityModeDownscope:
+	default:
+		return fmt.Errorf("unknown locality_mode: %q", c.LocalityMode)
+	}
+	return nil
+}
+
+// checkLocality compares the location of the instance with the home region and zones.
+// Foreign instances are denied, or admitted with the "locality:foreign" selector in downscope mode.
+func (p *IIDAttestorPlugin) checkLocality(c *IIDAttestorPluginConfig, a *attestation) ([]*spc.Selector, error) {
+	if c.HomeRegion == "" && len(c.HomeAvailabilityZones) == 0 {
+		return nil, nil
+	}
+	s := a.server
+
+	locality := localityHome
+	if c.HomeRegion != "" && s.Region != c.HomeRegion {
+		locality = localityForeign
+	}
+	if len(c.HomeAvailabilityZones) > 0 && !contains(c.HomeAvailabilityZones, s.AvailabilityZone) {
+		locality = localityForeign
+	}
+
+	if locality == localityForeign && c.LocalityMode == localityModeDeny {
+		return nil, deny(reasonLocalityNotAllowed, fmt.Errorf("instance is outside of the home zone: region=%q, zone=%q", s.Region, s.AvailabilityZone))
+	}
+
+	a.logger.Debug("Checked instance locality", "locality", locality, "region", s.Region, "zone", s.AvailabilityZone)
+
+	return []*spc.Selector{
+		{
+			Type:  common.PluginName,
+			Value: fmt.Sprintf("locality:%s", locality),
+		},
+	}, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
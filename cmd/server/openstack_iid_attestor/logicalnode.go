@@ -0,0 +1,65 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/verify"
+)
+
+// validateLogicalNodeConfig compiles logical_node_pattern, which must match logical nodes entirely
+func validateLogicalNodeConfig(c *IIDAttestorPluginConfig) error {
+	if c.LogicalNodePattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + c.LogicalNodePattern + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid logical_node_pattern: %v", err)
+	}
+	c.logicalNodePattern = re
+	return nil
+}
+
+// checkLogicalNode denies logical nodes not matching logical_node_pattern, or any if it is not set, so that agents
+// can't mint agent IDs the operator didn't plan for. It is checked before the denial cache, whose denials are of
+// the instance rather than one of its agents.
+func checkLogicalNode(c *IIDAttestorPluginConfig, payload *common.AttestationPayload) error {
+	node := payload.LogicalNode
+	if node == "" {
+		return nil
+	}
+	if c.logicalNodePattern == nil || !c.logicalNodePattern.MatchString(node) {
+		return deny(reasonLogicalNodeNotAllowed, fmt.Errorf("logical node %q is not allowed", node))
+	}
+	return nil
+}
+
+// logicalNodeSelectors returns the "logical_node:<name>" selector of the agent, if it attested as a logical node
+func logicalNodeSelectors(a *attestation) []*spc.Selector {
+	if a.payload.LogicalNode == "" {
+		return nil
+	}
+	return []*spc.Selector{{
+		Type:  common.PluginName,
+		Value: verify.LogicalNodeSelector(a.payload.LogicalNode),
+	}}
+}
+
+// nodeKey returns the key of the agent of the attestation in the records of agents of instances, the instance ID or
+// "<instance ID>/<logical node>"
+func (a *attestation) nodeKey() string {
+	if a.payload.LogicalNode == "" {
+		return a.instanceID
+	}
+	return a.instanceID + "/" + a.payload.LogicalNode
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestLogicalNode(t *testing.T) {
+	tCase := []struct {
+		pattern       string
+		data          string
+		wantErr       bool
+		wantAgentID   string
+		wantSelectors []string
+	}{
+		// 0: agent of the instance
+		{pattern: "containerd|docker", data: `{"instance_id": "123"}`, wantAgentID: "spiffe://example.com/spire/agent/openstack_iid/abc/123"},
+		// 1: logical node allowed by the pattern
+		{
+			pattern:       "containerd|docker",
+			data:          `{"instance_id": "123", "logical_node": "docker"}`,
+			wantAgentID:   "spiffe://example.com/spire/agent/openstack_iid/abc/123/docker",
+			wantSelectors: []string{"logical_node:docker"},
+		},
+		// 2: the pattern must match the logical node entirely
+		{pattern: "containerd|docker", data: `{"instance_id": "123", "logical_node": "docker-2"}`, wantErr: true},
+		// 3: logical nodes are denied without a pattern
+		{data: `{"instance_id": "123", "logical_node": "docker"}`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.LogicalNodePattern = c.pattern
+		if err := validateLogicalNodeConfig(p.config); err != nil {
+			t.Fatalf("#%v: unexpected error: %v", i, err)
+		}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStreamWithData([]byte(c.data))
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			} else if code := reasonCode(err); code != reasonLogicalNodeNotAllowed {
+				t.Errorf("#%v: got reason %v, want %v", i, code, reasonLogicalNodeNotAllowed)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		if fs.Response().AgentId != c.wantAgentID {
+			t.Errorf("#%v: got agent ID %v, want %v", i, fs.Response().AgentId, c.wantAgentID)
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if !reflect.DeepEqual(got, c.wantSelectors) {
+			t.Errorf("#%v: got selectors %v, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
+
+func TestValidateLogicalNodeConfig(t *testing.T) {
+	if err := validateLogicalNodeConfig(&IIDAttestorPluginConfig{LogicalNodePattern: "containerd|("}); err == nil {
+		t.Errorf("an error expected for an invalid pattern, got nil")
+	}
+}
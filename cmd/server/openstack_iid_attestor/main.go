@@ -11,17 +11,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/hcl"
 	"github.com/spiffe/spire/pkg/common/catalog"
 	"github.com/spiffe/spire/pkg/server/plugin/nodeattestor"
 	nodeattestorbase "github.com/spiffe/spire/pkg/server/plugin/nodeattestor/base"
+	spc "github.com/spiffe/spire/proto/spire/common"
 	spi "github.com/spiffe/spire/proto/spire/common/plugin"
 
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/events"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/health"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/nonce"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
 )
 
 // IIDAttestorPlugin implements the nodeattestor Plugin interface
@@ -31,17 +43,305 @@ type IIDAttestorPlugin struct {
 	logger   hclog.Logger
 	config   *IIDAttestorPluginConfig
 	instance openstack.InstanceClient
+	dns      openstack.DNSClient
+	network  openstack.NetworkClient
+	role     openstack.RoleClient
+	console  openstack.ConsoleClient
+	// bootSecurity is nil unless required_boot_security or boot_security_selectors is set
+	bootSecurity openstack.BootSecurityClient
+	quota        *quotaTracker
+	events       *events.Emitter
+	hooks        hooks.Hooks
+	nonces       *nonce.Manager
+	denials      *denialCache
+	limiter      *limiter
+	breaker      *circuitBreaker
+	metrics      *telemetry.Server
+	prober       *health.Prober
+	verifier     *vendordata.Verifier
+	// hmacKeys is nil unless payload_hmac_key is configured
+	hmacKeys *payloadHMACKeys
+	// encryptionKeys is nil unless payload_encryption_key is configured
+	encryptionKeys payloadEncryptionKeys
+
+	// bundles is nil unless bundle_fingerprint is configured
+	bundles *bundleSource
+
+	// inventory is nil unless inventory is configured
+	inventory *inventory
+
+	// fingerprints is nil unless instance_change_mode or instance_lifecycle_policy is set
+	fingerprints *fingerprintStore
+
+	// projectRates is nil unless project_rate_limit is configured
+	projectRates *projectRateLimiter
+
+	// maintenance is nil unless maintenance is configured. maintenanceMode is 1 in maintenance mode, and accessed
+	// atomically.
+	maintenance        *maintenanceStore
+	maintenanceMode    int32
+	maintenanceSignals sync.Once
+
+	// offline is true in the replay mode, which disables the options with effects outside the plugin
+	offline bool
 
 	mtx *sync.RWMutex
+	// drain tracks the attestations in flight, which hold the read lock, for Shutdown
+	drain    common.Drain
+	stopOnce sync.Once
 
-	getInstanceHandler    func(string, hclog.Logger) (openstack.InstanceClient, error)
-	attestedBeforeHandler func(p *IIDAttestorPlugin, ctx context.Context, agentID string) (bool, error)
+	getInstanceHandler     func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error)
+	getInstanceAtHandler   func(context.Context, string, *openstack.AuthConfig, *openstack.Endpoint, hclog.Logger) (openstack.InstanceClient, error)
+	getDNSHandler          func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.DNSClient, error)
+	getNetworkHandler      func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.NetworkClient, error)
+	getRoleHandler         func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.RoleClient, error)
+	getConsoleHandler      func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.ConsoleClient, error)
+	getBootSecurityHandler func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.BootSecurityClient, error)
+	attestedBeforeHandler  func(p *IIDAttestorPlugin, ctx context.Context, agentID string) (bool, error)
+	probeHandler           func(context.Context, string, *openstack.AuthConfig) error
+
+	checkCapabilitiesHandler func(context.Context, string, *openstack.AuthConfig, []string) (*openstack.CapabilityReport, error)
 }
 
 type IIDAttestorPluginConfig struct {
 	trustDomain        string
 	CloudName          string   `hcl:"cloud_name"`
 	ProjectIDWhitelist []string `hcl:"projectid_whitelist"`
+
+	// Clouds instances are looked up in after cloud_name, e.g. the other regions of a deployment sharing Keystone.
+	// auth applies to all of them.
+	AdditionalClouds []string `hcl:"additional_clouds"`
+	// Timeout to prepare the client of each cloud on configuration, e.g. "10s". Defaults to 10s.
+	CloudTimeout string `hcl:"cloud_timeout" default:"10s"`
+	cloudTimeout time.Duration
+	// If true, configuration fails unless all clouds are usable. Otherwise one usable cloud is enough, and
+	// the others are retried on lookups.
+	RequireAllClouds bool `hcl:"require_all_clouds"`
+	// Fallback Keystone endpoints and regions of clouds, which lookups fail over to while the cloud entry is
+	// unavailable.
+	Failover []*FailoverConfig `hcl:"failover"`
+
+	// Trust domain the cloud is mapped to in a topology of multiple trust domains. If set, it must be the trust
+	// domain of the server.
+	CloudTrustDomain string `hcl:"cloud_trust_domain"`
+	// Trust domains projects are mapped to, by project ID. Instances of projects mapped to other trust domains
+	// than the one of the server are denied.
+	ProjectTrustDomains map[string]string `hcl:"project_trust_domains"`
+
+	// Overrides authentication options of the cloud entry.
+	Auth *openstack.AuthConfig `hcl:"auth"`
+	// If true, instances are retrieved with tokens scoped to each project of projectid_whitelist, so that
+	// the credentials need no admin role. The project hinted by agents is looked up first.
+	// Requires password credentials, since application credentials are bound to a single project.
+	ProjectScoped bool `hcl:"project_scoped"`
+
+	// Region the SPIRE server is homed in. If set, instances in other regions are foreign.
+	HomeRegion string `hcl:"home_region"`
+	// Availability zones the SPIRE server is homed in. If set, instances in other zones are foreign.
+	HomeAvailabilityZones []string `hcl:"home_availability_zones"`
+	// How to handle foreign instances, "deny" or "downscope". Defaults to "deny".
+	LocalityMode string `hcl:"locality_mode" default:"deny"`
+
+	// If true, instance IDs must be UUIDs in the canonical form other than the nil UUID.
+	RequireUUID bool `hcl:"require_uuid"`
+	// UUID versions instance IDs may have, e.g. [4]. Any version if empty.
+	UUIDVersions []int `hcl:"uuid_versions"`
+
+	// Pattern of the logical nodes agents may attest as, e.g. "containerd|docker", which must match them entirely.
+	// Several agents on an instance get distinct agent IDs as logical nodes. Logical nodes are denied if empty.
+	LogicalNodePattern string `hcl:"logical_node_pattern"`
+	logicalNodePattern *regexp.Regexp
+
+	// Instances can be attested only within this duration after creation, e.g. "30m".
+	AttestationWindow string `hcl:"attestation_window"`
+	attestationWindow time.Duration
+	// If true, agents attested before may re-attest with this plugin.
+	CanReattest bool `hcl:"can_reattest"`
+	// Statuses of the instances in Nova which may attest, e.g. "MIGRATING" to admit instances during live migration.
+	// Defaults to "ACTIVE".
+	AllowedInstanceStates []string `hcl:"allowed_instance_states" default:"[\"ACTIVE\"]"`
+
+	// Maximum number of distinct instances per project which may attest. 0 means unlimited.
+	ProjectInstanceQuota int `hcl:"project_instance_quota"`
+	// Maximum number of distinct instances per project which may attest in an hour. 0 means unlimited.
+	ProjectHourlyQuota int `hcl:"project_hourly_quota"`
+
+	// Designate zone in which the DNS record of the instance must resolve to one of its fixed IPs.
+	DNSZone string `hcl:"dns_zone"`
+	// If true, agents get the "hostname:<name>" selector from the Nova name of the instance.
+	HostnameHints bool `hcl:"hostname_hints"`
+
+	// Allowed device_owner values of the instance's ports. A trailing "*" matches any suffix, e.g. "compute:*".
+	AllowedPortDeviceOwners []string `hcl:"allowed_port_device_owners"`
+	// How to handle ports with unexpected device_owner, "deny" or "flag". Defaults to "deny".
+	PortDeviceOwnerMode string `hcl:"port_device_owner_mode" default:"deny"`
+
+	// Keystone roles which must be effectively assigned on the owning project of the instance.
+	RequiredRoles []string `hcl:"required_roles"`
+	// Whose roles are checked, "project" for any user in the owning project or "user" for the creating user.
+	// Defaults to "project".
+	RoleSubject string `hcl:"role_subject" default:"project"`
+	// Duration to cache role assignments, e.g. "5m". Disabled if empty.
+	RoleCacheTTL string `hcl:"role_cache_ttl"`
+	roleCacheTTL time.Duration
+
+	// How to handle instances whose image, flavor or networks changed since the previous attestation,
+	// "deny" or "flag". Disabled if empty.
+	InstanceChangeMode string `hcl:"instance_change_mode"`
+	// How to handle instances rebuilt, resized or migrated since the previous attestation, by event. Overrides
+	// instance_change_mode for the events configured.
+	InstanceLifecycle *InstanceLifecycleConfig `hcl:"instance_lifecycle_policy"`
+
+	// Public keys trusted to verify identities signed by the vendordata signing service, by key ID.
+	// Trust the new key before signing with it and untrust the old one afterwards to rotate signing keys.
+	SignedIdentityKeys []*SignedIdentityKeyConfig `hcl:"signed_identity_key"`
+	// If true, agents must send an identity signed with a trusted key. Otherwise identities are verified if sent.
+	RequireSignedIdentity bool `hcl:"require_signed_identity"`
+	// PEM file of the CA certificates trusted to certify the keys of signed identities carrying a certificate chain,
+	// in addition to signed_identity_key.
+	SignedIdentityCAFile string `hcl:"signed_identity_ca_file"`
+	// Signature algorithms accepted for signed identities, of "EdDSA", "ES256" and "PS256". Defaults to all.
+	SignedIdentityAlgorithms []string `hcl:"signed_identity_algorithms" default:"[\"EdDSA\", \"ES256\", \"PS256\"]"`
+	// Maximum difference between the time identities were signed at and the time of the server, e.g. "5m".
+	// Defaults to 5 minutes.
+	SignedIdentityMaxAge string `hcl:"signed_identity_max_age" default:"5m"`
+	signedIdentityMaxAge time.Duration
+	// Secrets trusted to authenticate attestation payloads with HMAC, by key ID. Secrets may be restricted to the
+	// projects whose instances are provisioned with them. Trust the new secret before provisioning instances with it
+	// and untrust the old one once no instance attests with it to rotate secrets.
+	PayloadHMACKeys []*PayloadHMACKeyConfig `hcl:"payload_hmac_key"`
+	// If true, agents must send a payload authenticated with a trusted secret. Otherwise MACs are verified if sent.
+	RequirePayloadHMAC bool `hcl:"require_payload_hmac"`
+	// If true, MACs must have a nonce, which is accepted only once. Otherwise nonces are checked if sent, so that
+	// agents predating them are still admitted.
+	RequirePayloadHMACNonce bool `hcl:"require_payload_hmac_nonce"`
+	// Maximum difference between the time MACs were issued at and the time of the server, e.g. "5m".
+	// Defaults to 5 minutes.
+	PayloadHMACMaxAge string `hcl:"payload_hmac_max_age" default:"5m"`
+	// Private keys decrypting attestation data agents encrypt with payload_encryption, by key ID. Keep the old key
+	// configured until no agent encrypts to it to rotate keys.
+	PayloadEncryptionKeys []*PayloadEncryptionKeyConfig `hcl:"payload_encryption_key"`
+	// If true, agents must encrypt the attestation data. Otherwise encrypted and plaintext data are accepted.
+	RequirePayloadEncryption bool `hcl:"require_payload_encryption"`
+	// If true, agents must answer a challenge by authenticating a nonce with a trusted secret, which unlike the MAC
+	// of the payload can't be replayed. Agents must enable payload_hmac.
+	ChallengeHMAC bool `hcl:"challenge_hmac"`
+	// If true, the metadata of the instance sent by agents of payload version 2 must match Nova
+	VerifyPayloadMetadata bool `hcl:"verify_payload_metadata"`
+	// If true, the fixed IPs and MAC addresses sent by agents enabling payload_network must be of the Neutron ports
+	// of the instance
+	VerifyPayloadNetwork bool `hcl:"verify_payload_network"`
+	// Challenges agents to write a nonce to the serial console of the instance, which is verified in the console
+	// log from Nova, if set. Agents must enable console_beacon.
+	ConsoleBeacon *ConsoleBeaconConfig `hcl:"console_beacon"`
+	// Verifies the fingerprint of the trust bundle CA sent by agents enabling bundle_fingerprint against the current
+	// trust bundle of the SPIRE Server if set.
+	BundleFingerprint *BundleFingerprintConfig `hcl:"bundle_fingerprint"`
+	// Boot security attributes instances must have, of "trusted_image_certificates", "secure_boot" and "uefi".
+	// Attributes are read from Nova and the image of the instance in Glance.
+	RequiredBootSecurity []string `hcl:"required_boot_security"`
+	// If true, agents get the "boot:<attribute>" selector for each boot security attribute of the instance
+	BootSecuritySelectors bool `hcl:"boot_security_selectors"`
+	// Minimum sum of the weights of the evidence an attestation must verify. Disabled if 0.
+	MinAssuranceLevel int `hcl:"min_assurance_level"`
+	// Weights of evidence overriding the defaults, e.g. { signed_identity = 3 }.
+	AssuranceWeights map[string]int `hcl:"assurance_weights"`
+	assuranceWeights map[string]int
+
+	// Prefixes the values of all selectors with "<cloud_name>:" if "cloud" or "<project ID>:" if "project",
+	// so that selectors of multiple deployments federated into a trust domain don't collide. Disabled if empty.
+	SelectorNamespace string `hcl:"selector_namespace"`
+	// Drops selectors of fields users of the cloud control, e.g. the hostname and the DNS name, violating the
+	// rules. Disabled if not set. Must match selector_sanitization of the resolver.
+	SelectorSanitization *common.SelectorSanitization `hcl:"selector_sanitization"`
+
+	// How to handle failures of Designate, Neutron or Keystone when Nova verified the instance, "deny" to fail
+	// the attestation, "reduce" to admit without their checks and selectors, or "warn" to also add the
+	// "enrichment:degraded" selector. Defaults to "deny".
+	EnrichmentFailureMode string `hcl:"enrichment_failure_mode" default:"deny"`
+	// Deadlines of the queries of each enrichment service, "designate", "neutron" or "keystone", e.g.
+	// { neutron = "5s" }. Services run concurrently, and those without a deadline are bounded by attestation_timeout.
+	EnrichmentTimeouts map[string]string `hcl:"enrichment_timeouts"`
+	enrichmentTimeouts map[string]time.Duration
+
+	// Cache backend shared by the caches of the plugin. Defaults to in-memory.
+	Cache *cache.Config `hcl:"cache"`
+	// Duration to cache instances retrieved from Nova, e.g. "1m". Disabled if empty.
+	InstanceCacheTTL string `hcl:"instance_cache_ttl"`
+	instanceCacheTTL time.Duration
+	// Duration to cache instances not found in Nova, e.g. "30s". Disabled if empty.
+	NegativeCacheTTL string `hcl:"negative_cache_ttl"`
+	negativeCacheTTL time.Duration
+	// Primes the instance cache from an inventory snapshot on configuration if set. Requires instance_cache_ttl.
+	CachePriming *CachePrimingConfig `hcl:"cache_priming"`
+	// Deadline of an attestation including OpenStack API calls, e.g. "30s". Defaults to 30 seconds.
+	AttestationTimeout string `hcl:"attestation_timeout" default:"30s"`
+	attestationTimeout time.Duration
+	// Time attestations in flight are given to finish when the plugin is stopped, e.g. "10s".
+	// Defaults to attestation_timeout.
+	ShutdownTimeout string `hcl:"shutdown_timeout"`
+	shutdownTimeout time.Duration
+
+	// Maximum number of attestations processed concurrently. Others are rejected with ResourceExhausted.
+	// 0 means unlimited.
+	MaxConcurrentAttestations int `hcl:"max_concurrent_attestations"`
+	// Delay agents rejected by max_concurrent_attestations are told to retry after, e.g. "5s". Defaults to 1 second.
+	BusyRetryAfter string `hcl:"busy_retry_after" default:"1s"`
+	busyRetryAfter time.Duration
+	// Limits the rate of attestations of each project, rejecting agents with ResourceExhausted, if set.
+	ProjectRateLimit *ProjectRateLimitConfig `hcl:"project_rate_limit"`
+	// Suspends Nova lookups after consecutive failures, rejecting agents with Unavailable, if set.
+	CircuitBreaker *CircuitBreakerConfig `hcl:"circuit_breaker"`
+	// Re-admits known instances from the cache backend while OpenStack is down for planned maintenance, if set.
+	// Requires can_reattest.
+	Maintenance *MaintenanceConfig `hcl:"maintenance"`
+
+	// Duration to cache denials of instances, e.g. "1m". Disabled if empty.
+	DenialCacheTTL string `hcl:"denial_cache_ttl"`
+	denialCacheTTL time.Duration
+	// Lifetime of the nonces of attestation challenges, e.g. "5m". Defaults to 5 minutes.
+	NonceTTL string `hcl:"nonce_ttl" default:"5m"`
+	nonceTTL time.Duration
+
+	// Address to serve metrics in the Prometheus format at, e.g. ":9988". Disabled if empty.
+	MetricsAddress string `hcl:"metrics_address"`
+	// Interval to probe Keystone and Nova of the cloud, e.g. "1m". Disabled if empty.
+	// The status is reported by metrics and at /healthz of metrics_address.
+	HealthCheckInterval string `hcl:"health_check_interval"`
+	healthCheckInterval time.Duration
+
+	// Fields of the verified Nova server document logged with the attestation decision at debug level.
+	LogInstanceFields []string `hcl:"log_instance_fields"`
+	// Directory to record each attestation to with the responses of OpenStack, for the replay mode. Disabled if empty.
+	RecordFixtures string `hcl:"record_fixtures"`
+
+	// Verifies that the credentials have the least privilege the enabled features need, "warn" or "enforce".
+	// Disabled if empty.
+	CapabilityCheck string `hcl:"capability_check"`
+	// If true, the credentials are expected to hold admin privileges.
+	AdminMode bool `hcl:"admin_mode"`
+
+	// Records the instances attested by this server for inventory systems if set.
+	Inventory *InventoryConfig `hcl:"inventory"`
+
+	// Publishes attestation decisions as CloudEvents if set.
+	Events *events.Config `hcl:"events"`
+	// Hooks notified of attestation decisions, labeled with their type.
+	Hooks []*hooks.Config `hcl:"hook"`
+
+	// Policy options which are evaluated along with, but not enforced instead of, this configuration.
+	// Divergences of decisions are reported for safe rollouts of stricter policies.
+	Candidate *IIDAttestorPluginConfig `hcl:"candidate"`
+	// Enforces the candidate on part of the attestations if set. Valid in the candidate only.
+	Canary *CanaryConfig `hcl:"canary"`
+	// If true, attestations are verified and reported as usual, but every agent is denied with OBSERVE_ONLY, to
+	// evaluate the plugin against production traffic before trusting it. Not valid in the candidate.
+	ObserveOnly bool `hcl:"observe_only"`
+}
+
+// needs returns true if the configuration or its candidate satisfies f
+func (c *IIDAttestorPluginConfig) needs(f func(*IIDAttestorPluginConfig) bool) bool {
+	return f(c) || (c.Candidate != nil && f(c.Candidate))
 }
 
 // BuiltIn constructs a catalog Plugin using a new instance of this plugin.
@@ -55,14 +355,33 @@ func builtin(p *IIDAttestorPlugin) catalog.Plugin {
 
 func New() *IIDAttestorPlugin {
 	return &IIDAttestorPlugin{
-		mtx:                   &sync.RWMutex{},
-		getInstanceHandler:    getOpenStackInstance,
-		attestedBeforeHandler: attestedBefore,
+		quota:                  newQuotaTracker(),
+		mtx:                    &sync.RWMutex{},
+		getInstanceHandler:     getOpenStackInstance,
+		getInstanceAtHandler:   getOpenStackInstanceAt,
+		getDNSHandler:          getOpenStackDNS,
+		getNetworkHandler:      getOpenStackNetwork,
+		getRoleHandler:         getOpenStackRole,
+		getConsoleHandler:      getOpenStackConsole,
+		getBootSecurityHandler: getOpenStackBootSecurity,
+		attestedBeforeHandler:  attestedBefore,
+		probeHandler:           openstack.Probe,
+
+		checkCapabilitiesHandler: openstack.CheckCapabilities,
 	}
 }
 
 func (p *IIDAttestorPlugin) Attest(stream nodeattestor.NodeAttestor_AttestServer) error {
-	p.logger.Info("Received attestation request")
+	// The ID correlates the log lines, the decision and the OpenStack requests of the attestation
+	attestationID := openstack.NewRequestID()
+	p.logger.Info("Received attestation request", "attestation_id", attestationID)
+
+	streamCtx, done, ok := p.drain.Enter(stream.Context())
+	if !ok {
+		recordDecision(errShuttingDown)
+		return errShuttingDown
+	}
+	defer done()
 
 	p.mtx.RLock()
 	defer p.mtx.RUnlock()
@@ -71,65 +390,558 @@ func (p *IIDAttestorPlugin) Attest(stream nodeattestor.NodeAttestor_AttestServer
 		return errors.New("plugin not configured")
 	}
 
-	req, err := stream.Recv()
+	// The deadline keeps hung OpenStack calls or silent agents from pinning the attestation
+	// and the read lock, which would block reconfiguration.
+	ctx, cancel := context.WithTimeout(openstack.WithRequestID(streamCtx, attestationID), p.config.attestationTimeout)
+	defer cancel()
+
+	var req *nodeattestor.AttestRequest
+	err := common.CallWithContext(ctx, func() (err error) {
+		req, err = stream.Recv()
+		return err
+	})
 	if err != nil {
+		recordDecision(&transientError{code: reasonAttestationIncomplete, err: err})
 		return err
 	}
 
-	iid := string(req.AttestationData.Data)
-	s, err := p.instance.Get(iid)
+	data := req.AttestationData.Data
+	payload, err := p.parseAttestationData(data)
+	if err == nil && len(payload.Versions) > 0 {
+		data, payload, err = p.negotiatePayloadVersion(ctx, stream, data, payload)
+	}
 	if err != nil {
-		return fmt.Errorf("your IID is invalid: %v", err)
+		recordDecision(err)
+		return err
+	}
+	recordPayloadVersion(payload)
+	a := p.newAttestation(attestationID, payload)
+	if unknown := payload.UnknownFields(); len(unknown) > 0 {
+		a.logger.Debug("Attestation payload has fields unknown to this version", "fields", strings.Join(unknown, ","))
 	}
 
-	p.logger.Debug("Got instance data successfully")
+	attestCtx, fixture := p.startFixture(ctx, data)
+	maintenance := p.inMaintenance()
+	release, err := p.limiter.acquire()
+	if err == nil {
+		if maintenance {
+			err = p.attestInMaintenance(attestCtx, a)
+		} else {
+			err = p.attest(attestCtx, a)
+		}
+		release()
+	}
+	if err == nil && p.config.ChallengeHMAC {
+		err = p.verifyHMACChallenge(ctx, stream, a)
+	}
+	// The console log can't be read during maintenance
+	if err == nil && p.config.ConsoleBeacon != nil && !maintenance {
+		// The beacon is verified after releasing the concurrency limit, since it waits for the agent and the console
+		err = p.verifyConsoleBeacon(ctx, stream, a)
+	}
+	if err == nil {
+		err = p.checkAssurance(a)
+	}
+	if err == nil {
+		err = p.recordQuota(a)
+	}
+	p.saveFixture(fixture, a, err)
+	p.notifyDecision(a, err)
+	p.logDecision(a, err)
+	if p.config.ObserveOnly {
+		err = observe(a, err)
+	}
+	recordDecision(err)
+	if err != nil {
+		return err
+	}
 
-	agentID := common.GenerateSpiffeID(p.config.trustDomain, s.TenantID, iid)
+	err = common.CallWithContext(ctx, func() error {
+		return stream.Send(&nodeattestor.AttestResponse{
+			AgentId:   a.agentID,
+			Selectors: a.selectors,
+		})
+	})
+	if err == nil && p.inventory != nil {
+		p.inventory.record(a, time.Now())
+	}
+	if err == nil && p.maintenance != nil && !maintenance {
+		p.maintenance.remember(a)
+	}
+	return err
+}
 
-	attested, err := p.attestedBeforeHandler(p, stream.Context(), agentID)
-	switch {
-	case err != nil:
-		return err
-	case attested:
-		return fmt.Errorf("IID has already been used to attest an agent: %v", iid)
+// attestation holds the state of an attestation request
+type attestation struct {
+	// id is the global request ID of the OpenStack requests of the attestation
+	id string
+	// logger tags the log lines of the attestation with its ID
+	logger     hclog.Logger
+	instanceID string
+	payload    *common.AttestationPayload
+	server     *openstack.Server
+	agentID    string
+	selectors  []*spc.Selector
+	// changes of the instance since the previous attestation
+	changes []string
+	// enrichment services skipped because they were unavailable
+	degraded []string
+	// agent ID of the instance if it attested before
+	attestedAgentID string
+	// evidence verified so far, which makes the assurance level
+	evidence []string
+	// boot security attributes of the instance, once retrieved
+	bootSecurity *openstack.BootSecurity
+	// configuration which decided the attestation, whose quotas count the instance once the agent is admitted
+	config *IIDAttestorPluginConfig
+}
+
+// newAttestation returns the attestation of the payload with the ID, whose OpenStack requests must be made with a
+// context of openstack.WithRequestID
+func (p *IIDAttestorPlugin) newAttestation(id string, payload *common.AttestationPayload) *attestation {
+	return &attestation{
+		id:         id,
+		logger:     p.logger.With("attestation_id", id),
+		instanceID: payload.InstanceID,
+		payload:    payload,
 	}
+}
 
-	for _, pid := range p.config.ProjectIDWhitelist {
-		if s.TenantID == pid {
-			resp := &nodeattestor.AttestResponse{
-				AgentId: agentID,
+// attest verifies the instance and fills the attestation with the agent ID and selectors
+func (p *IIDAttestorPlugin) attest(ctx context.Context, a *attestation) (err error) {
+	iid := a.instanceID
+	if err := checkInstanceUUID(p.config, iid); err != nil {
+		return err
+	}
+	if err := checkProjectHint(p.config, a.payload); err != nil {
+		return err
+	}
+	if err := checkLogicalNode(p.config, a.payload); err != nil {
+		return err
+	}
+	if p.denials != nil {
+		if denial, ok := p.denials.lookup(iid); ok {
+			a.logger.Debug("Rejecting with cached denial", "instance_id", iid)
+			return denial
+		}
+		defer func() {
+			if err != nil && isCacheable(err) {
+				p.denials.store(iid, err)
 			}
-			return stream.Send(resp)
+		}()
+	}
+
+	if err := p.breaker.allow(); err != nil {
+		return err
+	}
+	s, err := p.instance.Get(openstack.WithProjectHint(ctx, a.payload.ProjectID), iid)
+	p.breaker.record(err == nil || openstack.IsNotFound(err))
+	if err != nil {
+		if !openstack.IsNotFound(err) {
+			return transient(fmt.Errorf("your IID is invalid: %v", err))
+		}
+		return deny(reasonInstanceNotFound, fmt.Errorf("your IID is invalid: %v", err))
+	}
+	a.server = s
+	a.addEvidence(evidenceInstanceLookup)
+
+	a.logger.Debug("Got instance data successfully")
+
+	if err := p.projectRates.allow(s.TenantID); err != nil {
+		return err
+	}
+
+	if err := p.checkSignedIdentity(a); err != nil {
+		return err
+	}
+	if err := p.checkPayloadHMAC(a); err != nil {
+		return err
+	}
+	if err := p.checkPayloadMetadata(a); err != nil {
+		return err
+	}
+	if err := p.checkPayloadNetwork(ctx, a); err != nil {
+		return err
+	}
+	if err := p.checkBundleFingerprint(ctx, a); err != nil {
+		return err
+	}
+
+	agentID := common.GenerateLogicalNodeSpiffeID(p.config.trustDomain, s.TenantID, iid, a.payload.LogicalNode)
+
+	attested, err := p.attestedBeforeHandler(p, ctx, agentID)
+	if err != nil {
+		return &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	if attested {
+		a.attestedAgentID = agentID
+	}
+
+	enforced := p.config
+	if c := p.config.Candidate; c != nil {
+		// Canary instances are decided by the candidate, while the outer configuration is evaluated in its place
+		canary := c.Canary.covers(iid, time.Now())
+		shadow := c
+		if canary {
+			enforced, shadow = c, p.config
 		}
+		shadowSelectors, shadowErr := p.evaluate(ctx, shadow, a, attested, false)
+		defer func() {
+			decided := &outcome{selectors: a.selectors, err: err}
+			evaluated := &outcome{selectors: shadowSelectors, err: shadowErr}
+			if canary {
+				p.reportDivergence(a, evaluated, decided, true)
+				return
+			}
+			p.reportDivergence(a, decided, evaluated, false)
+		}()
+	}
+
+	selectors, err := p.evaluate(ctx, enforced, a, attested, true)
+	if err != nil {
+		return err
+	}
+	changeSelectors, err := p.checkInstanceChanges(a, attested)
+	if err != nil {
+		return err
 	}
+	selectors = append(selectors, namespaceSelectors(p.config, a.server, changeSelectors)...)
+	selectors = append(selectors, namespaceSelectors(p.config, a.server, logicalNodeSelectors(a))...)
 
-	return errors.New("invalid attestation request")
+	a.agentID = agentID
+	a.selectors = selectors
+	a.config = enforced
+	return nil
 }
 
 func (p *IIDAttestorPlugin) Configure(ctx context.Context, req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
 	config := &IIDAttestorPluginConfig{}
-	if err := hcl.Decode(config, req.Configuration); err != nil {
+	if err := common.DecodeConfig(config, req.Configuration); err != nil {
 		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
 	}
+	if p.offline {
+		disableSideEffects(config)
+	}
 	if req.GlobalConfig == nil {
 		return nil, errors.New("global configuration is required")
 	}
 	if req.GlobalConfig.TrustDomain == "" {
 		return nil, errors.New("trust_domain is required")
 	}
-	if len(config.ProjectIDWhitelist) == 0 {
-		return nil, errors.New("projectid_whitelist is required")
+	if err := validatePolicy(config); err != nil {
+		return nil, err
+	}
+	if err := parseDurations(config); err != nil {
+		return nil, err
+	}
+	if err := validateInstanceLogFields(config.LogInstanceFields); err != nil {
+		return nil, err
+	}
+	if err := validateUUIDPolicy(config); err != nil {
+		return nil, err
+	}
+	if err := validateRecordFixtures(config); err != nil {
+		return nil, err
+	}
+	if err := validateCapabilityCheck(config); err != nil {
+		return nil, err
+	}
+	if err := validateInstanceChangeMode(config); err != nil {
+		return nil, err
+	}
+	if err := validateInstanceLifecycle(config); err != nil {
+		return nil, err
+	}
+	if err := validateInventoryConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateProjectScoped(config); err != nil {
+		return nil, err
+	}
+	if err := validateClouds(config); err != nil {
+		return nil, err
+	}
+	if err := validateFailover(config); err != nil {
+		return nil, err
+	}
+	if err := validateBackpressureConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateConsoleBeaconConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateBundleFingerprintConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateAssuranceConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateMaintenanceConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateCachePrimingConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateCanaryConfig(config); err != nil {
+		return nil, err
+	}
+	if err := validateObserveOnly(config); err != nil {
+		return nil, err
+	}
+	if err := validateTrustDomainMapping(config, req.GlobalConfig.TrustDomain); err != nil {
+		return nil, err
+	}
+	if c := config.Candidate; c != nil {
+		if c.Candidate != nil {
+			return nil, errors.New("candidate configuration must not be nested")
+		}
+		// The candidate evaluates the same cloud, which names the selector namespace
+		c.CloudName = config.CloudName
+		if err := validatePolicy(c); err != nil {
+			return nil, fmt.Errorf("invalid candidate configuration: %v", err)
+		}
+		if err := parseDurations(c); err != nil {
+			return nil, fmt.Errorf("invalid candidate configuration: %v", err)
+		}
+		if err := validateTrustDomainMapping(c, req.GlobalConfig.TrustDomain); err != nil {
+			return nil, fmt.Errorf("invalid candidate configuration: %v", err)
+		}
+		c.trustDomain = req.GlobalConfig.TrustDomain
+	}
+	if err := p.checkCapabilities(ctx, config); err != nil {
+		return nil, err
+	}
+	verifier, err := newSignedIdentityVerifier(config)
+	if err != nil {
+		return nil, err
+	}
+	hmacKeys, err := newPayloadHMACKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKeys, err := newPayloadEncryptionKeys(config)
+	if err != nil {
+		return nil, err
 	}
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	instance, err := p.getInstanceHandler(config.CloudName, p.logger)
+	var instance openstack.InstanceClient
+	if config.ProjectScoped {
+		// Scoped tokens are obtained on first lookup in each project and kept for later ones
+		cloud, auth, getInstance := config.CloudName, config.Auth, p.getInstanceHandler
+		instance = openstack.NewProjectScopedInstance(config.ProjectIDWhitelist, func(ctx context.Context, projectID string) (openstack.InstanceClient, error) {
+			return getInstance(ctx, cloud, auth.ScopedTo(projectID), p.logger)
+		}, p.logger)
+	} else {
+		instance, err = p.prepareInstances(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err := cache.New(config.Cache)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare OpenStack Client: %v", err)
+		return nil, fmt.Errorf("failed to prepare cache: %v", err)
+	}
+	lister, canList := instance.(openstack.InstanceLister)
+	if config.CachePriming != nil && !canList {
+		return nil, errors.New("cache_priming requires an OpenStack Client listing instances")
+	}
+	if config.instanceCacheTTL > 0 || config.negativeCacheTTL > 0 {
+		instance = openstack.NewCachedInstance(instance, c, config.instanceCacheTTL, config.negativeCacheTTL, p.logger)
+	}
+	var primed *openstack.CachedInstance
+	if config.CachePriming != nil {
+		primed = instance.(*openstack.CachedInstance)
+	}
+
+	var dns openstack.DNSClient
+	if config.needs(func(c *IIDAttestorPluginConfig) bool { return c.DNSZone != "" }) {
+		dns, err = p.getDNSHandler(ctx, config.CloudName, config.Auth, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare OpenStack DNS Client: %v", err)
+		}
+	}
+
+	var network openstack.NetworkClient
+	if config.needs(needsPorts) {
+		network, err = p.getNetworkHandler(ctx, config.CloudName, config.Auth, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare OpenStack Network Client: %v", err)
+		}
+	}
+
+	var role openstack.RoleClient
+	if config.needs(func(c *IIDAttestorPluginConfig) bool { return len(c.RequiredRoles) > 0 }) {
+		role, err = p.getRoleHandler(ctx, config.CloudName, config.Auth, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare OpenStack Identity Client: %v", err)
+		}
+		if config.roleCacheTTL > 0 {
+			role = openstack.NewCachedRole(role, c, config.roleCacheTTL, p.logger)
+		}
+	}
+
+	var console openstack.ConsoleClient
+	if config.ConsoleBeacon != nil {
+		console, err = p.getConsoleHandler(ctx, config.CloudName, config.Auth, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare OpenStack Console Client: %v", err)
+		}
+	}
+
+	var bootSecurity openstack.BootSecurityClient
+	if config.needs(needsBootSecurity) {
+		bootSecurity, err = p.getBootSecurityHandler(ctx, config.CloudName, config.Auth, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare OpenStack Boot Security Client: %v", err)
+		}
+	}
+
+	// The components running in the background are all prepared before any of the running ones is replaced, so that a
+	// failed configuration leaves the plugin as it was. Only the ones prepared are closed on failure.
+	var bundles *bundleSource
+	if config.BundleFingerprint != nil {
+		bundles, err = newBundleSource(config.BundleFingerprint, p.logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hs hooks.Hooks
+	for _, hc := range config.Hooks {
+		h, err := hooks.New(hc, p.logger)
+		if err != nil {
+			hs.Close()
+			closeBundleSource(bundles)
+			return nil, fmt.Errorf("failed to prepare hook: %v", err)
+		}
+		hs = append(hs, h)
+	}
+	var emitter *events.Emitter
+	if config.Events != nil {
+		emitter, err = events.NewEmitter(config.Events, p.logger)
+		if err != nil {
+			hs.Close()
+			closeBundleSource(bundles)
+			return nil, fmt.Errorf("failed to prepare event emitter: %v", err)
+		}
+		// The hook closes the emitter, which divergence events share
+		hs = append(hs, hooks.NewEventHook(emitter))
+	}
+
+	handlers := make(map[string]http.Handler)
+	var prober *health.Prober
+	if config.healthCheckInterval > 0 {
+		probes := make(map[string]health.ProbeFunc)
+		for _, cloud := range config.clouds() {
+			cloud, auth := cloud, config.Auth
+			probes[cloud] = func(ctx context.Context) error {
+				return p.probeHandler(ctx, cloud, auth)
+			}
+		}
+		prober = health.NewProber(probes, config.healthCheckInterval, config.attestationTimeout, p.logger)
+		handlers["/healthz"] = prober
+	}
+	var inv *inventory
+	if config.Inventory != nil {
+		inv = newInventory(config.Inventory, p.inventory, p.logger)
+		if config.Inventory.Serve {
+			handlers["/attested"] = inv
+		}
+	}
+	// The metrics server is kept while its address doesn't change, since a new one couldn't listen on the address
+	var metrics *telemetry.Server
+	switch {
+	case config.MetricsAddress == "":
+	case p.metrics != nil && p.config != nil && p.config.MetricsAddress == config.MetricsAddress:
+		metrics = p.metrics
+	default:
+		metrics, err = telemetry.Serve(config.MetricsAddress, handlers, p.logger)
+		if err != nil {
+			hs.Close()
+			closeBundleSource(bundles)
+			return nil, fmt.Errorf("failed to serve metrics: %v", err)
+		}
 	}
 
+	// Nothing fails from here on, so the running components are replaced
+	p.hooks.Close()
+	if p.prober != nil {
+		p.prober.Stop()
+	}
+	if prober != nil {
+		prober.Start()
+	}
+	p.prober = prober
+	if p.inventory != nil {
+		p.inventory.close()
+	}
+	if inv != nil {
+		inv.start(config.Inventory.exportInterval)
+	}
+	p.inventory = inv
+	switch {
+	case metrics != nil && metrics == p.metrics:
+		metrics.SetHandlers(handlers)
+	case p.metrics != nil:
+		p.metrics.Close()
+	}
+	p.metrics = metrics
+	closeBundleSource(p.bundles)
+	p.bundles = bundles
+	if primed != nil {
+		// Priming runs in the background, so that listing a large fleet doesn't delay the start of the server
+		go p.primeInstanceCache(primed, lister, config.CachePriming)
+	}
+
+	if config.RecordFixtures != "" {
+		// Recording wraps the clients last, so that fixtures hold what the verification saw, including cached responses
+		instance = openstack.RecordInstance(instance)
+		if dns != nil {
+			dns = openstack.RecordDNS(dns)
+		}
+		if network != nil {
+			network = openstack.RecordNetwork(network)
+		}
+		if role != nil {
+			role = openstack.RecordRole(role)
+		}
+		if bootSecurity != nil {
+			bootSecurity = openstack.RecordBootSecurity(bootSecurity)
+		}
+	}
 	p.instance = instance
+	p.dns = dns
+	p.network = network
+	p.role = role
+	p.console = console
+	p.bootSecurity = bootSecurity
+	p.verifier = verifier
+	p.hmacKeys = hmacKeys
+	p.encryptionKeys = encryptionKeys
+	p.events = emitter
+	p.hooks = hs
+	p.nonces = nonce.NewManager(c, config.nonceTTL)
+	p.limiter = newLimiter(config.MaxConcurrentAttestations, config.busyRetryAfter)
+	p.projectRates = newProjectRateLimiter(config.ProjectRateLimit)
+	p.breaker = newCircuitBreaker(config.CircuitBreaker, p.logger)
+	p.denials = nil
+	if config.denialCacheTTL > 0 {
+		p.denials = newDenialCache(c, config.denialCacheTTL, req.Configuration, p.logger)
+	}
+	p.fingerprints = nil
+	if config.InstanceChangeMode != "" || config.InstanceLifecycle != nil {
+		p.fingerprints = newFingerprintStore(c, p.logger)
+	}
+	p.maintenance = nil
+	if config.Maintenance != nil {
+		p.maintenance = newMaintenanceStore(c, config.Maintenance.retention, p.logger)
+		p.maintenanceSignals.Do(p.watchMaintenanceSignals)
+	}
+	// Reconfiguration switches the mode as configured, whatever signals switched it to
+	p.setMaintenanceMode(config.Maintenance != nil && config.Maintenance.Enabled)
 	config.trustDomain = req.GlobalConfig.TrustDomain
 	p.config = config
 
@@ -146,12 +958,71 @@ func attestedBefore(p *IIDAttestorPlugin, ctx context.Context, agentID string) (
 }
 
 // getOpenStackInstance returns authenticated openstack compute client.
-func getOpenStackInstance(cloud string, logger hclog.Logger) (openstack.InstanceClient, error) {
-	provider, err := openstack.NewProvider(cloud)
+func getOpenStackInstance(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewInstance(provider, openstack.GetRegion(cloud), logger)
+}
+
+// getOpenStackInstanceAt returns openstack compute client authenticated at the Keystone endpoint in the region of given
+// endpoint, each defaulting to the one of the cloud entry.
+func getOpenStackInstanceAt(ctx context.Context, cloud string, auth *openstack.AuthConfig, endpoint *openstack.Endpoint, logger hclog.Logger) (openstack.InstanceClient, error) {
+	provider, err := openstack.NewProviderAt(ctx, cloud, auth, endpoint.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+	region := endpoint.Region
+	if region == "" {
+		region = openstack.GetRegion(cloud)
+	}
+	return openstack.NewInstance(provider, region, logger)
+}
+
+// getOpenStackDNS returns authenticated openstack dns client.
+func getOpenStackDNS(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.DNSClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewDNS(provider, openstack.GetRegion(cloud), logger)
+}
+
+// getOpenStackRole returns authenticated openstack identity client for role assignments.
+func getOpenStackRole(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.RoleClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
 	if err != nil {
 		return nil, err
 	}
-	return openstack.NewInstance(provider, logger)
+	return openstack.NewRole(provider, openstack.GetRegion(cloud), logger)
+}
+
+// getOpenStackConsole returns authenticated openstack compute client for console logs.
+func getOpenStackConsole(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.ConsoleClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewConsole(provider, openstack.GetRegion(cloud), logger)
+}
+
+// getOpenStackBootSecurity returns authenticated openstack compute and image clients for boot security attributes.
+func getOpenStackBootSecurity(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.BootSecurityClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewBootSecurity(provider, openstack.GetRegion(cloud), logger)
+}
+
+// getOpenStackNetwork returns authenticated openstack network client.
+func getOpenStackNetwork(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.NetworkClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewNetwork(provider, openstack.GetRegion(cloud), logger)
 }
 
 func (p *IIDAttestorPlugin) SetLogger(log hclog.Logger) {
@@ -159,5 +1030,21 @@ func (p *IIDAttestorPlugin) SetLogger(log hclog.Logger) {
 }
 
 func main() {
-	catalog.PluginMain(BuiltIn())
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replayMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-test" {
+		os.Exit(selfTestMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-evidence" {
+		os.Exit(verifyEvidenceMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(schemaMain(os.Args[2:]))
+	}
+	p := New()
+	p.watchShutdownSignals()
+	catalog.PluginMain(builtin(p))
+	// PluginMain returns once SPIRE stops the plugin
+	p.stop()
 }
@@ -10,11 +10,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/hashicorp/go-hclog"
+	"github.com/spiffe/spire/pkg/server/plugin/nodeattestor"
 	"github.com/spiffe/spire/proto/spire/common/plugin"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
@@ -39,8 +47,11 @@ var (
 func newTestPlugin() *IIDAttestorPlugin {
 	return &IIDAttestorPlugin{
 		config: &IIDAttestorPluginConfig{
-			trustDomain: "example.com",
+			trustDomain:          "example.com",
+			attestationTimeout:   defaultAttestationTimeout,
+			signedIdentityMaxAge: defaultSignedIdentityMaxAge,
 		},
+		quota:  newQuotaTracker(),
 		mtx:    &sync.RWMutex{},
 		logger: testutil.TestLogger(),
 	}
@@ -56,7 +67,7 @@ func onceAttestedBeforeHandler(p *IIDAttestorPlugin, ctx context.Context, agentI
 
 func TestConfigure(t *testing.T) {
 	p := newTestPlugin()
-	p.getInstanceHandler = func(n string, logger hclog.Logger) (openstack.InstanceClient, error) {
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
 		return fake.NewInstance(testProjectID, nil, nil), nil
 	}
 	p.attestedBeforeHandler = notAttestedBeforeHandler
@@ -70,9 +81,46 @@ func TestConfigure(t *testing.T) {
 	}
 }
 
+func TestConfigureJSON(t *testing.T) {
+	for i, conf := range []string{
+		// 0: HCL
+		`cloud_name = "test"
+		projectid_whitelist = ["alpha", "bravo"]
+		candidate {
+			projectid_whitelist = ["alpha"]
+			attestation_window = "10m"
+		}`,
+		// 1: JSON
+		`{"cloud_name": "test", "projectid_whitelist": ["alpha", "bravo"], "candidate": {"projectid_whitelist": ["alpha"], "attestation_window": "10m"}}`,
+		// 2: JSON after whitespace, as indented in server.conf
+		`
+		 {
+			"cloud_name": "test",
+			"projectid_whitelist": ["alpha", "bravo"],
+			"candidate": {"projectid_whitelist": ["alpha"], "attestation_window": "10m"}
+		}`,
+	} {
+		p := newTestPlugin()
+		p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+
+		if _, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf)); err != nil {
+			t.Errorf("#%v: error from Configure(): %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.config.ProjectIDWhitelist, []string{"alpha", "bravo"}) {
+			t.Errorf("#%v: unexpected projectid_whitelist: %v", i, p.config.ProjectIDWhitelist)
+		}
+		if c := p.config.Candidate; c == nil || c.attestationWindow != 10*time.Minute {
+			t.Errorf("#%v: unexpected candidate configuration: %+v", i, c)
+		}
+	}
+}
+
 func TestConfigureError(t *testing.T) {
 	p := newTestPlugin()
-	p.getInstanceHandler = func(n string, logger hclog.Logger) (openstack.InstanceClient, error) {
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
 		return fake.NewInstance(testProjectID, nil, nil), nil
 	}
 	p.attestedBeforeHandler = notAttestedBeforeHandler
@@ -88,7 +136,7 @@ func TestConfigureError(t *testing.T) {
 
 func TestConfigureEmptyProjectID(t *testing.T) {
 	p := newTestPlugin()
-	p.getInstanceHandler = func(n string, logger hclog.Logger) (openstack.InstanceClient, error) {
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
 		return fake.NewInstance(testProjectID, nil, nil), nil
 	}
 	p.attestedBeforeHandler = notAttestedBeforeHandler
@@ -109,6 +157,24 @@ func TestConfigureEmptyProjectID(t *testing.T) {
 	}
 }
 
+func TestConfigureHostnameHintsWithoutDNSZone(t *testing.T) {
+	p := newTestPlugin()
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+		return fake.NewInstance(testProjectID, nil, nil), nil
+	}
+
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["alpha"]
+	hostname_hints = true
+	`
+
+	req := fake.NewFakeConfigureRequest(globalConfig, conf)
+	if _, err := p.Configure(context.Background(), req); err == nil {
+		t.Error("expected error for hostname_hints without dns_zone, got nil")
+	}
+}
+
 func TestAttest(t *testing.T) {
 	fi := fake.NewInstance(testProjectID, nil, nil)
 
@@ -124,6 +190,39 @@ func TestAttest(t *testing.T) {
 	}
 }
 
+func TestAttestPayload(t *testing.T) {
+	tCase := []struct {
+		data    string
+		wantErr bool
+	}{
+		// 0: payload of newer agents with unknown fields
+		{data: `{"instance_id": "123", "future_field": true}`},
+		// 1: known fields are validated strictly
+		{data: `{"instance_id": ["123"]}`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStreamWithData([]byte(c.data))
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+		} else if want := "spiffe://example.com/spire/agent/openstack_iid/abc/123"; fs.Response().AgentId != want {
+			t.Errorf("#%v: got %v, want %v", i, fs.Response().AgentId, want)
+		}
+	}
+}
+
 func TestAttestInvalidUUID(t *testing.T) {
 	errMsg := "invalid uuid"
 	fi := fake.NewErrorInstance(errMsg)
@@ -175,3 +274,499 @@ func TestAttestBefore(t *testing.T) {
 		t.Errorf("unexpected error messsage: %v", err)
 	}
 }
+
+func TestAttestLocality(t *testing.T) {
+	tCase := []struct {
+		region        string
+		zone          string
+		mode          string
+		wantErr       bool
+		wantSelectors int
+	}{
+		// 0: instance in home zone
+		{region: "region-a", zone: "zone-1", mode: localityModeDeny, wantSelectors: 1},
+		// 1: instance in foreign zone is denied
+		{region: "region-a", zone: "zone-2", mode: localityModeDeny, wantErr: true},
+		// 2: instance in foreign region is denied
+		{region: "region-b", zone: "zone-1", mode: localityModeDeny, wantErr: true},
+		// 3: instance in foreign zone is down-scoped
+		{region: "region-a", zone: "zone-2", mode: localityModeDownscope, wantSelectors: 1},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithZone(testProjectID, c.region, c.zone)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.HomeRegion = "region-a"
+		p.config.HomeAvailabilityZones = []string{"zone-1"}
+		p.config.LocalityMode = c.mode
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStream(testUUID)
+
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		if got := len(fs.Response().Selectors); got != c.wantSelectors {
+			t.Errorf("#%v: got %v selectors, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
+
+func TestAttestSelectorNamespace(t *testing.T) {
+	tCase := []struct {
+		namespace string
+		want      string
+	}{
+		// 0: no namespace
+		{namespace: "", want: "locality:home"},
+		// 1: cloud namespace
+		{namespace: common.SelectorNamespaceCloud, want: "east:locality:home"},
+		// 2: project namespace
+		{namespace: common.SelectorNamespaceProject, want: testProjectID + ":locality:home"},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithZone(testProjectID, "region-a", "zone-1")
+		p.config.CloudName = "east"
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.HomeRegion = "region-a"
+		p.config.SelectorNamespace = c.namespace
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStream(testUUID)
+		if err := p.Attest(fs); err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		if got := fs.Response().Selectors; len(got) != 1 || got[0].Value != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestConfigureInvalidLocalityMode(t *testing.T) {
+	p := newTestPlugin()
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+		return fake.NewInstance(testProjectID, nil, nil), nil
+	}
+
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["alpha"]
+	locality_mode = "unknown"
+	`
+
+	ctx := context.Background()
+	req := fake.NewFakeConfigureRequest(globalConfig, conf)
+
+	if _, err := p.Configure(ctx, req); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestAttestReattest(t *testing.T) {
+	fi := fake.NewInstance(testProjectID, nil, nil)
+
+	p := newTestPlugin()
+	p.instance = fi
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+
+	p.attestedBeforeHandler = onceAttestedBeforeHandler
+
+	fs := fake.NewAttestStream(testUUID)
+
+	if err := p.Attest(fs); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+}
+
+func TestAttestWindow(t *testing.T) {
+	tCase := []struct {
+		created  time.Time
+		attested bool
+		wantErr  bool
+	}{
+		// 0: created within the window
+		{created: time.Now().Add(-time.Minute)},
+		// 1: created before the window
+		{created: time.Now().Add(-time.Hour), wantErr: true},
+		// 2: known agent re-attests after the window
+		{created: time.Now().Add(-time.Hour), attested: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithTime(testProjectID, c.created)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.attestationWindow = 10 * time.Minute
+		p.config.CanReattest = true
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		if c.attested {
+			p.attestedBeforeHandler = onceAttestedBeforeHandler
+		}
+
+		fs := fake.NewAttestStream(testUUID)
+
+		err := p.Attest(fs)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+		}
+	}
+}
+
+func TestAttestProjectQuota(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.ProjectInstanceQuota = 1
+	p.config.CanReattest = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	if err := p.Attest(fake.NewAttestStream("alpha")); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+	// the same instance doesn't count against the quota again
+	if err := p.Attest(fake.NewAttestStream("alpha")); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+	if err := p.Attest(fake.NewAttestStream("bravo")); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestAttestProjectQuotaDenied(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.ProjectInstanceQuota = 1
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	// the agent denied by a check after the policy evaluation doesn't count against the quota
+	p.config.MinAssuranceLevel = 100
+	if err := p.Attest(fake.NewAttestStream("alpha")); reasonCode(err) != reasonAssuranceTooLow {
+		t.Errorf("got %v, want a denial of %v", err, reasonAssuranceTooLow)
+	}
+	p.config.MinAssuranceLevel = 0
+	if err := p.Attest(fake.NewAttestStream("bravo")); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+}
+
+func TestAttestProjectHint(t *testing.T) {
+	tCase := []struct {
+		projectID string
+		wantCode  string
+	}{
+		// 0: project of the whitelist is looked up
+		{projectID: testProjectID, wantCode: reasonOpenStackUnavailable},
+		// 1: no hint from agents sending the bare instance ID
+		{wantCode: reasonOpenStackUnavailable},
+		// 2: project outside of the whitelist is denied without a lookup
+		{projectID: "xyz", wantCode: reasonProjectNotAllowed},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewErrorInstance("unexpected lookup")
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.denials = newDenialCache(cache.NewMemory(), time.Minute, "", p.logger)
+
+		payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: c.projectID}
+		data, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+		// the claim of the agent doesn't deny the instance to agents claiming its project
+		if _, ok := p.denials.lookup(testUUID); ok {
+			t.Errorf("#%v: denial of the project hint is cached", i)
+		}
+	}
+}
+
+func TestAttestDNS(t *testing.T) {
+	addresses := map[string]interface{}{
+		"private": []interface{}{
+			map[string]interface{}{
+				"addr":            "10.0.0.5",
+				"OS-EXT-IPS:type": "fixed",
+			},
+		},
+	}
+
+	tCase := []struct {
+		records       map[string][]string
+		hints         bool
+		failureMode   string
+		wantErr       bool
+		wantSelectors []string
+	}{
+		// 0: record resolves to fixed IP
+		{records: map[string][]string{"bravo.example.com.": {"10.0.0.5"}}, wantSelectors: []string{"dns:bravo.example.com"}},
+		// 1: record resolves to other IP
+		{records: map[string][]string{"bravo.example.com.": {"10.0.0.6"}}, wantErr: true},
+		// 2: no record
+		{records: map[string][]string{}, wantErr: true},
+		// 3: hostname of the verified record
+		{records: map[string][]string{"bravo.example.com.": {"10.0.0.5"}}, hints: true, wantSelectors: []string{"dns:bravo.example.com", "hostname:bravo"}},
+		// 4: no hostname unless the record is verified
+		{records: map[string][]string{}, hints: true, failureMode: enrichmentModeWarn, wantSelectors: []string{"enrichment:degraded"}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithAddresses(testProjectID, addresses)
+		p.dns = fake.NewDNS(c.records)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.DNSZone = "example.com"
+		p.config.HostnameHints = c.hints
+		p.config.EnrichmentFailureMode = c.failureMode
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStream(testUUID)
+
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if !reflect.DeepEqual(got, c.wantSelectors) {
+			t.Errorf("#%v: got selectors %v, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
+
+func TestHostnameSelectors(t *testing.T) {
+	tCase := []struct {
+		name         string
+		hints        bool
+		sanitization *common.SelectorSanitization
+		want         []string
+	}{
+		// 0: valid hostname
+		{name: "Web-01", hints: true, want: []string{"hostname:web-01"}},
+		// 1: hints disabled
+		{name: "web-01", hints: false},
+		// 2: not a valid hostname
+		{name: "web server", hints: true},
+		// 3: FQDN is not a single label
+		{name: "web-01.example.com", hints: true},
+		// 4: longer than selector_sanitization allows
+		{name: "web-server-01", hints: true, sanitization: &common.SelectorSanitization{MaxLength: 8}},
+	}
+
+	for i, c := range tCase {
+		config := &IIDAttestorPluginConfig{HostnameHints: c.hints, SelectorSanitization: c.sanitization}
+		if err := config.SelectorSanitization.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		s := &openstack.Server{Server: servers.Server{Name: c.name}}
+		var got []string
+		for _, sel := range hostnameSelectors(config, s) {
+			got = append(got, sel.Value)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestAttestPortDeviceOwner(t *testing.T) {
+	tCase := []struct {
+		owner         string
+		mode          string
+		wantErr       bool
+		wantSelectors []string
+	}{
+		// 0: port owned by compute
+		{owner: "compute:nova", mode: portOwnerModeDeny, wantSelectors: []string{"port:id:port-1"}},
+		// 1: trunk subport is denied
+		{owner: "trunk:subport", mode: portOwnerModeDeny, wantErr: true},
+		// 2: trunk subport is flagged
+		{owner: "trunk:subport", mode: portOwnerModeFlag, wantSelectors: []string{"port:unexpected_owner"}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.network = fake.NewNetwork([]ports.Port{
+			{ID: "port-1", DeviceID: testUUID, DeviceOwner: c.owner},
+		})
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.AllowedPortDeviceOwners = []string{"compute:*"}
+		p.config.PortDeviceOwnerMode = c.mode
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		fs := fake.NewAttestStream(testUUID)
+
+		err := p.Attest(fs)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+			continue
+		}
+		var got []string
+		for _, s := range fs.Response().Selectors {
+			got = append(got, s.Value)
+		}
+		if !reflect.DeepEqual(got, c.wantSelectors) {
+			t.Errorf("#%v: got %v, want %v", i, got, c.wantSelectors)
+		}
+	}
+}
+
+func TestAttestCandidateNotEnforced(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstanceWithZone(testProjectID, "region-a", "zone-2")
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.Candidate = &IIDAttestorPluginConfig{
+		ProjectIDWhitelist:    []string{testProjectID},
+		HomeAvailabilityZones: []string{"zone-1"},
+		LocalityMode:          localityModeDeny,
+	}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	fs := fake.NewAttestStream(testUUID)
+
+	if err := p.Attest(fs); err != nil {
+		t.Errorf("Attestation error: %v", err)
+	}
+	if len(fs.Response().Selectors) != 0 {
+		t.Errorf("selectors of the candidate configuration should not be used: %v", fs.Response().Selectors)
+	}
+}
+
+func TestConfigureCandidate(t *testing.T) {
+	p := newTestPlugin()
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+		return fake.NewInstance(testProjectID, nil, nil), nil
+	}
+
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["alpha", "bravo"]
+	candidate {
+		projectid_whitelist = ["alpha"]
+		attestation_window = "10m"
+	}
+	`
+
+	ctx := context.Background()
+	req := fake.NewFakeConfigureRequest(globalConfig, conf)
+
+	if _, err := p.Configure(ctx, req); err != nil {
+		t.Fatalf("error from Configure(): %v", err)
+	}
+	c := p.config.Candidate
+	if c == nil {
+		t.Fatal("candidate configuration should be loaded")
+	}
+	if c.attestationWindow != 10*time.Minute || c.trustDomain != globalConfig.TrustDomain {
+		t.Errorf("unexpected candidate configuration: %+v", c)
+	}
+}
+
+func TestAttestDenialCache(t *testing.T) {
+	c := cache.NewMemory()
+
+	p := newTestPlugin()
+	p.instance = fake.NewInstance("invalid-project-id", nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.denials = newDenialCache(c, time.Minute, pluginConfig, testutil.TestLogger())
+
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err == nil || err.Error() != "invalid attestation request" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the instance isn't looked up again while the denial is cached
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err == nil || err.Error() != "invalid attestation request" {
+		t.Errorf("expected cached denial, got %v", err)
+	}
+
+	// configuration changes invalidate cached denials
+	p.denials = newDenialCache(c, time.Minute, pluginConfig+"\ncan_reattest = true", testutil.TestLogger())
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAttestDenialCacheTransient(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewErrorInstance("service unavailable")
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.denials = newDenialCache(cache.NewMemory(), time.Minute, pluginConfig, testutil.TestLogger())
+
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err == nil {
+		t.Fatal("an error expected, got nil")
+	}
+
+	// transient failures are not cached
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// hungStream is a stream whose agent never sends the attestation data
+type hungStream struct {
+	*fake.AttestPluginStream
+	done chan struct{}
+}
+
+func (s *hungStream) Recv() (*nodeattestor.AttestRequest, error) {
+	<-s.done
+	return nil, io.EOF
+}
+
+func TestAttestTimeout(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.attestationTimeout = 10 * time.Millisecond
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	fs := &hungStream{
+		AttestPluginStream: fake.NewAttestStream(testUUID),
+		done:               make(chan struct{}),
+	}
+	defer close(fs.done)
+
+	if err := p.Attest(fs); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,202 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	maintenanceKeyPrefix = "maintenance:"
+
+	defaultMaintenanceRetention = 7 * 24 * time.Hour
+)
+
+// MaintenanceConfig configures the admission of known instances while OpenStack is down for planned maintenance
+type MaintenanceConfig struct {
+	// If true, the server is in maintenance mode. The mode can also be entered by SIGUSR1 and left by SIGUSR2
+	// without reconfiguration.
+	Enabled bool `hcl:"enabled"`
+	// Duration to remember instances admitted outside of maintenance, e.g. "168h". Defaults to 7 days.
+	Retention string `hcl:"retention" default:"168h"`
+	retention time.Duration
+}
+
+// maintenanceRecord is what an instance admitted outside of maintenance is re-admitted with during maintenance
+type maintenanceRecord struct {
+	AgentID   string          `json:"agent_id"`
+	ProjectID string          `json:"project_id"`
+	Selectors []*spc.Selector `json:"selectors"`
+}
+
+// maintenanceStore records the instances admitted outside of maintenance
+type maintenanceStore struct {
+	logger    hclog.Logger
+	cache     cache.Cache
+	retention time.Duration
+}
+
+var (
+	maintenanceMode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "maintenance_mode",
+		Help:      "1 if the server is in maintenance mode, 0 otherwise.",
+	})
+	maintenanceAttestations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Name:      "maintenance_attestations_total",
+		Help:      "Number of attestations during maintenance, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(maintenanceMode, maintenanceAttestations)
+}
+
+// validateMaintenanceConfig validates maintenance
+func validateMaintenanceConfig(c *IIDAttestorPluginConfig) error {
+	mc := c.Maintenance
+	if mc == nil {
+		return nil
+	}
+	if !c.CanReattest {
+		return errors.New("maintenance requires can_reattest, since only re-attestations are admitted during maintenance")
+	}
+	mc.retention = defaultMaintenanceRetention
+	if mc.Retention != "" {
+		d, err := time.ParseDuration(mc.Retention)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid retention of maintenance: %q", mc.Retention)
+		}
+		mc.retention = d
+	}
+	return nil
+}
+
+func newMaintenanceStore(c cache.Cache, retention time.Duration, logger hclog.Logger) *maintenanceStore {
+	return &maintenanceStore{
+		logger:    logger,
+		cache:     c,
+		retention: retention,
+	}
+}
+
+// lookup returns the record of the agent of the key, see attestation.nodeKey
+func (ms *maintenanceStore) lookup(key string) (*maintenanceRecord, bool) {
+	b, ok, err := ms.cache.Get(maintenanceKeyPrefix + key)
+	if err != nil {
+		ms.logger.Warn("Failed to lookup maintenance record", "key", key, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	r := &maintenanceRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		ms.logger.Warn("Failed to decode maintenance record", "key", key, "error", err)
+		return nil, false
+	}
+	return r, true
+}
+
+// remember records the admitted instance, so that it can be re-admitted during maintenance
+func (ms *maintenanceStore) remember(a *attestation) {
+	b, err := json.Marshal(&maintenanceRecord{
+		AgentID:   a.agentID,
+		ProjectID: a.server.TenantID,
+		Selectors: a.selectors,
+	})
+	if err != nil {
+		ms.logger.Warn("Failed to encode maintenance record", "instance_id", a.instanceID, "error", err)
+		return
+	}
+	if err := ms.cache.Set(maintenanceKeyPrefix+a.nodeKey(), b, ms.retention); err != nil {
+		ms.logger.Warn("Failed to store maintenance record", "instance_id", a.instanceID, "error", err)
+	}
+}
+
+// inMaintenance returns true if maintenance is configured and the server is in maintenance mode
+func (p *IIDAttestorPlugin) inMaintenance() bool {
+	return p.maintenance != nil && atomic.LoadInt32(&p.maintenanceMode) == 1
+}
+
+// setMaintenanceMode enters or leaves maintenance mode. It doesn't need the lock of the plugin, so that signals can
+// switch the mode while attestations are in progress.
+func (p *IIDAttestorPlugin) setMaintenanceMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	if atomic.SwapInt32(&p.maintenanceMode, v) != v {
+		p.logger.Warn("Switched maintenance mode", "enabled", enabled)
+	}
+	maintenanceMode.Set(float64(v))
+}
+
+// attestInMaintenance admits the instance with the agent ID and selectors it was last admitted with, without calling
+// OpenStack. Instances not admitted before, or whose agent is no longer known to SPIRE, e.g. since it was evicted,
+// are refused with Unavailable until maintenance ends.
+func (p *IIDAttestorPlugin) attestInMaintenance(ctx context.Context, a *attestation) error {
+	iid := a.instanceID
+	if err := checkInstanceUUID(p.config, iid); err != nil {
+		return err
+	}
+	if err := checkLogicalNode(p.config, a.payload); err != nil {
+		return err
+	}
+
+	r, ok := p.maintenance.lookup(a.nodeKey())
+	if !ok {
+		maintenanceAttestations.WithLabelValues("refused").Inc()
+		return &transientError{code: reasonMaintenanceRefused, err: fmt.Errorf("instance %v is unknown, and first-time attestations are refused during maintenance", iid)}
+	}
+	attested, err := p.attestedBeforeHandler(p, ctx, r.AgentID)
+	if err != nil {
+		return &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	if !attested {
+		maintenanceAttestations.WithLabelValues("refused").Inc()
+		return &transientError{code: reasonMaintenanceRefused, err: fmt.Errorf("agent %v is unknown, and first-time attestations are refused during maintenance", r.AgentID)}
+	}
+	if !isProjectAllowed(p.config, r.ProjectID) {
+		return deny(reasonProjectNotAllowed, errors.New("invalid attestation request"))
+	}
+
+	// What the agent sent is verified against the recorded project, since Nova can't tell
+	a.server = &openstack.Server{Server: servers.Server{ID: iid, TenantID: r.ProjectID}}
+	if err := p.checkSignedIdentity(a); err != nil {
+		return err
+	}
+	if err := p.checkPayloadHMAC(a); err != nil {
+		return err
+	}
+	if err := p.checkBundleFingerprint(ctx, a); err != nil {
+		return err
+	}
+
+	a.logger.Info("Re-attesting known agent during maintenance", "instance_id", iid)
+	maintenanceAttestations.WithLabelValues("admitted").Inc()
+	a.attestedAgentID = r.AgentID
+	a.agentID = r.AgentID
+	a.selectors = r.Selectors
+	return nil
+}
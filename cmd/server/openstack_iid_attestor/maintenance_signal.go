@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchMaintenanceSignals enters maintenance mode on SIGUSR1 and leaves it on SIGUSR2
+func (p *IIDAttestorPlugin) watchMaintenanceSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for s := range sig {
+			p.setMaintenanceMode(s == syscall.SIGUSR1)
+		}
+	}()
+}
@@ -0,0 +1,12 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+// watchMaintenanceSignals does nothing, since Windows has no user signals. Maintenance mode is switched by
+// reconfiguration only.
+func (p *IIDAttestorPlugin) watchMaintenanceSignals() {}
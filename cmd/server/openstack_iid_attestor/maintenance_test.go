@@ -0,0 +1,115 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestMaintenance(t *testing.T) {
+	const otherUUID = "8d4c5b3e-7f2a-4b1c-9e6d-0a1b2c3d4e5f"
+
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, []map[string]interface{}{{"id": "sg1", "name": "web"}})
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.CanReattest = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.maintenance = newMaintenanceStore(cache.NewMemory(), time.Hour, testutil.TestLogger())
+
+	// admitted instances are remembered outside of maintenance
+	stream := fake.NewAttestStream(testUUID)
+	if err := p.Attest(stream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := stream.Response()
+
+	// Nova is down for maintenance
+	p.setMaintenanceMode(true)
+	defer p.setMaintenanceMode(false)
+	p.instance = fake.NewErrorInstance("connection refused")
+
+	tCase := []struct {
+		instanceID     string
+		attestedBefore func(*IIDAttestorPlugin, context.Context, string) (bool, error)
+		wantCode       string
+	}{
+		// 0: known instance re-attesting
+		{instanceID: testUUID, attestedBefore: onceAttestedBeforeHandler},
+		// 1: first-time attestation
+		{instanceID: otherUUID, attestedBefore: onceAttestedBeforeHandler, wantCode: reasonMaintenanceRefused},
+		// 2: agent evicted since the instance was remembered
+		{instanceID: testUUID, attestedBefore: notAttestedBeforeHandler, wantCode: reasonMaintenanceRefused},
+	}
+
+	for i, c := range tCase {
+		p.attestedBeforeHandler = c.attestedBefore
+		stream := fake.NewAttestStream(c.instanceID)
+		err := p.Attest(stream)
+		if c.wantCode != "" {
+			if code := reasonCode(err); code != c.wantCode {
+				t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		resp := stream.Response()
+		if resp.AgentId != want.AgentId || len(resp.Selectors) != len(want.Selectors) {
+			t.Errorf("#%v: got %v, want %v", i, resp, want)
+		}
+	}
+
+	// the project was removed from the whitelist since
+	p.attestedBeforeHandler = onceAttestedBeforeHandler
+	p.config.ProjectIDWhitelist = []string{"other"}
+	if code := reasonCode(p.Attest(fake.NewAttestStream(testUUID))); code != reasonProjectNotAllowed {
+		t.Errorf("got reason code %v, want %v", code, reasonProjectNotAllowed)
+	}
+}
+
+func TestValidateMaintenanceConfig(t *testing.T) {
+	tCase := []struct {
+		config        *IIDAttestorPluginConfig
+		wantRetention time.Duration
+		wantErr       bool
+	}{
+		// 0: defaults
+		{config: &IIDAttestorPluginConfig{CanReattest: true, Maintenance: &MaintenanceConfig{}}, wantRetention: defaultMaintenanceRetention},
+		// 1: retention
+		{config: &IIDAttestorPluginConfig{CanReattest: true, Maintenance: &MaintenanceConfig{Retention: "24h"}}, wantRetention: 24 * time.Hour},
+		// 2: invalid retention
+		{config: &IIDAttestorPluginConfig{CanReattest: true, Maintenance: &MaintenanceConfig{Retention: "0s"}}, wantErr: true},
+		// 3: without can_reattest
+		{config: &IIDAttestorPluginConfig{Maintenance: &MaintenanceConfig{}}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		err := validateMaintenanceConfig(c.config)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if c.config.Maintenance.retention != c.wantRetention {
+			t.Errorf("#%v: got retention %v, want %v", i, c.config.Maintenance.retention, c.wantRetention)
+		}
+	}
+}
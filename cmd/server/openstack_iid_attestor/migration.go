@@ -0,0 +1,97 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+)
+
+const (
+	eventTypeDivergence = "io.spiffe.spire.openstack_iid.attestation.divergence"
+)
+
+// divergence is the data of divergence events
+type divergence struct {
+	InstanceID          string   `json:"instance_id"`
+	Admitted            bool     `json:"admitted"`
+	Reason              string   `json:"reason,omitempty"`
+	ReasonCode          string   `json:"reason_code,omitempty"`
+	Selectors           []string `json:"selectors,omitempty"`
+	CandidateAdmitted   bool     `json:"candidate_admitted"`
+	CandidateReason     string   `json:"candidate_reason,omitempty"`
+	CandidateReasonCode string   `json:"candidate_reason_code,omitempty"`
+	CandidateSelectors  []string `json:"candidate_selectors,omitempty"`
+	// CandidateEnforced is true if the candidate decided the attestation as a canary
+	CandidateEnforced bool `json:"candidate_enforced"`
+}
+
+// outcome is the result of evaluating a configuration
+type outcome struct {
+	selectors []*spc.Selector
+	err       error
+}
+
+// reportDivergence compares the decision of the outer configuration with the decision of the candidate configuration,
+// and reports them if they differ. canary tells which of them was enforced.
+func (p *IIDAttestorPlugin) reportDivergence(a *attestation, outer, candidate *outcome, canary bool) {
+	d := &divergence{
+		InstanceID:         a.instanceID,
+		Admitted:           outer.err == nil,
+		Selectors:          selectorValues(outer.selectors),
+		CandidateAdmitted:  candidate.err == nil,
+		CandidateSelectors: selectorValues(candidate.selectors),
+		CandidateEnforced:  canary,
+	}
+	if err := outer.err; err != nil {
+		d.Reason = err.Error()
+		d.ReasonCode = reasonCode(err)
+		d.Selectors = nil
+	}
+	if err := candidate.err; err != nil {
+		d.CandidateReason = err.Error()
+		d.CandidateReasonCode = reasonCode(err)
+		d.CandidateSelectors = nil
+	}
+
+	enforced := strconv.FormatBool(canary)
+	if d.Admitted == d.CandidateAdmitted && strings.Join(d.Selectors, ",") == strings.Join(d.CandidateSelectors, ",") {
+		candidateEvaluations.WithLabelValues(enforced, "agreed").Inc()
+		return
+	}
+	candidateEvaluations.WithLabelValues(enforced, "diverged").Inc()
+
+	p.logger.Warn("Candidate configuration diverges from the outer one",
+		"instance_id", d.InstanceID,
+		"admitted", d.Admitted,
+		"reason", d.Reason,
+		"reason_code", d.ReasonCode,
+		"selectors", d.Selectors,
+		"candidate_admitted", d.CandidateAdmitted,
+		"candidate_reason", d.CandidateReason,
+		"candidate_reason_code", d.CandidateReasonCode,
+		"candidate_selectors", d.CandidateSelectors,
+		"candidate_enforced", d.CandidateEnforced)
+
+	if p.events != nil {
+		p.events.Emit(eventTypeDivergence, a.instanceID, d)
+	}
+}
+
+// selectorValues returns sorted values of selectors
+func selectorValues(selectors []*spc.Selector) []string {
+	var values []string
+	for _, s := range selectors {
+		values = append(values, s.Value)
+	}
+	sort.Strings(values)
+	return values
+}
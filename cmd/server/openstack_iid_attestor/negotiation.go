@@ -0,0 +1,123 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spiffe/spire/proto/spire/server/nodeattestor"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+// versionChallengePurpose is the purpose of the nonces of version challenges
+const versionChallengePurpose = "version_challenge"
+
+var versionNegotiations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "payload_version_negotiations_total",
+	Help:      "Number of payload version negotiations, by version picked and result.",
+}, []string{"version", "result"})
+
+func init() {
+	telemetry.Registry.MustRegister(versionNegotiations)
+}
+
+// parseAttestationData decrypts and parses the attestation data of the agent
+func (p *IIDAttestorPlugin) parseAttestationData(data []byte) (*common.AttestationPayload, error) {
+	plaintext, err := p.decryptPayload(data)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := common.ParseAttestationPayload(plaintext)
+	if err != nil {
+		return nil, deny(reasonInvalidPayload, err)
+	}
+	return payload, nil
+}
+
+// negotiatePayloadVersion answers agents listing the payload versions they can send with a version challenge, which
+// advertises the versions this server supports as the first challenge round. The agent picks one, and sends its
+// attestation data again in it unless it sent it in that version already. The data the attestation continues with is
+// returned, so that payload formats can change without upgrading agents and servers in lockstep.
+func (p *IIDAttestorPlugin) negotiatePayloadVersion(ctx context.Context, stream nodeattestor.NodeAttestor_AttestServer, data []byte, payload *common.AttestationPayload) ([]byte, *common.AttestationPayload, error) {
+	iid := payload.InstanceID
+	nonce, err := p.nonces.Issue(versionChallengePurpose, iid)
+	if err != nil {
+		versionNegotiations.WithLabelValues("", "error").Inc()
+		return nil, nil, &transientError{code: reasonDatastoreUnavailable, err: err}
+	}
+	// The nonce is consumed whatever the result, so that it can't be answered twice
+	defer func() {
+		if err := p.nonces.Consume(nonce, versionChallengePurpose, iid); err != nil {
+			p.logger.Debug("Failed to consume the nonce of the version challenge", "instance_id", iid, "error", err)
+		}
+	}()
+
+	supported := common.SupportedPayloadVersions()
+	challenge, err := (&common.Challenge{Type: common.ChallengeVersion, Nonce: nonce, Versions: supported}).Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	var req *nodeattestor.AttestRequest
+	err = common.CallWithContext(ctx, func() error {
+		if err := stream.Send(&nodeattestor.AttestResponse{Challenge: challenge}); err != nil {
+			return err
+		}
+		req, err = stream.Recv()
+		return err
+	})
+	if err != nil {
+		versionNegotiations.WithLabelValues("", "unanswered").Inc()
+		return nil, nil, deny(reasonVersionNegotiationFailed, fmt.Errorf("agent of instance %v didn't answer the version challenge: %v", iid, err))
+	}
+
+	resp, err := common.ParseChallengeResponse(req.Response)
+	if err == nil && (resp.Type != common.ChallengeVersion || resp.Nonce != nonce) {
+		err = errors.New("the response is not of the challenge")
+	}
+	if err == nil && !containsVersion(supported, resp.Version) {
+		err = fmt.Errorf("version %v was not advertised", resp.Version)
+	}
+	negotiated := data
+	if err == nil && len(resp.AttestationData) > 0 {
+		negotiated = resp.AttestationData
+		payload, err = p.parseAttestationData(negotiated)
+		if err == nil && payload.InstanceID != iid {
+			err = fmt.Errorf("attestation data is of another instance %v", payload.InstanceID)
+		}
+	}
+	if err == nil && payload.PayloadVersion() != resp.Version {
+		err = fmt.Errorf("attestation data is of version %v, not of version %v picked", payload.PayloadVersion(), resp.Version)
+	}
+	if err != nil {
+		versionNegotiations.WithLabelValues("", "invalid").Inc()
+		if reasonCode(err) == reasonPayloadDecryptionFailed {
+			return nil, nil, err
+		}
+		return nil, nil, deny(reasonVersionNegotiationFailed, fmt.Errorf("invalid version challenge response from instance %v: %v", iid, err))
+	}
+
+	versionNegotiations.WithLabelValues(strconv.Itoa(resp.Version), "negotiated").Inc()
+	p.logger.Debug("Negotiated payload version", "instance_id", iid, "version", resp.Version, "resent", len(resp.AttestationData) > 0)
+	return negotiated, payload, nil
+}
+
+func containsVersion(versions []int, v int) bool {
+	for _, e := range versions {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/nonce"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestVersionNegotiation(t *testing.T) {
+	negotiating := fmt.Sprintf(`{"instance_id": %q, "version": 2, "versions": [1, 2]}`, testUUID)
+	answer := func(version int, data string) func(c *common.Challenge) *common.ChallengeResponse {
+		return func(c *common.Challenge) *common.ChallengeResponse {
+			return &common.ChallengeResponse{Type: c.Type, Nonce: c.Nonce, Version: version, AttestationData: []byte(data)}
+		}
+	}
+
+	tCase := []struct {
+		data string
+		// answer returns the response to the challenge, or nil to hang up
+		answer         func(c *common.Challenge) *common.ChallengeResponse
+		wantChallenges int
+		wantCode       string
+	}{
+		// 0: agents not asking aren't challenged
+		{data: testUUID},
+		// 1: the version sent is picked
+		{data: negotiating, answer: answer(2, ""), wantChallenges: 1},
+		// 2: the data is sent again in the version picked
+		{data: negotiating, answer: answer(1, testUUID), wantChallenges: 1},
+		// 3: version not advertised
+		{data: negotiating, answer: answer(3, ""), wantChallenges: 1, wantCode: reasonVersionNegotiationFailed},
+		// 4: data of another version than picked
+		{data: negotiating, answer: answer(1, ""), wantChallenges: 1, wantCode: reasonVersionNegotiationFailed},
+		// 5: data of another instance
+		{data: negotiating, answer: answer(1, "other"), wantChallenges: 1, wantCode: reasonVersionNegotiationFailed},
+		// 6: no answer
+		{
+			data: negotiating,
+			answer: func(c *common.Challenge) *common.ChallengeResponse {
+				return nil
+			},
+			wantChallenges: 1,
+			wantCode:       reasonVersionNegotiationFailed,
+		},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		p.nonces = nonce.NewManager(cache.NewMemory(), time.Minute)
+
+		stream := fake.NewAttestStreamWithData([]byte(c.data))
+		stream.Respond = func(b []byte) []byte {
+			challenge, err := common.ParseChallenge(b)
+			if err != nil {
+				t.Errorf("#%v: unexpected challenge: %v", i, err)
+				return nil
+			}
+			if challenge.Type != common.ChallengeVersion || len(challenge.Versions) == 0 {
+				t.Errorf("#%v: unexpected challenge: %+v", i, challenge)
+			}
+			resp := c.answer(challenge)
+			if resp == nil {
+				return nil
+			}
+			data, _ := resp.Marshal()
+			return data
+		}
+
+		err := p.Attest(stream)
+		if len(stream.Challenges) != c.wantChallenges {
+			t.Errorf("#%v: got %v challenges, want %v", i, len(stream.Challenges), c.wantChallenges)
+		}
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			} else if stream.Response() == nil || stream.Response().AgentId == "" {
+				t.Errorf("#%v: agent is not admitted: %v", i, stream.Response())
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+var observedAttestations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "observed_attestations_total",
+	Help:      "Number of attestations observed with observe_only, by the result and reason code they would have had.",
+}, []string{"result", "reason"})
+
+func init() {
+	telemetry.Registry.MustRegister(observedAttestations)
+}
+
+func validateObserveOnly(c *IIDAttestorPluginConfig) error {
+	if c.Candidate != nil && c.Candidate.ObserveOnly {
+		return errors.New("observe_only is not an option of the candidate configuration")
+	}
+	return nil
+}
+
+// observe counts the decision of an attestation which is only observed, and returns the denial of the agent in its
+// place. The decision is named in the denial, so that operators can tell from the agent what it would have been.
+func observe(a *attestation, err error) error {
+	if err == nil {
+		observedAttestations.WithLabelValues("admitted", "").Inc()
+		return deny(reasonObserveOnly, fmt.Errorf("attestations are only observed; instance %v would have been admitted", a.instanceID))
+	}
+	code := reasonCode(err)
+	observedAttestations.WithLabelValues("denied", code).Inc()
+	return deny(reasonObserveOnly, fmt.Errorf("attestations are only observed; instance %v would have been denied with %v", a.instanceID, code))
+}
@@ -0,0 +1,75 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestObserveOnly(t *testing.T) {
+	agentID := "spiffe://example.com/spire/agent/openstack_iid/" + testProjectID + "/" + testUUID
+
+	tCase := []struct {
+		whitelist      []string
+		attestedBefore func(p *IIDAttestorPlugin, ctx context.Context, agentID string) (bool, error)
+		want           []string
+	}{
+		// 0: would have been admitted
+		{whitelist: []string{testProjectID}, attestedBefore: notAttestedBeforeHandler, want: []string{"attested:" + agentID}},
+		// 1: would have been denied
+		{whitelist: []string{"xyz"}, attestedBefore: notAttestedBeforeHandler, want: []string{"denied:" + reasonProjectNotAllowed}},
+		// 2: observed denials evict no agent
+		{whitelist: []string{"xyz"}, attestedBefore: onceAttestedBeforeHandler, want: []string{"denied:" + reasonProjectNotAllowed}},
+	}
+
+	for i, c := range tCase {
+		h := &recordingHook{}
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = c.whitelist
+		p.config.CanReattest = true
+		p.config.ProjectInstanceQuota = 1
+		p.config.ObserveOnly = true
+		p.attestedBeforeHandler = c.attestedBefore
+		p.hooks = hooks.Hooks{h}
+
+		stream := fake.NewAttestStream(testUUID)
+		err := p.Attest(stream)
+		if code := reasonCode(err); code != reasonObserveOnly {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, reasonObserveOnly)
+		}
+		if stream.Response() != nil {
+			t.Errorf("#%v: agent is admitted: %v", i, stream.Response())
+		}
+		if len(h.calls) != len(c.want) || h.calls[0] != c.want[0] {
+			t.Errorf("#%v: got %v, want %v", i, h.calls, c.want)
+			continue
+		}
+		if !h.decisions[0].Observed {
+			t.Errorf("#%v: decision is not marked observed: %+v", i, h.decisions[0])
+		}
+		if len(p.quota.attested) != 0 {
+			t.Errorf("#%v: observed admissions count against the quota: %v", i, p.quota.attested)
+		}
+	}
+}
+
+func TestValidateObserveOnly(t *testing.T) {
+	c := &IIDAttestorPluginConfig{ObserveOnly: true, Candidate: &IIDAttestorPluginConfig{}}
+	if err := validateObserveOnly(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.Candidate.ObserveOnly = true
+	if err := validateObserveOnly(c); err == nil {
+		t.Error("an error expected for observe_only in the candidate")
+	}
+}
@@ -0,0 +1,124 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+// launchedAtSkew is the tolerance of the launch time sent by agents, which the guest reads from its own clock and
+// which starts booting shortly before Nova records the instance as launched
+const launchedAtSkew = 5 * time.Minute
+
+// newerPayloadVersionLabel replaces versions newer than this server knows in metrics, which agents could otherwise
+// choose freely
+const newerPayloadVersionLabel = "newer"
+
+var attestationPayloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "attestation_payloads_total",
+	Help:      "Number of attestation payloads sent by agents, by payload version.",
+}, []string{"version"})
+
+func init() {
+	telemetry.Registry.MustRegister(attestationPayloads)
+}
+
+// recordPayloadVersion counts the payload by its version, so that operators see when legacy agents are gone
+func recordPayloadVersion(payload *common.AttestationPayload) {
+	label := newerPayloadVersionLabel
+	if v := payload.PayloadVersion(); v <= common.PayloadVersion2 {
+		label = strconv.Itoa(v)
+	}
+	attestationPayloads.WithLabelValues(label).Inc()
+}
+
+// checkPayloadMetadata compares the metadata of the instance sent by the agent with the Nova server document if
+// verify_payload_metadata is set. Payloads predating the metadata are not checked, so that fleets of mixed versions
+// keep attesting.
+func (p *IIDAttestorPlugin) checkPayloadMetadata(a *attestation) error {
+	if !p.config.VerifyPayloadMetadata || a.payload == nil || a.payload.Metadata == nil {
+		return nil
+	}
+	m, s := a.payload.Metadata, a.server
+
+	var mismatches []string
+	if m.Name != "" && m.Name != s.Name {
+		mismatches = append(mismatches, fmt.Sprintf("name %q, but %q in Nova", m.Name, s.Name))
+	}
+	if m.AvailabilityZone != "" && m.AvailabilityZone != s.AvailabilityZone {
+		mismatches = append(mismatches, fmt.Sprintf("availability zone %q, but %q in Nova", m.AvailabilityZone, s.AvailabilityZone))
+	}
+	if m.LaunchedAt != "" && !s.LaunchedAt.IsZero() {
+		// The payload is validated, and the instance may have rebooted since it was launched
+		if t, _ := time.Parse(time.RFC3339, m.LaunchedAt); t.Before(s.LaunchedAt.Add(-launchedAtSkew)) {
+			mismatches = append(mismatches, fmt.Sprintf("launch time %v, but %v in Nova", m.LaunchedAt, s.LaunchedAt.UTC().Format(time.RFC3339)))
+		}
+	}
+	if len(mismatches) > 0 {
+		return deny(reasonPayloadMetadataMismatch, fmt.Errorf("metadata of instance %v from %v has %v", a.instanceID, m.Source, strings.Join(mismatches, " and ")))
+	}
+	a.addEvidence(evidencePayloadMetadata)
+	return nil
+}
+
+// checkPayloadNetwork cross-checks the fixed IPs and MAC addresses sent by the agent against the Neutron ports of the
+// instance if verify_payload_network is set. Each address sent must be of a port, while ports missing from the payload
+// are not denied, since the config drive lacks the ports attached after boot.
+func (p *IIDAttestorPlugin) checkPayloadNetwork(ctx context.Context, a *attestation) error {
+	if !p.config.VerifyPayloadNetwork || a.payload == nil || a.payload.Metadata == nil {
+		return nil
+	}
+	m := a.payload.Metadata
+	if len(m.FixedIPs) == 0 && len(m.MACAddresses) == 0 {
+		return nil
+	}
+
+	pl, err := p.network.ListPorts(ctx, a.server.ID)
+	if err != nil {
+		return transient(fmt.Errorf("failed to list ports: %v", err))
+	}
+	ips := make(map[string]bool)
+	macs := make(map[string]bool)
+	for _, port := range pl {
+		if mac, err := net.ParseMAC(port.MACAddress); err == nil {
+			macs[mac.String()] = true
+		}
+		for _, fixed := range port.FixedIPs {
+			if ip := net.ParseIP(fixed.IPAddress); ip != nil {
+				ips[ip.String()] = true
+			}
+		}
+	}
+
+	var mismatches []string
+	for _, ip := range m.FixedIPs {
+		if !ips[net.ParseIP(ip).String()] {
+			mismatches = append(mismatches, fmt.Sprintf("fixed IP %v", ip))
+		}
+	}
+	for _, s := range m.MACAddresses {
+		if mac, _ := net.ParseMAC(s); !macs[mac.String()] {
+			mismatches = append(mismatches, fmt.Sprintf("MAC address %v", s))
+		}
+	}
+	if len(mismatches) > 0 {
+		return deny(reasonPayloadNetworkMismatch, fmt.Errorf("network data of instance %v from %v has %v, not of its ports in Neutron", a.instanceID, m.Source, strings.Join(mismatches, " and ")))
+	}
+	return nil
+}
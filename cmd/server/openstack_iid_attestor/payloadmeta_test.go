@@ -0,0 +1,141 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestPayloadMetadata(t *testing.T) {
+	tCase := []struct {
+		metadata *common.PayloadMetadata
+		verify   bool
+		wantCode string
+	}{
+		// 0: metadata matching Nova
+		{metadata: &common.PayloadMetadata{Name: "bravo", AvailabilityZone: "nova", Source: "metadata_service"}, verify: true},
+		// 1: renamed in the metadata
+		{metadata: &common.PayloadMetadata{Name: "charlie", AvailabilityZone: "nova", Source: "metadata_service"}, verify: true, wantCode: reasonPayloadMetadataMismatch},
+		// 2: in another zone in the metadata
+		{metadata: &common.PayloadMetadata{Name: "bravo", AvailabilityZone: "az2", Source: "config_drive"}, verify: true, wantCode: reasonPayloadMetadataMismatch},
+		// 3: fields missing from the metadata are not compared
+		{metadata: &common.PayloadMetadata{Source: "cloud_init"}, verify: true},
+		// 4: legacy payload
+		{verify: true},
+		// 5: not verified
+		{metadata: &common.PayloadMetadata{Name: "charlie"}},
+		// 6: booted after the launch in Nova, e.g. rebooted since
+		{metadata: &common.PayloadMetadata{LaunchedAt: launchedAt(time.Hour)}, verify: true},
+		// 7: booted shortly before the launch in Nova, within the clock skew
+		{metadata: &common.PayloadMetadata{LaunchedAt: launchedAt(-time.Minute)}, verify: true},
+		// 8: booted before the instance was launched
+		{metadata: &common.PayloadMetadata{LaunchedAt: launchedAt(-time.Hour), Source: "metadata_service"}, verify: true, wantCode: reasonPayloadMetadataMismatch},
+		// 9: launch time not verified
+		{metadata: &common.PayloadMetadata{LaunchedAt: launchedAt(-time.Hour)}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		// The fake instance is launched now
+		p.instance = fake.NewInstanceWithZone(testProjectID, "", "nova")
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.VerifyPayloadMetadata = c.verify
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: testProjectID}
+		if c.metadata != nil {
+			payload.Version = common.PayloadVersion2
+			payload.Metadata = c.metadata
+		}
+		data, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
+
+// launchedAt returns the launch time of the payload metadata, d after now
+func launchedAt(d time.Duration) string {
+	return time.Now().Add(d).UTC().Format(time.RFC3339)
+}
+
+func TestAttestPayloadNetwork(t *testing.T) {
+	tCase := []struct {
+		metadata *common.PayloadMetadata
+		verify   bool
+		wantCode string
+	}{
+		// 0: addresses of the ports, in other forms than Neutron's
+		{metadata: &common.PayloadMetadata{FixedIPs: []string{"192.0.2.10", "2001:db8::10"}, MACAddresses: []string{"fa:16:3e:00:00:01"}}, verify: true},
+		// 1: ports missing from the payload are not denied
+		{metadata: &common.PayloadMetadata{MACAddresses: []string{"fa:16:3e:00:00:01"}}, verify: true},
+		// 2: fixed IP of no port
+		{metadata: &common.PayloadMetadata{FixedIPs: []string{"192.0.2.20"}, Source: "metadata_service"}, verify: true, wantCode: reasonPayloadNetworkMismatch},
+		// 3: MAC address of no port
+		{metadata: &common.PayloadMetadata{MACAddresses: []string{"fa:16:3e:00:00:02"}, Source: "config_drive"}, verify: true, wantCode: reasonPayloadNetworkMismatch},
+		// 4: agents not sending network data
+		{metadata: &common.PayloadMetadata{Source: "metadata_service"}, verify: true},
+		// 5: not verified
+		{metadata: &common.PayloadMetadata{FixedIPs: []string{"192.0.2.20"}}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.network = fake.NewNetwork([]ports.Port{
+			{
+				ID:         "port-1",
+				DeviceID:   testUUID,
+				MACAddress: "FA:16:3E:00:00:01",
+				FixedIPs:   []ports.IP{{IPAddress: "192.0.2.10"}, {IPAddress: "2001:0db8::0010"}},
+			},
+		})
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.VerifyPayloadNetwork = c.verify
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		payload := &common.AttestationPayload{
+			InstanceID: testUUID,
+			ProjectID:  testProjectID,
+			Version:    common.PayloadVersion2,
+			Metadata:   c.metadata,
+		}
+		data, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Attest(fake.NewAttestStreamWithData(data))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+	}
+}
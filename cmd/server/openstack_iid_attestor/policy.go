@@ -0,0 +1,220 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	defaultNonceTTL           = 5 * time.Minute
+	defaultAttestationTimeout = 30 * time.Second
+	defaultCloudTimeout       = 10 * time.Second
+)
+
+// validatePolicy validates and normalizes the policy options of the configuration
+func validatePolicy(c *IIDAttestorPluginConfig) error {
+	if len(c.ProjectIDWhitelist) == 0 {
+		return errors.New("projectid_whitelist is required")
+	}
+	if err := validateLocalityConfig(c); err != nil {
+		return err
+	}
+	if err := validatePortConfig(c); err != nil {
+		return err
+	}
+	if err := validateRoleConfig(c); err != nil {
+		return err
+	}
+	if err := validateBootSecurityConfig(c); err != nil {
+		return err
+	}
+	if err := validateLogicalNodeConfig(c); err != nil {
+		return err
+	}
+	if err := validateEnrichmentConfig(c); err != nil {
+		return err
+	}
+	if err := validateInstanceStates(c); err != nil {
+		return err
+	}
+	if c.HostnameHints && c.DNSZone == "" {
+		return errors.New("hostname_hints requires dns_zone, in which the name of the instance is verified")
+	}
+	if err := common.ValidateSelectorNamespace(c.SelectorNamespace, c.CloudName); err != nil {
+		return err
+	}
+	if err := c.SelectorSanitization.Validate(); err != nil {
+		return err
+	}
+	if c.ProjectInstanceQuota < 0 || c.ProjectHourlyQuota < 0 {
+		return errors.New("project quotas must not be negative")
+	}
+	return nil
+}
+
+// parseDurations parses duration strings in the configuration. Empty strings are parsed as zero.
+func parseDurations(c *IIDAttestorPluginConfig) error {
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"attestation_window", c.AttestationWindow, &c.attestationWindow},
+		{"instance_cache_ttl", c.InstanceCacheTTL, &c.instanceCacheTTL},
+		{"negative_cache_ttl", c.NegativeCacheTTL, &c.negativeCacheTTL},
+		{"denial_cache_ttl", c.DenialCacheTTL, &c.denialCacheTTL},
+		{"nonce_ttl", c.NonceTTL, &c.nonceTTL},
+		{"attestation_timeout", c.AttestationTimeout, &c.attestationTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout, &c.shutdownTimeout},
+		{"health_check_interval", c.HealthCheckInterval, &c.healthCheckInterval},
+		{"role_cache_ttl", c.RoleCacheTTL, &c.roleCacheTTL},
+		{"cloud_timeout", c.CloudTimeout, &c.cloudTimeout},
+		{"signed_identity_max_age", c.SignedIdentityMaxAge, &c.signedIdentityMaxAge},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", d.name, err)
+		}
+		*d.to = v
+	}
+	if c.nonceTTL <= 0 {
+		c.nonceTTL = defaultNonceTTL
+	}
+	if c.attestationTimeout <= 0 {
+		c.attestationTimeout = defaultAttestationTimeout
+	}
+	if c.cloudTimeout <= 0 {
+		c.cloudTimeout = defaultCloudTimeout
+	}
+	if c.signedIdentityMaxAge <= 0 {
+		c.signedIdentityMaxAge = defaultSignedIdentityMaxAge
+	}
+	return nil
+}
+
+// evaluate applies the policies of given configuration to the instance and returns selectors for the agent.
+// Side effects like logging are made only if enforce is true. Quotas are checked but not recorded, since checks
+// after the evaluation may still deny the agent; see recordQuota.
+func (p *IIDAttestorPlugin) evaluate(ctx context.Context, c *IIDAttestorPluginConfig, a *attestation, attested, enforce bool) ([]*spc.Selector, error) {
+	s := a.server
+
+	if err := checkInstanceState(c, s); err != nil {
+		return nil, err
+	}
+	switch {
+	case attested && !c.CanReattest:
+		return nil, deny(reasonAlreadyAttested, fmt.Errorf("IID has already been used to attest an agent: %v", a.instanceID))
+	case attested:
+		if enforce {
+			a.logger.Info("Re-attesting known agent", "instance_id", a.instanceID)
+		}
+	default:
+		if err := checkAttestationWindow(c, s); err != nil {
+			return nil, err
+		}
+	}
+
+	if !isProjectAllowed(c, s.TenantID) {
+		return nil, deny(reasonProjectNotAllowed, errors.New("invalid attestation request"))
+	}
+	if err := checkTrustDomain(c, s); err != nil {
+		return nil, err
+	}
+
+	selectors, err := p.checkLocality(c, a)
+	if err != nil {
+		return nil, err
+	}
+	bootSelectors, err := p.checkBootSecurity(ctx, c, a)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failures of the services enriching the Nova server document may be tolerated, see enrichment_failure_mode
+	enriched, degraded, err := p.enrich(ctx, c, a, enforce)
+	if err != nil {
+		return nil, err
+	}
+	selectors = append(selectors, enriched[enrichmentDesignate]...)
+	selectors = append(selectors, enriched[enrichmentNeutron]...)
+	selectors = append(selectors, bootSelectors...)
+
+	if degraded {
+		selectors = append(selectors, degradedSelectors(c)...)
+	}
+
+	if err := p.quota.admit(s.TenantID, a.instanceID, c.ProjectInstanceQuota, c.ProjectHourlyQuota, time.Now(), false); err != nil {
+		return nil, deny(reasonQuotaExceeded, err)
+	}
+
+	return namespaceSelectors(c, s, selectors), nil
+}
+
+// recordQuota counts the instance of an attestation which passed every check against the quotas of the
+// configuration deciding it. The quotas are checked again, since other instances of the project may have been
+// admitted in the meantime.
+func (p *IIDAttestorPlugin) recordQuota(a *attestation) error {
+	// Agents of a server observing attestations are never admitted, so they don't count against the quotas
+	c := a.config
+	if c == nil || p.config.ObserveOnly {
+		return nil
+	}
+	if err := p.quota.admit(a.server.TenantID, a.instanceID, c.ProjectInstanceQuota, c.ProjectHourlyQuota, time.Now(), true); err != nil {
+		return deny(reasonQuotaExceeded, err)
+	}
+	return nil
+}
+
+// namespaceSelectors prefixes the values of the selectors with the configured namespace
+func namespaceSelectors(c *IIDAttestorPluginConfig, s *openstack.Server, selectors []*spc.Selector) []*spc.Selector {
+	if c.SelectorNamespace == "" {
+		return selectors
+	}
+	for _, selector := range selectors {
+		selector.Value = common.NamespacedSelectorValue(c.SelectorNamespace, c.CloudName, s.TenantID, selector.Value)
+	}
+	return selectors
+}
+
+// checkAttestationWindow returns an error if the instance was created before the attestation window
+func checkAttestationWindow(c *IIDAttestorPluginConfig, s *openstack.Server) error {
+	if c.attestationWindow == 0 {
+		return nil
+	}
+	if age := time.Since(s.Created); age > c.attestationWindow {
+		return deny(reasonInstanceTooOld, fmt.Errorf("instance was created %v ago, outside of the attestation window", age.Round(time.Second)))
+	}
+	return nil
+}
+
+// checkProjectHint denies agents claiming a project outside of the whitelist before Nova is queried. The hint is
+// not trusted to admit instances, whose project in Nova is checked after the lookup, and the denial isn't cached, since
+// it is of what the agent claimed rather than of the instance.
+func checkProjectHint(c *IIDAttestorPluginConfig, payload *common.AttestationPayload) error {
+	if payload == nil || payload.ProjectID == "" || isProjectAllowed(c, payload.ProjectID) {
+		return nil
+	}
+	return deny(reasonProjectNotAllowed, fmt.Errorf("project %v of the attestation data is not allowed", payload.ProjectID))
+}
+
+// isProjectAllowed returns true if given projectID is in the whitelist
+func isProjectAllowed(c *IIDAttestorPluginConfig, projectID string) bool {
+	return contains(c.ProjectIDWhitelist, projectID)
+}
@@ -0,0 +1,95 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+const (
+	portOwnerModeDeny = "deny"
+	portOwnerModeFlag = "flag"
+)
+
+// needsPorts returns true if the ports of instances are listed, to validate their device_owner or to cross-check the
+// network data sent by agents
+func needsPorts(c *IIDAttestorPluginConfig) bool {
+	return len(c.AllowedPortDeviceOwners) > 0 || c.VerifyPayloadNetwork
+}
+
+func validatePortConfig(c *IIDAttestorPluginConfig) error {
+	switch c.PortDeviceOwnerMode {
+	case "":
+		c.PortDeviceOwnerMode = portOwnerModeDeny
+	case portOwnerModeDeny, portOwnerModeFlag:
+	default:
+		return fmt.Errorf("unknown port_device_owner_mode: %q", c.PortDeviceOwnerMode)
+	}
+	return nil
+}
+
+// checkPorts validates device_owner of the ports attached to the instance.
+// It returns "port:id:<id>" selectors for the validated ports, and in flag mode
+// the "port:unexpected_owner" selector if any port has an unexpected device_owner.
+func (p *IIDAttestorPlugin) checkPorts(ctx context.Context, c *IIDAttestorPluginConfig, a *attestation) ([]*spc.Selector, error) {
+	if len(c.AllowedPortDeviceOwners) == 0 {
+		return nil, nil
+	}
+	s := a.server
+
+	pl, err := p.network.ListPorts(ctx, s.ID)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to list ports: %v", err))
+	}
+
+	var selectors []*spc.Selector
+	var flagged bool
+	for _, port := range pl {
+		if !matchDeviceOwner(c.AllowedPortDeviceOwners, port.DeviceOwner) {
+			if c.PortDeviceOwnerMode == portOwnerModeDeny {
+				return nil, deny(reasonPortOwnerNotAllowed, fmt.Errorf("port %v has unexpected device_owner: %q", port.ID, port.DeviceOwner))
+			}
+			a.logger.Warn("Port has unexpected device_owner", "port_id", port.ID, "device_owner", port.DeviceOwner)
+			flagged = true
+			continue
+		}
+		selectors = append(selectors, &spc.Selector{
+			Type:  common.PluginName,
+			Value: fmt.Sprintf("port:id:%s", port.ID),
+		})
+	}
+
+	if flagged {
+		selectors = append(selectors, &spc.Selector{
+			Type:  common.PluginName,
+			Value: "port:unexpected_owner",
+		})
+	}
+	return selectors, nil
+}
+
+// matchDeviceOwner returns true if the owner matches one of patterns.
+// A pattern ending with "*" matches any owner with the prefix, e.g. "compute:*".
+func matchDeviceOwner(patterns []string, owner string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(owner, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if pattern == owner {
+			return true
+		}
+	}
+	return false
+}
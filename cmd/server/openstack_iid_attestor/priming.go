@@ -0,0 +1,101 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const defaultCachePrimingTimeout = 5 * time.Minute
+
+// CachePrimingConfig is the configuration of priming the instance cache on configuration
+type CachePrimingConfig struct {
+	// Inventory snapshot listing the instances to prime, in the format of the attested inventory.
+	InventoryFile string `hcl:"inventory_file"`
+	// If true, instances of all projects are listed at once, which requires admin role.
+	// Otherwise the instances visible to the credentials are listed.
+	AllTenants bool `hcl:"all_tenants"`
+	// Deadline of listing the instances, e.g. "10m". Defaults to 5 minutes.
+	Timeout string `hcl:"timeout" default:"5m"`
+	timeout time.Duration
+}
+
+func validateCachePrimingConfig(c *IIDAttestorPluginConfig) error {
+	pc := c.CachePriming
+	if pc == nil {
+		return nil
+	}
+	if pc.InventoryFile == "" {
+		return errors.New("inventory_file of cache_priming is required")
+	}
+	if c.instanceCacheTTL <= 0 {
+		return errors.New("cache_priming requires instance_cache_ttl")
+	}
+	pc.timeout = defaultCachePrimingTimeout
+	if pc.Timeout != "" {
+		d, err := time.ParseDuration(pc.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout of cache_priming: %q", pc.Timeout)
+		}
+		pc.timeout = d
+	}
+	return nil
+}
+
+// loadInventoryInstanceIDs returns the IDs of the instances in the inventory snapshot
+func loadInventoryInstanceIDs(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(common.ExpandEnv(path))
+	if err != nil {
+		return nil, err
+	}
+	var doc inventoryDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("malformed inventory snapshot: %v", err)
+	}
+	var ids []string
+	for _, r := range doc.Agents {
+		if r.InstanceID != "" {
+			ids = append(ids, r.InstanceID)
+		}
+	}
+	return ids, nil
+}
+
+// primeInstanceCache stores the live instances of the inventory snapshot in the instance cache with a single
+// listing of Nova, so that agents re-attesting en masse, e.g. after an upgrade of the SPIRE server, don't
+// look up their instances one by one.
+func (p *IIDAttestorPlugin) primeInstanceCache(cached *openstack.CachedInstance, lister openstack.InstanceLister, c *CachePrimingConfig) {
+	ids, err := loadInventoryInstanceIDs(c.InventoryFile)
+	if err != nil {
+		p.logger.Warn("Failed to load inventory snapshot for cache priming", "file", c.InventoryFile, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	found, missing, err := openstack.NewBatchVerifier(lister, c.AllTenants, 0).Verify(ctx, ids)
+	if err != nil {
+		p.logger.Warn("Failed to list instances for cache priming", "error", err)
+		return
+	}
+
+	servers := make([]*openstack.Server, 0, len(found))
+	for _, s := range found {
+		servers = append(servers, s)
+	}
+	cached.Prime(servers)
+	p.logger.Info("Primed instance cache", "primed", len(servers), "missing", len(missing))
+}
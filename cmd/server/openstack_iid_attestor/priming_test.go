@@ -0,0 +1,93 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// listingInstance lists instances, but fails to get them one by one
+type listingInstance struct {
+	servers []*openstack.Server
+}
+
+func (l *listingInstance) Get(_ context.Context, uuid string) (*openstack.Server, error) {
+	return nil, errors.New("unexpected lookup")
+}
+
+func (l *listingInstance) List(_ context.Context, allTenants bool) ([]*openstack.Server, error) {
+	return l.servers, nil
+}
+
+func TestPrimeInstanceCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "priming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "attested.json")
+	snapshot := `{"agents": [{"agent_id": "a", "instance_id": "alpha"}, {"agent_id": "b", "instance_id": "bravo"}]}`
+	if err := ioutil.WriteFile(path, []byte(snapshot), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPlugin()
+	li := &listingInstance{
+		servers: []*openstack.Server{
+			{Server: servers.Server{ID: "alpha", TenantID: testProjectID}},
+			{Server: servers.Server{ID: "charlie", TenantID: testProjectID}},
+		},
+	}
+	ci := openstack.NewCachedInstance(li, cache.NewMemory(), time.Minute, 0, testutil.TestLogger()).(*openstack.CachedInstance)
+	p.primeInstanceCache(ci, li, &CachePrimingConfig{InventoryFile: path, timeout: time.Minute})
+
+	// instances of the snapshot are primed
+	if s, err := ci.Get(context.Background(), "alpha"); err != nil || s.TenantID != testProjectID {
+		t.Errorf("unexpected result: %+v, %v", s, err)
+	}
+	// instances missing from Nova or the snapshot are not
+	for _, uuid := range []string{"bravo", "charlie"} {
+		if _, err := ci.Get(context.Background(), uuid); err == nil {
+			t.Errorf("%v: an error expected, got nil", uuid)
+		}
+	}
+}
+
+func TestValidateCachePrimingConfig(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: valid
+		{config: &IIDAttestorPluginConfig{instanceCacheTTL: time.Minute, CachePriming: &CachePrimingConfig{InventoryFile: "attested.json"}}},
+		// 1: nothing to prime
+		{config: &IIDAttestorPluginConfig{CachePriming: &CachePrimingConfig{InventoryFile: "attested.json"}}, wantErr: true},
+		// 2: no snapshot
+		{config: &IIDAttestorPluginConfig{instanceCacheTTL: time.Minute, CachePriming: &CachePrimingConfig{}}, wantErr: true},
+		// 3: invalid timeout
+		{config: &IIDAttestorPluginConfig{instanceCacheTTL: time.Minute, CachePriming: &CachePrimingConfig{InventoryFile: "attested.json", Timeout: "0s"}}, wantErr: true},
+	} {
+		err := validateCachePrimingConfig(c.config)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
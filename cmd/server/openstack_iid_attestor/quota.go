@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// quotaTracker records distinct instances attested per project
+type quotaTracker struct {
+	mu sync.Mutex
+	// project ID -> instance ID -> time of the first attestation
+	attested map[string]map[string]time.Time
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{
+		attested: make(map[string]map[string]time.Time),
+	}
+}
+
+// admit returns an error if the instance exceeds quotas of the project, and records it if record is true.
+// Instances attested before don't count against the quotas again.
+func (q *quotaTracker) admit(projectID, instanceID string, total, perHour int, now time.Time, record bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	instances := q.attested[projectID]
+	if _, ok := instances[instanceID]; ok {
+		return nil
+	}
+
+	if total > 0 && len(instances) >= total {
+		return fmt.Errorf("project %v exceeds the quota of %d attested instances", projectID, total)
+	}
+	if perHour > 0 {
+		var n int
+		for _, t := range instances {
+			if now.Sub(t) < time.Hour {
+				n++
+			}
+		}
+		if n >= perHour {
+			return fmt.Errorf("project %v exceeds the quota of %d attested instances per hour", projectID, perHour)
+		}
+	}
+
+	if !record {
+		return nil
+	}
+	if instances == nil {
+		instances = make(map[string]time.Time)
+		q.attested[projectID] = instances
+	}
+	instances[instanceID] = now
+	return nil
+}
@@ -0,0 +1,32 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaTrackerPerHour(t *testing.T) {
+	q := newQuotaTracker()
+	now := time.Now()
+
+	if err := q.admit("alpha", "1", 0, 1, now.Add(-2*time.Hour), true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := q.admit("alpha", "2", 0, 1, now, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := q.admit("alpha", "3", 0, 1, now, true); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	// other projects have their own quotas
+	if err := q.admit("bravo", "3", 0, 1, now, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
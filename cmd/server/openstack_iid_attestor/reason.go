@@ -0,0 +1,134 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/verify"
+)
+
+// Reason codes of denials. They are part of the interface to alerting, and must not be changed once released.
+// Codes of the checks shared with the verify package are defined there.
+const (
+	reasonInvalidPayload            = verify.ReasonInvalidPayload
+	reasonInvalidInstanceID         = "INVALID_INSTANCE_ID"
+	reasonInstanceNotFound          = verify.ReasonInstanceNotFound
+	reasonAlreadyAttested           = "INSTANCE_ALREADY_ATTESTED"
+	reasonInstanceTooOld            = verify.ReasonInstanceTooOld
+	reasonInstanceChanged           = "INSTANCE_CHANGED"
+	reasonInstanceStateNotAllowed   = "INSTANCE_STATE_NOT_ALLOWED"
+	reasonReattestRequired          = "INSTANCE_REATTEST_REQUIRED"
+	reasonProjectNotAllowed         = verify.ReasonProjectNotAllowed
+	reasonTrustDomainMismatch       = "POLICY_TRUST_DOMAIN_MISMATCH"
+	reasonLocalityNotAllowed        = verify.ReasonLocalityNotAllowed
+	reasonDNSMismatch               = "POLICY_DNS_MISMATCH"
+	reasonPortOwnerNotAllowed       = "POLICY_PORT_OWNER_NOT_ALLOWED"
+	reasonRoleMissing               = "POLICY_ROLE_MISSING"
+	reasonBootSecurityMissing       = "POLICY_BOOT_SECURITY_MISSING"
+	reasonLogicalNodeNotAllowed     = verify.ReasonLogicalNodeNotAllowed
+	reasonQuotaExceeded             = "QUOTA_EXCEEDED"
+	reasonSignedIdentityMissing     = verify.ReasonSignedIdentityMissing
+	reasonSignedIdentityInvalid     = verify.ReasonSignedIdentityInvalid
+	reasonPayloadHMACMissing        = verify.ReasonPayloadHMACMissing
+	reasonPayloadHMACInvalid        = verify.ReasonPayloadHMACInvalid
+	reasonPayloadHMACReplayed       = "PAYLOAD_HMAC_REPLAYED"
+	reasonPayloadMetadataMismatch   = "PAYLOAD_METADATA_MISMATCH"
+	reasonPayloadNetworkMismatch    = "PAYLOAD_NETWORK_MISMATCH"
+	reasonHMACChallengeFailed       = "HMAC_CHALLENGE_FAILED"
+	reasonConsoleBeaconFailed       = "CONSOLE_BEACON_FAILED"
+	reasonVersionNegotiationFailed  = "VERSION_NEGOTIATION_FAILED"
+	reasonBundleFingerprintMissing  = "BUNDLE_FINGERPRINT_MISSING"
+	reasonBundleFingerprintMismatch = "BUNDLE_FINGERPRINT_MISMATCH"
+	reasonOpenStackUnavailable      = verify.ReasonOpenStackUnavailable
+	reasonDatastoreUnavailable      = "DATASTORE_UNAVAILABLE"
+	reasonRegistrationUnavailable   = "REGISTRATION_API_UNAVAILABLE"
+	reasonAttestationIncomplete     = "ATTESTATION_INCOMPLETE"
+	reasonServerBusy                = "SERVER_BUSY"
+	reasonProjectRateLimited        = "PROJECT_RATE_LIMITED"
+	reasonCircuitOpen               = "OPENSTACK_CIRCUIT_OPEN"
+	reasonMaintenanceRefused        = "MAINTENANCE_REFUSED"
+	reasonShuttingDown              = "SERVER_SHUTTING_DOWN"
+	reasonAssuranceTooLow           = "ASSURANCE_TOO_LOW"
+	reasonPayloadDecryptionFailed   = "PAYLOAD_DECRYPTION_FAILED"
+	reasonObserveOnly               = "OBSERVE_ONLY"
+	reasonUnknown                   = "UNKNOWN"
+)
+
+var attestations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: telemetry.Namespace,
+	Name:      "attestations_total",
+	Help:      "Number of attestations, by result and reason code of denials.",
+}, []string{"result", "reason"})
+
+func init() {
+	telemetry.Registry.MustRegister(attestations)
+}
+
+// denialError is a denial of an attestation with a stable, machine-readable reason code
+type denialError struct {
+	code string
+	err  error
+}
+
+func (e *denialError) Error() string {
+	return e.err.Error()
+}
+
+// GRPCStatus returns the status sent to the agent, carrying the reason code in the details
+func (e *denialError) GRPCStatus() *status.Status {
+	return reasonStatus(codes.PermissionDenied, e.code, e.err.Error())
+}
+
+func deny(code string, err error) error {
+	return &denialError{code: code, err: err}
+}
+
+// reasonCode returns the reason code of the error of an attestation
+func reasonCode(err error) string {
+	switch e := err.(type) {
+	case *denialError:
+		return e.code
+	case *transientError:
+		return e.code
+	case *backpressureError:
+		return e.code
+	}
+	return reasonUnknown
+}
+
+// recordDecision counts the attestation by its result and reason code
+func recordDecision(err error) {
+	if err == nil {
+		attestations.WithLabelValues("admitted", "").Inc()
+		return
+	}
+	attestations.WithLabelValues("denied", reasonCode(err)).Inc()
+}
+
+func reasonStatus(c codes.Code, code, msg string) *status.Status {
+	st := status.New(c, msg)
+	detailed, err := st.WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{
+			{
+				Type:        code,
+				Subject:     common.PluginName,
+				Description: msg,
+			},
+		},
+	})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
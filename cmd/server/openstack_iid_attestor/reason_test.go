@@ -0,0 +1,63 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestReasonCode(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance("invalid-project-id", nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+	p.denials = newDenialCache(cache.NewMemory(), time.Minute, pluginConfig, testutil.TestLogger())
+
+	// cached denials keep the reason code
+	for i := 0; i < 2; i++ {
+		err := p.Attest(fake.NewAttestStream(testUUID))
+		if code := reasonCode(err); code != reasonProjectNotAllowed {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, reasonProjectNotAllowed)
+		}
+
+		st := status.Convert(err)
+		if st.Code() != codes.PermissionDenied || st.Message() != "invalid attestation request" {
+			t.Errorf("#%v: unexpected status: %v", i, st)
+		}
+		if len(st.Details()) != 1 {
+			t.Fatalf("#%v: unexpected details: %v", i, st.Details())
+		}
+		pf, ok := st.Details()[0].(*errdetails.PreconditionFailure)
+		if !ok || len(pf.Violations) != 1 || pf.Violations[0].Type != reasonProjectNotAllowed {
+			t.Errorf("#%v: unexpected details: %v", i, st.Details())
+		}
+	}
+}
+
+func TestReasonCodeTransient(t *testing.T) {
+	err := transient(errors.New("nova: connection refused"))
+	if code := reasonCode(err); code != reasonOpenStackUnavailable {
+		t.Errorf("got reason code %v, want %v", code, reasonOpenStackUnavailable)
+	}
+	if c := status.Code(err); c != codes.Unavailable {
+		t.Errorf("got status code %v, want %v", c, codes.Unavailable)
+	}
+	if code := reasonCode(errors.New("unexpected")); code != reasonUnknown {
+		t.Errorf("got reason code %v, want %v", code, reasonUnknown)
+	}
+}
@@ -0,0 +1,195 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/hashicorp/go-hclog"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/hooks"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// replayResult compares the recorded decision of a fixture with the one replayed
+type replayResult struct {
+	Fixture  string          `json:"fixture"`
+	Recorded *hooks.Decision `json:"recorded"`
+	Replayed *hooks.Decision `json:"replayed"`
+	Diverged bool            `json:"diverged"`
+}
+
+// replayMain replays recorded fixtures with a plugin configuration, writing a result per fixture as JSON lines.
+// It returns 1 if any fixture can't be replayed.
+func replayMain(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configFile := fs.String("config", "", "file of the plugin_data of the server plugin to replay with")
+	trustDomain := fs.String("trust-domain", "", "trust domain of the server (default: the recorded one)")
+	verbose := fs.Bool("v", false, "log the verification at debug level")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor replay -config <file> <fixture>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	conf, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	level := hclog.Warn
+	if *verbose {
+		level = hclog.Debug
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "replay",
+		Output: os.Stderr,
+		Level:  level,
+	})
+
+	status := 0
+	enc := json.NewEncoder(os.Stdout)
+	for _, path := range fs.Args() {
+		r, err := replayFile(path, string(conf), *trustDomain, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			status = 1
+			continue
+		}
+		enc.Encode(r)
+	}
+	return status
+}
+
+func replayFile(path, conf, trustDomain string, logger hclog.Logger) (*replayResult, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &attestationFixture{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("invalid fixture: %v", err)
+	}
+	if f.OpenStack == nil {
+		f.OpenStack = &openstack.Fixture{}
+	}
+	if trustDomain == "" {
+		trustDomain = f.TrustDomain
+	}
+
+	d, err := replay(context.Background(), f, conf, trustDomain, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &replayResult{
+		Fixture:  path,
+		Recorded: f.Decision,
+		Replayed: d,
+		Diverged: !sameDecision(f.Decision, d),
+	}, nil
+}
+
+// replay feeds the fixture through the verification of a new plugin, whose OpenStack clients serve the recorded
+// responses, and returns the decision
+func replay(ctx context.Context, f *attestationFixture, conf, trustDomain string, logger hclog.Logger) (*hooks.Decision, error) {
+	client := openstack.NewReplayClient(f.OpenStack)
+	p := New()
+	p.SetLogger(logger)
+	p.offline = true
+	p.getInstanceHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error) {
+		return client, nil
+	}
+	p.getDNSHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.DNSClient, error) {
+		return client, nil
+	}
+	p.getNetworkHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.NetworkClient, error) {
+		return client, nil
+	}
+	p.getRoleHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.RoleClient, error) {
+		return client, nil
+	}
+	p.getBootSecurityHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.BootSecurityClient, error) {
+		return client, nil
+	}
+	p.attestedBeforeHandler = func(*IIDAttestorPlugin, context.Context, string) (bool, error) {
+		return f.AttestedBefore, nil
+	}
+
+	_, err := p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: conf,
+		GlobalConfig:  &spi.ConfigureRequest_GlobalConfig{TrustDomain: trustDomain},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure: %v", err)
+	}
+
+	data, err := p.decryptPayload(f.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	payload, err := common.ParseAttestationPayload(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	// The replay keeps the ID of the recorded attestation, so that its log lines correlate with the recorded ones
+	attestationID := openstack.NewRequestID()
+	if f.Decision != nil && f.Decision.AttestationID != "" {
+		attestationID = f.Decision.AttestationID
+	}
+	a := p.newAttestation(attestationID, payload)
+	err = p.attest(openstack.WithRequestID(ctx, a.id), a)
+	return newDecision(a, err), nil
+}
+
+// disableSideEffects clears the options with effects outside the plugin, so that replays don't serve metrics,
+// publish events, write files or connect to the cache backend
+func disableSideEffects(c *IIDAttestorPluginConfig) {
+	c.MetricsAddress = ""
+	c.HealthCheckInterval = ""
+	c.CapabilityCheck = ""
+	c.Cache = nil
+	c.CachePriming = nil
+	c.Inventory = nil
+	c.Events = nil
+	c.Hooks = nil
+	c.RecordFixtures = ""
+	// Challenges need the agent, and beacons the console log of the instance, which aren't recorded
+	c.ChallengeHMAC = false
+	c.ConsoleBeacon = nil
+	// The trust bundle is of the live SPIRE Server
+	c.BundleFingerprint = nil
+	// Fixtures record the responses of a single site
+	c.Failover = nil
+	// Maintenance admits from records of the cache backend, and switches the mode by signals
+	c.Maintenance = nil
+}
+
+// sameDecision returns true if the decisions admit the same agent with the same selectors, or deny for the same reason
+func sameDecision(recorded, replayed *hooks.Decision) bool {
+	if recorded == nil || replayed == nil {
+		return recorded == replayed
+	}
+	return recorded.Admitted == replayed.Admitted &&
+		recorded.ReasonCode == replayed.ReasonCode &&
+		recorded.AgentID == replayed.AgentID &&
+		reflect.DeepEqual(recorded.Selectors, replayed.Selectors)
+}
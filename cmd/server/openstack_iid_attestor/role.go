@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	roleSubjectProject = "project"
+	roleSubjectUser    = "user"
+)
+
+func validateRoleConfig(c *IIDAttestorPluginConfig) error {
+	switch c.RoleSubject {
+	case "":
+		c.RoleSubject = roleSubjectProject
+	case roleSubjectProject, roleSubjectUser:
+	default:
+		return fmt.Errorf("unknown role_subject: %q", c.RoleSubject)
+	}
+	return nil
+}
+
+// checkRoles returns an error unless the owning project of the instance, or the user who created it,
+// holds all the required roles.
+func (p *IIDAttestorPlugin) checkRoles(ctx context.Context, c *IIDAttestorPluginConfig, s *openstack.Server) error {
+	if len(c.RequiredRoles) == 0 {
+		return nil
+	}
+
+	var userID string
+	if c.RoleSubject == roleSubjectUser {
+		if s.UserID == "" {
+			return deny(reasonRoleMissing, fmt.Errorf("instance %v has no creating user", s.ID))
+		}
+		userID = s.UserID
+	}
+
+	roles, err := p.role.ListRoles(ctx, s.TenantID, userID)
+	if err != nil {
+		return transient(fmt.Errorf("failed to list role assignments: %v", err))
+	}
+
+	var missing []string
+	for _, r := range c.RequiredRoles {
+		if !contains(roles, r) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		return deny(reasonRoleMissing, fmt.Errorf("%v of instance %v lacks required roles: %v", c.RoleSubject, s.ID, strings.Join(missing, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestRequiredRoles(t *testing.T) {
+	assignments := map[string][]string{
+		testProjectID:                     {"member", "reader"},
+		testProjectID + "/" + fake.UserID: {"member", "spire-node"},
+	}
+
+	tCase := []struct {
+		roles   []string
+		subject string
+		wantErr bool
+	}{
+		// 0: project holds the role
+		{roles: []string{"member"}, subject: roleSubjectProject},
+		// 1: project lacks the role
+		{roles: []string{"spire-node"}, subject: roleSubjectProject, wantErr: true},
+		// 2: creating user holds the role
+		{roles: []string{"member", "spire-node"}, subject: roleSubjectUser},
+		// 3: creating user lacks the role
+		{roles: []string{"reader"}, subject: roleSubjectUser, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.role = fake.NewRole(assignments)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.RequiredRoles = c.roles
+		p.config.RoleSubject = c.subject
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		err := p.Attest(fake.NewAttestStream(testUUID))
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: Attestation error: %v", i, err)
+		}
+	}
+}
+
+func TestCachedRole(t *testing.T) {
+	f := fake.NewRole(map[string][]string{testProjectID: {"spire-node"}})
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.role = openstack.NewCachedRole(f, cache.NewMemory(), time.Minute, p.logger)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.config.RequiredRoles = []string{"spire-node"}
+	p.config.RoleSubject = roleSubjectProject
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	for i := 0; i < 2; i++ {
+		if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+			t.Fatalf("Attestation error: %v", err)
+		}
+	}
+	if f.Calls != 1 {
+		t.Errorf("expected cached role assignments, got %v calls", f.Calls)
+	}
+}
@@ -0,0 +1,84 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// schemaOption returns the option at the path of option names
+func schemaOption(t *testing.T, options []*common.ConfigOption, path ...string) *common.ConfigOption {
+	for _, o := range options {
+		if o.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return o
+		}
+		return schemaOption(t, o.Options, path[1:]...)
+	}
+	t.Fatalf("option %v not found", path)
+	return nil
+}
+
+func TestConfigSchema(t *testing.T) {
+	s, err := configSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The defaults of the schema must match the defaults applied by Configure
+	for _, c := range []struct {
+		path []string
+		want time.Duration
+	}{
+		{path: []string{"attestation_timeout"}, want: defaultAttestationTimeout},
+		{path: []string{"cloud_timeout"}, want: defaultCloudTimeout},
+		{path: []string{"nonce_ttl"}, want: defaultNonceTTL},
+		{path: []string{"busy_retry_after"}, want: defaultBusyRetryAfter},
+		{path: []string{"payload_hmac_max_age"}, want: defaultPayloadHMACMaxAge},
+		{path: []string{"circuit_breaker", "open_duration"}, want: defaultBreakerOpenDuration},
+		{path: []string{"console_beacon", "timeout"}, want: defaultBeaconTimeout},
+		{path: []string{"console_beacon", "poll_interval"}, want: defaultBeaconPollInterval},
+		{path: []string{"bundle_fingerprint", "refresh_interval"}, want: defaultBundleRefreshInterval},
+		{path: []string{"failover", "fail_back_interval"}, want: defaultFailBackInterval},
+		{path: []string{"inventory", "export_interval"}, want: defaultInventoryExportInterval},
+		{path: []string{"maintenance", "retention"}, want: defaultMaintenanceRetention},
+		{path: []string{"cache_priming", "timeout"}, want: defaultCachePrimingTimeout},
+	} {
+		o := schemaOption(t, s.Options, c.path...)
+		if d, err := time.ParseDuration(o.Default); err != nil || d != c.want {
+			t.Errorf("got default %q of %v, want %v", o.Default, c.path, c.want)
+		}
+	}
+	for _, c := range []struct {
+		path []string
+		want int
+	}{
+		{path: []string{"circuit_breaker", "failure_threshold"}, want: defaultBreakerFailureThreshold},
+		{path: []string{"console_beacon", "lines"}, want: defaultBeaconLines},
+	} {
+		if o := schemaOption(t, s.Options, c.path...); o.Default != strconv.Itoa(c.want) {
+			t.Errorf("got default %q of %v, want %v", o.Default, c.path, c.want)
+		}
+	}
+
+	if o := schemaOption(t, s.Options, "bundle_fingerprint", "registration_socket"); o.Default != defaultRegistrationSocket {
+		t.Errorf("got default %q of registration_socket, want %v", o.Default, defaultRegistrationSocket)
+	}
+	if o := schemaOption(t, s.Options, "signed_identity_key"); o.Type != "list(block)" || o.Label != "key_id" {
+		t.Errorf("unexpected signed_identity_key: %+v", o)
+	}
+	if o := schemaOption(t, s.Options, "candidate"); !o.Recursive {
+		t.Errorf("candidate not recursive: %+v", o)
+	}
+}
@@ -0,0 +1,26 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+)
+
+// validateProjectScoped validates the options of project_scoped
+func validateProjectScoped(c *IIDAttestorPluginConfig) error {
+	if !c.ProjectScoped {
+		return nil
+	}
+	if c.AdminMode {
+		return errors.New("project_scoped and admin_mode are mutually exclusive")
+	}
+	if a := c.Auth; a != nil && (a.ApplicationCredentialID != "" || a.ApplicationCredentialName != "" || a.ApplicationCredentialFile != "") {
+		return errors.New("project_scoped requires password credentials, since application credentials are bound to a project")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestProjectScoped(t *testing.T) {
+	var scopes []string
+	p := newTestPlugin()
+	p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+		scopes = append(scopes, auth.ProjectID)
+		if auth.ProjectID != testProjectID {
+			return fake.NewErrorInstance("unexpected scope"), nil
+		}
+		return fake.NewInstance(testProjectID, nil, nil), nil
+	}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	conf := `
+	cloud_name = "test"
+	projectid_whitelist = ["xyz", "` + testProjectID + `"]
+	project_scoped = true
+	auth {
+		username = "spire"
+		password = "secret"
+	}
+	`
+	if _, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, conf)); err != nil {
+		t.Fatalf("error from Configure(): %v", err)
+	}
+	// tokens are scoped on first lookup
+	if len(scopes) != 0 {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+
+	payload := &common.AttestationPayload{InstanceID: testUUID, ProjectID: testProjectID}
+	data, err := payload.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.Attest(fake.NewAttestStreamWithData(data)); err != nil {
+			t.Fatalf("#%v: attestation error: %v", i, err)
+		}
+	}
+	// the hint picks the scope, whose token is kept for the second attestation
+	if !reflect.DeepEqual(scopes, []string{testProjectID}) {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+}
+
+func TestValidateProjectScoped(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: password credentials
+		{config: &IIDAttestorPluginConfig{ProjectScoped: true, Auth: &openstack.AuthConfig{Username: "spire"}}},
+		// 1: credentials of the cloud entry
+		{config: &IIDAttestorPluginConfig{ProjectScoped: true}},
+		// 2: admin credentials are what project_scoped avoids
+		{config: &IIDAttestorPluginConfig{ProjectScoped: true, AdminMode: true}, wantErr: true},
+		// 3: application credentials can't be rescoped
+		{config: &IIDAttestorPluginConfig{ProjectScoped: true, Auth: &openstack.AuthConfig{ApplicationCredentialID: "alpha"}}, wantErr: true},
+	} {
+		err := validateProjectScoped(c.config)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+)
+
+// selfTestMain configures the plugin against the clouds and verifies a known instance, printing a report of the
+// steps. It returns 1 if any step failed.
+func selfTestMain(args []string) int {
+	fs := flag.NewFlagSet("self-test", flag.ContinueOnError)
+	configFile := fs.String("config", "", "file of the plugin_data of the server plugin")
+	trustDomain := fs.String("trust-domain", "", "trust domain of the server")
+	instanceID := fs.String("instance-id", "", "ID of a known instance to verify (default: only configure)")
+	verbose := fs.Bool("v", false, "log at debug level")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: openstack_iid_attestor self-test -config <file> -trust-domain <domain> [-instance-id <id>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configFile == "" || *trustDomain == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	conf, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	level := hclog.Warn
+	if *verbose {
+		level = hclog.Debug
+	}
+	p := New()
+	p.SetLogger(hclog.New(&hclog.LoggerOptions{
+		Name:   "self-test",
+		Output: os.Stderr,
+		Level:  level,
+	}))
+
+	r := selftest.NewReport(os.Stdout)
+	selfTest(context.Background(), p, string(conf), *trustDomain, *instanceID, r)
+	return r.Summary()
+}
+
+// selfTest runs the steps of the self-test with the plugin, which must not be configured yet
+func selfTest(ctx context.Context, p *IIDAttestorPlugin, conf, trustDomain, instanceID string, r *selftest.Report) {
+	if !configureOffline(ctx, p, conf, trustDomain, r) {
+		return
+	}
+
+	if instanceID == "" {
+		r.Skip("nova lookup", "-instance-id is not set")
+		r.Skip("attestation", "-instance-id is not set")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.config.attestationTimeout)
+	defer cancel()
+	s, err := p.instance.Get(ctx, instanceID)
+	if err != nil {
+		r.Fail("nova lookup", err)
+		r.Skip("attestation", "the instance can't be looked up")
+		return
+	}
+	r.Pass("nova lookup", "instance %v in project %v is %v", s.Name, s.TenantID, s.Status)
+
+	// The self-test has no agent to send a signed identity or to answer challenges
+	p.config.RequireSignedIdentity = false
+	a := p.newAttestation(openstack.NewRequestID(), &common.AttestationPayload{InstanceID: instanceID})
+	if err := p.attest(openstack.WithRequestID(ctx, a.id), a); err != nil {
+		r.Fail("attestation", fmt.Errorf("%v: %v", reasonCode(err), err))
+		return
+	}
+	r.Pass("attestation", "admitted as %v with selectors %v", a.agentID, selectorList(a))
+}
+
+// configureOffline configures the plugin, which must not be configured yet, for the commands run outside of SPIRE,
+// and reports the configuration step. It returns false if the configuration failed.
+func configureOffline(ctx context.Context, p *IIDAttestorPlugin, conf, trustDomain string, r *selftest.Report) bool {
+	// The options with effects outside the plugin are disabled as in the replay mode, and no agent is known to have
+	// attested
+	p.offline = true
+	p.attestedBeforeHandler = func(*IIDAttestorPlugin, context.Context, string) (bool, error) {
+		return false, nil
+	}
+
+	_, err := p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: conf,
+		GlobalConfig:  &spi.ConfigureRequest_GlobalConfig{TrustDomain: trustDomain},
+	})
+	if err != nil {
+		r.Fail("configuration", err)
+		return false
+	}
+	r.Pass("configuration", "configured with clouds %v; unavailable clouds are logged", strings.Join(p.config.clouds(), ", "))
+	return true
+}
+
+// selectorList formats the selectors of the attestation for reports
+func selectorList(a *attestation) string {
+	var selectors []string
+	for _, s := range a.selectors {
+		selectors = append(selectors, s.Type+":"+s.Value)
+	}
+	return strings.Join(selectors, ", ")
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/selftest"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestSelfTest(t *testing.T) {
+	tCase := []struct {
+		conf        string
+		instanceID  string
+		instanceErr error
+		want        []string
+	}{
+		// 0: admitted instance
+		{
+			conf:       `projectid_whitelist = ["` + testProjectID + `"]`,
+			instanceID: testUUID,
+			want:       []string{"PASS  configuration", "PASS  nova lookup", "PASS  attestation: admitted as spiffe://example.com/spire/agent/openstack_iid/abc/123"},
+		},
+		// 1: instance denied by the policy
+		{
+			conf:       `projectid_whitelist = ["xyz"]`,
+			instanceID: testUUID,
+			want:       []string{"PASS  configuration", "PASS  nova lookup", "FAIL  attestation: POLICY_PROJECT_NOT_ALLOWED"},
+		},
+		// 2: no instance to verify
+		{
+			conf: `projectid_whitelist = ["` + testProjectID + `"]`,
+			want: []string{"PASS  configuration", "SKIP  nova lookup", "SKIP  attestation"},
+		},
+		// 3: cloud unavailable
+		{
+			conf:        `projectid_whitelist = ["` + testProjectID + `"]`,
+			instanceID:  testUUID,
+			instanceErr: errors.New("keystone: connection refused"),
+			want:        []string{"FAIL  configuration"},
+		},
+		// 4: invalid configuration
+		{
+			conf: `projectid_whitelist = [`,
+			want: []string{"FAIL  configuration"},
+		},
+	}
+
+	for i, c := range tCase {
+		p := New()
+		p.SetLogger(testutil.TestLogger())
+		p.getInstanceHandler = func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error) {
+			if c.instanceErr != nil {
+				return nil, c.instanceErr
+			}
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+
+		var b bytes.Buffer
+		selfTest(context.Background(), p, `cloud_name = "test"`+"\n"+c.conf, "example.com", c.instanceID, selftest.NewReport(&b))
+
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != len(c.want) {
+			t.Errorf("#%v: unexpected report: %v", i, b.String())
+			continue
+		}
+		for j, want := range c.want {
+			if !strings.HasPrefix(lines[j], want) {
+				t.Errorf("#%v: got %q, want %q", i, lines[j], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// errShuttingDown refuses attestations arriving after Shutdown, so that agents retry with the restarted server
+var errShuttingDown = &transientError{code: reasonShuttingDown, err: errors.New("server is shutting down")}
+
+// shutdownTimeout returns the time attestations in flight are given to finish on shutdown
+func (p *IIDAttestorPlugin) shutdownTimeout() time.Duration {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	switch {
+	case p.config == nil:
+		return defaultAttestationTimeout
+	case p.config.shutdownTimeout > 0:
+		return p.config.shutdownTimeout
+	default:
+		// Attestations are abandoned after attestation_timeout anyway
+		return p.config.attestationTimeout
+	}
+}
+
+// Shutdown stops admitting attestations and waits for the ones in flight until ctx is done, cancelling the rest.
+// Decisions queued for hooks and events are then delivered, the inventory is exported for the last time, the
+// metrics server and the health prober are stopped, the registration API is disconnected, and the Keystone tokens of
+// the plugin are revoked.
+// The plugin can't be used afterwards.
+func (p *IIDAttestorPlugin) Shutdown(ctx context.Context) error {
+	var errs []string
+	if err := p.drain.Close(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	p.mtx.Lock()
+	p.hooks.Close()
+	p.hooks = nil
+	p.events = nil
+	if p.inventory != nil {
+		p.inventory.close()
+		p.inventory = nil
+	}
+	if p.prober != nil {
+		p.prober.Stop()
+		p.prober = nil
+	}
+	if p.bundles != nil {
+		p.bundles.close()
+		p.bundles = nil
+	}
+	if p.metrics != nil {
+		if err := p.metrics.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to stop metrics server: %v", err))
+		}
+		p.metrics = nil
+	}
+	cloudTimeout := defaultCloudTimeout
+	if p.config != nil && p.config.cloudTimeout > 0 {
+		cloudTimeout = p.config.cloudTimeout
+	}
+	p.mtx.Unlock()
+
+	// Revocation is given its own deadline, since draining may have used up ctx
+	revokeCtx, cancel := context.WithTimeout(context.Background(), cloudTimeout)
+	defer cancel()
+	if err := openstack.RevokeTokens(revokeCtx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// stop shuts the plugin down within shutdown_timeout, once whether SPIRE stopped the plugin or the process is
+// terminated
+func (p *IIDAttestorPlugin) stop() {
+	p.stopOnce.Do(func() {
+		logger := p.logger
+		if logger == nil {
+			logger = hclog.Default()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.shutdownTimeout())
+		defer cancel()
+		if err := p.Shutdown(ctx); err != nil {
+			logger.Warn("Plugin didn't shut down cleanly", "error", err)
+			return
+		}
+		logger.Info("Plugin shut down")
+	})
+}
+
+// watchShutdownSignals shuts the plugin down and exits on SIGTERM, instead of dropping the attestations in flight
+func (p *IIDAttestorPlugin) watchShutdownSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	go func() {
+		<-sig
+		p.stop()
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,86 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spiffe/spire/pkg/server/plugin/nodeattestor"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+// receivingStream is a stream which tells when the attestation started receiving, and whose agent never sends
+type receivingStream struct {
+	*fake.AttestPluginStream
+	receiving chan struct{}
+	done      chan struct{}
+}
+
+func (s *receivingStream) Recv() (*nodeattestor.AttestRequest, error) {
+	close(s.receiving)
+	<-s.done
+	return nil, io.EOF
+}
+
+func TestShutdown(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	fs := &receivingStream{
+		AttestPluginStream: fake.NewAttestStream(testUUID),
+		receiving:          make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	defer close(fs.done)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Attest(fs)
+	}()
+	<-fs.receiving
+
+	// the attestation in flight is cancelled at the deadline of the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err == nil {
+		t.Error("expected error for the cancelled attestation, got nil")
+	}
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("unexpected error: got %v, want %v", err, context.Canceled)
+	}
+
+	err := p.Attest(fake.NewAttestStream(testUUID))
+	if code := reasonCode(err); code != reasonShuttingDown {
+		t.Errorf("got reason code %v, want %v", code, reasonShuttingDown)
+	}
+	if c := status.Code(err); c != codes.Unavailable {
+		t.Errorf("got status code %v, want %v", c, codes.Unavailable)
+	}
+}
+
+func TestShutdownIdle(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewInstance(testProjectID, nil, nil)
+	p.config.ProjectIDWhitelist = []string{testProjectID}
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	if err := p.Attest(fake.NewAttestStream(testUUID)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// instanceStateActive is the status of running instances, the only state admitted by default
+const instanceStateActive = "ACTIVE"
+
+// vmStateActive is the vm_state of ACTIVE instances
+const vmStateActive = "active"
+
+// instanceStates are the statuses of servers in the Compute API
+var instanceStates = map[string]bool{
+	instanceStateActive: true,
+	"BUILD":             true,
+	"DELETED":           true,
+	"ERROR":             true,
+	"HARD_REBOOT":       true,
+	"MIGRATING":         true,
+	"PASSWORD":          true,
+	"PAUSED":            true,
+	"REBOOT":            true,
+	"REBUILD":           true,
+	"RESCUE":            true,
+	"RESIZE":            true,
+	"REVERT_RESIZE":     true,
+	"SHELVED":           true,
+	"SHELVED_OFFLOADED": true,
+	"SHUTOFF":           true,
+	"SOFT_DELETED":      true,
+	"SUSPENDED":         true,
+	"UNKNOWN":           true,
+	"VERIFY_RESIZE":     true,
+}
+
+func validateInstanceStates(c *IIDAttestorPluginConfig) error {
+	for _, s := range c.AllowedInstanceStates {
+		if !instanceStates[s] {
+			return fmt.Errorf("unknown instance state in allowed_instance_states: %q", s)
+		}
+	}
+	return nil
+}
+
+// checkInstanceState returns an error if the status of the instance is not allowed, so that stopped, shelved,
+// deleted or failed instances can't obtain SVIDs. ACTIVE instances must also be running by the vm_state and
+// power_state, since Nova keeps the status of instances which stopped or paused outside its API, e.g. shut down from
+// the guest, until the state is synchronized. The states aren't checked if Nova doesn't report them.
+func checkInstanceState(c *IIDAttestorPluginConfig, s *openstack.Server) error {
+	allowed := c.AllowedInstanceStates
+	if len(allowed) == 0 {
+		allowed = []string{instanceStateActive}
+	}
+	if !contains(allowed, s.Status) {
+		return deny(reasonInstanceStateNotAllowed, fmt.Errorf("instance %v is in state %q, which is not allowed", s.ID, s.Status))
+	}
+	if s.Status != instanceStateActive {
+		return nil
+	}
+	if s.VmState != "" && s.VmState != vmStateActive {
+		return deny(reasonInstanceStateNotAllowed, fmt.Errorf("instance %v is ACTIVE but in vm_state %q", s.ID, s.VmState))
+	}
+	if s.PowerState != extendedstatus.NOSTATE && s.PowerState != extendedstatus.RUNNING {
+		return deny(reasonInstanceStateNotAllowed, fmt.Errorf("instance %v is ACTIVE but in power_state %v", s.ID, s.PowerState))
+	}
+	return nil
+}
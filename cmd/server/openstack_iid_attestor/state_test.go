@@ -0,0 +1,87 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestInstanceState(t *testing.T) {
+	tCase := []struct {
+		status   string
+		vmState  string
+		power    extendedstatus.PowerState
+		allowed  []string
+		attested bool
+		wantCode string
+	}{
+		// 0: running instances are admitted by default
+		{status: "ACTIVE"},
+		// 1: stopped instance
+		{status: "SHUTOFF", wantCode: reasonInstanceStateNotAllowed},
+		// 2: shelved instance, even if its agent attested before
+		{status: "SHELVED_OFFLOADED", attested: true, wantCode: reasonInstanceStateNotAllowed},
+		// 3: instance in error
+		{status: "ERROR", wantCode: reasonInstanceStateNotAllowed},
+		// 4: states allowed in addition
+		{status: "MIGRATING", allowed: []string{"ACTIVE", "MIGRATING"}},
+		// 5: ACTIVE is not allowed unless listed
+		{status: "ACTIVE", allowed: []string{"MIGRATING"}, wantCode: reasonInstanceStateNotAllowed},
+		// 6: running instance reporting the extended status
+		{status: "ACTIVE", vmState: "active", power: extendedstatus.RUNNING},
+		// 7: ACTIVE instance shut down from the guest
+		{status: "ACTIVE", vmState: "active", power: extendedstatus.SHUTDOWN, wantCode: reasonInstanceStateNotAllowed},
+		// 8: ACTIVE instance paused
+		{status: "ACTIVE", vmState: "active", power: extendedstatus.PAUSED, wantCode: reasonInstanceStateNotAllowed},
+		// 9: ACTIVE instance being stopped
+		{status: "ACTIVE", vmState: "stopped", power: extendedstatus.RUNNING, wantCode: reasonInstanceStateNotAllowed},
+		// 10: power_state of other allowed states isn't checked
+		{status: "MIGRATING", vmState: "active", power: extendedstatus.PAUSED, allowed: []string{"MIGRATING"}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstanceWithPowerState(testProjectID, c.status, c.vmState, c.power)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.AllowedInstanceStates = c.allowed
+		p.config.CanReattest = true
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+		if c.attested {
+			p.attestedBeforeHandler = onceAttestedBeforeHandler
+		}
+
+		err := p.Attest(fake.NewAttestStream(testUUID))
+		if c.wantCode == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != c.wantCode {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+		}
+		if isCacheable(err) {
+			t.Errorf("#%v: denial should not be cached: %v", i, err)
+		}
+	}
+}
+
+func TestValidateInstanceStates(t *testing.T) {
+	c := &IIDAttestorPluginConfig{AllowedInstanceStates: []string{"ACTIVE", "VERIFY_RESIZE"}}
+	if err := validateInstanceStates(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.AllowedInstanceStates = []string{"active"}
+	if err := validateInstanceStates(c); err == nil {
+		t.Error("an error expected for an unknown state")
+	}
+}
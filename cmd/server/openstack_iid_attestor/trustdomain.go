@@ -0,0 +1,52 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// validateTrustDomainMapping validates the trust domains the cloud and its projects are mapped to.
+// The cloud must be mapped to the trust domain of the server, if at all, so that a configuration
+// meant for another server of a nested topology fails instead of admitting agents.
+func validateTrustDomainMapping(c *IIDAttestorPluginConfig, trustDomain string) error {
+	for _, td := range c.ProjectTrustDomains {
+		if err := validateTrustDomainName(td); err != nil {
+			return err
+		}
+	}
+	if c.CloudTrustDomain == "" {
+		return nil
+	}
+	if err := validateTrustDomainName(c.CloudTrustDomain); err != nil {
+		return err
+	}
+	if !strings.EqualFold(c.CloudTrustDomain, trustDomain) {
+		return fmt.Errorf("cloud %q is mapped to trust domain %v, but the server is of trust domain %v", c.CloudName, c.CloudTrustDomain, trustDomain)
+	}
+	return nil
+}
+
+func validateTrustDomainName(td string) error {
+	if td == "" || strings.ContainsAny(td, ":/") {
+		return fmt.Errorf("invalid trust domain: %q", td)
+	}
+	return nil
+}
+
+// checkTrustDomain denies instances of projects mapped to trust domains other than the one of the server
+func checkTrustDomain(c *IIDAttestorPluginConfig, s *openstack.Server) error {
+	td, ok := c.ProjectTrustDomains[s.TenantID]
+	if !ok || strings.EqualFold(td, c.trustDomain) {
+		return nil
+	}
+	return deny(reasonTrustDomainMismatch, fmt.Errorf("project %v is mapped to trust domain %v", s.TenantID, td))
+}
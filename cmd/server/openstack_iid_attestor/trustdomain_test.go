@@ -0,0 +1,92 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestAttestTrustDomain(t *testing.T) {
+	tCase := []struct {
+		mapping  map[string]string
+		wantCode string
+	}{
+		// 0: project of the trust domain of the server
+		{mapping: map[string]string{testProjectID: "Example.com"}},
+		// 1: project of another trust domain
+		{mapping: map[string]string{testProjectID: "child.example.com"}, wantCode: reasonTrustDomainMismatch},
+		// 2: projects not mapped belong to the trust domain of the cloud
+		{mapping: map[string]string{"xyz": "child.example.com"}},
+	}
+
+	for i, c := range tCase {
+		p := newTestPlugin()
+		p.instance = fake.NewInstance(testProjectID, nil, nil)
+		p.config.ProjectIDWhitelist = []string{testProjectID}
+		p.config.ProjectTrustDomains = c.mapping
+		p.attestedBeforeHandler = notAttestedBeforeHandler
+
+		err := p.Attest(fake.NewAttestStream(testUUID))
+		if c.wantCode != "" {
+			if code := reasonCode(err); code != c.wantCode {
+				t.Errorf("#%v: got reason code %v, want %v", i, code, c.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: attestation error: %v", i, err)
+		}
+	}
+}
+
+func TestConfigureTrustDomain(t *testing.T) {
+	for i, c := range []struct {
+		conf    string
+		wantErr bool
+	}{
+		// 0: the cloud is mapped to the trust domain of the server
+		{conf: `
+			cloud_name = "test"
+			projectid_whitelist = ["abc"]
+			cloud_trust_domain = "example.com"
+			project_trust_domains = {
+				xyz = "child.example.com"
+			}
+		`},
+		// 1: configuration of the server of another trust domain
+		{conf: `
+			cloud_name = "test"
+			projectid_whitelist = ["abc"]
+			cloud_trust_domain = "child.example.com"
+		`, wantErr: true},
+		// 2: trust domains are names, not IDs
+		{conf: `
+			cloud_name = "test"
+			projectid_whitelist = ["abc"]
+			project_trust_domains = {
+				xyz = "spiffe://child.example.com"
+			}
+		`, wantErr: true},
+	} {
+		p := newTestPlugin()
+		p.getInstanceHandler = func(ctx context.Context, n string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+			return fake.NewInstance(testProjectID, nil, nil), nil
+		}
+
+		_, err := p.Configure(context.Background(), fake.NewFakeConfigureRequest(globalConfig, c.conf))
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const nilUUID = "00000000-0000-0000-0000-000000000000"
+
+// validateUUIDPolicy validates the options of require_uuid
+func validateUUIDPolicy(c *IIDAttestorPluginConfig) error {
+	if len(c.UUIDVersions) > 0 && !c.RequireUUID {
+		return errors.New("uuid_versions requires require_uuid")
+	}
+	for _, v := range c.UUIDVersions {
+		if v < 1 || v > 5 {
+			return fmt.Errorf("invalid uuid_versions: %v", v)
+		}
+	}
+	return nil
+}
+
+// checkInstanceUUID denies instance IDs which Nova can't have generated, so that garbage is rejected
+// without querying OpenStack
+func checkInstanceUUID(c *IIDAttestorPluginConfig, id string) error {
+	if !c.RequireUUID {
+		return nil
+	}
+	version, ok := uuidVersion(id)
+	if !ok {
+		return deny(reasonInvalidInstanceID, fmt.Errorf("instance ID is not a UUID: %q", id))
+	}
+	if id == nilUUID {
+		return deny(reasonInvalidInstanceID, errors.New("instance ID is the nil UUID"))
+	}
+	if len(c.UUIDVersions) == 0 {
+		return nil
+	}
+	for _, v := range c.UUIDVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return deny(reasonInvalidInstanceID, fmt.Errorf("instance ID is a version %v UUID: %v", version, id))
+}
+
+// uuidVersion returns the version of the UUID in the canonical 8-4-4-4-12 form, in lowercase as Nova generates.
+// The second return value is false if id is not in the form.
+func uuidVersion(id string) (int, bool) {
+	if len(id) != len(nilUUID) {
+		return 0, false
+	}
+	for i := 0; i < len(id); i++ {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if id[i] != '-' {
+				return 0, false
+			}
+		case '0' <= id[i] && id[i] <= '9', 'a' <= id[i] && id[i] <= 'f':
+		default:
+			return 0, false
+		}
+	}
+	return hexValue(id[14]), true
+}
+
+func hexValue(b byte) int {
+	if b <= '9' {
+		return int(b - '0')
+	}
+	return int(b-'a') + 10
+}
@@ -0,0 +1,92 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+func TestCheckInstanceUUID(t *testing.T) {
+	tCase := []struct {
+		id       string
+		versions []int
+		wantErr  bool
+	}{
+		// 0: version 4 UUID
+		{id: "2d1d1c6e-0f25-4b5c-9a36-3c8a7e0b4a51"},
+		// 1: version 1 UUID
+		{id: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		// 2: allowed version
+		{id: "2d1d1c6e-0f25-4b5c-9a36-3c8a7e0b4a51", versions: []int{4}},
+		// 3: version not allowed
+		{id: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", versions: []int{4}, wantErr: true},
+		// 4: nil UUID
+		{id: nilUUID, wantErr: true},
+		// 5: not a UUID
+		{id: "123", wantErr: true},
+		// 6: without hyphens
+		{id: "2d1d1c6e0f254b5c9a363c8a7e0b4a51", wantErr: true},
+		// 7: uppercase, which Nova doesn't generate
+		{id: "2D1D1C6E-0F25-4B5C-9A36-3C8A7E0B4A51", wantErr: true},
+		// 8: braces
+		{id: "{2d1d1c6e-0f25-4b5c-9a36-3c8a7e0b4a}", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		err := checkInstanceUUID(&IIDAttestorPluginConfig{RequireUUID: true, UUIDVersions: c.versions}, c.id)
+		if !c.wantErr {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if code := reasonCode(err); code != reasonInvalidInstanceID {
+			t.Errorf("#%v: got reason code %v, want %v", i, code, reasonInvalidInstanceID)
+		}
+	}
+}
+
+func TestAttestInstanceUUID(t *testing.T) {
+	p := newTestPlugin()
+	p.instance = fake.NewErrorInstance("must not be called")
+	p.config.RequireUUID = true
+	p.attestedBeforeHandler = notAttestedBeforeHandler
+
+	err := p.Attest(fake.NewAttestStream(testUUID))
+	if code := reasonCode(err); code != reasonInvalidInstanceID {
+		t.Errorf("got reason code %v, want %v", code, reasonInvalidInstanceID)
+	}
+}
+
+func TestValidateUUIDPolicy(t *testing.T) {
+	for i, c := range []struct {
+		config  *IIDAttestorPluginConfig
+		wantErr bool
+	}{
+		// 0: disabled
+		{config: &IIDAttestorPluginConfig{}},
+		// 1: any version
+		{config: &IIDAttestorPluginConfig{RequireUUID: true}},
+		// 2: version 4 only
+		{config: &IIDAttestorPluginConfig{RequireUUID: true, UUIDVersions: []int{4}}},
+		// 3: versions without require_uuid
+		{config: &IIDAttestorPluginConfig{UUIDVersions: []int{4}}, wantErr: true},
+		// 4: unknown version
+		{config: &IIDAttestorPluginConfig{RequireUUID: true, UUIDVersions: []int{9}}, wantErr: true},
+	} {
+		err := validateUUIDPolicy(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -13,10 +13,7 @@ import (
 	"regexp"
 	"sync"
 
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/secgroups"
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/hcl"
-	"github.com/mitchellh/mapstructure"
 	"github.com/spiffe/spire/pkg/common/catalog"
 	"github.com/spiffe/spire/pkg/common/idutil"
 	spu "github.com/spiffe/spire/pkg/common/util"
@@ -26,10 +23,11 @@ import (
 
 	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/verify"
 )
 
 var (
-	regexpAgentIDPath = regexp.MustCompile(`^/spire/agent/openstack_iid/([^/]+)/([^/]+)$`)
+	regexpAgentIDPath = regexp.MustCompile(`^/spire/agent/openstack_iid/([^/]+)/([^/]+)(?:/([^/]+))?$`)
 )
 
 // IIDResolverPlugin implements he noderesolver Plugin interface
@@ -39,17 +37,25 @@ type IIDResolverPlugin struct {
 	instance openstack.InstanceClient
 
 	mu                 sync.RWMutex
-	getInstanceHandler func(string, hclog.Logger) (openstack.InstanceClient, error)
+	getInstanceHandler func(context.Context, string, *openstack.AuthConfig, hclog.Logger) (openstack.InstanceClient, error)
 }
 
 type IIDResolverPluginConfig struct {
 	// Name of cloud entry in clouds.yaml to use.
 	CloudName string `hcl:"cloud_name"`
+	// Overrides authentication options of the cloud entry.
+	Auth *openstack.AuthConfig `hcl:"auth"`
 	// If true, the plugin makes Selector of Custom Meta Data.
 	CustomMetaData bool `hcl:"custom_meta_data"`
 	// If CustomMetaData is true, the Selector is generated using the specified keys.
 	// If value is empty, use all entries
 	MetaDataKeys []string `hcl:"meta_data_keys"`
+	// Prefixes the values of all selectors with "<cloud_name>:" if "cloud" or "<project ID>:" if "project".
+	// Must match selector_namespace of the attestor. Disabled if empty.
+	SelectorNamespace string `hcl:"selector_namespace"`
+	// Drops selectors of security group names and instance metadata violating the rules. Disabled if not set.
+	// Must match selector_sanitization of the attestor.
+	SelectorSanitization *common.SelectorSanitization `hcl:"selector_sanitization"`
 }
 
 // BuiltIn constructs a catalog Plugin using a new instance of this plugin.
@@ -71,14 +77,20 @@ func New() *IIDResolverPlugin {
 
 func (p *IIDResolverPlugin) Configure(ctx context.Context, req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
 	config := new(IIDResolverPluginConfig)
-	if err := hcl.Decode(config, req.Configuration); err != nil {
+	if err := common.DecodeConfig(config, req.Configuration); err != nil {
 		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
 	}
+	if err := common.ValidateSelectorNamespace(config.SelectorNamespace, config.CloudName); err != nil {
+		return nil, err
+	}
+	if err := config.SelectorSanitization.Validate(); err != nil {
+		return nil, err
+	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	instance, err := p.getInstanceHandler(config.CloudName, p.logger)
+	instance, err := p.getInstanceHandler(ctx, config.CloudName, config.Auth, p.logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare OpenStack Client: %v", err)
 	}
@@ -96,7 +108,7 @@ func (p *IIDResolverPlugin) Resolve(ctx context.Context, req *noderesolver.Resol
 	}
 
 	for _, spiffeID := range req.BaseSpiffeIdList {
-		selectors, err := p.makeSelectorFromSpiffeID(spiffeID)
+		selectors, err := p.makeSelectorFromSpiffeID(ctx, spiffeID)
 		if err != nil {
 			return nil, err
 		}
@@ -112,92 +124,37 @@ func (p *IIDResolverPlugin) GetPluginInfo(ctx context.Context, req *spi.GetPlugi
 }
 
 // makeSelectorFromSpiffeID returns Selector sets related to instance
-func (p *IIDResolverPlugin) makeSelectorFromSpiffeID(spiffeID string) (*spc.Selectors, error) {
+func (p *IIDResolverPlugin) makeSelectorFromSpiffeID(ctx context.Context, spiffeID string) (*spc.Selectors, error) {
 	iid, err := genInstanceIDFromSpiffeID(spiffeID)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := p.instance.Get(iid)
+	s, err := p.instance.Get(ctx, iid)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get instance information: %v", err)
 	}
 
-	sgSelector, err := genSGSelector(s.SecurityGroups)
-	var selectors spc.Selectors
-	selectors.Entries = sgSelector
-
+	values, err := verify.SecurityGroupSelectors(s.SecurityGroups, p.config.SelectorSanitization)
+	if err != nil {
+		return nil, err
+	}
 	if p.config.CustomMetaData {
-		metaSelector := genCustomMetaSelector(s.Metadata, p.config.MetaDataKeys)
-		selectors.Entries = append(selectors.Entries, metaSelector...)
+		values = append(values, verify.MetadataSelectors(s.Metadata, p.config.MetaDataKeys, p.config.SelectorSanitization)...)
 	}
 
+	var selectors spc.Selectors
+	for _, value := range values {
+		selectors.Entries = append(selectors.Entries, &spc.Selector{
+			Type:  common.PluginName,
+			Value: common.NamespacedSelectorValue(p.config.SelectorNamespace, p.config.CloudName, s.TenantID, value),
+		})
+	}
 	spu.SortSelectors(selectors.Entries)
 
 	return &selectors, nil
 }
 
-// genSGSelector generates Selector list about SecurityGroup.
-func genSGSelector(sgMapList []map[string]interface{}) ([]*spc.Selector, error) {
-	var sList []*spc.Selector
-	for i := range sgMapList {
-		m := sgMapList[i]
-		if m == nil {
-			continue
-		}
-
-		var sg secgroups.SecurityGroup
-		if err := mapstructure.Decode(m, &sg); err != nil {
-			return nil, fmt.Errorf("failed to decode SecurityGroup info: %v", err)
-		}
-
-		if sg.ID != "" {
-			sList = append(sList,
-				&spc.Selector{
-					Type:  common.PluginName,
-					Value: fmt.Sprintf("sg:id:%s", sg.ID),
-				})
-		}
-		if sg.Name != "" {
-			sList = append(sList,
-				&spc.Selector{
-					Type:  common.PluginName,
-					Value: fmt.Sprintf("sg:name:%s", sg.Name),
-				})
-		}
-	}
-	return sList, nil
-}
-
-// genCustomMetaSelector generates Selector list about Custom Meta Data.
-func genCustomMetaSelector(meta map[string]string, acceptKeys []string) []*spc.Selector {
-	var sList []*spc.Selector
-
-	if acceptKeys != nil {
-		for _, key := range acceptKeys {
-			if v, ok := meta[key]; ok && v != "" {
-				sList = append(sList,
-					&spc.Selector{
-						Type:  common.PluginName,
-						Value: fmt.Sprintf("meta:%s:%s", key, v),
-					})
-			}
-		}
-	} else {
-		for k, v := range meta {
-			if k != "" && v != "" {
-				sList = append(sList,
-					&spc.Selector{
-						Type:  common.PluginName,
-						Value: fmt.Sprintf("meta:%s:%s", k, v),
-					})
-			}
-		}
-	}
-
-	return sList
-}
-
 // genInstanceIDFromSpiffeID returns InstanceID which is included spiffeID
 func genInstanceIDFromSpiffeID(spiffeID string) (string, error) {
 	u, err := idutil.ParseSpiffeID(spiffeID, idutil.AllowAnyTrustDomainAgent())
@@ -212,12 +169,12 @@ func genInstanceIDFromSpiffeID(spiffeID string) (string, error) {
 }
 
 // getOpenStackInstance returns authenticated openstack compute client.
-func getOpenStackInstance(cloud string, logger hclog.Logger) (openstack.InstanceClient, error) {
-	provider, err := openstack.NewProvider(cloud)
+func getOpenStackInstance(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
+	provider, err := openstack.NewProviderWithAuth(ctx, cloud, auth)
 	if err != nil {
 		return nil, err
 	}
-	return openstack.NewInstance(provider, logger)
+	return openstack.NewInstance(provider, openstack.GetRegion(cloud), logger)
 }
 
 func (p *IIDResolverPlugin) SetLogger(log hclog.Logger) {
@@ -39,7 +39,7 @@ type fakeInstance struct {
 	errMsg    string
 }
 
-func (i *fakeInstance) getFakeOpenStackInstance(cloud string, logger hclog.Logger) (openstack.InstanceClient, error) {
+func (i *fakeInstance) getFakeOpenStackInstance(ctx context.Context, cloud string, auth *openstack.AuthConfig, logger hclog.Logger) (openstack.InstanceClient, error) {
 	if i.errMsg != "" {
 		return nil, errors.New(i.errMsg)
 	} else {
@@ -253,6 +253,110 @@ func TestResolve(t *testing.T) {
 	}
 }
 
+func TestResolveSelectorNamespace(t *testing.T) {
+	fi := &fakeInstance{
+		projectID: testProjectID,
+		secGroup: []map[string]interface{}{
+			{
+				"name": "web",
+			},
+		},
+	}
+
+	for i, c := range []struct {
+		namespace string
+		want      string
+	}{
+		{namespace: "cloud", want: "test:sg:name:web"},
+		{namespace: "project", want: testProjectID + ":sg:name:web"},
+	} {
+		p := New()
+		p.logger = testutil.TestLogger()
+		p.getInstanceHandler = fi.getFakeOpenStackInstance
+
+		ctx := context.Background()
+		req := getFakeConfigureRequest()
+		req.Configuration += fmt.Sprintf("selector_namespace = %q\n", c.namespace)
+		if _, err := p.Configure(ctx, req); err != nil {
+			t.Fatalf("#%v: failed to configure testing: %v", i, err)
+		}
+
+		testSpiffeID := fmt.Sprintf("spiffe://acme.com/spire/agent/openstack_iid/%v/%v", testProjectID, testInstanceID)
+		resp, err := p.Resolve(ctx, getFakeResolveRequest([]string{testSpiffeID}))
+		if err != nil {
+			t.Errorf("#%v: error from Resolve(): %v", i, err)
+			continue
+		}
+		if e := resp.Map[testSpiffeID].Entries; len(e) != 1 || e[0].Value != c.want {
+			t.Errorf("#%v: got %v, want %v", i, e, c.want)
+		}
+	}
+}
+
+func TestResolveSelectorSanitization(t *testing.T) {
+	fi := &fakeInstance{
+		projectID: testProjectID,
+		metaData: map[string]string{
+			"env":  "Prod",
+			"role": "db:sg:name:admin",
+		},
+		secGroup: []map[string]interface{}{
+			{
+				"name": "Web Servers",
+			},
+		},
+	}
+
+	for i, c := range []struct {
+		config string
+		want   []string
+	}{
+		// 0: printable by default
+		{config: `selector_sanitization {}`, want: []string{"meta:env:Prod", "sg:name:Web Servers"}},
+		// 1: strict and lower-cased
+		{config: `selector_sanitization {
+    strictness = "strict"
+    lowercase = true
+}`, want: []string{"meta:env:prod"}},
+		// 2: unknown strictness
+		{config: `selector_sanitization {
+    strictness = "paranoid"
+}`},
+	} {
+		p := New()
+		p.logger = testutil.TestLogger()
+		p.getInstanceHandler = fi.getFakeOpenStackInstance
+
+		ctx := context.Background()
+		req := getFakeConfigureRequest()
+		req.Configuration += c.config
+		_, err := p.Configure(ctx, req)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%v: failed to configure testing: %v", i, err)
+		}
+
+		testSpiffeID := fmt.Sprintf("spiffe://acme.com/spire/agent/openstack_iid/%v/%v", testProjectID, testInstanceID)
+		resp, err := p.Resolve(ctx, getFakeResolveRequest([]string{testSpiffeID}))
+		if err != nil {
+			t.Errorf("#%v: error from Resolve(): %v", i, err)
+			continue
+		}
+		var got []string
+		for _, e := range resp.Map[testSpiffeID].Entries {
+			got = append(got, e.Value)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
 func TestGetInstanceIDFromSpiffeID(t *testing.T) {
 	tCase := []struct {
 		spiffeID string
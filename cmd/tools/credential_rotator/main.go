@@ -0,0 +1,102 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// credential_rotator rotates the Keystone application credential the plugins authenticate with.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const defaultRetryInterval = 5 * time.Minute
+
+// stringList is a flag which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	var roles stringList
+	cloud := flag.String("cloud", "", "name of cloud entry in clouds.yaml of the service user")
+	file := flag.String("credential-file", "", "path of the application_credential_file of the plugins")
+	namePrefix := flag.String("name-prefix", "spire-openstack", "prefix of the names of the application credentials")
+	ttl := flag.Duration("ttl", 0, "lifetime of the application credentials (default: no expiry)")
+	flag.Var(&roles, "role", "role the application credentials are restricted to (can be repeated, default: all roles)")
+	selfRotate := flag.Bool("self-rotate", true, "authenticate with the credential being rotated, which is created unrestricted")
+	grace := flag.Duration("grace", time.Minute, "time the old credential is kept for after the file is replaced")
+	every := flag.Duration("rotate-every", 0, "rotate whenever the credential is older than this, or once and exit if zero")
+	timeout := flag.Duration("timeout", 5*time.Minute, "timeout of each rotation")
+	flag.Parse()
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name: "credential_rotator",
+	})
+	r := &rotator{
+		logger:     logger,
+		keystone:   keystoneClient{cloudName: *cloud},
+		file:       *file,
+		namePrefix: *namePrefix,
+		ttl:        *ttl,
+		roles:      roles,
+		selfRotate: *selfRotate,
+		grace:      *grace,
+		timeout:    *timeout,
+		now:        time.Now,
+	}
+	if err := run(r, *every); err != nil {
+		logger.Error("Rotation failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(r *rotator, every time.Duration) error {
+	if r.file == "" {
+		return errors.New("-credential-file is required")
+	}
+	if every < 0 || (every > 0 && r.ttl > 0 && r.ttl <= every) {
+		return errors.New("-rotate-every must be positive and shorter than -ttl, so that credentials are rotated before they expire")
+	}
+	if r.grace >= r.timeout {
+		return errors.New("-grace must be shorter than -timeout")
+	}
+
+	if every == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		return r.rotate(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return r.run(ctx, every, defaultRetryInterval)
+}
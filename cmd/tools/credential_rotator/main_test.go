@@ -0,0 +1,218 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// fakeKeystone keeps application credentials by ID, authenticating with the credential file or the cloud entry
+type fakeKeystone struct {
+	credentials map[string]string
+	created     int
+	// failVerify fails the verification of new credentials
+	failVerify bool
+	// unrestricted are the credentials allowed to manage credentials
+	unrestricted map[string]bool
+}
+
+func newFakeKeystone() *fakeKeystone {
+	return &fakeKeystone{
+		credentials:  map[string]string{"old": "old-secret"},
+		unrestricted: map[string]bool{"old": true},
+	}
+}
+
+// authenticate returns the ID of the credential of auth, or "" for the cloud entry
+func (f *fakeKeystone) authenticate(auth *openstack.AuthConfig) (string, error) {
+	if auth == nil {
+		return "", nil
+	}
+	c, err := openstack.ReadCredentialFile(auth.ApplicationCredentialFile)
+	if err != nil {
+		return "", err
+	}
+	if secret, ok := f.credentials[c.ID]; !ok || secret != c.Secret {
+		return "", fmt.Errorf("unknown application credential %v", c.ID)
+	}
+	return c.ID, nil
+}
+
+func (f *fakeKeystone) Create(ctx context.Context, auth *openstack.AuthConfig, opts *openstack.ApplicationCredentialOpts) (*openstack.ApplicationCredential, error) {
+	id, err := f.authenticate(auth)
+	if err != nil {
+		return nil, err
+	}
+	if id != "" && !f.unrestricted[id] {
+		return nil, errors.New("restricted application credential")
+	}
+	f.created++
+	c := &openstack.ApplicationCredential{
+		ID:     fmt.Sprintf("new-%d", f.created),
+		Name:   opts.Name,
+		Secret: fmt.Sprintf("new-secret-%d", f.created),
+	}
+	f.credentials[c.ID] = c.Secret
+	f.unrestricted[c.ID] = opts.Unrestricted
+	return c, nil
+}
+
+func (f *fakeKeystone) Verify(ctx context.Context, auth *openstack.AuthConfig) error {
+	if f.failVerify {
+		return errors.New("unauthorized")
+	}
+	_, err := f.authenticate(auth)
+	return err
+}
+
+func (f *fakeKeystone) Delete(ctx context.Context, auth *openstack.AuthConfig, id string) error {
+	if _, err := f.authenticate(auth); err != nil {
+		return err
+	}
+	delete(f.credentials, id)
+	return nil
+}
+
+func newTestRotator(t *testing.T, k keystone) (*rotator, func()) {
+	dir, err := ioutil.TempDir("", "credential_rotator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &rotator{
+		logger:     hclog.NewNullLogger(),
+		keystone:   k,
+		file:       filepath.Join(dir, "credential.json"),
+		namePrefix: "spire",
+		selfRotate: true,
+		timeout:    time.Minute,
+		now:        func() time.Time { return now },
+	}, func() { os.RemoveAll(dir) }
+}
+
+func TestRotate(t *testing.T) {
+	for _, selfRotate := range []bool{true, false} {
+		k := newFakeKeystone()
+		r, cleanup := newTestRotator(t, k)
+		defer cleanup()
+		r.selfRotate = selfRotate
+		if err := openstack.WriteCredentialFile(r.file, &openstack.CredentialFile{ID: "old", Secret: "old-secret"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.rotate(context.Background()); err != nil {
+			t.Fatalf("self-rotate %v: unexpected error: %v", selfRotate, err)
+		}
+		c, err := openstack.ReadCredentialFile(r.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ID != "new-1" || c.Secret != "new-secret-1" || !c.CreatedAt.Equal(r.now()) {
+			t.Errorf("self-rotate %v: unexpected credential file: %+v", selfRotate, c)
+		}
+		if _, ok := k.credentials["old"]; ok {
+			t.Errorf("self-rotate %v: old credential not deleted", selfRotate)
+		}
+		if k.unrestricted["new-1"] != selfRotate {
+			t.Errorf("self-rotate %v: new credential is unrestricted: %v", selfRotate, k.unrestricted["new-1"])
+		}
+	}
+}
+
+func TestRotateBootstrap(t *testing.T) {
+	k := newFakeKeystone()
+	r, cleanup := newTestRotator(t, k)
+	defer cleanup()
+
+	if err := r.rotate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c, err := openstack.ReadCredentialFile(r.file); err != nil || c.ID != "new-1" {
+		t.Errorf("unexpected credential file: %+v, %v", c, err)
+	}
+	if _, ok := k.credentials["old"]; !ok {
+		t.Error("unrelated credential deleted")
+	}
+}
+
+func TestRotateVerificationFailure(t *testing.T) {
+	k := newFakeKeystone()
+	k.failVerify = true
+	r, cleanup := newTestRotator(t, k)
+	defer cleanup()
+	if err := openstack.WriteCredentialFile(r.file, &openstack.CredentialFile{ID: "old", Secret: "old-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.rotate(context.Background()); err == nil {
+		t.Fatal("an error expected, got nil")
+	}
+	if c, err := openstack.ReadCredentialFile(r.file); err != nil || c.ID != "old" {
+		t.Errorf("old credential not restored: %+v, %v", c, err)
+	}
+	if _, ok := k.credentials["new-1"]; ok {
+		t.Error("unused credential not deleted")
+	}
+	if _, ok := k.credentials["old"]; !ok {
+		t.Error("old credential deleted")
+	}
+}
+
+func TestUntilDue(t *testing.T) {
+	r, cleanup := newTestRotator(t, newFakeKeystone())
+	defer cleanup()
+
+	if d, err := r.untilDue(time.Hour); err != nil || d != 0 {
+		t.Errorf("missing file must be due: %v, %v", d, err)
+	}
+	if err := openstack.WriteCredentialFile(r.file, &openstack.CredentialFile{ID: "old", Secret: "old-secret", CreatedAt: r.now().Add(-20 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := r.untilDue(time.Hour); err != nil || d != 40*time.Minute {
+		t.Errorf("got %v, %v, want %v", d, err, 40*time.Minute)
+	}
+	if d, err := r.untilDue(10 * time.Minute); err != nil || d != 0 {
+		t.Errorf("got %v, %v, want 0", d, err)
+	}
+}
+
+func TestRunValidation(t *testing.T) {
+	tCase := []struct {
+		file  string
+		every time.Duration
+		ttl   time.Duration
+		grace time.Duration
+	}{
+		// 0: credential file is required
+		{every: time.Hour},
+		// 1: credentials would expire before rotation
+		{file: "credential.json", every: time.Hour, ttl: time.Hour},
+		// 2: negative interval
+		{file: "credential.json", every: -time.Hour},
+		// 3: grace outlasting the rotation
+		{file: "credential.json", every: time.Hour, grace: time.Minute},
+	}
+
+	for i, c := range tCase {
+		r := &rotator{file: c.file, ttl: c.ttl, grace: c.grace, timeout: time.Minute}
+		if err := run(r, c.every); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// keystone manages the application credentials of the service user
+type keystone interface {
+	Create(ctx context.Context, auth *openstack.AuthConfig, opts *openstack.ApplicationCredentialOpts) (*openstack.ApplicationCredential, error)
+	// Verify authenticates with given options
+	Verify(ctx context.Context, auth *openstack.AuthConfig) error
+	Delete(ctx context.Context, auth *openstack.AuthConfig, id string) error
+}
+
+// keystoneClient manages application credentials with Keystone of the cloud entry
+type keystoneClient struct {
+	cloudName string
+}
+
+func (k keystoneClient) Create(ctx context.Context, auth *openstack.AuthConfig, opts *openstack.ApplicationCredentialOpts) (*openstack.ApplicationCredential, error) {
+	return openstack.CreateApplicationCredential(ctx, k.cloudName, auth, opts)
+}
+
+func (k keystoneClient) Verify(ctx context.Context, auth *openstack.AuthConfig) error {
+	_, err := openstack.IssueToken(ctx, k.cloudName, auth)
+	return err
+}
+
+func (k keystoneClient) Delete(ctx context.Context, auth *openstack.AuthConfig, id string) error {
+	return openstack.DeleteApplicationCredential(ctx, k.cloudName, auth, id)
+}
+
+// rotator rotates the application credential in a credential file, see openstack.CredentialFile
+type rotator struct {
+	logger   hclog.Logger
+	keystone keystone
+	file     string
+	// namePrefix prefixes the names of the credentials, which are suffixed with their creation time
+	namePrefix string
+	// ttl is the lifetime of the credentials, which never expire if zero
+	ttl   time.Duration
+	roles []string
+	// selfRotate authenticates with the credential in the file, which must then be unrestricted. The cloud entry
+	// authenticates otherwise, e.g. with the password of the service user.
+	selfRotate bool
+	// grace is the time the old credential is kept for after the file is replaced, for readers of the file which
+	// don't read it again on re-authentication
+	grace time.Duration
+	// timeout bounds each rotation
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// rotate creates a new application credential, replaces the file with it, verifies that it authenticates and deletes
+// the old one. The old credential is kept in the file if any step but the deletion fails. A missing file is created,
+// authenticating with the cloud entry.
+func (r *rotator) rotate(ctx context.Context) error {
+	old, err := r.current()
+	if err != nil {
+		return err
+	}
+	fileAuth := &openstack.AuthConfig{ApplicationCredentialFile: r.file}
+	var auth *openstack.AuthConfig
+	if old != nil && r.selfRotate {
+		auth = fileAuth
+	}
+
+	now := r.now()
+	opts := &openstack.ApplicationCredentialOpts{
+		Name:         fmt.Sprintf("%s-%s", r.namePrefix, now.UTC().Format("20060102T150405Z")),
+		Description:  "rotated by credential_rotator",
+		Roles:        r.roles,
+		Unrestricted: r.selfRotate,
+	}
+	if r.ttl > 0 {
+		opts.ExpiresAt = now.Add(r.ttl)
+	}
+	created, err := r.keystone.Create(ctx, auth, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create application credential: %v", err)
+	}
+	r.logger.Info("Created application credential", "id", created.ID, "name", created.Name)
+
+	if err := openstack.WriteCredentialFile(r.file, &openstack.CredentialFile{ID: created.ID, Secret: created.Secret, CreatedAt: now}); err != nil {
+		r.discard(ctx, auth, created.ID)
+		return fmt.Errorf("failed to write %v: %v", r.file, err)
+	}
+	if err := r.keystone.Verify(ctx, fileAuth); err != nil {
+		if err := r.restore(old); err != nil {
+			r.logger.Error("Failed to restore the old application credential", "file", r.file, "error", err)
+		}
+		r.discard(ctx, auth, created.ID)
+		return fmt.Errorf("new application credential %v failed to authenticate: %v", created.ID, err)
+	}
+	r.logger.Info("Replaced application credential", "file", r.file, "id", created.ID)
+	if old == nil {
+		return nil
+	}
+
+	if r.grace > 0 {
+		select {
+		case <-time.After(r.grace):
+		case <-ctx.Done():
+			return fmt.Errorf("old application credential %v not deleted: %v", old.ID, ctx.Err())
+		}
+	}
+	if r.selfRotate {
+		auth = fileAuth
+	}
+	if err := r.keystone.Delete(ctx, auth, old.ID); err != nil {
+		return fmt.Errorf("failed to delete old application credential %v: %v", old.ID, err)
+	}
+	r.logger.Info("Deleted old application credential", "id", old.ID)
+	return nil
+}
+
+// current returns the credential in the file, or nil if the file doesn't exist
+func (r *rotator) current() (*openstack.CredentialFile, error) {
+	if _, err := os.Stat(common.ExpandEnv(r.file)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return openstack.ReadCredentialFile(r.file)
+}
+
+// restore puts the old credential back into the file, or removes the file if there was none
+func (r *rotator) restore(old *openstack.CredentialFile) error {
+	if old == nil {
+		return os.Remove(common.ExpandEnv(r.file))
+	}
+	return openstack.WriteCredentialFile(r.file, old)
+}
+
+// discard deletes a credential which didn't replace the old one, which would otherwise be left unused
+func (r *rotator) discard(ctx context.Context, auth *openstack.AuthConfig, id string) {
+	if err := r.keystone.Delete(ctx, auth, id); err != nil {
+		r.logger.Error("Failed to delete unused application credential", "id", id, "error", err)
+	}
+}
+
+// untilDue returns the time until the credential in the file is due for rotation, which is zero if the file doesn't
+// exist
+func (r *rotator) untilDue(every time.Duration) (time.Duration, error) {
+	c, err := r.current()
+	if err != nil || c == nil {
+		return 0, err
+	}
+	if d := c.CreatedAt.Add(every).Sub(r.now()); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
+
+// run rotates the credential whenever it is older than every, until ctx is done. Failed rotations are retried after
+// retry.
+func (r *rotator) run(ctx context.Context, every, retry time.Duration) error {
+	for {
+		wait, err := r.untilDue(every)
+		if err == nil && wait == 0 {
+			rctx, cancel := context.WithTimeout(ctx, r.timeout)
+			err = r.rotate(rctx)
+			cancel()
+			wait = every
+		}
+		if err != nil {
+			r.logger.Error("Failed to rotate application credential", "retry_in", retry, "error", err)
+			wait = retry
+		} else {
+			r.logger.Info("Waiting for the next rotation", "next", r.now().Add(wait).Format(time.RFC3339))
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// drift_report compares the agents attested by the SPIRE Server with the Nova inventory.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spiffe/spire/proto/spire/api/registration"
+	"google.golang.org/grpc"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// stringList is a flag which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	var clouds, projects stringList
+	flag.Var(&clouds, "cloud", "name of cloud entry in clouds.yaml to compare with (can be repeated)")
+	flag.Var(&projects, "project", "project ID to report (can be repeated, default: all)")
+	socket := flag.String("registration-socket", "/tmp/spire-registration.sock", "path of the registration API socket of the SPIRE Server")
+	trustDomain := flag.String("trust-domain", "", "trust domain of the agents to compare (default: all)")
+	allTenants := flag.Bool("all-tenants", true, "list instances of all projects, which requires admin role")
+	output := flag.String("output", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of the report")
+	flag.Parse()
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "drift_report",
+		Output: os.Stderr,
+		Level:  hclog.Warn,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := run(ctx, clouds, projects, *socket, *trustDomain, *allTenants, *output, logger); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, clouds, projects []string, socket, trustDomain string, allTenants bool, output string, logger hclog.Logger) error {
+	if len(clouds) == 0 {
+		return errors.New("at least one -cloud is required")
+	}
+	if output != outputTable && output != outputJSON {
+		return fmt.Errorf("invalid output format: %v", output)
+	}
+
+	inventories := make(map[string]openstack.InstanceLister)
+	for _, cloud := range clouds {
+		provider, err := openstack.NewProvider(ctx, cloud)
+		if err != nil {
+			return fmt.Errorf("failed to prepare OpenStack Client for %v: %v", cloud, err)
+		}
+		ic, err := openstack.NewInstance(provider, openstack.GetRegion(cloud), logger)
+		if err != nil {
+			return fmt.Errorf("failed to prepare OpenStack Client for %v: %v", cloud, err)
+		}
+		inventories[cloud] = ic.(openstack.InstanceLister)
+	}
+
+	conn, err := grpc.DialContext(ctx, socket, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to connect to registration API: %v", err)
+	}
+	defer conn.Close()
+
+	r := &reporter{
+		logger:      logger,
+		agents:      registrationAgents{client: registration.NewRegistrationClient(conn)},
+		inventories: inventories,
+		trustDomain: trustDomain,
+		projects:    projects,
+		allTenants:  allTenants,
+	}
+	report, err := r.report(ctx)
+	if err != nil {
+		return err
+	}
+	return report.write(os.Stdout, output)
+}
+
+// registrationAgents lists attested agents with the registration API of the SPIRE Server
+type registrationAgents struct {
+	client registration.RegistrationClient
+}
+
+func (a registrationAgents) ListAgents(ctx context.Context) ([]string, error) {
+	resp, err := a.client.ListAgents(ctx, &registration.ListAgentsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %v", err)
+	}
+	var ids []string
+	for _, n := range resp.Nodes {
+		ids = append(ids, n.SpiffeId)
+	}
+	return ids, nil
+}
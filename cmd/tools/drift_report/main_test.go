@@ -0,0 +1,130 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+type fakeAgents []string
+
+func (f fakeAgents) ListAgents(ctx context.Context) ([]string, error) {
+	return f, nil
+}
+
+type fakeInventory []*openstack.Server
+
+func (f fakeInventory) List(ctx context.Context, allTenants bool) ([]*openstack.Server, error) {
+	return f, nil
+}
+
+func newServer(projectID, id, name string) *openstack.Server {
+	return &openstack.Server{
+		Server: servers.Server{
+			ID:       id,
+			Name:     name,
+			TenantID: projectID,
+		},
+	}
+}
+
+func newTestReporter(projects []string) *reporter {
+	return &reporter{
+		logger: hclog.NewNullLogger(),
+		agents: fakeAgents{
+			common.GenerateSpiffeID("example.org", "alpha", "i-1"),
+			common.GenerateSpiffeID("example.org", "alpha", "i-2"),
+			common.GenerateSpiffeID("example.org", "bravo", "i-3"),
+			common.GenerateSpiffeID("other.org", "bravo", "i-4"),
+			"spiffe://example.org/spire/agent/x509pop/abc",
+		},
+		inventories: map[string]openstack.InstanceLister{
+			"east": fakeInventory{newServer("alpha", "i-1", "web-1"), newServer("alpha", "i-5", "web-5")},
+			"west": fakeInventory{newServer("bravo", "i-6", "db-6")},
+		},
+		trustDomain: "example.org",
+		projects:    projects,
+	}
+}
+
+func TestReport(t *testing.T) {
+	report, err := newTestReporter(nil).report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := instanceIDs(report.AgentsWithoutInstance); got != "i-2,i-3" {
+		t.Errorf("unexpected agents without instance: %v", got)
+	}
+	if got := instanceIDs(report.InstancesWithoutAgent); got != "i-5,i-6" {
+		t.Errorf("unexpected instances without agent: %v", got)
+	}
+	if d := report.InstancesWithoutAgent[1]; d.Cloud != "west" || d.ProjectID != "bravo" || d.InstanceName != "db-6" {
+		t.Errorf("unexpected drift: %+v", d)
+	}
+}
+
+func TestReportProjects(t *testing.T) {
+	report, err := newTestReporter([]string{"alpha"}).report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := instanceIDs(report.AgentsWithoutInstance); got != "i-2" {
+		t.Errorf("unexpected agents without instance: %v", got)
+	}
+	if got := instanceIDs(report.InstancesWithoutAgent); got != "i-5" {
+		t.Errorf("unexpected instances without agent: %v", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	report, err := newTestReporter(nil).report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := report.write(&b, outputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.AgentsWithoutInstance) != 2 || len(decoded.InstancesWithoutAgent) != 2 {
+		t.Errorf("unexpected report: %+v", decoded)
+	}
+
+	b.Reset()
+	if err := report.write(&b, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "DRIFT") {
+		t.Errorf("unexpected table:\n%v", b.String())
+	}
+}
+
+func instanceIDs(dl []Drift) string {
+	var ids []string
+	for _, d := range dl {
+		ids = append(ids, d.InstanceID)
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,186 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// agentLister lists the SPIFFE IDs of attested agents
+type agentLister interface {
+	ListAgents(ctx context.Context) ([]string, error)
+}
+
+// Drift is an agent without a live instance or an instance without an attested agent
+type Drift struct {
+	Cloud        string `json:"cloud,omitempty"`
+	ProjectID    string `json:"project_id"`
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name,omitempty"`
+	SpiffeID     string `json:"spiffe_id,omitempty"`
+}
+
+// Report is the result of the comparison
+type Report struct {
+	AgentsWithoutInstance []Drift `json:"agents_without_instance"`
+	InstancesWithoutAgent []Drift `json:"instances_without_agent"`
+}
+
+type reporter struct {
+	logger      hclog.Logger
+	agents      agentLister
+	inventories map[string]openstack.InstanceLister
+	trustDomain string
+	projects    []string
+	allTenants  bool
+}
+
+func (r *reporter) report(ctx context.Context) (*Report, error) {
+	ids, err := r.agents.ListAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// instance ID -> agent
+	agents := make(map[string]Drift)
+	for _, id := range ids {
+		projectID, instanceID, err := common.ParseSpiffeID(id)
+		if err != nil {
+			// agents attested by other plugins
+			continue
+		}
+		if r.trustDomain != "" {
+			if u, err := url.Parse(id); err != nil || u.Host != r.trustDomain {
+				continue
+			}
+		}
+		if !r.reported(projectID) {
+			continue
+		}
+		agents[instanceID] = Drift{
+			ProjectID:  projectID,
+			InstanceID: instanceID,
+			SpiffeID:   id,
+		}
+	}
+
+	report := &Report{
+		AgentsWithoutInstance: []Drift{},
+		InstancesWithoutAgent: []Drift{},
+	}
+	instanceIDs := make([]string, 0, len(agents))
+	for instanceID := range agents {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	live := make(map[string]bool)
+	for cloud, inventory := range r.inventories {
+		// a single listing per cloud serves both directions of the comparison
+		v := openstack.NewBatchVerifier(inventory, r.allTenants, 0)
+		found, _, err := v.Verify(ctx, instanceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances of %v: %v", cloud, err)
+		}
+		for instanceID := range found {
+			live[instanceID] = true
+		}
+
+		sl, err := v.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances of %v: %v", cloud, err)
+		}
+		for _, s := range sl {
+			if !r.reported(s.TenantID) {
+				continue
+			}
+			if a, ok := agents[s.ID]; ok && a.ProjectID == s.TenantID {
+				continue
+			}
+			report.InstancesWithoutAgent = append(report.InstancesWithoutAgent, Drift{
+				Cloud:        cloud,
+				ProjectID:    s.TenantID,
+				InstanceID:   s.ID,
+				InstanceName: s.Name,
+			})
+		}
+	}
+	for instanceID, a := range agents {
+		if !live[instanceID] {
+			report.AgentsWithoutInstance = append(report.AgentsWithoutInstance, a)
+		}
+	}
+
+	sortDrifts(report.AgentsWithoutInstance)
+	sortDrifts(report.InstancesWithoutAgent)
+	return report, nil
+}
+
+// reported returns true if the drift of given project should be reported
+func (r *reporter) reported(projectID string) bool {
+	if len(r.projects) == 0 {
+		return true
+	}
+	for _, p := range r.projects {
+		if p == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+func sortDrifts(dl []Drift) {
+	sort.Slice(dl, func(i, j int) bool {
+		if dl[i].Cloud != dl[j].Cloud {
+			return dl[i].Cloud < dl[j].Cloud
+		}
+		if dl[i].ProjectID != dl[j].ProjectID {
+			return dl[i].ProjectID < dl[j].ProjectID
+		}
+		return dl[i].InstanceID < dl[j].InstanceID
+	})
+}
+
+func (r *Report) write(w io.Writer, output string) error {
+	if output == outputJSON {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DRIFT\tCLOUD\tPROJECT\tINSTANCE\tNAME\tSPIFFE ID")
+	for _, d := range r.AgentsWithoutInstance {
+		fmt.Fprintf(tw, "agent_without_instance\t%v\t%v\t%v\t%v\t%v\n", orDash(d.Cloud), d.ProjectID, d.InstanceID, orDash(d.InstanceName), d.SpiffeID)
+	}
+	for _, d := range r.InstancesWithoutAgent {
+		fmt.Fprintf(tw, "instance_without_agent\t%v\t%v\t%v\t%v\t%v\n", d.Cloud, d.ProjectID, d.InstanceID, orDash(d.InstanceName), orDash(d.SpiffeID))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
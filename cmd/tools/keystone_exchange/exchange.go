@@ -0,0 +1,140 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+// svidValidator validates SVIDs presented by workloads and returns their SPIFFE ID
+type svidValidator interface {
+	VerifyX509SVID(chain []*x509.Certificate) (string, error)
+	ValidateJWTSVID(ctx context.Context, token, audience string) (string, error)
+}
+
+// issuer issues Keystone credentials for a mapping
+type issuer interface {
+	IssueToken(ctx context.Context, m *MappingConfig) (*openstack.Token, error)
+	CreateApplicationCredential(ctx context.Context, m *MappingConfig, spiffeID string) (*openstack.ApplicationCredential, error)
+}
+
+// keystoneIssuer issues credentials with Keystone
+type keystoneIssuer struct{}
+
+func (keystoneIssuer) IssueToken(ctx context.Context, m *MappingConfig) (*openstack.Token, error) {
+	return openstack.IssueToken(ctx, m.CloudName, m.Auth)
+}
+
+func (keystoneIssuer) CreateApplicationCredential(ctx context.Context, m *MappingConfig, spiffeID string) (*openstack.ApplicationCredential, error) {
+	now := time.Now()
+	name := fmt.Sprintf("spire-%d", now.UnixNano())
+	return openstack.CreateApplicationCredential(ctx, m.CloudName, m.Auth, &openstack.ApplicationCredentialOpts{
+		Name:        name,
+		Description: spiffeID,
+		Roles:       m.Roles,
+		ExpiresAt:   now.Add(m.credentialTTL),
+	})
+}
+
+// exchange is the HTTP handler exchanging SVIDs for Keystone credentials
+type exchange struct {
+	logger    hclog.Logger
+	audience  string
+	mappings  map[string]*MappingConfig
+	validator svidValidator
+	issuer    issuer
+}
+
+func newExchange(c *ExchangeConfig, v svidValidator, i issuer, logger hclog.Logger) http.Handler {
+	mappings := make(map[string]*MappingConfig)
+	for _, m := range c.Mappings {
+		mappings[m.SpiffeID] = m
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/exchange", &exchange{
+		logger:    logger,
+		audience:  c.Audience,
+		mappings:  mappings,
+		validator: v,
+		issuer:    i,
+	})
+	return mux
+}
+
+// exchangeResponse is the body of a successful exchange
+type exchangeResponse struct {
+	SpiffeID              string                           `json:"spiffe_id"`
+	Token                 *openstack.Token                 `json:"token,omitempty"`
+	ApplicationCredential *openstack.ApplicationCredential `json:"application_credential,omitempty"`
+}
+
+func (e *exchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	spiffeID, err := e.authenticate(r)
+	if err != nil {
+		e.logger.Warn("Rejected exchange request", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "invalid SVID", http.StatusUnauthorized)
+		return
+	}
+
+	m, ok := e.mappings[spiffeID]
+	if !ok {
+		e.logger.Warn("No mapping for SPIFFE ID", "spiffe_id", spiffeID)
+		http.Error(w, "no credentials for the SPIFFE ID", http.StatusForbidden)
+		return
+	}
+
+	resp := &exchangeResponse{SpiffeID: spiffeID}
+	switch m.Kind {
+	case kindApplicationCredential:
+		resp.ApplicationCredential, err = e.issuer.CreateApplicationCredential(r.Context(), m, spiffeID)
+	default:
+		resp.Token, err = e.issuer.IssueToken(r.Context(), m)
+	}
+	if err != nil {
+		e.logger.Error("Failed to issue credentials", "spiffe_id", spiffeID, "error", err)
+		http.Error(w, "failed to issue credentials", http.StatusBadGateway)
+		return
+	}
+
+	e.logger.Info("Exchanged SVID", "spiffe_id", spiffeID, "kind", m.Kind)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// authenticate returns the SPIFFE ID of the JWT-SVID in the Authorization header or,
+// if there is none, of the X.509-SVID presented as the client certificate.
+func (e *exchange) authenticate(r *http.Request) (string, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		const prefix = "Bearer "
+		if !strings.HasPrefix(h, prefix) {
+			return "", errors.New("unsupported authorization scheme")
+		}
+		return e.validator.ValidateJWTSVID(r.Context(), strings.TrimPrefix(h, prefix), e.audience)
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", errors.New("no SVID presented")
+	}
+	return e.validator.VerifyX509SVID(r.TLS.PeerCertificates)
+}
@@ -0,0 +1,182 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// keystone_exchange exchanges SPIFFE SVIDs of workloads for Keystone tokens or application credentials.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/workload"
+)
+
+const (
+	kindToken                 = "token"
+	kindApplicationCredential = "application_credential"
+
+	defaultListenAddress = ":8443"
+	defaultCredentialTTL = time.Hour
+)
+
+// ExchangeConfig is the configuration of the exchange service
+type ExchangeConfig struct {
+	// Address the service listens on with TLS
+	ListenAddress string `hcl:"listen_address"`
+	// Path of the Workload API socket of the local SPIRE Agent
+	WorkloadAPISocket string `hcl:"workload_api_socket"`
+	// Audience JWT-SVIDs must be issued for
+	Audience string `hcl:"audience"`
+	// Default cloud entry in clouds.yaml of the mappings
+	CloudName string `hcl:"cloud_name"`
+	// Credentials issued for each SPIFFE ID
+	Mappings []*MappingConfig `hcl:"mapping"`
+}
+
+// MappingConfig maps a SPIFFE ID to the Keystone credentials issued for it
+type MappingConfig struct {
+	SpiffeID string `hcl:",key"`
+	// Cloud entry in clouds.yaml, overriding the default one
+	CloudName string `hcl:"cloud_name"`
+	// Credentials used to authenticate on behalf of the workload
+	Auth *openstack.AuthConfig `hcl:"auth"`
+	// "token" (default) or "application_credential"
+	Kind string `hcl:"kind"`
+	// Lifetime of application credentials, e.g. "1h"
+	CredentialTTL string `hcl:"credential_ttl"`
+	// Roles application credentials are restricted to
+	Roles []string `hcl:"roles"`
+
+	credentialTTL time.Duration
+}
+
+func loadConfig(path string) (*ExchangeConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &ExchangeConfig{}
+	if err := common.DecodeConfig(c, string(b)); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ExchangeConfig) validate() error {
+	if c.ListenAddress == "" {
+		c.ListenAddress = defaultListenAddress
+	}
+	if c.WorkloadAPISocket == "" {
+		return errors.New("workload_api_socket is required")
+	}
+	if c.Audience == "" {
+		return errors.New("audience is required")
+	}
+	if len(c.Mappings) == 0 {
+		return errors.New("at least one mapping is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range c.Mappings {
+		if seen[m.SpiffeID] {
+			return fmt.Errorf("duplicate mapping: %v", m.SpiffeID)
+		}
+		seen[m.SpiffeID] = true
+
+		if m.CloudName == "" {
+			m.CloudName = c.CloudName
+		}
+		switch m.Kind {
+		case "":
+			m.Kind = kindToken
+		case kindToken, kindApplicationCredential:
+		default:
+			return fmt.Errorf("invalid kind of mapping %v: %v", m.SpiffeID, m.Kind)
+		}
+		m.credentialTTL = defaultCredentialTTL
+		if m.CredentialTTL != "" {
+			d, err := time.ParseDuration(m.CredentialTTL)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid credential_ttl of mapping %v: %v", m.SpiffeID, m.CredentialTTL)
+			}
+			m.credentialTTL = d
+		}
+	}
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "keystone_exchange.conf", "path to the configuration file")
+	flag.Parse()
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name: "keystone_exchange",
+	})
+	if err := run(*configPath, logger); err != nil {
+		logger.Error("Exchange service failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, logger hclog.Logger) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := workload.Dial(config.WorkloadAPISocket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Workload API: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- fmt.Errorf("X.509-SVID watch failed: %v", client.WatchX509(ctx))
+	}()
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 30*time.Second)
+	err = client.WaitX509(waitCtx)
+	waitCancel()
+	if err != nil {
+		return fmt.Errorf("failed to fetch X.509-SVID: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: newExchange(config, client, keystoneIssuer{}, logger),
+		TLSConfig: &tls.Config{
+			GetCertificate: client.GetCertificate,
+			ClientAuth:     tls.RequestClientCert,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+	go func() {
+		logger.Info("Listening", "address", config.ListenAddress)
+		errCh <- server.ListenAndServeTLS("", "")
+	}()
+
+	err = <-errCh
+	server.Close()
+	return err
+}
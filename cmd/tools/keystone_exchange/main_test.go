@@ -0,0 +1,167 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const testConfig = `
+workload_api_socket = "/tmp/agent.sock"
+audience = "keystone"
+cloud_name = "test"
+
+mapping "spiffe://example.org/web" {
+	auth {
+		application_credential_id = "web"
+		application_credential_secret = "secret"
+	}
+}
+
+mapping "spiffe://example.org/batch" {
+	kind = "application_credential"
+	credential_ttl = "10m"
+	roles = ["reader"]
+}
+`
+
+type fakeValidator struct{}
+
+func (fakeValidator) VerifyX509SVID(chain []*x509.Certificate) (string, error) {
+	return "spiffe://example.org/batch", nil
+}
+
+func (fakeValidator) ValidateJWTSVID(ctx context.Context, token, audience string) (string, error) {
+	if audience != "keystone" {
+		return "", errors.New("invalid audience")
+	}
+	switch token {
+	case "web":
+		return "spiffe://example.org/web", nil
+	case "unknown":
+		return "spiffe://example.org/unknown", nil
+	}
+	return "", errors.New("invalid token")
+}
+
+type fakeIssuer struct{}
+
+func (fakeIssuer) IssueToken(ctx context.Context, m *MappingConfig) (*openstack.Token, error) {
+	return &openstack.Token{ID: "token-" + m.Auth.ApplicationCredentialID}, nil
+}
+
+func (fakeIssuer) CreateApplicationCredential(ctx context.Context, m *MappingConfig, spiffeID string) (*openstack.ApplicationCredential, error) {
+	return &openstack.ApplicationCredential{ID: "appcred", ExpiresAt: time.Unix(0, 0).Add(m.credentialTTL)}, nil
+}
+
+func newTestExchange(t *testing.T) http.Handler {
+	c := &ExchangeConfig{}
+	if err := common.DecodeConfig(c, testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return newExchange(c, fakeValidator{}, fakeIssuer{}, hclog.NewNullLogger())
+}
+
+func TestValidate(t *testing.T) {
+	c := &ExchangeConfig{}
+	if err := common.DecodeConfig(c, testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ListenAddress != defaultListenAddress {
+		t.Errorf("unexpected listen address: %v", c.ListenAddress)
+	}
+	if m := c.Mappings[0]; m.SpiffeID != "spiffe://example.org/web" || m.Kind != kindToken || m.CloudName != "test" {
+		t.Errorf("unexpected mapping: %+v", m)
+	}
+	if m := c.Mappings[1]; m.credentialTTL != 10*time.Minute || len(m.Roles) != 1 {
+		t.Errorf("unexpected mapping: %+v", m)
+	}
+
+	c.Mappings[1].Kind = "password"
+	if err := c.validate(); err == nil {
+		t.Error("expected error for invalid kind")
+	}
+}
+
+func TestExchangeJWTSVID(t *testing.T) {
+	h := newTestExchange(t)
+
+	for _, c := range []struct {
+		token string
+		code  int
+	}{
+		{token: "web", code: http.StatusOK},
+		{token: "unknown", code: http.StatusForbidden},
+		{token: "invalid", code: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/exchange", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("unexpected status for %v: got %v, want %v", c.token, rec.Code, c.code)
+			continue
+		}
+		if c.code != http.StatusOK {
+			continue
+		}
+
+		var resp exchangeResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.SpiffeID != "spiffe://example.org/web" || resp.Token == nil || resp.Token.ID != "token-web" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	}
+}
+
+func TestExchangeX509SVID(t *testing.T) {
+	h := newTestExchange(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/exchange", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status without SVID: %v", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/exchange", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v", rec.Code)
+	}
+	var resp exchangeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ApplicationCredential == nil || resp.ApplicationCredential.ID != "appcred" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
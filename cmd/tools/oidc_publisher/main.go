@@ -0,0 +1,141 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// oidc_publisher publishes the JWT-SVID signing keys and an OIDC discovery document to a Swift container.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/workload"
+)
+
+const (
+	defaultCacheControl = "public, max-age=300"
+	retryInterval       = 5 * time.Second
+)
+
+// PublisherConfig is the configuration of the publisher
+type PublisherConfig struct {
+	// Path of the Workload API socket of the local SPIRE Agent
+	WorkloadAPISocket string `hcl:"workload_api_socket"`
+	// Trust domain whose JWT signing keys are published
+	TrustDomain string `hcl:"trust_domain"`
+	// Issuer URL relying parties see, i.e. the public URL of the container
+	Issuer string `hcl:"issuer"`
+	// Name of cloud entry in clouds.yaml
+	CloudName string `hcl:"cloud_name"`
+	// Overrides authentication options of the cloud entry
+	Auth *openstack.AuthConfig `hcl:"auth"`
+	// Swift container serving the documents
+	Container string `hcl:"container"`
+	// Create the container and make it world-readable on startup
+	ManageContainer bool `hcl:"manage_container"`
+	// Cache-Control header of the published objects
+	CacheControl string `hcl:"cache_control"`
+}
+
+func loadConfig(path string) (*PublisherConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &PublisherConfig{}
+	if err := common.DecodeConfig(c, string(b)); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *PublisherConfig) validate() error {
+	if c.WorkloadAPISocket == "" {
+		return errors.New("workload_api_socket is required")
+	}
+	if c.TrustDomain == "" {
+		return errors.New("trust_domain is required")
+	}
+	if c.Container == "" {
+		return errors.New("container is required")
+	}
+	u, err := url.Parse(c.Issuer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("issuer must be an https URL: %v", c.Issuer)
+	}
+	c.Issuer = strings.TrimSuffix(c.Issuer, "/")
+	if c.CacheControl == "" {
+		c.CacheControl = defaultCacheControl
+	}
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "oidc_publisher.conf", "path to the configuration file")
+	flag.Parse()
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name: "oidc_publisher",
+	})
+	if err := run(*configPath, logger); err != nil {
+		logger.Error("Publisher failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, logger hclog.Logger) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	provider, err := openstack.NewProviderWithAuth(ctx, config.CloudName, config.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to prepare OpenStack Client: %v", err)
+	}
+	storage, err := openstack.NewObjectStorage(provider, openstack.GetRegion(config.CloudName), logger)
+	if err != nil {
+		return fmt.Errorf("failed to prepare OpenStack Object Storage Client: %v", err)
+	}
+	if config.ManageContainer {
+		if err := storage.EnsurePublicContainer(ctx, config.Container); err != nil {
+			return fmt.Errorf("failed to prepare container: %v", err)
+		}
+	}
+
+	client, err := workload.Dial(config.WorkloadAPISocket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Workload API: %v", err)
+	}
+	defer client.Close()
+
+	p := newPublisher(config, storage, logger)
+	for {
+		err := client.WatchJWTBundles(ctx, func(bundles map[string][]byte) error {
+			if err := p.publish(ctx, bundles); err != nil {
+				logger.Error("Failed to publish", "error", err)
+			}
+			return nil
+		})
+		logger.Warn("JWT bundle watch failed, retrying", "error", err)
+		time.Sleep(retryInterval)
+	}
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type fakeStorage struct {
+	objects map[string][]byte
+	puts    int
+	err     error
+}
+
+func (f *fakeStorage) EnsurePublicContainer(ctx context.Context, container string) error {
+	return nil
+}
+
+func (f *fakeStorage) PutObject(ctx context.Context, container, name, contentType, cacheControl string, body []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.puts++
+	f.objects[container+"/"+name] = body
+	return nil
+}
+
+const testBundle = `{"keys":[
+	{"kty":"EC","kid":"a","crv":"P-256","x":"x","y":"y","use":"jwt-svid"},
+	{"kty":"EC","crv":"P-256","x":"x","y":"y","use":"x509-svid"}
+]}`
+
+func newTestPublisher(t *testing.T) (*publisher, *fakeStorage) {
+	c := &PublisherConfig{
+		WorkloadAPISocket: "/tmp/agent.sock",
+		TrustDomain:       "example.org",
+		Issuer:            "https://oidc.example.org/",
+		Container:         "oidc",
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeStorage{objects: make(map[string][]byte)}
+	return newPublisher(c, s, hclog.NewNullLogger()), s
+}
+
+func TestPublish(t *testing.T) {
+	p, s := newTestPublisher(t)
+	bundles := map[string][]byte{"spiffe://example.org": []byte(testBundle)}
+
+	if err := p.publish(context.Background(), bundles); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(s.objects["oidc/keys"], &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Keys) != 1 || keys.Keys[0]["kid"] != "a" || keys.Keys[0]["use"] != "sig" {
+		t.Errorf("unexpected keys: %v", keys.Keys)
+	}
+
+	var d discoveryDocument
+	if err := json.Unmarshal(s.objects["oidc/.well-known/openid-configuration"], &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Issuer != "https://oidc.example.org" || d.JWKSURI != "https://oidc.example.org/keys" {
+		t.Errorf("unexpected discovery document: %+v", d)
+	}
+
+	// unchanged bundles are not uploaded again
+	if err := p.publish(context.Background(), bundles); err != nil {
+		t.Fatal(err)
+	}
+	if s.puts != 2 {
+		t.Errorf("unexpected number of uploads: %v", s.puts)
+	}
+}
+
+func TestPublishFailure(t *testing.T) {
+	p, s := newTestPublisher(t)
+	bundles := map[string][]byte{"spiffe://example.org": []byte(testBundle)}
+
+	if err := p.publish(context.Background(), map[string][]byte{}); err == nil {
+		t.Error("expected error for missing trust domain")
+	}
+
+	s.err = errors.New("unavailable")
+	if err := p.publish(context.Background(), bundles); err == nil {
+		t.Error("expected error for failed upload")
+	}
+
+	// failed uploads are retried with the next update
+	s.err = nil
+	if err := p.publish(context.Background(), bundles); err != nil {
+		t.Fatal(err)
+	}
+	if s.puts != 2 {
+		t.Errorf("unexpected number of uploads: %v", s.puts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &PublisherConfig{
+		WorkloadAPISocket: "/tmp/agent.sock",
+		TrustDomain:       "example.org",
+		Issuer:            "http://oidc.example.org",
+		Container:         "oidc",
+	}
+	if err := c.validate(); err == nil {
+		t.Error("expected error for non-https issuer")
+	}
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	keysObject      = "keys"
+	discoveryObject = ".well-known/openid-configuration"
+)
+
+// discoveryDocument is the subset of the OpenID Provider Metadata relying parties need to verify JWT-SVIDs
+type discoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// publisher uploads the JWKS and the discovery document when the JWT bundle changes
+type publisher struct {
+	logger  hclog.Logger
+	config  *PublisherConfig
+	storage openstack.ObjectStorageClient
+
+	// last published JWKS
+	last []byte
+}
+
+func newPublisher(c *PublisherConfig, storage openstack.ObjectStorageClient, logger hclog.Logger) *publisher {
+	return &publisher{
+		logger:  logger,
+		config:  c,
+		storage: storage,
+	}
+}
+
+func (p *publisher) publish(ctx context.Context, bundles map[string][]byte) error {
+	td := "spiffe://" + p.config.TrustDomain
+	bundle, ok := bundles[td]
+	if !ok {
+		return fmt.Errorf("no JWT bundle for trust domain: %v", td)
+	}
+	keys, err := publicJWKS(bundle)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(keys, p.last) {
+		return nil
+	}
+
+	discovery, err := json.Marshal(&discoveryDocument{
+		Issuer:                           p.config.Issuer,
+		JWKSURI:                          p.config.Issuer + "/" + keysObject,
+		AuthorizationEndpoint:            "",
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{"RS256", "ES256", "ES384"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keys go first so that the discovery document never points to stale keys
+	if err := p.storage.PutObject(ctx, p.config.Container, keysObject, "application/json", p.config.CacheControl, keys); err != nil {
+		return fmt.Errorf("failed to upload JWKS: %v", err)
+	}
+	if err := p.storage.PutObject(ctx, p.config.Container, discoveryObject, "application/json", p.config.CacheControl, discovery); err != nil {
+		return fmt.Errorf("failed to upload discovery document: %v", err)
+	}
+
+	p.last = keys
+	p.logger.Info("Published JWT signing keys", "trust_domain", p.config.TrustDomain, "container", p.config.Container)
+	return nil
+}
+
+// publicJWKS returns the JWT signing keys of the bundle as a JWKS for relying parties.
+// SPIRE marks the keys with "use": "jwt-svid", which is replaced with the standard "sig".
+func publicJWKS(bundle []byte) ([]byte, error) {
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(bundle, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT bundle: %v", err)
+	}
+
+	keys := []map[string]interface{}{}
+	for _, k := range set.Keys {
+		switch k["use"] {
+		case nil, "jwt-svid", "sig":
+		default:
+			continue
+		}
+		k["use"] = "sig"
+		keys = append(keys, k)
+	}
+	return json.Marshal(map[string]interface{}{
+		"keys": keys,
+	})
+}
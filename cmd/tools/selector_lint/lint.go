@@ -0,0 +1,421 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	// reasonUnknown is of selectors of no category the plugins emit, e.g. of a renamed category
+	reasonUnknown = "unknown"
+	// reasonDisabled is of selectors of a category the configuration doesn't enable
+	reasonDisabled = "disabled"
+	// reasonNamespace is of selectors lacking the prefix of selector_namespace
+	reasonNamespace = "namespace"
+	// reasonNotNormalized is of selectors the plugins emit in another form, e.g. lower-cased, or never due to
+	// selector_sanitization
+	reasonNotNormalized = "not_normalized"
+)
+
+// ranks of the results of checking a selector value against a plugin, by how close the plugin comes to emitting it
+const (
+	rankUnknown = iota
+	rankNamespace
+	rankDisabled
+	rankNotNormalized
+	rankEmitted
+)
+
+var unknown = result{rank: rankUnknown, reason: reasonUnknown, detail: "no selector of the plugins has this form"}
+
+var regexpHostname = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// entryLister lists the registration entries of the SPIRE Server
+type entryLister interface {
+	ListEntries(ctx context.Context) ([]*spc.RegistrationEntry, error)
+}
+
+// attestorConfig is the part of the configuration of the openstack_iid node attestor which decides its selectors
+type attestorConfig struct {
+	CloudName               string                       `hcl:"cloud_name"`
+	HostnameHints           bool                         `hcl:"hostname_hints"`
+	DNSZone                 string                       `hcl:"dns_zone"`
+	HomeRegion              string                       `hcl:"home_region"`
+	HomeAvailabilityZones   []string                     `hcl:"home_availability_zones"`
+	LocalityMode            string                       `hcl:"locality_mode"`
+	AllowedPortDeviceOwners []string                     `hcl:"allowed_port_device_owners"`
+	PortDeviceOwnerMode     string                       `hcl:"port_device_owner_mode"`
+	BootSecuritySelectors   bool                         `hcl:"boot_security_selectors"`
+	InstanceChangeMode      string                       `hcl:"instance_change_mode"`
+	EnrichmentFailureMode   string                       `hcl:"enrichment_failure_mode"`
+	LogicalNodePattern      string                       `hcl:"logical_node_pattern"`
+	SelectorNamespace       string                       `hcl:"selector_namespace"`
+	SelectorSanitization    *common.SelectorSanitization `hcl:"selector_sanitization"`
+	logicalNodePattern      *regexp.Regexp
+}
+
+// resolverConfig is the part of the configuration of the openstack_iid node resolver which decides its selectors
+type resolverConfig struct {
+	CloudName            string                       `hcl:"cloud_name"`
+	CustomMetaData       bool                         `hcl:"custom_meta_data"`
+	MetaDataKeys         []string                     `hcl:"meta_data_keys"`
+	SelectorNamespace    string                       `hcl:"selector_namespace"`
+	SelectorSanitization *common.SelectorSanitization `hcl:"selector_sanitization"`
+}
+
+// Finding is a selector of a registration entry which the plugins can't emit as configured, so that the entry no
+// longer applies to any agent
+type Finding struct {
+	EntryID  string `json:"entry_id"`
+	SpiffeID string `json:"spiffe_id"`
+	Selector string `json:"selector"`
+	Reason   string `json:"reason"`
+	Detail   string `json:"detail"`
+}
+
+// category is a kind of selector a plugin emits, e.g. "sg:name:<name>"
+type category struct {
+	// value is the selector value, or its prefix if variable
+	value    string
+	variable bool
+	// disabled returns why the plugin doesn't emit the selector with the variable part, or "" if it does
+	disabled func(rest string) string
+	// normalize returns the variable part as the plugin emits it, or false if the plugin never emits it. Nil if
+	// the variable part is emitted as is.
+	normalize func(rest string) (string, bool)
+}
+
+func (c *category) match(value string) (string, bool) {
+	if !c.variable {
+		return "", value == c.value
+	}
+	if !strings.HasPrefix(value, c.value) || len(value) == len(c.value) {
+		return "", false
+	}
+	return value[len(c.value):], true
+}
+
+// plugin is a plugin emitting selectors of the "openstack_iid" type
+type plugin struct {
+	name string
+	// configured is false if the configuration of the plugin wasn't given, which is taken as the plugin not being
+	// enabled
+	configured bool
+	namespace  string
+	cloudName  string
+	categories []category
+}
+
+type result struct {
+	rank   int
+	reason string
+	detail string
+}
+
+func newAttestor(c *attestorConfig) *plugin {
+	p := &plugin{name: "attestor", configured: c != nil}
+	if c == nil {
+		c = &attestorConfig{}
+	}
+	p.namespace = c.SelectorNamespace
+	p.cloudName = c.CloudName
+
+	sanitize := c.SelectorSanitization.Sanitize
+	unless := func(enabled bool, why string) func(string) string {
+		return func(string) string {
+			if enabled {
+				return ""
+			}
+			return why
+		}
+	}
+	p.categories = []category{
+		{
+			value:    "hostname:",
+			variable: true,
+			disabled: unless(c.HostnameHints && c.DNSZone != "", "hostname_hints or dns_zone is not set"),
+			normalize: func(rest string) (string, bool) {
+				name := strings.ToLower(rest)
+				if !regexpHostname.MatchString(name) {
+					return "", false
+				}
+				return sanitize(name)
+			},
+		},
+		{
+			value:    "dns:",
+			variable: true,
+			disabled: func(rest string) string {
+				if c.DNSZone == "" {
+					return "dns_zone is not set"
+				}
+				zone := strings.TrimSuffix(strings.ToLower(c.DNSZone), ".")
+				if !strings.HasSuffix(strings.ToLower(rest), "."+zone) {
+					return fmt.Sprintf("name is outside of dns_zone %v", c.DNSZone)
+				}
+				return ""
+			},
+			normalize: func(rest string) (string, bool) {
+				return sanitize(strings.ToLower(rest))
+			},
+		},
+		{
+			value:    "locality:home",
+			disabled: unless(c.HomeRegion != "" || len(c.HomeAvailabilityZones) > 0, "neither home_region nor home_availability_zones is set"),
+		},
+		{
+			value: "locality:foreign",
+			disabled: func(string) string {
+				if c.HomeRegion == "" && len(c.HomeAvailabilityZones) == 0 {
+					return "neither home_region nor home_availability_zones is set"
+				}
+				if c.LocalityMode == "" || c.LocalityMode == "deny" {
+					return "locality_mode denies foreign instances"
+				}
+				return ""
+			},
+		},
+		{
+			value:    "port:unexpected_owner",
+			disabled: unless(len(c.AllowedPortDeviceOwners) > 0 && c.PortDeviceOwnerMode == "flag", "allowed_port_device_owners is not set or port_device_owner_mode is not \"flag\""),
+		},
+		{
+			value:    "port:id:",
+			variable: true,
+			disabled: unless(len(c.AllowedPortDeviceOwners) > 0, "allowed_port_device_owners is not set"),
+		},
+		{
+			value:    "instance:changed",
+			disabled: unless(c.InstanceChangeMode == "flag", "instance_change_mode is not \"flag\""),
+		},
+		{
+			value:    "enrichment:degraded",
+			disabled: unless(c.EnrichmentFailureMode == "warn", "enrichment_failure_mode is not \"warn\""),
+		},
+		{
+			value:    "logical_node:",
+			variable: true,
+			disabled: func(rest string) string {
+				if c.logicalNodePattern == nil {
+					return "logical_node_pattern is not set"
+				}
+				if !c.logicalNodePattern.MatchString(rest) {
+					return "logical node doesn't match logical_node_pattern"
+				}
+				return ""
+			},
+		},
+	}
+	for _, attr := range []string{"trusted_image_certificates", "secure_boot", "uefi"} {
+		p.categories = append(p.categories, category{
+			value:    "boot:" + attr,
+			disabled: unless(c.BootSecuritySelectors, "boot_security_selectors is not set"),
+		})
+	}
+	return p
+}
+
+func newResolver(c *resolverConfig) *plugin {
+	p := &plugin{name: "resolver", configured: c != nil}
+	if c == nil {
+		c = &resolverConfig{}
+	}
+	p.namespace = c.SelectorNamespace
+	p.cloudName = c.CloudName
+
+	sanitize := c.SelectorSanitization.Sanitize
+	p.categories = []category{
+		{
+			value:    "sg:id:",
+			variable: true,
+		},
+		{
+			value:     "sg:name:",
+			variable:  true,
+			normalize: sanitize,
+		},
+		{
+			value:    "meta:",
+			variable: true,
+			disabled: func(rest string) string {
+				if !c.CustomMetaData {
+					return "custom_meta_data is not set"
+				}
+				key := strings.SplitN(rest, ":", 2)[0]
+				if c.MetaDataKeys != nil && !contains(c.MetaDataKeys, key) {
+					return fmt.Sprintf("key %q is not in meta_data_keys", key)
+				}
+				return ""
+			},
+			normalize: func(rest string) (string, bool) {
+				kv := strings.SplitN(rest, ":", 2)
+				if len(kv) != 2 {
+					return "", false
+				}
+				k, ok := sanitize(kv[0])
+				if !ok {
+					return "", false
+				}
+				v, ok := sanitize(kv[1])
+				if !ok {
+					return "", false
+				}
+				return k + ":" + v, true
+			},
+		},
+	}
+	return p
+}
+
+// check returns how close the plugin comes to emitting the selector value
+func (p *plugin) check(value string) result {
+	r := unknown
+	if v, ok := p.stripNamespace(value); ok {
+		r = p.match(v)
+	}
+	if r.rank == rankUnknown && p.namespace != "" && p.match(value).rank > rankUnknown {
+		return result{
+			rank:   rankNamespace,
+			reason: reasonNamespace,
+			detail: fmt.Sprintf("%v selectors are prefixed by selector_namespace %q", p.name, p.namespace),
+		}
+	}
+	return r
+}
+
+// stripNamespace returns the selector value without the prefix of selector_namespace, or false if it lacks one
+func (p *plugin) stripNamespace(value string) (string, bool) {
+	switch p.namespace {
+	case common.SelectorNamespaceCloud:
+		prefix := p.cloudName + ":"
+		if !strings.HasPrefix(value, prefix) {
+			return "", false
+		}
+		return value[len(prefix):], true
+	case common.SelectorNamespaceProject:
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return "", false
+		}
+		return parts[1], true
+	}
+	return value, true
+}
+
+func (p *plugin) match(value string) result {
+	for _, c := range p.categories {
+		rest, ok := c.match(value)
+		if !ok {
+			continue
+		}
+		if !p.configured {
+			return result{rank: rankDisabled, reason: reasonDisabled, detail: fmt.Sprintf("no %v configuration given", p.name)}
+		}
+		if c.disabled != nil {
+			if why := c.disabled(rest); why != "" {
+				return result{rank: rankDisabled, reason: reasonDisabled, detail: why}
+			}
+		}
+		if c.normalize != nil {
+			n, ok := c.normalize(rest)
+			if !ok {
+				return result{rank: rankNotNormalized, reason: reasonNotNormalized, detail: "never emitted due to selector_sanitization"}
+			}
+			if n != rest {
+				return result{rank: rankNotNormalized, reason: reasonNotNormalized, detail: fmt.Sprintf("emitted as %q", c.value+n)}
+			}
+		}
+		return result{rank: rankEmitted}
+	}
+	return unknown
+}
+
+// linter reports the selectors of registration entries the plugins can't emit
+type linter struct {
+	entries entryLister
+	plugins []*plugin
+}
+
+func (l *linter) lint(ctx context.Context) ([]Finding, error) {
+	entries, err := l.entries.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, e := range entries {
+		for _, s := range e.Selectors {
+			if s.Type != common.PluginName {
+				continue
+			}
+			best := unknown
+			for _, p := range l.plugins {
+				if r := p.check(s.Value); r.rank > best.rank {
+					best = r
+				}
+			}
+			if best.rank == rankEmitted {
+				continue
+			}
+			findings = append(findings, Finding{
+				EntryID:  e.EntryId,
+				SpiffeID: e.SpiffeId,
+				Selector: fmt.Sprintf("%s:%s", s.Type, s.Value),
+				Reason:   best.reason,
+				Detail:   best.detail,
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].SpiffeID != findings[j].SpiffeID {
+			return findings[i].SpiffeID < findings[j].SpiffeID
+		}
+		return findings[i].EntryID < findings[j].EntryID
+	})
+	return findings, nil
+}
+
+func writeFindings(w io.Writer, findings []Finding, output string) error {
+	if output == outputJSON {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(findings)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REASON\tENTRY\tSPIFFE ID\tSELECTOR\tDETAIL")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", f.Reason, f.EntryID, f.SpiffeID, f.Selector, f.Detail)
+	}
+	return tw.Flush()
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
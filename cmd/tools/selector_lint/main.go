@@ -0,0 +1,155 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// selector_lint reports registration entries with selectors the openstack_iid plugins can no longer emit.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/spiffe/spire/proto/spire/api/registration"
+	spc "github.com/spiffe/spire/proto/spire/common"
+	"google.golang.org/grpc"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// exitFindings is the exit status if any selector is reported, which tells findings from failures of the lint
+const exitFindings = 2
+
+func main() {
+	attestorFile := flag.String("attestor-config", "", "path of the plugin_data of the openstack_iid node attestor")
+	resolverFile := flag.String("resolver-config", "", "path of the plugin_data of the openstack_iid node resolver")
+	socket := flag.String("registration-socket", "/tmp/spire-registration.sock", "path of the registration API socket of the SPIRE Server")
+	output := flag.String("output", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of the lint")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	findings, err := run(ctx, *attestorFile, *resolverFile, *socket, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if findings > 0 {
+		os.Exit(exitFindings)
+	}
+}
+
+func run(ctx context.Context, attestorFile, resolverFile, socket, output string) (int, error) {
+	if attestorFile == "" && resolverFile == "" {
+		return 0, errors.New("at least one of -attestor-config and -resolver-config is required")
+	}
+	if output != outputTable && output != outputJSON {
+		return 0, fmt.Errorf("invalid output format: %v", output)
+	}
+	attestor, err := loadAttestorConfig(attestorFile)
+	if err != nil {
+		return 0, err
+	}
+	resolver, err := loadResolverConfig(resolverFile)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := grpc.DialContext(ctx, socket, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to registration API: %v", err)
+	}
+	defer conn.Close()
+
+	l := &linter{
+		entries: registrationEntries{client: registration.NewRegistrationClient(conn)},
+		plugins: []*plugin{newAttestor(attestor), newResolver(resolver)},
+	}
+	findings, err := l.lint(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(findings), writeFindings(os.Stdout, findings, output)
+}
+
+// loadAttestorConfig reads the configuration of the node attestor, which is nil if path is empty
+func loadAttestorConfig(path string) (*attestorConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &attestorConfig{}
+	if err := decodeFile(c, path); err != nil {
+		return nil, err
+	}
+	if err := validateSelectorOptions(c.SelectorNamespace, c.CloudName, c.SelectorSanitization); err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", path, err)
+	}
+	if c.LogicalNodePattern != "" {
+		re, err := regexp.Compile("^(?:" + c.LogicalNodePattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid logical_node_pattern in %v: %v", path, err)
+		}
+		c.logicalNodePattern = re
+	}
+	return c, nil
+}
+
+// loadResolverConfig reads the configuration of the node resolver, which is nil if path is empty
+func loadResolverConfig(path string) (*resolverConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &resolverConfig{}
+	if err := decodeFile(c, path); err != nil {
+		return nil, err
+	}
+	if err := validateSelectorOptions(c.SelectorNamespace, c.CloudName, c.SelectorSanitization); err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", path, err)
+	}
+	return c, nil
+}
+
+func decodeFile(out interface{}, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %v", err)
+	}
+	if err := common.DecodeConfig(out, string(b)); err != nil {
+		return fmt.Errorf("failed to decode %v: %v", path, err)
+	}
+	return nil
+}
+
+func validateSelectorOptions(namespace, cloudName string, sanitization *common.SelectorSanitization) error {
+	if err := common.ValidateSelectorNamespace(namespace, cloudName); err != nil {
+		return err
+	}
+	return sanitization.Validate()
+}
+
+// registrationEntries lists registration entries with the registration API of the SPIRE Server
+type registrationEntries struct {
+	client registration.RegistrationClient
+}
+
+func (r registrationEntries) ListEntries(ctx context.Context) ([]*spc.RegistrationEntry, error) {
+	resp, err := r.client.FetchEntries(ctx, &spc.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch registration entries: %v", err)
+	}
+	return resp.Entries, nil
+}
@@ -0,0 +1,212 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	spc "github.com/spiffe/spire/proto/spire/common"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+type fakeEntries []*spc.RegistrationEntry
+
+func (f fakeEntries) ListEntries(ctx context.Context) ([]*spc.RegistrationEntry, error) {
+	return f, nil
+}
+
+func newEntry(id string, values ...string) *spc.RegistrationEntry {
+	e := &spc.RegistrationEntry{
+		EntryId:  id,
+		SpiffeId: "spiffe://example.org/" + id,
+		ParentId: "spiffe://example.org/spire/server",
+	}
+	for _, v := range values {
+		e.Selectors = append(e.Selectors, &spc.Selector{Type: common.PluginName, Value: v})
+	}
+	return e
+}
+
+func newTestAttestorConfig() *attestorConfig {
+	return &attestorConfig{
+		HostnameHints:         true,
+		DNSZone:               "example.org.",
+		HomeRegion:            "RegionOne",
+		BootSecuritySelectors: true,
+		SelectorSanitization:  &common.SelectorSanitization{Strictness: common.SanitizationPrintable, MaxLength: 255, Lowercase: true},
+	}
+}
+
+func newTestResolverConfig() *resolverConfig {
+	return &resolverConfig{
+		CustomMetaData: true,
+		MetaDataKeys:   []string{"role"},
+	}
+}
+
+func TestLint(t *testing.T) {
+	type tCase struct {
+		value    string
+		resolver *resolverConfig
+		// noResolver lints without the configuration of the resolver
+		noResolver bool
+		reason     string
+	}
+
+	namespaced := newTestResolverConfig()
+	namespaced.SelectorNamespace = common.SelectorNamespaceCloud
+	namespaced.CloudName = "east"
+
+	for i, c := range []tCase{
+		// 0: emitted selectors aren't reported
+		{value: "hostname:web-1"},
+		// 1: renamed or unknown category
+		{value: "host:web-1", reason: reasonUnknown},
+		// 2: disabled category
+		{value: "port:id:p-1", reason: reasonDisabled},
+		// 3: name outside of dns_zone
+		{value: "dns:web-1.example.com", reason: reasonDisabled},
+		// 4: foreign instances are denied by default
+		{value: "locality:foreign", reason: reasonDisabled},
+		// 5: emitted lower-cased
+		{value: "dns:Web-1.example.org", reason: reasonNotNormalized},
+		// 6: meta data key not in meta_data_keys
+		{value: "meta:owner:alice", reason: reasonDisabled},
+		// 7: meta data of the keys
+		{value: "meta:role:web"},
+		// 8: plugin without configuration
+		{value: "sg:name:web", noResolver: true, reason: reasonDisabled},
+		// 9: missing namespace
+		{value: "sg:id:g-1", resolver: namespaced, reason: reasonNamespace},
+		// 10: namespaced
+		{value: "east:sg:id:g-1", resolver: namespaced},
+		// 11: unknown boot security attribute
+		{value: "boot:measured_boot", reason: reasonUnknown},
+		// 12: the namespace of another plugin doesn't apply
+		{value: "east:hostname:web-1", resolver: namespaced, reason: reasonUnknown},
+	} {
+		resolver := newTestResolverConfig()
+		switch {
+		case c.noResolver:
+			resolver = nil
+		case c.resolver != nil:
+			resolver = c.resolver
+		}
+		l := &linter{
+			entries: fakeEntries{newEntry("e-1", c.value)},
+			plugins: []*plugin{newAttestor(newTestAttestorConfig()), newResolver(resolver)},
+		}
+		findings, err := l.lint(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case c.reason == "" && len(findings) != 0:
+			t.Errorf("%d: unexpected findings: %+v", i, findings)
+		case c.reason != "" && (len(findings) != 1 || findings[0].Reason != c.reason):
+			t.Errorf("%d: expected %v, got %+v", i, c.reason, findings)
+		}
+	}
+}
+
+func TestLintIgnoresOtherTypes(t *testing.T) {
+	e := newEntry("e-1")
+	e.Selectors = append(e.Selectors, &spc.Selector{Type: "unix", Value: "uid:0"})
+	l := &linter{
+		entries: fakeEntries{e},
+		plugins: []*plugin{newAttestor(nil), newResolver(nil)},
+	}
+	findings, err := l.lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("unexpected findings: %+v", findings)
+	}
+}
+
+func TestWriteFindings(t *testing.T) {
+	l := &linter{
+		entries: fakeEntries{
+			newEntry("e-2", "hostname:web-2", "enrichment:degraded"),
+			newEntry("e-1", "instance:changed"),
+		},
+		plugins: []*plugin{newAttestor(newTestAttestorConfig()), newResolver(nil)},
+	}
+	findings, err := l.lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := writeFindings(&b, findings, outputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Finding
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0].EntryID != "e-1" || decoded[1].Selector != "openstack_iid:enrichment:degraded" {
+		t.Errorf("unexpected findings: %+v", decoded)
+	}
+
+	b.Reset()
+	if err := writeFindings(&b, findings, outputTable); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "REASON") {
+		t.Errorf("unexpected table:\n%v", b.String())
+	}
+}
+
+func TestLoadAttestorConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selector-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "attestor.conf")
+	conf := `
+cloud_name = "east"
+hostname_hints = true
+logical_node_pattern = "kubelet-[0-9]+"
+selector_namespace = "cloud"
+projectid_whitelist = ["alpha"]
+`
+	if err := ioutil.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadAttestorConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newAttestor(c)
+	if r := p.check("east:logical_node:kubelet-1"); r.rank != rankEmitted {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	if r := p.check("east:logical_node:etcd-1"); r.reason != reasonDisabled {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`selector_namespace = "cloud"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAttestorConfig(path); err == nil {
+		t.Error("expected an error for selector_namespace without cloud_name")
+	}
+}
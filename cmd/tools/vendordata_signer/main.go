@@ -0,0 +1,264 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// vendordata_signer is a Nova dynamic vendordata service which signs the identities of instances, and optionally
+// provisions instances with the HMAC secret of their project and the SPIRE trust bundle.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/workload"
+)
+
+const (
+	defaultListenAddress = ":8444"
+	// bundleRetryInterval is the interval of the retries of the trust bundle watch
+	bundleRetryInterval = 5 * time.Second
+)
+
+// SignerConfig is the configuration of the signing service
+type SignerConfig struct {
+	// Address the service listens on
+	ListenAddress string `hcl:"listen_address"`
+	// Certificate and key to serve with TLS. Plain HTTP is served if empty.
+	CertFile string `hcl:"cert_file"`
+	KeyFile  string `hcl:"key_file"`
+
+	// Cloud entry in clouds.yaml of the credentials validating the tokens of Nova
+	CloudName string                `hcl:"cloud_name"`
+	Auth      *openstack.AuthConfig `hcl:"auth"`
+	// IDs of the users Nova authenticates to the service as, i.e. [vendordata_dynamic_auth] of nova.conf
+	AllowedUserIDs []string `hcl:"allowed_user_ids"`
+
+	// ID of the key identities are signed with
+	ActiveKeyID string `hcl:"active_key_id"`
+	// Signing keys. Keys other than the active one are kept to publish their public keys during rotation.
+	Keys []*KeyConfig `hcl:"key"`
+
+	// Master secret the HMAC secrets of projects are derived from, served at /hmac. Optional.
+	HMACKey *HMACKeyConfig `hcl:"hmac_key"`
+
+	// Path of the Workload API socket of the local SPIRE Agent, whose trust bundle is served at /bundle. Optional.
+	WorkloadAPISocket string `hcl:"workload_api_socket"`
+}
+
+// KeyConfig is a signing key
+type KeyConfig struct {
+	KeyID          string `hcl:",key"`
+	PrivateKeyFile string `hcl:"private_key_file"`
+	// Certificate of the key followed by the intermediate certificates, sent along with identities so that servers
+	// can trust the CA instead of the key. Optional.
+	CertificateFile string `hcl:"certificate_file"`
+}
+
+// HMACKeyConfig is the master secret of the HMAC secrets of projects
+type HMACKeyConfig struct {
+	// Key ID the derived secrets are provisioned with, which the servers trust the master secret as
+	KeyID string `hcl:"key_id"`
+	// Base64 encoded secret of at least 16 bytes
+	Secret     string `hcl:"secret"`
+	SecretFile string `hcl:"secret_file"`
+}
+
+func loadConfig(path string) (*SignerConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &SignerConfig{}
+	if err := common.DecodeConfig(c, string(b)); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SignerConfig) validate() error {
+	if c.ListenAddress == "" {
+		c.ListenAddress = defaultListenAddress
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if len(c.AllowedUserIDs) == 0 {
+		return errors.New("allowed_user_ids is required")
+	}
+	if c.ActiveKeyID == "" {
+		return errors.New("active_key_id is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range c.Keys {
+		if seen[k.KeyID] {
+			return fmt.Errorf("duplicate key: %v", k.KeyID)
+		}
+		seen[k.KeyID] = true
+		if k.PrivateKeyFile == "" {
+			return fmt.Errorf("private_key_file of key %v is required", k.KeyID)
+		}
+	}
+	if !seen[c.ActiveKeyID] {
+		return fmt.Errorf("active key %v is not configured", c.ActiveKeyID)
+	}
+	if c.HMACKey != nil && c.HMACKey.KeyID == "" {
+		return errors.New("key_id of hmac_key is required")
+	}
+	return nil
+}
+
+// loadKeyring loads the signing keys of the configuration
+func loadKeyring(c *SignerConfig) (*keyring, error) {
+	k := &keyring{
+		signers: make(map[string]*vendordata.Signer),
+	}
+	for _, kc := range c.Keys {
+		key, err := vendordata.LoadPrivateKey(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %v: %v", kc.KeyID, err)
+		}
+		var s *vendordata.Signer
+		if kc.CertificateFile != "" {
+			var chain []*x509.Certificate
+			chain, err = vendordata.LoadCertificates(kc.CertificateFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load certificate of key %v: %v", kc.KeyID, err)
+			}
+			s, err = vendordata.NewSignerWithChain(kc.KeyID, key, chain)
+		} else {
+			s, err = vendordata.NewSigner(kc.KeyID, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %v: %v", kc.KeyID, err)
+		}
+		k.signers[kc.KeyID] = s
+	}
+	k.active = k.signers[c.ActiveKeyID]
+
+	if hc := c.HMACKey; hc != nil {
+		s, err := common.ResolveSecret("secret", hc.Secret, hc.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hmac_key: %v", err)
+		}
+		k.hmacMaster, err = common.DecodeHMACSecret(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hmac_key: %v", err)
+		}
+		k.hmacKeyID = hc.KeyID
+	}
+	return k, nil
+}
+
+func main() {
+	configPath := flag.String("config", "vendordata_signer.conf", "path to the configuration file")
+	flag.Parse()
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name: "vendordata_signer",
+	})
+	if err := run(*configPath, logger); err != nil {
+		logger.Error("Signing service failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, logger hclog.Logger) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	keys, err := loadKeyring(config)
+	if err != nil {
+		return err
+	}
+	validator, err := openstack.NewTokenValidator(context.Background(), config.CloudName, config.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to prepare token validator: %v", err)
+	}
+
+	svc := newService(config.AllowedUserIDs, validator, keys, logger)
+	if config.WorkloadAPISocket != "" {
+		client, err := workload.Dial(config.WorkloadAPISocket)
+		if err != nil {
+			return fmt.Errorf("failed to connect to Workload API: %v", err)
+		}
+		defer client.Close()
+		go watchBundle(client, logger)
+		svc.bundles = client
+	}
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: svc,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("Listening", "address", config.ListenAddress, "active_key_id", keys.active.KeyID())
+		if config.CertFile != "" {
+			errCh <- server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+			return
+		}
+		errCh <- server.ListenAndServe()
+	}()
+
+	// Keys are reloaded on SIGHUP, so that rotation steps take effect without dropping requests from Nova
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-hup:
+			if err := reload(configPath, svc); err != nil {
+				logger.Error("Failed to reload keys, keeping the current ones", "error", err)
+				continue
+			}
+			logger.Info("Reloaded keys", "active_key_id", svc.activeKeyID())
+		}
+	}
+}
+
+// watchBundle keeps the trust bundle of the client current, retrying failed watches forever
+func watchBundle(client *workload.Client, logger hclog.Logger) {
+	for {
+		err := client.WatchX509(context.Background())
+		logger.Warn("Trust bundle watch failed, retrying", "error", err)
+		time.Sleep(bundleRetryInterval)
+	}
+}
+
+// reload loads the keys of the configuration file into the service.
+// Other options need a restart to take effect.
+func reload(configPath string, svc *service) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	keys, err := loadKeyring(config)
+	if err != nil {
+		return err
+	}
+	svc.setKeys(keys)
+	return nil
+}
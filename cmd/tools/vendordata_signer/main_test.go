@@ -0,0 +1,413 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+type fakeValidator struct{}
+
+func (fakeValidator) ValidateToken(ctx context.Context, token string) (string, error) {
+	switch token {
+	case "nova":
+		return "nova-user", nil
+	case "other":
+		return "other-user", nil
+	}
+	return "", errors.New("invalid token")
+}
+
+// writeTestConfig writes keys and a configuration signing with the active key, and returns the path of the configuration
+func writeTestConfig(t *testing.T, dir, active string, kids ...string) string {
+	var keys []string
+	for _, kid := range kids {
+		path := filepath.Join(dir, kid+".pem")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys = append(keys, fmt.Sprintf("key %q {\n\tprivate_key_file = %q\n}\n", kid, path))
+	}
+
+	path := filepath.Join(dir, "vendordata_signer.conf")
+	config := fmt.Sprintf("allowed_user_ids = [\"nova-user\"]\nactive_key_id = %q\n%v", active, strings.Join(keys, ""))
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestService(t *testing.T, configPath string) *service {
+	c, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadKeyring(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newService(c.AllowedUserIDs, fakeValidator{}, keys, hclog.NewNullLogger())
+}
+
+func signRequest(t *testing.T, h http.Handler, token, body string) *httptest.ResponseRecorder {
+	return postRequest(t, h, "/", token, body)
+}
+
+func postRequest(t *testing.T, h http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("X-Auth-Token", token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func fetchKeys(t *testing.T, h http.Handler) (*vendordata.Verifier, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v", rec.Code)
+	}
+	var resp keysResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for kid, p := range resp.Keys {
+		block, _ := pem.Decode([]byte(p))
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = key
+	}
+	v, err := vendordata.NewVerifier(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, resp.ActiveKeyID
+}
+
+func TestValidate(t *testing.T) {
+	for i, c := range []struct {
+		config  string
+		wantErr bool
+	}{
+		// 0: valid
+		{config: `
+			allowed_user_ids = ["nova"]
+			active_key_id = "a"
+			key "a" { private_key_file = "a.pem" }`},
+		// 1: active key not configured
+		{config: `
+			allowed_user_ids = ["nova"]
+			active_key_id = "b"
+			key "a" { private_key_file = "a.pem" }`, wantErr: true},
+		// 2: no allowed users
+		{config: `
+			active_key_id = "a"
+			key "a" { private_key_file = "a.pem" }`, wantErr: true},
+		// 3: duplicate keys
+		{config: `
+			allowed_user_ids = ["nova"]
+			active_key_id = "a"
+			key "a" { private_key_file = "a.pem" }
+			key "a" { private_key_file = "b.pem" }`, wantErr: true},
+		// 4: hmac_key
+		{config: `
+			allowed_user_ids = ["nova"]
+			active_key_id = "a"
+			key "a" { private_key_file = "a.pem" }
+			hmac_key { key_id = "h1", secret_file = "h1" }`},
+		// 5: hmac_key without key_id
+		{config: `
+			allowed_user_ids = ["nova"]
+			active_key_id = "a"
+			key "a" { private_key_file = "a.pem" }
+			hmac_key { secret_file = "h1" }`, wantErr: true},
+	} {
+		sc := &SignerConfig{}
+		if err := common.DecodeConfig(sc, c.config); err != nil {
+			t.Fatal(err)
+		}
+		err := sc.validate()
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata_signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	svc := newTestService(t, writeTestConfig(t, dir, "k1", "k1"))
+
+	for i, c := range []struct {
+		token string
+		body  string
+		code  int
+	}{
+		{token: "nova", body: `{"instance-id": "alpha", "project-id": "bravo", "hostname": "charlie"}`, code: http.StatusOK},
+		{token: "invalid", body: `{"instance-id": "alpha", "project-id": "bravo"}`, code: http.StatusUnauthorized},
+		{token: "other", body: `{"instance-id": "alpha", "project-id": "bravo"}`, code: http.StatusForbidden},
+		{token: "nova", body: `{"project-id": "bravo"}`, code: http.StatusBadRequest},
+	} {
+		rec := signRequest(t, svc, c.token, c.body)
+		if rec.Code != c.code {
+			t.Errorf("#%v: got status %v, want %v", i, rec.Code, c.code)
+		}
+	}
+
+	v, _ := fetchKeys(t, svc)
+	rec := signRequest(t, svc, "nova", `{"instance-id": "alpha", "project-id": "bravo"}`)
+	var signed vendordata.SignedIdentity
+	if err := json.NewDecoder(rec.Body).Decode(&signed); err != nil {
+		t.Fatal(err)
+	}
+	id, err := v.Verify(&signed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id.InstanceID != "alpha" || id.ProjectID != "bravo" {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata_signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, "k1", "k1")
+	svc := newTestService(t, path)
+	body := `{"instance-id": "alpha", "project-id": "bravo"}`
+
+	// 1: the new key is added, still signing with the old key
+	writeTestConfig(t, dir, "k1", "k1", "k2")
+	if err := reload(path, svc); err != nil {
+		t.Fatal(err)
+	}
+	trusted, active := fetchKeys(t, svc)
+	if active != "k1" || len(trusted.KeyIDs()) != 2 {
+		t.Fatalf("unexpected keys: %v, active %v", trusted.KeyIDs(), active)
+	}
+	oldSigned := &vendordata.SignedIdentity{}
+	if err := json.NewDecoder(signRequest(t, svc, "nova", body).Body).Decode(oldSigned); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2: the new key is activated once servers trust it
+	writeTestConfig(t, dir, "k2", "k1", "k2")
+	if err := reload(path, svc); err != nil {
+		t.Fatal(err)
+	}
+	newSigned := &vendordata.SignedIdentity{}
+	if err := json.NewDecoder(signRequest(t, svc, "nova", body).Body).Decode(newSigned); err != nil {
+		t.Fatal(err)
+	}
+	if oldSigned.KeyID != "k1" || newSigned.KeyID != "k2" {
+		t.Errorf("unexpected key IDs: %v, %v", oldSigned.KeyID, newSigned.KeyID)
+	}
+	for i, s := range []*vendordata.SignedIdentity{oldSigned, newSigned} {
+		if _, err := trusted.Verify(s); err != nil {
+			t.Errorf("#%v: unexpected error during rotation: %v", i, err)
+		}
+	}
+
+	// 3: the old key is removed
+	writeTestConfig(t, dir, "k2", "k2")
+	if err := reload(path, svc); err != nil {
+		t.Fatal(err)
+	}
+	trusted, _ = fetchKeys(t, svc)
+	if _, err := trusted.Verify(oldSigned); err != vendordata.ErrUnknownKey {
+		t.Errorf("unexpected error for retired key: %v", err)
+	}
+
+	// A broken configuration keeps the current keys
+	if err := ioutil.WriteFile(path, []byte(`active_key_id = "k3"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(path, svc); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	if svc.activeKeyID() != "k2" {
+		t.Errorf("unexpected active key: %v", svc.activeKeyID())
+	}
+}
+
+func TestHMACSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata_signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, "k1", "k1")
+	svc := newTestService(t, path)
+	body := `{"instance-id": "alpha", "project-id": "bravo"}`
+
+	// not served without hmac_key
+	if rec := postRequest(t, svc, "/hmac", "nova", body); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+
+	master := []byte("0123456789abcdef")
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = append(config, fmt.Sprintf("hmac_key {\n\tkey_id = \"h1\"\n\tsecret = %q\n}\n", base64.StdEncoding.EncodeToString(master))...)
+	if err := ioutil.WriteFile(path, config, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(path, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		token string
+		body  string
+		code  int
+	}{
+		{token: "nova", body: body, code: http.StatusOK},
+		{token: "other", body: body, code: http.StatusForbidden},
+		{token: "nova", body: `{"instance-id": "alpha"}`, code: http.StatusBadRequest},
+	} {
+		rec := postRequest(t, svc, "/hmac", c.token, c.body)
+		if rec.Code != c.code {
+			t.Errorf("#%v: got status %v, want %v", i, rec.Code, c.code)
+		}
+	}
+
+	var resp hmacResponse
+	if err := json.NewDecoder(postRequest(t, svc, "/hmac", "nova", body).Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	keyID, secret, err := common.ParseHMACSecret(resp.HMACSecret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyID != "h1" || string(secret) != string(common.DeriveProjectHMACSecret(master, "bravo")) {
+		t.Errorf("got %v:%x, want the secret of project bravo with key h1", keyID, secret)
+	}
+}
+
+type fakeBundles []*x509.Certificate
+
+func (b fakeBundles) Bundle() []*x509.Certificate {
+	return b
+}
+
+func TestTrustBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata_signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	svc := newTestService(t, writeTestConfig(t, dir, "k1", "k1"))
+	body := `{"instance-id": "alpha", "project-id": "bravo"}`
+
+	// not served without workload_api_socket
+	if rec := postRequest(t, svc, "/bundle", "nova", body); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		bundles fakeBundles
+		token   string
+		code    int
+	}{
+		// 0: the bundle is served to Nova
+		{bundles: fakeBundles{root}, token: "nova", code: http.StatusOK},
+		// 1: other users are rejected
+		{bundles: fakeBundles{root}, token: "other", code: http.StatusForbidden},
+		// 2: the agent hasn't received the bundle yet
+		{token: "nova", code: http.StatusServiceUnavailable},
+	} {
+		svc.bundles = c.bundles
+		rec := postRequest(t, svc, "/bundle", c.token, body)
+		if rec.Code != c.code {
+			t.Errorf("#%v: got status %v, want %v", i, rec.Code, c.code)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var resp vendordata.TrustBundle
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		certs, err := resp.Certificates()
+		if err != nil || len(certs) != 1 || !certs[0].Equal(root) {
+			t.Errorf("#%v: unexpected bundle: %v, %v", i, certs, err)
+		}
+	}
+}
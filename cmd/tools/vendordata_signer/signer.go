@@ -0,0 +1,241 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// novaRequest is the subset of the request Nova sends to dynamic vendordata services
+type novaRequest struct {
+	ProjectID  string `json:"project-id"`
+	InstanceID string `json:"instance-id"`
+	ImageID    string `json:"image-id"`
+	Hostname   string `json:"hostname"`
+}
+
+// keysResponse lists the public keys of the signing keys, for servers to be configured to trust
+type keysResponse struct {
+	ActiveKeyID string `json:"active_key_id"`
+	// PEM encoded public keys by key ID
+	Keys map[string]string `json:"keys"`
+}
+
+// hmacResponse provisions the instance with the HMAC secret of its project
+type hmacResponse struct {
+	// Secret in the form "<key ID>:<base64 encoded secret>"
+	HMACSecret string `json:"hmac_secret"`
+}
+
+// bundleSource provides the current SPIRE trust bundle, e.g. workload.Client
+type bundleSource interface {
+	// Bundle returns the CA certificates of the trust bundle, or nil if it is not available yet
+	Bundle() []*x509.Certificate
+}
+
+// keyring is the set of signing keys and the active one, and the master HMAC secret if configured
+type keyring struct {
+	active  *vendordata.Signer
+	signers map[string]*vendordata.Signer
+
+	hmacKeyID  string
+	hmacMaster []byte
+}
+
+// service signs the identities of instances on requests from Nova
+type service struct {
+	logger       hclog.Logger
+	allowedUsers []string
+	validator    openstack.TokenValidator
+
+	mtx  sync.RWMutex
+	keys *keyring
+
+	// bundles serves the trust bundle at /bundle if not nil
+	bundles bundleSource
+}
+
+func newService(allowedUsers []string, validator openstack.TokenValidator, keys *keyring, logger hclog.Logger) *service {
+	return &service{
+		logger:       logger,
+		allowedUsers: allowedUsers,
+		validator:    validator,
+		keys:         keys,
+	}
+}
+
+func (s *service) setKeys(keys *keyring) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.keys = keys
+}
+
+func (s *service) activeKeyID() string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.keys.active.KeyID()
+}
+
+func (s *service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		s.sign(w, r)
+	case r.URL.Path == "/hmac" && r.Method == http.MethodPost:
+		s.hmacSecret(w, r)
+	case r.URL.Path == "/bundle" && r.Method == http.MethodPost:
+		s.trustBundle(w, r)
+	case r.URL.Path == "/keys" && r.Method == http.MethodGet:
+		s.publicKeys(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// decodeRequest authenticates the request as from Nova and decodes it. It writes the error and returns nil if the
+// request is rejected.
+func (s *service) decodeRequest(w http.ResponseWriter, r *http.Request) *novaRequest {
+	userID, err := s.validator.ValidateToken(r.Context(), r.Header.Get("X-Auth-Token"))
+	if err != nil {
+		s.logger.Warn("Rejecting request with invalid token", "error", err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil
+	}
+	if !contains(s.allowedUsers, userID) {
+		s.logger.Warn("Rejecting request from unexpected user", "user_id", userID)
+		http.Error(w, "user not allowed", http.StatusForbidden)
+		return nil
+	}
+
+	req := &novaRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return nil
+	}
+	if req.InstanceID == "" || req.ProjectID == "" {
+		http.Error(w, "instance-id and project-id are required", http.StatusBadRequest)
+		return nil
+	}
+	return req
+}
+
+func (s *service) sign(w http.ResponseWriter, r *http.Request) {
+	req := s.decodeRequest(w, r)
+	if req == nil {
+		return
+	}
+
+	s.mtx.RLock()
+	signer := s.keys.active
+	s.mtx.RUnlock()
+
+	signed, err := signer.Sign(&vendordata.Identity{
+		InstanceID: req.InstanceID,
+		ProjectID:  req.ProjectID,
+		ImageID:    req.ImageID,
+		Hostname:   req.Hostname,
+	})
+	if err != nil {
+		s.logger.Error("Failed to sign identity", "instance_id", req.InstanceID, "error", err)
+		http.Error(w, "failed to sign identity", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Debug("Signed identity", "instance_id", req.InstanceID, "key_id", signed.KeyID)
+	writeJSON(w, signed)
+}
+
+// hmacSecret provisions the instance with the HMAC secret of its project, derived from the master secret so that
+// the servers trusting the master secret can verify the MACs of any project
+func (s *service) hmacSecret(w http.ResponseWriter, r *http.Request) {
+	s.mtx.RLock()
+	keys := s.keys
+	s.mtx.RUnlock()
+	if keys.hmacMaster == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	req := s.decodeRequest(w, r)
+	if req == nil {
+		return
+	}
+	secret := common.DeriveProjectHMACSecret(keys.hmacMaster, req.ProjectID)
+	s.logger.Debug("Provisioned hmac secret", "instance_id", req.InstanceID, "project_id", req.ProjectID, "key_id", keys.hmacKeyID)
+	writeJSON(w, &hmacResponse{HMACSecret: common.FormatHMACSecret(keys.hmacKeyID, secret)})
+}
+
+// trustBundle provisions the instance with the current trust bundle, which its agent bootstraps with
+func (s *service) trustBundle(w http.ResponseWriter, r *http.Request) {
+	if s.bundles == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	req := s.decodeRequest(w, r)
+	if req == nil {
+		return
+	}
+	certs := s.bundles.Bundle()
+	if len(certs) == 0 {
+		s.logger.Warn("Trust bundle requested before it is available", "instance_id", req.InstanceID)
+		http.Error(w, "trust bundle is not available yet", http.StatusServiceUnavailable)
+		return
+	}
+	s.logger.Debug("Provisioned trust bundle", "instance_id", req.InstanceID, "certificates", len(certs))
+	writeJSON(w, vendordata.NewTrustBundle(certs))
+}
+
+func (s *service) publicKeys(w http.ResponseWriter) {
+	s.mtx.RLock()
+	keys := s.keys
+	s.mtx.RUnlock()
+
+	resp := &keysResponse{
+		ActiveKeyID: keys.active.KeyID(),
+		Keys:        make(map[string]string),
+	}
+	var ids []string
+	for kid := range keys.signers {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	for _, kid := range ids {
+		der, err := x509.MarshalPKIXPublicKey(keys.signers[kid].Public())
+		if err != nil {
+			s.logger.Error("Failed to encode public key", "key_id", kid, "error", err)
+			http.Error(w, "failed to encode public keys", http.StatusInternalServerError)
+			return
+		}
+		resp.Keys[kid] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
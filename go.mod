@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/DataDog/datadog-go v3.4.0+incompatible // indirect
 	github.com/armon/go-metrics v0.3.2 // indirect
+	github.com/golang/protobuf v1.3.4
 	github.com/gophercloud/gophercloud v0.8.0
 	github.com/gophercloud/utils v0.0.0-20200302155035-0565566533e4
 	github.com/grpc-ecosystem/go-grpc-middleware v1.2.0 // indirect
@@ -18,16 +19,16 @@ require (
 	github.com/mitchellh/go-testing-interface v1.0.0 // indirect
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/oklog/run v1.1.0 // indirect
-	github.com/prometheus/client_golang v1.4.1 // indirect
+	github.com/prometheus/client_golang v1.4.1
 	github.com/prometheus/procfs v0.0.10 // indirect
 	github.com/spiffe/spire v0.9.2
 	github.com/spiffe/spire/proto/spire v0.9.2
 	github.com/uber-go/tally v3.3.15+incompatible // indirect
 	github.com/zeebo/errs v1.2.2 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
-	golang.org/x/net v0.0.0-20200301022130-244492dfa37a // indirect
-	golang.org/x/sys v0.0.0-20200302150141-5c8b2ff67527 // indirect
-	google.golang.org/genproto v0.0.0-20200302123026-7795fca6ccb1 // indirect
+	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
+	golang.org/x/sys v0.0.0-20200302150141-5c8b2ff67527
+	google.golang.org/genproto v0.0.0-20200302123026-7795fca6ccb1
 	google.golang.org/grpc v1.27.1
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,113 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package audit emits audit events in the DMTF Cloud Auditing Data Federation (CADF) format, which the audit
+// middleware and the notifications of OpenStack services use, so that attestations show up in the same audit trail.
+package audit
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+const (
+	eventTypeURI = "http://schemas.dmtf.org/cloud/audit/1.0/event"
+	eventType    = "activity"
+
+	// ActionAuthenticate is the action of attestations
+	ActionAuthenticate = "authenticate"
+	// ActionDelete is the action of evictions of agents
+	ActionDelete = "delete"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	// TypeURIServer is the type of the instances initiating attestations
+	TypeURIServer = "service/compute/servers"
+	// TypeURIAgent is the type of the SPIRE agents targeted by attestations
+	TypeURIAgent = "service/security/account/user"
+	// TypeURIObserver is the type of the server plugin observing attestations
+	TypeURIObserver = "service/security"
+
+	// ReasonType is the type of the reason codes of denials
+	ReasonType = "spire-openstack-plugin"
+
+	// timeFormat is the format of timestamps of pycadf
+	timeFormat = "2006-01-02T15:04:05.000000-0700"
+)
+
+// Resource is a CADF resource, the initiator, target or observer of an event
+type Resource struct {
+	TypeURI   string `json:"typeURI"`
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
+}
+
+// Reason is the reason of the outcome of an event
+type Reason struct {
+	ReasonType string `json:"reasonType"`
+	ReasonCode string `json:"reasonCode"`
+}
+
+// Attachment is additional data of an event
+type Attachment struct {
+	TypeURI string      `json:"typeURI"`
+	Name    string      `json:"name"`
+	Content interface{} `json:"content"`
+}
+
+// Event is a CADF event of the activity type
+type Event struct {
+	TypeURI     string        `json:"typeURI"`
+	ID          string        `json:"id"`
+	EventType   string        `json:"eventType"`
+	EventTime   string        `json:"eventTime"`
+	Action      string        `json:"action"`
+	Outcome     string        `json:"outcome"`
+	Initiator   *Resource     `json:"initiator"`
+	Target      *Resource     `json:"target"`
+	Observer    *Resource     `json:"observer"`
+	Reason      *Reason       `json:"reason,omitempty"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+}
+
+// NewEvent returns an event of the action with the outcome, which happened now
+func NewEvent(action, outcome string, initiator, target, observer *Resource) *Event {
+	return &Event{
+		TypeURI:   eventTypeURI,
+		ID:        newID(),
+		EventType: eventType,
+		EventTime: time.Now().UTC().Format(timeFormat),
+		Action:    action,
+		Outcome:   outcome,
+		Initiator: initiator,
+		Target:    target,
+		Observer:  observer,
+	}
+}
+
+// Attach attaches JSON content to the event
+func (e *Event) Attach(name string, content interface{}) {
+	e.Attachments = append(e.Attachments, &Attachment{
+		TypeURI: "mime:application/json",
+		Name:    name,
+		Content: content,
+	})
+}
+
+// newID returns a random UUID, the format of the IDs of CADF events
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
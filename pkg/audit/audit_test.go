@@ -0,0 +1,160 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func newTestEvent() *Event {
+	e := NewEvent(ActionAuthenticate, OutcomeFailure,
+		&Resource{TypeURI: TypeURIServer, ID: "alpha", ProjectID: "bravo"},
+		&Resource{TypeURI: TypeURIAgent, ID: "spiffe://example.com/spire/agent/openstack_iid/bravo/alpha"},
+		&Resource{TypeURI: TypeURIObserver, ID: "spire-server/openstack_iid"})
+	e.Reason = &Reason{ReasonType: ReasonType, ReasonCode: "POLICY_PROJECT_NOT_ALLOWED"}
+	return e
+}
+
+func TestNewEvent(t *testing.T) {
+	e := newTestEvent()
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(e.ID) {
+		t.Errorf("id is not a UUID: %v", e.ID)
+	}
+	if _, err := time.Parse(timeFormat, e.EventTime); err != nil {
+		t.Errorf("unexpected event time: %v", err)
+	}
+
+	e.Attach("selectors", []string{"charlie"})
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`"typeURI":"http://schemas.dmtf.org/cloud/audit/1.0/event"`,
+		`"eventType":"activity"`,
+		`"reason":{"reasonType":"spire-openstack-plugin","reasonCode":"POLICY_PROJECT_NOT_ALLOWED"}`,
+		`"attachments":[{"typeURI":"mime:application/json","name":"selectors","content":["charlie"]}]`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("%s lacks %v", b, want)
+		}
+	}
+}
+
+func TestNotifierHTTP(t *testing.T) {
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		received <- e
+	}))
+	defer srv.Close()
+
+	n, err := NewNotifier(&Config{URL: srv.URL}, testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := newTestEvent()
+	n.Notify("spire.attestation.denied", want)
+	n.Close()
+
+	select {
+	case e := <-received:
+		if e.ID != want.ID || e.Initiator.ID != "alpha" || e.Reason.ReasonCode != want.Reason.ReasonCode {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("event not received")
+	}
+}
+
+func TestRabbitMQSink(t *testing.T) {
+	tCase := []struct {
+		routed  bool
+		status  int
+		wantErr bool
+	}{
+		// 0: published
+		{routed: true, status: http.StatusOK},
+		// 1: no queue is bound to the routing key
+		{status: http.StatusOK, wantErr: true},
+		// 2: exchange not found
+		{status: http.StatusNotFound, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "guest" || pass != "secret" {
+				t.Errorf("#%v: unexpected credentials: %v %v", i, user, pass)
+			}
+			var req struct {
+				RoutingKey string `json:"routing_key"`
+				Payload    string `json:"payload"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("#%v: failed to decode request: %v", i, err)
+			}
+			if req.RoutingKey != "notifications.info" {
+				t.Errorf("#%v: unexpected routing key: %v", i, req.RoutingKey)
+			}
+			var envelope map[string]string
+			var msg struct {
+				EventType   string `json:"event_type"`
+				PublisherID string `json:"publisher_id"`
+				Payload     Event  `json:"payload"`
+			}
+			if err := json.Unmarshal([]byte(req.Payload), &envelope); err != nil {
+				t.Errorf("#%v: malformed envelope: %v", i, err)
+			} else if err := json.Unmarshal([]byte(envelope["oslo.message"]), &msg); err != nil {
+				t.Errorf("#%v: malformed message: %v", i, err)
+			}
+			if envelope["oslo.version"] != "2.0" || msg.EventType != "spire.attestation.denied" || msg.PublisherID != "spire-server/openstack_iid" || msg.Payload.Initiator == nil {
+				t.Errorf("#%v: unexpected notification: %v", i, req.Payload)
+			}
+
+			w.WriteHeader(c.status)
+			json.NewEncoder(w).Encode(map[string]bool{"routed": c.routed})
+		}))
+
+		publishURL := strings.Replace(srv.URL, "http://", "http://guest:secret@", 1) + "/api/exchanges/%2F/openstack/publish"
+		err := NewRabbitMQSink(publishURL, defaultTopic, "", time.Second).Send("spire.attestation.denied", newTestEvent())
+		srv.Close()
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			} else if strings.Contains(err.Error(), "secret") {
+				t.Errorf("#%v: error reveals the password: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestNewNotifierInvalidConfig(t *testing.T) {
+	for i, c := range []*Config{
+		{},
+		{Transport: "amqp", URL: "amqp://localhost"},
+	} {
+		if _, err := NewNotifier(c, testutil.TestLogger()); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
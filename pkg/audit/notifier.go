@@ -0,0 +1,251 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	TransportHTTP     = "http"
+	TransportRabbitMQ = "rabbitmq"
+
+	defaultTopic   = "notifications"
+	defaultTimeout = 5 * time.Second
+	queueSize      = 1024
+
+	// notificationTimeFormat is the format of timestamps of oslo.messaging notifications
+	notificationTimeFormat = "2006-01-02 15:04:05.000000"
+)
+
+// Config represents the configuration of the notifier
+type Config struct {
+	// "http" to post events to url, or "rabbitmq" to publish them as oslo.messaging notifications through the
+	// management API of RabbitMQ, where url is the publish endpoint of the exchange. Defaults to "http".
+	Transport string
+	URL       string
+	// Topic of the notifications, which are routed with "<topic>.info". Defaults to "notifications".
+	Topic string
+	// Publisher of the notifications. Defaults to the ID of the observer of events.
+	PublisherID string
+	Timeout     time.Duration
+}
+
+// Sink delivers events of the event type
+type Sink interface {
+	Send(eventType string, e *Event) error
+}
+
+type notification struct {
+	eventType string
+	event     *Event
+}
+
+// Notifier delivers audit events to a sink asynchronously, so that a slow sink doesn't block attestations.
+// Events are dropped if the queue is full.
+type Notifier struct {
+	logger hclog.Logger
+	sink   Sink
+	queue  chan *notification
+	done   chan struct{}
+}
+
+// NewNotifier returns a Notifier delivering events with the configured transport
+func NewNotifier(c *Config, logger hclog.Logger) (*Notifier, error) {
+	if c.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	var sink Sink
+	switch c.Transport {
+	case "", TransportHTTP:
+		sink = NewHTTPSink(c.URL, timeout)
+	case TransportRabbitMQ:
+		topic := c.Topic
+		if topic == "" {
+			topic = defaultTopic
+		}
+		sink = NewRabbitMQSink(c.URL, topic, c.PublisherID, timeout)
+	default:
+		return nil, fmt.Errorf("unknown transport: %q", c.Transport)
+	}
+	return newNotifier(sink, logger), nil
+}
+
+func newNotifier(sink Sink, logger hclog.Logger) *Notifier {
+	n := &Notifier{
+		logger: logger,
+		sink:   sink,
+		queue:  make(chan *notification, queueSize),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues an event of the event type, which names the notification, e.g. "spire.attestation.admitted"
+func (n *Notifier) Notify(eventType string, e *Event) {
+	select {
+	case n.queue <- &notification{eventType: eventType, event: e}:
+	default:
+		n.logger.Warn("Audit event queue is full, dropping event", "event_type", eventType, "id", e.ID)
+	}
+}
+
+// Close stops the notifier after delivering queued events
+func (n *Notifier) Close() {
+	close(n.queue)
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for m := range n.queue {
+		if err := n.sink.Send(m.eventType, m.event); err != nil {
+			n.logger.Warn("Failed to send audit event", "event_type", m.eventType, "id", m.event.ID, "error", err)
+		}
+	}
+}
+
+// HTTPSink posts events to an HTTP endpoint, e.g. an audit collector
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a Sink posting events to url
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSink) Send(_ string, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return checkResponse(redact(s.url), resp, nil)
+}
+
+// RabbitMQSink publishes events as oslo.messaging notifications through the management API of RabbitMQ, so that
+// they reach the consumers of the notifications of OpenStack services without an AMQP client
+type RabbitMQSink struct {
+	url         string
+	routingKey  string
+	publisherID string
+	client      *http.Client
+}
+
+// NewRabbitMQSink returns a Sink publishing events to the exchange of publishURL, e.g.
+// "http://rabbitmq:15672/api/exchanges/%2F/openstack/publish", with the routing key of the info notifications of topic
+func NewRabbitMQSink(publishURL, topic, publisherID string, timeout time.Duration) *RabbitMQSink {
+	return &RabbitMQSink{
+		url:         publishURL,
+		routingKey:  topic + ".info",
+		publisherID: publisherID,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *RabbitMQSink) Send(eventType string, e *Event) error {
+	publisherID := s.publisherID
+	if publisherID == "" && e.Observer != nil {
+		publisherID = e.Observer.ID
+	}
+	// Notifications are wrapped in the envelope of version 2.0 of oslo.messaging
+	msg, err := json.Marshal(map[string]interface{}{
+		"message_id":   e.ID,
+		"publisher_id": publisherID,
+		"event_type":   eventType,
+		"priority":     "INFO",
+		"payload":      e,
+		"timestamp":    time.Now().UTC().Format(notificationTimeFormat),
+	})
+	if err != nil {
+		return err
+	}
+	envelope, err := json.Marshal(map[string]string{
+		"oslo.version": "2.0",
+		"oslo.message": string(msg),
+	})
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"properties":       map[string]string{"content_type": "application/json"},
+		"routing_key":      s.routingKey,
+		"payload":          string(envelope),
+		"payload_encoding": "string",
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Routed bool `json:"routed"`
+	}
+	if err := checkResponse(redact(s.url), resp, &result); err != nil {
+		return err
+	}
+	if !result.Routed {
+		return fmt.Errorf("notification is not routed to any queue with routing key %q", s.routingKey)
+	}
+	return nil
+}
+
+// redact removes the password from rawURL for error messages, since the management API is usually authenticated
+// with the credentials in the URL
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// checkResponse closes the response, decoding the body into result if not nil
+func checkResponse(endpoint string, resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("unexpected status code from %s: %s", endpoint, resp.Status)
+	}
+	if result == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("malformed response from %s: %v", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/perf"
+)
+
+// benchmarkEntries is the number of entries of the caches benchmarked, as of a deployment of 10k nodes
+const benchmarkEntries = 10000
+
+func newBenchmarkMemory(b *testing.B) *Memory {
+	m := NewBoundedMemory(Limits{MaxEntries: 2 * benchmarkEntries}, nil, "spire:")
+	value := make([]byte, 2048)
+	for i := 0; i < benchmarkEntries; i++ {
+		if err := m.Set("spire:instance:"+strconv.Itoa(i), value, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return m
+}
+
+func BenchmarkMemoryGet(b *testing.B) {
+	b.ReportAllocs()
+	m := newBenchmarkMemory(b)
+	keys := make([]string, benchmarkEntries)
+	for i := range keys {
+		keys[i] = "spire:instance:" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, _ := m.Get(keys[i%benchmarkEntries]); !ok {
+			b.Fatal("entry not found")
+		}
+	}
+}
+
+func BenchmarkMemoryGetMiss(b *testing.B) {
+	b.ReportAllocs()
+	m := newBenchmarkMemory(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, _ := m.Get("spire:instance:missing"); ok {
+			b.Fatal("unexpected entry")
+		}
+	}
+}
+
+// BenchmarkMemorySet measures stores into a full cache, each of which evicts an entry
+func BenchmarkMemorySet(b *testing.B) {
+	b.ReportAllocs()
+	m := NewBoundedMemory(Limits{MaxEntries: benchmarkEntries}, nil, "spire:")
+	value := make([]byte, 2048)
+	keys := make([]string, 2*benchmarkEntries)
+	for i := range keys {
+		keys[i] = "spire:instance:" + strconv.Itoa(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Set(keys[i%len(keys)], value, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPerformanceBudget(t *testing.T) {
+	perf.Check(t, []perf.Benchmark{
+		{Name: "MemoryGet", F: BenchmarkMemoryGet, Budget: perf.Budget{NsPerOp: 1000, AllocsPerOp: 1}},
+		{Name: "MemoryGetMiss", F: BenchmarkMemoryGetMiss, Budget: perf.Budget{NsPerOp: 500, AllocsPerOp: 1}},
+		{Name: "MemorySet", F: BenchmarkMemorySet, Budget: perf.Budget{NsPerOp: 5000}},
+	})
+}
@@ -0,0 +1,211 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+const (
+	BackendMemory    = "memory"
+	BackendRedis     = "redis"
+	BackendMemcached = "memcached"
+
+	defaultTimeout = 3 * time.Second
+)
+
+// ErrFull is returned by Add of the memory backend if entries which can't be evicted reach its bounds
+var ErrFull = errors.New("cache full")
+
+// Cache is a key-value store shared by the caches of the server plugins
+type Cache interface {
+	// Get returns the value of the key. The second return value is false if the key doesn't exist.
+	Get(key string) ([]byte, bool, error)
+	// Set stores the value of the key, which expires after ttl. Zero ttl means no expiration.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the key
+	Delete(key string) error
+	// Take returns the value of the key and removes it. Of concurrent callers, including ones
+	// on other servers sharing the backend, only one gets the value.
+	Take(key string) ([]byte, bool, error)
+	// Add stores the value of the key like Set unless the key exists, and returns true if it stored the value. Of
+	// concurrent callers, including ones on other servers sharing the backend, only one stores it. An error is
+	// returned if the value can't be stored, e.g. ErrFull.
+	Add(key string, value []byte, ttl time.Duration) (bool, error)
+}
+
+// Config represents the configuration of the cache backend
+type Config struct {
+	// "memory", "redis" or "memcached". Defaults to "memory".
+	Backend string `hcl:"backend" default:"memory"`
+	// Address of the redis or memcached server, e.g. "127.0.0.1:6379".
+	Address string `hcl:"address"`
+	// Username for the ACLs of the redis server.
+	Username string `hcl:"username"`
+	// Password for the redis server.
+	Password string `hcl:"password"`
+	// File containing the password for the redis server.
+	PasswordFile string `hcl:"password_file"`
+	// Database number of the redis server.
+	Database int `hcl:"database"`
+	// Prefix of all keys, which allows multiple deployments to share a backend.
+	KeyPrefix string `hcl:"key_prefix"`
+	// Connect to the redis or memcached server over TLS.
+	TLS bool `hcl:"tls"`
+	// File containing the CA certificates verifying the server over TLS. The system roots are used if empty.
+	TLSCAFile string `hcl:"tls_ca_file"`
+	// Maximum number of connections to the redis or memcached server. Defaults to 10.
+	PoolSize int `hcl:"pool_size"`
+
+	// Maximum number of entries of the memory backend. Unbounded if zero.
+	MaxEntries int `hcl:"max_entries"`
+	// Maximum size in bytes of the keys and values of the memory backend. Unbounded if zero.
+	MaxBytes int `hcl:"max_bytes"`
+	// Bounds of the entries of a kind in the memory backend, labeled with the kind, e.g. "instance".
+	Limits []*LimitConfig `hcl:"limit"`
+}
+
+// LimitConfig bounds the entries of a kind in the memory backend
+type LimitConfig struct {
+	Kind       string `hcl:",key"`
+	MaxEntries int    `hcl:"max_entries"`
+	MaxBytes   int    `hcl:"max_bytes"`
+}
+
+// New returns a Cache of the configured backend
+func New(c *Config) (Cache, error) {
+	if c == nil {
+		return NewMemory(), nil
+	}
+
+	bounded := c.MaxEntries != 0 || c.MaxBytes != 0 || len(c.Limits) > 0
+	if bounded && c.Backend != "" && c.Backend != BackendMemory {
+		return nil, fmt.Errorf("max_entries, max_bytes and limit apply to the memory cache only, not to %v", c.Backend)
+	}
+
+	pooled := c.TLS || c.TLSCAFile != "" || c.PoolSize != 0
+	if pooled && (c.Backend == "" || c.Backend == BackendMemory) {
+		return nil, errors.New("tls, tls_ca_file and pool_size apply to the redis and memcached caches only")
+	}
+	opts, err := dialOptions(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache Cache
+	switch c.Backend {
+	case "", BackendMemory:
+		kindLimits, err := kindLimits(c)
+		if err != nil {
+			return nil, err
+		}
+		cache = NewBoundedMemory(Limits{MaxEntries: c.MaxEntries, MaxBytes: c.MaxBytes}, kindLimits, c.KeyPrefix)
+	case BackendRedis:
+		if c.Address == "" {
+			return nil, fmt.Errorf("address is required for %v cache", c.Backend)
+		}
+		password, err := common.ResolveSecret("password", c.Password, c.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		cache = NewRedis(common.ExpandEnv(c.Address), c.Username, password, c.Database, opts)
+	case BackendMemcached:
+		if c.Address == "" {
+			return nil, fmt.Errorf("address is required for %v cache", c.Backend)
+		}
+		cache = NewMemcached(common.ExpandEnv(c.Address), opts)
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %q", c.Backend)
+	}
+
+	if c.KeyPrefix != "" {
+		cache = &prefixed{prefix: c.KeyPrefix, cache: cache}
+	}
+	return cache, nil
+}
+
+// dialOptions returns the options of the connections to the redis or memcached server
+func dialOptions(c *Config) (DialOptions, error) {
+	if c.PoolSize < 0 {
+		return DialOptions{}, errors.New("pool_size of the cache must not be negative")
+	}
+	opts := DialOptions{PoolSize: c.PoolSize}
+	if !c.TLS {
+		if c.TLSCAFile != "" {
+			return DialOptions{}, errors.New("tls_ca_file of the cache requires tls")
+		}
+		return opts, nil
+	}
+
+	opts.TLSConfig = &tls.Config{}
+	if c.TLSCAFile != "" {
+		b, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return DialOptions{}, fmt.Errorf("failed to read tls_ca_file of the cache: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(b) {
+			return DialOptions{}, fmt.Errorf("no certificates found in tls_ca_file of the cache: %v", c.TLSCAFile)
+		}
+		opts.TLSConfig.RootCAs = roots
+	}
+	return opts, nil
+}
+
+// kindLimits validates the bounds of the memory backend and returns the bounds of each kind
+func kindLimits(c *Config) (map[string]Limits, error) {
+	if c.MaxEntries < 0 || c.MaxBytes < 0 {
+		return nil, errors.New("max_entries and max_bytes of the cache must not be negative")
+	}
+	limits := make(map[string]Limits)
+	for _, l := range c.Limits {
+		if l.Kind == "" {
+			return nil, errors.New("limit of the cache requires a kind")
+		}
+		if _, ok := limits[l.Kind]; ok {
+			return nil, fmt.Errorf("duplicate limit of the cache: %v", l.Kind)
+		}
+		if l.MaxEntries < 0 || l.MaxBytes < 0 {
+			return nil, fmt.Errorf("max_entries and max_bytes of limit %v must not be negative", l.Kind)
+		}
+		limits[l.Kind] = Limits{MaxEntries: l.MaxEntries, MaxBytes: l.MaxBytes}
+	}
+	return limits, nil
+}
+
+type prefixed struct {
+	prefix string
+	cache  Cache
+}
+
+func (p *prefixed) Get(key string) ([]byte, bool, error) {
+	return p.cache.Get(p.prefix + key)
+}
+
+func (p *prefixed) Set(key string, value []byte, ttl time.Duration) error {
+	return p.cache.Set(p.prefix+key, value, ttl)
+}
+
+func (p *prefixed) Delete(key string) error {
+	return p.cache.Delete(p.prefix + key)
+}
+
+func (p *prefixed) Take(key string) ([]byte, bool, error) {
+	return p.cache.Take(p.prefix + key)
+}
+
+func (p *prefixed) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	return p.cache.Add(p.prefix+key, value, ttl)
+}
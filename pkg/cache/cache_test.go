@@ -0,0 +1,456 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	if err := m.Set("alpha", []byte("bravo"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ok, _ := m.Get("alpha"); !ok || string(v) != "bravo" {
+		t.Errorf("got %q, %v, want %q, true", v, ok, "bravo")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := m.Get("alpha"); ok {
+		t.Error("expired entry should not be found")
+	}
+
+	m.Set("charlie", []byte("delta"), 0)
+	m.Delete("charlie")
+	if _, ok, _ := m.Get("charlie"); ok {
+		t.Error("deleted entry should not be found")
+	}
+
+	m.Set("echo", []byte("foxtrot"), time.Minute)
+	if v, ok, _ := m.Take("echo"); !ok || string(v) != "foxtrot" {
+		t.Errorf("got %q, %v, want %q, true", v, ok, "foxtrot")
+	}
+	if _, ok, _ := m.Take("echo"); ok {
+		t.Error("taken entry should not be found")
+	}
+
+	if ok, _ := m.Add("golf", []byte("hotel"), time.Minute); !ok {
+		t.Error("absent entry should be added")
+	}
+	if ok, _ := m.Add("golf", []byte("india"), time.Minute); ok {
+		t.Error("existing entry should not be added again")
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := m.Add("golf", []byte("juliett"), time.Minute); !ok {
+		t.Error("expired entry should be replaced")
+	}
+}
+
+func TestMemoryBounded(t *testing.T) {
+	m := NewBoundedMemory(Limits{MaxEntries: 3, MaxBytes: 100}, map[string]Limits{"nonce": {MaxEntries: 1}}, "spire:")
+
+	m.Set("spire:instance:alpha", []byte("1"), 0)
+	m.Set("spire:instance:bravo", []byte("2"), 0)
+	m.Set("spire:instance:charlie", []byte("3"), 0)
+	// alpha is used more recently than bravo, which is evicted first
+	m.Get("spire:instance:alpha")
+	m.Set("spire:instance:delta", []byte("4"), 0)
+	for key, want := range map[string]bool{"alpha": true, "bravo": false, "charlie": true, "delta": true} {
+		if _, ok, _ := m.Get("spire:instance:" + key); ok != want {
+			t.Errorf("%v: got %v, want %v", key, ok, want)
+		}
+	}
+
+	// Nonces are bounded by their own limit, without evicting instances
+	m.Set("spire:nonce:echo", []byte("5"), 0)
+	m.Set("spire:nonce:foxtrot", []byte("6"), 0)
+	if _, ok, _ := m.Get("spire:nonce:echo"); ok {
+		t.Error("nonce beyond the limit of its kind should be evicted")
+	}
+	if _, ok, _ := m.Get("spire:nonce:foxtrot"); !ok {
+		t.Error("the latest nonce should be kept")
+	}
+	if got := m.kinds["instance"].entries; got != 2 {
+		t.Errorf("got %v instances, want 2", got)
+	}
+
+	// An entry larger than the bounds is not kept
+	m.Set("spire:denial:golf", make([]byte, 200), 0)
+	if _, ok, _ := m.Get("spire:denial:golf"); ok {
+		t.Error("entry exceeding max_bytes should be evicted")
+	}
+	if m.total.entries != 3 || m.total.bytes > 100 {
+		t.Errorf("unexpected usage: %v entries, %v bytes", m.total.entries, m.total.bytes)
+	}
+
+	// Replacing an entry doesn't count it twice
+	m.Set("spire:nonce:foxtrot", []byte("7"), 0)
+	if m.total.entries != 3 || m.kinds["nonce"].entries != 1 {
+		t.Errorf("unexpected usage: %v entries, %v nonces", m.total.entries, m.kinds["nonce"].entries)
+	}
+}
+
+func TestMemoryBoundedAdd(t *testing.T) {
+	now := time.Now()
+	m := NewBoundedMemory(Limits{MaxEntries: 3, MaxBytes: 100}, map[string]Limits{"nonce": {MaxEntries: 2}}, "")
+	m.now = func() time.Time { return now }
+
+	// Entries stored by Add aren't evicted by entries stored later
+	for _, key := range []string{"nonce:alpha", "nonce:bravo"} {
+		if ok, err := m.Add(key, []byte{}, time.Minute); !ok || err != nil {
+			t.Fatalf("%v: unexpected result: %v, %v", key, ok, err)
+		}
+	}
+	m.Set("nonce:charlie", []byte{}, time.Minute)
+	if ok, err := m.Add("nonce:delta", []byte{}, time.Minute); ok || err != ErrFull {
+		t.Errorf("got %v, %v, want false, %v", ok, err, ErrFull)
+	}
+	for key, want := range map[string]bool{"nonce:alpha": true, "nonce:bravo": true, "nonce:charlie": false, "nonce:delta": false} {
+		if _, ok, _ := m.Get(key); ok != want {
+			t.Errorf("%v: got %v, want %v", key, ok, want)
+		}
+	}
+	// ...but by other entries, which are evicted for them
+	m.Set("instance:echo", []byte{}, 0)
+	if ok, err := m.Add("other:foxtrot", []byte{}, time.Minute); !ok || err != nil {
+		t.Errorf("unexpected result: %v, %v", ok, err)
+	}
+	if _, ok, _ := m.Get("instance:echo"); ok {
+		t.Error("entry stored by Set should be evicted for an entry stored by Add")
+	}
+
+	// Expired entries stored by Add make room
+	now = now.Add(time.Minute)
+	if ok, err := m.Add("nonce:golf", []byte{}, time.Minute); !ok || err != nil {
+		t.Errorf("unexpected result: %v, %v", ok, err)
+	}
+
+	// An entry larger than the bounds is not stored
+	if ok, err := m.Add("other:hotel", make([]byte, 200), time.Minute); ok || err != ErrFull {
+		t.Errorf("got %v, %v, want false, %v", ok, err, ErrFull)
+	}
+	if m.total.entries > 3 || m.kinds["nonce"].entries > 2 {
+		t.Errorf("unexpected usage: %v entries, %v nonces", m.total.entries, m.kinds["nonce"].entries)
+	}
+}
+
+func TestNewBounds(t *testing.T) {
+	tCase := []struct {
+		config  *Config
+		wantErr bool
+	}{
+		// 0: bounded memory backend
+		{config: &Config{MaxEntries: 100000, Limits: []*LimitConfig{{Kind: "nonce", MaxEntries: 10000}}}},
+		// 1: bounds of other backends
+		{config: &Config{Backend: BackendRedis, Address: "127.0.0.1:6379", MaxEntries: 100}, wantErr: true},
+		// 2: negative bound
+		{config: &Config{MaxBytes: -1}, wantErr: true},
+		// 3: duplicate kind
+		{config: &Config{Limits: []*LimitConfig{{Kind: "nonce"}, {Kind: "nonce"}}}, wantErr: true},
+		// 4: limit without kind
+		{config: &Config{Limits: []*LimitConfig{{MaxEntries: 1}}}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		_, err := New(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestNewPrefixed(t *testing.T) {
+	c, err := New(&Config{KeyPrefix: "spire:"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Set("alpha", []byte("bravo"), 0)
+
+	m := c.(*prefixed).cache
+	if _, ok, _ := m.Get("spire:alpha"); !ok {
+		t.Error("key should be prefixed")
+	}
+}
+
+func TestNewDialOptions(t *testing.T) {
+	tCase := []struct {
+		config  *Config
+		wantErr bool
+	}{
+		// 0: redis over TLS with the system roots
+		{config: &Config{Backend: BackendRedis, Address: "127.0.0.1:6379", TLS: true, PoolSize: 20}},
+		// 1: CA file without TLS
+		{config: &Config{Backend: BackendMemcached, Address: "127.0.0.1:11211", TLSCAFile: "ca.pem"}, wantErr: true},
+		// 2: missing CA file
+		{config: &Config{Backend: BackendRedis, Address: "127.0.0.1:6379", TLS: true, TLSCAFile: "nonexistent.pem"}, wantErr: true},
+		// 3: negative pool size
+		{config: &Config{Backend: BackendRedis, Address: "127.0.0.1:6379", PoolSize: -1}, wantErr: true},
+		// 4: options of the memory backend
+		{config: &Config{TLS: true}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		_, err := New(c.config)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestRedisPool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	values := make(map[string][]byte)
+	var dialed int
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			dialed++
+			mu.Unlock()
+			go serveRedis(conn, &mu, values)
+		}
+	}()
+
+	c := NewRedis(l.Addr().String(), "alpha", "secret", 0, DialOptions{PoolSize: 2})
+	if err := c.Set("bravo", []byte("charlie"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ok, err := c.Get("bravo"); !ok || err != nil || string(v) != "charlie" {
+		t.Errorf("got %q, %v, %v, want %q, true, nil", v, ok, err, "charlie")
+	}
+	// An error reply doesn't drop the connection
+	if _, err := c.do("UNKNOWN"); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	if _, ok, _ := c.Take("bravo"); !ok {
+		t.Error("the value should be taken")
+	}
+	if ok, err := c.Add("bravo", []byte("echo"), time.Minute); !ok || err != nil {
+		t.Errorf("got %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := c.Add("bravo", []byte("foxtrot"), time.Minute); ok || err != nil {
+		t.Errorf("got %v, %v, want false, nil", ok, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.Set(fmt.Sprint(i), []byte("delta"), 0); err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dialed < 1 || dialed > 2 {
+		t.Errorf("got %v connections, want 1 or 2 of the pool", dialed)
+	}
+}
+
+func TestRedisTakeConcurrently(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	values := make(map[string][]byte)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveRedis(conn, &mu, values)
+		}
+	}()
+
+	c := NewRedis(l.Addr().String(), "alpha", "secret", 0, DialOptions{PoolSize: 8})
+
+	// Values are added to the key one after another once the previous one is taken. A value would be taken twice,
+	// and the next one lost, if the key could be deleted after another caller took it and the next value was added.
+	const n = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			for {
+				ok, err := c.Add("bravo", []byte(strconv.Itoa(i)), time.Minute)
+				if err != nil {
+					t.Errorf("#%v: unexpected error: %v", i, err)
+					return
+				}
+				if ok {
+					break
+				}
+			}
+		}
+	}()
+
+	var takenMu sync.Mutex
+	taken := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// The key is checked once more after the last value was added
+				finished := false
+				select {
+				case <-done:
+					finished = true
+				default:
+				}
+				v, ok, err := c.Take("bravo")
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if ok {
+					takenMu.Lock()
+					taken[string(v)]++
+					takenMu.Unlock()
+				} else if finished {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if got := taken[strconv.Itoa(i)]; got != 1 {
+			t.Errorf("value %v is taken %v times, want once", i, got)
+		}
+	}
+}
+
+// serveRedis replies to the commands of the tests, which must start with AUTH
+func serveRedis(conn net.Conn, mu *sync.Mutex, values map[string][]byte) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		req, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range req.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		mu.Lock()
+		switch {
+		case args[0] == "AUTH":
+			authenticated = len(args) == 3 && args[1] == "alpha" && args[2] == "secret"
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required\r\n")
+		case args[0] == "SET":
+			if _, ok := values[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+				fmt.Fprint(conn, "$-1\r\n")
+				break
+			}
+			values[args[1]] = []byte(args[2])
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "GET":
+			if v, ok := values[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case args[0] == "EVAL" && args[1] == redisTakeScript:
+			if v, ok := values[args[3]]; ok {
+				delete(values, args[3])
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case args[0] == "DEL":
+			_, ok := values[args[1]]
+			delete(values, args[1])
+			if ok {
+				fmt.Fprint(conn, ":1\r\n")
+			} else {
+				fmt.Fprint(conn, ":0\r\n")
+			}
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+		mu.Unlock()
+	}
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	if _, err := New(&Config{Backend: "unknown"}); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tCase := []struct {
+		in      string
+		want    interface{}
+		wantErr bool
+	}{
+		{in: "+OK\r\n", want: "OK"},
+		{in: "$5\r\nbravo\r\n", want: "bravo"},
+		{in: "$-1\r\n", want: nil},
+		{in: "-ERR unknown\r\n", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		got, err := readRedisReply(bufio.NewReader(strings.NewReader(c.in)))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if b, ok := got.([]byte); ok {
+			got = string(b)
+		}
+		if got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
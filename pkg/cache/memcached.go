@@ -0,0 +1,158 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Memcached is a Cache stored in a memcached server
+type Memcached struct {
+	pool *connPool
+}
+
+// NewMemcached returns a Cache stored in the memcached server at address.
+// Connections are established lazily.
+func NewMemcached(address string, opts DialOptions) *Memcached {
+	return &Memcached{
+		pool: newConnPool(address, opts, nil),
+	}
+}
+
+func (c *Memcached) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var found bool
+	err := c.roundTrip(fmt.Sprintf("get %s\r\n", key), nil, func(r *bufio.Reader) error {
+		for {
+			line, err := readLine(r)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes>
+			f := strings.Fields(line)
+			if len(f) < 4 || f[0] != "VALUE" {
+				return fmt.Errorf("unexpected memcached reply: %q", line)
+			}
+			n, err := strconv.Atoi(f[3])
+			if err != nil {
+				return err
+			}
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return err
+			}
+			value, found = b[:n], true
+		}
+	})
+	return value, found, err
+}
+
+func (c *Memcached) Set(key string, value []byte, ttl time.Duration) error {
+	cmd := fmt.Sprintf("set %s 0 %d %d\r\n", key, memcachedExptime(ttl), len(value))
+	return c.roundTrip(cmd, value, expectReply("STORED"))
+}
+
+func (c *Memcached) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	var stored bool
+	cmd := fmt.Sprintf("add %s 0 %d %d\r\n", key, memcachedExptime(ttl), len(value))
+	err := c.roundTrip(cmd, value, func(r *bufio.Reader) error {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			stored = true
+		case "NOT_STORED":
+		default:
+			return fmt.Errorf("unexpected memcached reply: %q", line)
+		}
+		return nil
+	})
+	return stored, err
+}
+
+// memcachedExptime returns ttl in seconds, rounded up since memcached expiration has a resolution of seconds
+func memcachedExptime(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func (c *Memcached) Delete(key string) error {
+	return c.roundTrip(fmt.Sprintf("delete %s\r\n", key), nil, expectReply("DELETED", "NOT_FOUND"))
+}
+
+// Take gets the value and deletes the key. Only the caller whose delete removed the key gets the value.
+func (c *Memcached) Take(key string) ([]byte, bool, error) {
+	value, ok, err := c.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var deleted bool
+	err = c.roundTrip(fmt.Sprintf("delete %s\r\n", key), nil, func(r *bufio.Reader) error {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "DELETED":
+			deleted = true
+		case "NOT_FOUND":
+		default:
+			return fmt.Errorf("unexpected memcached reply: %q", line)
+		}
+		return nil
+	})
+	if err != nil || !deleted {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func expectReply(want ...string) func(*bufio.Reader) error {
+	return func(r *bufio.Reader) error {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		for _, w := range want {
+			if line == w {
+				return nil
+			}
+		}
+		return fmt.Errorf("unexpected memcached reply: %q", line)
+	}
+}
+
+// roundTrip sends a command with optional data block and reads its reply.
+// The connection is dropped on any error.
+func (c *Memcached) roundTrip(cmd string, data []byte, read func(*bufio.Reader) error) error {
+	return c.pool.do(func(conn *poolConn) error {
+		conn.w.WriteString(cmd)
+		if data != nil {
+			conn.w.Write(data)
+			conn.w.WriteString("\r\n")
+		}
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		return read(conn.r)
+	}, func(error) bool {
+		return false
+	})
+}
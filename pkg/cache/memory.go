@@ -0,0 +1,312 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	// evictionCapacity and evictionExpired label evictions of entries beyond the bounds, which were still valid or
+	// already expired
+	evictionCapacity = "capacity"
+	evictionExpired  = "expired"
+
+	// otherKind is the kind of keys without a kind prefix
+	otherKind = "other"
+)
+
+var (
+	memoryEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of entries of the memory cache, by kind.",
+	}, []string{"kind"})
+	memoryBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "cache",
+		Name:      "bytes",
+		Help:      "Size of the keys and values of the memory cache, by kind.",
+	}, []string{"kind"})
+	evictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Number of entries of the memory cache evicted to stay within its bounds, by kind and reason.",
+	}, []string{"kind", "reason"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(memoryEntries, memoryBytes, evictionsTotal)
+}
+
+// Limits bounds the entries of a memory cache. Zero means no bound.
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int
+}
+
+type memoryEntry struct {
+	key     string
+	kind    string
+	value   []byte
+	expires time.Time
+	// added is true for entries stored by Add, which aren't evicted for capacity
+	added bool
+
+	// elements of the entry in the lists of all entries and of its kind
+	element     *list.Element
+	kindElement *list.Element
+}
+
+func (e *memoryEntry) size() int {
+	return len(e.key) + len(e.value)
+}
+
+// list returns the list of u the entry belongs to
+func (e *memoryEntry) list(u *usage) *list.List {
+	if e.added {
+		return u.added
+	}
+	return u.lru
+}
+
+// usage is the usage of a memory cache, or of the entries of a kind, with its entries stored by Set from the most
+// recently used, and the ones stored by Add from the most recently added
+type usage struct {
+	limits  Limits
+	entries int
+	bytes   int
+	lru     *list.List
+	added   *list.List
+}
+
+func newUsage(limits Limits) *usage {
+	return &usage{limits: limits, lru: list.New(), added: list.New()}
+}
+
+func (u *usage) exceeded() bool {
+	return (u.limits.MaxEntries > 0 && u.entries > u.limits.MaxEntries) || (u.limits.MaxBytes > 0 && u.bytes > u.limits.MaxBytes)
+}
+
+// Memory is an in-memory Cache. If bounded, the least recently used entries are evicted beyond the bounds. Entries
+// stored by Add, e.g. used nonces, are evicted only once expired, since forgetting them would let them be added again,
+// and Add fails with ErrFull if they alone reach the bounds.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	total   *usage
+	kinds   map[string]*usage
+	// kindLimits are the bounds of the entries of each kind
+	kindLimits map[string]Limits
+	// prefix is the prefix of all keys, which is stripped to tell the kind of keys
+	prefix string
+	now    func() time.Time
+}
+
+// NewMemory returns an empty, unbounded in-memory Cache
+func NewMemory() *Memory {
+	return NewBoundedMemory(Limits{}, nil, "")
+}
+
+// NewBoundedMemory returns an empty in-memory Cache bounded by limits, and for each kind by kindLimits.
+// The kind of a key is its part up to the first colon after prefix, e.g. "instance" of "instance:<uuid>".
+func NewBoundedMemory(limits Limits, kindLimits map[string]Limits, prefix string) *Memory {
+	return &Memory{
+		entries:    make(map[string]*memoryEntry),
+		total:      newUsage(limits),
+		kinds:      make(map[string]*usage),
+		kindLimits: kindLimits,
+		prefix:     prefix,
+		now:        time.Now,
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if m.expired(e) {
+		m.remove(e)
+		return nil, false, nil
+	}
+	if !e.added {
+		m.total.lru.MoveToFront(e.element)
+		m.kinds[e.kind].lru.MoveToFront(e.kindElement)
+	}
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+	e := m.newEntry(key, value, ttl)
+	// An entry which alone exceeds the bounds is not kept, rather than evicting all others for it
+	if m.tooLarge(e) {
+		evictionsTotal.WithLabelValues(e.kind, evictionCapacity).Inc()
+		return nil
+	}
+	m.add(e)
+	m.fit(m.kinds[e.kind])
+	return nil
+}
+
+func (m *Memory) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		if !m.expired(e) {
+			return false, nil
+		}
+		m.remove(e)
+	}
+	e := m.newEntry(key, value, ttl)
+	e.added = true
+	if m.tooLarge(e) {
+		return false, ErrFull
+	}
+	m.add(e)
+	if !m.fit(m.kinds[e.kind]) {
+		m.remove(e)
+		return false, ErrFull
+	}
+	return true, nil
+}
+
+func (m *Memory) newEntry(key string, value []byte, ttl time.Duration) *memoryEntry {
+	e := &memoryEntry{key: key, kind: m.kindOf(key), value: value}
+	if ttl > 0 {
+		e.expires = m.now().Add(ttl)
+	}
+	return e
+}
+
+// fit evicts entries until the entries of ku and all entries are within their bounds, first the least recently used
+// entries stored by Set, then the expired entries stored by Add from the oldest. It returns false if unexpired
+// entries stored by Add alone exceed the bounds. m.mu must be held.
+func (m *Memory) fit(ku *usage) bool {
+	for _, u := range []*usage{ku, m.total} {
+		for u.exceeded() {
+			if el := u.lru.Back(); el != nil {
+				m.evict(el.Value.(*memoryEntry))
+				continue
+			}
+			el := u.added.Back()
+			if el == nil || !m.expired(el.Value.(*memoryEntry)) {
+				return false
+			}
+			m.evict(el.Value.(*memoryEntry))
+		}
+	}
+	return true
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+	return nil
+}
+
+func (m *Memory) Take(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	m.remove(e)
+	if m.expired(e) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// tooLarge returns true if the entry alone exceeds the bytes of the bounds or of the bounds of its kind
+func (m *Memory) tooLarge(e *memoryEntry) bool {
+	return exceedsBytes(m.total.limits, e) || exceedsBytes(m.kindLimits[e.kind], e)
+}
+
+// exceedsBytes returns true if the entry alone exceeds the bytes of limits
+func exceedsBytes(limits Limits, e *memoryEntry) bool {
+	return limits.MaxBytes > 0 && e.size() > limits.MaxBytes
+}
+
+func (m *Memory) expired(e *memoryEntry) bool {
+	return !e.expires.IsZero() && !m.now().Before(e.expires)
+}
+
+// kindOf returns the kind of the key
+func (m *Memory) kindOf(key string) string {
+	key = strings.TrimPrefix(key, m.prefix)
+	if i := strings.Index(key, ":"); i > 0 {
+		return key[:i]
+	}
+	return otherKind
+}
+
+func (m *Memory) add(e *memoryEntry) {
+	ku, ok := m.kinds[e.kind]
+	if !ok {
+		ku = newUsage(m.kindLimits[e.kind])
+		m.kinds[e.kind] = ku
+	}
+	m.entries[e.key] = e
+	e.element = e.list(m.total).PushFront(e)
+	e.kindElement = e.list(ku).PushFront(e)
+	m.account(ku, 1, e.size())
+	memoryEntries.WithLabelValues(e.kind).Set(float64(ku.entries))
+	memoryBytes.WithLabelValues(e.kind).Set(float64(ku.bytes))
+}
+
+func (m *Memory) remove(e *memoryEntry) {
+	ku := m.kinds[e.kind]
+	delete(m.entries, e.key)
+	e.list(m.total).Remove(e.element)
+	e.list(ku).Remove(e.kindElement)
+	m.account(ku, -1, -e.size())
+	memoryEntries.WithLabelValues(e.kind).Set(float64(ku.entries))
+	memoryBytes.WithLabelValues(e.kind).Set(float64(ku.bytes))
+}
+
+func (m *Memory) account(ku *usage, entries, bytes int) {
+	ku.entries += entries
+	ku.bytes += bytes
+	m.total.entries += entries
+	m.total.bytes += bytes
+}
+
+func (m *Memory) evict(e *memoryEntry) {
+	reason := evictionCapacity
+	if m.expired(e) {
+		reason = evictionExpired
+	}
+	m.remove(e)
+	evictionsTotal.WithLabelValues(e.kind, reason).Inc()
+}
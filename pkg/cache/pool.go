@@ -0,0 +1,147 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultPoolSize = 10
+
+// DialOptions are the options of the connections to a redis or memcached server
+type DialOptions struct {
+	// TLSConfig enables TLS if not nil
+	TLSConfig *tls.Config
+	// PoolSize is the maximum number of connections. defaultPoolSize is used if zero.
+	PoolSize int
+	// Timeout bounds dialing and each operation. defaultTimeout is used if zero.
+	Timeout time.Duration
+}
+
+// connPool bounds the connections to a server and keeps the idle ones. Each operation holds a connection of its own,
+// so that operations don't queue up behind a slow one.
+type connPool struct {
+	address string
+	opts    DialOptions
+	// init runs on new connections, e.g. to authenticate
+	init func(*poolConn) error
+
+	// slots holds a token for each connection in use
+	slots chan struct{}
+	mu    sync.Mutex
+	idle  []*poolConn
+}
+
+type poolConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newConnPool(address string, opts DialOptions, init func(*poolConn) error) *connPool {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = defaultPoolSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	return &connPool{
+		address: address,
+		opts:    opts,
+		init:    init,
+		slots:   make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// get returns an idle connection or dials a new one. It waits for a connection to be released for the timeout at
+// most if all of them are in use.
+func (p *connPool) get() (*poolConn, error) {
+	timer := time.NewTimer(p.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, errors.New("timed out waiting for a connection to the cache")
+	}
+
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put releases the connection, which is closed if broken is true
+func (p *connPool) put(c *poolConn, broken bool) {
+	if broken {
+		c.Close()
+	} else {
+		p.mu.Lock()
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// do runs f with a connection whose deadline is the timeout. The connection is dropped if f returns an error
+// for which keep returns false.
+func (p *connPool) do(f func(*poolConn) error, keep func(error) bool) error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = c.SetDeadline(time.Now().Add(p.opts.Timeout))
+	if err == nil {
+		err = f(c)
+	}
+	p.put(c, err != nil && !keep(err))
+	return err
+}
+
+func (p *connPool) dial() (*poolConn, error) {
+	dialer := &net.Dialer{Timeout: p.opts.Timeout}
+	var conn net.Conn
+	var err error
+	if p.opts.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.address, p.opts.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", p.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %v: %v", p.address, err)
+	}
+
+	c := &poolConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if p.init != nil {
+		err := c.SetDeadline(time.Now().Add(p.opts.Timeout))
+		if err == nil {
+			err = p.init(c)
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
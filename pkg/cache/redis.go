@@ -0,0 +1,216 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Redis is a Cache stored in a redis server
+type Redis struct {
+	pool *connPool
+}
+
+// NewRedis returns a Cache stored in the redis server at address. Connections are established lazily, and
+// authenticated with username and password if password is set. Username is for the ACLs of redis 6.
+func NewRedis(address, username, password string, database int, opts DialOptions) *Redis {
+	return &Redis{
+		pool: newConnPool(address, opts, func(c *poolConn) error {
+			if password != "" {
+				args := []string{"AUTH", password}
+				if username != "" {
+					args = []string{"AUTH", username, password}
+				}
+				if _, err := redisRoundTrip(c, args...); err != nil {
+					return fmt.Errorf("failed to authenticate to redis: %v", err)
+				}
+			}
+			if database != 0 {
+				if _, err := redisRoundTrip(c, "SELECT", strconv.Itoa(database)); err != nil {
+					return fmt.Errorf("failed to select redis database: %v", err)
+				}
+			}
+			return nil
+		}),
+	}
+}
+
+func (c *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected redis reply: %v", reply)
+	}
+	return b, true, nil
+}
+
+func (c *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(redisMillis(ttl), 10))
+	}
+	_, err := c.do(args...)
+	return err
+}
+
+// redisMillis returns ttl in milliseconds, at least one
+func redisMillis(ttl time.Duration) int64 {
+	ms := int64(ttl / time.Millisecond)
+	if ms == 0 {
+		ms = 1
+	}
+	return ms
+}
+
+func (c *Redis) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", key, string(value), "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(redisMillis(ttl), 10))
+	}
+	reply, err := c.do(args...)
+	if err != nil {
+		return false, err
+	}
+	// SET with NX replies nil if the key exists
+	return reply != nil, nil
+}
+
+func (c *Redis) Delete(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// redisTakeScript gets the value of the key and deletes it atomically. GETDEL does the same, but requires redis 6.2.
+const redisTakeScript = `local v = redis.call("GET", KEYS[1])
+if v then
+	redis.call("DEL", KEYS[1])
+end
+return v`
+
+// Take gets the value and deletes the key in a script, so that no other command runs in between. Only one of
+// concurrent callers gets the value, and a value set after it was taken is not deleted.
+func (c *Redis) Take(key string) ([]byte, bool, error) {
+	reply, err := c.do("EVAL", redisTakeScript, "1", key)
+	if err != nil {
+		return nil, false, err
+	}
+	// The script replies nil if the key doesn't exist
+	if reply == nil {
+		return nil, false, nil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected redis reply: %v", reply)
+	}
+	return b, true, nil
+}
+
+// do sends a command and reads its reply. The connection is dropped on any I/O error.
+func (c *Redis) do(args ...string) (interface{}, error) {
+	var reply interface{}
+	err := c.pool.do(func(conn *poolConn) (err error) {
+		reply, err = redisRoundTrip(conn, args...)
+		return err
+	}, func(err error) bool {
+		_, ok := err.(redisError)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func redisRoundTrip(c *poolConn, args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readRedisReply reads a reply in RESP format
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply: %q", line)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,104 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/perf"
+)
+
+// benchmarkPayload returns the JSON payload of an agent sending the metadata and an HMAC
+func benchmarkPayload(b *testing.B) []byte {
+	p := &AttestationPayload{
+		Version:    PayloadVersion2,
+		InstanceID: "3b4b6ea6-3ad4-4c7c-9b8b-5d6f1b0b7c11",
+		ProjectID:  "alpha",
+		Metadata:   &PayloadMetadata{Name: "web-1", Hostname: "web-1", AvailabilityZone: "nova", Source: "metadata_service"},
+	}
+	p.SignHMAC("k1", []byte("0123456789abcdef"), "", time.Now())
+	data, err := p.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkParseAttestationPayload(b *testing.B) {
+	b.ReportAllocs()
+	data := benchmarkPayload(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAttestationPayload(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAttestationPayloadBare(b *testing.B) {
+	b.ReportAllocs()
+	data := []byte("3b4b6ea6-3ad4-4c7c-9b8b-5d6f1b0b7c11")
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAttestationPayload(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAttestationPayloadCompressed(b *testing.B) {
+	b.ReportAllocs()
+	data, err := CompressPayload(benchmarkPayload(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAttestationPayload(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyHMAC(b *testing.B) {
+	b.ReportAllocs()
+	secret := []byte("0123456789abcdef")
+	p, err := ParseAttestationPayload(benchmarkPayload(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	secrets := func(string) []byte { return secret }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.VerifyHMAC(secrets, time.Minute, time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSanitize(b *testing.B) {
+	b.ReportAllocs()
+	s := &SelectorSanitization{Strictness: SanitizationStrict, Lowercase: true}
+	if err := s.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Sanitize("Web-Frontend_01.example")
+	}
+}
+
+func TestPerformanceBudget(t *testing.T) {
+	perf.Check(t, []perf.Benchmark{
+		{Name: "ParseAttestationPayload", F: BenchmarkParseAttestationPayload, Budget: perf.Budget{NsPerOp: 50000}},
+		{Name: "ParseAttestationPayloadBare", F: BenchmarkParseAttestationPayloadBare, Budget: perf.Budget{NsPerOp: 2000}},
+		{Name: "ParseAttestationPayloadCompressed", F: BenchmarkParseAttestationPayloadCompressed, Budget: perf.Budget{NsPerOp: 100000}},
+		{Name: "VerifyHMAC", F: BenchmarkVerifyHMAC, Budget: perf.Budget{NsPerOp: 20000}},
+		{Name: "Sanitize", F: BenchmarkSanitize, Budget: perf.Budget{NsPerOp: 2000}},
+	})
+}
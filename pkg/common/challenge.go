@@ -0,0 +1,122 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ChallengeConsoleBeacon asks the agent to write the nonce to the serial console of the instance
+	ChallengeConsoleBeacon = "console_beacon"
+	// ChallengeHMAC asks the agent to authenticate the nonce with its payload HMAC secret
+	ChallengeHMAC = "hmac"
+	// ChallengeVersion advertises the payload versions the server supports to agents listing the versions they can
+	// send, which pick one and send their attestation data again in it unless they sent it in that version already
+	ChallengeVersion = "version"
+
+	// consoleBeaconPrefix starts the console line of a beacon, so that it can't be mistaken for other output
+	consoleBeaconPrefix = "SPIRE-OPENSTACK-BEACON "
+)
+
+// Challenge is a challenge sent by the server plugin after verifying the attestation data
+type Challenge struct {
+	Type  string `json:"type"`
+	Nonce string `json:"nonce"`
+	// Versions are the payload versions the server supports, advertised by version challenges
+	Versions []int `json:"versions,omitempty"`
+}
+
+// ChallengeResponse is the answer of the agent plugin to a challenge, sent once the challenge is carried out
+type ChallengeResponse struct {
+	Type  string `json:"type"`
+	Nonce string `json:"nonce"`
+	// KeyID and MAC answer HMAC challenges
+	KeyID string `json:"key_id,omitempty"`
+	MAC   string `json:"mac,omitempty"`
+	// Version is the payload version the agent picked answering a version challenge, and AttestationData the
+	// attestation data in it. The data is empty if the agent sent it in that version already.
+	Version         int    `json:"version,omitempty"`
+	AttestationData []byte `json:"attestation_data,omitempty"`
+}
+
+// Marshal encodes the challenge
+func (c *Challenge) Marshal() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// Marshal encodes the challenge response
+func (r *ChallengeResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ParseChallenge parses a challenge sent by the server plugin
+func ParseChallenge(data []byte) (*Challenge, error) {
+	c := &Challenge{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("malformed challenge: %v", err)
+	}
+	if c.Type == "" || c.Nonce == "" {
+		return nil, errors.New("challenge lacks type or nonce")
+	}
+	return c, nil
+}
+
+// ParseChallengeResponse parses a challenge response sent by the agent plugin
+func ParseChallengeResponse(data []byte) (*ChallengeResponse, error) {
+	r := &ChallengeResponse{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("malformed challenge response: %v", err)
+	}
+	if r.Type == "" || r.Nonce == "" {
+		return nil, errors.New("challenge response lacks type or nonce")
+	}
+	return r, nil
+}
+
+// SupportedPayloadVersions returns the payload versions this release can send and accept, in ascending order
+func SupportedPayloadVersions() []int {
+	return []int{PayloadVersion1, PayloadVersion2}
+}
+
+// NegotiatePayloadVersion returns the highest of the payload versions the agent can send which the server advertised,
+// or an error if there is none
+func NegotiatePayloadVersion(agent, server []int) (int, error) {
+	picked := 0
+	for _, v := range agent {
+		for _, s := range server {
+			if v == s && v > picked {
+				picked = v
+			}
+		}
+	}
+	if picked == 0 {
+		return 0, fmt.Errorf("no payload version in common: agent sends %v, server supports %v", agent, server)
+	}
+	return picked, nil
+}
+
+// ConsoleBeaconLine returns the console line answering a console beacon challenge with nonce
+func ConsoleBeaconLine(nonce string) string {
+	return consoleBeaconPrefix + nonce
+}
+
+// HasConsoleBeacon returns true if the console output contains the beacon line of nonce.
+// Only whole lines match, so that echoes of the nonce within other output don't.
+func HasConsoleBeacon(output, nonce string) bool {
+	want := ConsoleBeaconLine(nonce)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimRight(line, "\r") == want {
+			return true
+		}
+	}
+	return false
+}
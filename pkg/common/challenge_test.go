@@ -0,0 +1,86 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"testing"
+)
+
+func TestHasConsoleBeacon(t *testing.T) {
+	tCase := []struct {
+		output string
+		want   bool
+	}{
+		// 0: beacon line
+		{output: "login: \nSPIRE-OPENSTACK-BEACON n0nce\n", want: true},
+		// 1: beacon line ending with CR of serial consoles
+		{output: "SPIRE-OPENSTACK-BEACON n0nce\r\nlogin: ", want: true},
+		// 2: beacon of another nonce
+		{output: "SPIRE-OPENSTACK-BEACON n0nce2\n"},
+		// 3: nonce within another line
+		{output: "$ echo SPIRE-OPENSTACK-BEACON n0nce\n"},
+		// 4: empty output
+		{output: ""},
+	}
+
+	for i, c := range tCase {
+		if got := HasConsoleBeacon(c.output, "n0nce"); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	for i, c := range []struct {
+		data    string
+		wantErr bool
+	}{
+		// 0: console beacon
+		{data: `{"type": "console_beacon", "nonce": "n0nce"}`},
+		// 1: lacking nonce
+		{data: `{"type": "console_beacon"}`, wantErr: true},
+		// 2: malformed
+		{data: `n0nce`, wantErr: true},
+	} {
+		_, err := ParseChallenge([]byte(c.data))
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+		_, err = ParseChallengeResponse([]byte(c.data))
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error of response: %v", i, err)
+		}
+	}
+}
+
+func TestNegotiatePayloadVersion(t *testing.T) {
+	for i, c := range []struct {
+		agent   []int
+		server  []int
+		want    int
+		wantErr bool
+	}{
+		// 0: the highest in common
+		{agent: []int{1, 2}, server: []int{1, 2}, want: 2},
+		// 1: servers predating a version
+		{agent: []int{1, 2, 3}, server: []int{1, 2}, want: 2},
+		// 2: agents predating a version
+		{agent: []int{1}, server: []int{1, 2}, want: 1},
+		// 3: none in common
+		{agent: []int{2}, server: []int{1}, wantErr: true},
+	} {
+		got, err := NegotiatePayloadVersion(c.agent, c.server)
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
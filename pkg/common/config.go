@@ -0,0 +1,18 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"github.com/hashicorp/hcl"
+)
+
+// DecodeConfig decodes plugin configuration written in either HCL or JSON into out.
+// hcl.Decode detects JSON by itself.
+func DecodeConfig(out interface{}, config string) error {
+	return hcl.Decode(out, config)
+}
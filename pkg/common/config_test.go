@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testConfig struct {
+	CloudName string   `hcl:"cloud_name"`
+	Projects  []string `hcl:"projects"`
+	Quota     int      `hcl:"quota"`
+	Nested    *struct {
+		Backend string `hcl:"backend"`
+	} `hcl:"nested"`
+}
+
+func TestDecodeConfig(t *testing.T) {
+	for i, in := range []string{
+		`
+		cloud_name = "alpha"
+		projects = ["bravo", "charlie"]
+		quota = 10
+		nested {
+			backend = "delta"
+		}
+		`,
+		`
+		{
+			"cloud_name": "alpha",
+			"projects": ["bravo", "charlie"],
+			"quota": 10,
+			"nested": {
+				"backend": "delta"
+			}
+		}
+		`,
+	} {
+		var c testConfig
+		if err := DecodeConfig(&c, in); err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if c.CloudName != "alpha" || !reflect.DeepEqual(c.Projects, []string{"bravo", "charlie"}) || c.Quota != 10 {
+			t.Errorf("#%v: unexpected config: %+v", i, c)
+		}
+		if c.Nested == nil || c.Nested.Backend != "delta" {
+			t.Errorf("#%v: unexpected nested config: %+v", i, c.Nested)
+		}
+	}
+}
+
+func TestDecodeConfigInvalidJSON(t *testing.T) {
+	var c testConfig
+	if err := DecodeConfig(&c, `{"cloud_name": }`); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
@@ -0,0 +1,37 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+)
+
+// CallWithContext runs f and returns ctx.Err() as soon as ctx is done.
+// It is used for sends and receives on gRPC streams, which don't take a context. An abandoned call
+// keeps running in the background until the stream is closed as the RPC returns, so f must not touch
+// state the caller uses after ctx is done. Requests to OpenStack are bound to ctx instead.
+func CallWithContext(ctx context.Context, f func() error) error {
+	if ctx.Done() == nil {
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
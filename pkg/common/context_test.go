@@ -0,0 +1,39 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCallWithContext(t *testing.T) {
+	want := errors.New("failed")
+	if err := CallWithContext(context.Background(), func() error { return want }); err != want {
+		t.Errorf("unexpected error: got %v, want %v", err, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	if err := CallWithContext(ctx, func() error { called = true; return nil }); err != context.Canceled {
+		t.Errorf("unexpected error: got %v, want %v", err, context.Canceled)
+	}
+	if called {
+		t.Error("function must not be called with a cancelled context")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	block := make(chan struct{})
+	defer close(block)
+	go cancel()
+	if err := CallWithContext(ctx, func() error { <-block; return nil }); err != context.Canceled {
+		t.Errorf("unexpected error: got %v, want %v", err, context.Canceled)
+	}
+}
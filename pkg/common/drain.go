@@ -0,0 +1,98 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Drain tracks the attestations in flight so that a plugin can be shut down without cutting them off.
+// Once Close is called no new attestation is admitted, and the ones in flight are given until the
+// deadline of Close to finish before they are cancelled.
+// The zero value is ready to use.
+type Drain struct {
+	mtx      sync.Mutex
+	closing  bool
+	next     uint64
+	cancels  map[uint64]context.CancelFunc
+	inflight sync.WaitGroup
+}
+
+// Enter admits an attestation and returns the context it must run with and the function
+// to call once it is done. It returns false if the plugin is shutting down.
+func (d *Drain) Enter(ctx context.Context) (context.Context, func(), bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.closing {
+		return nil, nil, false
+	}
+	if d.cancels == nil {
+		d.cancels = make(map[uint64]context.CancelFunc)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	id := d.next
+	d.next++
+	d.cancels[id] = cancel
+	d.inflight.Add(1)
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			d.mtx.Lock()
+			delete(d.cancels, id)
+			d.mtx.Unlock()
+			cancel()
+			d.inflight.Done()
+		})
+	}, true
+}
+
+// Closing returns true once Close is called.
+func (d *Drain) Closing() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.closing
+}
+
+// Close stops admitting attestations and waits for the ones in flight.
+// When ctx is done first, the remaining attestations are cancelled and an error reporting them is returned
+// once they have returned.
+func (d *Drain) Close(ctx context.Context) error {
+	d.mtx.Lock()
+	d.closing = true
+	d.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.mtx.Lock()
+	n := len(d.cancels)
+	for _, cancel := range d.cancels {
+		cancel()
+	}
+	d.mtx.Unlock()
+	<-done
+
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("cancelled %v attestations in flight: %v", n, ctx.Err())
+}
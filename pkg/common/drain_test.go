@@ -0,0 +1,71 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	var d Drain
+
+	ctx, done, ok := d.Enter(context.Background())
+	if !ok {
+		t.Fatal("attestation must be admitted before Close")
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- d.Close(context.Background())
+	}()
+
+	for !d.Closing() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, _, ok := d.Enter(context.Background()); ok {
+		t.Error("attestation must not be admitted after Close")
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("Close must wait for the attestation in flight, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	done()
+	done()
+	if err := <-closed; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("context must be cancelled once the attestation is done")
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	var d Drain
+
+	ctx, done, ok := d.Enter(context.Background())
+	if !ok {
+		t.Fatal("attestation must be admitted before Close")
+	}
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+
+	deadline, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Close(deadline); err == nil {
+		t.Error("expected an error for the cancelled attestation")
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("unexpected context error: got %v, want %v", ctx.Err(), context.Canceled)
+	}
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// encryptedMagic is the header of encrypted attestation data, which can't start a JSON object, an instance ID or
+// gzip compressed data
+var encryptedMagic = []byte{0x00, 'E', 'P', 0x01}
+
+const (
+	// payloadKeySize is the size of the AES-256 key encrypting the attestation data
+	payloadKeySize = 32
+	// maxPayloadKeyIDLength bounds the key ID, whose length is encoded in a byte
+	maxPayloadKeyIDLength = 255
+)
+
+// ErrUnknownPayloadKey is returned if encrypted attestation data is encrypted to a key unknown to the server
+var ErrUnknownPayloadKey = errors.New("attestation data is encrypted to an unknown key")
+
+// IsEncryptedPayload returns true if the attestation data is encrypted
+func IsEncryptedPayload(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// EncryptPayload encrypts the attestation data to the RSA public key of the server, so that intermediaries between
+// the agent and the server don't see the instance ID and the metadata. The data is encrypted with a random AES-256-GCM
+// key, which is encrypted to the public key with RSA-OAEP and SHA-256. The key ID tells the server which private key
+// to decrypt with.
+//
+// The encrypted form is the header, the length of the key ID in a byte, the key ID, the length of the encrypted key
+// in two bytes in big endian, the encrypted key, the nonce and the sealed data, authenticated along with the header
+// and the key ID.
+func EncryptPayload(data []byte, keyID string, pub *rsa.PublicKey) ([]byte, error) {
+	if keyID == "" || len(keyID) > maxPayloadKeyIDLength {
+		return nil, fmt.Errorf("key ID must be of 1 to %d bytes", maxPayloadKeyIDLength)
+	}
+	key := make([]byte, payloadKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the payload key: %v", err)
+	}
+	gcm, err := newPayloadGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encryptedMagic)
+	buf.WriteByte(byte(len(keyID)))
+	buf.WriteString(keyID)
+	aad := append([]byte(nil), buf.Bytes()...)
+	binary.Write(&buf, binary.BigEndian, uint16(len(wrapped)))
+	buf.Write(wrapped)
+	buf.Write(nonce)
+	buf.Write(gcm.Seal(nil, nonce, data, aad))
+	return buf.Bytes(), nil
+}
+
+// EncryptionOverhead returns the number of bytes EncryptPayload adds to the attestation data encrypted to the key
+func EncryptionOverhead(keyID string, pub *rsa.PublicKey) int {
+	// the header, the key ID, the encrypted key, and the nonce and the tag of AES-GCM
+	return len(encryptedMagic) + 1 + len(keyID) + 2 + pub.Size() + 12 + 16
+}
+
+// PayloadKeyID returns the ID of the key the attestation data is encrypted to
+func PayloadKeyID(data []byte) (string, error) {
+	keyID, _, err := splitEncryptedPayload(data)
+	return keyID, err
+}
+
+// DecryptPayload decrypts encrypted attestation data with the private key of its key ID, which key returns, or nil if
+// the key is unknown.
+func DecryptPayload(data []byte, key func(keyID string) *rsa.PrivateKey) ([]byte, error) {
+	keyID, rest, err := splitEncryptedPayload(data)
+	if err != nil {
+		return nil, err
+	}
+	priv := key(keyID)
+	if priv == nil {
+		return nil, ErrUnknownPayloadKey
+	}
+	aad := data[:len(encryptedMagic)+1+len(keyID)]
+
+	if len(rest) < 2 {
+		return nil, errors.New("malformed encrypted attestation data: truncated key")
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return nil, errors.New("malformed encrypted attestation data: truncated key")
+	}
+	payloadKey, err := rsa.DecryptOAEP(sha256.New(), nil, priv, rest[:n], encryptedMagic)
+	if err != nil || len(payloadKey) != payloadKeySize {
+		return nil, errors.New("failed to decrypt the payload key")
+	}
+	rest = rest[n:]
+
+	gcm, err := newPayloadGCM(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted attestation data: truncated nonce")
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt attestation data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// splitEncryptedPayload returns the key ID of encrypted attestation data and the data following it
+func splitEncryptedPayload(data []byte) (string, []byte, error) {
+	if !IsEncryptedPayload(data) {
+		return "", nil, errors.New("attestation data is not encrypted")
+	}
+	rest := data[len(encryptedMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return "", nil, errors.New("malformed encrypted attestation data: truncated key ID")
+	}
+	n := int(rest[0])
+	return string(rest[1 : 1+n]), rest[1+n:], nil
+}
+
+func newPayloadGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
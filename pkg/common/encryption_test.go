@@ -0,0 +1,96 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestEncryptPayload(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"instance_id": "2d1d1c6e-7f4e-4a6b-9a56-0f2a4c3e8b1d"}`)
+
+	encrypted, err := EncryptPayload(data, "2020-01", &priv.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error from EncryptPayload(): %v", err)
+	}
+	if !IsEncryptedPayload(encrypted) || bytes.Contains(encrypted, []byte("instance_id")) {
+		t.Fatalf("payload is not encrypted: %q", encrypted)
+	}
+	if _, err := ParseAttestationPayload(encrypted); err == nil {
+		t.Error("expected error parsing encrypted attestation data, got nil")
+	}
+	if got, want := len(encrypted)-len(data), EncryptionOverhead("2020-01", &priv.PublicKey); got != want {
+		t.Errorf("encryption added %v bytes, but overhead is %v bytes", got, want)
+	}
+	if keyID, err := PayloadKeyID(encrypted); err != nil || keyID != "2020-01" {
+		t.Errorf("unexpected key ID: %q, %v", keyID, err)
+	}
+
+	keys := func(keyID string) *rsa.PrivateKey {
+		switch keyID {
+		case "2020-01":
+			return priv
+		case "other":
+			return other
+		}
+		return nil
+	}
+	decrypted, err := DecryptPayload(encrypted, keys)
+	if err != nil {
+		t.Fatalf("unexpected error from DecryptPayload(): %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("got %q, want %q", decrypted, data)
+	}
+
+	if _, err := DecryptPayload(encrypted, func(string) *rsa.PrivateKey { return nil }); err != ErrUnknownPayloadKey {
+		t.Errorf("unexpected error for an unknown key: got %v, want %v", err, ErrUnknownPayloadKey)
+	}
+
+	// The key ID is authenticated
+	relabeled := append([]byte(nil), encrypted...)
+	copy(relabeled[len(encryptedMagic)+1:], "2020-02")
+	if _, err := DecryptPayload(relabeled, func(string) *rsa.PrivateKey { return priv }); err == nil {
+		t.Error("expected error for a relabeled key ID, got nil")
+	}
+
+	// Another key can't decrypt
+	encryptedToOther, err := EncryptPayload(data, "other", &priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptPayload(encryptedToOther, keys); err == nil {
+		t.Error("expected error for data encrypted to another key, got nil")
+	}
+
+	for i := 0; i < len(encrypted); i += 37 {
+		if _, err := DecryptPayload(encrypted[:i], keys); err == nil {
+			t.Errorf("expected error for data truncated to %v bytes, got nil", i)
+		}
+	}
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := DecryptPayload(tampered, keys); err == nil {
+		t.Error("expected error for tampered data, got nil")
+	}
+
+	if _, err := EncryptPayload(data, "", &priv.PublicKey); err == nil {
+		t.Error("expected error for an empty key ID, got nil")
+	}
+}
@@ -0,0 +1,58 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is returned to the callers sharing a call which panicked
+var errFlightPanicked = errors.New("shared call panicked")
+
+// Flight collapses concurrent calls of a function into one, whose result the callers share, like
+// golang.org/x/sync/singleflight with a single key. The zero value is ready to use.
+type Flight struct {
+	mtx  sync.Mutex
+	call *flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// Do calls fn and returns its result, unless a call is in flight, whose result is returned once it completes instead.
+// fn is called in the goroutine of the caller. Callers waiting for the call of another stop waiting when ctx is done.
+// shared is true if the result is of the call of another caller.
+func (f *Flight) Do(ctx context.Context, fn func() (interface{}, error)) (v interface{}, shared bool, err error) {
+	f.mtx.Lock()
+	if c := f.call; c != nil {
+		f.mtx.Unlock()
+		select {
+		case <-c.done:
+			return c.val, true, c.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	c := &flightCall{done: make(chan struct{}), err: errFlightPanicked}
+	f.call = c
+	f.mtx.Unlock()
+
+	defer func() {
+		f.mtx.Lock()
+		f.call = nil
+		f.mtx.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	return c.val, false, c.err
+}
@@ -0,0 +1,90 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlight(t *testing.T) {
+	var f Flight
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "alpha", nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	var shared int32
+	results := make(chan interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, s, err := f.Do(context.Background(), fn)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if s {
+				atomic.AddInt32(&shared, 1)
+			}
+			results <- v
+		}()
+	}
+	// let the callers join the call in flight
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Errorf("got %v calls, want 1", calls)
+	}
+	if shared != callers-1 {
+		t.Errorf("got %v shared results, want %v", shared, callers-1)
+	}
+	for v := range results {
+		if v != "alpha" {
+			t.Errorf("got %v, want alpha", v)
+		}
+	}
+
+	// calls after the flight call fn again
+	if _, s, _ := f.Do(context.Background(), func() (interface{}, error) { return nil, nil }); s {
+		t.Error("result of a completed call must not be shared")
+	}
+}
+
+func TestFlightWaiterDeadline(t *testing.T) {
+	var f Flight
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go f.Do(context.Background(), func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.Do(ctx, func() (interface{}, error) { return nil, nil }); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
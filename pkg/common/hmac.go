@@ -0,0 +1,205 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// hmacContext separates the MACs of attestation payloads from other uses of the same secret
+	hmacContext = "spire-openstack-payload-hmac-v1"
+	// challengeHMACContext separates the MACs answering HMAC challenges from the MACs of payloads
+	challengeHMACContext = "spire-openstack-challenge-hmac-v1"
+	// projectHMACContext separates the secrets of projects derived from a master secret from the MACs
+	projectHMACContext = "spire-openstack-project-hmac-v1"
+
+	// hmacNonceSize is the size of the random bytes of the nonces NewHMACNonce returns
+	hmacNonceSize = 16
+	// maxHMACNonceLength bounds the nonces the server keeps
+	maxHMACNonceLength = 64
+)
+
+// ErrUnknownHMACKey is returned by VerifyHMAC if no secret is known for the key ID of the MAC
+var ErrUnknownHMACKey = errors.New("unknown hmac key")
+
+// PayloadHMAC authenticates the attestation payload with a secret shared by the instance and the server
+type PayloadHMAC struct {
+	// KeyID identifies the secret, so that secrets can be rotated
+	KeyID string `json:"key_id"`
+	// IssuedAt is the Unix time the MAC was computed at, which bounds the replay of the payload
+	IssuedAt int64 `json:"issued_at"`
+	// Nonce is a random value chosen by the agent, which the server accepts only once
+	Nonce string `json:"nonce,omitempty"`
+	// MAC is the base64 encoded HMAC-SHA256 of the payload
+	MAC string `json:"mac"`
+}
+
+// Validate validates the form of the MAC, without verifying it
+func (h *PayloadHMAC) Validate() error {
+	if h.KeyID == "" {
+		return errors.New("hmac lacks key_id")
+	}
+	if strings.ContainsAny(h.KeyID, "\r\n") {
+		return fmt.Errorf("invalid hmac key_id: %q", h.KeyID)
+	}
+	if h.IssuedAt <= 0 {
+		return errors.New("hmac lacks issued_at")
+	}
+	if len(h.Nonce) > maxHMACNonceLength || strings.ContainsAny(h.Nonce, "\r\n") {
+		return fmt.Errorf("invalid hmac nonce: %q", h.Nonce)
+	}
+	mac, err := base64.StdEncoding.DecodeString(h.MAC)
+	if err != nil {
+		return fmt.Errorf("malformed hmac: %v", err)
+	}
+	if len(mac) != sha256.Size {
+		return fmt.Errorf("hmac is %d bytes, expected %d bytes", len(mac), sha256.Size)
+	}
+	return nil
+}
+
+// ParseHMACSecret parses a secret in the form "<key ID>:<base64 encoded secret>", as provisioned to instances
+func ParseHMACSecret(s string) (string, []byte, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", nil, errors.New("hmac secret must be in the form \"<key ID>:<base64 encoded secret>\"")
+	}
+	secret, err := DecodeHMACSecret(s[i+1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return s[:i], secret, nil
+}
+
+// DecodeHMACSecret decodes a base64 encoded secret, which must be at least 16 bytes
+func DecodeHMACSecret(s string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("malformed hmac secret: %v", err)
+	}
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("hmac secret is %d bytes, at least 16 bytes required", len(secret))
+	}
+	return secret, nil
+}
+
+// DeriveProjectHMACSecret derives the secret of the project from the master secret, so that the vendordata signer can
+// provision each project its own secret and the server can verify it without configuring every project
+func DeriveProjectHMACSecret(master []byte, projectID string) []byte {
+	m := hmac.New(sha256.New, master)
+	fmt.Fprintf(m, "%s\n%s", projectHMACContext, projectID)
+	return m.Sum(nil)
+}
+
+// FormatHMACSecret formats the secret of the key ID in the form ParseHMACSecret parses
+func FormatHMACSecret(keyID string, secret []byte) string {
+	return keyID + ":" + base64.StdEncoding.EncodeToString(secret)
+}
+
+// NewHMACNonce returns a random nonce for SignHMAC
+func NewHMACNonce() (string, error) {
+	b := make([]byte, hmacNonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SignHMAC authenticates the payload with the secret of the key ID at given time. The nonce, if not empty, makes the
+// server accept the payload only once.
+func (p *AttestationPayload) SignHMAC(keyID string, secret []byte, nonce string, now time.Time) {
+	h := &PayloadHMAC{KeyID: keyID, IssuedAt: now.Unix(), Nonce: nonce}
+	h.MAC = base64.StdEncoding.EncodeToString(p.computeHMAC(h, secret))
+	p.HMAC = h
+}
+
+// VerifyHMAC verifies the MAC of the payload with the secret of its key ID, which secrets returns, or nil if it is
+// unknown. MACs issued more than maxAge before or after now are rejected.
+func (p *AttestationPayload) VerifyHMAC(secrets func(keyID string) []byte, maxAge time.Duration, now time.Time) error {
+	h := p.HMAC
+	if h == nil {
+		return errors.New("payload has no hmac")
+	}
+	secret := secrets(h.KeyID)
+	if secret == nil {
+		return ErrUnknownHMACKey
+	}
+	mac, err := base64.StdEncoding.DecodeString(h.MAC)
+	if err != nil {
+		return fmt.Errorf("malformed hmac: %v", err)
+	}
+	if !hmac.Equal(mac, p.computeHMAC(h, secret)) {
+		return errors.New("hmac mismatch")
+	}
+	// The MAC is verified first, so that the age is of an authentic time
+	if age := now.Sub(time.Unix(h.IssuedAt, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("hmac issued at %v is out of the window of %v", time.Unix(h.IssuedAt, 0).UTC(), maxAge)
+	}
+	return nil
+}
+
+// computeHMAC computes the MAC of the fields of the payload the server relies on, along with the key ID and the time
+func (p *AttestationPayload) computeHMAC(h *PayloadHMAC, secret []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	// Fields are separated by newlines, which are invalid in the IDs
+	fmt.Fprintf(m, "%s\n%s\n%d\n%s\n%s", hmacContext, h.KeyID, h.IssuedAt, p.InstanceID, p.ProjectID)
+	// The logical node is only covered if set, so that MACs of payloads without it are unchanged
+	if p.LogicalNode != "" {
+		fmt.Fprintf(m, "\n%s", p.LogicalNode)
+	}
+	// So are the bundle fingerprint and the nonce, which are labeled since logical nodes can't contain "="
+	if p.BundleFingerprint != "" {
+		fmt.Fprintf(m, "\nbundle_fingerprint=%s", p.BundleFingerprint)
+	}
+	if h.Nonce != "" {
+		fmt.Fprintf(m, "\nnonce=%s", h.Nonce)
+	}
+	return m.Sum(nil)
+}
+
+// SignChallenge answers an HMAC challenge of nonce with the secret of the key ID
+func (r *ChallengeResponse) SignChallenge(keyID string, secret []byte, nonce string) {
+	r.KeyID = keyID
+	r.MAC = base64.StdEncoding.EncodeToString(computeChallengeHMAC(keyID, secret, nonce))
+}
+
+// VerifyChallenge verifies the MAC answering an HMAC challenge of nonce with the secret of its key ID, which secrets
+// returns, or nil if it is unknown
+func (r *ChallengeResponse) VerifyChallenge(secrets func(keyID string) []byte, nonce string) error {
+	if r.KeyID == "" || r.MAC == "" {
+		return errors.New("challenge response lacks key_id or mac")
+	}
+	secret := secrets(r.KeyID)
+	if secret == nil {
+		return ErrUnknownHMACKey
+	}
+	mac, err := base64.StdEncoding.DecodeString(r.MAC)
+	if err != nil {
+		return fmt.Errorf("malformed hmac: %v", err)
+	}
+	if !hmac.Equal(mac, computeChallengeHMAC(r.KeyID, secret, nonce)) {
+		return errors.New("hmac mismatch")
+	}
+	return nil
+}
+
+// computeChallengeHMAC computes the MAC of the nonce along with the key ID. The nonce is bound to the instance by the
+// server plugin, so that the instance isn't part of the MAC.
+func computeChallengeHMAC(keyID string, secret []byte, nonce string) []byte {
+	m := hmac.New(sha256.New, secret)
+	fmt.Fprintf(m, "%s\n%s\n%s", challengeHMACContext, keyID, nonce)
+	return m.Sum(nil)
+}
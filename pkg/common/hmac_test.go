@@ -0,0 +1,208 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadHMAC(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	secrets := map[string][]byte{
+		"alpha": []byte("0123456789abcdef"),
+		"bravo": []byte("fedcba9876543210"),
+	}
+	lookup := func(keyID string) []byte {
+		return secrets[keyID]
+	}
+
+	tCase := []struct {
+		keyID       string
+		nonce       string
+		signedAt    time.Time
+		tamper      func(p *AttestationPayload)
+		wantErr     bool
+		wantUnknown bool
+	}{
+		// 0: authentic
+		{keyID: "alpha", signedAt: now},
+		// 1: the other key of a rotation
+		{keyID: "bravo", signedAt: now.Add(-time.Minute)},
+		// 2: tampered instance ID
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.InstanceID = "456" }, wantErr: true},
+		// 3: tampered project hint
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.ProjectID = "charlie" }, wantErr: true},
+		// 4: tampered time
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.HMAC.IssuedAt++ }, wantErr: true},
+		// 5: MAC of another key claimed as the key
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.HMAC.KeyID = "bravo" }, wantErr: true},
+		// 6: too old
+		{keyID: "alpha", signedAt: now.Add(-10 * time.Minute), wantErr: true},
+		// 7: too far in the future
+		{keyID: "alpha", signedAt: now.Add(10 * time.Minute), wantErr: true},
+		// 8: unknown key
+		{keyID: "charlie", signedAt: now, wantErr: true, wantUnknown: true},
+		// 9: logical node added to the payload
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.LogicalNode = "containerd" }, wantErr: true},
+		// 10: bundle fingerprint added to the payload
+		{keyID: "alpha", signedAt: now, tamper: func(p *AttestationPayload) { p.BundleFingerprint = strings.Repeat("0a", 32) }, wantErr: true},
+		// 11: with a nonce
+		{keyID: "alpha", nonce: "charlie", signedAt: now},
+		// 12: tampered nonce
+		{keyID: "alpha", nonce: "charlie", signedAt: now, tamper: func(p *AttestationPayload) { p.HMAC.Nonce = "delta" }, wantErr: true},
+		// 13: nonce removed
+		{keyID: "alpha", nonce: "charlie", signedAt: now, tamper: func(p *AttestationPayload) { p.HMAC.Nonce = "" }, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p := &AttestationPayload{InstanceID: "123", ProjectID: "bravo"}
+		secret := secrets[c.keyID]
+		if secret == nil {
+			secret = []byte("unknown secret!!")
+		}
+		p.SignHMAC(c.keyID, secret, c.nonce, c.signedAt)
+
+		// The MAC survives encoding
+		b, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("#%v: unexpected error: %v", i, err)
+		}
+		p, err = ParseAttestationPayload(b)
+		if err != nil {
+			t.Fatalf("#%v: unexpected error: %v", i, err)
+		}
+		if c.tamper != nil {
+			c.tamper(p)
+		}
+
+		err = p.VerifyHMAC(lookup, 5*time.Minute, now)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+		if (err == ErrUnknownHMACKey) != c.wantUnknown {
+			t.Errorf("#%v: got %v, unknown key expected: %v", i, err, c.wantUnknown)
+		}
+	}
+}
+
+func TestParseAttestationPayloadInvalidHMAC(t *testing.T) {
+	for i, data := range []string{
+		`{"instance_id": "123", "hmac": "mac"}`,
+		`{"instance_id": "123", "hmac": {"issued_at": 1, "mac": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`,
+		`{"instance_id": "123", "hmac": {"key_id": "alpha", "mac": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`,
+		`{"instance_id": "123", "hmac": {"key_id": "alpha", "issued_at": 1, "mac": "AAAA"}}`,
+		`{"instance_id": "123", "hmac": {"key_id": "alpha", "issued_at": 1, "mac": "not base64"}}`,
+	} {
+		if _, err := ParseAttestationPayload([]byte(data)); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
+
+func TestParseHMACSecret(t *testing.T) {
+	tCase := []struct {
+		secret    string
+		wantKeyID string
+		wantErr   bool
+	}{
+		// 0: valid
+		{secret: "alpha:MDEyMzQ1Njc4OWFiY2RlZg==", wantKeyID: "alpha"},
+		// 1: no key ID
+		{secret: "MDEyMzQ1Njc4OWFiY2RlZg==", wantErr: true},
+		// 2: too short
+		{secret: "alpha:MDEyMw==", wantErr: true},
+		// 3: not base64
+		{secret: "alpha:secret", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		keyID, secret, err := ParseHMACSecret(c.secret)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if keyID != c.wantKeyID || string(secret) != "0123456789abcdef" {
+			t.Errorf("#%v: got %v:%s, want %v:0123456789abcdef", i, keyID, secret, c.wantKeyID)
+		}
+	}
+}
+
+func TestChallengeHMAC(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	secrets := func(keyID string) []byte {
+		if keyID == "k1" {
+			return secret
+		}
+		return nil
+	}
+
+	tCase := []struct {
+		keyID   string
+		secret  []byte
+		nonce   string
+		wantErr bool
+	}{
+		// 0: valid
+		{keyID: "k1", secret: secret, nonce: "n0nce"},
+		// 1: another nonce
+		{keyID: "k1", secret: secret, nonce: "other", wantErr: true},
+		// 2: another secret
+		{keyID: "k1", secret: []byte("fedcba9876543210"), nonce: "n0nce", wantErr: true},
+		// 3: unknown key
+		{keyID: "k2", secret: secret, nonce: "n0nce", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		r := &ChallengeResponse{Type: ChallengeHMAC, Nonce: c.nonce}
+		r.SignChallenge(c.keyID, c.secret, c.nonce)
+		err := r.VerifyChallenge(secrets, "n0nce")
+		if c.wantErr != (err != nil) {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+
+	if err := (&ChallengeResponse{Type: ChallengeHMAC, Nonce: "n0nce"}).VerifyChallenge(secrets, "n0nce"); err == nil {
+		t.Error("an error expected for a response without mac, got nil")
+	}
+}
+
+func TestDeriveProjectHMACSecret(t *testing.T) {
+	master := []byte("0123456789abcdef")
+	alpha := DeriveProjectHMACSecret(master, "alpha")
+	if len(alpha) < 16 {
+		t.Fatalf("derived secret is %d bytes, at least 16 bytes required", len(alpha))
+	}
+	if string(alpha) != string(DeriveProjectHMACSecret(master, "alpha")) {
+		t.Error("derivation is not deterministic")
+	}
+	if string(alpha) == string(DeriveProjectHMACSecret(master, "bravo")) {
+		t.Error("projects share the derived secret")
+	}
+	if string(alpha) == string(DeriveProjectHMACSecret([]byte("fedcba9876543210"), "alpha")) {
+		t.Error("master secrets derive the same secret")
+	}
+
+	keyID, secret, err := ParseHMACSecret(FormatHMACSecret("k1", alpha))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyID != "k1" || string(secret) != string(alpha) {
+		t.Errorf("got %v:%x, want k1:%x", keyID, secret, alpha)
+	}
+}
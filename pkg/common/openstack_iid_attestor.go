@@ -8,14 +8,20 @@
 package common
 
 import (
+	"fmt"
 	"net/url"
 	"path"
+	"regexp"
 )
 
 const (
 	PluginName = "openstack_iid"
 )
 
+var (
+	regexpAgentIDPath = regexp.MustCompile(`^/spire/agent/openstack_iid/([^/]+)/([^/]+)(?:/([^/]+))?$`)
+)
+
 func GenerateSpiffeID(trustDomain, projectID, instanceID string) string {
 	spiffePath := path.Join("spire", "agent", PluginName, projectID, instanceID)
 	id := &url.URL{
@@ -25,3 +31,33 @@ func GenerateSpiffeID(trustDomain, projectID, instanceID string) string {
 	}
 	return id.String()
 }
+
+// GenerateLogicalNodeSpiffeID returns the agent ID of the logical node of the instance, one of the SPIRE agents
+// running on it. It is the agent ID of the instance if logicalNode is empty.
+func GenerateLogicalNodeSpiffeID(trustDomain, projectID, instanceID, logicalNode string) string {
+	if logicalNode == "" {
+		return GenerateSpiffeID(trustDomain, projectID, instanceID)
+	}
+	return GenerateSpiffeID(trustDomain, projectID, instanceID+"/"+logicalNode)
+}
+
+// ParseSpiffeID returns the project ID and instance ID of an agent ID generated by GenerateSpiffeID or
+// GenerateLogicalNodeSpiffeID
+func ParseSpiffeID(spiffeID string) (projectID, instanceID string, err error) {
+	projectID, instanceID, _, err = ParseLogicalNodeSpiffeID(spiffeID)
+	return projectID, instanceID, err
+}
+
+// ParseLogicalNodeSpiffeID returns the project ID, instance ID and logical node of an agent ID generated by
+// GenerateLogicalNodeSpiffeID. The logical node is empty for agent IDs of instances.
+func ParseLogicalNodeSpiffeID(spiffeID string) (projectID, instanceID, logicalNode string, err error) {
+	u, err := url.Parse(spiffeID)
+	if err != nil || u.Scheme != "spiffe" {
+		return "", "", "", fmt.Errorf("invalid spiffeID: %v", spiffeID)
+	}
+	m := regexpAgentIDPath.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", "", "", fmt.Errorf("invalid spiffeID format: %v", spiffeID)
+	}
+	return m[1], m[2], m[3], nil
+}
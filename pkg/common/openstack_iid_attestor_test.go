@@ -23,3 +23,57 @@ func TestGenerateSpiffeID(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestParseSpiffeID(t *testing.T) {
+	projectID, instanceID, err := ParseSpiffeID(GenerateSpiffeID("example.com", "alpha", "bravo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectID != "alpha" || instanceID != "bravo" {
+		t.Errorf("got %v/%v, want alpha/bravo", projectID, instanceID)
+	}
+
+	projectID, instanceID, logicalNode, err := ParseLogicalNodeSpiffeID(GenerateLogicalNodeSpiffeID("example.com", "alpha", "bravo", "containerd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectID != "alpha" || instanceID != "bravo" || logicalNode != "containerd" {
+		t.Errorf("got %v/%v/%v, want alpha/bravo/containerd", projectID, instanceID, logicalNode)
+	}
+	if id := GenerateLogicalNodeSpiffeID("example.com", "alpha", "bravo", ""); id != GenerateSpiffeID("example.com", "alpha", "bravo") {
+		t.Errorf("got %v for an empty logical node, want the agent ID of the instance", id)
+	}
+
+	for _, id := range []string{
+		"spiffe://example.com/spire/agent/x509pop/alpha",
+		"spiffe://example.com/spire/agent/openstack_iid/alpha/bravo/charlie/delta",
+		"spiffe://example.com/workload",
+		"https://example.com/spire/agent/openstack_iid/alpha/bravo",
+	} {
+		if _, _, err := ParseSpiffeID(id); err == nil {
+			t.Errorf("expected error for %v", id)
+		}
+	}
+}
+
+func TestNamespacedSelectorValue(t *testing.T) {
+	for i, c := range []struct {
+		namespace string
+		want      string
+	}{
+		{namespace: "", want: "sg:name:web"},
+		{namespace: SelectorNamespaceCloud, want: "east:sg:name:web"},
+		{namespace: SelectorNamespaceProject, want: "alpha:sg:name:web"},
+	} {
+		if got := NamespacedSelectorValue(c.namespace, "east", "alpha", "sg:name:web"); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+
+	if err := ValidateSelectorNamespace(SelectorNamespaceCloud, ""); err == nil {
+		t.Error("expected error for cloud namespace without cloud_name")
+	}
+	if err := ValidateSelectorNamespace("region", "east"); err == nil {
+		t.Error("expected error for unknown namespace")
+	}
+}
@@ -0,0 +1,377 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// Versions of the JSON payload. Payloads without a version are of PayloadVersion1.
+const (
+	PayloadVersion1 = 1
+	// PayloadVersion2 adds the metadata of the instance
+	PayloadVersion2 = 2
+)
+
+// AttestationPayload is the attestation data sent by the agent plugin.
+// Agents predating the payload send the bare instance ID instead of a JSON object.
+type AttestationPayload struct {
+	// Version is the version of the payload, zero for PayloadVersion1. Servers accept newer versions, whose
+	// additions are preserved as unknown fields.
+	Version int `json:"version,omitempty"`
+
+	InstanceID string `json:"instance_id"`
+	// ProjectID is the project of the instance in the metadata. It is a hint for the server to pick the scope
+	// of the Nova lookup, and is never trusted as the project of the instance.
+	ProjectID string `json:"project_id,omitempty"`
+	// SignedIdentity is the identity signed by the vendordata signing service, if the agent is configured to send it
+	SignedIdentity *vendordata.SignedIdentity `json:"signed_identity,omitempty"`
+	// HMAC authenticates the payload with a secret provisioned to the instance, if the agent is configured to send it
+	HMAC *PayloadHMAC `json:"hmac,omitempty"`
+	// Metadata is the metadata of the instance the agent retrieved, since PayloadVersion2
+	Metadata *PayloadMetadata `json:"metadata,omitempty"`
+	// LogicalNode names one of several SPIRE agents on the instance, e.g. one per container runtime, so that each
+	// gets its own agent ID. Servers admit it only if it matches their logical_node_pattern.
+	LogicalNode string `json:"logical_node,omitempty"`
+	// Versions are the payload versions the agent can send, asking servers supporting negotiation for a version
+	// challenge, see ChallengeVersion. Servers predating negotiation preserve them as an unknown field.
+	Versions []int `json:"versions,omitempty"`
+	// BundleFingerprint is the SHA-256 fingerprint of the trust bundle CA placed in the instance metadata by the
+	// provisioning pipeline, in the form of vendordata.CertificateFingerprint, if the agent is configured to send it
+	BundleFingerprint string `json:"bundle_fingerprint,omitempty"`
+
+	// Unknown holds the fields added by newer agents, preserved verbatim so that they survive re-encoding
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// PayloadMetadata is the part of the instance metadata sent in the payload, which the server can compare with Nova.
+// Other fields, e.g. the user metadata which may hold secrets, are never sent.
+type PayloadMetadata struct {
+	Name             string `json:"name,omitempty"`
+	Hostname         string `json:"hostname,omitempty"`
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+	// Source is the metadata source of the agent, e.g. "config_drive", whose copy is not updated after boot
+	Source string `json:"source,omitempty"`
+	// LaunchedAt is the time the instance booted at, in RFC 3339, which can't be earlier than its launched_at in Nova
+	// but for the clock skew
+	LaunchedAt string `json:"launched_at,omitempty"`
+	// FixedIPs and MACAddresses are of network_data.json of the metadata source, sent if the agent enables
+	// payload_network, in canonical form
+	FixedIPs     []string `json:"fixed_ips,omitempty"`
+	MACAddresses []string `json:"mac_addresses,omitempty"`
+}
+
+// Validate validates the launch time and the addresses of the metadata
+func (m *PayloadMetadata) Validate() error {
+	if m.LaunchedAt != "" {
+		if _, err := time.Parse(time.RFC3339, m.LaunchedAt); err != nil {
+			return fmt.Errorf("invalid launched_at in metadata: %q", m.LaunchedAt)
+		}
+	}
+	for _, ip := range m.FixedIPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid fixed IP in metadata: %q", ip)
+		}
+	}
+	for _, mac := range m.MACAddresses {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("invalid MAC address in metadata: %q", mac)
+		}
+	}
+	return nil
+}
+
+// MaxAttestationPayloadSize is the maximum size of the attestation data accepted by the server plugin
+const MaxAttestationPayloadSize = 4096
+
+// MaxDecompressedPayloadSize is the maximum size of compressed attestation data once decompressed,
+// which bounds the memory a decompression bomb can take
+const MaxDecompressedPayloadSize = 64 * 1024
+
+// gzipMagic is the header of gzip compressed attestation data, which can't start a JSON object or an instance ID
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// knownPayloadFields are validated strictly and must not be preserved as unknown fields
+var knownPayloadFields = map[string]bool{
+	"instance_id":        true,
+	"project_id":         true,
+	"signed_identity":    true,
+	"hmac":               true,
+	"version":            true,
+	"metadata":           true,
+	"logical_node":       true,
+	"versions":           true,
+	"bundle_fingerprint": true,
+}
+
+// regexpLogicalNode is the syntax of logical nodes, which are a segment of the agent ID
+var regexpLogicalNode = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// ParseAttestationPayload parses the attestation data in either the JSON or the bare instance ID form.
+// The JSON form may be compressed with gzip. Encrypted data must be decrypted first.
+// Known fields are validated strictly, while unknown fields are tolerated and preserved.
+func ParseAttestationPayload(data []byte) (*AttestationPayload, error) {
+	if len(data) > MaxAttestationPayloadSize {
+		return nil, fmt.Errorf("attestation data exceeds %d bytes: %d bytes", MaxAttestationPayloadSize, len(data))
+	}
+	if IsEncryptedPayload(data) {
+		return nil, errors.New("attestation data is encrypted, and must be decrypted with DecryptPayload first")
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		decompressed, err := decompressPayload(data)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(decompressed), []byte("{")) {
+			return nil, errors.New("compressed attestation data must be a JSON payload")
+		}
+		data = decompressed
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("attestation data is empty")
+	}
+	if trimmed[0] != '{' {
+		p := &AttestationPayload{InstanceID: string(data)}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return nil, fmt.Errorf("malformed attestation payload: %v", err)
+	}
+
+	p := &AttestationPayload{}
+	raw, ok := fields["instance_id"]
+	if !ok {
+		return nil, errors.New("attestation payload lacks instance_id")
+	}
+	if err := json.Unmarshal(raw, &p.InstanceID); err != nil {
+		return nil, fmt.Errorf("invalid instance_id: %v", err)
+	}
+	if raw, ok := fields["project_id"]; ok {
+		if err := json.Unmarshal(raw, &p.ProjectID); err != nil {
+			return nil, fmt.Errorf("invalid project_id: %v", err)
+		}
+	}
+	if raw, ok := fields["signed_identity"]; ok {
+		p.SignedIdentity = &vendordata.SignedIdentity{}
+		if err := json.Unmarshal(raw, p.SignedIdentity); err != nil {
+			return nil, fmt.Errorf("invalid signed_identity: %v", err)
+		}
+	}
+	if raw, ok := fields["hmac"]; ok {
+		p.HMAC = &PayloadHMAC{}
+		if err := json.Unmarshal(raw, p.HMAC); err != nil {
+			return nil, fmt.Errorf("invalid hmac: %v", err)
+		}
+	}
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &p.Version); err != nil {
+			return nil, fmt.Errorf("invalid version: %v", err)
+		}
+	}
+	if raw, ok := fields["metadata"]; ok {
+		p.Metadata = &PayloadMetadata{}
+		if err := json.Unmarshal(raw, p.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata: %v", err)
+		}
+	}
+	if raw, ok := fields["logical_node"]; ok {
+		if err := json.Unmarshal(raw, &p.LogicalNode); err != nil {
+			return nil, fmt.Errorf("invalid logical_node: %v", err)
+		}
+	}
+	if raw, ok := fields["versions"]; ok {
+		if err := json.Unmarshal(raw, &p.Versions); err != nil {
+			return nil, fmt.Errorf("invalid versions: %v", err)
+		}
+	}
+	if raw, ok := fields["bundle_fingerprint"]; ok {
+		if err := json.Unmarshal(raw, &p.BundleFingerprint); err != nil {
+			return nil, fmt.Errorf("invalid bundle_fingerprint: %v", err)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	for k, v := range fields {
+		if knownPayloadFields[k] {
+			continue
+		}
+		if p.Unknown == nil {
+			p.Unknown = make(map[string]json.RawMessage)
+		}
+		p.Unknown[k] = v
+	}
+	return p, nil
+}
+
+// Validate validates the known fields of the payload
+func (p *AttestationPayload) Validate() error {
+	if p.Version < 0 {
+		return fmt.Errorf("invalid version: %v", p.Version)
+	}
+	if p.InstanceID == "" {
+		return errors.New("instance ID is empty")
+	}
+	if strings.ContainsAny(p.InstanceID, " \t\r\n/") {
+		return fmt.Errorf("invalid instance ID: %q", p.InstanceID)
+	}
+	if strings.ContainsAny(p.ProjectID, " \t\r\n/") {
+		return fmt.Errorf("invalid project ID: %q", p.ProjectID)
+	}
+	if p.SignedIdentity != nil {
+		if err := p.SignedIdentity.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.HMAC != nil {
+		if err := p.HMAC.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Metadata != nil {
+		if p.Version < PayloadVersion2 {
+			return fmt.Errorf("metadata requires version %v", PayloadVersion2)
+		}
+		if err := p.Metadata.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.LogicalNode != "" {
+		if err := ValidateLogicalNode(p.LogicalNode); err != nil {
+			return err
+		}
+	}
+	for _, v := range p.Versions {
+		if v <= 0 {
+			return fmt.Errorf("invalid version in versions: %v", v)
+		}
+	}
+	if p.BundleFingerprint != "" {
+		if f, err := vendordata.NormalizeFingerprint(p.BundleFingerprint); err != nil || f != p.BundleFingerprint {
+			return fmt.Errorf("invalid bundle fingerprint: %q", p.BundleFingerprint)
+		}
+	}
+	return nil
+}
+
+// ValidateLogicalNode validates the name of a logical node, which must be 1 to 63 ASCII letters, digits, ".", "_"
+// or "-", starting with a letter or digit
+func ValidateLogicalNode(name string) error {
+	if !regexpLogicalNode.MatchString(name) {
+		return fmt.Errorf("invalid logical node: %q", name)
+	}
+	return nil
+}
+
+// PayloadVersion returns the version of the payload, PayloadVersion1 for payloads without a version
+func (p *AttestationPayload) PayloadVersion() int {
+	if p.Version == 0 {
+		return PayloadVersion1
+	}
+	return p.Version
+}
+
+// Marshal encodes the payload in the JSON form, including the preserved unknown fields
+func (p *AttestationPayload) Marshal() ([]byte, error) {
+	fields := make(map[string]interface{})
+	for k, v := range p.Unknown {
+		fields[k] = v
+	}
+	fields["instance_id"] = p.InstanceID
+	if p.ProjectID != "" {
+		fields["project_id"] = p.ProjectID
+	}
+	if p.SignedIdentity != nil {
+		fields["signed_identity"] = p.SignedIdentity
+	}
+	if p.HMAC != nil {
+		fields["hmac"] = p.HMAC
+	}
+	if p.Version != 0 {
+		fields["version"] = p.Version
+	}
+	if p.Metadata != nil {
+		fields["metadata"] = p.Metadata
+	}
+	if p.LogicalNode != "" {
+		fields["logical_node"] = p.LogicalNode
+	}
+	if len(p.Versions) > 0 {
+		fields["versions"] = p.Versions
+	}
+	if p.BundleFingerprint != "" {
+		fields["bundle_fingerprint"] = p.BundleFingerprint
+	}
+	return json.Marshal(fields)
+}
+
+// UnknownFields returns the sorted names of the unknown fields
+func (p *AttestationPayload) UnknownFields() []string {
+	var names []string
+	for k := range p.Unknown {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompressPayload compresses the encoded JSON payload with gzip
+func CompressPayload(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload decompresses gzip compressed attestation data, failing once it exceeds MaxDecompressedPayloadSize
+// instead of decompressing it entirely
+func decompressPayload(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("malformed compressed attestation data: %v", err)
+	}
+	defer r.Close()
+	r.Multistream(false)
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressedPayloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("malformed compressed attestation data: %v", err)
+	}
+	if len(b) > MaxDecompressedPayloadSize {
+		return nil, fmt.Errorf("decompressed attestation data exceeds %d bytes", MaxDecompressedPayloadSize)
+	}
+	return b, nil
+}
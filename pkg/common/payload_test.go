@@ -0,0 +1,163 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAttestationPayload(t *testing.T) {
+	tCase := []struct {
+		data       string
+		instanceID string
+		unknown    []string
+		wantErr    bool
+	}{
+		// 0: bare instance ID from older agents
+		{data: "1b2c3d", instanceID: "1b2c3d"},
+		// 1: JSON payload
+		{data: `{"instance_id": "1b2c3d"}`, instanceID: "1b2c3d"},
+		// 2: unknown fields from newer agents are tolerated
+		{data: `{"instance_id": "1b2c3d", "nonce": "abc", "extra": {"a": 1}}`, instanceID: "1b2c3d", unknown: []string{"extra", "nonce"}},
+		// 3: known fields are validated strictly
+		{data: `{"instance_id": 123}`, wantErr: true},
+		// 4: instance_id is required
+		{data: `{"nonce": "abc"}`, wantErr: true},
+		// 5: malformed JSON
+		{data: `{"instance_id": "1b2c3d"`, wantErr: true},
+		// 6: empty data
+		{data: "", wantErr: true},
+		// 7: instance ID must not contain path separators
+		{data: "../1b2c3d", wantErr: true},
+		// 8: oversized payload
+		{data: `{"instance_id": "1b2c3d", "padding": "` + strings.Repeat("a", MaxAttestationPayloadSize) + `"}`, wantErr: true},
+		// 9: signed identity
+		{data: `{"instance_id": "1b2c3d", "signed_identity": {"kid": "k1", "alg": "EdDSA", "document": "e30", "signature": "c2ln"}}`, instanceID: "1b2c3d"},
+		// 10: signed identity lacking the key ID
+		{data: `{"instance_id": "1b2c3d", "signed_identity": {"alg": "EdDSA", "document": "e30", "signature": "c2ln"}}`, wantErr: true},
+		// 11: project hint
+		{data: `{"instance_id": "1b2c3d", "project_id": "a1b2"}`, instanceID: "1b2c3d"},
+		// 12: malformed project hint
+		{data: `{"instance_id": "1b2c3d", "project_id": "a1/b2"}`, wantErr: true},
+		// 13: version 2 with metadata
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"name": "web", "availability_zone": "nova", "source": "metadata_service"}}`, instanceID: "1b2c3d"},
+		// 14: newer versions are accepted
+		{data: `{"version": 3, "instance_id": "1b2c3d", "launched_at": "2019-01-01T00:00:00Z"}`, instanceID: "1b2c3d", unknown: []string{"launched_at"}},
+		// 15: metadata without the version
+		{data: `{"instance_id": "1b2c3d", "metadata": {"name": "web"}}`, wantErr: true},
+		// 16: malformed version
+		{data: `{"version": "2", "instance_id": "1b2c3d"}`, wantErr: true},
+		// 17: malformed metadata
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": "web"}`, wantErr: true},
+		// 18: logical node
+		{data: `{"instance_id": "1b2c3d", "logical_node": "containerd"}`, instanceID: "1b2c3d"},
+		// 19: logical node must not contain path separators
+		{data: `{"instance_id": "1b2c3d", "logical_node": "../containerd"}`, wantErr: true},
+		// 20: malformed logical node
+		{data: `{"instance_id": "1b2c3d", "logical_node": ["containerd"]}`, wantErr: true},
+		// 21: metadata with addresses
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"fixed_ips": ["192.0.2.10", "2001:db8::10"], "mac_addresses": ["fa:16:3e:00:00:01"]}}`, instanceID: "1b2c3d"},
+		// 22: malformed fixed IP
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"fixed_ips": ["192.0.2"]}}`, wantErr: true},
+		// 23: malformed MAC address
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"mac_addresses": ["fa:16:3e"]}}`, wantErr: true},
+		// 24: versions to negotiate
+		{data: `{"version": 2, "instance_id": "1b2c3d", "versions": [1, 2]}`, instanceID: "1b2c3d"},
+		// 25: invalid version to negotiate
+		{data: `{"instance_id": "1b2c3d", "versions": [0]}`, wantErr: true},
+		// 26: bundle fingerprint
+		{data: `{"instance_id": "1b2c3d", "bundle_fingerprint": "` + strings.Repeat("0a", 32) + `"}`, instanceID: "1b2c3d"},
+		// 27: bundle fingerprint not in the normalized form
+		{data: `{"instance_id": "1b2c3d", "bundle_fingerprint": "` + strings.Repeat("0A:", 31) + `0A"}`, wantErr: true},
+		// 28: SHA-1 bundle fingerprint
+		{data: `{"instance_id": "1b2c3d", "bundle_fingerprint": "` + strings.Repeat("0a", 20) + `"}`, wantErr: true},
+		// 29: metadata with the launch time
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"launched_at": "2020-01-01T00:00:00Z"}}`, instanceID: "1b2c3d"},
+		// 30: malformed launch time
+		{data: `{"version": 2, "instance_id": "1b2c3d", "metadata": {"launched_at": "2020-01-01 00:00:00"}}`, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p, err := ParseAttestationPayload([]byte(c.data))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if p.InstanceID != c.instanceID {
+			t.Errorf("#%v: got %v, want %v", i, p.InstanceID, c.instanceID)
+		}
+		if !reflect.DeepEqual(p.UnknownFields(), c.unknown) {
+			t.Errorf("#%v: got unknown fields %v, want %v", i, p.UnknownFields(), c.unknown)
+		}
+	}
+}
+
+func TestAttestationPayloadMarshal(t *testing.T) {
+	p, err := ParseAttestationPayload([]byte(`{"instance_id": "1b2c3d", "extra": {"a": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"extra":{"a":1},"instance_id":"1b2c3d"}` {
+		t.Errorf("unexpected payload: %s", b)
+	}
+}
+
+func TestParseCompressedAttestationPayload(t *testing.T) {
+	compress := func(s string) string {
+		b, err := CompressPayload([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tCase := []struct {
+		data    string
+		wantErr bool
+	}{
+		// 0: compressed JSON payload
+		{data: compress(`{"instance_id": "1b2c3d", "extra": "` + strings.Repeat("a", 2*MaxAttestationPayloadSize) + `"}`)},
+		// 1: decompression bomb
+		{data: compress(`{"instance_id": "1b2c3d", "extra": "` + strings.Repeat("a", MaxDecompressedPayloadSize) + `"}`), wantErr: true},
+		// 2: only the JSON form may be compressed
+		{data: compress("1b2c3d"), wantErr: true},
+		// 3: nested compression
+		{data: compress(compress(`{"instance_id": "1b2c3d"}`)), wantErr: true},
+		// 4: truncated
+		{data: compress(`{"instance_id": "1b2c3d"}`)[:10], wantErr: true},
+	}
+
+	for i, c := range tCase {
+		p, err := ParseAttestationPayload([]byte(c.data))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if p.InstanceID != "1b2c3d" {
+			t.Errorf("#%v: got %v, want %v", i, p.InstanceID, "1b2c3d")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// SanitizationPrintable allows printable characters other than the selector separator ":"
+	SanitizationPrintable = "printable"
+	// SanitizationStrict allows ASCII letters, digits, ".", "_" and "-"
+	SanitizationStrict = "strict"
+
+	defaultSanitizationMaxLength = 255
+)
+
+// SelectorSanitization configures the sanitization of selector values derived from fields users of the cloud
+// control, e.g. server names, security group names and instance metadata. Values violating it are dropped rather
+// than rewritten, so that crafted fields can't be made to collide with the values of other fields.
+type SelectorSanitization struct {
+	// Characters allowed in the fields, "printable" or "strict". Defaults to "printable".
+	Strictness string `hcl:"strictness" default:"printable"`
+	// Maximum length of each field in bytes. Defaults to 255.
+	MaxLength int `hcl:"max_length" default:"255"`
+	// If true, the fields are lower-cased before the check, so that selectors don't depend on the case
+	Lowercase bool `hcl:"lowercase"`
+}
+
+// Validate validates and normalizes the sanitization options. A nil sanitization is valid, and disables it.
+func (s *SelectorSanitization) Validate() error {
+	if s == nil {
+		return nil
+	}
+	switch s.Strictness {
+	case "":
+		s.Strictness = SanitizationPrintable
+	case SanitizationPrintable, SanitizationStrict:
+	default:
+		return fmt.Errorf("unknown strictness of selector_sanitization: %q", s.Strictness)
+	}
+	switch {
+	case s.MaxLength < 0:
+		return errors.New("max_length of selector_sanitization must not be negative")
+	case s.MaxLength == 0:
+		s.MaxLength = defaultSanitizationMaxLength
+	}
+	return nil
+}
+
+// Sanitize returns the field normalized for a selector value, or false if it violates the sanitization and the
+// selector must be dropped. Fields are returned as is if the sanitization is nil.
+func (s *SelectorSanitization) Sanitize(field string) (string, bool) {
+	if s == nil {
+		return field, true
+	}
+	if s.Lowercase {
+		field = strings.ToLower(field)
+	}
+	if field == "" || len(field) > s.MaxLength || !utf8.ValidString(field) {
+		return "", false
+	}
+	for _, r := range field {
+		if !s.allows(r) {
+			return "", false
+		}
+	}
+	return field, true
+}
+
+func (s *SelectorSanitization) allows(r rune) bool {
+	if s.Strictness == SanitizationStrict {
+		return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-')
+	}
+	return r != ':' && unicode.IsPrint(r)
+}
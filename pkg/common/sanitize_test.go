@@ -0,0 +1,83 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectorSanitization(t *testing.T) {
+	printable := &SelectorSanitization{}
+	strict := &SelectorSanitization{Strictness: SanitizationStrict, MaxLength: 9, Lowercase: true}
+	for _, s := range []*SelectorSanitization{printable, strict} {
+		if err := s.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tCase := []struct {
+		s      *SelectorSanitization
+		field  string
+		want   string
+		wantOK bool
+	}{
+		// 0: disabled
+		{field: "web:admin\n", want: "web:admin\n", wantOK: true},
+		// 1: printable
+		{s: printable, field: "Web Servers (prod)", want: "Web Servers (prod)", wantOK: true},
+		// 2: separator crafted to spoof another selector
+		{s: printable, field: "web:sg:name:admin"},
+		// 3: control characters
+		{s: printable, field: "web\nadmin"},
+		// 4: invalid UTF-8
+		{s: printable, field: "web\xff"},
+		// 5: too long
+		{s: printable, field: strings.Repeat("a", 256)},
+		// 6: empty
+		{s: printable, field: ""},
+		// 7: strict, lower-cased
+		{s: strict, field: "Web-1.a_b", want: "web-1.a_b", wantOK: true},
+		// 8: strict rejects spaces
+		{s: strict, field: "web 1"},
+		// 9: strict rejects non-ASCII letters
+		{s: strict, field: "wéb"},
+		// 10: strict max length
+		{s: strict, field: "web-server"},
+	}
+
+	for i, c := range tCase {
+		got, ok := c.s.Sanitize(c.field)
+		if ok != c.wantOK || got != c.want {
+			t.Errorf("#%v: got %q, %v, want %q, %v", i, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestValidateSelectorSanitization(t *testing.T) {
+	for i, c := range []struct {
+		s       *SelectorSanitization
+		wantErr bool
+	}{
+		// 0: disabled
+		{},
+		// 1: defaults
+		{s: &SelectorSanitization{}},
+		// 2: unknown strictness
+		{s: &SelectorSanitization{Strictness: "paranoid"}, wantErr: true},
+		// 3: negative length
+		{s: &SelectorSanitization{MaxLength: -1}, wantErr: true},
+	} {
+		err := c.s.Validate()
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ConfigSchema describes the configuration of a plugin, so that external validators and UIs stay in sync with it
+type ConfigSchema struct {
+	// Component is "agent" or "server", Type the plugin type, e.g. "NodeAttestor", and Plugin the plugin name
+	Component string          `json:"component"`
+	Type      string          `json:"type"`
+	Plugin    string          `json:"plugin"`
+	Options   []*ConfigOption `json:"options"`
+}
+
+// ConfigOption describes an option of the configuration. Options of the "block" and "list(block)" types are nested
+// blocks, whose options are in Options, and which are labeled with Label if set, e.g. `key "label" { ... }`.
+// Recursive blocks take the options of the nearest enclosing block of the same type, e.g. the candidate of the
+// server takes the options of the configuration itself, and have no Options.
+type ConfigOption struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Default    string          `json:"default,omitempty"`
+	Deprecated string          `json:"deprecated,omitempty"`
+	Label      string          `json:"label,omitempty"`
+	Recursive  bool            `json:"recursive,omitempty"`
+	Options    []*ConfigOption `json:"options,omitempty"`
+}
+
+// NewConfigSchema returns the schema of the configuration decoded into config, a pointer to a struct, by
+// DecodeConfig. Options are the fields with the "hcl" tag, in the order they are declared. The "default" tag sets
+// the default of the option as written in the configuration, and the "deprecated" tag tells what to use instead.
+func NewConfigSchema(component, pluginType, pluginName string, config interface{}) (*ConfigSchema, error) {
+	t := reflect.TypeOf(config)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, got %T", config)
+	}
+	options, _, err := configOptions(t.Elem(), map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigSchema{
+		Component: component,
+		Type:      pluginType,
+		Plugin:    pluginName,
+		Options:   options,
+	}, nil
+}
+
+// WriteJSON writes the schema as indented JSON
+func (s *ConfigSchema) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// configOptions returns the options of the struct type and the label of its blocks, if a field has the ",key" tag.
+// enclosing are the types of the blocks enclosing it.
+func configOptions(t reflect.Type, enclosing map[reflect.Type]bool) ([]*ConfigOption, string, error) {
+	enclosing[t] = true
+	defer delete(enclosing, t)
+
+	var options []*ConfigOption
+	var label string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("hcl")
+		if !ok || f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if strings.HasSuffix(tag, ",key") {
+			label = snakeCase(f.Name)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+
+		o := &ConfigOption{
+			Name:       name,
+			Default:    f.Tag.Get("default"),
+			Deprecated: f.Tag.Get("deprecated"),
+		}
+		typ, block, err := configType(f.Type)
+		if err != nil {
+			return nil, "", fmt.Errorf("option %v: %v", name, err)
+		}
+		o.Type = typ
+		switch {
+		case block == nil:
+		case enclosing[block]:
+			o.Recursive = true
+		default:
+			if o.Options, o.Label, err = configOptions(block, enclosing); err != nil {
+				return nil, "", fmt.Errorf("option %v: %v", name, err)
+			}
+		}
+		options = append(options, o)
+	}
+	return options, label, nil
+}
+
+// configType returns the type of an option of the Go type, and the struct type of its blocks if it is a block
+func configType(t reflect.Type) (string, reflect.Type, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", nil, nil
+	case reflect.Bool:
+		return "bool", nil, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int", nil, nil
+	case reflect.Float32, reflect.Float64:
+		return "float", nil, nil
+	case reflect.Struct:
+		return "block", t, nil
+	case reflect.Slice:
+		elem, block, err := configType(t.Elem())
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("list(%s)", elem), block, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "", nil, fmt.Errorf("unsupported map key type %v", t.Key())
+		}
+		elem, block, err := configType(t.Elem())
+		if err != nil {
+			return "", nil, err
+		}
+		if block != nil {
+			return "", nil, fmt.Errorf("unsupported map of blocks %v", t)
+		}
+		return fmt.Sprintf("map(%s)", elem), nil, nil
+	}
+	return "", nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// snakeCase returns the Go name in snake case, e.g. "key_id" for "KeyID"
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type testSchemaKey struct {
+	KeyID   string `hcl:",key"`
+	KeyFile string `hcl:"key_file"`
+}
+
+type testSchemaConfig struct {
+	CloudName string            `hcl:"cloud_name"`
+	Region    string            `hcl:"region" deprecated:"use cloud_name"`
+	Timeout   string            `hcl:"timeout" default:"10s"`
+	Enabled   *bool             `hcl:"enabled" default:"true"`
+	Versions  []int             `hcl:"versions"`
+	Ratio     float64           `hcl:"ratio"`
+	Domains   map[string]string `hcl:"domains"`
+	Keys      []*testSchemaKey  `hcl:"key"`
+	Nested    *struct {
+		Backend string `hcl:"backend" default:"memory"`
+	} `hcl:"nested"`
+	Candidate *testSchemaConfig `hcl:"candidate"`
+
+	timeout int
+	Ignored string
+}
+
+func TestNewConfigSchema(t *testing.T) {
+	s, err := NewConfigSchema("server", "NodeAttestor", PluginName, &testSchemaConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []*ConfigOption{
+		{Name: "cloud_name", Type: "string"},
+		{Name: "region", Type: "string", Deprecated: "use cloud_name"},
+		{Name: "timeout", Type: "string", Default: "10s"},
+		{Name: "enabled", Type: "bool", Default: "true"},
+		{Name: "versions", Type: "list(int)"},
+		{Name: "ratio", Type: "float"},
+		{Name: "domains", Type: "map(string)"},
+		{Name: "key", Type: "list(block)", Label: "key_id", Options: []*ConfigOption{
+			{Name: "key_file", Type: "string"},
+		}},
+		{Name: "nested", Type: "block", Options: []*ConfigOption{
+			{Name: "backend", Type: "string", Default: "memory"},
+		}},
+		{Name: "candidate", Type: "block", Recursive: true},
+	}
+	if s.Component != "server" || s.Type != "NodeAttestor" || s.Plugin != PluginName {
+		t.Errorf("unexpected plugin: %v %v %v", s.Component, s.Type, s.Plugin)
+	}
+	if !reflect.DeepEqual(s.Options, want) {
+		got, _ := json.Marshal(s.Options)
+		t.Errorf("unexpected options: %s", got)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatalf("unexpected error from WriteJSON(): %v", err)
+	}
+	var decoded ConfigSchema
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Options, want) {
+		t.Errorf("unexpected decoded options: %s", buf.Bytes())
+	}
+}
+
+func TestNewConfigSchemaInvalid(t *testing.T) {
+	for i, config := range []interface{}{
+		// 0: not a pointer
+		testSchemaConfig{},
+		// 1: not a struct
+		new(string),
+		// 2: unsupported type
+		&struct {
+			Callback func() `hcl:"callback"`
+		}{},
+		// 3: map of blocks
+		&struct {
+			Blocks map[string]*testSchemaKey `hcl:"blocks"`
+		}{},
+	} {
+		if _, err := NewConfigSchema("server", "NodeAttestor", PluginName, config); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"KeyID":    "key_id",
+		"Cloud":    "cloud",
+		"SpiffeID": "spiffe_id",
+		"URL":      "url",
+		"HTTPPort": "http_port",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	regexpEnvReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// ResolveSecret returns the value of a secret config option.
+// If file is set, the secret is read from the file instead, and trailing newlines are trimmed.
+// References to environment variables like ${NAME} are expanded in both value and file.
+func ResolveSecret(name, value, file string) (string, error) {
+	if file != "" {
+		if value != "" {
+			return "", fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+		}
+		b, err := ioutil.ReadFile(ExpandEnv(file))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_file: %v", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return ExpandEnv(value), nil
+}
+
+// ExpandEnv replaces ${NAME} references with values of the environment variables.
+// Unlike os.ExpandEnv, "$" not followed by "{" is kept as is.
+func ExpandEnv(s string) string {
+	return regexpEnvReference.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(regexpEnvReference.FindStringSubmatch(ref)[1])
+	})
+}
@@ -0,0 +1,48 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("SPIRE_OPENSTACK_TEST", "alpha")
+	defer os.Unsetenv("SPIRE_OPENSTACK_TEST")
+
+	want := "alpha-$bravo"
+	if got := ExpandEnv("${SPIRE_OPENSTACK_TEST}-$bravo"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("alpha\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ResolveSecret("password", "", file); err != nil || got != "alpha" {
+		t.Errorf("got %q, %v, want %q", got, err, "alpha")
+	}
+	if got, err := ResolveSecret("password", "bravo", ""); err != nil || got != "bravo" {
+		t.Errorf("got %q, %v, want %q", got, err, "bravo")
+	}
+	if _, err := ResolveSecret("password", "bravo", file); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
@@ -0,0 +1,46 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// SelectorNamespaceCloud prefixes selectors with the name of the cloud entry
+	SelectorNamespaceCloud = "cloud"
+	// SelectorNamespaceProject prefixes selectors with the project ID of the instance
+	SelectorNamespaceProject = "project"
+)
+
+// ValidateSelectorNamespace validates the selector_namespace option of the plugins
+func ValidateSelectorNamespace(namespace, cloudName string) error {
+	switch namespace {
+	case "", SelectorNamespaceProject:
+	case SelectorNamespaceCloud:
+		if cloudName == "" {
+			return errors.New("selector_namespace \"cloud\" requires cloud_name")
+		}
+	default:
+		return fmt.Errorf("unknown selector_namespace: %q", namespace)
+	}
+	return nil
+}
+
+// NamespacedSelectorValue prefixes the selector value with the namespace, e.g. "east:sg:name:web",
+// so that selectors of instances in different deployments or projects don't collide
+func NamespacedSelectorValue(namespace, cloudName, projectID, value string) string {
+	switch namespace {
+	case SelectorNamespaceCloud:
+		return cloudName + ":" + value
+	case SelectorNamespaceProject:
+		return projectID + ":" + value
+	}
+	return value
+}
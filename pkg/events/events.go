@@ -0,0 +1,165 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	SinkHTTP  = "http"
+	SinkKafka = "kafka"
+
+	specVersion   = "1.0"
+	defaultSource = "spire-server/openstack_iid"
+	queueSize     = 1024
+)
+
+// Event represents a CloudEvent in the structured JSON format
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// Config represents the configuration of the event emitter
+type Config struct {
+	// "http" or "kafka"
+	Sink string `hcl:"sink"`
+	// URL of the HTTP sink, or the Kafka REST Proxy for the kafka sink.
+	URL string `hcl:"url"`
+	// Kafka topic to publish events to.
+	Topic string `hcl:"topic"`
+	// Source attribute of events. Defaults to "spire-server/openstack_iid".
+	Source string `hcl:"source" default:"spire-server/openstack_iid"`
+	// Timeout of a request to the sink, e.g. "5s". Defaults to 5s.
+	Timeout string `hcl:"timeout" default:"5s"`
+}
+
+// Sink delivers events
+type Sink interface {
+	Send(e *Event) error
+}
+
+// Emitter publishes events to a sink asynchronously, so that a slow sink doesn't block attestations.
+// Events are dropped if the queue is full.
+type Emitter struct {
+	logger hclog.Logger
+	source string
+	sink   Sink
+	queue  chan *Event
+	done   chan struct{}
+}
+
+// NewEmitter returns an Emitter publishing events to the configured sink
+func NewEmitter(c *Config, logger hclog.Logger) (*Emitter, error) {
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		timeout = d
+	}
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required for %v sink", c.Sink)
+	}
+
+	var sink Sink
+	switch c.Sink {
+	case SinkHTTP:
+		sink = NewHTTPSink(c.URL, timeout)
+	case SinkKafka:
+		if c.Topic == "" {
+			return nil, fmt.Errorf("topic is required for %v sink", c.Sink)
+		}
+		s, err := NewKafkaRESTSink(c.URL, c.Topic, timeout)
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	default:
+		return nil, fmt.Errorf("unknown event sink: %q", c.Sink)
+	}
+
+	return NewEmitterWithSink(sink, c.Source, logger), nil
+}
+
+// NewEmitterWithSink returns an Emitter publishing events of the source to given sink, for sinks of other packages.
+// The source defaults to "spire-server/openstack_iid".
+func NewEmitterWithSink(sink Sink, source string, logger hclog.Logger) *Emitter {
+	if source == "" {
+		source = defaultSource
+	}
+	return newEmitter(sink, source, logger)
+}
+
+func newEmitter(sink Sink, source string, logger hclog.Logger) *Emitter {
+	e := &Emitter{
+		logger: logger,
+		source: source,
+		sink:   sink,
+		queue:  make(chan *Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Emit queues an event of given type with data
+func (e *Emitter) Emit(eventType, subject string, data interface{}) {
+	ev := &Event{
+		SpecVersion:     specVersion,
+		ID:              newID(),
+		Source:          e.source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	select {
+	case e.queue <- ev:
+	default:
+		e.logger.Warn("Event queue is full, dropping event", "type", eventType, "subject", subject)
+	}
+}
+
+// Close stops the emitter after sending queued events
+func (e *Emitter) Close() {
+	close(e.queue)
+	<-e.done
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+	for ev := range e.queue {
+		if err := e.sink.Send(ev); err != nil {
+			e.logger.Warn("Failed to send event", "type", ev.Type, "id", ev.ID, "error", err)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,85 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestEmitterHTTP(t *testing.T) {
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/cloudevents+json" {
+			t.Errorf("unexpected content type: %v", ct)
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		received <- e
+	}))
+	defer srv.Close()
+
+	e, err := NewEmitter(&Config{Sink: SinkHTTP, URL: srv.URL}, testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.Emit("alpha", "bravo", map[string]string{"charlie": "delta"})
+	e.Close()
+
+	select {
+	case ev := <-received:
+		if ev.SpecVersion != specVersion || ev.Type != "alpha" || ev.Subject != "bravo" || ev.Source != defaultSource {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("event not received")
+	}
+}
+
+func TestKafkaRESTSink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/spire" {
+			t.Errorf("unexpected path: %v", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewKafkaRESTSink(srv.URL, "spire", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Send(&Event{ID: "alpha"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewEmitterInvalidConfig(t *testing.T) {
+	for i, c := range []*Config{
+		{Sink: SinkHTTP},
+		{Sink: SinkKafka, URL: "http://localhost"},
+		{Sink: "unknown", URL: "http://localhost"},
+		{Sink: SinkKafka, URL: "http://[::1", Topic: "spire"},
+	} {
+		if _, err := NewEmitter(c, testutil.TestLogger()); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
+
+func TestNewKafkaRESTSinkInvalidURL(t *testing.T) {
+	if _, err := NewKafkaRESTSink("http://[::1", "spire", time.Second); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
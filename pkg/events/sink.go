@@ -0,0 +1,91 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// HTTPSink posts events in the structured content mode of the CloudEvents HTTP binding
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a Sink posting events to url
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSink) Send(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.url, "application/cloudevents+json", b)
+}
+
+// KafkaRESTSink produces events to a Kafka topic through the Kafka REST Proxy (v2 API)
+type KafkaRESTSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaRESTSink returns a Sink producing events to topic through the REST Proxy at proxyURL
+func NewKafkaRESTSink(proxyURL, topic string, timeout time.Duration) (*KafkaRESTSink, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url of the REST Proxy: %v", err)
+	}
+	u.Path = path.Join(u.Path, "topics", topic)
+	return &KafkaRESTSink{
+		url:    u.String(),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *KafkaRESTSink) Send(e *Event) error {
+	type record struct {
+		Key   string `json:"key"`
+		Value *Event `json:"value"`
+	}
+	b, err := json.Marshal(struct {
+		Records []record `json:"records"`
+	}{
+		Records: []record{{Key: e.Subject, Value: e}},
+	})
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.url, "application/vnd.kafka.json.v2+json", b)
+}
+
+func post(client *http.Client, url, contentType string, body []byte) error {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code from %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package health probes the configured clouds in the background and reports their status.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+var (
+	cloudUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "cloud",
+		Name:      "up",
+		Help:      "Whether Keystone and Nova of the cloud were reachable at the last probe.",
+	}, []string{"cloud"})
+	probeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "cloud",
+		Name:      "probe_duration_seconds",
+		Help:      "Duration of the last probe of the cloud.",
+	}, []string{"cloud"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(cloudUp, probeDuration)
+}
+
+// ProbeFunc checks the reachability of a cloud
+type ProbeFunc func(ctx context.Context) error
+
+// Status is the result of the last probe of a cloud
+type Status struct {
+	Cloud     string        `json:"cloud"`
+	Healthy   bool          `json:"healthy"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// Prober probes clouds periodically
+type Prober struct {
+	logger   hclog.Logger
+	probes   map[string]ProbeFunc
+	interval time.Duration
+	timeout  time.Duration
+
+	mtx      sync.RWMutex
+	statuses map[string]*Status
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewProber returns a Prober running probes, keyed by cloud name, every interval
+func NewProber(probes map[string]ProbeFunc, interval, timeout time.Duration, logger hclog.Logger) *Prober {
+	return &Prober{
+		logger:   logger,
+		probes:   probes,
+		interval: interval,
+		timeout:  timeout,
+		statuses: make(map[string]*Status),
+	}
+}
+
+// Start starts probing in the background. The first probe runs immediately.
+func (p *Prober) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			p.ProbeAll(ctx)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops probing and waits for the running probes
+func (p *Prober) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+	for cloud := range p.probes {
+		cloudUp.DeleteLabelValues(cloud)
+		probeDuration.DeleteLabelValues(cloud)
+	}
+}
+
+// ProbeAll probes all clouds concurrently
+func (p *Prober) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for cloud, probe := range p.probes {
+		wg.Add(1)
+		go func(cloud string, probe ProbeFunc) {
+			defer wg.Done()
+			p.probe(ctx, cloud, probe)
+		}(cloud, probe)
+	}
+	wg.Wait()
+}
+
+func (p *Prober) probe(ctx context.Context, cloud string, probe ProbeFunc) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := probe(ctx)
+	s := &Status{
+		Cloud:     cloud,
+		Healthy:   err == nil,
+		CheckedAt: start,
+		Duration:  time.Since(start),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	p.mtx.Lock()
+	prev := p.statuses[cloud]
+	p.statuses[cloud] = s
+	p.mtx.Unlock()
+
+	switch {
+	case err != nil && (prev == nil || prev.Healthy):
+		p.logger.Warn("Cloud is unhealthy", "cloud", cloud, "error", err)
+	case err == nil && prev != nil && !prev.Healthy:
+		p.logger.Info("Cloud is healthy again", "cloud", cloud)
+	}
+
+	up := 0.0
+	if s.Healthy {
+		up = 1
+	}
+	cloudUp.WithLabelValues(cloud).Set(up)
+	probeDuration.WithLabelValues(cloud).Set(s.Duration.Seconds())
+}
+
+// Statuses returns the statuses of the clouds probed so far, sorted by cloud name
+func (p *Prober) Statuses() []Status {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	var sl []Status
+	for _, s := range p.statuses {
+		sl = append(sl, *s)
+	}
+	sort.Slice(sl, func(i, j int) bool { return sl[i].Cloud < sl[j].Cloud })
+	return sl
+}
+
+// Healthy returns true if all clouds were healthy at their last probe
+func (p *Prober) Healthy() bool {
+	for _, s := range p.Statuses() {
+		if !s.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP reports the statuses as JSON, with 503 if any cloud is unhealthy
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := http.StatusOK
+	if !p.Healthy() {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clouds": p.Statuses(),
+	})
+}
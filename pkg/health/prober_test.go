@@ -0,0 +1,70 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestProber(t *testing.T) {
+	healthy := true
+	p := NewProber(map[string]ProbeFunc{
+		"alpha": func(context.Context) error { return nil },
+		"bravo": func(context.Context) error {
+			if healthy {
+				return nil
+			}
+			return errors.New("keystone: unreachable")
+		},
+	}, time.Minute, time.Second, hclog.NewNullLogger())
+
+	p.ProbeAll(context.Background())
+	if !p.Healthy() {
+		t.Errorf("unexpected statuses: %+v", p.Statuses())
+	}
+
+	healthy = false
+	p.ProbeAll(context.Background())
+	sl := p.Statuses()
+	if p.Healthy() || len(sl) != 2 || sl[1].Cloud != "bravo" || sl[1].Error != "keystone: unreachable" {
+		t.Errorf("unexpected statuses: %+v", sl)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code: %v", rec.Code)
+	}
+}
+
+func TestProberTimeout(t *testing.T) {
+	p := NewProber(map[string]ProbeFunc{
+		"alpha": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, time.Minute, 10*time.Millisecond, hclog.NewNullLogger())
+
+	p.Start()
+	defer p.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for len(p.Statuses()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p.Healthy() || len(p.Statuses()) != 1 {
+		t.Errorf("unexpected statuses: %+v", p.Statuses())
+	}
+}
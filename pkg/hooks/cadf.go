@@ -0,0 +1,110 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/audit"
+)
+
+const (
+	// Event types of the notifications of the cadf hook, named like the notifications of OpenStack services
+	NotificationTypeAttested = "spire.attestation.admitted"
+	NotificationTypeDenied   = "spire.attestation.denied"
+	NotificationTypeEvicted  = "spire.attestation.evicted"
+
+	defaultObserverID = "spire-server/openstack_iid"
+	// unknownID is the ID of resources which are not known, e.g. the agent of a denied attestation
+	unknownID = "unknown"
+)
+
+// CADFHook emits decisions as CADF audit events, so that attestations show up in the audit trail of the cloud
+type CADFHook struct {
+	notifier *audit.Notifier
+	observer *audit.Resource
+}
+
+func newCADFHook(c *Config, logger hclog.Logger) (*CADFHook, error) {
+	var timeout time.Duration
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		timeout = d
+	}
+	observerID := c.Source
+	if observerID == "" {
+		observerID = defaultObserverID
+	}
+	notifier, err := audit.NewNotifier(&audit.Config{
+		Transport:   c.Transport,
+		URL:         c.URL,
+		Topic:       c.Topic,
+		PublisherID: observerID,
+		Timeout:     timeout,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewCADFHook(notifier, observerID), nil
+}
+
+// NewCADFHook returns a hook emitting audit events observed by observerID to the notifier
+func NewCADFHook(notifier *audit.Notifier, observerID string) *CADFHook {
+	return &CADFHook{
+		notifier: notifier,
+		observer: &audit.Resource{TypeURI: audit.TypeURIObserver, ID: observerID, Name: "openstack_iid"},
+	}
+}
+
+func (h *CADFHook) OnAttested(d *Decision) {
+	e := h.newEvent(audit.ActionAuthenticate, audit.OutcomeSuccess, d)
+	if len(d.Selectors) > 0 {
+		e.Attach("selectors", d.Selectors)
+	}
+	h.notifier.Notify(NotificationTypeAttested, e)
+}
+
+func (h *CADFHook) OnDenied(d *Decision) {
+	h.notifier.Notify(NotificationTypeDenied, h.newEvent(audit.ActionAuthenticate, audit.OutcomeFailure, d))
+}
+
+func (h *CADFHook) OnEvicted(d *Decision) {
+	h.notifier.Notify(NotificationTypeEvicted, h.newEvent(audit.ActionDelete, audit.OutcomeSuccess, d))
+}
+
+func (h *CADFHook) Close() {
+	h.notifier.Close()
+}
+
+// newEvent returns the audit event of the decision, initiated by the instance and targeting its agent
+func (h *CADFHook) newEvent(action, outcome string, d *Decision) *audit.Event {
+	agentID := d.AgentID
+	if agentID == "" {
+		agentID = unknownID
+	}
+	e := audit.NewEvent(action, outcome,
+		&audit.Resource{TypeURI: audit.TypeURIServer, ID: d.InstanceID, ProjectID: d.ProjectID},
+		&audit.Resource{TypeURI: audit.TypeURIAgent, ID: agentID},
+		h.observer)
+	if d.ReasonCode != "" {
+		e.Reason = &audit.Reason{ReasonType: audit.ReasonType, ReasonCode: d.ReasonCode}
+	}
+	if d.AttestationID != "" {
+		e.Attach("attestation_id", d.AttestationID)
+	}
+	if d.Observed {
+		e.Attach("observed", true)
+	}
+	return e
+}
@@ -0,0 +1,222 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package hooks notifies integrations of attestation decisions. Integrations implement Hook,
+// so that they are added here without changes to the verification of the attestor.
+package hooks
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/events"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const (
+	TypeLog     = "log"
+	TypeWebhook = "webhook"
+	TypeKafka   = "kafka"
+	TypeCADF    = "cadf"
+	TypeMistral = "mistral"
+
+	EventTypeAttested = "io.spiffe.spire.openstack_iid.attestation.admitted"
+	EventTypeDenied   = "io.spiffe.spire.openstack_iid.attestation.denied"
+	EventTypeEvicted  = "io.spiffe.spire.openstack_iid.attestation.evicted"
+)
+
+// Decision is an attestation decision passed to hooks
+type Decision struct {
+	// AttestationID correlates the decision with the log lines and the OpenStack requests of the attestation
+	AttestationID string   `json:"attestation_id,omitempty"`
+	InstanceID    string   `json:"instance_id"`
+	ProjectID     string   `json:"project_id,omitempty"`
+	AgentID       string   `json:"agent_id,omitempty"`
+	Selectors     []string `json:"selectors,omitempty"`
+	Admitted      bool     `json:"admitted"`
+	Reason        string   `json:"reason,omitempty"`
+	ReasonCode    string   `json:"reason_code,omitempty"`
+	Changes       []string `json:"changes,omitempty"`
+	Degraded      []string `json:"degraded,omitempty"`
+	// Denials is the number of denials of the project within the window of a hook with a threshold
+	Denials int `json:"denials,omitempty"`
+	// Observed is true if the decision was not enforced, since the server only observes attestations. The agent was
+	// denied whatever the decision.
+	Observed bool `json:"observed,omitempty"`
+}
+
+// Hook is notified of attestation decisions. Hooks are called on the attestation path, so they must not block.
+type Hook interface {
+	// OnAttested is called when an agent is admitted
+	OnAttested(d *Decision)
+	// OnDenied is called when an attestation is denied
+	OnDenied(d *Decision)
+	// OnEvicted is called after OnDenied when the denied agent was attested before, i.e. it lost its identity
+	OnEvicted(d *Decision)
+	// Close releases the resources of the hook after delivering pending notifications
+	Close()
+}
+
+// Config represents the configuration of a hook
+type Config struct {
+	// "log", "webhook", "kafka", "cadf" or "mistral"
+	Type string `hcl:",key"`
+	// URL of the webhook, the Kafka REST Proxy for the kafka hook, or the audit endpoint for the cadf hook.
+	URL string `hcl:"url"`
+	// Kafka topic to publish decisions to, or the topic of the notifications of the cadf hook.
+	Topic string `hcl:"topic"`
+	// Source attribute of events, or the observer of the audit events of the cadf hook.
+	// Defaults to "spire-server/openstack_iid".
+	Source string `hcl:"source" default:"spire-server/openstack_iid"`
+	// Timeout of a request to the webhook or the REST Proxy, e.g. "5s". Defaults to 5s.
+	Timeout string `hcl:"timeout" default:"5s"`
+	// Transport of the cadf hook, "http" or "rabbitmq". Defaults to "http".
+	Transport string `hcl:"transport" default:"http"`
+
+	// Outcomes the hook is notified of, any of "attested", "denied" and "evicted". Defaults to all of them.
+	On []string `hcl:"on"`
+	// Notifies of denials only once a project is denied this many times within window, e.g. to respond to repeated
+	// denials. Requires "denied" in on.
+	Threshold int `hcl:"threshold"`
+	// Window the denials of a project are counted in for threshold, e.g. "10m". Defaults to 10m.
+	Window string `hcl:"window" default:"10m"`
+
+	// Name of the Mistral workflow the mistral hook executes, with the event of each decision as the input "event".
+	Workflow string `hcl:"workflow"`
+	// Cloud entry in clouds.yaml of the credentials executing the workflow of the mistral hook
+	CloudName string                `hcl:"cloud_name"`
+	Auth      *openstack.AuthConfig `hcl:"auth"`
+}
+
+// New returns a Hook of the configured type, notified of the configured outcomes
+func New(c *Config, logger hclog.Logger) (Hook, error) {
+	h, err := newHook(c, logger)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTrigger(c, h)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("invalid %v hook: %v", c.Type, err)
+	}
+	return t, nil
+}
+
+func newHook(c *Config, logger hclog.Logger) (Hook, error) {
+	switch c.Type {
+	case TypeLog:
+		return NewLogHook(logger), nil
+	case TypeWebhook, TypeKafka:
+		sink := events.SinkHTTP
+		if c.Type == TypeKafka {
+			sink = events.SinkKafka
+		}
+		emitter, err := events.NewEmitter(&events.Config{
+			Sink:    sink,
+			URL:     c.URL,
+			Topic:   c.Topic,
+			Source:  c.Source,
+			Timeout: c.Timeout,
+		}, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v hook: %v", c.Type, err)
+		}
+		return NewEventHook(emitter), nil
+	case TypeCADF:
+		h, err := newCADFHook(c, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v hook: %v", c.Type, err)
+		}
+		return h, nil
+	case TypeMistral:
+		h, err := newMistralHook(c, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v hook: %v", c.Type, err)
+		}
+		return h, nil
+	default:
+		return nil, fmt.Errorf("unknown hook: %q", c.Type)
+	}
+}
+
+// Hooks notifies all of the hooks in order
+type Hooks []Hook
+
+func (hs Hooks) OnAttested(d *Decision) {
+	for _, h := range hs {
+		h.OnAttested(d)
+	}
+}
+
+func (hs Hooks) OnDenied(d *Decision) {
+	for _, h := range hs {
+		h.OnDenied(d)
+	}
+}
+
+func (hs Hooks) OnEvicted(d *Decision) {
+	for _, h := range hs {
+		h.OnEvicted(d)
+	}
+}
+
+func (hs Hooks) Close() {
+	for _, h := range hs {
+		h.Close()
+	}
+}
+
+// LogHook logs decisions, for deployments collecting the server log instead of events
+type LogHook struct {
+	logger hclog.Logger
+}
+
+// NewLogHook returns a Hook logging decisions to logger
+func NewLogHook(logger hclog.Logger) *LogHook {
+	return &LogHook{logger: logger.Named("hook")}
+}
+
+func (h *LogHook) OnAttested(d *Decision) {
+	h.logger.Info("Agent attested", "attestation_id", d.AttestationID, "agent_id", d.AgentID, "instance_id", d.InstanceID, "project_id", d.ProjectID, "observed", d.Observed)
+}
+
+func (h *LogHook) OnDenied(d *Decision) {
+	h.logger.Info("Attestation denied", "attestation_id", d.AttestationID, "instance_id", d.InstanceID, "project_id", d.ProjectID, "reason_code", d.ReasonCode, "reason", d.Reason, "observed", d.Observed)
+}
+
+func (h *LogHook) OnEvicted(d *Decision) {
+	h.logger.Warn("Agent evicted", "attestation_id", d.AttestationID, "agent_id", d.AgentID, "instance_id", d.InstanceID, "project_id", d.ProjectID, "reason_code", d.ReasonCode)
+}
+
+func (h *LogHook) Close() {}
+
+// EventHook publishes decisions as CloudEvents with the emitter, which it closes on Close
+type EventHook struct {
+	emitter *events.Emitter
+}
+
+// NewEventHook returns a Hook publishing decisions with emitter
+func NewEventHook(emitter *events.Emitter) *EventHook {
+	return &EventHook{emitter: emitter}
+}
+
+func (h *EventHook) OnAttested(d *Decision) {
+	h.emitter.Emit(EventTypeAttested, d.InstanceID, d)
+}
+
+func (h *EventHook) OnDenied(d *Decision) {
+	h.emitter.Emit(EventTypeDenied, d.InstanceID, d)
+}
+
+func (h *EventHook) OnEvicted(d *Decision) {
+	h.emitter.Emit(EventTypeEvicted, d.InstanceID, d)
+}
+
+func (h *EventHook) Close() {
+	h.emitter.Close()
+}
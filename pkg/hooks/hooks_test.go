@@ -0,0 +1,134 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/audit"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/events"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestWebhook(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e events.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		received = append(received, e.Type)
+	}))
+	defer srv.Close()
+
+	h, err := New(&Config{Type: TypeWebhook, URL: srv.URL}, testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := &Decision{InstanceID: "alpha"}
+	h.OnAttested(d)
+	h.OnDenied(d)
+	h.OnEvicted(d)
+	h.Close()
+
+	want := []string{EventTypeAttested, EventTypeDenied, EventTypeEvicted}
+	if len(received) != len(want) {
+		t.Fatalf("got %v, want %v", received, want)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Errorf("#%v: got %v, want %v", i, received[i], want[i])
+		}
+	}
+}
+
+func TestCADF(t *testing.T) {
+	var received []audit.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e audit.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		received = append(received, e)
+	}))
+	defer srv.Close()
+
+	h, err := New(&Config{Type: TypeCADF, URL: srv.URL}, testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h.OnAttested(&Decision{InstanceID: "alpha", ProjectID: "bravo", AgentID: "spiffe://example.com/agent", Selectors: []string{"charlie"}})
+	h.OnDenied(&Decision{AttestationID: "req-1", InstanceID: "alpha", ProjectID: "bravo", ReasonCode: "POLICY_PROJECT_NOT_ALLOWED"})
+	h.OnEvicted(&Decision{InstanceID: "alpha", ProjectID: "bravo", AgentID: "spiffe://example.com/agent", ReasonCode: "POLICY_PROJECT_NOT_ALLOWED"})
+	h.Close()
+
+	want := []struct {
+		action     string
+		outcome    string
+		agentID    string
+		reasonCode string
+	}{
+		{audit.ActionAuthenticate, audit.OutcomeSuccess, "spiffe://example.com/agent", ""},
+		{audit.ActionAuthenticate, audit.OutcomeFailure, unknownID, "POLICY_PROJECT_NOT_ALLOWED"},
+		{audit.ActionDelete, audit.OutcomeSuccess, "spiffe://example.com/agent", "POLICY_PROJECT_NOT_ALLOWED"},
+	}
+	if len(received) != len(want) {
+		t.Fatalf("got %v events, want %v", len(received), len(want))
+	}
+	for i, w := range want {
+		e := received[i]
+		if e.Action != w.action || e.Outcome != w.outcome || e.Target.ID != w.agentID {
+			t.Errorf("#%v: unexpected event: %+v", i, e)
+		}
+		if e.Initiator.ID != "alpha" || e.Initiator.ProjectID != "bravo" || e.Observer.ID != defaultObserverID {
+			t.Errorf("#%v: unexpected resources: %+v %+v", i, e.Initiator, e.Observer)
+		}
+		if (e.Reason == nil) != (w.reasonCode == "") || (e.Reason != nil && e.Reason.ReasonCode != w.reasonCode) {
+			t.Errorf("#%v: unexpected reason: %+v", i, e.Reason)
+		}
+	}
+	if len(received[0].Attachments) != 1 || received[0].Attachments[0].Name != "selectors" {
+		t.Errorf("unexpected attachments: %+v", received[0].Attachments)
+	}
+	if len(received[1].Attachments) != 1 || received[1].Attachments[0].Name != "attestation_id" || received[1].Attachments[0].Content != "req-1" {
+		t.Errorf("unexpected attachments: %+v", received[1].Attachments)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for i, c := range []*Config{
+		{Type: TypeWebhook},
+		{Type: TypeKafka, URL: "http://localhost"},
+		{Type: TypeCADF},
+		{Type: TypeCADF, URL: "http://localhost", Transport: "amqp"},
+		{Type: "unknown"},
+		{},
+		{Type: TypeMistral},
+		{Type: TypeLog, On: []string{"admitted"}},
+		{Type: TypeLog, On: []string{OutcomeAttested}, Threshold: 3},
+		{Type: TypeLog, Threshold: 3, Window: "forever"},
+	} {
+		if _, err := New(c, testutil.TestLogger()); err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		}
+	}
+}
+
+func TestNewLog(t *testing.T) {
+	h, err := New(&Config{Type: TypeLog}, testutil.TestLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := h.(*LogHook); !ok {
+		t.Errorf("unexpected hook: %T", h)
+	}
+	h.Close()
+}
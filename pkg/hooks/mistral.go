@@ -0,0 +1,81 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/events"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+const defaultMistralTimeout = 5 * time.Second
+
+// mistralSink executes a Mistral workflow with each event as the input "event", so that decisions trigger automation
+// of the cloud, e.g. the quarantine of instances repeatedly denied
+type mistralSink struct {
+	logger   hclog.Logger
+	workflow string
+	timeout  time.Duration
+	// newClient authenticates on the first event, so that an unavailable Keystone doesn't fail the configuration of
+	// the server
+	newClient func(ctx context.Context) (openstack.WorkflowClient, error)
+	// client is used by the goroutine of the emitter only
+	client openstack.WorkflowClient
+}
+
+func newMistralHook(c *Config, logger hclog.Logger) (*EventHook, error) {
+	if c.Workflow == "" {
+		return nil, errors.New("workflow is required")
+	}
+	timeout := defaultMistralTimeout
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		timeout = d
+	}
+	sink := &mistralSink{
+		logger:   logger,
+		workflow: c.Workflow,
+		timeout:  timeout,
+		newClient: func(ctx context.Context) (openstack.WorkflowClient, error) {
+			provider, err := openstack.NewProviderWithAuth(ctx, c.CloudName, c.Auth)
+			if err != nil {
+				return nil, err
+			}
+			return openstack.NewWorkflow(provider, openstack.GetRegion(c.CloudName))
+		},
+	}
+	return NewEventHook(events.NewEmitterWithSink(sink, c.Source, logger)), nil
+}
+
+func (s *mistralSink) Send(e *events.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if s.client == nil {
+		client, err := s.newClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to prepare OpenStack Workflow Client: %v", err)
+		}
+		s.client = client
+	}
+	id, err := s.client.ExecuteWorkflow(ctx, s.workflow, map[string]interface{}{"event": e})
+	if err != nil {
+		return fmt.Errorf("failed to execute workflow %v: %v", s.workflow, err)
+	}
+	s.logger.Debug("Executed workflow", "workflow", s.workflow, "execution_id", id, "type", e.Type, "subject", e.Subject)
+	return nil
+}
@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/events"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+type fakeWorkflow struct {
+	workflows []string
+	inputs    []interface{}
+}
+
+func (w *fakeWorkflow) ExecuteWorkflow(ctx context.Context, workflow string, input interface{}) (string, error) {
+	w.workflows = append(w.workflows, workflow)
+	w.inputs = append(w.inputs, input)
+	return "execution-1", nil
+}
+
+func TestMistral(t *testing.T) {
+	wf := &fakeWorkflow{}
+	authFailures := 1
+	sink := &mistralSink{
+		logger:   testutil.TestLogger(),
+		workflow: "quarantine",
+		timeout:  time.Second,
+		newClient: func(context.Context) (openstack.WorkflowClient, error) {
+			if authFailures > 0 {
+				authFailures--
+				return nil, errors.New("keystone unavailable")
+			}
+			return wf, nil
+		},
+	}
+
+	// the client is prepared again after a failure
+	if err := sink.Send(&events.Event{Type: EventTypeDenied, Subject: "alpha"}); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	e := &events.Event{Type: EventTypeDenied, Subject: "alpha", Data: &Decision{InstanceID: "alpha", Denials: 3}}
+	if err := sink.Send(e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(wf.workflows) != 1 || wf.workflows[0] != "quarantine" {
+		t.Fatalf("unexpected executions: %v", wf.workflows)
+	}
+	input, ok := wf.inputs[0].(map[string]interface{})
+	if !ok || input["event"] != e {
+		t.Errorf("unexpected input: %+v", wf.inputs[0])
+	}
+}
@@ -0,0 +1,157 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeAttested = "attested"
+	OutcomeDenied   = "denied"
+	OutcomeEvicted  = "evicted"
+
+	defaultTriggerWindow = 10 * time.Minute
+	// maxTrackedProjects bounds the projects whose denials are counted, since denied agents choose the project hints
+	// of their payloads
+	maxTrackedProjects = 4096
+)
+
+// trigger notifies the hook of the configured outcomes only, and of denials only once a project is denied threshold
+// times within the window, for integrations acting on decisions rather than recording them
+type trigger struct {
+	hook      Hook
+	outcomes  map[string]bool
+	threshold int
+	window    time.Duration
+	now       func() time.Time
+	// enforcedOnly drops observed decisions, which no agent was admitted or denied by
+	enforcedOnly bool
+
+	mtx sync.Mutex
+	// denials are the times of the denials of each project within the window, oldest first
+	denials map[string][]time.Time
+}
+
+// newTrigger returns the hook as is if it is notified of all decisions, or the trigger of the hook otherwise
+func newTrigger(c *Config, h Hook) (Hook, error) {
+	// Automated responses act on enforced decisions only, not on those of a server observing attestations
+	enforcedOnly := c.Type == TypeMistral
+	if len(c.On) == 0 && c.Threshold == 0 && !enforcedOnly {
+		return h, nil
+	}
+
+	t := &trigger{
+		hook:         h,
+		outcomes:     make(map[string]bool),
+		threshold:    c.Threshold,
+		window:       defaultTriggerWindow,
+		now:          time.Now,
+		enforcedOnly: enforcedOnly,
+		denials:      make(map[string][]time.Time),
+	}
+	for _, o := range c.On {
+		switch o {
+		case OutcomeAttested, OutcomeDenied, OutcomeEvicted:
+			t.outcomes[o] = true
+		default:
+			return nil, fmt.Errorf("unknown outcome: %q", o)
+		}
+	}
+	if len(c.On) == 0 {
+		t.outcomes = map[string]bool{OutcomeAttested: true, OutcomeDenied: true, OutcomeEvicted: true}
+	}
+	if c.Threshold < 0 {
+		return nil, errors.New("threshold must not be negative")
+	}
+	if c.Threshold > 0 && !t.outcomes[OutcomeDenied] {
+		return nil, errors.New(`threshold requires "denied" in on`)
+	}
+	if c.Window != "" {
+		d, err := time.ParseDuration(c.Window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid window: %q", c.Window)
+		}
+		t.window = d
+	}
+	return t, nil
+}
+
+func (t *trigger) OnAttested(d *Decision) {
+	if t.outcomes[OutcomeAttested] && !t.dropped(d) {
+		t.hook.OnAttested(d)
+	}
+}
+
+func (t *trigger) OnDenied(d *Decision) {
+	if !t.outcomes[OutcomeDenied] || t.dropped(d) {
+		return
+	}
+	if t.threshold <= 1 {
+		t.hook.OnDenied(d)
+		return
+	}
+	n := t.countDenial(d.ProjectID)
+	if n < t.threshold {
+		return
+	}
+	// the decision is shared by the other hooks
+	triggered := *d
+	triggered.Denials = n
+	t.hook.OnDenied(&triggered)
+}
+
+func (t *trigger) OnEvicted(d *Decision) {
+	if t.outcomes[OutcomeEvicted] && !t.dropped(d) {
+		t.hook.OnEvicted(d)
+	}
+}
+
+// dropped returns true if the hook is not notified of the decision whatever its outcome
+func (t *trigger) dropped(d *Decision) bool {
+	return t.enforcedOnly && d.Observed
+}
+
+func (t *trigger) Close() {
+	t.hook.Close()
+}
+
+// countDenial records a denial of the project and returns the number of its denials within the window. The count
+// starts over once it reaches the threshold, so that the hook is notified once per threshold denials.
+func (t *trigger) countDenial(projectID string) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := t.now()
+	since := now.Add(-t.window)
+	if _, ok := t.denials[projectID]; !ok && len(t.denials) >= maxTrackedProjects {
+		for p, times := range t.denials {
+			if !times[len(times)-1].After(since) {
+				delete(t.denials, p)
+			}
+		}
+		if len(t.denials) >= maxTrackedProjects {
+			return 1
+		}
+	}
+
+	times := t.denials[projectID]
+	for len(times) > 0 && !times[0].After(since) {
+		times = times[1:]
+	}
+	times = append(times, now)
+	if len(times) >= t.threshold {
+		delete(t.denials, projectID)
+		return len(times)
+	}
+	t.denials[projectID] = times
+	return len(times)
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package hooks
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingHook records the decisions it is notified of as "<outcome> <project>/<denials>"
+type recordingHook struct {
+	notified []string
+}
+
+func (h *recordingHook) record(outcome string, d *Decision) {
+	h.notified = append(h.notified, fmt.Sprintf("%v %v/%v", outcome, d.ProjectID, d.Denials))
+}
+
+func (h *recordingHook) OnAttested(d *Decision) { h.record(OutcomeAttested, d) }
+func (h *recordingHook) OnDenied(d *Decision)   { h.record(OutcomeDenied, d) }
+func (h *recordingHook) OnEvicted(d *Decision)  { h.record(OutcomeEvicted, d) }
+func (h *recordingHook) Close()                 {}
+
+func TestTrigger(t *testing.T) {
+	type event struct {
+		outcome   string
+		projectID string
+		// after is the time since the previous event
+		after    time.Duration
+		observed bool
+	}
+	tCase := []struct {
+		config *Config
+		events []event
+		want   []string
+	}{
+		// 0: selected outcomes only
+		{
+			config: &Config{On: []string{OutcomeDenied, OutcomeEvicted}},
+			events: []event{{outcome: OutcomeAttested, projectID: "alpha"}, {outcome: OutcomeDenied, projectID: "alpha"}, {outcome: OutcomeEvicted, projectID: "alpha"}},
+			want:   []string{"denied alpha/0", "evicted alpha/0"},
+		},
+		// 1: repeated denials of a project within the window, counted per project and started over once notified
+		{
+			config: &Config{On: []string{OutcomeDenied}, Threshold: 3, Window: "10m"},
+			events: []event{
+				{outcome: OutcomeDenied, projectID: "alpha"},
+				{outcome: OutcomeDenied, projectID: "bravo"},
+				{outcome: OutcomeDenied, projectID: "alpha", after: time.Minute},
+				{outcome: OutcomeDenied, projectID: "alpha", after: time.Minute},
+				{outcome: OutcomeDenied, projectID: "alpha", after: time.Minute},
+				{outcome: OutcomeAttested, projectID: "bravo"},
+			},
+			want: []string{"denied alpha/3"},
+		},
+		// 2: denials out of the window are not counted
+		{
+			config: &Config{Threshold: 2, Window: "10m"},
+			events: []event{
+				{outcome: OutcomeDenied, projectID: "alpha"},
+				{outcome: OutcomeDenied, projectID: "alpha", after: 11 * time.Minute},
+				{outcome: OutcomeDenied, projectID: "alpha", after: time.Minute},
+				{outcome: OutcomeAttested, projectID: "alpha"},
+			},
+			want: []string{"denied alpha/2", "attested alpha/0"},
+		},
+		// 3: automated responses don't act on observed decisions
+		{
+			config: &Config{Type: TypeMistral},
+			events: []event{
+				{outcome: OutcomeAttested, projectID: "alpha", observed: true},
+				{outcome: OutcomeDenied, projectID: "alpha", observed: true},
+				{outcome: OutcomeDenied, projectID: "bravo"},
+			},
+			want: []string{"denied bravo/0"},
+		},
+	}
+
+	for i, c := range tCase {
+		rec := &recordingHook{}
+		h, err := newTrigger(c.config, rec)
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		now := time.Unix(0, 0)
+		h.(*trigger).now = func() time.Time { return now }
+		for _, e := range c.events {
+			now = now.Add(e.after)
+			d := &Decision{ProjectID: e.projectID, Observed: e.observed}
+			switch e.outcome {
+			case OutcomeAttested:
+				h.OnAttested(d)
+			case OutcomeDenied:
+				h.OnDenied(d)
+			case OutcomeEvicted:
+				h.OnEvicted(d)
+			}
+			if d.Denials != 0 {
+				t.Errorf("#%v: the shared decision is modified: %+v", i, d)
+			}
+		}
+		if !reflect.DeepEqual(rec.notified, c.want) {
+			t.Errorf("#%v: got %v, want %v", i, rec.notified, c.want)
+		}
+	}
+}
+
+func TestTriggerNotConfigured(t *testing.T) {
+	rec := &recordingHook{}
+	h, err := newTrigger(&Config{}, rec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h != Hook(rec) {
+		t.Errorf("got %T, want the hook as is", h)
+	}
+}
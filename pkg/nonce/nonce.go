@@ -0,0 +1,165 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package nonce manages the nonces of the attestation challenges, and the nonces agents choose for their payloads.
+// Nonces are bound to a purpose and a subject, expire after a TTL and can be consumed only once.
+// They are persisted in a cache.Cache, so that servers sharing the backend can consume nonces issued by each other.
+package nonce
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/telemetry"
+)
+
+const (
+	keyPrefix = "nonce:"
+	size      = 32
+)
+
+var (
+	// ErrUnknown is returned for nonces never issued, already consumed or expired
+	ErrUnknown = errors.New("unknown nonce")
+	// ErrMismatch is returned for nonces issued for another purpose or subject
+	ErrMismatch = errors.New("nonce issued for another subject")
+	// ErrReplayed is returned for nonces of peers which were used before
+	ErrReplayed = errors.New("nonce used before")
+)
+
+var (
+	issuedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "nonce",
+		Name:      "issued_total",
+		Help:      "Number of nonces issued.",
+	}, []string{"purpose"})
+	consumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: telemetry.Namespace,
+		Subsystem: "nonce",
+		Name:      "consumed_total",
+		Help:      "Number of nonces presented for consumption, by result.",
+	}, []string{"purpose", "result"})
+)
+
+func init() {
+	telemetry.Registry.MustRegister(issuedTotal, consumedTotal)
+}
+
+type record struct {
+	Purpose string    `json:"purpose"`
+	Subject string    `json:"subject"`
+	Expires time.Time `json:"expires"`
+}
+
+// Manager issues and consumes nonces
+type Manager struct {
+	cache cache.Cache
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewManager returns a Manager persisting nonces in c, which expire after ttl
+func NewManager(c cache.Cache, ttl time.Duration) *Manager {
+	return &Manager{
+		cache: c,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+// Issue returns a new nonce for purpose and subject
+func (m *Manager) Issue(purpose, subject string) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+
+	v, err := json.Marshal(&record{
+		Purpose: purpose,
+		Subject: subject,
+		Expires: m.now().Add(m.ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := m.cache.Set(keyPrefix+nonce, v, m.ttl); err != nil {
+		return "", fmt.Errorf("failed to store nonce: %v", err)
+	}
+
+	issuedTotal.WithLabelValues(purpose).Inc()
+	return nonce, nil
+}
+
+// Consume verifies that nonce was issued for purpose and subject and invalidates it.
+// A nonce is invalidated even if the verification fails, so that it can't be guessed in turn.
+func (m *Manager) Consume(nonce, purpose, subject string) error {
+	err := m.consume(nonce, purpose, subject)
+
+	result := "ok"
+	switch err {
+	case nil:
+	case ErrUnknown:
+		result = "unknown"
+	case ErrMismatch:
+		result = "mismatch"
+	default:
+		result = "error"
+	}
+	consumedTotal.WithLabelValues(purpose, result).Inc()
+	return err
+}
+
+// Use records a nonce the peer chose for purpose and subject, e.g. of a payload it authenticated, for ttl, which must
+// cover the time the peer's message is valid for. Only the first use of a nonce succeeds; others fail with ErrReplayed.
+func (m *Manager) Use(nonce, purpose, subject string, ttl time.Duration) error {
+	// Issued nonces are base64url encoded, so that they don't collide with the keys of used ones
+	ok, err := m.cache.Add(keyPrefix+purpose+":"+subject+":"+nonce, []byte{}, ttl)
+
+	result := "ok"
+	switch {
+	case err != nil:
+		result = "error"
+		err = fmt.Errorf("failed to record nonce: %v", err)
+	case !ok:
+		result = "replayed"
+		err = ErrReplayed
+	}
+	consumedTotal.WithLabelValues(purpose, result).Inc()
+	return err
+}
+
+func (m *Manager) consume(nonce, purpose, subject string) error {
+	v, ok, err := m.cache.Take(keyPrefix + nonce)
+	if err != nil {
+		return fmt.Errorf("failed to consume nonce: %v", err)
+	}
+	if !ok {
+		return ErrUnknown
+	}
+
+	var r record
+	if err := json.Unmarshal(v, &r); err != nil {
+		return fmt.Errorf("failed to decode nonce: %v", err)
+	}
+	// backends may keep keys for a while after their TTL
+	if !m.now().Before(r.Expires) {
+		return ErrUnknown
+	}
+	if r.Purpose != purpose || r.Subject != subject {
+		return ErrMismatch
+	}
+	return nil
+}
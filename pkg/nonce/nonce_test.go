@@ -0,0 +1,114 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package nonce
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+)
+
+func TestConsume(t *testing.T) {
+	m := NewManager(cache.NewMemory(), time.Minute)
+
+	n, err := m.Issue("keypair", "alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Consume(n, "keypair", "alpha"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := m.Consume(n, "keypair", "alpha"); err != ErrUnknown {
+		t.Errorf("unexpected error for consumed nonce: got %v, want %v", err, ErrUnknown)
+	}
+	if err := m.Consume("bravo", "keypair", "alpha"); err != ErrUnknown {
+		t.Errorf("unexpected error for unknown nonce: got %v, want %v", err, ErrUnknown)
+	}
+}
+
+func TestConsumeMismatch(t *testing.T) {
+	m := NewManager(cache.NewMemory(), time.Minute)
+
+	for _, c := range []struct {
+		purpose string
+		subject string
+	}{
+		{purpose: "keypair", subject: "bravo"},
+		{purpose: "metadata", subject: "alpha"},
+	} {
+		n, err := m.Issue("keypair", "alpha")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Consume(n, c.purpose, c.subject); err != ErrMismatch {
+			t.Errorf("unexpected error: got %v, want %v", err, ErrMismatch)
+		}
+		// failed attempts invalidate the nonce
+		if err := m.Consume(n, "keypair", "alpha"); err != ErrUnknown {
+			t.Errorf("unexpected error: got %v, want %v", err, ErrUnknown)
+		}
+	}
+}
+
+func TestConsumeExpired(t *testing.T) {
+	now := time.Now()
+	m := NewManager(cache.NewMemory(), time.Minute)
+	m.now = func() time.Time { return now }
+
+	n, err := m.Issue("keypair", "alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if err := m.Consume(n, "keypair", "alpha"); err != ErrUnknown {
+		t.Errorf("unexpected error for expired nonce: got %v, want %v", err, ErrUnknown)
+	}
+}
+
+func TestIssueUnique(t *testing.T) {
+	m := NewManager(cache.NewMemory(), time.Minute)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		n, err := m.Issue("keypair", "alpha")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[n] {
+			t.Fatalf("duplicate nonce: %v", n)
+		}
+		seen[n] = true
+	}
+}
+
+func TestUse(t *testing.T) {
+	m := NewManager(cache.NewMemory(), time.Minute)
+
+	if err := m.Use("charlie", "payload_hmac", "alpha", time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := m.Use("charlie", "payload_hmac", "alpha", time.Minute); err != ErrReplayed {
+		t.Errorf("unexpected error for used nonce: got %v, want %v", err, ErrReplayed)
+	}
+	// nonces are of the subject
+	if err := m.Use("charlie", "payload_hmac", "bravo", time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Used nonces aren't evicted to store others, which fail instead
+	m = NewManager(cache.NewBoundedMemory(cache.Limits{MaxEntries: 1}, nil, ""), time.Minute)
+	if err := m.Use("charlie", "payload_hmac", "alpha", time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := m.Use("delta", "payload_hmac", "alpha", time.Minute); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	if err := m.Use("charlie", "payload_hmac", "alpha", time.Minute); err != ErrReplayed {
+		t.Errorf("unexpected error for used nonce: got %v, want %v", err, ErrReplayed)
+	}
+}
@@ -0,0 +1,134 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// EndpointAllowlist pins the OpenStack APIs the plugin talks to, so that a poisoned service catalog can't direct
+// the verification of instances to hosts of the attacker.
+type EndpointAllowlist struct {
+	// Hosts of the APIs, e.g. "keystone.example.com" or "nova.example.com:8774". Hosts without a port match any port.
+	Hosts []string `hcl:"hosts"`
+	// Hex encoded SHA-256 fingerprints of the CA certificates the APIs must chain to, which may be separated by
+	// colons. HTTPS is required if set.
+	CASHA256 []string `hcl:"ca_sha256"`
+}
+
+// validate parses the fingerprints of the allowlist
+func (l *EndpointAllowlist) validate() ([][]byte, error) {
+	if len(l.Hosts) == 0 {
+		return nil, errors.New("endpoint_allowlist requires hosts")
+	}
+	for _, h := range l.Hosts {
+		if h == "" || strings.Contains(h, "/") {
+			return nil, fmt.Errorf("invalid host in endpoint_allowlist: %q", h)
+		}
+	}
+	var fingerprints [][]byte
+	for _, f := range l.CASHA256 {
+		b, err := hex.DecodeString(strings.Replace(f, ":", "", -1))
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid ca_sha256 in endpoint_allowlist: %q", f)
+		}
+		fingerprints = append(fingerprints, b)
+	}
+	return fingerprints, nil
+}
+
+// allows returns an error unless the URL is of an allowed host, and of HTTPS if CAs are pinned
+func (l *EndpointAllowlist) allows(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if len(l.CASHA256) > 0 && u.Scheme != "https" {
+		return fmt.Errorf("%v is not HTTPS, which endpoint_allowlist requires with ca_sha256", rawurl)
+	}
+	for _, h := range l.Hosts {
+		if _, _, err := net.SplitHostPort(h); err == nil {
+			if strings.EqualFold(h, u.Host) {
+				return nil
+			}
+		} else if strings.EqualFold(h, u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v is not in endpoint_allowlist", u.Host)
+}
+
+// verifyChains returns a function verifying that the certificate of the API chains to a pinned CA
+func verifyChains(fingerprints [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.Raw)
+				for _, f := range fingerprints {
+					if string(sum[:]) == string(f) {
+						return nil
+					}
+				}
+			}
+		}
+		return errors.New("certificate doesn't chain to a CA of endpoint_allowlist")
+	}
+}
+
+// allowlistTransport refuses requests to hosts outside of the allowlist
+type allowlistTransport struct {
+	allowlist *EndpointAllowlist
+	base      http.RoundTripper
+}
+
+func (t *allowlistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.allowlist.allows(req.URL.String()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// allowsKeystone returns an error unless the Keystone to authenticate with is in the allowlist
+func (a *AuthConfig) allowsKeystone(authOpts *gophercloud.AuthOptions) error {
+	if a == nil || a.EndpointAllowlist == nil {
+		return nil
+	}
+	if err := a.EndpointAllowlist.allows(authOpts.IdentityEndpoint); err != nil {
+		return fmt.Errorf("keystone refused: %v", err)
+	}
+	return nil
+}
+
+// pinCatalog makes the provider fail to locate endpoints of the service catalog outside of the allowlist,
+// which tells catalog poisoning apart from failures of the APIs.
+func (a *AuthConfig) pinCatalog(provider *gophercloud.ProviderClient) {
+	if a == nil || a.EndpointAllowlist == nil || provider.EndpointLocator == nil {
+		return
+	}
+	locate := provider.EndpointLocator
+	provider.EndpointLocator = func(opts gophercloud.EndpointOpts) (string, error) {
+		u, err := locate(opts)
+		if err != nil {
+			return "", err
+		}
+		if err := a.EndpointAllowlist.allows(u); err != nil {
+			return "", fmt.Errorf("%v endpoint in the service catalog refused: %v", opts.Type, err)
+		}
+		return u, nil
+	}
+}
@@ -0,0 +1,148 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestEndpointAllowlist(t *testing.T) {
+	tCase := []struct {
+		allowlist *EndpointAllowlist
+		url       string
+		wantErr   bool
+	}{
+		// 0: host without port matches any port
+		{allowlist: &EndpointAllowlist{Hosts: []string{"keystone.example.com"}}, url: "https://keystone.example.com:5000/v3"},
+		// 1: hosts are compared regardless of case
+		{allowlist: &EndpointAllowlist{Hosts: []string{"Nova.example.com"}}, url: "https://nova.EXAMPLE.com/v2.1"},
+		// 2: host with port matches the port only
+		{allowlist: &EndpointAllowlist{Hosts: []string{"nova.example.com:8774"}}, url: "https://nova.example.com:8774/v2.1"},
+		// 3: other port
+		{allowlist: &EndpointAllowlist{Hosts: []string{"nova.example.com:8774"}}, url: "https://nova.example.com/v2.1", wantErr: true},
+		// 4: other host
+		{allowlist: &EndpointAllowlist{Hosts: []string{"nova.example.com"}}, url: "https://nova.example.com.attacker.test/v2.1", wantErr: true},
+		// 5: HTTP with pinned CAs
+		{allowlist: &EndpointAllowlist{Hosts: []string{"nova.example.com"}, CASHA256: []string{"00"}}, url: "http://nova.example.com/v2.1", wantErr: true},
+		// 6: IPv6 address
+		{allowlist: &EndpointAllowlist{Hosts: []string{"fd00::1"}}, url: "https://[fd00::1]:5000/v3"},
+	}
+
+	for i, c := range tCase {
+		err := c.allowlist.allows(c.url)
+		if (err != nil) != c.wantErr {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestEndpointAllowlistValidate(t *testing.T) {
+	sum := sha256.Sum256([]byte("ca"))
+	fingerprint := hex.EncodeToString(sum[:])
+	colons := ""
+	for i := 0; i < len(fingerprint); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += fingerprint[i : i+2]
+	}
+
+	tCase := []struct {
+		allowlist *EndpointAllowlist
+		wantErr   bool
+	}{
+		// 0: hosts only
+		{allowlist: &EndpointAllowlist{Hosts: []string{"keystone.example.com"}}},
+		// 1: fingerprints with and without colons
+		{allowlist: &EndpointAllowlist{Hosts: []string{"keystone.example.com"}, CASHA256: []string{fingerprint, colons}}},
+		// 2: no hosts
+		{allowlist: &EndpointAllowlist{}, wantErr: true},
+		// 3: URL instead of host
+		{allowlist: &EndpointAllowlist{Hosts: []string{"https://keystone.example.com/v3"}}, wantErr: true},
+		// 4: fingerprint of other hash
+		{allowlist: &EndpointAllowlist{Hosts: []string{"keystone.example.com"}, CASHA256: []string{fingerprint[:40]}}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		fingerprints, err := c.allowlist.validate()
+		if (err != nil) != c.wantErr {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if err == nil && len(fingerprints) != len(c.allowlist.CASHA256) {
+			t.Errorf("#%v: got %v fingerprints, want %v", i, len(fingerprints), len(c.allowlist.CASHA256))
+		}
+	}
+}
+
+func TestVerifyChains(t *testing.T) {
+	leaf := &x509.Certificate{Raw: []byte("leaf")}
+	ca := &x509.Certificate{Raw: []byte("ca")}
+	sum := sha256.Sum256(ca.Raw)
+	verify := verifyChains([][]byte{sum[:]})
+
+	if err := verify(nil, [][]*x509.Certificate{{leaf, ca}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := verify(nil, [][]*x509.Certificate{{leaf}}); err == nil {
+		t.Error("expected error for a chain without the pinned CA, got nil")
+	}
+}
+
+func TestPinCatalog(t *testing.T) {
+	auth := &AuthConfig{EndpointAllowlist: &EndpointAllowlist{Hosts: []string{"nova.example.com"}}}
+	provider := &gophercloud.ProviderClient{
+		EndpointLocator: func(opts gophercloud.EndpointOpts) (string, error) {
+			if opts.Type == "compute" {
+				return "https://nova.example.com/v2.1/", nil
+			}
+			return "https://attacker.test/", nil
+		},
+	}
+	auth.pinCatalog(provider)
+
+	if _, err := provider.EndpointLocator(gophercloud.EndpointOpts{Type: "compute"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := provider.EndpointLocator(gophercloud.EndpointOpts{Type: "network"}); err == nil {
+		t.Error("expected error for an endpoint outside of the allowlist, got nil")
+	}
+}
+
+func TestHTTPClientEndpointAllowlist(t *testing.T) {
+	sum := sha256.Sum256([]byte("ca"))
+	auth := &AuthConfig{EndpointAllowlist: &EndpointAllowlist{
+		Hosts:    []string{"keystone.example.com"},
+		CASHA256: []string{hex.EncodeToString(sum[:])},
+	}}
+	client, err := auth.httpClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	transport, ok := client.Transport.(*allowlistTransport)
+	if !ok {
+		t.Fatalf("unexpected transport: %T", client.Transport)
+	}
+	if base := transport.base.(*http.Transport); base.TLSClientConfig == nil || base.TLSClientConfig.VerifyPeerCertificate == nil {
+		t.Error("CAs are not pinned")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://attacker.test/v3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected error for a host outside of the allowlist, got nil")
+	}
+}
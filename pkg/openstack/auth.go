@@ -0,0 +1,228 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// AuthConfig overrides authentication options of the cloud entry in clouds.yaml.
+// Secrets can be read from files, environment variables or Barbican instead of the plugin config.
+type AuthConfig struct {
+	AuthURL           string `hcl:"auth_url"`
+	Username          string `hcl:"username"`
+	UserID            string `hcl:"user_id"`
+	Password          string `hcl:"password"`
+	PasswordFile      string `hcl:"password_file"`
+	DomainName        string `hcl:"domain_name"`
+	DomainID          string `hcl:"domain_id"`
+	ProjectName       string `hcl:"project_name"`
+	ProjectID         string `hcl:"project_id"`
+	ProjectDomainName string `hcl:"project_domain_name"`
+	ProjectDomainID   string `hcl:"project_domain_id"`
+
+	ApplicationCredentialID         string `hcl:"application_credential_id"`
+	ApplicationCredentialName       string `hcl:"application_credential_name"`
+	ApplicationCredentialSecret     string `hcl:"application_credential_secret"`
+	ApplicationCredentialSecretFile string `hcl:"application_credential_secret_file"`
+	// JSON file holding the ID and secret of the application credential, read again on each authentication, so that
+	// credentials rotated by credential_rotator are used without reconfiguration. See CredentialFile.
+	ApplicationCredentialFile string `hcl:"application_credential_file"`
+
+	// Barbican secret holding the password or application credential secret.
+	BarbicanSecretRef string `hcl:"barbican_secret_ref"`
+	// Name of cloud entry in clouds.yaml used to retrieve the Barbican secret.
+	BarbicanCloudName string `hcl:"barbican_cloud_name"`
+
+	// Proxy for OpenStack APIs, e.g. "socks5://127.0.0.1:1080" or "http://proxy:3128".
+	Proxy string `hcl:"proxy"`
+	// Network namespace to connect to OpenStack APIs from, e.g. "/var/run/netns/mgmt". Linux only.
+	NetworkNamespace string `hcl:"network_namespace"`
+
+	// File to persist the token and service catalog to, which is used while Keystone is unavailable.
+	TokenCacheFile string `hcl:"token_cache_file"`
+	// File containing a base64 encoded 32-byte key to encrypt the token cache with. Not encrypted if empty.
+	TokenCacheKeyFile string `hcl:"token_cache_key_file"`
+
+	// Pins the hosts of Keystone and the APIs of the service catalog, and the CAs they chain to, if set.
+	EndpointAllowlist *EndpointAllowlist `hcl:"endpoint_allowlist"`
+}
+
+// NewProviderWithAuth returns a new authenticated ProviderClient.
+// Options of the cloud entry are overridden by given AuthConfig, if any.
+func NewProviderWithAuth(ctx context.Context, cloudName string, auth *AuthConfig) (*gophercloud.ProviderClient, error) {
+	if auth == nil {
+		return NewProvider(ctx, cloudName)
+	}
+
+	authOpts, err := auth.authOptions(ctx, cloudName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := auth.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.allowsKeystone(authOpts); err != nil {
+		return nil, err
+	}
+	provider, err := authenticate(ctx, authOpts, client)
+	if err == nil {
+		auth.pinCatalog(provider)
+		auth.reauthFromCredentialFile(provider, cloudName)
+	}
+	if auth.TokenCacheFile == "" {
+		return provider, err
+	}
+	if err != nil {
+		if isKeystoneRejection(err) {
+			return nil, err
+		}
+		provider, cacheErr := auth.restoreToken(authOpts, client)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("%v (persisted token unavailable: %v)", err, cacheErr)
+		}
+		auth.pinCatalog(provider)
+		auth.reauthFromCredentialFile(provider, cloudName)
+		return provider, nil
+	}
+	if err := auth.persistToken(authOpts, provider); err != nil {
+		return nil, fmt.Errorf("failed to persist token: %v", err)
+	}
+	// The persisted token is used by later processes while Keystone is unavailable, and must not be revoked
+	untrack(provider)
+	return provider, nil
+}
+
+// NewProviderAt returns a new ProviderClient authenticated like NewProviderWithAuth, but at given Keystone endpoint,
+// e.g. the one of another region. The token cache is not used, since it holds the token of the other endpoint.
+func NewProviderAt(ctx context.Context, cloudName string, auth *AuthConfig, authURL string) (*gophercloud.ProviderClient, error) {
+	if authURL == "" {
+		return NewProviderWithAuth(ctx, cloudName, auth)
+	}
+
+	var authOpts *gophercloud.AuthOptions
+	var client *http.Client
+	var err error
+	if auth == nil {
+		authOpts, err = cloudAuthOptions(cloudName)
+	} else {
+		authOpts, err = auth.authOptions(ctx, cloudName)
+		if err == nil {
+			client, err = auth.httpClient()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	authOpts.IdentityEndpoint = common.ExpandEnv(authURL)
+	if err := auth.allowsKeystone(authOpts); err != nil {
+		return nil, err
+	}
+	provider, err := authenticate(ctx, authOpts, client)
+	if err != nil {
+		return nil, err
+	}
+	auth.pinCatalog(provider)
+	return provider, nil
+}
+
+// ScopedTo returns a copy of the options scoped to the project. Options of the cloud entry are used if a is nil.
+// The token cache is disabled in the copy, since it holds a single token.
+func (a *AuthConfig) ScopedTo(projectID string) *AuthConfig {
+	scoped := &AuthConfig{}
+	if a != nil {
+		*scoped = *a
+	}
+	scoped.ProjectID = projectID
+	// Keystone rejects project IDs qualified with a domain
+	scoped.ProjectName = ""
+	scoped.ProjectDomainName = ""
+	scoped.ProjectDomainID = ""
+	scoped.TokenCacheFile = ""
+	scoped.TokenCacheKeyFile = ""
+	return scoped
+}
+
+func (a *AuthConfig) authOptions(ctx context.Context, cloudName string) (*gophercloud.AuthOptions, error) {
+	authOpts := &gophercloud.AuthOptions{}
+	if a.AuthURL == "" {
+		opts, err := cloudAuthOptions(cloudName)
+		if err != nil {
+			return nil, err
+		}
+		authOpts = opts
+	}
+	if a.ApplicationCredentialFile != "" {
+		override(&authOpts.IdentityEndpoint, common.ExpandEnv(a.AuthURL))
+		if err := a.applyCredentialFile(authOpts); err != nil {
+			return nil, err
+		}
+		return authOpts, nil
+	}
+
+	password, err := common.ResolveSecret("password", a.Password, a.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	appCredSecret, err := common.ResolveSecret("application_credential_secret", a.ApplicationCredentialSecret, a.ApplicationCredentialSecretFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.BarbicanSecretRef != "" {
+		if password != "" || appCredSecret != "" {
+			return nil, errors.New("barbican_secret_ref and inline secrets are mutually exclusive")
+		}
+		secret, err := GetBarbicanSecret(ctx, a.BarbicanCloudName, a.BarbicanSecretRef)
+		if err != nil {
+			return nil, err
+		}
+		if a.ApplicationCredentialID != "" || a.ApplicationCredentialName != "" {
+			appCredSecret = secret
+		} else {
+			password = secret
+		}
+	}
+
+	override(&authOpts.IdentityEndpoint, common.ExpandEnv(a.AuthURL))
+	override(&authOpts.Username, common.ExpandEnv(a.Username))
+	override(&authOpts.UserID, common.ExpandEnv(a.UserID))
+	override(&authOpts.Password, password)
+	override(&authOpts.DomainName, common.ExpandEnv(a.DomainName))
+	override(&authOpts.DomainID, common.ExpandEnv(a.DomainID))
+	override(&authOpts.ApplicationCredentialID, common.ExpandEnv(a.ApplicationCredentialID))
+	override(&authOpts.ApplicationCredentialName, common.ExpandEnv(a.ApplicationCredentialName))
+	override(&authOpts.ApplicationCredentialSecret, appCredSecret)
+
+	if a.ProjectID != "" || a.ProjectName != "" {
+		authOpts.Scope = &gophercloud.AuthScope{
+			ProjectID:   common.ExpandEnv(a.ProjectID),
+			ProjectName: common.ExpandEnv(a.ProjectName),
+			DomainName:  common.ExpandEnv(a.ProjectDomainName),
+			DomainID:    common.ExpandEnv(a.ProjectDomainID),
+		}
+		authOpts.TenantID = ""
+		authOpts.TenantName = ""
+	}
+
+	return authOpts, nil
+}
+
+func override(to *string, v string) {
+	if v != "" {
+		*to = v
+	}
+}
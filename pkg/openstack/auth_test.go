@@ -0,0 +1,75 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestAuthOptions(t *testing.T) {
+	os.Setenv("SPIRE_OPENSTACK_TEST_SECRET", "alpha")
+	defer os.Unsetenv("SPIRE_OPENSTACK_TEST_SECRET")
+
+	a := &AuthConfig{
+		AuthURL:                     "https://keystone.example.com/v3",
+		ApplicationCredentialID:     "bravo",
+		ApplicationCredentialSecret: "${SPIRE_OPENSTACK_TEST_SECRET}",
+		ProjectID:                   "charlie",
+	}
+
+	opts, err := a.authOptions(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.IdentityEndpoint != a.AuthURL {
+		t.Errorf("got %v, want %v", opts.IdentityEndpoint, a.AuthURL)
+	}
+	if opts.ApplicationCredentialSecret != "alpha" {
+		t.Errorf("got %v, want %v", opts.ApplicationCredentialSecret, "alpha")
+	}
+	if opts.Scope == nil || opts.Scope.ProjectID != "charlie" {
+		t.Errorf("unexpected scope: %+v", opts.Scope)
+	}
+}
+
+func TestAuthOptionsMutuallyExclusive(t *testing.T) {
+	a := &AuthConfig{
+		AuthURL:      "https://keystone.example.com/v3",
+		Password:     "alpha",
+		PasswordFile: "/path/to/password",
+	}
+
+	if _, err := a.authOptions(context.Background(), ""); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
+
+func TestAuthConfigScopedTo(t *testing.T) {
+	a := &AuthConfig{
+		Username:          "alpha",
+		ProjectName:       "bravo",
+		ProjectDomainName: "Default",
+		TokenCacheFile:    "/path/to/token",
+	}
+
+	scoped := a.ScopedTo("charlie")
+	if scoped.Username != "alpha" || scoped.ProjectID != "charlie" || scoped.ProjectName != "" || scoped.ProjectDomainName != "" {
+		t.Errorf("unexpected options: %+v", scoped)
+	}
+	if scoped.TokenCacheFile != "" {
+		t.Errorf("token cache must be disabled: %+v", scoped)
+	}
+	if a.ProjectName != "bravo" {
+		t.Errorf("original options modified: %+v", a)
+	}
+	if (*AuthConfig)(nil).ScopedTo("charlie").ProjectID != "charlie" {
+		t.Error("unexpected options of the cloud entry")
+	}
+}
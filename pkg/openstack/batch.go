@@ -0,0 +1,100 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchVerifier verifies many instances against Nova at once. Instead of one API call per instance, the instances
+// are listed page by page and the listing is shared by all verifications until it expires, which keeps the number
+// of API calls of tools working on large fleets constant.
+type BatchVerifier struct {
+	lister     InstanceLister
+	allTenants bool
+	ttl        time.Duration
+
+	mtx      sync.Mutex
+	servers  map[string]*Server
+	listedAt time.Time
+	now      func() time.Time
+}
+
+// NewBatchVerifier returns a BatchVerifier listing instances with lister. The listing is reused for ttl;
+// zero reuses it for the lifetime of the verifier, which suits one-shot tools.
+func NewBatchVerifier(lister InstanceLister, allTenants bool, ttl time.Duration) *BatchVerifier {
+	return &BatchVerifier{
+		lister:     lister,
+		allTenants: allTenants,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Verify returns the live instances among uuids keyed by their IDs, and the uuids not found.
+func (v *BatchVerifier) Verify(ctx context.Context, uuids []string) (map[string]*Server, []string, error) {
+	servers, err := v.listing(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[string]*Server)
+	var missing []string
+	for _, uuid := range uuids {
+		if s, ok := servers[uuid]; ok {
+			found[uuid] = s
+		} else {
+			missing = append(missing, uuid)
+		}
+	}
+	return found, missing, nil
+}
+
+// List returns all instances of the shared listing
+func (v *BatchVerifier) List(ctx context.Context) ([]*Server, error) {
+	servers, err := v.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sl := make([]*Server, 0, len(servers))
+	for _, s := range servers {
+		sl = append(sl, s)
+	}
+	return sl, nil
+}
+
+// Invalidate discards the shared listing
+func (v *BatchVerifier) Invalidate() {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	v.servers = nil
+}
+
+// listing returns the shared listing, refreshing it if expired. Concurrent callers wait for a single refresh.
+func (v *BatchVerifier) listing(ctx context.Context) (map[string]*Server, error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if v.servers != nil && (v.ttl == 0 || v.now().Sub(v.listedAt) < v.ttl) {
+		return v.servers, nil
+	}
+
+	sl, err := v.lister.List(ctx, v.allTenants)
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]*Server, len(sl))
+	for _, s := range sl {
+		servers[s.ID] = s
+	}
+	v.servers = servers
+	v.listedAt = v.now()
+	return servers, nil
+}
@@ -0,0 +1,72 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+type countingLister struct {
+	calls   int
+	servers []*Server
+}
+
+func (l *countingLister) List(ctx context.Context, allTenants bool) ([]*Server, error) {
+	l.calls++
+	return l.servers, nil
+}
+
+func TestBatchVerifier(t *testing.T) {
+	lister := &countingLister{
+		servers: []*Server{
+			{Server: servers.Server{ID: "alpha"}},
+			{Server: servers.Server{ID: "bravo"}},
+		},
+	}
+	now := time.Unix(0, 0)
+	v := NewBatchVerifier(lister, true, time.Minute)
+	v.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	found, missing, err := v.Verify(ctx, []string{"alpha", "charlie", "delta"})
+	if err != nil {
+		t.Fatalf("error from Verify(): %v", err)
+	}
+	if len(found) != 1 || found["alpha"] == nil {
+		t.Errorf("unexpected found instances: %v", found)
+	}
+	if !reflect.DeepEqual(missing, []string{"charlie", "delta"}) {
+		t.Errorf("unexpected missing instances: %v", missing)
+	}
+
+	if sl, err := v.List(ctx); err != nil || len(sl) != 2 {
+		t.Errorf("unexpected listing: %v, %v", sl, err)
+	}
+	if lister.calls != 1 {
+		t.Errorf("expected a shared listing, got %v calls", lister.calls)
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, err := v.Verify(ctx, []string{"alpha"}); err != nil {
+		t.Fatalf("error from Verify(): %v", err)
+	}
+	if lister.calls != 2 {
+		t.Errorf("expected an expired listing to be refreshed, got %v calls", lister.calls)
+	}
+
+	v.Invalidate()
+	v.Verify(ctx, nil)
+	if lister.calls != 3 {
+		t.Errorf("expected an invalidated listing to be refreshed, got %v calls", lister.calls)
+	}
+}
@@ -0,0 +1,44 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/perf"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// BenchmarkCachedInstance measures lookups of an instance found in the cache, which decode the cached server
+func BenchmarkCachedInstance(b *testing.B) {
+	b.ReportAllocs()
+	ci := &countingInstance{}
+	i := NewCachedInstance(ci, cache.NewMemory(), time.Hour, 0, testutil.TestLogger())
+	ctx := context.Background()
+	if _, err := i.Get(ctx, "123"); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := i.Get(ctx, "123"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if ci.calls != 1 {
+		b.Fatalf("got %v calls, want 1", ci.calls)
+	}
+}
+
+func TestPerformanceBudget(t *testing.T) {
+	perf.Check(t, []perf.Benchmark{
+		{Name: "CachedInstance", F: BenchmarkCachedInstance, Budget: perf.Budget{NsPerOp: 50000}},
+	})
+}
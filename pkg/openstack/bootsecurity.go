@@ -0,0 +1,138 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// trustedCertificatesMicroversion is the first compute API microversion showing trusted_image_certificates
+	trustedCertificatesMicroversion = "2.63"
+
+	// SecureBootRequired is the os_secure_boot image property of instances Nova boots with secure boot only
+	SecureBootRequired = "required"
+	// FirmwareTypeUEFI is the hw_firmware_type image property of instances booting with UEFI
+	FirmwareTypeUEFI = "uefi"
+)
+
+// BootSecurity holds the attributes of an instance related to the security of its boot. Attributes which Nova or
+// Glance don't expose are empty.
+type BootSecurity struct {
+	// TrustedImageCertificates are the IDs of the certificates the signature of the image was verified with
+	TrustedImageCertificates []string `json:"trusted_image_certificates,omitempty"`
+	// SecureBoot is the os_secure_boot property of the image, e.g. "required"
+	SecureBoot string `json:"os_secure_boot,omitempty"`
+	// FirmwareType is the hw_firmware_type property of the image, e.g. "uefi"
+	FirmwareType string `json:"hw_firmware_type,omitempty"`
+}
+
+type BootSecurityClient interface {
+	// GetBootSecurity retrieves the boot security attributes of the server from Nova and Glance
+	GetBootSecurity(ctx context.Context, s *Server) (*BootSecurity, error)
+}
+
+// BootSecurityService represents OpenStack Compute and Image Service clients for boot security attributes
+type BootSecurityService struct {
+	Logger  hclog.Logger
+	compute *gophercloud.ServiceClient
+	image   *gophercloud.ServiceClient
+}
+
+// NewBootSecurity returns new OpenStack Compute and Image Service clients for boot security attributes with given
+// provider
+func NewBootSecurity(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (BootSecurityClient, error) {
+	compute, err := openstack.NewComputeV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	image, err := openstack.NewImageServiceV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BootSecurityService{
+		Logger:  logger,
+		compute: compute,
+		image:   image,
+	}, nil
+}
+
+func (b *BootSecurityService) GetBootSecurity(ctx context.Context, s *Server) (*BootSecurity, error) {
+	b.Logger.Debug("Get Boot Security", "uuid", s.ID, "request_id", RequestID(ctx))
+
+	bs := &BootSecurity{}
+	certs, err := b.trustedImageCertificates(ctx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	bs.TrustedImageCertificates = certs
+
+	// Instances booted from volume have no image, whose properties are on the volume instead
+	imageID, _ := s.Image["id"].(string)
+	if imageID == "" {
+		return bs, nil
+	}
+	var image struct {
+		SecureBoot   string `json:"os_secure_boot"`
+		FirmwareType string `json:"hw_firmware_type"`
+	}
+	sc := withContext(ctx, b.image)
+	_, err = sc.Get(sc.ServiceURL("images", imageID), &image, &gophercloud.RequestOpts{
+		OkCodes:     []int{200},
+		MoreHeaders: requestIDHeaders(ctx),
+	})
+	switch err.(type) {
+	case nil:
+		bs.SecureBoot = image.SecureBoot
+		bs.FirmwareType = image.FirmwareType
+	case gophercloud.ErrDefault404:
+		// The image was deleted since the instance was booted from it
+		b.Logger.Debug("Image of the instance not found", "uuid", s.ID, "image_id", imageID)
+	default:
+		return nil, err
+	}
+	return bs, nil
+}
+
+// trustedImageCertificates retrieves the trusted_image_certificates of the server, which Nova shows from microversion
+// 2.63. None are returned by older Nova, which can't verify image signatures with trusted certificates.
+func (b *BootSecurityService) trustedImageCertificates(ctx context.Context, uuid string) ([]string, error) {
+	headers := requestIDHeaders(ctx)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["X-OpenStack-Nova-API-Version"] = trustedCertificatesMicroversion
+
+	var resp struct {
+		Server struct {
+			TrustedImageCertificates []string `json:"trusted_image_certificates"`
+		} `json:"server"`
+	}
+	sc := withContext(ctx, b.compute)
+	_, err := sc.Get(sc.ServiceURL("servers", uuid), &resp, &gophercloud.RequestOpts{
+		OkCodes:     []int{200, 203},
+		MoreHeaders: headers,
+	})
+	if e, ok := err.(gophercloud.ErrUnexpectedResponseCode); ok && e.Actual == http.StatusNotAcceptable {
+		b.Logger.Debug("Compute API doesn't support trusted image certificates", "microversion", trustedCertificatesMicroversion)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Server.TrustedImageCertificates, nil
+}
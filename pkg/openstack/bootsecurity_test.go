@@ -0,0 +1,104 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestGetBootSecurity(t *testing.T) {
+	tCase := []struct {
+		image        map[string]interface{}
+		microversion bool
+		imageStatus  int
+		want         *BootSecurity
+		wantErr      bool
+	}{
+		// 0: all attributes
+		{
+			image:        map[string]interface{}{"id": "image"},
+			microversion: true,
+			want:         &BootSecurity{TrustedImageCertificates: []string{"cert"}, SecureBoot: SecureBootRequired, FirmwareType: FirmwareTypeUEFI},
+		},
+		// 1: Nova predating trusted image certificates
+		{
+			image: map[string]interface{}{"id": "image"},
+			want:  &BootSecurity{SecureBoot: SecureBootRequired, FirmwareType: FirmwareTypeUEFI},
+		},
+		// 2: booted from volume
+		{microversion: true, want: &BootSecurity{TrustedImageCertificates: []string{"cert"}}},
+		// 3: image deleted
+		{
+			image:        map[string]interface{}{"id": "image"},
+			microversion: true,
+			imageStatus:  http.StatusNotFound,
+			want:         &BootSecurity{TrustedImageCertificates: []string{"cert"}},
+		},
+		// 4: Glance unavailable
+		{image: map[string]interface{}{"id": "image"}, microversion: true, imageStatus: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/compute/servers/alpha":
+				if !c.microversion || r.Header.Get("X-OpenStack-Nova-API-Version") != trustedCertificatesMicroversion {
+					w.WriteHeader(http.StatusNotAcceptable)
+					return
+				}
+				fmt.Fprint(w, `{"server": {"id": "alpha", "trusted_image_certificates": ["cert"]}}`)
+			case "/image/images/image":
+				if c.imageStatus != 0 {
+					w.WriteHeader(c.imageStatus)
+					return
+				}
+				fmt.Fprint(w, `{"id": "image", "os_secure_boot": "required", "hw_firmware_type": "uefi"}`)
+			default:
+				t.Errorf("#%v: unexpected request: %v", i, r.URL.Path)
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		provider, err := openstack.NewClient(ts.URL + "/v3/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &BootSecurityService{
+			Logger:  testutil.TestLogger(),
+			compute: &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: ts.URL + "/compute/"},
+			image:   &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: ts.URL + "/image/"},
+		}
+
+		bs, err := b.GetBootSecurity(context.Background(), &Server{Server: servers.Server{ID: "alpha", Image: c.image}})
+		ts.Close()
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(bs, c.want) {
+			t.Errorf("#%v: got %+v, want %+v", i, bs, c.want)
+		}
+	}
+}
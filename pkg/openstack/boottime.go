@@ -0,0 +1,48 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetBootTime gets the time the system booted at. The guest boots when Nova launches the instance, so it is no earlier
+// than the launched_at of the instance in Nova but for the clock skew, and later once the guest is rebooted.
+func GetBootTime() (time.Time, error) {
+	t, err := readBootTime()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading boot time: %v", err)
+	}
+	return t, nil
+}
+
+// parseProcStatBootTime parses the boot time from the "btime" line of /proc/stat, in seconds since the epoch
+func parseProcStatBootTime(r io.Reader) (time.Time, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || sec <= 0 {
+			return time.Time{}, fmt.Errorf("invalid btime %q", fields[1])
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if err := s.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("no btime")
+}
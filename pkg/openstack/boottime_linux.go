@@ -0,0 +1,25 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"os"
+	"time"
+)
+
+// procStatPath is the kernel statistics, whose "btime" is the boot time
+const procStatPath = "/proc/stat"
+
+func readBootTime() (time.Time, error) {
+	f, err := os.Open(procStatPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	return parseProcStatBootTime(f)
+}
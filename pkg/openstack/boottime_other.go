@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+	"time"
+)
+
+func readBootTime() (time.Time, error) {
+	return time.Time{}, errors.New("boot time is supported only on Linux")
+}
@@ -0,0 +1,48 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcStatBootTime(t *testing.T) {
+	tCase := []struct {
+		stat    string
+		want    time.Time
+		wantErr bool
+	}{
+		// 0: btime among the other statistics
+		{stat: "cpu  10 0 20 300 0 0 0 0 0 0\nintr 42\nctxt 1000\nbtime 1577836800\nprocesses 100\n", want: time.Unix(1577836800, 0)},
+		// 1: no btime
+		{stat: "cpu  10 0 20 300 0 0 0 0 0 0\n", wantErr: true},
+		// 2: malformed btime
+		{stat: "btime yesterday\n", wantErr: true},
+		// 3: zero btime
+		{stat: "btime 0\n", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		got, err := parseProcStatBootTime(strings.NewReader(c.stat))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+)
+
+const (
+	instanceKeyPrefix = "instance:"
+	notFoundKeyPrefix = "instance-not-found:"
+)
+
+// CachedInstance is an InstanceClient which caches instances and negative lookups
+type CachedInstance struct {
+	Logger      hclog.Logger
+	client      InstanceClient
+	cache       cache.Cache
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// cachedServer is the serialized form of Server in the cache.
+// Image is stored separately since servers.Server doesn't marshal it.
+type cachedServer struct {
+	Server           servers.Server         `json:"server"`
+	Image            map[string]interface{} `json:"image"`
+	AvailabilityZone string                 `json:"availability_zone"`
+	Region           string                 `json:"region"`
+	LaunchedAt       time.Time              `json:"launched_at"`
+}
+
+// NewCachedInstance returns an InstanceClient which caches results of given client.
+// Instances are cached for ttl and instances not found for negativeTTL. Zero disables each cache.
+func NewCachedInstance(client InstanceClient, c cache.Cache, ttl, negativeTTL time.Duration, logger hclog.Logger) InstanceClient {
+	return &CachedInstance{
+		Logger:      logger,
+		client:      client,
+		cache:       c,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+// cachedNotFoundError is returned for instances found in the negative cache
+type cachedNotFoundError string
+
+func (e cachedNotFoundError) Error() string {
+	return fmt.Sprintf("instance not found (cached): %v", string(e))
+}
+
+// IsNotFound returns true if err means that the instance doesn't exist
+func IsNotFound(err error) bool {
+	switch err.(type) {
+	case gophercloud.ErrDefault404, cachedNotFoundError:
+		return true
+	}
+	return false
+}
+
+func (i *CachedInstance) Get(ctx context.Context, uuid string) (*Server, error) {
+	if s, ok := i.lookup(uuid); ok {
+		return s, nil
+	}
+	if i.negativeTTL > 0 {
+		if _, ok, err := i.cache.Get(notFoundKeyPrefix + uuid); err != nil {
+			i.Logger.Warn("Failed to lookup negative cache", "uuid", uuid, "error", err)
+		} else if ok {
+			return nil, cachedNotFoundError(uuid)
+		}
+	}
+
+	s, err := i.client.Get(ctx, uuid)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok && i.negativeTTL > 0 {
+			if err := i.cache.Set(notFoundKeyPrefix+uuid, []byte{}, i.negativeTTL); err != nil {
+				i.Logger.Warn("Failed to store negative cache", "uuid", uuid, "error", err)
+			}
+		}
+		return nil, err
+	}
+
+	i.store(s)
+	return s, nil
+}
+
+// Prime stores instances retrieved in bulk, e.g. by a BatchVerifier, so that later lookups don't call Nova
+func (i *CachedInstance) Prime(servers []*Server) {
+	for _, s := range servers {
+		i.store(s)
+	}
+}
+
+// Invalidate removes the instance and its negative lookup from the cache
+func (i *CachedInstance) Invalidate(uuid string) error {
+	if err := i.cache.Delete(instanceKeyPrefix + uuid); err != nil {
+		return err
+	}
+	return i.cache.Delete(notFoundKeyPrefix + uuid)
+}
+
+func (i *CachedInstance) lookup(uuid string) (*Server, bool) {
+	if i.ttl <= 0 {
+		return nil, false
+	}
+
+	b, ok, err := i.cache.Get(instanceKeyPrefix + uuid)
+	if err != nil {
+		i.Logger.Warn("Failed to lookup instance cache", "uuid", uuid, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	s, err := unmarshalServer(b)
+	if err != nil {
+		i.Logger.Warn("Failed to decode cached instance", "uuid", uuid, "error", err)
+		return nil, false
+	}
+
+	i.Logger.Debug("Found instance in cache", "uuid", uuid)
+	return s, true
+}
+
+func (i *CachedInstance) store(s *Server) {
+	if i.ttl <= 0 {
+		return
+	}
+
+	b, err := marshalServer(s)
+	if err != nil {
+		i.Logger.Warn("Failed to encode instance", "uuid", s.ID, "error", err)
+		return
+	}
+	if err := i.cache.Set(instanceKeyPrefix+s.ID, b, i.ttl); err != nil {
+		i.Logger.Warn("Failed to store instance cache", "uuid", s.ID, "error", err)
+	}
+}
+
+// marshalServer serializes the server with the attributes which servers.Server doesn't marshal
+func marshalServer(s *Server) ([]byte, error) {
+	return json.Marshal(&cachedServer{
+		Server:           s.Server,
+		Image:            s.Image,
+		AvailabilityZone: s.AvailabilityZone,
+		Region:           s.Region,
+		LaunchedAt:       s.LaunchedAt,
+	})
+}
+
+func unmarshalServer(b []byte) (*Server, error) {
+	var cs cachedServer
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return nil, err
+	}
+	cs.Server.Image = cs.Image
+	return &Server{
+		Server: cs.Server,
+		ServerAvailabilityZoneExt: availabilityzones.ServerAvailabilityZoneExt{
+			AvailabilityZone: cs.AvailabilityZone,
+		},
+		Region:     cs.Region,
+		LaunchedAt: cs.LaunchedAt,
+	}, nil
+}
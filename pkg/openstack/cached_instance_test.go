@@ -0,0 +1,89 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// testLaunchedAt is the time the servers of countingInstance were launched at
+var testLaunchedAt = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type countingInstance struct {
+	calls    int
+	notFound bool
+}
+
+func (c *countingInstance) Get(_ context.Context, uuid string) (*Server, error) {
+	c.calls++
+	if c.notFound {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &Server{
+		Server: servers.Server{
+			ID:       uuid,
+			TenantID: "alpha",
+			Image:    map[string]interface{}{"id": "image-1"},
+		},
+		Region:     "region-a",
+		LaunchedAt: testLaunchedAt,
+	}, nil
+}
+
+func TestCachedInstance(t *testing.T) {
+	ci := &countingInstance{}
+	i := NewCachedInstance(ci, cache.NewMemory(), time.Minute, 0, testutil.TestLogger())
+
+	for n := 0; n < 2; n++ {
+		s, err := i.Get(context.Background(), "123")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.TenantID != "alpha" || s.Region != "region-a" || s.Image["id"] != "image-1" || !s.LaunchedAt.Equal(testLaunchedAt) {
+			t.Errorf("unexpected server: %+v", s)
+		}
+	}
+	if ci.calls != 1 {
+		t.Errorf("got %v calls, want 1", ci.calls)
+	}
+}
+
+func TestCachedInstanceNotFound(t *testing.T) {
+	ci := &countingInstance{notFound: true}
+	i := NewCachedInstance(ci, cache.NewMemory(), time.Minute, time.Minute, testutil.TestLogger())
+
+	for n := 0; n < 2; n++ {
+		if _, err := i.Get(context.Background(), "123"); !IsNotFound(err) {
+			t.Errorf("not found error expected, got %v", err)
+		}
+	}
+	if ci.calls != 1 {
+		t.Errorf("got %v calls, want 1", ci.calls)
+	}
+}
+
+func TestCachedInstancePrime(t *testing.T) {
+	ci := &countingInstance{notFound: true}
+	i := NewCachedInstance(ci, cache.NewMemory(), time.Minute, 0, testutil.TestLogger()).(*CachedInstance)
+	i.Prime([]*Server{{Server: servers.Server{ID: "123", TenantID: "alpha"}}})
+
+	if s, err := i.Get(context.Background(), "123"); err != nil || s.TenantID != "alpha" {
+		t.Errorf("unexpected result: %+v, %v", s, err)
+	}
+	if ci.calls != 0 {
+		t.Errorf("got %v calls, want 0", ci.calls)
+	}
+}
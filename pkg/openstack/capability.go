@@ -0,0 +1,208 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+// Capabilities which can be verified by CheckCapabilities
+const (
+	// Retrieve a server by ID, which the attestor needs for any project it attests
+	CapabilityGetServer = "compute:get_server"
+	// List servers of all projects, which requires admin role
+	CapabilityListAllServers = "compute:list_all_servers"
+	// List Designate zones and recordsets
+	CapabilityListDNSZones = "dns:list_zones"
+	// List Neutron ports
+	CapabilityListPorts = "network:list_ports"
+	// List Keystone role assignments of projects
+	CapabilityListRoleAssignments = "identity:list_role_assignments"
+	// Hold the admin role in the scope of the token
+	CapabilityAdminRole = "identity:admin_role"
+)
+
+// adminCapabilities are the capabilities which are expected only in admin mode
+var adminCapabilities = map[string]bool{
+	CapabilityListAllServers: true,
+	CapabilityAdminRole:      true,
+}
+
+// nonexistentServerID is retrieved to see whether Nova denies or just doesn't find it
+const nonexistentServerID = "00000000-0000-0000-0000-000000000000"
+
+// Capability is the result of the verification of a capability
+type Capability struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Admin    bool   `json:"admin"`
+	Allowed  bool   `json:"allowed"`
+	// Error is set if the verification itself failed, e.g. the service has no endpoint
+	Error string `json:"error,omitempty"`
+}
+
+// CapabilityReport tells what the credentials of a cloud entry can do
+type CapabilityReport struct {
+	Cloud        string       `json:"cloud"`
+	Roles        []string     `json:"roles"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// Violations returns the deviations of the report from least privilege, i.e. required capabilities which are not
+// allowed, and admin capabilities which are allowed but not required, unless adminMode is true.
+func (r *CapabilityReport) Violations(adminMode bool) []string {
+	var sl []string
+	for _, c := range r.Capabilities {
+		switch {
+		case c.Required && !c.Allowed:
+			msg := fmt.Sprintf("%v is required but not allowed", c.Name)
+			if c.Error != "" {
+				msg += ": " + c.Error
+			}
+			sl = append(sl, msg)
+		case c.Admin && c.Allowed && !c.Required && !adminMode:
+			sl = append(sl, fmt.Sprintf("%v is allowed but requires admin mode", c.Name))
+		}
+	}
+	return sl
+}
+
+// String returns the report in a form suitable for logs
+func (r *CapabilityReport) String() string {
+	var sl []string
+	for _, c := range r.Capabilities {
+		s := fmt.Sprintf("%v=%v", c.Name, c.Allowed)
+		if c.Required {
+			s += "(required)"
+		}
+		sl = append(sl, s)
+	}
+	return fmt.Sprintf("cloud=%v roles=[%v] %v", r.Cloud, strings.Join(r.Roles, ","), strings.Join(sl, " "))
+}
+
+// CheckCapabilities verifies which capabilities the credentials of the cloud entry have by issuing harmless API calls.
+// All known capabilities are verified so that excess privileges show up in the report; required ones are marked.
+func CheckCapabilities(ctx context.Context, cloudName string, auth *AuthConfig, required []string) (*CapabilityReport, error) {
+	provider, err := NewProviderWithAuth(ctx, cloudName, auth)
+	if err != nil {
+		return nil, fmt.Errorf("keystone: %v", err)
+	}
+	provider = providerWithContext(ctx, provider)
+	report := &CapabilityReport{
+		Cloud: cloudName,
+	}
+	if r, err := authResult(provider); err == nil {
+		if roles, err := r.ExtractRoles(); err == nil {
+			for _, role := range roles {
+				report.Roles = append(report.Roles, role.Name)
+			}
+		}
+	}
+
+	isRequired := make(map[string]bool)
+	for _, name := range required {
+		isRequired[name] = true
+	}
+	eo := gophercloud.EndpointOpts{
+		Region: GetRegion(cloudName),
+	}
+
+	for _, check := range []struct {
+		name string
+		f    func() (bool, error)
+	}{
+		{CapabilityGetServer, func() (bool, error) {
+			sc, err := openstack.NewComputeV2(provider, eo)
+			if err != nil {
+				return false, err
+			}
+			return permitted(servers.Get(sc, nonexistentServerID).Err)
+		}},
+		{CapabilityListAllServers, func() (bool, error) {
+			sc, err := openstack.NewComputeV2(provider, eo)
+			if err != nil {
+				return false, err
+			}
+			return permitted(firstPage(servers.List(sc, servers.ListOpts{AllTenants: true, Limit: 1})))
+		}},
+		{CapabilityListDNSZones, func() (bool, error) {
+			sc, err := openstack.NewDNSV2(provider, eo)
+			if err != nil {
+				return false, err
+			}
+			return permitted(firstPage(zones.List(sc, zones.ListOpts{Limit: 1})))
+		}},
+		{CapabilityListPorts, func() (bool, error) {
+			sc, err := openstack.NewNetworkV2(provider, eo)
+			if err != nil {
+				return false, err
+			}
+			return permitted(firstPage(ports.List(sc, ports.ListOpts{DeviceID: nonexistentServerID, Limit: 1})))
+		}},
+		{CapabilityListRoleAssignments, func() (bool, error) {
+			sc, err := openstack.NewIdentityV3(provider, eo)
+			if err != nil {
+				return false, err
+			}
+			_, err = sc.Get(sc.ServiceURL("role_assignments")+"?user.id="+nonexistentServerID, nil, nil)
+			return permitted(err)
+		}},
+		{CapabilityAdminRole, func() (bool, error) {
+			for _, role := range report.Roles {
+				if role == "admin" {
+					return true, nil
+				}
+			}
+			return false, nil
+		}},
+	} {
+		c := Capability{
+			Name:     check.name,
+			Required: isRequired[check.name],
+			Admin:    adminCapabilities[check.name],
+		}
+		allowed, err := check.f()
+		c.Allowed = allowed
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		report.Capabilities = append(report.Capabilities, c)
+	}
+	return report, nil
+}
+
+// firstPage retrieves the first page of the pager only
+func firstPage(pager pagination.Pager) error {
+	return pager.EachPage(func(pagination.Page) (bool, error) {
+		return false, nil
+	})
+}
+
+// permitted classifies the error of an API call; denials are not errors, and resources not found are permitted
+func permitted(err error) (bool, error) {
+	switch err.(type) {
+	case nil, gophercloud.ErrDefault404:
+		return true, nil
+	case gophercloud.ErrDefault401, gophercloud.ErrDefault403:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,59 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestCapabilityReportViolations(t *testing.T) {
+	report := &CapabilityReport{
+		Cloud: "openstack",
+		Capabilities: []Capability{
+			{Name: CapabilityGetServer, Required: true, Allowed: true},
+			{Name: CapabilityListAllServers, Admin: true, Allowed: true},
+			{Name: CapabilityListDNSZones, Required: true, Error: "No suitable endpoint could be found"},
+			{Name: CapabilityListPorts, Allowed: true},
+			{Name: CapabilityAdminRole, Admin: true},
+		},
+	}
+
+	expected := []string{
+		"compute:list_all_servers is allowed but requires admin mode",
+		"dns:list_zones is required but not allowed: No suitable endpoint could be found",
+	}
+	if v := report.Violations(false); !reflect.DeepEqual(v, expected) {
+		t.Errorf("unexpected violations: %v", v)
+	}
+	if v := report.Violations(true); !reflect.DeepEqual(v, expected[1:]) {
+		t.Errorf("unexpected violations in admin mode: %v", v)
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	for _, c := range []struct {
+		err     error
+		allowed bool
+		fails   bool
+	}{
+		{nil, true, false},
+		{gophercloud.ErrDefault404{}, true, false},
+		{gophercloud.ErrDefault403{}, false, false},
+		{gophercloud.ErrDefault401{}, false, false},
+		{errors.New("connection refused"), false, true},
+	} {
+		allowed, err := permitted(c.err)
+		if allowed != c.allowed || (err != nil) != c.fails {
+			t.Errorf("unexpected result for %v: %v, %v", c.err, allowed, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// cloudInitInstanceIDPath is the instance ID cloud-init recorded at boot, from whichever datasource it used
+	cloudInitInstanceIDPath = "/var/lib/cloud/data/instance-id"
+	// cloudInitInstanceDataPath is the cache of the datasource cloud-init ran with, on versions since 18.4
+	cloudInitInstanceDataPath = "/run/cloud-init/instance-data.json"
+)
+
+// GetMetadataFromCloudInit gets the instance ID cloud-init recorded at boot, from the metadata service or the config
+// drive. Only the UUID of the metadata is set, and it is as trustworthy as the datasource was at boot.
+func GetMetadataFromCloudInit() (*Metadata, error) {
+	return readCloudInitMetadata(cloudInitInstanceIDPath, cloudInitInstanceDataPath)
+}
+
+// readCloudInitMetadata reads the instance ID from the instance-id file, or the datasource cache if it is missing
+func readCloudInitMetadata(instanceIDPath, instanceDataPath string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(instanceIDPath)
+	if os.IsNotExist(err) {
+		return readCloudInitInstanceData(instanceDataPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading instance id of cloud-init: %v", err)
+	}
+	uuid := strings.TrimSpace(string(b))
+	if uuid == "" {
+		return nil, fmt.Errorf("instance id of cloud-init in %v is empty", instanceIDPath)
+	}
+	return &Metadata{UUID: uuid}, nil
+}
+
+// readCloudInitInstanceData reads the instance ID from the datasource cache of cloud-init
+func readCloudInitInstanceData(path string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading instance data of cloud-init: %v", err)
+	}
+	var data struct {
+		V1 struct {
+			InstanceID string `json:"instance_id"`
+		} `json:"v1"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("malformed instance data of cloud-init: %v", err)
+	}
+	if data.V1.InstanceID == "" {
+		return nil, errors.New("instance data of cloud-init lacks v1.instance_id")
+	}
+	return &Metadata{UUID: data.V1.InstanceID}, nil
+}
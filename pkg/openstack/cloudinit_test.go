@@ -0,0 +1,75 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCloudInitMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tCase := []struct {
+		instanceID   string
+		instanceData string
+		wantUUID     string
+		wantErr      bool
+	}{
+		// 0: instance-id
+		{instanceID: "alpha\n", instanceData: `{"v1": {"instance_id": "bravo"}}`, wantUUID: "alpha"},
+		// 1: falls back to the datasource cache
+		{instanceData: `{"v1": {"instance_id": "bravo"}}`, wantUUID: "bravo"},
+		// 2: empty instance-id
+		{instanceID: "\n", instanceData: `{"v1": {"instance_id": "bravo"}}`, wantErr: true},
+		// 3: no instance ID in the datasource cache
+		{instanceData: `{"v1": {}}`, wantErr: true},
+		// 4: malformed datasource cache
+		{instanceData: `{"v1": `, wantErr: true},
+		// 5: neither
+		{wantErr: true},
+	}
+
+	for i, c := range tCase {
+		instanceID := filepath.Join(dir, "instance-id")
+		instanceData := filepath.Join(dir, "instance-data.json")
+		os.Remove(instanceID)
+		os.Remove(instanceData)
+		if c.instanceID != "" {
+			if err := ioutil.WriteFile(instanceID, []byte(c.instanceID), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.instanceData != "" {
+			if err := ioutil.WriteFile(instanceData, []byte(c.instanceData), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		m, err := readCloudInitMetadata(instanceID, instanceData)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if m.UUID != c.wantUUID {
+			t.Errorf("#%v: got %v, want %v", i, m.UUID, c.wantUUID)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// configDriveLabel is the filesystem label of the config drive
+	configDriveLabel        = "config-2"
+	configDriveMetadataPath = "openstack/%s/meta_data.json"
+	// configDriveVendorDataPath holds the dynamic vendordata by target, as of the creation of the instance
+	configDriveVendorDataPath = "openstack/%s/vendor_data2.json"
+	// configDriveNetworkDataPath holds the network configuration, as of the creation of the instance
+	configDriveNetworkDataPath = "openstack/%s/network_data.json"
+)
+
+// GetMetadataFromConfigDrive gets metadata from the config drive attached to the instance.
+func GetMetadataFromConfigDrive() (*Metadata, error) {
+	root, cleanup, err := findConfigDrive()
+	if err != nil {
+		return nil, fmt.Errorf("config drive not found: %v", err)
+	}
+	defer cleanup()
+
+	return readConfigDriveMetadata(root)
+}
+
+// readConfigDriveMetadata reads metadata from the config drive mounted at root
+func readConfigDriveMetadata(root string) (*Metadata, error) {
+	p := filepath.Join(root, filepath.FromSlash(fmt.Sprintf(configDriveMetadataPath, defaultMetadataVersion)))
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata from config drive: %v", err)
+	}
+	defer f.Close()
+
+	return parseMetadata(f)
+}
+
+// GetVendorDataFromConfigDrive gets the dynamic vendordata of given target from the config drive attached to the
+// instance. Unlike the metadata service, the data is of the creation of the instance.
+func GetVendorDataFromConfigDrive(target string) (json.RawMessage, error) {
+	root, cleanup, err := findConfigDrive()
+	if err != nil {
+		return nil, fmt.Errorf("config drive not found: %v", err)
+	}
+	defer cleanup()
+
+	return readConfigDriveVendorData(root, target)
+}
+
+// readConfigDriveVendorData reads the dynamic vendordata of given target from the config drive mounted at root
+func readConfigDriveVendorData(root, target string) (json.RawMessage, error) {
+	p := filepath.Join(root, filepath.FromSlash(fmt.Sprintf(configDriveVendorDataPath, defaultMetadataVersion)))
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vendordata from config drive: %v", err)
+	}
+	defer f.Close()
+
+	return parseVendorData(f, target)
+}
+
+// GetNetworkDataFromConfigDrive gets the network configuration of the instance from the config drive attached to it.
+// Unlike the metadata service, ports attached after the creation of the instance are missing.
+func GetNetworkDataFromConfigDrive() (json.RawMessage, error) {
+	root, cleanup, err := findConfigDrive()
+	if err != nil {
+		return nil, fmt.Errorf("config drive not found: %v", err)
+	}
+	defer cleanup()
+
+	return readConfigDriveNetworkData(root)
+}
+
+// readConfigDriveNetworkData reads the network configuration from the config drive mounted at root
+func readConfigDriveNetworkData(root string) (json.RawMessage, error) {
+	p := filepath.Join(root, filepath.FromSlash(fmt.Sprintf(configDriveNetworkDataPath, defaultMetadataVersion)))
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading network data from config drive: %v", err)
+	}
+	defer f.Close()
+
+	return parseNetworkData(f)
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	procMounts = "/proc/mounts"
+)
+
+var (
+	configDriveDevice = filepath.Join("/dev/disk/by-label", configDriveLabel)
+)
+
+// findConfigDrive returns the mount point of the config drive.
+// If the config drive isn't mounted yet, it's mounted read-only on a temporary directory,
+// which is unmounted by the returned cleanup function.
+func findConfigDrive() (string, func(), error) {
+	device, err := filepath.EvalSymlinks(configDriveDevice)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if mountPoint, err := findMountPoint(procMounts, device); err != nil {
+		return "", nil, err
+	} else if mountPoint != "" {
+		return mountPoint, func() {}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		return "", nil, err
+	}
+	var mountErr error
+	for _, fstype := range []string{"iso9660", "vfat"} {
+		if mountErr = syscall.Mount(device, dir, fstype, syscall.MS_RDONLY, ""); mountErr == nil {
+			return dir, func() {
+				syscall.Unmount(dir, 0)
+				os.Remove(dir)
+			}, nil
+		}
+	}
+	os.Remove(dir)
+	return "", nil, fmt.Errorf("failed to mount %s: %v", device, mountErr)
+}
+
+// findMountPoint returns the mount point of the device listed in the mounts file, or empty if not mounted
+func findMountPoint(mounts, device string) (string, error) {
+	f, err := os.Open(mounts)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == device {
+			// octal escapes like "\040" are used for spaces in mount points
+			return strings.Replace(fields[1], `\040`, " ", -1), nil
+		}
+	}
+	return "", s.Err()
+}
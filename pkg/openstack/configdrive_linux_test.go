@@ -0,0 +1,36 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindMountPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mounts := filepath.Join(dir, "mounts")
+	content := "/dev/vda1 / ext4 rw 0 0\n/dev/sr0 /mnt/config\\040drive iso9660 ro 0 0\n"
+	if err := ioutil.WriteFile(mounts, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := findMountPoint(mounts, "/dev/sr0"); err != nil || got != "/mnt/config drive" {
+		t.Errorf("got %q, %v, want %q", got, err, "/mnt/config drive")
+	}
+	if got, err := findMountPoint(mounts, "/dev/sr1"); err != nil || got != "" {
+		t.Errorf("got %q, %v, want empty", got, err)
+	}
+}
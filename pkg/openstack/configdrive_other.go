@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+)
+
+func findConfigDrive() (string, func(), error) {
+	return "", nil, errors.New("config drive is not supported on this platform")
+}
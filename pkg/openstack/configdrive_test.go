@@ -0,0 +1,98 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfigDriveMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "openstack", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"uuid": "alpha", "project_id": "bravo"}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "openstack", "latest", "meta_data.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readConfigDriveMetadata(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.UUID != "alpha" || m.ProjectID != "bravo" {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+}
+
+func TestReadConfigDriveVendorData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := readConfigDriveVendorData(dir, "spire"); err == nil {
+		t.Error("an error expected without vendordata, got nil")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "openstack", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"spire": {"hmac_secret": "alpha"}, "other": {}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "openstack", "latest", "vendor_data2.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readConfigDriveVendorData(dir, "spire")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != `{"hmac_secret": "alpha"}` {
+		t.Errorf("unexpected vendordata: %s", data)
+	}
+	if _, err := readConfigDriveVendorData(dir, "missing"); err == nil {
+		t.Error("an error expected for a missing target, got nil")
+	}
+}
+
+func TestReadConfigDriveNetworkData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := readConfigDriveNetworkData(dir); err == nil {
+		t.Error("an error expected without network data, got nil")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "openstack", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"links": [{"id": "tap0", "ethernet_mac_address": "fa:16:3e:00:00:01"}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "openstack", "latest", "network_data.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readConfigDriveNetworkData(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("unexpected network data: %s", data)
+	}
+}
@@ -0,0 +1,120 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Types of drives of GetDriveTypeW
+	driveRemovable = 2
+	driveFixed     = 3
+	driveCDROM     = 5
+
+	// semFailCriticalErrors keeps Windows from prompting to insert a disk into empty drives
+	semFailCriticalErrors = 0x0001
+)
+
+var (
+	kernel32                  = syscall.NewLazyDLL("kernel32.dll")
+	procGetLogicalDrives      = kernel32.NewProc("GetLogicalDrives")
+	procGetDriveTypeW         = kernel32.NewProc("GetDriveTypeW")
+	procGetVolumeInformationW = kernel32.NewProc("GetVolumeInformationW")
+	procSetErrorMode          = kernel32.NewProc("SetErrorMode")
+)
+
+// configDriveFilesystems are the file systems, as Windows names them, of the config drive in the ISO 9660 and VFAT
+// formats of Nova. ISO 9660 volumes are named "CDFS", or "UDF" by some drivers.
+var configDriveFilesystems = map[string][]uint32{
+	"CDFS":  {driveCDROM},
+	"UDF":   {driveCDROM},
+	"FAT":   {driveFixed, driveRemovable},
+	"FAT32": {driveFixed, driveRemovable},
+}
+
+// findConfigDrive returns the root of the drive labeled as the config drive, e.g. "D:\". Network drives and volumes
+// of other file systems, e.g. an NTFS data volume a user labeled "config-2", are not the config drive.
+func findConfigDrive() (string, func(), error) {
+	drives, _, err := procGetLogicalDrives.Call()
+	if drives == 0 {
+		return "", nil, err
+	}
+
+	mode, _, _ := procSetErrorMode.Call(semFailCriticalErrors)
+	defer procSetErrorMode.Call(mode)
+
+	for i := 0; i < 26; i++ {
+		if drives&(1<<uint(i)) == 0 {
+			continue
+		}
+		root := string(rune('A'+i)) + `:\`
+		typ, err := driveType(root)
+		if err != nil {
+			continue
+		}
+		label, fsName, err := volumeInformation(root)
+		if err != nil {
+			// Empty removable and CD-ROM drives have no volume
+			continue
+		}
+		if isConfigDriveVolume(typ, label, fsName) {
+			return root, func() {}, nil
+		}
+	}
+	return "", nil, errors.New("no ISO 9660 or VFAT drive labeled " + configDriveLabel)
+}
+
+// isConfigDriveVolume returns true if the volume of the type of drive, label and file system is the config drive.
+// VFAT labels are upper-cased, e.g. "CONFIG-2".
+func isConfigDriveVolume(driveType uint32, label, fsName string) bool {
+	if !strings.EqualFold(label, configDriveLabel) {
+		return false
+	}
+	for _, t := range configDriveFilesystems[strings.ToUpper(fsName)] {
+		if t == driveType {
+			return true
+		}
+	}
+	return false
+}
+
+// driveType returns the type of the drive at root
+func driveType(root string) (uint32, error) {
+	rootPtr, err := syscall.UTF16PtrFromString(root)
+	if err != nil {
+		return 0, err
+	}
+	r, _, _ := procGetDriveTypeW.Call(uintptr(unsafe.Pointer(rootPtr)))
+	return uint32(r), nil
+}
+
+// volumeInformation returns the label and the name of the file system of the volume at root
+func volumeInformation(root string) (string, string, error) {
+	rootPtr, err := syscall.UTF16PtrFromString(root)
+	if err != nil {
+		return "", "", err
+	}
+
+	label := make([]uint16, syscall.MAX_PATH+1)
+	fsName := make([]uint16, syscall.MAX_PATH+1)
+	r, _, err := procGetVolumeInformationW.Call(
+		uintptr(unsafe.Pointer(rootPtr)),
+		uintptr(unsafe.Pointer(&label[0])),
+		uintptr(len(label)),
+		0, 0, 0,
+		uintptr(unsafe.Pointer(&fsName[0])),
+		uintptr(len(fsName)))
+	if r == 0 {
+		return "", "", err
+	}
+	return syscall.UTF16ToString(label), syscall.UTF16ToString(fsName), nil
+}
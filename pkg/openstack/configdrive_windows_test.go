@@ -0,0 +1,40 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"testing"
+)
+
+func TestIsConfigDriveVolume(t *testing.T) {
+	tCase := []struct {
+		driveType uint32
+		label     string
+		fsName    string
+		want      bool
+	}{
+		// 0: ISO 9660 config drive
+		{driveType: driveCDROM, label: "config-2", fsName: "CDFS", want: true},
+		// 1: VFAT config drive, whose label is upper-cased
+		{driveType: driveFixed, label: "CONFIG-2", fsName: "FAT32", want: true},
+		// 2: VFAT config drive attached as a removable disk
+		{driveType: driveRemovable, label: "CONFIG-2", fsName: "FAT", want: true},
+		// 3: data volume labeled as the config drive
+		{driveType: driveFixed, label: "config-2", fsName: "NTFS"},
+		// 4: another CD-ROM
+		{driveType: driveCDROM, label: "cidata", fsName: "CDFS"},
+		// 5: network drive
+		{driveType: 4, label: "config-2", fsName: "FAT32"},
+	}
+
+	for i, c := range tCase {
+		if got := isConfigDriveVolume(c.driveType, c.label, c.fsName); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
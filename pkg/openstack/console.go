@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/hashicorp/go-hclog"
+)
+
+type ConsoleClient interface {
+	// GetConsoleOutput retrieves the last lines of the console log of the instance from Provider
+	GetConsoleOutput(ctx context.Context, uuid string, lines int) (string, error)
+}
+
+// Console represents a OpenStack Compute Service client for console logs
+type Console struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewConsole returns a new OpenStack Compute Service client for console logs with given provider
+func NewConsole(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (ConsoleClient, error) {
+	sc, err := openstack.NewComputeV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Console{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (c *Console) GetConsoleOutput(ctx context.Context, uuid string, lines int) (string, error) {
+	c.Logger.Debug("Get Console Output", "uuid", uuid, "lines", lines, "request_id", RequestID(ctx))
+
+	// os-getConsoleOutput is a server action, which gophercloud doesn't provide
+	req := map[string]interface{}{
+		"os-getConsoleOutput": map[string]interface{}{
+			"length": lines,
+		},
+	}
+	var resp struct {
+		Output string `json:"output"`
+	}
+	sc := withContext(ctx, c.serviceClient)
+	_, err := sc.Post(sc.ServiceURL("servers", uuid, "action"), req, &resp, &gophercloud.RequestOpts{
+		OkCodes:     []int{200},
+		MoreHeaders: requestIDHeaders(ctx),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Output, nil
+}
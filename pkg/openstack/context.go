@@ -0,0 +1,43 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// providerWithContext returns a copy of provider whose requests are canceled once ctx is done.
+// A token obtained by re-authentication with the copy is handed back to provider, so that other callers don't
+// re-authenticate again.
+//
+// This is the compatibility shim of the move to gophercloud v2, whose calls take the context themselves. The module
+// is still on gophercloud v0.8.0, since v2 needs a newer Go than the tree targets; the shim and withContext go away
+// with the bump.
+func providerWithContext(ctx context.Context, provider *gophercloud.ProviderClient) *gophercloud.ProviderClient {
+	pc := *provider
+	pc.Context = ctx
+	if reauth := provider.ReauthFunc; reauth != nil {
+		pc.ReauthFunc = func() error {
+			if err := reauth(); err != nil {
+				return err
+			}
+			pc.SetToken(provider.Token())
+			return nil
+		}
+	}
+	return &pc
+}
+
+// withContext returns a copy of sc whose requests are canceled once ctx is done
+func withContext(ctx context.Context, sc *gophercloud.ServiceClient) *gophercloud.ServiceClient {
+	c := *sc
+	c.ProviderClient = providerWithContext(ctx, sc.ProviderClient)
+	return &c
+}
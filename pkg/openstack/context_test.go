@@ -0,0 +1,80 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+)
+
+func TestWithContextCancel(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	provider, err := openstack.NewClient(ts.URL + "/v3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: ts.URL + "/compute/"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := withContext(ctx, sc).Get(sc.ServiceURL("servers"), nil, nil); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request is not canceled with the context: %v", elapsed)
+	}
+	if provider.Context != nil {
+		t.Error("the context is set to the shared provider")
+	}
+}
+
+func TestWithContextReauth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	provider, err := openstack.NewClient(ts.URL + "/v3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.SetToken("old")
+	provider.ReauthFunc = func() error {
+		provider.SetToken("new")
+		return nil
+	}
+	sc := &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: ts.URL + "/compute/"}
+
+	if _, err := withContext(context.Background(), sc).Get(sc.ServiceURL("servers"), nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The copy retries with the token the shared provider re-authenticated with
+	if token := provider.Token(); token != "new" {
+		t.Errorf("got token %q, want %q", token, "new")
+	}
+}
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// CredentialFile is the content of application_credential_file, which credential_rotator replaces on rotation
+type CredentialFile struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	// CreatedAt is the time the credential was created at, which schedules its rotation
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReadCredentialFile reads the application credential in the file at path
+func ReadCredentialFile(path string) (*CredentialFile, error) {
+	b, err := ioutil.ReadFile(common.ExpandEnv(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read application_credential_file: %v", err)
+	}
+	c := &CredentialFile{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("malformed application_credential_file: %v", err)
+	}
+	if c.ID == "" || c.Secret == "" {
+		return nil, errors.New("application_credential_file lacks id or secret")
+	}
+	return c, nil
+}
+
+// WriteCredentialFile replaces the file at path with the application credential atomically, so that readers never
+// see a partial file. The file is readable by the owner only.
+func WriteCredentialFile(path string, c *CredentialFile) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	path = common.ExpandEnv(path)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the content must be on disk before the rename, or a crash may leave an empty file in place of the old one
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// applyCredentialFile authenticates authOpts with the application credential of application_credential_file. The
+// user and the scope of the cloud entry are dropped, since Keystone prefers a password over the application credential
+// and application credentials can't be scoped.
+func (a *AuthConfig) applyCredentialFile(authOpts *gophercloud.AuthOptions) error {
+	if a.ApplicationCredentialID != "" || a.ApplicationCredentialName != "" || a.ApplicationCredentialSecret != "" ||
+		a.ApplicationCredentialSecretFile != "" || a.BarbicanSecretRef != "" || a.Password != "" || a.PasswordFile != "" {
+		return errors.New("application_credential_file and other credentials are mutually exclusive")
+	}
+	c, err := ReadCredentialFile(a.ApplicationCredentialFile)
+	if err != nil {
+		return err
+	}
+	*authOpts = gophercloud.AuthOptions{
+		IdentityEndpoint:            authOpts.IdentityEndpoint,
+		ApplicationCredentialID:     c.ID,
+		ApplicationCredentialSecret: c.Secret,
+	}
+	return nil
+}
+
+// reauthFromCredentialFile makes the provider read application_credential_file again on re-authentication, so that
+// a rotated credential is used once the tokens of the old one are revoked with its deletion
+func (a *AuthConfig) reauthFromCredentialFile(provider *gophercloud.ProviderClient, cloudName string) {
+	if a == nil || a.ApplicationCredentialFile == "" {
+		return
+	}
+	provider.ReauthFunc = func() error {
+		opts, err := a.authOptions(context.Background(), cloudName)
+		if err != nil {
+			return err
+		}
+		return openstack.Authenticate(provider, *opts)
+	}
+}
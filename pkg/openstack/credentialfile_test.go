@@ -0,0 +1,95 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCredentialFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credential.json")
+
+	if _, err := ReadCredentialFile(path); err == nil {
+		t.Error("an error expected for a missing file, got nil")
+	}
+
+	want := &CredentialFile{ID: "alpha", Secret: "bravo", CreatedAt: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := WriteCredentialFile(path, want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := ReadCredentialFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != want.ID || got.Secret != want.Secret || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if fi, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || fi.Mode().Perm() != 0600) {
+		t.Errorf("credential file must be readable by the owner only: %v, %v", fi.Mode(), err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"id": "alpha"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCredentialFile(path); err == nil {
+		t.Error("an error expected without secret, got nil")
+	}
+}
+
+func TestAuthOptionsCredentialFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credential.json")
+	if err := WriteCredentialFile(path, &CredentialFile{ID: "alpha", Secret: "bravo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AuthConfig{
+		AuthURL:                   "https://keystone.example.com/v3",
+		Username:                  "charlie",
+		ProjectID:                 "delta",
+		ApplicationCredentialFile: path,
+	}
+	opts, err := a.authOptions(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.IdentityEndpoint != a.AuthURL || opts.ApplicationCredentialID != "alpha" || opts.ApplicationCredentialSecret != "bravo" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+	if opts.Username != "" || opts.Scope != nil {
+		t.Errorf("user and scope must be dropped: %+v", opts)
+	}
+
+	// the file is read again on each authentication
+	if err := WriteCredentialFile(path, &CredentialFile{ID: "echo", Secret: "foxtrot"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := a.authOptions(context.Background(), ""); err != nil || opts.ApplicationCredentialID != "echo" {
+		t.Errorf("rotated credential not read: %+v, %v", opts, err)
+	}
+
+	a.ApplicationCredentialSecret = "golf"
+	if _, err := a.authOptions(context.Background(), ""); err == nil {
+		t.Error("an error expected for other credentials, got nil")
+	}
+}
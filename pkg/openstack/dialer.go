@@ -0,0 +1,100 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialContextFunc dials a connection to an OpenStack API
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// contextDialer adapts DialContextFunc to the dialer interfaces of golang.org/x/net/proxy
+type contextDialer DialContextFunc
+
+func (d contextDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
+
+// httpClient returns the HTTP client connecting to OpenStack APIs as configured,
+// or nil if the default client of gophercloud suffices.
+func (a *AuthConfig) httpClient() (*http.Client, error) {
+	if a == nil || (a.Proxy == "" && a.NetworkNamespace == "" && a.EndpointAllowlist == nil) {
+		return nil, nil
+	}
+
+	dial := (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	if a.NetworkNamespace != "" {
+		d, err := namespaceDialer(a.NetworkNamespace)
+		if err != nil {
+			return nil, err
+		}
+		dial = d
+	}
+
+	transport := &http.Transport{
+		DialContext:           dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if a.Proxy != "" {
+		u, err := url.Parse(a.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			transport.Proxy = http.ProxyURL(u)
+		case "socks5", "socks5h":
+			// name resolution is always left to the proxy, so the scheme variants behave the same
+			u.Scheme = "socks5"
+			d, err := proxy.FromURL(u, contextDialer(dial))
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy: %v", err)
+			}
+			cd, ok := d.(proxy.ContextDialer)
+			if !ok {
+				return nil, fmt.Errorf("proxy doesn't support contexts: %v", a.Proxy)
+			}
+			transport.DialContext = cd.DialContext
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+		}
+	}
+
+	client := &http.Client{Transport: transport}
+	if a.EndpointAllowlist != nil {
+		fingerprints, err := a.EndpointAllowlist.validate()
+		if err != nil {
+			return nil, err
+		}
+		if len(fingerprints) > 0 {
+			transport.TLSClientConfig = &tls.Config{VerifyPeerCertificate: verifyChains(fingerprints)}
+		}
+		client.Transport = &allowlistTransport{allowlist: a.EndpointAllowlist, base: transport}
+	}
+	return client, nil
+}
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// namespaceDialer returns a DialContextFunc creating sockets in the network namespace at path,
+// e.g. "/var/run/netns/mgmt". Host names are resolved in the namespace of the process.
+func namespaceDialer(path string) (DialContextFunc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network_namespace: %v", err)
+	}
+	f.Close()
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			conn, err := dialInNamespace(ctx, path, network, net.JoinHostPort(addrs[0], port))
+			ch <- result{conn, err}
+		}()
+		r := <-ch
+		return r.conn, r.err
+	}, nil
+}
+
+// dialInNamespace switches the network namespace of the current thread, dials and switches back.
+// The thread stays locked, and is thus discarded with the goroutine, unless the namespace is restored.
+func dialInNamespace(ctx context.Context, path, network, address string) (net.Conn, error) {
+	runtime.LockOSThread()
+
+	orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer orig.Close()
+	target, err := os.Open(path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to enter network namespace %v: %v", path, err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET) == nil {
+		runtime.UnlockOSThread()
+	}
+	return conn, err
+}
+
+// bindInterface makes the dialer send through the interface regardless of the routes, with SO_BINDTODEVICE, which
+// needs CAP_NET_RAW on kernels before 5.7
+func bindInterface(d *net.Dialer, iface string) error {
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return fmt.Errorf("invalid metadata interface: %v", err)
+	}
+	d.Control = func(_, _ string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if serr != nil {
+			return fmt.Errorf("failed to bind to interface %v: %v", iface, serr)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+func namespaceDialer(path string) (DialContextFunc, error) {
+	return nil, errors.New("network_namespace is supported only on Linux")
+}
+
+// bindInterface makes the dialer send from the IPv4 address of the interface, unless it has a source address, since
+// sockets can be bound to interfaces only on Linux. Whether the interface is used is left to the routes.
+func bindInterface(d *net.Dialer, iface string) error {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("invalid metadata interface: %v", err)
+	}
+	if d.LocalAddr != nil {
+		return nil
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of interface %v: %v", iface, err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			d.LocalAddr = &net.TCPAddr{IP: n.IP}
+			return nil
+		}
+	}
+	return fmt.Errorf("no IPv4 address on interface %v to reach the metadata service from", iface)
+}
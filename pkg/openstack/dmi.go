@@ -0,0 +1,62 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// GetDMIProductUUID gets the UUID of the system from the DMI (SMBIOS) table, which Nova sets to the instance UUID.
+// Unlike the metadata service, the table is provided by the hypervisor and can't be spoofed over the network.
+func GetDMIProductUUID() (string, error) {
+	uuid, err := readDMIProductUUID()
+	if err != nil {
+		return "", fmt.Errorf("error reading product uuid from DMI: %v", err)
+	}
+	return strings.TrimSpace(uuid), nil
+}
+
+// MatchDMIUUID returns whether the UUID of the DMI table is the instance UUID. SMBIOS before 2.6 and some
+// firmware encode the first three fields of the UUID in the little-endian order, so the byte-swapped form matches too.
+func MatchDMIUUID(dmiUUID, instanceUUID string) bool {
+	d, err := parseUUID(dmiUUID)
+	if err != nil {
+		return false
+	}
+	i, err := parseUUID(instanceUUID)
+	if err != nil {
+		return false
+	}
+	if d == i {
+		return true
+	}
+	swapped := d
+	for _, f := range [][2]int{{0, 4}, {4, 6}, {6, 8}} {
+		for a, b := f[0], f[1]-1; a < b; a, b = a+1, b-1 {
+			swapped[a], swapped[b] = swapped[b], swapped[a]
+		}
+	}
+	return swapped == i
+}
+
+// parseUUID parses a UUID in the canonical form, in any case
+func parseUUID(s string) ([16]byte, error) {
+	var u [16]byte
+	s = strings.TrimSpace(s)
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("malformed uuid: %q", s)
+	}
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return u, fmt.Errorf("malformed uuid: %q", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
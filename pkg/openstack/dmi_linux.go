@@ -0,0 +1,41 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// dmiProductUUIDPath is the product UUID of the DMI table exported by the kernel, which is readable only by root
+	dmiProductUUIDPath = "/sys/class/dmi/id/product_uuid"
+	// dmiSysVendorPath and dmiProductNamePath are the system vendor and product, which are readable by anyone
+	dmiSysVendorPath   = "/sys/class/dmi/id/sys_vendor"
+	dmiProductNamePath = "/sys/class/dmi/id/product_name"
+)
+
+func readDMIProductUUID() (string, error) {
+	b, err := ioutil.ReadFile(dmiProductUUIDPath)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readDMISystem() (string, string, error) {
+	vendor, err := ioutil.ReadFile(dmiSysVendorPath)
+	if err != nil {
+		return "", "", err
+	}
+	product, err := ioutil.ReadFile(dmiProductNamePath)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(string(vendor)), strings.TrimSpace(string(product)), nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"errors"
+)
+
+func readDMIProductUUID() (string, error) {
+	return "", errors.New("DMI product uuid is supported only on Linux")
+}
+
+func readDMISystem() (string, string, error) {
+	return "", "", errors.New("DMI system information is supported only on Linux")
+}
@@ -0,0 +1,42 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"testing"
+)
+
+func TestMatchDMIUUID(t *testing.T) {
+	const instanceUUID = "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"
+
+	tCase := []struct {
+		dmiUUID string
+		want    bool
+	}{
+		// 0: same
+		{dmiUUID: instanceUUID, want: true},
+		// 1: upper case with a newline, as exported by the kernel
+		{dmiUUID: "0A1B2C3D-4E5F-6A7B-8C9D-0E1F2A3B4C5D\n", want: true},
+		// 2: first three fields byte-swapped
+		{dmiUUID: "3d2c1b0a-5f4e-7b6a-8c9d-0e1f2a3b4c5d", want: true},
+		// 3: another instance
+		{dmiUUID: "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5e"},
+		// 4: last fields byte-swapped
+		{dmiUUID: "0a1b2c3d-4e5f-6a7b-9d8c-5d4c3b2a1f0e"},
+		// 5: malformed
+		{dmiUUID: "Not Settable"},
+		// 6: empty
+		{dmiUUID: ""},
+	}
+
+	for i, c := range tCase {
+		if got := MatchDMIUUID(c.dmiUUID, instanceUUID); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
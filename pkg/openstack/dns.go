@@ -0,0 +1,81 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	"github.com/hashicorp/go-hclog"
+)
+
+type DNSClient interface {
+	// LookupAddresses retrieves the addresses of A and AAAA records with given name from Designate
+	LookupAddresses(ctx context.Context, zoneName, name string) ([]string, error)
+}
+
+// DNS represents a OpenStack DNS Service (Designate) client
+type DNS struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewDNS returns a new OpenStack DNS Service client with given provider
+func NewDNS(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (DNSClient, error) {
+	sc, err := openstack.NewDNSV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DNS{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (d *DNS) LookupAddresses(ctx context.Context, zoneName, name string) ([]string, error) {
+	d.Logger.Debug("Lookup DNS Records", "zone", zoneName, "name", name)
+
+	return lookupAddresses(withContext(ctx, d.serviceClient), zoneName, name)
+}
+
+func lookupAddresses(sc *gophercloud.ServiceClient, zoneName, name string) ([]string, error) {
+	pages, err := zones.List(sc, zones.ListOpts{Name: zoneName}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	zl, err := zones.ExtractZones(pages)
+	if err != nil {
+		return nil, err
+	}
+	if len(zl) == 0 {
+		return nil, fmt.Errorf("zone not found: %v", zoneName)
+	}
+
+	pages, err = recordsets.ListByZone(sc, zl[0].ID, recordsets.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	rl, err := recordsets.ExtractRecordSets(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, r := range rl {
+		if r.Type == "A" || r.Type == "AAAA" {
+			addrs = append(addrs, r.Records...)
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,62 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Evidence is the evidence an agent attests with, collected on the instance for offline verification
+type Evidence struct {
+	CollectedAt time.Time `json:"collected_at"`
+	// MetadataSource is the source Metadata was retrieved from, "metadata_service" or "config_drive"
+	MetadataSource string          `json:"metadata_source,omitempty"`
+	Metadata       *Metadata       `json:"metadata,omitempty"`
+	NetworkData    json.RawMessage `json:"network_data,omitempty"`
+	// AttestationData is the attestation data the agent sends, including the signed identity if configured
+	AttestationData []byte `json:"attestation_data,omitempty"`
+	// Errors are the errors of the items which couldn't be collected, by item
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// AddError records the error of an item which couldn't be collected
+func (e *Evidence) AddError(item string, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[string]string)
+	}
+	e.Errors[item] = err.Error()
+}
+
+// WriteFile writes the evidence to a file readable only by the owner, since it carries the signed identity
+func (e *Evidence) WriteFile(path string) error {
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// ReadEvidence reads evidence written by Evidence.WriteFile
+func ReadEvidence(path string) (*Evidence, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &Evidence{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("malformed evidence: %v", err)
+	}
+	if len(e.AttestationData) == 0 {
+		return nil, errors.New("evidence lacks attestation data")
+	}
+	return e, nil
+}
@@ -0,0 +1,60 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEvidence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evidence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &Evidence{
+		CollectedAt:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		MetadataSource:  "config_drive",
+		Metadata:        &Metadata{UUID: "alpha", ProjectID: "bravo"},
+		AttestationData: []byte(`{"instance_id":"alpha"}`),
+	}
+	e.AddError("network_data", errors.New("connection refused"))
+
+	path := filepath.Join(dir, "evidence.json")
+	if err := e.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected file: %v, %v", fi, err)
+	}
+	got, err := ReadEvidence(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, e) {
+		t.Errorf("got %+v, want %+v", got, e)
+	}
+
+	// Evidence without attestation data can't be verified
+	e.AttestationData = nil
+	e.NetworkData = json.RawMessage(`{"links":[]}`)
+	if err := e.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEvidence(path); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
@@ -0,0 +1,201 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Endpoint overrides the Keystone endpoint and the region of a cloud entry, e.g. with those of another region of the
+// same deployment. Empty fields are of the cloud entry.
+type Endpoint struct {
+	AuthURL string `hcl:"auth_url"`
+	Region  string `hcl:"region"`
+}
+
+// FailoverInstance is an InstanceClient of a cloud reachable through several sites, the primary one first and its
+// fallbacks in order. Lookups fail over to the next site while a site is unavailable, and the primary site is tried
+// again every failBackInterval once failed over, so that lookups fail back once it recovers.
+type FailoverInstance struct {
+	Logger    hclog.Logger
+	sites     []string
+	newClient func(ctx context.Context, site string) (InstanceClient, error)
+	// timeout bounds each site but the last one tried, so that a site which hangs leaves time for the others
+	timeout          time.Duration
+	failBackInterval time.Duration
+	onSwitch         func(from, to string)
+	now              func() time.Time
+
+	mtx     sync.Mutex
+	clients map[string]InstanceClient
+
+	// active is the index of the site lookups go to, and failBackAt is when the primary site is tried again.
+	// They are guarded by stateMtx, since mtx is held while preparing clients.
+	stateMtx   sync.Mutex
+	active     int
+	failBackAt time.Time
+}
+
+// NewFailoverInstance returns an InstanceClient looking up instances in the first available of given sites.
+// newClient prepares the client of a site, and onSwitch, if not nil, is called whenever lookups go to another site.
+func NewFailoverInstance(sites []string, newClient func(context.Context, string) (InstanceClient, error), timeout, failBackInterval time.Duration, onSwitch func(from, to string), logger hclog.Logger) *FailoverInstance {
+	return &FailoverInstance{
+		Logger:           logger,
+		sites:            sites,
+		newClient:        newClient,
+		timeout:          timeout,
+		failBackInterval: failBackInterval,
+		onSwitch:         onSwitch,
+		now:              time.Now,
+		clients:          make(map[string]InstanceClient),
+	}
+}
+
+// Prepare prepares the client of the first available site, and makes it the site lookups go to
+func (i *FailoverInstance) Prepare(ctx context.Context) error {
+	return i.do(ctx, func(InstanceClient) error { return nil })
+}
+
+// Active returns the site lookups go to
+func (i *FailoverInstance) Active() string {
+	i.stateMtx.Lock()
+	defer i.stateMtx.Unlock()
+	return i.sites[i.active]
+}
+
+func (i *FailoverInstance) Get(ctx context.Context, uuid string) (*Server, error) {
+	var s *Server
+	err := i.do(ctx, func(c InstanceClient) (err error) {
+		s, err = c.Get(ctx, uuid)
+		return err
+	})
+	return s, err
+}
+
+func (i *FailoverInstance) List(ctx context.Context, allTenants bool) ([]*Server, error) {
+	var sl []*Server
+	err := i.do(ctx, func(c InstanceClient) error {
+		lister, ok := c.(InstanceLister)
+		if !ok {
+			return errors.New("client doesn't list instances")
+		}
+		var err error
+		sl, err = lister.List(ctx, allTenants)
+		return err
+	})
+	return sl, err
+}
+
+// do calls fn with the client of each site in order until a site answers, and makes it the site lookups go to.
+// An instance which is not found is an answer, since the sites are of the same cloud. The first failure is returned
+// if no site answers.
+func (i *FailoverInstance) do(ctx context.Context, fn func(InstanceClient) error) error {
+	order := i.order()
+	var failure error
+	for n, site := range order {
+		if ctx.Err() != nil {
+			break
+		}
+		siteCtx, cancel := ctx, context.CancelFunc(func() {})
+		if n < len(order)-1 && i.timeout > 0 {
+			siteCtx, cancel = context.WithTimeout(ctx, i.timeout)
+		}
+		err := i.try(siteCtx, i.sites[site], fn)
+		cancel()
+		if err == nil || IsNotFound(err) {
+			i.activate(site)
+			return err
+		}
+		i.Logger.Warn("Site is unavailable", "site", i.sites[site], "error", err)
+		if failure == nil {
+			failure = err
+		}
+	}
+	if failure == nil {
+		failure = ctx.Err()
+	}
+	return failure
+}
+
+func (i *FailoverInstance) try(ctx context.Context, site string, fn func(InstanceClient) error) error {
+	c, err := i.client(ctx, site)
+	if err != nil {
+		return err
+	}
+	return fn(c)
+}
+
+// order returns the indexes of the sites in the order they are tried: the primary site if its fail-back is due,
+// the active site, and then the others
+func (i *FailoverInstance) order() []int {
+	i.stateMtx.Lock()
+	defer i.stateMtx.Unlock()
+
+	var order []int
+	added := make(map[int]bool)
+	add := func(n int) {
+		if !added[n] {
+			added[n] = true
+			order = append(order, n)
+		}
+	}
+	if i.active > 0 && !i.now().Before(i.failBackAt) {
+		add(0)
+		i.failBackAt = i.now().Add(i.failBackInterval)
+	}
+	add(i.active)
+	for n := range i.sites {
+		add(n)
+	}
+	return order
+}
+
+// activate makes the site the one lookups go to
+func (i *FailoverInstance) activate(site int) {
+	i.stateMtx.Lock()
+	defer i.stateMtx.Unlock()
+
+	if site == i.active {
+		return
+	}
+	from, to := i.sites[i.active], i.sites[site]
+	if site == 0 {
+		i.Logger.Info("Failed back to primary site", "site", to, "from", from)
+	} else {
+		i.Logger.Warn("Failed over to fallback site", "site", to, "from", from)
+		if i.active == 0 {
+			i.failBackAt = i.now().Add(i.failBackInterval)
+		}
+	}
+	i.active = site
+	if i.onSwitch != nil {
+		i.onSwitch(from, to)
+	}
+}
+
+// client returns the client of the site, preparing it if it isn't yet.
+// Failures are not kept, so that the next lookup retries.
+func (i *FailoverInstance) client(ctx context.Context, site string) (InstanceClient, error) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if c, ok := i.clients[site]; ok {
+		return c, nil
+	}
+	c, err := i.newClient(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+	i.clients[site] = c
+	return c, nil
+}
@@ -0,0 +1,131 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// siteInstance stands for a site of a cloud which has the instance "123", and fails while the site is down
+type siteInstance struct {
+	site    string
+	down    map[string]bool
+	lookups *[]string
+}
+
+func (s *siteInstance) Get(_ context.Context, uuid string) (*Server, error) {
+	*s.lookups = append(*s.lookups, s.site)
+	if s.down[s.site] {
+		return nil, errors.New("service unavailable")
+	}
+	if uuid != "123" {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &Server{Server: servers.Server{ID: uuid}, Region: s.site}, nil
+}
+
+func TestFailoverInstance(t *testing.T) {
+	var lookups, switches []string
+	down := map[string]bool{}
+	now := time.Now()
+	ic := NewFailoverInstance([]string{"primary", "fallback1", "fallback2"}, func(_ context.Context, site string) (InstanceClient, error) {
+		return &siteInstance{site: site, down: down, lookups: &lookups}, nil
+	}, time.Second, time.Minute, func(from, to string) {
+		switches = append(switches, from+">"+to)
+	}, testutil.TestLogger())
+	ic.now = func() time.Time { return now }
+
+	tCase := []struct {
+		down        []string
+		elapsed     time.Duration
+		uuid        string
+		wantLookups []string
+		wantActive  string
+		wantErr     bool
+	}{
+		// 0: the primary site answers
+		{uuid: "123", wantLookups: []string{"primary"}, wantActive: "primary"},
+		// 1: not found is an answer
+		{uuid: "456", wantLookups: []string{"primary"}, wantActive: "primary"},
+		// 2: failover to the first available fallback
+		{down: []string{"primary", "fallback1"}, uuid: "123", wantLookups: []string{"primary", "fallback1", "fallback2"}, wantActive: "fallback2"},
+		// 3: lookups stay on the fallback until fail-back is due
+		{elapsed: 30 * time.Second, uuid: "123", wantLookups: []string{"fallback2"}, wantActive: "fallback2"},
+		// 4: the primary site is tried again once fail-back is due, and stays failed over while it is down
+		{down: []string{"primary"}, elapsed: 30 * time.Second, uuid: "123", wantLookups: []string{"primary", "fallback2"}, wantActive: "fallback2"},
+		// 5: the next fail-back is due an interval after the last try
+		{elapsed: 30 * time.Second, uuid: "123", wantLookups: []string{"fallback2"}, wantActive: "fallback2"},
+		// 6: fail-back once the primary site recovers
+		{elapsed: 30 * time.Second, uuid: "123", wantLookups: []string{"primary"}, wantActive: "primary"},
+		// 7: all sites are down
+		{down: []string{"primary", "fallback1", "fallback2"}, uuid: "123", wantLookups: []string{"primary", "fallback1", "fallback2"}, wantActive: "primary", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		for site := range down {
+			delete(down, site)
+		}
+		for _, site := range c.down {
+			down[site] = true
+		}
+		now = now.Add(c.elapsed)
+		lookups = nil
+
+		s, err := ic.Get(context.Background(), c.uuid)
+		switch {
+		case c.wantErr:
+			if err == nil || IsNotFound(err) {
+				t.Errorf("#%v: got %v, want the failure of the sites", i, err)
+			}
+		case c.uuid != "123":
+			if !IsNotFound(err) {
+				t.Errorf("#%v: got %v, want not found", i, err)
+			}
+		case err != nil:
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		case s.Region != c.wantActive:
+			t.Errorf("#%v: got instance of site %v, want %v", i, s.Region, c.wantActive)
+		}
+		if !reflect.DeepEqual(lookups, c.wantLookups) {
+			t.Errorf("#%v: got lookups %v, want %v", i, lookups, c.wantLookups)
+		}
+		if got := ic.Active(); got != c.wantActive {
+			t.Errorf("#%v: got active site %v, want %v", i, got, c.wantActive)
+		}
+	}
+
+	wantSwitches := []string{"primary>fallback2", "fallback2>primary"}
+	if !reflect.DeepEqual(switches, wantSwitches) {
+		t.Errorf("got switches %v, want %v", switches, wantSwitches)
+	}
+}
+
+func TestFailoverInstancePrepare(t *testing.T) {
+	ic := NewFailoverInstance([]string{"primary", "fallback1"}, func(_ context.Context, site string) (InstanceClient, error) {
+		if site == "primary" {
+			return nil, errors.New("keystone is down")
+		}
+		return &siteInstance{site: site, lookups: &[]string{}}, nil
+	}, time.Second, time.Minute, nil, testutil.TestLogger())
+
+	if err := ic.Prepare(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ic.Active(); got != "fallback1" {
+		t.Errorf("got active site %v, want fallback1", got)
+	}
+}
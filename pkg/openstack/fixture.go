@@ -0,0 +1,234 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+const (
+	fixtureCompute  = "compute"
+	fixtureNetwork  = "network"
+	fixtureDNS      = "dns"
+	fixtureIdentity = "identity"
+	// fixtureBootSecurity records the boot security attributes of Nova and Glance
+	fixtureBootSecurity = "boot_security"
+)
+
+// RecordedCall is a response of OpenStack recorded in a Fixture
+type RecordedCall struct {
+	// Service and key identify the request, e.g. "compute" and the instance ID
+	Service  string          `json:"service"`
+	Key      string          `json:"key"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	NotFound bool            `json:"not_found,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Fixture holds the responses of OpenStack recorded during an attestation, which a ReplayClient serves back offline
+type Fixture struct {
+	mtx   sync.Mutex
+	Calls []*RecordedCall `json:"calls"`
+}
+
+type fixtureKey struct{}
+
+// WithFixture returns a context in which the recording clients record their responses into f
+func WithFixture(ctx context.Context, f *Fixture) context.Context {
+	return context.WithValue(ctx, fixtureKey{}, f)
+}
+
+func fixtureOf(ctx context.Context) *Fixture {
+	f, _ := ctx.Value(fixtureKey{}).(*Fixture)
+	return f
+}
+
+func (f *Fixture) record(service, key string, result interface{}, err error) {
+	c := &RecordedCall{Service: service, Key: key}
+	switch {
+	case IsNotFound(err):
+		c.NotFound = true
+	case err != nil:
+		c.Error = err.Error()
+	default:
+		var b []byte
+		if s, ok := result.(*Server); ok {
+			b, err = marshalServer(s)
+		} else {
+			b, err = json.Marshal(result)
+		}
+		if err != nil {
+			c.Error = fmt.Sprintf("failed to record result: %v", err)
+		}
+		c.Result = b
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.Calls = append(f.Calls, c)
+}
+
+// lookup returns the first recorded call of the request
+func (f *Fixture) lookup(service, key string) (*RecordedCall, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, c := range f.Calls {
+		if c.Service == service && c.Key == key {
+			if c.NotFound {
+				return nil, gophercloud.ErrDefault404{}
+			}
+			if c.Error != "" {
+				return nil, errors.New(c.Error)
+			}
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no %v response is recorded for %q", service, key)
+}
+
+// recordingClient records the responses of the wrapped clients into the fixture of the context, if any
+type recordingClient struct {
+	instance InstanceClient
+	network  NetworkClient
+	dns      DNSClient
+	role     RoleClient
+	boot     BootSecurityClient
+}
+
+// RecordInstance returns an InstanceClient recording the responses of client into the fixture of the context
+func RecordInstance(client InstanceClient) InstanceClient {
+	return &recordingClient{instance: client}
+}
+
+// RecordNetwork returns a NetworkClient recording the responses of client into the fixture of the context
+func RecordNetwork(client NetworkClient) NetworkClient {
+	return &recordingClient{network: client}
+}
+
+// RecordDNS returns a DNSClient recording the responses of client into the fixture of the context
+func RecordDNS(client DNSClient) DNSClient {
+	return &recordingClient{dns: client}
+}
+
+// RecordRole returns a RoleClient recording the responses of client into the fixture of the context
+func RecordRole(client RoleClient) RoleClient {
+	return &recordingClient{role: client}
+}
+
+// RecordBootSecurity returns a BootSecurityClient recording the responses of client into the fixture of the context
+func RecordBootSecurity(client BootSecurityClient) BootSecurityClient {
+	return &recordingClient{boot: client}
+}
+
+func (r *recordingClient) Get(ctx context.Context, uuid string) (*Server, error) {
+	s, err := r.instance.Get(ctx, uuid)
+	if f := fixtureOf(ctx); f != nil {
+		f.record(fixtureCompute, uuid, s, err)
+	}
+	return s, err
+}
+
+func (r *recordingClient) ListPorts(ctx context.Context, deviceID string) ([]ports.Port, error) {
+	pl, err := r.network.ListPorts(ctx, deviceID)
+	if f := fixtureOf(ctx); f != nil {
+		f.record(fixtureNetwork, deviceID, pl, err)
+	}
+	return pl, err
+}
+
+func (r *recordingClient) LookupAddresses(ctx context.Context, zoneName, name string) ([]string, error) {
+	addrs, err := r.dns.LookupAddresses(ctx, zoneName, name)
+	if f := fixtureOf(ctx); f != nil {
+		f.record(fixtureDNS, dnsFixtureKey(zoneName, name), addrs, err)
+	}
+	return addrs, err
+}
+
+func (r *recordingClient) ListRoles(ctx context.Context, projectID, userID string) ([]string, error) {
+	roles, err := r.role.ListRoles(ctx, projectID, userID)
+	if f := fixtureOf(ctx); f != nil {
+		f.record(fixtureIdentity, roleFixtureKey(projectID, userID), roles, err)
+	}
+	return roles, err
+}
+
+func (r *recordingClient) GetBootSecurity(ctx context.Context, s *Server) (*BootSecurity, error) {
+	bs, err := r.boot.GetBootSecurity(ctx, s)
+	if f := fixtureOf(ctx); f != nil {
+		f.record(fixtureBootSecurity, s.ID, bs, err)
+	}
+	return bs, err
+}
+
+// ReplayClient serves the responses recorded in a fixture, in place of the clients of all services
+type ReplayClient struct {
+	fixture *Fixture
+}
+
+// NewReplayClient returns a client serving the responses recorded in f. Requests which were not recorded fail.
+func NewReplayClient(f *Fixture) *ReplayClient {
+	return &ReplayClient{fixture: f}
+}
+
+func (r *ReplayClient) Get(_ context.Context, uuid string) (*Server, error) {
+	c, err := r.fixture.lookup(fixtureCompute, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalServer(c.Result)
+}
+
+func (r *ReplayClient) ListPorts(_ context.Context, deviceID string) ([]ports.Port, error) {
+	var pl []ports.Port
+	err := r.replay(fixtureNetwork, deviceID, &pl)
+	return pl, err
+}
+
+func (r *ReplayClient) LookupAddresses(_ context.Context, zoneName, name string) ([]string, error) {
+	var addrs []string
+	err := r.replay(fixtureDNS, dnsFixtureKey(zoneName, name), &addrs)
+	return addrs, err
+}
+
+func (r *ReplayClient) ListRoles(_ context.Context, projectID, userID string) ([]string, error) {
+	var roles []string
+	err := r.replay(fixtureIdentity, roleFixtureKey(projectID, userID), &roles)
+	return roles, err
+}
+
+func (r *ReplayClient) GetBootSecurity(_ context.Context, s *Server) (*BootSecurity, error) {
+	bs := &BootSecurity{}
+	if err := r.replay(fixtureBootSecurity, s.ID, bs); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (r *ReplayClient) replay(service, key string, result interface{}) error {
+	c, err := r.fixture.lookup(service, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(c.Result, result)
+}
+
+func dnsFixtureKey(zoneName, name string) string {
+	return strings.Join([]string{zoneName, name}, "/")
+}
+
+func roleFixtureKey(projectID, userID string) string {
+	return strings.Join([]string{projectID, userID}, "/")
+}
@@ -0,0 +1,95 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+type staticNetwork []ports.Port
+
+func (n staticNetwork) ListPorts(context.Context, string) ([]ports.Port, error) {
+	return n, nil
+}
+
+type staticBootSecurity BootSecurity
+
+func (b staticBootSecurity) GetBootSecurity(context.Context, *Server) (*BootSecurity, error) {
+	bs := BootSecurity(b)
+	return &bs, nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var lookups []string
+	instance := RecordInstance(&projectInstance{projectID: "alpha", instances: map[string]string{"123": "alpha"}, lookups: &lookups})
+	network := RecordNetwork(staticNetwork{{ID: "port-1", DeviceOwner: "compute:nova"}})
+	boot := RecordBootSecurity(staticBootSecurity{SecureBoot: SecureBootRequired, FirmwareType: FirmwareTypeUEFI})
+
+	// Lookups without a fixture in the context aren't recorded
+	if _, err := instance.Get(context.Background(), "123"); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Fixture{}
+	ctx := WithFixture(context.Background(), f)
+	want, err := instance.Get(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance.Get(ctx, "456"); !IsNotFound(err) {
+		t.Fatalf("got %v, want not found", err)
+	}
+	wantPorts, err := network.ListPorts(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBoot, err := boot.GetBootSecurity(ctx, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Calls) != 4 {
+		t.Fatalf("got %v calls, want 4", len(f.Calls))
+	}
+
+	// Fixtures are replayed after a round trip through JSON
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &Fixture{}
+	if err := json.Unmarshal(b, replayed); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReplayClient(replayed)
+
+	s, err := r.Get(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.ID != want.ID || s.TenantID != want.TenantID {
+		t.Errorf("got %+v, want %+v", s.Server, want.Server)
+	}
+	if _, err := r.Get(context.Background(), "456"); !IsNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+	pl, err := r.ListPorts(context.Background(), "123")
+	if err != nil || len(pl) != 1 || pl[0].ID != wantPorts[0].ID || pl[0].DeviceOwner != wantPorts[0].DeviceOwner {
+		t.Errorf("got %v, %v, want %v", pl, err, wantPorts)
+	}
+	if bs, err := r.GetBootSecurity(context.Background(), s); err != nil || !reflect.DeepEqual(bs, wantBoot) {
+		t.Errorf("got %+v, %v, want %+v", bs, err, wantBoot)
+	}
+	if _, err := r.ListRoles(context.Background(), "alpha", ""); err == nil {
+		t.Errorf("an error expected for requests not recorded, got nil")
+	}
+}
@@ -0,0 +1,207 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+)
+
+// keystoneTimeFormat is the format of timestamps accepted by the Identity API
+const keystoneTimeFormat = "2006-01-02T15:04:05.000000"
+
+// Token is a scoped Keystone token
+type Token struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ProjectID string    `json:"project_id,omitempty"`
+}
+
+// ApplicationCredential is a Keystone application credential created on behalf of a workload
+type ApplicationCredential struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ProjectID string    `json:"project_id,omitempty"`
+}
+
+// ApplicationCredentialOpts are the options of an application credential to create
+type ApplicationCredentialOpts struct {
+	Name        string
+	Description string
+	// Roles restrict the credential to the roles, which are all roles of the user on the project if empty
+	Roles []string
+	// ExpiresAt is the time the credential expires at. It never expires if zero.
+	ExpiresAt time.Time
+	// Unrestricted allows the credential to create and delete application credentials, e.g. to rotate itself
+	Unrestricted bool
+}
+
+// IssueToken authenticates with given options and returns the resulting token
+func IssueToken(ctx context.Context, cloudName string, auth *AuthConfig) (*Token, error) {
+	provider, err := NewProviderWithAuth(ctx, cloudName, auth)
+	if err != nil {
+		return nil, err
+	}
+	r, err := authResult(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := r.ExtractToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{
+		ID:        t.ID,
+		ExpiresAt: t.ExpiresAt,
+	}
+	if p, err := r.ExtractProject(); err == nil && p != nil {
+		token.ProjectID = p.ID
+	}
+	return token, nil
+}
+
+// CreateApplicationCredential creates an application credential for the user authenticated with given options
+func CreateApplicationCredential(ctx context.Context, cloudName string, auth *AuthConfig, o *ApplicationCredentialOpts) (*ApplicationCredential, error) {
+	sc, userID, err := identityClient(ctx, cloudName, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := map[string]interface{}{
+		"name":        o.Name,
+		"description": o.Description,
+	}
+	if !o.ExpiresAt.IsZero() {
+		opts["expires_at"] = o.ExpiresAt.UTC().Format(keystoneTimeFormat)
+	}
+	if len(o.Roles) > 0 {
+		var rl []map[string]string
+		for _, role := range o.Roles {
+			rl = append(rl, map[string]string{"name": role})
+		}
+		opts["roles"] = rl
+	}
+	if o.Unrestricted {
+		opts["unrestricted"] = true
+	}
+
+	var resp struct {
+		ApplicationCredential struct {
+			ID        string `json:"id"`
+			Name      string `json:"name"`
+			Secret    string `json:"secret"`
+			ProjectID string `json:"project_id"`
+		} `json:"application_credential"`
+	}
+	sc = withContext(ctx, sc)
+	_, err = sc.Post(sc.ServiceURL("users", userID, "application_credentials"), map[string]interface{}{
+		"application_credential": opts,
+	}, &resp, &gophercloud.RequestOpts{
+		OkCodes: []int{201},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApplicationCredential{
+		ID:        resp.ApplicationCredential.ID,
+		Name:      resp.ApplicationCredential.Name,
+		Secret:    resp.ApplicationCredential.Secret,
+		ExpiresAt: o.ExpiresAt,
+		ProjectID: resp.ApplicationCredential.ProjectID,
+	}, nil
+}
+
+// DeleteApplicationCredential deletes the application credential of the user authenticated with given options.
+// Keystone revokes the tokens issued to the credential.
+func DeleteApplicationCredential(ctx context.Context, cloudName string, auth *AuthConfig, id string) error {
+	sc, userID, err := identityClient(ctx, cloudName, auth)
+	if err != nil {
+		return err
+	}
+	sc = withContext(ctx, sc)
+	_, err = sc.Delete(sc.ServiceURL("users", userID, "application_credentials", id), &gophercloud.RequestOpts{
+		OkCodes: []int{204},
+	})
+	return err
+}
+
+// identityClient returns the Identity v3 client of the user authenticated with given options, along with the ID of
+// the user
+func identityClient(ctx context.Context, cloudName string, auth *AuthConfig) (*gophercloud.ServiceClient, string, error) {
+	provider, err := NewProviderWithAuth(ctx, cloudName, auth)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := authResult(provider)
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := r.ExtractUser()
+	if err != nil {
+		return nil, "", err
+	}
+	sc, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{
+		Region: GetRegion(cloudName),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return sc, user.ID, nil
+}
+
+// TokenValidator validates Keystone tokens presented by clients of services
+type TokenValidator interface {
+	// ValidateToken returns the ID of the user the token is issued to
+	ValidateToken(ctx context.Context, token string) (string, error)
+}
+
+type tokenValidator struct {
+	sc *gophercloud.ServiceClient
+}
+
+// NewTokenValidator returns a TokenValidator validating tokens with Keystone on behalf of the user
+// authenticated with given options
+func NewTokenValidator(ctx context.Context, cloudName string, auth *AuthConfig) (TokenValidator, error) {
+	provider, err := NewProviderWithAuth(ctx, cloudName, auth)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{
+		Region: GetRegion(cloudName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tokenValidator{sc: sc}, nil
+}
+
+func (v *tokenValidator) ValidateToken(ctx context.Context, token string) (string, error) {
+	user, err := tokens.Get(withContext(ctx, v.sc), token).ExtractUser()
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// authResult returns the result of the Identity v3 authentication of provider
+func authResult(provider *gophercloud.ProviderClient) (*tokens.CreateResult, error) {
+	r, ok := provider.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return nil, errors.New("token exchange requires Identity API v3")
+	}
+	return &r, nil
+}
@@ -8,36 +8,119 @@
 package openstack
 
 import (
+	"context"
+	"time"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/serverusage"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/hashicorp/go-hclog"
 )
 
 type InstanceClient interface {
 	// Get retrieves a instance information from Provider
-	Get(uuid string) (*servers.Server, error)
+	Get(ctx context.Context, uuid string) (*Server, error)
+}
+
+type InstanceLister interface {
+	// List retrieves the instances visible to the credentials from Provider.
+	// Instances of all projects are listed if allTenants is true, which usually requires admin role.
+	List(ctx context.Context, allTenants bool) ([]*Server, error)
+}
+
+// Server represents a Nova server including the extended attributes used by the plugins
+type Server struct {
+	servers.Server
+	availabilityzones.ServerAvailabilityZoneExt
+	extendedstatus.ServerExtendedStatusExt
+
+	// Region is the region of the compute endpoint the server was found in
+	Region string `json:"-"`
+	// LaunchedAt is the time the server was last launched at, e.g. booted, rebuilt or resized, zero if Nova hides
+	// the server usage
+	LaunchedAt time.Time `json:"-"`
 }
 
 // Instance represents a OpenStack Compute Service client
 type Instance struct {
 	Logger        hclog.Logger
+	region        string
 	serviceClient *gophercloud.ServiceClient
 }
 
 // NewInstance returns a new OpenStack Compute Service client with given provider
-func NewInstance(client *gophercloud.ProviderClient, logger hclog.Logger) (InstanceClient, error) {
-	sc, err := openstack.NewComputeV2(client, gophercloud.EndpointOpts{})
+func NewInstance(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (InstanceClient, error) {
+	sc, err := openstack.NewComputeV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &Instance{
 		Logger:        logger,
+		region:        region,
 		serviceClient: sc,
 	}, nil
 }
 
-func (i *Instance) Get(uuid string) (*servers.Server, error) {
-	i.Logger.Debug("Get Instance Information", "uuid", uuid)
-	return servers.Get(i.serviceClient, uuid).Extract()
+func (i *Instance) Get(ctx context.Context, uuid string) (*Server, error) {
+	i.Logger.Debug("Get Instance Information", "uuid", uuid, "request_id", RequestID(ctx))
+
+	var s struct {
+		servers.Server
+		availabilityzones.ServerAvailabilityZoneExt
+		extendedstatus.ServerExtendedStatusExt
+		serverusage.UsageExt
+	}
+	// servers.Get doesn't take headers, which carry the global request ID
+	sc := withContext(ctx, i.serviceClient)
+	var r servers.GetResult
+	_, r.Err = sc.Get(sc.ServiceURL("servers", uuid), &r.Body, &gophercloud.RequestOpts{
+		OkCodes:     []int{200, 203},
+		MoreHeaders: requestIDHeaders(ctx),
+	})
+	if err := r.ExtractInto(&s); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Server:                    s.Server,
+		ServerAvailabilityZoneExt: s.ServerAvailabilityZoneExt,
+		ServerExtendedStatusExt:   s.ServerExtendedStatusExt,
+		Region:                    i.region,
+		LaunchedAt:                s.LaunchedAt,
+	}, nil
+}
+
+func (i *Instance) List(ctx context.Context, allTenants bool) ([]*Server, error) {
+	i.Logger.Debug("List Instances", "all_tenants", allTenants)
+
+	var sl []struct {
+		servers.Server
+		availabilityzones.ServerAvailabilityZoneExt
+		extendedstatus.ServerExtendedStatusExt
+		serverusage.UsageExt
+	}
+	pages, err := servers.List(withContext(ctx, i.serviceClient), servers.ListOpts{AllTenants: allTenants}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	if err := servers.ExtractServersInto(pages, &sl); err != nil {
+		return nil, err
+	}
+
+	var result []*Server
+	for _, s := range sl {
+		result = append(result, &Server{
+			Server:                    s.Server,
+			ServerAvailabilityZoneExt: s.ServerAvailabilityZoneExt,
+			ServerExtendedStatusExt:   s.ServerExtendedStatusExt,
+			Region:                    i.region,
+			LaunchedAt:                s.LaunchedAt,
+		})
+	}
+	return result, nil
 }
@@ -0,0 +1,128 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+	"github.com/hashicorp/go-hclog"
+)
+
+// GetBarbicanSecret retrieves the payload of the Barbican secret with the credentials of given cloud entry.
+// The secret can be referenced either by its ID or its href.
+func GetBarbicanSecret(ctx context.Context, cloudName, secretRef string) (string, error) {
+	provider, err := NewProvider(ctx, cloudName)
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate to retrieve Barbican secret: %v", err)
+	}
+	sc, err := openstack.NewKeyManagerV1(provider, gophercloud.EndpointOpts{
+		Region: GetRegion(cloudName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := secrets.GetPayload(withContext(ctx, sc), path.Base(secretRef), nil).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve Barbican secret: %v", err)
+	}
+	return strings.TrimRight(string(payload), "\r\n"), nil
+}
+
+type SecretStoreClient interface {
+	// GetSecretByName retrieves the payload of the newest secret with given name from Provider
+	GetSecretByName(ctx context.Context, name string) ([]byte, bool, error)
+	// StoreSecret stores payload as a new secret with given name and removes the older ones
+	StoreSecret(ctx context.Context, name string, payload []byte) error
+}
+
+// SecretStore represents a OpenStack Key Manager Service (Barbican) client
+type SecretStore struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewSecretStore returns a new OpenStack Key Manager Service client with given provider
+func NewSecretStore(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (SecretStoreClient, error) {
+	sc, err := openstack.NewKeyManagerV1(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStore{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (s *SecretStore) GetSecretByName(ctx context.Context, name string) ([]byte, bool, error) {
+	s.Logger.Debug("Get Secret", "name", name)
+
+	sc := withContext(ctx, s.serviceClient)
+	sl, err := listSecrets(sc, name)
+	if err != nil || len(sl) == 0 {
+		return nil, false, err
+	}
+	newest := sl[len(sl)-1]
+	payload, err := secrets.GetPayload(sc, path.Base(newest.SecretRef), secrets.GetPayloadOpts{
+		PayloadContentType: "application/octet-stream",
+	}).Extract()
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, true, nil
+}
+
+func (s *SecretStore) StoreSecret(ctx context.Context, name string, payload []byte) error {
+	s.Logger.Debug("Store Secret", "name", name)
+
+	sc := withContext(ctx, s.serviceClient)
+	old, err := listSecrets(sc, name)
+	if err != nil {
+		return err
+	}
+	created, err := secrets.Create(sc, secrets.CreateOpts{
+		Name:                   name,
+		Payload:                base64.StdEncoding.EncodeToString(payload),
+		PayloadContentType:     "application/octet-stream",
+		PayloadContentEncoding: "base64",
+		SecretType:             secrets.OpaqueSecret,
+	}).Extract()
+	if err != nil {
+		return err
+	}
+	for _, o := range old {
+		if o.SecretRef == created.SecretRef {
+			continue
+		}
+		if err := secrets.Delete(sc, path.Base(o.SecretRef)).ExtractErr(); err != nil {
+			s.Logger.Warn("Failed to delete old secret", "secret_ref", o.SecretRef, "error", err)
+		}
+	}
+	return nil
+}
+
+// listSecrets returns the secrets with given name, oldest first
+func listSecrets(sc *gophercloud.ServiceClient, name string) ([]secrets.Secret, error) {
+	pages, err := secrets.List(sc, secrets.ListOpts{
+		Name: name,
+		Sort: "created:asc",
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return secrets.ExtractSecrets(pages)
+}
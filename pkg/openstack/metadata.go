@@ -8,42 +8,355 @@
 package openstack
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 const (
 	defaultMetadataVersion = "latest"
-	metadataURLTemplate    = "http://169.254.169.254/openstack/%s/meta_data.json"
+	metadataPath           = "/openstack/%s/meta_data.json"
+	vendorDataPath         = "/openstack/%s/vendor_data2.json"
+	networkDataPath        = "/openstack/%s/network_data.json"
+
+	defaultMetadataTimeout = 10 * time.Second
 )
 
+// metadataServiceIPv6 is the IPv6 link-local address of OpenStack Metadata service, reachable only with the zone of the
+// interface on the network of the instance
+const metadataServiceIPv6 = "fe80::a9fe:a9fe"
+
+// metadataServiceURL is the URL of OpenStack Metadata service, which tests replace
+var metadataServiceURL = "http://169.254.169.254"
+
 // Metadata represents the information fetched from OpenStack metadata service
 type Metadata struct {
 	UUID             string `json:"uuid"`
 	Name             string `json:"name"`
+	Hostname         string `json:"hostname"`
 	AvailabilityZone string `json:"availability_zone"`
 	ProjectID        string `json:"project_id"`
+	// Meta is the metadata of the instance given by users, e.g. secrets provisioned to the instance
+	Meta map[string]string `json:"meta"`
 	// we don't care any other fields.
 }
 
+// MetadataService is a client of OpenStack Metadata service
+type MetadataService struct {
+	// Timeout bounds each request, so that a metadata service which blackholes traffic doesn't hang the caller
+	// without a deadline. Defaults to 10 seconds.
+	Timeout time.Duration
+	// Retry retries requests which failed transiently. A single attempt is made if nil.
+	Retry *RetryPolicy
+	// IPv6 reaches the metadata service at its IPv6 link-local address, for instances on IPv6-only networks
+	IPv6 bool
+	// Interface is the network interface the metadata service is reached on. With IPv6, it scopes the link-local
+	// address, and defaults to the first interface which is up and has an IPv6 link-local address, looked up on each
+	// request so that interfaces brought up after the agent are found. Otherwise requests are sent through it
+	// regardless of the routes, for instances whose default route doesn't reach the metadata service.
+	Interface string
+	// SourceAddress is the local address requests are sent from, e.g. the address of a NIC routed by source policy
+	SourceAddress string
+	// Transport reaches the metadata service through a local proxy, e.g. a sidecar of hardened images, at
+	// "unix:///path/to/socket" for a Unix socket or "http://host:port" for another address. Requests are made to the
+	// metadata service itself if empty. See ValidateMetadataTransport.
+	Transport string
+	// SessionToken acquires a session token sent with each request, for proxies requiring one. Disabled if nil.
+	SessionToken *MetadataSessionToken
+}
+
 // GetMetadataFromMetadataService gets metadata from OpenStack Metadata service.
-func GetMetadataFromMetadataService() (*Metadata, error) {
-	metadataURL := getMetadataURL(defaultMetadataVersion)
-	resp, err := http.Get(metadataURL)
+func GetMetadataFromMetadataService(ctx context.Context) (*Metadata, error) {
+	return (&MetadataService{}).GetMetadata(ctx)
+}
+
+// GetVendorDataFromMetadataService gets the dynamic vendordata of given target from OpenStack Metadata service.
+func GetVendorDataFromMetadataService(ctx context.Context, target string) (json.RawMessage, error) {
+	return (&MetadataService{}).GetVendorData(ctx, target)
+}
+
+// GetNetworkDataFromMetadataService gets the network configuration of the instance from OpenStack Metadata service.
+func GetNetworkDataFromMetadataService(ctx context.Context) (json.RawMessage, error) {
+	return (&MetadataService{}).GetNetworkData(ctx)
+}
+
+// GetMetadata gets the metadata of the instance
+func (m *MetadataService) GetMetadata(ctx context.Context) (*Metadata, error) {
+	var metadata *Metadata
+	err := m.Retry.Do(ctx, func() error {
+		return m.get(ctx, metadataPath, "metadata", func(r io.Reader) (err error) {
+			metadata, err = parseMetadata(r)
+			return err
+		})
+	})
+	return metadata, err
+}
+
+// GetVendorData gets the dynamic vendordata of given target. Nova calls the vendordata services when the instance
+// reads the vendordata, so the data is always current unlike the copy on the config drive.
+func (m *MetadataService) GetVendorData(ctx context.Context, target string) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := m.Retry.Do(ctx, func() error {
+		return m.get(ctx, vendorDataPath, "vendordata", func(r io.Reader) (err error) {
+			data, err = parseVendorData(r, target)
+			return err
+		})
+	})
+	return data, err
+}
+
+// GetNetworkData gets the network configuration of the instance as is, see ParseNetworkData. It is retried, since the
+// fixed IPs and MAC addresses in it may be sent for attestation.
+func (m *MetadataService) GetNetworkData(ctx context.Context) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := m.Retry.Do(ctx, func() error {
+		return m.get(ctx, networkDataPath, "network data", func(r io.Reader) (err error) {
+			data, err = parseNetworkData(r)
+			return err
+		})
+	})
+	return data, err
+}
+
+// parseNetworkData reads network_data.json as is, which must be a JSON document
+func parseNetworkData(r io.Reader) (json.RawMessage, error) {
+	var data json.RawMessage
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("malformed network data: %v", err)
+	}
+	return data, nil
+}
+
+// metadataServiceError is a failure to reach the metadata service, or an error status of it
+type metadataServiceError struct {
+	err       error
+	transient bool
+}
+
+func (e *metadataServiceError) Error() string {
+	return e.err.Error()
+}
+
+// IsTransientMetadataError returns true if err is a failure of the metadata service which may pass on retry,
+// i.e. it couldn't be reached or responded with a server error
+func IsTransientMetadataError(err error) bool {
+	e, ok := err.(*metadataServiceError)
+	return ok && e.transient
+}
+
+// get gets the document at path of the latest version and decodes it with decode
+func (m *MetadataService) get(ctx context.Context, path, what string, decode func(io.Reader) error) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = defaultMetadataTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	base, err := m.url()
+	if err != nil {
+		return &metadataServiceError{err: err, transient: true}
+	}
+	client, err := m.client()
 	if err != nil {
-		return nil, fmt.Errorf("error fetching metadata from %s: %v", metadataURL, err)
+		return err
+	}
+	var token string
+	if m.SessionToken != nil {
+		if token, err = m.SessionToken.get(ctx, client, base); err != nil {
+			return err
+		}
+	}
+	url := base + fmt.Sprintf(path, defaultMetadataVersion)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set(m.SessionToken.header(), token)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return &metadataServiceError{err: fmt.Errorf("error fetching %s from %s: %v", what, url, err), transient: true}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized && token != "" {
+		// A new token is requested on retry
+		m.SessionToken.invalidate(token)
+		return &metadataServiceError{err: fmt.Errorf("session token rejected when reading %s from %s", what, url), transient: true}
+	}
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("unexpected status code when reading metadata from %s: %s", metadataURL, resp.Status)
+		err = fmt.Errorf("unexpected status code when reading %s from %s: %s", what, url, resp.Status)
+		transient := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return &metadataServiceError{err: err, transient: transient}
+	}
+
+	return decode(resp.Body)
+}
+
+// url returns the URL of the metadata service, at the IPv6 link-local address scoped to the interface if IPv6 is set,
+// or of the proxy if Transport is an HTTP URL
+func (m *MetadataService) url() (string, error) {
+	if strings.HasPrefix(m.Transport, "http") {
+		return strings.TrimSuffix(m.Transport, "/"), nil
+	}
+	if !m.IPv6 {
+		return metadataServiceURL, nil
+	}
+	iface := m.Interface
+	if iface == "" {
+		var err error
+		if iface, err = linkLocalInterface(); err != nil {
+			return "", err
+		}
+	}
+	// The zone is escaped in URLs, as RFC 6874 defines
+	return "http://[" + metadataServiceIPv6 + "%25" + url.PathEscape(iface) + "]", nil
+}
+
+// client returns the HTTP client dialing the Unix socket of Transport, if it is one, or dialing from SourceAddress
+// and Interface if they are set
+func (m *MetadataService) client() (*http.Client, error) {
+	if m.Transport == "" && (m.SourceAddress != "" || (m.Interface != "" && !m.IPv6)) {
+		d, err := m.boundDialer()
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext:       d.DialContext,
+				DisableKeepAlives: true,
+			},
+		}, nil
+	}
+	if m.Transport == "" || strings.HasPrefix(m.Transport, "http") {
+		return http.DefaultClient, nil
+	}
+	path, err := metadataSocket(m.Transport)
+	if err != nil {
 		return nil, err
 	}
+	return &http.Client{
+		Transport: &http.Transport{
+			// The host of requests is kept as is for the proxy, and the socket is dialed instead
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+			// Clients are not reused across requests
+			DisableKeepAlives: true,
+		},
+	}, nil
+}
+
+// boundDialer returns the dialer sending from SourceAddress, and through Interface unless it scopes the IPv6 address
+func (m *MetadataService) boundDialer() (*net.Dialer, error) {
+	d := &net.Dialer{}
+	if m.SourceAddress != "" {
+		ip := net.ParseIP(m.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid metadata source address: %q", m.SourceAddress)
+		}
+		addr := &net.TCPAddr{IP: ip}
+		if m.IPv6 && ip.IsLinkLocalUnicast() {
+			// link-local addresses can't be bound without their zone
+			if addr.Zone = m.Interface; addr.Zone == "" {
+				var err error
+				if addr.Zone, err = linkLocalInterface(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		d.LocalAddr = addr
+	}
+	if m.Interface != "" && !m.IPv6 {
+		if err := bindInterface(d, m.Interface); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
 
-	return parseMetadata(resp.Body)
+// ValidateMetadataTransport returns an error unless transport is empty, a Unix socket URL or an HTTP URL without path
+func ValidateMetadataTransport(transport string) error {
+	if transport == "" {
+		return nil
+	}
+	u, err := url.Parse(transport)
+	if err != nil {
+		return fmt.Errorf("invalid metadata transport: %v", err)
+	}
+	switch u.Scheme {
+	case "unix":
+		_, err := metadataSocket(transport)
+		return err
+	case "http", "https":
+		if u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("invalid metadata transport: %q must be of the form http://host:port", transport)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported metadata transport: %q", transport)
+	}
+}
+
+// metadataSocket returns the path of the Unix socket of the transport
+func metadataSocket(transport string) (string, error) {
+	u, err := url.Parse(transport)
+	if err != nil {
+		return "", fmt.Errorf("invalid metadata transport: %v", err)
+	}
+	if u.Scheme != "unix" || u.Host != "" || u.Path == "" {
+		return "", fmt.Errorf("invalid metadata transport: %q must be of the form unix:///path/to/socket", transport)
+	}
+	return u.Path, nil
+}
+
+// linkLocalInterface returns the name of the first interface which is up, is not a loopback and has an IPv6
+// link-local address
+func linkLocalInterface() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list network interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if addrs, err := iface.Addrs(); err == nil && hasLinkLocalIPv6(addrs) {
+			return iface.Name, nil
+		}
+	}
+	return "", errors.New("no network interface with an IPv6 link-local address to reach the metadata service on")
+}
+
+func hasLinkLocalIPv6(addrs []net.Addr) bool {
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() == nil && n.IP.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+func parseVendorData(r io.Reader, target string) (json.RawMessage, error) {
+	var targets map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&targets); err != nil {
+		return nil, err
+	}
+	data, ok := targets[target]
+	if !ok {
+		return nil, fmt.Errorf("no vendordata of target %q", target)
+	}
+	return data, nil
 }
 
 func parseMetadata(r io.Reader) (*Metadata, error) {
@@ -59,7 +372,3 @@ func parseMetadata(r io.Reader) (*Metadata, error) {
 
 	return &metadata, nil
 }
-
-func getMetadataURL(metadataVersion string) string {
-	return fmt.Sprintf(metadataURLTemplate, metadataVersion)
-}
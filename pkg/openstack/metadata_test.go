@@ -0,0 +1,216 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataServiceURL(t *testing.T) {
+	tCase := []struct {
+		m        *MetadataService
+		wantHost string
+	}{
+		// 0: IPv4
+		{m: &MetadataService{}, wantHost: "169.254.169.254"},
+		// 1: IPv6 on the interface
+		{m: &MetadataService{IPv6: true, Interface: "eth0"}, wantHost: "fe80::a9fe:a9fe%eth0"},
+		// 2: local proxy
+		{m: &MetadataService{Transport: "http://127.0.0.1:8775/"}, wantHost: "127.0.0.1"},
+		// 3: Unix socket keeps the host for the proxy
+		{m: &MetadataService{Transport: "unix:///run/metadata.sock"}, wantHost: "169.254.169.254"},
+	}
+
+	for i, c := range tCase {
+		got, err := c.m.url()
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		u, err := url.Parse(got + "/openstack/latest")
+		if err != nil {
+			t.Errorf("#%v: invalid URL %v: %v", i, got, err)
+			continue
+		}
+		if u.Hostname() != c.wantHost {
+			t.Errorf("#%v: got host %v, want %v", i, u.Hostname(), c.wantHost)
+		}
+	}
+}
+
+func TestHasLinkLocalIPv6(t *testing.T) {
+	ipNet := func(s string) net.Addr {
+		ip, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = ip
+		return n
+	}
+
+	tCase := []struct {
+		addrs []net.Addr
+		want  bool
+	}{
+		// 0: link-local along with a global address
+		{addrs: []net.Addr{ipNet("2001:db8::10/64"), ipNet("fe80::f816:3eff:fe12:3456/64")}, want: true},
+		// 1: IPv4 link-local only
+		{addrs: []net.Addr{ipNet("169.254.10.1/16")}},
+		// 2: global only
+		{addrs: []net.Addr{ipNet("2001:db8::10/64")}},
+		// 3: none
+		{},
+	}
+
+	for i, c := range tCase {
+		if got := hasLinkLocalIPv6(c.addrs); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestValidateMetadataTransport(t *testing.T) {
+	tCase := []struct {
+		transport string
+		wantErr   bool
+	}{
+		// 0: direct
+		{transport: ""},
+		// 1: Unix socket
+		{transport: "unix:///run/metadata.sock"},
+		// 2: local proxy
+		{transport: "http://127.0.0.1:8775"},
+		// 3: Unix socket without path
+		{transport: "unix://", wantErr: true},
+		// 4: Unix socket with host
+		{transport: "unix://localhost/run/metadata.sock", wantErr: true},
+		// 5: proxy with path
+		{transport: "http://127.0.0.1:8775/openstack", wantErr: true},
+		// 6: unsupported scheme
+		{transport: "socks5://127.0.0.1:1080", wantErr: true},
+	}
+
+	for i, c := range tCase {
+		if err := ValidateMetadataTransport(c.transport); (err != nil) != c.wantErr {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestMetadataServiceUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "metadata.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "169.254.169.254" || r.URL.Path != "/openstack/latest/meta_data.json" {
+			t.Errorf("unexpected request: %v %v", r.Host, r.URL.Path)
+		}
+		fmt.Fprint(w, `{"uuid": "alpha", "project_id": "bravo"}`)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	meta, err := (&MetadataService{Transport: "unix://" + socket}).GetMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.UUID != "alpha" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+}
+
+func TestMetadataServiceSourceAddress(t *testing.T) {
+	var remote string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}))
+	defer srv.Close()
+
+	tCase := []struct {
+		m       *MetadataService
+		wantErr bool
+	}{
+		// 0: sent from the source address
+		{m: &MetadataService{SourceAddress: "127.0.0.1"}},
+		// 1: not an address
+		{m: &MetadataService{SourceAddress: "localhost"}, wantErr: true},
+		// 2: unknown interface
+		{m: &MetadataService{Interface: "nonexistent0"}, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		remote = ""
+		client, err := c.m.client()
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+		if remote != c.m.SourceAddress {
+			t.Errorf("#%v: request is sent from %v, want %v", i, remote, c.m.SourceAddress)
+		}
+	}
+}
+
+func TestMetadataServiceLinkLocalSourceAddress(t *testing.T) {
+	tCase := []struct {
+		m        *MetadataService
+		wantZone string
+	}{
+		// 0: link-local address scoped with the interface of the metadata service
+		{m: &MetadataService{IPv6: true, Interface: "eth1", SourceAddress: "fe80::f816:3eff:fe00:1"}, wantZone: "eth1"},
+		// 1: global addresses are not scoped
+		{m: &MetadataService{IPv6: true, Interface: "eth1", SourceAddress: "2001:db8::1"}},
+		// 2: not scoped without IPv6
+		{m: &MetadataService{SourceAddress: "fe80::f816:3eff:fe00:1"}},
+	}
+
+	for i, c := range tCase {
+		d, err := c.m.boundDialer()
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		addr, ok := d.LocalAddr.(*net.TCPAddr)
+		if !ok {
+			t.Errorf("#%v: unexpected local address: %v", i, d.LocalAddr)
+			continue
+		}
+		if !addr.IP.Equal(net.ParseIP(c.m.SourceAddress)) || addr.Zone != c.wantZone {
+			t.Errorf("#%v: got local address %v, want %v with zone %q", i, addr, c.m.SourceAddress, c.wantZone)
+		}
+	}
+}
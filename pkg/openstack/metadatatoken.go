@@ -0,0 +1,118 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionTokenPath      = "/latest/api/token"
+	defaultSessionTokenTTL       = 6 * time.Hour
+	defaultSessionTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	defaultSessionTokenHeader    = "X-aws-ec2-metadata-token"
+
+	// maxSessionTokenSize bounds the token read from the proxy
+	maxSessionTokenSize = 4096
+)
+
+// MetadataSessionToken acquires the session token metadata proxies in the style of AWS IMDSv2 require: the token is
+// issued for a PUT request stating its lifetime, and sent in a header of each request for metadata. Tokens are reused
+// until shortly before they expire, so a MetadataSessionToken must not be copied after first use.
+type MetadataSessionToken struct {
+	// Path of the token endpoint. Defaults to "/latest/api/token".
+	Path string
+	// TTL is the lifetime of the tokens requested, in whole seconds. Defaults to 6 hours.
+	TTL time.Duration
+	// TTLHeader is the header of token requests stating the lifetime in seconds. Defaults to
+	// "X-aws-ec2-metadata-token-ttl-seconds".
+	TTLHeader string
+	// Header is the header of requests for metadata carrying the token. Defaults to "X-aws-ec2-metadata-token".
+	Header string
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+func (t *MetadataSessionToken) header() string {
+	if t.Header == "" {
+		return defaultSessionTokenHeader
+	}
+	return t.Header
+}
+
+// get returns the cached token, or requests a new one from the proxy at base if it is due for refresh
+func (t *MetadataSessionToken) get(ctx context.Context, client *http.Client, base string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.refresh) {
+		return t.token, nil
+	}
+
+	path, ttl, ttlHeader := t.Path, t.TTL, t.TTLHeader
+	if path == "" {
+		path = defaultSessionTokenPath
+	}
+	if ttl < time.Second {
+		ttl = defaultSessionTokenTTL
+	}
+	if ttlHeader == "" {
+		ttlHeader = defaultSessionTokenTTLHeader
+	}
+
+	url := base + "/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequest(http.MethodPut, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(ttlHeader, strconv.Itoa(int(ttl/time.Second)))
+	issued := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", &metadataServiceError{err: fmt.Errorf("error fetching session token from %s: %v", url, err), transient: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code when fetching session token from %s: %s", url, resp.Status)
+		transient := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return "", &metadataServiceError{err: err, transient: transient}
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSessionTokenSize))
+	if err != nil {
+		return "", &metadataServiceError{err: fmt.Errorf("error reading session token from %s: %v", url, err), transient: true}
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("empty session token from %s", url)
+	}
+
+	// Refreshed once 90% of the lifetime passed, so that requests in flight don't carry an expired token
+	t.token = token
+	t.refresh = issued.Add(ttl - ttl/10)
+	return token, nil
+}
+
+// invalidate drops the token if the proxy rejected it, e.g. after a restart losing its tokens, unless it was replaced
+// already
+func (t *MetadataSessionToken) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+	}
+}
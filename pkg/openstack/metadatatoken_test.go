@@ -0,0 +1,83 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetadataSessionToken(t *testing.T) {
+	var issued, rejected int
+	valid := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			if ttl := r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"); ttl != "60" {
+				t.Errorf("unexpected ttl: %q", ttl)
+			}
+			issued++
+			valid = fmt.Sprintf("token-%d", issued)
+			fmt.Fprint(w, valid)
+		case r.Method == http.MethodGet && r.URL.Path == "/openstack/latest/meta_data.json":
+			if r.Header.Get("X-aws-ec2-metadata-token") != valid {
+				rejected++
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"uuid": "alpha", "project_id": "bravo"}`)
+		default:
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	m := &MetadataService{
+		Transport:    srv.URL,
+		Retry:        &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		SessionToken: &MetadataSessionToken{TTL: time.Minute},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.GetMetadata(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if issued != 1 || rejected != 0 {
+		t.Errorf("the token should be reused: issued %v, rejected %v", issued, rejected)
+	}
+
+	// The proxy lost its tokens, and a new one is requested on retry
+	valid = "lost"
+	if _, err := m.GetMetadata(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if issued != 2 || rejected != 1 {
+		t.Errorf("the token should be requested again: issued %v, rejected %v", issued, rejected)
+	}
+}
+
+func TestMetadataSessionTokenUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer srv.Close()
+
+	m := &MetadataService{Transport: srv.URL, SessionToken: &MetadataSessionToken{}}
+	_, err := m.GetMetadata(context.Background())
+	if err == nil {
+		t.Fatal("an error expected, got nil")
+	}
+	if IsTransientMetadataError(err) {
+		t.Errorf("unexpected transient error: %v", err)
+	}
+}
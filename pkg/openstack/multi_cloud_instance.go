@@ -0,0 +1,92 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// MultiCloudInstance is an InstanceClient looking up instances in several clouds, e.g. the regions of a deployment
+// sharing Keystone. Clouds without a client are retried on lookups, so that a cloud down on startup joins once
+// it recovers.
+type MultiCloudInstance struct {
+	Logger    hclog.Logger
+	clouds    []string
+	newClient func(ctx context.Context, cloud string) (InstanceClient, error)
+
+	mtx     sync.Mutex
+	clients map[string]InstanceClient
+}
+
+// NewMultiCloudInstance returns an InstanceClient looking up instances in given clouds in order.
+// clients are the clients prepared in advance, and newClient prepares the ones of the other clouds.
+func NewMultiCloudInstance(clouds []string, clients map[string]InstanceClient, newClient func(context.Context, string) (InstanceClient, error), logger hclog.Logger) InstanceClient {
+	i := &MultiCloudInstance{
+		Logger:    logger,
+		clouds:    clouds,
+		newClient: newClient,
+		clients:   make(map[string]InstanceClient),
+	}
+	for cloud, c := range clients {
+		i.clients[cloud] = c
+	}
+	return i
+}
+
+// Get looks up the instance in each cloud until it is found. The instance is not found only if no cloud
+// has it, so a failure of any cloud is returned instead, since the instance may be in that cloud.
+func (i *MultiCloudInstance) Get(ctx context.Context, uuid string) (*Server, error) {
+	var failure, notFound error
+	for _, cloud := range i.clouds {
+		c, err := i.client(ctx, cloud)
+		if err != nil {
+			i.Logger.Warn("Cloud is unavailable", "cloud", cloud, "error", err)
+			if failure == nil {
+				failure = err
+			}
+			continue
+		}
+		s, err := c.Get(ctx, uuid)
+		if err == nil {
+			return s, nil
+		}
+		if !IsNotFound(err) {
+			if failure == nil {
+				failure = err
+			}
+			continue
+		}
+		i.Logger.Debug("Instance not found in cloud", "uuid", uuid, "cloud", cloud)
+		notFound = err
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	return nil, notFound
+}
+
+// client returns the client of the cloud, preparing it if it isn't yet.
+// Failures are not kept, so that the next lookup retries.
+func (i *MultiCloudInstance) client(ctx context.Context, cloud string) (InstanceClient, error) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if c, ok := i.clients[cloud]; ok {
+		return c, nil
+	}
+	c, err := i.newClient(ctx, cloud)
+	if err != nil {
+		return nil, err
+	}
+	i.Logger.Info("Cloud became available", "cloud", cloud)
+	i.clients[cloud] = c
+	return c, nil
+}
@@ -0,0 +1,73 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// failingInstance fails every lookup, as a cloud whose API is down
+type failingInstance struct{}
+
+func (failingInstance) Get(context.Context, string) (*Server, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestMultiCloudInstance(t *testing.T) {
+	var lookups []string
+	// projectInstance stands for a cloud which has the instances mapped to its name
+	instances := map[string]string{"123": "bravo", "456": "alpha"}
+	bravoUp := false
+	ic := NewMultiCloudInstance([]string{"alpha", "bravo"}, map[string]InstanceClient{
+		"alpha": &projectInstance{projectID: "alpha", instances: instances, lookups: &lookups},
+	}, func(_ context.Context, cloud string) (InstanceClient, error) {
+		if !bravoUp {
+			return nil, errors.New("keystone is down")
+		}
+		return &projectInstance{projectID: cloud, instances: instances, lookups: &lookups}, nil
+	}, testutil.TestLogger())
+
+	// Instances of usable clouds are found while another cloud is down
+	if _, err := ic.Get(context.Background(), "456"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Instances not found in usable clouds may be in the cloud which is down
+	_, err := ic.Get(context.Background(), "123")
+	if err == nil || IsNotFound(err) {
+		t.Errorf("got %v, want the failure of the cloud", err)
+	}
+
+	// The cloud joins once it recovers
+	bravoUp = true
+	s, err := ic.Get(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.TenantID != "bravo" {
+		t.Errorf("got instance of %v, want bravo", s.TenantID)
+	}
+	if _, err := ic.Get(context.Background(), "789"); !IsNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+}
+
+func TestMultiCloudInstanceFailure(t *testing.T) {
+	ic := NewMultiCloudInstance([]string{"alpha", "bravo"}, map[string]InstanceClient{
+		"alpha": failingInstance{},
+		"bravo": &projectInstance{projectID: "bravo", instances: map[string]string{}, lookups: &[]string{}},
+	}, nil, testutil.TestLogger())
+
+	_, err := ic.Get(context.Background(), "123")
+	if err == nil || IsNotFound(err) {
+		t.Errorf("got %v, want the failure of the cloud", err)
+	}
+}
@@ -0,0 +1,52 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/hashicorp/go-hclog"
+)
+
+type NetworkClient interface {
+	// ListPorts retrieves the ports attached to given device from Provider
+	ListPorts(ctx context.Context, deviceID string) ([]ports.Port, error)
+}
+
+// Network represents a OpenStack Networking Service client
+type Network struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewNetwork returns a new OpenStack Networking Service client with given provider
+func NewNetwork(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (NetworkClient, error) {
+	sc, err := openstack.NewNetworkV2(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Network{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (n *Network) ListPorts(ctx context.Context, deviceID string) ([]ports.Port, error) {
+	n.Logger.Debug("List Ports", "device_id", deviceID)
+
+	pages, err := ports.List(withContext(ctx, n.serviceClient), ports.ListOpts{DeviceID: deviceID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return ports.ExtractPorts(pages)
+}
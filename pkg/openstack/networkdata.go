@@ -0,0 +1,89 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// NetworkData represents network_data.json of the instance, which Nova renders from the Neutron ports attached to it
+type NetworkData struct {
+	Links    []NetworkLink    `json:"links"`
+	Networks []NetworkConfig  `json:"networks"`
+	Services []NetworkService `json:"services"`
+}
+
+// NetworkLink is a layer 2 interface of the instance, e.g. a port of type "ovs" or a "vlan" on top of another link
+type NetworkLink struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	MACAddress string `json:"ethernet_mac_address"`
+	MTU        int    `json:"mtu"`
+	// VIFID is the ID of the Neutron port of the link
+	VIFID string `json:"vif_id"`
+}
+
+// NetworkConfig is a layer 3 configuration of a link, e.g. "ipv4" with a fixed IP or "ipv4_dhcp" without one
+type NetworkConfig struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Link      string `json:"link"`
+	IPAddress string `json:"ip_address"`
+	Netmask   string `json:"netmask"`
+	// NetworkID is the ID of the Neutron network
+	NetworkID string `json:"network_id"`
+}
+
+// NetworkService is a service of the networks, e.g. of type "dns"
+type NetworkService struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// ParseNetworkData parses network_data.json as retrieved by MetadataService.GetNetworkData or
+// GetNetworkDataFromConfigDrive
+func ParseNetworkData(data json.RawMessage) (*NetworkData, error) {
+	n := &NetworkData{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("malformed network data: %v", err)
+	}
+	return n, nil
+}
+
+// FixedIPs returns the fixed IPs of the networks in canonical form, without duplicates. Networks configured by DHCP
+// have none.
+func (n *NetworkData) FixedIPs() []string {
+	var ips []string
+	seen := make(map[string]bool)
+	for _, nw := range n.Networks {
+		ip := net.ParseIP(nw.IPAddress)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// MACAddresses returns the MAC addresses of the links in canonical form, without duplicates
+func (n *NetworkData) MACAddresses() []string {
+	var macs []string
+	seen := make(map[string]bool)
+	for _, l := range n.Links {
+		mac, err := net.ParseMAC(l.MACAddress)
+		if err != nil || seen[mac.String()] {
+			continue
+		}
+		seen[mac.String()] = true
+		macs = append(macs, mac.String())
+	}
+	return macs
+}
@@ -0,0 +1,55 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testNetworkData = `{
+  "links": [
+    {"id": "tap0", "type": "ovs", "ethernet_mac_address": "FA:16:3E:00:00:01", "mtu": 1450, "vif_id": "port-alpha"},
+    {"id": "vlan0", "type": "vlan", "ethernet_mac_address": "fa:16:3e:00:00:01", "vlan_link": "tap0"},
+    {"id": "tap1", "type": "ovs", "ethernet_mac_address": "fa:16:3e:00:00:02", "vif_id": "port-bravo"}
+  ],
+  "networks": [
+    {"id": "network0", "type": "ipv4", "link": "tap0", "ip_address": "192.0.2.10", "netmask": "255.255.255.0", "network_id": "net-alpha"},
+    {"id": "network1", "type": "ipv6", "link": "tap0", "ip_address": "2001:DB8::10", "network_id": "net-alpha"},
+    {"id": "network2", "type": "ipv4_dhcp", "link": "tap1", "network_id": "net-bravo"}
+  ],
+  "services": [{"type": "dns", "address": "192.0.2.1"}]
+}`
+
+func TestParseNetworkData(t *testing.T) {
+	n, err := ParseNetworkData(json.RawMessage(testNetworkData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(n.Links) != 3 || n.Links[0].VIFID != "port-alpha" || n.Links[0].MTU != 1450 {
+		t.Errorf("unexpected links: %+v", n.Links)
+	}
+	if len(n.Networks) != 3 || n.Networks[0].NetworkID != "net-alpha" || n.Networks[0].Link != "tap0" {
+		t.Errorf("unexpected networks: %+v", n.Networks)
+	}
+	if len(n.Services) != 1 || n.Services[0].Address != "192.0.2.1" {
+		t.Errorf("unexpected services: %+v", n.Services)
+	}
+
+	if want := []string{"192.0.2.10", "2001:db8::10"}; !reflect.DeepEqual(n.FixedIPs(), want) {
+		t.Errorf("expected fixed IPs %v, got %v", want, n.FixedIPs())
+	}
+	if want := []string{"fa:16:3e:00:00:01", "fa:16:3e:00:00:02"}; !reflect.DeepEqual(n.MACAddresses(), want) {
+		t.Errorf("expected MAC addresses %v, got %v", want, n.MACAddresses())
+	}
+
+	if _, err := ParseNetworkData(json.RawMessage(`{"links": {}}`)); err == nil {
+		t.Error("an error expected for malformed network data, got nil")
+	}
+}
@@ -0,0 +1,64 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/containers"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
+	"github.com/hashicorp/go-hclog"
+)
+
+type ObjectStorageClient interface {
+	// EnsurePublicContainer creates the container, if needed, and makes its objects world-readable
+	EnsurePublicContainer(ctx context.Context, container string) error
+	// PutObject uploads an object to the container
+	PutObject(ctx context.Context, container, name, contentType, cacheControl string, body []byte) error
+}
+
+// ObjectStorage represents a OpenStack Object Storage Service (Swift) client
+type ObjectStorage struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewObjectStorage returns a new OpenStack Object Storage Service client with given provider
+func NewObjectStorage(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (ObjectStorageClient, error) {
+	sc, err := openstack.NewObjectStorageV1(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStorage{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (o *ObjectStorage) EnsurePublicContainer(ctx context.Context, container string) error {
+	o.Logger.Debug("Ensure Public Container", "container", container)
+
+	return containers.Create(withContext(ctx, o.serviceClient), container, containers.CreateOpts{
+		ContainerRead: ".r:*",
+	}).Err
+}
+
+func (o *ObjectStorage) PutObject(ctx context.Context, container, name, contentType, cacheControl string, body []byte) error {
+	o.Logger.Debug("Put Object", "container", container, "name", name)
+
+	return objects.Create(withContext(ctx, o.serviceClient), container, name, objects.CreateOpts{
+		Content:      bytes.NewReader(body),
+		ContentType:  contentType,
+		CacheControl: cacheControl,
+	}).Err
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// ProbeDMI, ProbeConfigDrive and ProbeMetadataService tell how ProbeInstance found the host to be an OpenStack
+	// instance
+	ProbeDMI             = "dmi"
+	ProbeConfigDrive     = "config_drive"
+	ProbeMetadataService = "metadata_service"
+
+	probePath = "/openstack/%s"
+)
+
+// ErrNotOpenStack is wrapped by the error of ProbeInstance if nothing tells that the host is an OpenStack instance
+var ErrNotOpenStack = errors.New("not an OpenStack instance")
+
+// ProbeInstance returns how the host was found to be an OpenStack instance: ProbeDMI if the DMI table names OpenStack,
+// ProbeConfigDrive if a config drive is attached, or ProbeMetadataService if the metadata service answers. The local
+// signs are checked first, so that the metadata service is asked only on clouds customizing the DMI table. The error
+// tells what each probe found otherwise.
+func ProbeInstance(ctx context.Context, m *MetadataService) (string, error) {
+	var findings []string
+
+	vendor, product, err := readDMISystem()
+	switch {
+	case err != nil:
+		findings = append(findings, fmt.Sprintf("DMI table unreadable: %v", err))
+	case IsOpenStackDMI(vendor, product):
+		return ProbeDMI, nil
+	default:
+		findings = append(findings, fmt.Sprintf("DMI table names vendor %q and product %q", vendor, product))
+	}
+
+	_, cleanup, err := findConfigDrive()
+	if err == nil {
+		cleanup()
+		return ProbeConfigDrive, nil
+	}
+	findings = append(findings, fmt.Sprintf("no config drive: %v", err))
+
+	if err := m.Probe(ctx); err != nil {
+		findings = append(findings, fmt.Sprintf("no metadata service: %v", err))
+		return "", fmt.Errorf("%w: %v", ErrNotOpenStack, strings.Join(findings, "; "))
+	}
+	return ProbeMetadataService, nil
+}
+
+// IsOpenStackDMI returns whether the system vendor or product of the DMI table names OpenStack, e.g. "OpenStack
+// Foundation" and "OpenStack Nova" set by Nova, or "OpenStack Compute" of some distributions
+func IsOpenStackDMI(vendor, product string) bool {
+	return strings.Contains(vendor, "OpenStack") || strings.Contains(product, "OpenStack")
+}
+
+// Probe returns nil if the metadata service answers with the OpenStack metadata. Unlike the other requests, it is not
+// retried, so that hosts off OpenStack learn it within the timeout.
+func (m *MetadataService) Probe(ctx context.Context) error {
+	return m.get(ctx, probePath, "metadata index", func(r io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	})
+}
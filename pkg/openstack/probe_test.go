@@ -0,0 +1,92 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsOpenStackDMI(t *testing.T) {
+	tCase := []struct {
+		vendor  string
+		product string
+		want    bool
+	}{
+		// 0: Nova
+		{vendor: "OpenStack Foundation", product: "OpenStack Nova", want: true},
+		// 1: vendor customized
+		{vendor: "Example Cloud", product: "OpenStack Compute", want: true},
+		// 2: other hypervisor
+		{vendor: "QEMU", product: "Standard PC (i440FX + PIIX, 1996)"},
+		// 3: empty
+		{},
+	}
+
+	for i, c := range tCase {
+		if got := IsOpenStackDMI(c.vendor, c.product); got != c.want {
+			t.Errorf("#%v: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestMetadataServiceProbe(t *testing.T) {
+	tCase := []struct {
+		status  int
+		wantErr bool
+	}{
+		// 0: OpenStack metadata
+		{status: http.StatusOK},
+		// 1: other metadata service
+		{status: http.StatusNotFound, wantErr: true},
+		// 2: not retried
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/openstack/latest" {
+				t.Errorf("#%v: unexpected path: %v", i, r.URL.Path)
+			}
+			attempts++
+			w.WriteHeader(c.status)
+			fmt.Fprint(w, "meta_data.json\nvendor_data2.json\n")
+		}))
+		metadataServiceURL = srv.URL
+
+		err := (&MetadataService{Retry: &RetryPolicy{MaxAttempts: 3}}).Probe(context.Background())
+		srv.Close()
+
+		if attempts != 1 {
+			t.Errorf("#%v: got %v attempts, want 1", i, attempts)
+		}
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestMetadataServiceProbeUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	metadataServiceURL = srv.URL
+	srv.Close()
+
+	if err := (&MetadataService{}).Probe(context.Background()); err == nil {
+		t.Error("an error expected, got nil")
+	}
+}
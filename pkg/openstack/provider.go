@@ -8,26 +8,100 @@
 package openstack
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
 	"github.com/gophercloud/utils/openstack/clientconfig"
 )
 
 // NewProvider returns a new authenticated ProviderClient
-func NewProvider(cloudName string) (*gophercloud.ProviderClient, error) {
+func NewProvider(ctx context.Context, cloudName string) (*gophercloud.ProviderClient, error) {
+	authOpts, err := cloudAuthOptions(cloudName)
+	if err != nil {
+		return nil, err
+	}
+	return authenticate(ctx, authOpts, nil)
+}
+
+// cloudAuthOptions returns AuthOptions of the cloud entry in clouds.yaml
+func cloudAuthOptions(cloudName string) (*gophercloud.AuthOptions, error) {
 	opts := &clientconfig.ClientOpts{
 		Cloud: cloudName,
 	}
-	authOpts, err := clientconfig.AuthOptions(opts)
+	return clientconfig.AuthOptions(opts)
+}
+
+// authenticate returns a ProviderClient authenticated with authOpts. The default HTTP client is used if client is nil.
+func authenticate(ctx context.Context, authOpts *gophercloud.AuthOptions, client *http.Client) (*gophercloud.ProviderClient, error) {
+	authOpts.AllowReauth = true
+
+	provider, err := openstack.NewClient(authOpts.IdentityEndpoint)
 	if err != nil {
 		return nil, err
 	}
-	authOpts.AllowReauth = true
+	if client != nil {
+		provider.HTTPClient = *client
+	}
 
-	provider, err := openstack.AuthenticatedClient(*authOpts)
+	// Only the first authentication is bound to ctx. Authenticate would hand a copy of provider, ctx included, to
+	// the re-authentication, so it is set up here instead.
+	opts := *authOpts
+	opts.AllowReauth = false
+	provider.Context = ctx
+	err = openstack.Authenticate(provider, opts)
+	provider.Context = nil
 	if err != nil {
 		return nil, err
 	}
+	opts.AllowReauth = true
+	provider.ReauthFunc = func() error {
+		return openstack.Authenticate(provider, opts)
+	}
 
+	track(provider)
 	return provider, nil
 }
+
+// GetRegion returns the region name configured for the given cloud entry.
+// It falls back to OS_REGION_NAME when clouds.yaml doesn't define one.
+func GetRegion(cloudName string) string {
+	if cloudName != "" {
+		cloud, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: cloudName})
+		if err == nil && cloud.RegionName != "" {
+			return cloud.RegionName
+		}
+	}
+	return os.Getenv("OS_REGION_NAME")
+}
+
+// Probe checks that Keystone and Nova of the cloud entry are reachable with given credentials.
+// The error tells which service failed.
+func Probe(ctx context.Context, cloudName string, auth *AuthConfig) error {
+	provider, err := NewProviderWithAuth(ctx, cloudName, auth)
+	if err != nil {
+		return fmt.Errorf("keystone: %v", err)
+	}
+	// Each probe authenticates anew, so the token is not held for revocation on shutdown
+	untrack(provider)
+	sc, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{
+		Region: GetRegion(cloudName),
+	})
+	if err != nil {
+		return fmt.Errorf("nova: %v", err)
+	}
+
+	// the first page is enough to see that Nova responds
+	err = servers.List(withContext(ctx, sc), servers.ListOpts{Limit: 1}).EachPage(func(pagination.Page) (bool, error) {
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("nova: %v", err)
+	}
+	return nil
+}
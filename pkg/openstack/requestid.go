@@ -0,0 +1,56 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// RequestIDHeader is the header carrying the global request ID, which OpenStack services log along with their own
+// request IDs, so that the requests of one attestation can be found in the logs of the cloud
+const RequestIDHeader = "X-OpenStack-Request-ID"
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random ID in the format of the global request IDs of OpenStack, "req-" and a UUID, which
+// services ignore in other formats
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("req-%032x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("req-%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WithRequestID returns a context sending the global request ID with the requests made with it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the global request ID of the context, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// requestIDHeaders returns the headers sending the global request ID of the context, or nil if there is none
+func requestIDHeaders(ctx context.Context) map[string]string {
+	requestID := RequestID(ctx)
+	if requestID == "" {
+		return nil
+	}
+	return map[string]string{RequestIDHeader: requestID}
+}
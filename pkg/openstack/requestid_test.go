@@ -0,0 +1,67 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+func TestNewRequestID(t *testing.T) {
+	// the format oslo.middleware accepts as a global request ID
+	format := regexp.MustCompile(`^req-[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$`)
+	a, b := NewRequestID(), NewRequestID()
+	if !format.MatchString(a) {
+		t.Errorf("unexpected format: %q", a)
+	}
+	if a == b {
+		t.Errorf("request IDs are not unique: %q", a)
+	}
+}
+
+func TestInstanceGetRequestID(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(RequestIDHeader))
+		fmt.Fprint(w, `{"server": {"id": "alpha", "tenant_id": "bravo"}}`)
+	}))
+	defer ts.Close()
+
+	provider, err := openstack.NewClient(ts.URL + "/v3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Instance{
+		Logger:        testutil.TestLogger(),
+		serviceClient: &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: ts.URL + "/v2.1/"},
+	}
+
+	requestID := NewRequestID()
+	s, err := i.Get(WithRequestID(context.Background(), requestID), "alpha")
+	if err != nil {
+		t.Fatalf("unexpected error from Get(): %v", err)
+	}
+	if s.ID != "alpha" || s.TenantID != "bravo" {
+		t.Errorf("unexpected server: %+v", s)
+	}
+	if _, err := i.Get(context.Background(), "alpha"); err != nil {
+		t.Fatalf("unexpected error from Get(): %v", err)
+	}
+	if len(got) != 2 || got[0] != requestID || got[1] != "" {
+		t.Errorf("unexpected request IDs: %q", got)
+	}
+}
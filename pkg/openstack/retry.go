@@ -0,0 +1,76 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries requests to the metadata service which failed transiently, e.g. while the network of the
+// instance is coming up during boot, with exponential backoff. A nil policy makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for every retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay randomized, from 0 for none to 1, so that instances booted together don't
+	// retry in lockstep
+	Jitter float64
+
+	sleep func(time.Duration)
+}
+
+// Do calls fn until it succeeds, fails permanently, the attempts are exhausted or ctx is done, and returns the last
+// error
+func (r *RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	err := fn()
+	if r == nil {
+		return err
+	}
+	for attempt := 1; attempt < r.MaxAttempts && IsTransientMetadataError(err); attempt++ {
+		if !r.wait(ctx, r.delay(attempt)) {
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+// wait waits for d, and returns false if ctx is done first
+func (r *RetryPolicy) wait(ctx context.Context, d time.Duration) bool {
+	if r.sleep != nil {
+		r.sleep(d)
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// delay returns the delay before the retry of the attempt, counted from 1
+func (r *RetryPolicy) delay(attempt int) time.Duration {
+	d := r.BaseDelay
+	for i := 1; i < attempt && (r.MaxDelay <= 0 || d < r.MaxDelay); i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		d -= time.Duration(r.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
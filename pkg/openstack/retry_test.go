@@ -0,0 +1,145 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetMetadataFromMetadataServiceWithRetry(t *testing.T) {
+	tCase := []struct {
+		// responses are the status codes of the attempts
+		responses    []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		// 0: available
+		{responses: []int{http.StatusOK}, wantAttempts: 1},
+		// 1: available after server errors
+		{responses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, wantAttempts: 3},
+		// 2: attempts exhausted
+		{responses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}, wantAttempts: 3, wantErr: true},
+		// 3: not found is not retried
+		{responses: []int{http.StatusNotFound, http.StatusOK}, wantAttempts: 1, wantErr: true},
+	}
+
+	for i, c := range tCase {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/openstack/latest/meta_data.json" {
+				t.Errorf("#%v: unexpected path: %v", i, r.URL.Path)
+			}
+			w.WriteHeader(c.responses[attempts])
+			attempts++
+			fmt.Fprint(w, `{"uuid": "alpha", "project_id": "bravo"}`)
+		}))
+		metadataServiceURL = srv.URL
+
+		var delays []time.Duration
+		policy := &RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Minute,
+			sleep:       func(d time.Duration) { delays = append(delays, d) },
+		}
+		meta, err := (&MetadataService{Retry: policy}).GetMetadata(context.Background())
+		srv.Close()
+
+		if attempts != c.wantAttempts {
+			t.Errorf("#%v: got %v attempts, want %v", i, attempts, c.wantAttempts)
+		}
+		if len(delays) != attempts-1 {
+			t.Errorf("#%v: slept %v times for %v attempts", i, len(delays), attempts)
+		} else if len(delays) == 2 && (delays[0] != time.Second || delays[1] != 2*time.Second) {
+			t.Errorf("#%v: unexpected delays: %v", i, delays)
+		}
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if meta.UUID != "alpha" {
+			t.Errorf("#%v: unexpected metadata: %+v", i, meta)
+		}
+	}
+}
+
+func TestMetadataServiceUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	metadataServiceURL = srv.URL
+	srv.Close()
+
+	if _, err := GetMetadataFromMetadataService(context.Background()); !IsTransientMetadataError(err) {
+		t.Errorf("got %v, want a transient error", err)
+	}
+}
+
+func TestMetadataServiceTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// blackholes the request until the client gives up
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	metadataServiceURL = srv.URL
+
+	// The request is bounded by the timeout of the client
+	start := time.Now()
+	_, err := (&MetadataService{Timeout: 50 * time.Millisecond}).GetMetadata(context.Background())
+	if !IsTransientMetadataError(err) {
+		t.Errorf("got %v, want a transient error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v", elapsed)
+	}
+
+	// and by the deadline of the caller, which also stops retries
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Second}
+	if _, err := (&MetadataService{Retry: policy}).GetMetadata(ctx); err == nil {
+		t.Error("an error expected, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retries took %v after the deadline", elapsed)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	r := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 5: 5 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if got := r.delay(attempt); got != want {
+			t.Errorf("#%v: got %v, want %v", attempt, got, want)
+		}
+	}
+
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := r.delay(3); got <= 2*time.Second || got > 4*time.Second {
+			t.Fatalf("delay with jitter out of range: %v", got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+)
+
+// issued holds the providers authenticated by this process, whose tokens are revoked on shutdown.
+// Providers of the token cache are not held, since the token must outlive the process.
+var issued = struct {
+	mtx       sync.Mutex
+	providers map[*gophercloud.ProviderClient]bool
+}{providers: make(map[*gophercloud.ProviderClient]bool)}
+
+func track(provider *gophercloud.ProviderClient) {
+	issued.mtx.Lock()
+	defer issued.mtx.Unlock()
+	issued.providers[provider] = true
+}
+
+func untrack(provider *gophercloud.ProviderClient) {
+	issued.mtx.Lock()
+	defer issued.mtx.Unlock()
+	delete(issued.providers, provider)
+}
+
+// RevokeTokens revokes the Keystone tokens issued to this process, so that they don't stay valid for hours after
+// the plugin stopped. Persisted tokens of the token cache are kept. Clients must not be used afterwards.
+func RevokeTokens(ctx context.Context) error {
+	issued.mtx.Lock()
+	providers := issued.providers
+	issued.providers = make(map[*gophercloud.ProviderClient]bool)
+	issued.mtx.Unlock()
+
+	var failed []string
+	for provider := range providers {
+		if err := revokeToken(ctx, provider); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to revoke %v of %v tokens: %v", len(failed), len(providers), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// revokeToken revokes the current token of the provider at the Keystone it was issued by
+func revokeToken(ctx context.Context, provider *gophercloud.ProviderClient) error {
+	token := provider.Token()
+	if token == "" {
+		return nil
+	}
+	sc, err := openstack.NewIdentityV3(providerWithContext(ctx, provider), gophercloud.EndpointOpts{})
+	if err != nil {
+		return err
+	}
+	return tokens.Revoke(sc, token).ExtractErr()
+}
@@ -0,0 +1,65 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack"
+)
+
+func TestRevokeTokens(t *testing.T) {
+	var mtx sync.Mutex
+	var revoked []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/v3/auth/tokens" {
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mtx.Lock()
+		revoked = append(revoked, r.Header.Get("X-Subject-Token"))
+		mtx.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	provider, err := openstack.NewClient(ts.URL + "/v3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.SetToken("token-1")
+	persisted, err := openstack.NewClient(ts.URL + "/v3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted.SetToken("token-2")
+
+	track(provider)
+	track(persisted)
+	untrack(persisted)
+
+	if err := RevokeTokens(context.Background()); err != nil {
+		t.Fatalf("unexpected error from RevokeTokens(): %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != "token-1" {
+		t.Errorf("unexpected revoked tokens: %v", revoked)
+	}
+
+	// Tokens are revoked once
+	if err := RevokeTokens(context.Background()); err != nil {
+		t.Fatalf("unexpected error from RevokeTokens(): %v", err)
+	}
+	if len(revoked) != 1 {
+		t.Errorf("unexpected revoked tokens: %v", revoked)
+	}
+}
@@ -0,0 +1,130 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/cache"
+)
+
+const rolesKeyPrefix = "roles:"
+
+type RoleClient interface {
+	// ListRoles retrieves the names of the roles effectively assigned on the project from Keystone.
+	// If userID is set, only the roles of the user are retrieved.
+	ListRoles(ctx context.Context, projectID, userID string) ([]string, error)
+}
+
+// Role represents a OpenStack Identity Service client for role assignments
+type Role struct {
+	Logger        hclog.Logger
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewRole returns a new OpenStack Identity Service client with given provider
+func NewRole(client *gophercloud.ProviderClient, region string, logger hclog.Logger) (RoleClient, error) {
+	sc, err := openstack.NewIdentityV3(client, gophercloud.EndpointOpts{
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Role{
+		Logger:        logger,
+		serviceClient: sc,
+	}, nil
+}
+
+func (r *Role) ListRoles(ctx context.Context, projectID, userID string) ([]string, error) {
+	r.Logger.Debug("List Role Assignments", "project_id", projectID, "user_id", userID, "request_id", RequestID(ctx))
+
+	q := url.Values{}
+	q.Set("scope.project.id", projectID)
+	if userID != "" {
+		q.Set("user.id", userID)
+	}
+	q.Set("effective", "")
+	q.Set("include_names", "true")
+
+	var resp struct {
+		RoleAssignments []struct {
+			Role struct {
+				Name string `json:"name"`
+			} `json:"role"`
+		} `json:"role_assignments"`
+	}
+	sc := withContext(ctx, r.serviceClient)
+	_, err := sc.Get(sc.ServiceURL("role_assignments")+"?"+q.Encode(), &resp, &gophercloud.RequestOpts{
+		MoreHeaders: requestIDHeaders(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var roles []string
+	for _, ra := range resp.RoleAssignments {
+		if name := ra.Role.Name; !seen[name] {
+			seen[name] = true
+			roles = append(roles, name)
+		}
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+// CachedRole is a RoleClient which caches role assignments
+type CachedRole struct {
+	Logger hclog.Logger
+	client RoleClient
+	cache  cache.Cache
+	ttl    time.Duration
+}
+
+// NewCachedRole returns a RoleClient which caches results of given client for ttl
+func NewCachedRole(client RoleClient, c cache.Cache, ttl time.Duration, logger hclog.Logger) RoleClient {
+	return &CachedRole{
+		Logger: logger,
+		client: client,
+		cache:  c,
+		ttl:    ttl,
+	}
+}
+
+func (r *CachedRole) ListRoles(ctx context.Context, projectID, userID string) ([]string, error) {
+	key := rolesKeyPrefix + projectID + ":" + userID
+	if b, ok, err := r.cache.Get(key); err != nil {
+		r.Logger.Warn("Failed to lookup role cache", "project_id", projectID, "user_id", userID, "error", err)
+	} else if ok {
+		var roles []string
+		if err := json.Unmarshal(b, &roles); err == nil {
+			return roles, nil
+		}
+		r.Logger.Warn("Failed to decode cached roles", "project_id", projectID, "user_id", userID, "error", err)
+	}
+
+	roles, err := r.client.ListRoles(ctx, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(roles); err == nil {
+		if err := r.cache.Set(key, b, r.ttl); err != nil {
+			r.Logger.Warn("Failed to store role cache", "project_id", projectID, "user_id", userID, "error", err)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,127 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type projectHintKey struct{}
+
+// WithProjectHint returns a context hinting the project the instance to retrieve belongs to
+func WithProjectHint(ctx context.Context, projectID string) context.Context {
+	if projectID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, projectHintKey{}, projectID)
+}
+
+func projectHint(ctx context.Context) string {
+	projectID, _ := ctx.Value(projectHintKey{}).(string)
+	return projectID
+}
+
+// ProjectScopedInstance is an InstanceClient retrieving instances with tokens scoped to their projects,
+// so that instances of multiple projects can be retrieved without admin credentials
+type ProjectScopedInstance struct {
+	Logger    hclog.Logger
+	projects  []string
+	newClient func(ctx context.Context, projectID string) (InstanceClient, error)
+
+	mtx     sync.Mutex
+	clients map[string]InstanceClient
+}
+
+// NewProjectScopedInstance returns an InstanceClient looking up instances in given projects.
+// newClient returns a client authenticated with a token scoped to the project, which is kept for later lookups.
+func NewProjectScopedInstance(projects []string, newClient func(context.Context, string) (InstanceClient, error), logger hclog.Logger) InstanceClient {
+	return &ProjectScopedInstance{
+		Logger:    logger,
+		projects:  projects,
+		newClient: newClient,
+		clients:   make(map[string]InstanceClient),
+	}
+}
+
+// Get looks up the instance in the project hinted by the context first, and then in the other projects.
+// Hints of projects not configured are ignored.
+func (i *ProjectScopedInstance) Get(ctx context.Context, uuid string) (*Server, error) {
+	var lastErr error
+	for _, projectID := range i.lookupOrder(projectHint(ctx)) {
+		c, err := i.client(ctx, projectID)
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.Get(ctx, uuid)
+		if err == nil {
+			return s, nil
+		}
+		if !IsNotFound(err) {
+			return nil, err
+		}
+		i.Logger.Debug("Instance not found in project", "uuid", uuid, "project_id", projectID)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// List lists the instances of each project with its scoped token. allTenants is ignored, since
+// a project scoped token lists the instances of its project only.
+func (i *ProjectScopedInstance) List(ctx context.Context, allTenants bool) ([]*Server, error) {
+	var result []*Server
+	for _, projectID := range i.projects {
+		c, err := i.client(ctx, projectID)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := c.(InstanceLister)
+		if !ok {
+			return nil, fmt.Errorf("client of project %v can't list instances", projectID)
+		}
+		sl, err := l.List(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sl...)
+	}
+	return result, nil
+}
+
+// lookupOrder returns the projects with the hinted one first
+func (i *ProjectScopedInstance) lookupOrder(hint string) []string {
+	order := []string{}
+	for _, projectID := range i.projects {
+		if projectID == hint {
+			order = append([]string{hint}, order...)
+			continue
+		}
+		order = append(order, projectID)
+	}
+	return order
+}
+
+// client returns the client of the project, authenticating on first use.
+// Failures are not kept, so that the next lookup retries.
+func (i *ProjectScopedInstance) client(ctx context.Context, projectID string) (InstanceClient, error) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if c, ok := i.clients[projectID]; ok {
+		return c, nil
+	}
+	c, err := i.newClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	i.clients[projectID] = c
+	return c, nil
+}
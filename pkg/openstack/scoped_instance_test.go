@@ -0,0 +1,125 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/testutil"
+)
+
+// projectInstance finds instances of the project only, as Nova does for project scoped tokens
+type projectInstance struct {
+	projectID string
+	instances map[string]string
+	lookups   *[]string
+}
+
+func (p *projectInstance) Get(_ context.Context, uuid string) (*Server, error) {
+	*p.lookups = append(*p.lookups, p.projectID)
+	if p.instances[uuid] != p.projectID {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &Server{Server: servers.Server{ID: uuid, TenantID: p.projectID}}, nil
+}
+
+func TestProjectScopedInstance(t *testing.T) {
+	instances := map[string]string{"123": "bravo"}
+
+	tCase := []struct {
+		hint        string
+		uuid        string
+		wantLookups []string
+		notFound    bool
+	}{
+		// 0: the hint picks the scope
+		{hint: "bravo", uuid: "123", wantLookups: []string{"bravo"}},
+		// 1: projects are looked up in order without a hint
+		{uuid: "123", wantLookups: []string{"alpha", "bravo"}},
+		// 2: wrong hints fall back to the other projects
+		{hint: "alpha", uuid: "123", wantLookups: []string{"alpha", "bravo"}},
+		// 3: hints of unknown projects are ignored
+		{hint: "delta", uuid: "123", wantLookups: []string{"alpha", "bravo"}},
+		// 4: not found in any project
+		{hint: "bravo", uuid: "456", wantLookups: []string{"bravo", "alpha", "charlie"}, notFound: true},
+	}
+
+	for i, c := range tCase {
+		var lookups, authenticated []string
+		ic := NewProjectScopedInstance([]string{"alpha", "bravo", "charlie"}, func(_ context.Context, projectID string) (InstanceClient, error) {
+			authenticated = append(authenticated, projectID)
+			return &projectInstance{projectID: projectID, instances: instances, lookups: &lookups}, nil
+		}, testutil.TestLogger())
+
+		for n := 0; n < 2; n++ {
+			lookups = nil
+			s, err := ic.Get(WithProjectHint(context.Background(), c.hint), c.uuid)
+			if c.notFound {
+				if !IsNotFound(err) {
+					t.Errorf("#%v: not found error expected, got %v", i, err)
+				}
+			} else if err != nil || s.TenantID != "bravo" {
+				t.Errorf("#%v: unexpected result: %+v, %v", i, s, err)
+			}
+			if !reflect.DeepEqual(lookups, c.wantLookups) {
+				t.Errorf("#%v: got lookups %v, want %v", i, lookups, c.wantLookups)
+			}
+		}
+		// scoped tokens are kept for later lookups
+		if len(authenticated) != len(c.wantLookups) {
+			t.Errorf("#%v: authenticated %v", i, authenticated)
+		}
+	}
+}
+
+func TestProjectScopedInstanceAuthFailure(t *testing.T) {
+	calls := 0
+	ic := NewProjectScopedInstance([]string{"alpha"}, func(_ context.Context, projectID string) (InstanceClient, error) {
+		calls++
+		return nil, errors.New("keystone unavailable")
+	}, testutil.TestLogger())
+
+	for n := 0; n < 2; n++ {
+		if _, err := ic.Get(context.Background(), "123"); err == nil || IsNotFound(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	// failures are retried
+	if calls != 2 {
+		t.Errorf("got %v authentications, want 2", calls)
+	}
+}
+
+// projectLister lists the instances of a project
+type projectLister struct {
+	countingLister
+}
+
+func (p *projectLister) Get(_ context.Context, uuid string) (*Server, error) {
+	return nil, gophercloud.ErrDefault404{}
+}
+
+func TestProjectScopedInstanceList(t *testing.T) {
+	ic := NewProjectScopedInstance([]string{"alpha", "bravo"}, func(_ context.Context, projectID string) (InstanceClient, error) {
+		return &projectLister{countingLister{servers: []*Server{{Server: servers.Server{ID: projectID + "-1", TenantID: projectID}}}}}, nil
+	}, testutil.TestLogger())
+
+	sl, err := ic.(InstanceLister).List(context.Background(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sl) != 2 || sl[0].ID != "alpha-1" || sl[1].ID != "bravo-1" {
+		t.Errorf("unexpected listing: %v", sl)
+	}
+}
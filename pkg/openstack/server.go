@@ -0,0 +1,32 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+// FixedIPs returns the fixed IP addresses of the server
+func FixedIPs(s *Server) []string {
+	var ips []string
+	for _, v := range s.Addresses {
+		addrs, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, a := range addrs {
+			m, ok := a.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if t, ok := m["OS-EXT-IPS:type"].(string); ok && t != "fixed" {
+				continue
+			}
+			if addr, ok := m["addr"].(string); ok && addr != "" {
+				ips = append(ips, addr)
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,39 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+func TestFixedIPs(t *testing.T) {
+	s := &Server{
+		Server: servers.Server{
+			Addresses: map[string]interface{}{
+				"private": []interface{}{
+					map[string]interface{}{
+						"addr":            "10.0.0.5",
+						"OS-EXT-IPS:type": "fixed",
+					},
+					map[string]interface{}{
+						"addr":            "203.0.113.10",
+						"OS-EXT-IPS:type": "floating",
+					},
+				},
+			},
+		},
+	}
+
+	want := []string{"10.0.0.5"}
+	if got := FixedIPs(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
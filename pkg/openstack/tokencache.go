@@ -0,0 +1,248 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// minTokenValidity is the minimum remaining validity of a persisted token to be used
+const minTokenValidity = time.Minute
+
+// tokenCacheFile is the file format of the token cache
+type tokenCacheFile struct {
+	Encrypted bool `json:"encrypted"`
+	// Data is the JSON encoded tokenCacheEntry, or its nonce and ciphertext if encrypted
+	Data []byte `json:"data"`
+}
+
+// tokenCacheEntry is a Keystone token along with the authentication response including the service catalog
+type tokenCacheEntry struct {
+	// Fingerprint identifies the authentication options the token was issued for
+	Fingerprint string          `json:"fingerprint"`
+	TokenID     string          `json:"token_id"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// persistToken writes the token and service catalog of the provider to the token cache file
+func (a *AuthConfig) persistToken(authOpts *gophercloud.AuthOptions, provider *gophercloud.ProviderClient) error {
+	r, err := authResult(provider)
+	if err != nil {
+		return err
+	}
+	t, err := r.ExtractToken()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&tokenCacheEntry{
+		Fingerprint: authFingerprint(authOpts),
+		TokenID:     t.ID,
+		ExpiresAt:   t.ExpiresAt,
+		Body:        body,
+	})
+	if err != nil {
+		return err
+	}
+
+	f := &tokenCacheFile{Data: data}
+	if key, err := a.tokenCacheKey(); err != nil {
+		return err
+	} else if key != nil {
+		f.Data, err = seal(key, data)
+		if err != nil {
+			return err
+		}
+		f.Encrypted = true
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	// replace the file atomically so that a crash doesn't leave a truncated cache
+	path := common.ExpandEnv(a.TokenCacheFile)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreToken returns a ProviderClient using the persisted token and service catalog, if still valid
+func (a *AuthConfig) restoreToken(authOpts *gophercloud.AuthOptions, client *http.Client) (*gophercloud.ProviderClient, error) {
+	b, err := ioutil.ReadFile(common.ExpandEnv(a.TokenCacheFile))
+	if err != nil {
+		return nil, err
+	}
+	f := &tokenCacheFile{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("malformed token cache: %v", err)
+	}
+	data := f.Data
+	if f.Encrypted {
+		key, err := a.tokenCacheKey()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, errors.New("token cache is encrypted but token_cache_key_file is not set")
+		}
+		if data, err = unseal(key, data); err != nil {
+			return nil, err
+		}
+	}
+	e := &tokenCacheEntry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("malformed token cache: %v", err)
+	}
+	if e.Fingerprint != authFingerprint(authOpts) {
+		return nil, errors.New("token cache was issued for other authentication options")
+	}
+	if time.Until(e.ExpiresAt) < minTokenValidity {
+		return nil, fmt.Errorf("persisted token expired at %v", e.ExpiresAt)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(e.Body, &body); err != nil {
+		return nil, fmt.Errorf("malformed token cache: %v", err)
+	}
+	r := tokens.CreateResult{}
+	r.Body = body
+	r.Header = http.Header{"X-Subject-Token": []string{e.TokenID}}
+	catalog, err := r.ExtractServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := openstack.NewClient(authOpts.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		provider.HTTPClient = *client
+	}
+	if err := provider.SetTokenAndAuthResult(r); err != nil {
+		return nil, err
+	}
+	provider.EndpointLocator = func(opts gophercloud.EndpointOpts) (string, error) {
+		return openstack.V3EndpointURL(catalog, opts)
+	}
+	// re-authenticate once Keystone is back and the token expires
+	opts := *authOpts
+	provider.ReauthFunc = func() error {
+		return openstack.Authenticate(provider, opts)
+	}
+	return provider, nil
+}
+
+// tokenCacheKey returns the AES-256 key encrypting the token cache, or nil if encryption is disabled
+func (a *AuthConfig) tokenCacheKey() ([]byte, error) {
+	if a.TokenCacheKeyFile == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(common.ExpandEnv(a.TokenCacheKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token_cache_key_file: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("token_cache_key_file must contain a base64 encoded 32-byte key")
+	}
+	return key, nil
+}
+
+// authFingerprint digests the identity of authentication options, so that a cached token
+// is not used after the options change
+func authFingerprint(o *gophercloud.AuthOptions) string {
+	fields := []string{o.IdentityEndpoint, o.UserID, o.Username, o.DomainID, o.DomainName,
+		o.ApplicationCredentialID, o.ApplicationCredentialName, o.TenantID, o.TenantName}
+	if s := o.Scope; s != nil {
+		fields = append(fields, s.ProjectID, s.ProjectName, s.DomainID, s.DomainName)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func unseal(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("malformed token cache")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token cache: %v", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isKeystoneRejection returns true if Keystone responded to the authentication with a client error,
+// e.g. invalid credentials, in which case the persisted token must not be used
+func isKeystoneRejection(err error) bool {
+	switch err.(type) {
+	case gophercloud.ErrDefault400, gophercloud.ErrDefault401, gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+)
+
+func newTestAuthenticatedProvider(t *testing.T, expiresAt time.Time) *gophercloud.ProviderClient {
+	r := tokens.CreateResult{}
+	r.Body = map[string]interface{}{
+		"token": map[string]interface{}{
+			"expires_at": expiresAt.UTC().Format(time.RFC3339Nano),
+			"catalog": []interface{}{
+				map[string]interface{}{
+					"type": "compute",
+					"name": "nova",
+					"endpoints": []interface{}{
+						map[string]interface{}{
+							"id":        "1",
+							"interface": "public",
+							"region":    "RegionOne",
+							"region_id": "RegionOne",
+							"url":       "https://nova.example.com/v2.1/",
+						},
+					},
+				},
+			},
+		},
+	}
+	r.Header = http.Header{"X-Subject-Token": []string{"token-1"}}
+
+	provider := &gophercloud.ProviderClient{}
+	if err := provider.SetTokenAndAuthResult(r); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestTokenCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokencache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := ioutil.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	authOpts := &gophercloud.AuthOptions{
+		IdentityEndpoint: "https://keystone.example.com/v3/",
+		Username:         "alice",
+	}
+
+	for _, keyFile := range []string{"", keyFile} {
+		auth := &AuthConfig{
+			TokenCacheFile:    filepath.Join(dir, "token.json"),
+			TokenCacheKeyFile: keyFile,
+		}
+
+		if err := auth.persistToken(authOpts, newTestAuthenticatedProvider(t, time.Now().Add(time.Hour))); err != nil {
+			t.Fatalf("error from persistToken(): %v", err)
+		}
+		provider, err := auth.restoreToken(authOpts, nil)
+		if err != nil {
+			t.Fatalf("error from restoreToken(): %v", err)
+		}
+		if provider.Token() != "token-1" {
+			t.Errorf("unexpected token: %v", provider.Token())
+		}
+		url, err := provider.EndpointLocator(gophercloud.EndpointOpts{
+			Type:         "compute",
+			Availability: gophercloud.AvailabilityPublic,
+		})
+		if err != nil || url != "https://nova.example.com/v2.1/" {
+			t.Errorf("unexpected endpoint: %v, %v", url, err)
+		}
+
+		other := *authOpts
+		other.Username = "bob"
+		if _, err := auth.restoreToken(&other, nil); err == nil {
+			t.Error("expected error for other authentication options, got nil")
+		}
+	}
+
+	auth := &AuthConfig{TokenCacheFile: filepath.Join(dir, "token.json")}
+	if err := auth.persistToken(authOpts, newTestAuthenticatedProvider(t, time.Now().Add(30*time.Second))); err != nil {
+		t.Fatalf("error from persistToken(): %v", err)
+	}
+	if _, err := auth.restoreToken(authOpts, nil); err == nil {
+		t.Error("expected error for expiring token, got nil")
+	}
+}
+
+func TestTokenCacheEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokencache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authOpts := &gophercloud.AuthOptions{IdentityEndpoint: "https://keystone.example.com/v3/"}
+	auth := &AuthConfig{
+		TokenCacheFile:    filepath.Join(dir, "token.json"),
+		TokenCacheKeyFile: keyFile,
+	}
+	if err := auth.persistToken(authOpts, newTestAuthenticatedProvider(t, time.Now().Add(time.Hour))); err != nil {
+		t.Fatalf("error from persistToken(): %v", err)
+	}
+
+	b, err := ioutil.ReadFile(auth.TokenCacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"token-1", "nova.example.com"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("token cache contains %v in plaintext", secret)
+		}
+	}
+
+	auth.TokenCacheKeyFile = ""
+	if _, err := auth.restoreToken(authOpts, nil); err == nil {
+		t.Error("expected error without key, got nil")
+	}
+}
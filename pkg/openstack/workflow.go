@@ -0,0 +1,70 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// workflowServiceType is the type of Mistral in the service catalog
+const workflowServiceType = "workflowv2"
+
+type WorkflowClient interface {
+	// ExecuteWorkflow starts an execution of the workflow with the input, and returns the ID of the execution
+	ExecuteWorkflow(ctx context.Context, workflow string, input interface{}) (string, error)
+}
+
+// Workflow represents a OpenStack Workflow Service (Mistral) client
+type Workflow struct {
+	serviceClient *gophercloud.ServiceClient
+}
+
+// NewWorkflow returns a new OpenStack Workflow Service client with given provider
+func NewWorkflow(client *gophercloud.ProviderClient, region string) (WorkflowClient, error) {
+	url, err := client.EndpointLocator(gophercloud.EndpointOpts{
+		Type:         workflowServiceType,
+		Region:       region,
+		Availability: gophercloud.AvailabilityPublic,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Workflow{
+		serviceClient: &gophercloud.ServiceClient{
+			ProviderClient: client,
+			Endpoint:       gophercloud.NormalizeURL(url),
+		},
+	}, nil
+}
+
+func (w *Workflow) ExecuteWorkflow(ctx context.Context, workflow string, input interface{}) (string, error) {
+	// Mistral takes the input as a JSON string
+	in, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode workflow input: %v", err)
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	sc := withContext(ctx, w.serviceClient)
+	_, err = sc.Post(sc.ServiceURL("executions"), map[string]interface{}{
+		"workflow_name": workflow,
+		"input":         string(in),
+	}, &resp, &gophercloud.RequestOpts{
+		OkCodes: []int{201},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
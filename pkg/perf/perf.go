@@ -0,0 +1,63 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package perf checks the benchmarks of the hot paths of attestation against performance budgets, so that
+// regressions are caught before they slow down deployments attesting many nodes.
+package perf
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// BudgetEnv enables the budget checks if set to a factor scaling the time budgets, e.g. "1", or "3" on slower
+// machines. Timings are only meaningful on an otherwise idle machine, so the checks are skipped by default.
+const BudgetEnv = "SPIRE_OPENSTACK_PERF_BUDGET"
+
+// Budget bounds the cost of an operation. Zero fields are not checked.
+type Budget struct {
+	// NsPerOp is the maximum time per operation, scaled by the factor of BudgetEnv
+	NsPerOp int64
+	// AllocsPerOp is the maximum number of allocations per operation, which doesn't depend on the machine
+	AllocsPerOp int64
+}
+
+// Benchmark is a benchmark with its budget
+type Benchmark struct {
+	Name   string
+	F      func(b *testing.B)
+	Budget Budget
+}
+
+// Check runs the benchmarks and fails the test for each one exceeding its budget. The test is skipped unless
+// BudgetEnv is set.
+func Check(t *testing.T, benchmarks []Benchmark) {
+	v := os.Getenv(BudgetEnv)
+	if v == "" {
+		t.Skipf("performance budgets are checked if %v is set", BudgetEnv)
+	}
+	scale, err := strconv.ParseFloat(v, 64)
+	if err != nil || scale <= 0 {
+		t.Fatalf("%v must be a positive factor: %q", BudgetEnv, v)
+	}
+
+	for _, bm := range benchmarks {
+		r := testing.Benchmark(bm.F)
+		if r.N == 0 {
+			t.Errorf("%v: benchmark failed", bm.Name)
+			continue
+		}
+		t.Logf("%v: %v%v", bm.Name, r.String(), r.MemString())
+		if max := int64(float64(bm.Budget.NsPerOp) * scale); max > 0 && r.NsPerOp() > max {
+			t.Errorf("%v: %v ns/op exceeds the budget of %v ns/op", bm.Name, r.NsPerOp(), max)
+		}
+		if max := bm.Budget.AllocsPerOp; max > 0 && r.AllocsPerOp() > max {
+			t.Errorf("%v: %v allocs/op exceeds the budget of %v allocs/op", bm.Name, r.AllocsPerOp(), max)
+		}
+	}
+}
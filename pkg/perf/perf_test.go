@@ -0,0 +1,40 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package perf
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	defer os.Setenv(BudgetEnv, os.Getenv(BudgetEnv))
+	benchmarks := []Benchmark{{
+		Name: "Noop",
+		F: func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+			}
+		},
+		Budget: Budget{NsPerOp: 1000, AllocsPerOp: 1},
+	}}
+
+	os.Unsetenv(BudgetEnv)
+	checked := false
+	t.Run("disabled", func(t *testing.T) {
+		Check(t, benchmarks)
+		checked = true
+	})
+	if checked {
+		t.Errorf("expected the check to be skipped unless %v is set", BudgetEnv)
+	}
+
+	os.Setenv(BudgetEnv, "1")
+	t.Run("enabled", func(t *testing.T) {
+		Check(t, benchmarks)
+	})
+}
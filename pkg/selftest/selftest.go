@@ -0,0 +1,77 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package selftest reports the steps of the self-test modes of the plugins, which validate installations
+// before they are wired into SPIRE.
+package selftest
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	resultPass = "PASS"
+	resultFail = "FAIL"
+	resultSkip = "SKIP"
+	resultWarn = "WARN"
+)
+
+// Report prints the result of each step as a line, e.g. "PASS  nova lookup: found in project abc"
+type Report struct {
+	w      io.Writer
+	failed int
+}
+
+// NewReport returns a Report printing to w
+func NewReport(w io.Writer) *Report {
+	return &Report{w: w}
+}
+
+// Pass reports a step which succeeded, with details
+func (r *Report) Pass(step, format string, args ...interface{}) {
+	r.print(resultPass, step, fmt.Sprintf(format, args...))
+}
+
+// Fail reports a step which failed with err
+func (r *Report) Fail(step string, err error) {
+	r.failed++
+	r.print(resultFail, step, err.Error())
+}
+
+// Warn reports a step which failed with err without failing the self-test, e.g. a fallback which wasn't needed
+func (r *Report) Warn(step string, err error) {
+	r.print(resultWarn, step, err.Error())
+}
+
+// Skip reports a step which wasn't run, with the reason
+func (r *Report) Skip(step, reason string) {
+	r.print(resultSkip, step, reason)
+}
+
+// Failed returns true if any step failed
+func (r *Report) Failed() bool {
+	return r.failed > 0
+}
+
+// Summary prints the number of failed steps, and returns the exit status of the self-test
+func (r *Report) Summary() int {
+	if r.failed > 0 {
+		fmt.Fprintf(r.w, "\n%d step(s) failed\n", r.failed)
+		return 1
+	}
+	fmt.Fprintln(r.w, "\nall steps passed")
+	return 0
+}
+
+func (r *Report) print(result, step, detail string) {
+	if detail == "" {
+		fmt.Fprintf(r.w, "%v  %v\n", result, step)
+		return
+	}
+	fmt.Fprintf(r.w, "%v  %v: %v\n", result, step, detail)
+}
@@ -0,0 +1,55 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package selftest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	var b bytes.Buffer
+	r := NewReport(&b)
+	r.Pass("metadata", "instance %v", "alpha")
+	r.Skip("signed identity", "signed_identity_target is not set")
+	r.Warn("metadata from config_drive", errors.New("no config drive"))
+	if r.Failed() {
+		t.Error("report failed without failures")
+	}
+	r.Fail("attestation data", errors.New("too large"))
+	if !r.Failed() {
+		t.Error("report didn't fail")
+	}
+	if status := r.Summary(); status != 1 {
+		t.Errorf("got exit status %v, want 1", status)
+	}
+
+	want := `PASS  metadata: instance alpha
+SKIP  signed identity: signed_identity_target is not set
+WARN  metadata from config_drive: no config drive
+FAIL  attestation data: too large
+
+1 step(s) failed
+`
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestReportPassed(t *testing.T) {
+	var b bytes.Buffer
+	r := NewReport(&b)
+	r.Pass("metadata", "")
+	if status := r.Summary(); status != 0 {
+		t.Errorf("got exit status %v, want 0", status)
+	}
+	if want := "PASS  metadata\n\nall steps passed\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,82 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package telemetry exposes metrics of the plugins in the Prometheus format.
+// SPIRE doesn't pass its metrics sink to external plugins, so they are served on a separate listener.
+package telemetry
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prefix of all metric names
+const Namespace = "spire_openstack"
+
+// Registry holds the metrics of the plugins
+var Registry = prometheus.NewRegistry()
+
+// Server serves the metrics of Registry
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+
+	mu  sync.RWMutex
+	mux *http.ServeMux
+}
+
+// Serve starts serving the metrics at http://<address>/metrics, along with given handlers keyed by path
+func Serve(address string, handlers map[string]http.Handler, logger hclog.Logger) (*Server, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: l}
+	s.SetHandlers(handlers)
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server failed", "error", err)
+		}
+	}()
+	return s, nil
+}
+
+// SetHandlers replaces the handlers served along with the metrics, e.g. on reconfiguration
+func (s *Server) SetHandlers(handlers map[string]http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	for path, h := range handlers {
+		mux.Handle(path, h)
+	}
+	s.mu.Lock()
+	s.mux = mux
+	s.mu.Unlock()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	mux := s.mux
+	s.mu.RUnlock()
+	mux.ServeHTTP(w, r)
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.server.Close()
+}
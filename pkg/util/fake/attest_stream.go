@@ -23,6 +23,11 @@ type AttestPluginStream struct {
 	req  *nodeattestor.AttestRequest
 	resp *nodeattestor.AttestResponse
 	grpc.ServerStream
+
+	// Respond answers the challenges sent by the plugin. Challenges are left unanswered if it is nil or returns nil.
+	Respond func(challenge []byte) []byte
+	// Challenges are the challenges sent by the plugin
+	Challenges [][]byte
 }
 
 func NewAttestStream(uuid string) *AttestPluginStream {
@@ -36,6 +41,18 @@ func NewAttestStream(uuid string) *AttestPluginStream {
 	}
 }
 
+// NewAttestStreamWithData returns a stream which sends given attestation data
+func NewAttestStreamWithData(data []byte) *AttestPluginStream {
+	return &AttestPluginStream{
+		req: &nodeattestor.AttestRequest{
+			AttestationData: &spc.AttestationData{
+				Type: common.PluginName,
+				Data: data,
+			},
+		},
+	}
+}
+
 func (f *AttestPluginStream) Context() context.Context {
 	return ctx
 }
@@ -49,7 +66,22 @@ func (f *AttestPluginStream) Recv() (*nodeattestor.AttestRequest, error) {
 	return req, nil
 }
 
+// Response returns the response sent by the plugin
+func (f *AttestPluginStream) Response() *nodeattestor.AttestResponse {
+	return f.resp
+}
+
 func (f *AttestPluginStream) Send(resp *nodeattestor.AttestResponse) error {
+	if len(resp.Challenge) > 0 {
+		f.Challenges = append(f.Challenges, resp.Challenge)
+		if f.Respond == nil {
+			return nil
+		}
+		if r := f.Respond(resp.Challenge); r != nil {
+			f.req = &nodeattestor.AttestRequest{Response: r}
+		}
+		return nil
+	}
 	if f.resp != nil {
 		return io.EOF
 	}
@@ -0,0 +1,47 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+type BootSecurity struct {
+	// instance ID -> boot security attributes
+	Attributes map[string]*openstack.BootSecurity
+	err        error
+	// Calls is the number of calls of GetBootSecurity
+	Calls int
+}
+
+// NewBootSecurity returns fake BootSecurityClient which returns given attributes, or none for other instances
+func NewBootSecurity(attributes map[string]*openstack.BootSecurity) *BootSecurity {
+	return &BootSecurity{
+		Attributes: attributes,
+	}
+}
+
+// NewErrorBootSecurity returns fake BootSecurityClient which returns given error
+func NewErrorBootSecurity(err error) *BootSecurity {
+	return &BootSecurity{
+		err: err,
+	}
+}
+
+func (f *BootSecurity) GetBootSecurity(_ context.Context, s *openstack.Server) (*openstack.BootSecurity, error) {
+	f.Calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if bs, ok := f.Attributes[s.ID]; ok {
+		return bs, nil
+	}
+	return &openstack.BootSecurity{}, nil
+}
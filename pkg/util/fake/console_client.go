@@ -0,0 +1,58 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type Console struct {
+	mtx   sync.Mutex
+	lines map[string][]string
+	err   error
+	// Calls is the number of calls of GetConsoleOutput
+	Calls int
+}
+
+// NewConsole returns fake ConsoleClient which returns the lines written to the console of each instance
+func NewConsole() *Console {
+	return &Console{
+		lines: make(map[string][]string),
+	}
+}
+
+// NewErrorConsole returns fake ConsoleClient which returns given error
+func NewErrorConsole(err error) *Console {
+	return &Console{
+		lines: make(map[string][]string),
+		err:   err,
+	}
+}
+
+// Write appends a line to the console log of the instance
+func (f *Console) Write(uuid, line string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.lines[uuid] = append(f.lines[uuid], line)
+}
+
+func (f *Console) GetConsoleOutput(_ context.Context, uuid string, lines int) (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.Calls++
+	if f.err != nil {
+		return "", f.err
+	}
+	l := f.lines[uuid]
+	if len(l) > lines {
+		l = l[len(l)-lines:]
+	}
+	return strings.Join(l, "\n"), nil
+}
@@ -0,0 +1,35 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+type DNS struct {
+	// name -> addresses
+	records map[string][]string
+}
+
+// NewDNS returns fake DNSClient which returns given records
+func NewDNS(records map[string][]string) openstack.DNSClient {
+	return &DNS{
+		records: records,
+	}
+}
+
+func (f *DNS) LookupAddresses(_ context.Context, zoneName, name string) ([]string, error) {
+	addrs, ok := f.records[name]
+	if !ok {
+		return nil, fmt.Errorf("record not found: %v", name)
+	}
+	return addrs, nil
+}
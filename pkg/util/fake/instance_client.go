@@ -8,19 +8,34 @@
 package fake
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 
 	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
 )
 
+// UserID is the ID of the user who created the instances returned by the fakes
+const UserID = "alice"
+
 type Instance struct {
-	projectID string
-	metaData  map[string]string
-	secGroup  []map[string]interface{}
-	created   time.Time
+	projectID  string
+	metaData   map[string]string
+	secGroup   []map[string]interface{}
+	created    time.Time
+	region     string
+	zone       string
+	addresses  map[string]interface{}
+	image      string
+	flavor     string
+	hostID     string
+	status     string
+	vmState    string
+	powerState extendedstatus.PowerState
 }
 
 // NewInstance returns fake InstanceClient which returns data including given projectID
@@ -40,17 +55,93 @@ func NewInstanceWithTime(projectID string, created time.Time) openstack.Instance
 	}
 }
 
-func (f *Instance) Get(uuid string) (*servers.Server, error) {
-	return &servers.Server{
-		ID:             uuid,
-		Name:           "bravo",
-		TenantID:       f.projectID,
-		Addresses:      map[string]interface{}{},
-		Metadata:       f.metaData,
-		SecurityGroups: f.secGroup,
-		Created:        f.created,
-		Updated:        f.created,
-	}, nil
+// NewInstanceWithZone returns fake InstanceClient which returns data located in given region and availability zone
+func NewInstanceWithZone(projectID, region, zone string) openstack.InstanceClient {
+	return &Instance{
+		projectID: projectID,
+		created:   time.Now(),
+		region:    region,
+		zone:      zone,
+	}
+}
+
+// NewInstanceWithAddresses returns fake InstanceClient which returns data including given addresses
+func NewInstanceWithAddresses(projectID string, addresses map[string]interface{}) openstack.InstanceClient {
+	return &Instance{
+		projectID: projectID,
+		created:   time.Now(),
+		addresses: addresses,
+	}
+}
+
+// NewInstanceWithPowerState returns fake InstanceClient which returns data of an instance in given status, vm_state
+// and power_state. Instances of the other fakes are ACTIVE, and don't report vm_state and power_state like Nova
+// hiding the extended status.
+func NewInstanceWithPowerState(projectID, status, vmState string, powerState extendedstatus.PowerState) openstack.InstanceClient {
+	return &Instance{
+		projectID:  projectID,
+		created:    time.Now(),
+		status:     status,
+		vmState:    vmState,
+		powerState: powerState,
+	}
+}
+
+// NewInstanceWithPlacement returns fake InstanceClient which returns data with given image, flavor and host ID
+func NewInstanceWithPlacement(projectID, image, flavor, hostID string) openstack.InstanceClient {
+	return &Instance{
+		projectID: projectID,
+		created:   time.Now(),
+		image:     image,
+		flavor:    flavor,
+		hostID:    hostID,
+	}
+}
+
+func (f *Instance) Get(_ context.Context, uuid string) (*openstack.Server, error) {
+	status := f.status
+	if status == "" {
+		status = "ACTIVE"
+	}
+	s := &openstack.Server{
+		Server: servers.Server{
+			ID:             uuid,
+			Name:           "bravo",
+			TenantID:       f.projectID,
+			UserID:         UserID,
+			HostID:         f.hostID,
+			Status:         status,
+			Addresses:      f.getAddresses(),
+			Metadata:       f.metaData,
+			SecurityGroups: f.secGroup,
+			Created:        f.created,
+			Updated:        f.created,
+		},
+		ServerAvailabilityZoneExt: availabilityzones.ServerAvailabilityZoneExt{
+			AvailabilityZone: f.zone,
+		},
+		ServerExtendedStatusExt: extendedstatus.ServerExtendedStatusExt{
+			VmState:    f.vmState,
+			PowerState: f.powerState,
+		},
+		Region: f.region,
+		// Instances are launched as they are created
+		LaunchedAt: f.created,
+	}
+	if f.image != "" {
+		s.Image = map[string]interface{}{"id": f.image}
+	}
+	if f.flavor != "" {
+		s.Flavor = map[string]interface{}{"id": f.flavor}
+	}
+	return s, nil
+}
+
+func (f *Instance) getAddresses() map[string]interface{} {
+	if f.addresses == nil {
+		return map[string]interface{}{}
+	}
+	return f.addresses
 }
 
 type ErrorInstance struct {
@@ -64,6 +155,6 @@ func NewErrorInstance(msg string) openstack.InstanceClient {
 	}
 }
 
-func (f *ErrorInstance) Get(uuid string) (*servers.Server, error) {
+func (f *ErrorInstance) Get(_ context.Context, uuid string) (*openstack.Server, error) {
 	return nil, errors.New(f.message)
 }
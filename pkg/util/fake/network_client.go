@@ -0,0 +1,55 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+)
+
+type Network struct {
+	ports []ports.Port
+	delay time.Duration
+}
+
+// NewNetwork returns fake NetworkClient which returns given ports
+func NewNetwork(p []ports.Port) openstack.NetworkClient {
+	return &Network{
+		ports: p,
+	}
+}
+
+// NewSlowNetwork returns fake NetworkClient which returns given ports after delay, or the error of the context if it
+// is done earlier
+func NewSlowNetwork(p []ports.Port, delay time.Duration) openstack.NetworkClient {
+	return &Network{
+		ports: p,
+		delay: delay,
+	}
+}
+
+func (f *Network) ListPorts(ctx context.Context, deviceID string) ([]ports.Port, error) {
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var pl []ports.Port
+	for _, p := range f.ports {
+		if p.DeviceID == "" || p.DeviceID == deviceID {
+			pl = append(pl, p)
+		}
+	}
+	return pl, nil
+}
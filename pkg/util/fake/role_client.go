@@ -0,0 +1,35 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+)
+
+type Role struct {
+	// "<project ID>" or "<project ID>/<user ID>" -> role names
+	Assignments map[string][]string
+	// Calls is the number of calls of ListRoles
+	Calls int
+}
+
+// NewRole returns fake RoleClient which returns given assignments
+func NewRole(assignments map[string][]string) *Role {
+	return &Role{
+		Assignments: assignments,
+	}
+}
+
+func (f *Role) ListRoles(_ context.Context, projectID, userID string) ([]string, error) {
+	f.Calls++
+	key := projectID
+	if userID != "" {
+		key += "/" + userID
+	}
+	return f.Assignments[key], nil
+}
@@ -0,0 +1,40 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fake
+
+import (
+	"context"
+	"sync"
+)
+
+type SecretStore struct {
+	mtx sync.Mutex
+	// name -> payload
+	Secrets map[string][]byte
+}
+
+// NewSecretStore returns fake SecretStoreClient which keeps secrets in memory
+func NewSecretStore() *SecretStore {
+	return &SecretStore{
+		Secrets: make(map[string][]byte),
+	}
+}
+
+func (f *SecretStore) GetSecretByName(_ context.Context, name string) ([]byte, bool, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	payload, ok := f.Secrets[name]
+	return payload, ok, nil
+}
+
+func (f *SecretStore) StoreSecret(_ context.Context, name string, payload []byte) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.Secrets[name] = payload
+	return nil
+}
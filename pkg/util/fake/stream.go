@@ -24,6 +24,9 @@ type FakeFetchAttestationDataStream struct {
 	req  *nodeattestor.FetchAttestationDataRequest
 	resp *nodeattestor.FetchAttestationDataResponse
 	grpc.ServerStream
+
+	challenges [][]byte
+	responses  [][]byte
 }
 
 func NewFakeFetchAttestationStream() *FakeFetchAttestationDataStream {
@@ -32,6 +35,14 @@ func NewFakeFetchAttestationStream() *FakeFetchAttestationDataStream {
 	}
 }
 
+// NewFakeFetchAttestationStreamWithChallenges returns a stream which sends given challenges after the attestation data
+func NewFakeFetchAttestationStreamWithChallenges(challenges ...[]byte) *FakeFetchAttestationDataStream {
+	return &FakeFetchAttestationDataStream{
+		req:        new(nodeattestor.FetchAttestationDataRequest),
+		challenges: challenges,
+	}
+}
+
 func (f *FakeFetchAttestationDataStream) Context() context.Context {
 	return ctx
 }
@@ -39,6 +50,10 @@ func (f *FakeFetchAttestationDataStream) Context() context.Context {
 func (f *FakeFetchAttestationDataStream) Recv() (*nodeattestor.FetchAttestationDataRequest, error) {
 	req := f.req
 	f.req = nil
+	if req == nil && f.resp != nil && len(f.challenges) > 0 {
+		req = &nodeattestor.FetchAttestationDataRequest{Challenge: f.challenges[0]}
+		f.challenges = f.challenges[1:]
+	}
 	if req == nil {
 		return nil, io.EOF
 	}
@@ -46,9 +61,22 @@ func (f *FakeFetchAttestationDataStream) Recv() (*nodeattestor.FetchAttestationD
 }
 
 func (f *FakeFetchAttestationDataStream) Send(resp *nodeattestor.FetchAttestationDataResponse) error {
+	if len(resp.Response) > 0 {
+		f.responses = append(f.responses, resp.Response)
+		return nil
+	}
 	if f.resp != nil {
 		return io.EOF
 	}
 	f.resp = resp
 	return nil
 }
+
+func (f *FakeFetchAttestationDataStream) Response() *nodeattestor.FetchAttestationDataResponse {
+	return f.resp
+}
+
+// ChallengeResponses returns the responses to the challenges sent by the plugin
+func (f *FakeFetchAttestationDataStream) ChallengeResponses() [][]byte {
+	return f.responses
+}
@@ -0,0 +1,59 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package vendordata
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// TrustBundle is the SPIRE trust bundle served to instances, which agents bootstrap with instead of a bundle baked
+// into the image
+type TrustBundle struct {
+	// PEM encoded CA certificates of the trust domain
+	Bundle string `json:"bundle"`
+}
+
+// NewTrustBundle returns the TrustBundle of given CA certificates
+func NewTrustBundle(certs []*x509.Certificate) *TrustBundle {
+	var b []byte
+	for _, c := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return &TrustBundle{Bundle: string(b)}
+}
+
+// Certificates returns the CA certificates of the bundle, in the order of the bundle
+func (b *TrustBundle) Certificates() ([]*x509.Certificate, error) {
+	return parseCertificates([]byte(b.Bundle), "trust bundle")
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the certificate in lowercase hex, which provisioning
+// pipelines place in the instance metadata to name the CA the agent is to bootstrap with
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint returns the SHA-256 fingerprint in the form of CertificateFingerprint. The output of
+// "openssl x509 -fingerprint -sha256", i.e. uppercase hex separated by colons after "SHA256 Fingerprint=", is accepted.
+func NormalizeFingerprint(s string) (string, error) {
+	f := strings.TrimSpace(s)
+	if i := strings.LastIndex(f, "="); i >= 0 {
+		f = f[i+1:]
+	}
+	f = strings.ToLower(strings.Replace(f, ":", "", -1))
+	if b, err := hex.DecodeString(f); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint: %q", s)
+	}
+	return f, nil
+}
@@ -0,0 +1,88 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package vendordata
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTrustBundle(t *testing.T) {
+	key := newTestKey(t)
+	roots := []*x509.Certificate{
+		newTestCertificate(t, "alpha", key, nil, nil, true),
+		newTestCertificate(t, "bravo", newTestKey(t), nil, nil, true),
+	}
+
+	b, err := json.Marshal(NewTrustBundle(roots))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := &TrustBundle{}
+	if err := json.Unmarshal(b, parsed); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := parsed.Certificates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(roots[0]) || !certs[1].Equal(roots[1]) {
+		t.Errorf("certificates do not match: %v", certs)
+	}
+
+	if _, err := (&TrustBundle{}).Certificates(); err == nil {
+		t.Error("expected error for an empty bundle")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	cert := newTestCertificate(t, "alpha", newTestKey(t), nil, nil, true)
+	sum := sha256.Sum256(cert.Raw)
+	want := hex.EncodeToString(sum[:])
+	if got := CertificateFingerprint(cert); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	var colons []string
+	for i := 0; i < len(want); i += 2 {
+		colons = append(colons, strings.ToUpper(want[i:i+2]))
+	}
+	tCase := []struct {
+		in      string
+		wantErr bool
+	}{
+		// 0: normalized form
+		{in: want},
+		// 1: uppercase
+		{in: strings.ToUpper(want)},
+		// 2: output of openssl
+		{in: "SHA256 Fingerprint=" + strings.Join(colons, ":") + "\n"},
+		// 3: SHA-1 fingerprint
+		{in: want[:40], wantErr: true},
+		// 4: not hex
+		{in: strings.Repeat("z", 64), wantErr: true},
+		// 5: empty
+		{in: "", wantErr: true},
+	}
+	for i, c := range tCase {
+		got, err := NormalizeFingerprint(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("#%v: an error expected", i)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("#%v: got %v, %v, want %v", i, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package vendordata signs and verifies instance identities served through Nova dynamic vendordata.
+// Identities are signed by the signing service with one active key and verified by servers against
+// a set of trusted keys selected by the key ID carried in the signed identity, so that signing keys
+// can be rotated by trusting the new key before signing with it. Alternatively, signing keys are certified
+// by a CA, whose certificate is trusted instead of each key, and identities carry the certificate chain.
+package vendordata
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Signature algorithms, named as in JWA (RFC 7518, RFC 8037)
+const (
+	// AlgorithmEdDSA is the algorithm of Ed25519 signatures
+	AlgorithmEdDSA = "EdDSA"
+	// AlgorithmES256 is the algorithm of ECDSA P-256 signatures with SHA-256, encoded as R || S
+	AlgorithmES256 = "ES256"
+	// AlgorithmPS256 is the algorithm of RSA-PSS signatures with SHA-256 and MGF1, salted with the length of the hash
+	AlgorithmPS256 = "PS256"
+)
+
+// minRSAKeySize is the minimum size of RSA keys in bits
+const minRSAKeySize = 2048
+
+// Algorithms returns the supported signature algorithms
+func Algorithms() []string {
+	return []string{AlgorithmEdDSA, AlgorithmES256, AlgorithmPS256}
+}
+
+// ErrUnknownKey is returned for identities signed with keys not trusted by the verifier, possibly wrapped with the
+// reason the certificate chain of the key is not trusted
+var ErrUnknownKey = errors.New("identity is signed with an untrusted key")
+
+// Identity is the identity of an instance asserted by the signing service
+type Identity struct {
+	InstanceID string `json:"instance_id"`
+	ProjectID  string `json:"project_id"`
+	ImageID    string `json:"image_id,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+	IssuedAt   int64  `json:"iat"`
+}
+
+// SignedIdentity is the signed form of an Identity, as served to the instance and sent by the agent
+type SignedIdentity struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// Document is the base64url encoded JSON of the identity, signed as is
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+	// CertificateChain is the base64 encoded DER certificate of the signing key followed by the intermediate
+	// certificates, if the key is certified by a CA
+	CertificateChain []string `json:"x5c,omitempty"`
+}
+
+// Validate returns an error if any field of the signed identity is missing
+func (s *SignedIdentity) Validate() error {
+	switch {
+	case s.KeyID == "":
+		return errors.New("signed identity lacks kid")
+	case s.Algorithm == "":
+		return errors.New("signed identity lacks alg")
+	case s.Document == "":
+		return errors.New("signed identity lacks document")
+	case s.Signature == "":
+		return errors.New("signed identity lacks signature")
+	}
+	return nil
+}
+
+// Signer signs identities with a single key
+type Signer struct {
+	keyID     string
+	algorithm string
+	key       crypto.Signer
+	chain     []string
+}
+
+// NewSigner returns a Signer signing with given key, identified by keyID
+func NewSigner(keyID string, key crypto.Signer) (*Signer, error) {
+	if keyID == "" {
+		return nil, errors.New("key ID is empty")
+	}
+	alg, err := algorithmOf(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		keyID:     keyID,
+		algorithm: alg,
+		key:       key,
+	}, nil
+}
+
+// NewSignerWithChain returns a Signer signing with given key, identified by keyID, whose signed identities carry the
+// certificate chain of the key, the certificate of the key first
+func NewSignerWithChain(keyID string, key crypto.Signer, chain []*x509.Certificate) (*Signer, error) {
+	s, err := NewSigner(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	certified, err := x509.MarshalPKIXPublicKey(chain[0].PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if string(certified) != string(public) {
+		return nil, errors.New("certificate is not of the signing key")
+	}
+	for _, cert := range chain {
+		s.chain = append(s.chain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	return s, nil
+}
+
+// KeyID returns the ID of the signing key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Algorithm returns the signature algorithm of the signing key
+func (s *Signer) Algorithm() string {
+	return s.algorithm
+}
+
+// Public returns the public key of the signing key
+func (s *Signer) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign signs the identity. IssuedAt is set to the current time if zero.
+func (s *Signer) Sign(id *Identity) (*SignedIdentity, error) {
+	if id.IssuedAt == 0 {
+		id.IssuedAt = time.Now().Unix()
+	}
+	b, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	doc := base64.RawURLEncoding.EncodeToString(b)
+
+	sig, err := sign(s.algorithm, s.key, []byte(doc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign identity: %v", err)
+	}
+	return &SignedIdentity{
+		KeyID:            s.keyID,
+		Algorithm:        s.algorithm,
+		Document:         doc,
+		Signature:        base64.RawURLEncoding.EncodeToString(sig),
+		CertificateChain: s.chain,
+	}, nil
+}
+
+// Verifier verifies signed identities against a set of trusted keys, and keys certified by trusted CAs
+type Verifier struct {
+	keys       map[string]crypto.PublicKey
+	roots      *x509.CertPool
+	algorithms map[string]bool
+}
+
+// NewVerifier returns a Verifier trusting given public keys by key ID, accepting all supported algorithms
+func NewVerifier(keys map[string]crypto.PublicKey) (*Verifier, error) {
+	return NewVerifierWithAlgorithms(keys, Algorithms())
+}
+
+// NewVerifierWithAlgorithms returns a Verifier trusting given public keys by key ID, accepting signatures of
+// given algorithms only. Keys of other algorithms are rejected, since they could never verify an identity.
+func NewVerifierWithAlgorithms(keys map[string]crypto.PublicKey, algorithms []string) (*Verifier, error) {
+	return NewVerifierWithRoots(keys, nil, algorithms)
+}
+
+// NewVerifierWithRoots returns a Verifier trusting given public keys by key ID, and the keys certified by the CAs of
+// roots if it is not nil, accepting signatures of given algorithms only. Keys trusted by key ID take precedence over
+// the certificate chains of identities.
+func NewVerifierWithRoots(keys map[string]crypto.PublicKey, roots *x509.CertPool, algorithms []string) (*Verifier, error) {
+	if len(keys) == 0 && roots == nil {
+		return nil, errors.New("no trusted keys")
+	}
+	if len(algorithms) == 0 {
+		return nil, errors.New("no accepted algorithms")
+	}
+	accepted := make(map[string]bool)
+	for _, alg := range algorithms {
+		if !isSupported(alg) {
+			return nil, fmt.Errorf("unsupported algorithm: %q", alg)
+		}
+		accepted[alg] = true
+	}
+	for kid, key := range keys {
+		alg, err := algorithmOf(key)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %v: %v", kid, err)
+		}
+		if !accepted[alg] {
+			return nil, fmt.Errorf("trusted key %v is of algorithm %v, which is not accepted", kid, alg)
+		}
+	}
+	return &Verifier{keys: keys, roots: roots, algorithms: accepted}, nil
+}
+
+// KeyIDs returns the sorted IDs of the trusted keys
+func (v *Verifier) KeyIDs() []string {
+	var ids []string
+	for kid := range v.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Verify verifies the signature of the identity and returns the identity.
+// ErrUnknownKey is returned if the identity is signed with a key not trusted.
+func (v *Verifier) Verify(s *SignedIdentity) (*Identity, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	key, ok := v.keys[s.KeyID]
+	if !ok {
+		if v.roots == nil || len(s.CertificateChain) == 0 {
+			return nil, ErrUnknownKey
+		}
+		var err error
+		if key, err = v.verifyChain(s.CertificateChain); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownKey, err)
+		}
+	}
+	alg, err := algorithmOf(key)
+	if err != nil {
+		return nil, err
+	}
+	// The identity names its algorithm, which must be the one of the trusted key, so that a signature of one
+	// scheme can't be passed off as one of another
+	if s.Algorithm != alg {
+		return nil, fmt.Errorf("algorithm %q does not match key %v", s.Algorithm, s.KeyID)
+	}
+	if !v.algorithms[alg] {
+		return nil, fmt.Errorf("algorithm %q is not accepted", s.Algorithm)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+	if !verify(alg, key, []byte(s.Document), sig) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s.Document)
+	if err != nil {
+		return nil, fmt.Errorf("malformed document: %v", err)
+	}
+	id := &Identity{}
+	if err := json.Unmarshal(b, id); err != nil {
+		return nil, fmt.Errorf("malformed document: %v", err)
+	}
+	return id, nil
+}
+
+// verifyChain verifies the certificate chain against the trusted CAs and returns the key of the first certificate
+func (v *Verifier) verifyChain(chain []string) (crypto.PublicKey, error) {
+	var certs []*x509.Certificate
+	for i, c := range chain {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate %d of x5c: %v", i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate %d of x5c: %v", i, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("certificate %q is not trusted: %v", leaf.Subject, err)
+	}
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, fmt.Errorf("certificate %q is not for digital signatures", leaf.Subject)
+	}
+	return leaf.PublicKey, nil
+}
+
+// algorithmOf returns the signature algorithm used with given public key
+func algorithmOf(key crypto.PublicKey) (string, error) {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return AlgorithmEdDSA, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve: %v", key.Curve.Params().Name)
+		}
+		return AlgorithmES256, nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeySize {
+			return "", fmt.Errorf("RSA key of %d bits is shorter than %d bits", key.N.BitLen(), minRSAKeySize)
+		}
+		return AlgorithmPS256, nil
+	}
+	return "", fmt.Errorf("unsupported key type: %T", key)
+}
+
+func isSupported(alg string) bool {
+	for _, a := range Algorithms() {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// pssOptions are the RSA-PSS parameters of PS256
+var pssOptions = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+// sign signs msg with the algorithm, which must be the one of the key
+func sign(alg string, key crypto.Signer, msg []byte) ([]byte, error) {
+	switch alg {
+	case AlgorithmEdDSA:
+		return key.Sign(rand.Reader, msg, crypto.Hash(0))
+	case AlgorithmES256:
+		digest := sha256.Sum256(msg)
+		der, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		// crypto.Signer returns ASN.1 DER, while ES256 signatures are the fixed-size concatenation of R and S
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &rs); err != nil {
+			return nil, fmt.Errorf("malformed ECDSA signature: %v", err)
+		}
+		sig := make([]byte, 64)
+		r, s := rs.R.Bytes(), rs.S.Bytes()
+		copy(sig[32-len(r):32], r)
+		copy(sig[64-len(s):], s)
+		return sig, nil
+	case AlgorithmPS256:
+		digest := sha256.Sum256(msg)
+		return key.Sign(rand.Reader, digest[:], pssOptions)
+	}
+	return nil, fmt.Errorf("unsupported algorithm: %q", alg)
+}
+
+// verify returns true if sig is a valid signature of msg by the key with the algorithm
+func verify(alg string, key crypto.PublicKey, msg, sig []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return alg == AlgorithmEdDSA && ed25519.Verify(key, msg, sig)
+	case *ecdsa.PublicKey:
+		if alg != AlgorithmES256 || len(sig) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(msg)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	case *rsa.PublicKey:
+		if alg != AlgorithmPS256 {
+			return false
+		}
+		digest := sha256.Sum256(msg)
+		return rsa.VerifyPSS(key, crypto.SHA256, digest[:], sig, pssOptions) == nil
+	}
+	return false
+}
+
+// LoadPrivateKey loads a PKCS #8 private key from a PEM file
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key in %v: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key in %v: %T", path, key)
+	}
+	return signer, nil
+}
+
+// LoadCertificates loads the certificates of a PEM file, in the order of the file
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificates(b, path)
+}
+
+// parseCertificates parses the certificates of PEM data, in the order of the data. name names the data in errors.
+func parseCertificates(b []byte, name string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %v: %v", name, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no CERTIFICATE PEM block in %v", name)
+	}
+	return certs, nil
+}
+
+// LoadPublicKey loads a PKIX public key from a PEM file
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key in %v: %v", path, err)
+	}
+	return key, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("no %v PEM block in %v", blockType, path)
+	}
+	return block.Bytes, nil
+}
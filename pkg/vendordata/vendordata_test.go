@@ -0,0 +1,421 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package vendordata
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	oldKey, newKey, otherKey := newTestKey(t), newTestKey(t), newTestKey(t)
+
+	v, err := NewVerifier(map[string]crypto.PublicKey{
+		"old": oldKey.Public(),
+		"new": newKey.Public(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		kid string
+		key ed25519.PrivateKey
+		err bool
+	}{
+		// 0: signed with the old key, still trusted during rotation
+		{kid: "old", key: oldKey},
+		// 1: signed with the new key
+		{kid: "new", key: newKey},
+		// 2: signed with an untrusted key
+		{kid: "other", key: otherKey, err: true},
+		// 3: signed with another key under a trusted key ID
+		{kid: "new", key: otherKey, err: true},
+	} {
+		s, err := NewSigner(c.kid, c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := s.Sign(&Identity{InstanceID: "alpha", ProjectID: "bravo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signed.KeyID != c.kid || signed.Algorithm != AlgorithmEdDSA {
+			t.Errorf("#%v: unexpected signed identity: %+v", i, signed)
+		}
+
+		id, err := v.Verify(signed)
+		if c.err {
+			if err == nil {
+				t.Errorf("#%v: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		if id.InstanceID != "alpha" || id.ProjectID != "bravo" || id.IssuedAt == 0 {
+			t.Errorf("#%v: unexpected identity: %+v", i, id)
+		}
+	}
+
+	if got := v.KeyIDs(); len(got) != 2 || got[0] != "new" || got[1] != "old" {
+		t.Errorf("unexpected key IDs: %v", got)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	edKey, ecKey, rsaKey := newTestKey(t), newECKey(t, elliptic.P256()), newRSAKey(t, 2048)
+	keys := map[string]crypto.PublicKey{
+		"ed":  edKey.Public(),
+		"ec":  ecKey.Public(),
+		"rsa": rsaKey.Public(),
+	}
+	v, err := NewVerifier(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		kid string
+		key crypto.Signer
+		alg string
+	}{
+		// 0: Ed25519
+		{kid: "ed", key: edKey, alg: AlgorithmEdDSA},
+		// 1: ECDSA P-256
+		{kid: "ec", key: ecKey, alg: AlgorithmES256},
+		// 2: RSA-PSS
+		{kid: "rsa", key: rsaKey, alg: AlgorithmPS256},
+	} {
+		s, err := NewSigner(c.kid, c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Algorithm() != c.alg {
+			t.Errorf("#%v: expected algorithm %v, got %v", i, c.alg, s.Algorithm())
+		}
+		signed, err := s.Sign(&Identity{InstanceID: "alpha"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signed.Algorithm != c.alg {
+			t.Errorf("#%v: unexpected algorithm of signed identity: %v", i, signed.Algorithm)
+		}
+		if id, err := v.Verify(signed); err != nil || id.InstanceID != "alpha" {
+			t.Errorf("#%v: unexpected result: %+v, %v", i, id, err)
+		}
+
+		// The signature must not verify under the key of another algorithm, even if the algorithm is forged
+		for kid, key := range keys {
+			if kid == c.kid {
+				continue
+			}
+			alg, _ := algorithmOf(key)
+			forged := *signed
+			forged.KeyID, forged.Algorithm = kid, alg
+			if _, err := v.Verify(&forged); err == nil {
+				t.Errorf("#%v: expected error verifying with %v", i, kid)
+			}
+		}
+
+		// Accepting other algorithms only rejects the key
+		var others []string
+		for _, alg := range Algorithms() {
+			if alg != c.alg {
+				others = append(others, alg)
+			}
+		}
+		if _, err := NewVerifierWithAlgorithms(map[string]crypto.PublicKey{c.kid: c.key.Public()}, others); err == nil {
+			t.Errorf("#%v: expected error for a key of an unaccepted algorithm", i)
+		}
+	}
+
+	for i, c := range []struct {
+		key  crypto.PublicKey
+		algs []string
+	}{
+		// 0: unsupported algorithm
+		{key: edKey.Public(), algs: []string{"RS256"}},
+		// 1: no algorithms
+		{key: edKey.Public(), algs: nil},
+		// 2: curve other than P-256
+		{key: newECKey(t, elliptic.P384()).Public(), algs: Algorithms()},
+		// 3: RSA key shorter than 2048 bits
+		{key: newRSAKey(t, 1024).Public(), algs: Algorithms()},
+	} {
+		if _, err := NewVerifierWithAlgorithms(map[string]crypto.PublicKey{"alpha": c.key}, c.algs); err == nil {
+			t.Errorf("#%v: expected error", i)
+		}
+	}
+}
+
+func newECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key := newTestKey(t)
+	s, err := NewSigner("alpha", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(map[string]crypto.PublicKey{"alpha": key.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Sign(&Identity{InstanceID: "charlie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tamper := range []func(*SignedIdentity){
+		// 0: document of another identity
+		func(s *SignedIdentity) { s.Document = other.Document },
+		// 1: another algorithm
+		func(s *SignedIdentity) { s.Algorithm = "none" },
+		// 2: missing signature
+		func(s *SignedIdentity) { s.Signature = "" },
+		// 3: unknown key ID
+		func(s *SignedIdentity) { s.KeyID = "bravo" },
+	} {
+		signed, err := s.Sign(&Identity{InstanceID: "delta"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(signed)
+		if _, err := v.Verify(signed); err == nil {
+			t.Errorf("#%v: expected error", i)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := newTestKey(t)
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPath := filepath.Join(dir, "key.pem")
+	pubPath := filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadPrivateKey(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyKey, err := LoadPublicKey(pubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signer.(ed25519.PrivateKey), key) || !bytes.Equal(verifyKey.(ed25519.PublicKey), key.Public().(ed25519.PublicKey)) {
+		t.Error("loaded keys do not match")
+	}
+
+	if _, err := LoadPrivateKey(pubPath); err == nil {
+		t.Error("expected error for public key loaded as private key")
+	}
+}
+
+// newTestCertificate returns a certificate of key issued by the parent, or self-signed if parent is nil
+func newTestCertificate(t *testing.T, name string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer, isCA bool) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	rootKey := newTestKey(t)
+	root := newTestCertificate(t, "root", rootKey, nil, nil, true)
+	intermediateKey := newTestKey(t)
+	intermediate := newTestCertificate(t, "intermediate", intermediateKey, root, rootKey, true)
+	otherKey := newTestKey(t)
+	other := newTestCertificate(t, "other", otherKey, nil, nil, true)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCertificate(t, "signer", key, intermediate, intermediateKey, false)
+	otherLeaf := newTestCertificate(t, "signer", key, other, otherKey, false)
+
+	if _, err := NewSignerWithChain("alpha", key, []*x509.Certificate{intermediate}); err == nil {
+		t.Error("expected error for a certificate of another key")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	v, err := NewVerifierWithRoots(nil, roots, Algorithms())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		chain       []*x509.Certificate
+		wantUnknown bool
+	}{
+		// 0: chain to the trusted root
+		{chain: []*x509.Certificate{leaf, intermediate}},
+		// 1: lacking the intermediate
+		{chain: []*x509.Certificate{leaf}, wantUnknown: true},
+		// 2: chain to another root
+		{chain: []*x509.Certificate{otherLeaf, other}, wantUnknown: true},
+		// 3: no chain
+		{wantUnknown: true},
+	}
+
+	for i, c := range tCase {
+		s, err := NewSigner("alpha", key)
+		if len(c.chain) > 0 {
+			s, err = NewSignerWithChain("alpha", key, c.chain)
+		}
+		if err != nil {
+			t.Fatalf("#%v: %v", i, err)
+		}
+		signed, err := s.Sign(&Identity{InstanceID: "delta", ProjectID: "echo"})
+		if err != nil {
+			t.Fatalf("#%v: %v", i, err)
+		}
+
+		id, err := v.Verify(signed)
+		if c.wantUnknown {
+			if !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("#%v: got %v, want an unknown key", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		} else if id.InstanceID != "delta" {
+			t.Errorf("#%v: unexpected identity: %+v", i, id)
+		}
+	}
+
+	// the chain is of the key verifying the signature
+	s, err := NewSigner("alpha", newTestKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.Sign(&Identity{InstanceID: "delta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed.Algorithm = AlgorithmES256
+	signed.CertificateChain = []string{base64.StdEncoding.EncodeToString(leaf.Raw), base64.StdEncoding.EncodeToString(intermediate.Raw)}
+	if _, err := v.Verify(signed); err == nil {
+		t.Error("expected error for a signature of another key")
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendordata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := newTestKey(t)
+	root := newTestCertificate(t, "root", key, nil, nil, true)
+	leaf := newTestCertificate(t, "leaf", newTestKey(t), root, key, false)
+	path := filepath.Join(dir, "chain.pem")
+	var b []byte
+	for _, cert := range []*x509.Certificate{leaf, root} {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := LoadCertificates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(leaf) || !certs[1].Equal(root) {
+		t.Errorf("loaded certificates do not match: %v", certs)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("none"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertificates(path); err == nil {
+		t.Error("expected error for a file without certificates")
+	}
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/perf"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+var (
+	benchmarkSecGroups = []map[string]interface{}{
+		{"id": "sg1", "name": "default"},
+		{"id": "sg2", "name": "web"},
+		{"id": "sg3", "name": "ssh"},
+	}
+	benchmarkMetadata = map[string]string{"env": "prod", "role": "web", "team": "platform", "tier": "frontend"}
+)
+
+func BenchmarkSecurityGroupSelectors(b *testing.B) {
+	b.ReportAllocs()
+	sanitization := &common.SelectorSanitization{}
+	if err := sanitization.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := SecurityGroupSelectors(benchmarkSecGroups, sanitization); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMetadataSelectors(b *testing.B) {
+	b.ReportAllocs()
+	sanitization := &common.SelectorSanitization{}
+	if err := sanitization.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MetadataSelectors(benchmarkMetadata, nil, sanitization)
+	}
+}
+
+// BenchmarkVerify measures the evaluation of the policy with an instance lookup which costs nothing
+func BenchmarkVerify(b *testing.B) {
+	b.ReportAllocs()
+	v, err := New(fake.NewInstance(testProjectID, benchmarkMetadata, benchmarkSecGroups), &Policy{
+		TrustDomain:       "example.com",
+		ProjectIDs:        []string{testProjectID},
+		MetadataSelectors: true,
+		SelectorNamespace: common.SelectorNamespaceProject,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := []byte(fmt.Sprintf(`{"instance_id": %q, "project_id": %q}`, testInstanceID, testProjectID))
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := v.Verify(ctx, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPerformanceBudget(t *testing.T) {
+	perf.Check(t, []perf.Benchmark{
+		{Name: "SecurityGroupSelectors", F: BenchmarkSecurityGroupSelectors, Budget: perf.Budget{NsPerOp: 20000}},
+		{Name: "MetadataSelectors", F: BenchmarkMetadataSelectors, Budget: perf.Budget{NsPerOp: 10000}},
+		{Name: "Verify", F: BenchmarkVerify, Budget: perf.Budget{NsPerOp: 100000}},
+	})
+}
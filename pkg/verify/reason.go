@@ -0,0 +1,49 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+// Reason codes of denials, which are the same as the ones of the server plugin. They must not be changed once
+// released.
+const (
+	ReasonInvalidPayload        = "INVALID_PAYLOAD"
+	ReasonInstanceNotFound      = "INSTANCE_NOT_FOUND"
+	ReasonInstanceTooOld        = "INSTANCE_TOO_OLD"
+	ReasonProjectNotAllowed     = "POLICY_PROJECT_NOT_ALLOWED"
+	ReasonLocalityNotAllowed    = "POLICY_LOCALITY_NOT_ALLOWED"
+	ReasonLogicalNodeNotAllowed = "POLICY_LOGICAL_NODE_NOT_ALLOWED"
+	ReasonSignedIdentityMissing = "SIGNED_IDENTITY_MISSING"
+	ReasonSignedIdentityInvalid = "SIGNED_IDENTITY_INVALID"
+	ReasonPayloadHMACMissing    = "PAYLOAD_HMAC_MISSING"
+	ReasonPayloadHMACInvalid    = "PAYLOAD_HMAC_INVALID"
+	ReasonOpenStackUnavailable  = "OPENSTACK_UNAVAILABLE"
+)
+
+// Error is a failure of a verification with its reason code
+type Error struct {
+	Reason string
+	// Transient is true if the instance was not verified because OpenStack was unavailable, and may be verified
+	// by trying again
+	Transient bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// ReasonOf returns the reason code of an error returned by Verify, or an empty string if it has none
+func ReasonOf(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Reason
+	}
+	return ""
+}
+
+func deny(reason string, err error) error {
+	return &Error{Reason: reason, Err: err}
+}
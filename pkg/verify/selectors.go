@@ -0,0 +1,84 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/secgroups"
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+)
+
+// Localities of instances in the "locality:<locality>" selector
+const (
+	LocalityHome    = "home"
+	LocalityForeign = "foreign"
+)
+
+// SecurityGroupSelectors returns the "sg:id:<id>" and "sg:name:<name>" selector values of the security groups of
+// the Nova server document. Names are sanitized, unless sanitization is nil.
+func SecurityGroupSelectors(sgMapList []map[string]interface{}, sanitization *common.SelectorSanitization) ([]string, error) {
+	var values []string
+	for _, m := range sgMapList {
+		if m == nil {
+			continue
+		}
+
+		var sg secgroups.SecurityGroup
+		if err := mapstructure.Decode(m, &sg); err != nil {
+			return nil, fmt.Errorf("failed to decode SecurityGroup info: %v", err)
+		}
+		if sg.ID != "" {
+			values = append(values, fmt.Sprintf("sg:id:%s", sg.ID))
+		}
+		if name, ok := sanitization.Sanitize(sg.Name); ok && name != "" {
+			values = append(values, fmt.Sprintf("sg:name:%s", name))
+		}
+	}
+	return values, nil
+}
+
+// MetadataSelectors returns the "meta:<key>:<value>" selector values of the instance metadata of the given keys,
+// or of all keys if keys is nil. Empty values are skipped. Keys and values are sanitized, unless sanitization is nil.
+func MetadataSelectors(meta map[string]string, keys []string, sanitization *common.SelectorSanitization) []string {
+	var values []string
+	add := func(k, v string) {
+		if k == "" || v == "" {
+			return
+		}
+		k, ok := sanitization.Sanitize(k)
+		if !ok {
+			return
+		}
+		if v, ok := sanitization.Sanitize(v); ok {
+			values = append(values, fmt.Sprintf("meta:%s:%s", k, v))
+		}
+	}
+	if keys != nil {
+		for _, key := range keys {
+			add(key, meta[key])
+		}
+		return values
+	}
+	for k, v := range meta {
+		add(k, v)
+	}
+	return values
+}
+
+// LocalitySelector returns the "locality:<locality>" selector value
+func LocalitySelector(locality string) string {
+	return fmt.Sprintf("locality:%s", locality)
+}
+
+// LogicalNodeSelector returns the "logical_node:<name>" selector value of an agent attesting as a logical node
+func LogicalNodeSelector(logicalNode string) string {
+	return fmt.Sprintf("logical_node:%s", logicalNode)
+}
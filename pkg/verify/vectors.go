@@ -0,0 +1,507 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// Vector is a test vector of the verification, from the attestation data sent by an agent and the instance as Nova
+// returns it to the decision, so that other implementations of the wire format can check their compatibility
+type Vector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Now is the Unix time the vector is verified at
+	Now    int64         `json:"now"`
+	Policy *VectorPolicy `json:"policy"`
+	// Server is the instance Nova returns. Other instance IDs are not found.
+	Server *VectorServer `json:"server"`
+	// AttestationData is the base64 encoded attestation data, which may be compressed
+	AttestationData string             `json:"attestation_data"`
+	Expected        *VectorExpectation `json:"expected"`
+
+	// payload is encoded into AttestationData by GenerateVectors, compressed if compress is true
+	payload  *common.AttestationPayload
+	compress bool
+}
+
+// VectorPolicy is the part of Policy set by vectors, in a form other implementations can read
+type VectorPolicy struct {
+	TrustDomain              string   `json:"trust_domain"`
+	ProjectIDs               []string `json:"project_ids"`
+	AttestationWindowSeconds int64    `json:"attestation_window_seconds,omitempty"`
+	HomeRegion               string   `json:"home_region,omitempty"`
+	HomeAvailabilityZones    []string `json:"home_availability_zones,omitempty"`
+	DownscopeForeign         bool     `json:"downscope_foreign,omitempty"`
+	MetadataSelectors        bool     `json:"metadata_selectors,omitempty"`
+	MetadataKeys             []string `json:"metadata_keys,omitempty"`
+	SelectorNamespace        string   `json:"selector_namespace,omitempty"`
+	CloudName                string   `json:"cloud_name,omitempty"`
+	// SigningKeys are the base64 encoded PKIX public keys trusted to sign identities, by key ID
+	SigningKeys           map[string]string `json:"signing_keys,omitempty"`
+	RequireSignedIdentity bool              `json:"require_signed_identity,omitempty"`
+	// HMACSecrets are the base64 encoded secrets of payload HMACs by key ID, shared by all projects
+	HMACSecrets        map[string]string `json:"hmac_secrets,omitempty"`
+	RequirePayloadHMAC bool              `json:"require_payload_hmac,omitempty"`
+	HMACMaxAgeSeconds  int64             `json:"hmac_max_age_seconds,omitempty"`
+}
+
+// VectorServer is the part of the Nova server document of the instance the verification relies on
+type VectorServer struct {
+	ID               string                   `json:"id"`
+	TenantID         string                   `json:"tenant_id"`
+	Created          time.Time                `json:"created"`
+	Region           string                   `json:"region,omitempty"`
+	AvailabilityZone string                   `json:"availability_zone,omitempty"`
+	Metadata         map[string]string        `json:"metadata,omitempty"`
+	SecurityGroups   []map[string]interface{} `json:"security_groups,omitempty"`
+}
+
+// VectorExpectation is the decision on a vector, either the reason code of the denial or the agent ID and the sorted
+// selector values of the admitted instance
+type VectorExpectation struct {
+	Reason    string   `json:"reason,omitempty"`
+	AgentID   string   `json:"agent_id,omitempty"`
+	Selectors []string `json:"selectors,omitempty"`
+}
+
+// Verify verifies the vector at its time, returning the decision
+func (v *Vector) Verify(ctx context.Context) (*VectorExpectation, error) {
+	policy, err := v.Policy.policy()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(v.AttestationData)
+	if err != nil {
+		return nil, fmt.Errorf("malformed attestation_data: %v", err)
+	}
+	verifier, err := New(&vectorInstance{server: v.Server}, policy)
+	if err != nil {
+		return nil, err
+	}
+	verifier.now = func() time.Time {
+		return time.Unix(v.Now, 0)
+	}
+
+	r, err := verifier.Verify(ctx, data)
+	if err != nil {
+		reason := ReasonOf(err)
+		if reason == "" {
+			return nil, err
+		}
+		return &VectorExpectation{Reason: reason}, nil
+	}
+	return &VectorExpectation{AgentID: r.AgentID, Selectors: r.Selectors}, nil
+}
+
+func (p *VectorPolicy) policy() (*Policy, error) {
+	policy := &Policy{
+		TrustDomain:           p.TrustDomain,
+		ProjectIDs:            p.ProjectIDs,
+		AttestationWindow:     time.Duration(p.AttestationWindowSeconds) * time.Second,
+		HomeRegion:            p.HomeRegion,
+		HomeAvailabilityZones: p.HomeAvailabilityZones,
+		DownscopeForeign:      p.DownscopeForeign,
+		MetadataSelectors:     p.MetadataSelectors,
+		MetadataKeys:          p.MetadataKeys,
+		SelectorNamespace:     p.SelectorNamespace,
+		CloudName:             p.CloudName,
+		RequireSignedIdentity: p.RequireSignedIdentity,
+		RequirePayloadHMAC:    p.RequirePayloadHMAC,
+		HMACMaxAge:            time.Duration(p.HMACMaxAgeSeconds) * time.Second,
+	}
+	if len(p.SigningKeys) > 0 {
+		keys := make(map[string]crypto.PublicKey)
+		for kid, encoded := range p.SigningKeys {
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("malformed signing key %v: %v", kid, err)
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				return nil, fmt.Errorf("malformed signing key %v: %v", kid, err)
+			}
+			keys[kid] = key
+		}
+		v, err := vendordata.NewVerifier(keys)
+		if err != nil {
+			return nil, err
+		}
+		policy.SignedIdentityVerifier = v
+	}
+	if len(p.HMACSecrets) > 0 {
+		secrets := make(map[string][]byte)
+		for kid, encoded := range p.HMACSecrets {
+			secret, err := common.DecodeHMACSecret(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("hmac secret %v: %v", kid, err)
+			}
+			secrets[kid] = secret
+		}
+		policy.HMACSecret = func(keyID, _ string) []byte {
+			return secrets[keyID]
+		}
+	}
+	return policy, nil
+}
+
+// vectorInstance returns the server of a vector, and no other instance
+type vectorInstance struct {
+	server *VectorServer
+}
+
+func (i *vectorInstance) Get(_ context.Context, uuid string) (*openstack.Server, error) {
+	s := i.server
+	if s == nil || s.ID != uuid {
+		return nil, gophercloud.ErrDefault404{}
+	}
+	return &openstack.Server{
+		Server: servers.Server{
+			ID:             s.ID,
+			TenantID:       s.TenantID,
+			Created:        s.Created,
+			Metadata:       s.Metadata,
+			SecurityGroups: s.SecurityGroups,
+		},
+		ServerAvailabilityZoneExt: availabilityzones.ServerAvailabilityZoneExt{
+			AvailabilityZone: s.AvailabilityZone,
+		},
+		Region: s.Region,
+	}, nil
+}
+
+const (
+	vectorTrustDomain = "example.org"
+	vectorProjectID   = "2f7e6b1c9d4a4e0f8a3b5c6d7e8f9a0b"
+	vectorInstanceID  = "6a1f3c2e-4b5d-4e6f-8a9b-0c1d2e3f4a5b"
+	vectorKeyID       = "signer-1"
+	vectorHMACKeyID   = "hmac-1"
+)
+
+// GenerateVectors returns the test vectors, which are the same on every call: keys and secrets are derived from fixed
+// seeds, and the signatures are deterministic
+func GenerateVectors() ([]*Vector, error) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))
+	signer, err := vendordata.NewSigner(vectorKeyID, key)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	signingKeys := map[string]string{vectorKeyID: base64.StdEncoding.EncodeToString(public)}
+
+	secret := bytes.Repeat([]byte{0x02}, 32)
+	hmacSecrets := map[string]string{vectorHMACKeyID: base64.StdEncoding.EncodeToString(secret)}
+
+	server := func() *VectorServer {
+		return &VectorServer{
+			ID:               vectorInstanceID,
+			TenantID:         vectorProjectID,
+			Created:          now.Add(-time.Hour),
+			Region:           "east",
+			AvailabilityZone: "nova",
+			Metadata:         map[string]string{"env": "prod", "role": "db"},
+			SecurityGroups:   []map[string]interface{}{{"id": "5c8d0f4e", "name": "web"}},
+		}
+	}
+	payload := func() *common.AttestationPayload {
+		return &common.AttestationPayload{InstanceID: vectorInstanceID, ProjectID: vectorProjectID}
+	}
+	identity := func() *vendordata.Identity {
+		return &vendordata.Identity{InstanceID: vectorInstanceID, ProjectID: vectorProjectID, IssuedAt: now.Unix()}
+	}
+	admitted := func(selectors ...string) *VectorExpectation {
+		return &VectorExpectation{
+			AgentID:   common.GenerateSpiffeID(vectorTrustDomain, vectorProjectID, vectorInstanceID),
+			Selectors: selectors,
+		}
+	}
+	denied := func(reason string) *VectorExpectation {
+		return &VectorExpectation{Reason: reason}
+	}
+
+	signed := payload()
+	if signed.SignedIdentity, err = signer.Sign(identity()); err != nil {
+		return nil, err
+	}
+	otherInstance := identity()
+	otherInstance.InstanceID = "0b9c8d7e-6f5a-4b3c-9d2e-1f0a9b8c7d6e"
+	signedOther := payload()
+	if signedOther.SignedIdentity, err = signer.Sign(otherInstance); err != nil {
+		return nil, err
+	}
+	// The identity of the instance with the signature of the other one
+	forgedIdentity := *signed.SignedIdentity
+	forgedIdentity.Signature = signedOther.SignedIdentity.Signature
+	forged := payload()
+	forged.SignedIdentity = &forgedIdentity
+
+	hmacSigned := payload()
+	hmacSigned.SignHMAC(vectorHMACKeyID, secret, "", now)
+	hmacTampered := payload()
+	hmacTampered.SignHMAC(vectorHMACKeyID, secret, "", now)
+	hmacTampered.ProjectID = "7c3d9e1f0a2b4c5d6e7f8a9b0c1d2e3f"
+	hmacExpired := payload()
+	hmacExpired.SignHMAC(vectorHMACKeyID, secret, "", now.Add(-time.Hour))
+	hmacUnknown := payload()
+	hmacUnknown.SignHMAC("hmac-0", secret, "", now)
+
+	v2 := payload()
+	v2.Version = common.PayloadVersion2
+	v2.Metadata = &common.PayloadMetadata{Name: "db-0", AvailabilityZone: "nova", Source: "config_drive"}
+	metadataV1 := payload()
+	metadataV1.Metadata = &common.PayloadMetadata{Name: "db-0"}
+
+	vectors := []*Vector{
+		{
+			Name:            "bare-instance-id",
+			Description:     "Bare instance ID sent by agents predating the JSON payload",
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:        "json-payload",
+			Description: "JSON payload without a version, of version 1",
+			payload:     payload(),
+			Expected:    admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:        "json-payload-v2",
+			Description: "JSON payload of version 2 with the metadata of the instance",
+			payload:     v2,
+			Expected:    admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:        "json-payload-gzip",
+			Description: "JSON payload of version 2 compressed with gzip",
+			payload:     v2,
+			compress:    true,
+			Expected:    admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "json-payload-unknown-fields",
+			Description:     "Fields added by newer agents are tolerated",
+			AttestationData: fmt.Sprintf(`{"instance_id": %q, "project_id": %q, "version": 3, "attested_by": "agent-x"}`, vectorInstanceID, vectorProjectID),
+			Expected:        admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "payload-empty-instance-id",
+			Description:     "JSON payload with an empty instance ID",
+			AttestationData: `{"instance_id": ""}`,
+			Expected:        denied(ReasonInvalidPayload),
+		},
+		{
+			Name:            "payload-instance-id-with-slash",
+			Description:     "Instance IDs must not contain slashes, which would alter the path of the agent ID",
+			AttestationData: `{"instance_id": "../6a1f3c2e"}`,
+			Expected:        denied(ReasonInvalidPayload),
+		},
+		{
+			Name:        "payload-metadata-version-1",
+			Description: "Metadata requires the payload of version 2",
+			payload:     metadataV1,
+			Expected:    denied(ReasonInvalidPayload),
+		},
+		{
+			Name:            "payload-too-large",
+			Description:     "Attestation data exceeding 4096 bytes",
+			AttestationData: string(bytes.Repeat([]byte("a"), common.MaxAttestationPayloadSize+1)),
+			Expected:        denied(ReasonInvalidPayload),
+		},
+		{
+			Name:            "instance-not-found",
+			Description:     "Instance unknown to Nova",
+			AttestationData: "0b9c8d7e-6f5a-4b3c-9d2e-1f0a9b8c7d6e",
+			Expected:        denied(ReasonInstanceNotFound),
+		},
+		{
+			Name:            "project-not-allowed",
+			Description:     "Instance of a project outside of the allowlist, whatever project the payload claims",
+			Policy:          &VectorPolicy{TrustDomain: vectorTrustDomain, ProjectIDs: []string{"7c3d9e1f0a2b4c5d6e7f8a9b0c1d2e3f"}},
+			AttestationData: fmt.Sprintf(`{"instance_id": %q, "project_id": "7c3d9e1f0a2b4c5d6e7f8a9b0c1d2e3f"}`, vectorInstanceID),
+			Expected:        denied(ReasonProjectNotAllowed),
+		},
+		{
+			Name:            "attestation-window",
+			Description:     "Instance created within the attestation window",
+			Policy:          &VectorPolicy{AttestationWindowSeconds: 7200},
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "instance-too-old",
+			Description:     "Instance created before the attestation window",
+			Policy:          &VectorPolicy{AttestationWindowSeconds: 600},
+			AttestationData: vectorInstanceID,
+			Expected:        denied(ReasonInstanceTooOld),
+		},
+		{
+			Name:            "metadata-selectors",
+			Description:     "Selectors of all instance metadata",
+			Policy:          &VectorPolicy{MetadataSelectors: true},
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("meta:env:prod", "meta:role:db", "sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "metadata-selectors-keys-project-namespace",
+			Description:     "Selectors of the instance metadata of given keys, prefixed with the project ID",
+			Policy:          &VectorPolicy{MetadataSelectors: true, MetadataKeys: []string{"env", "team"}, SelectorNamespace: common.SelectorNamespaceProject},
+			AttestationData: vectorInstanceID,
+			Expected: admitted(
+				vectorProjectID+":meta:env:prod",
+				vectorProjectID+":sg:id:5c8d0f4e",
+				vectorProjectID+":sg:name:web",
+			),
+		},
+		{
+			Name:            "cloud-namespace",
+			Description:     "Selectors prefixed with the name of the cloud",
+			Policy:          &VectorPolicy{SelectorNamespace: common.SelectorNamespaceCloud, CloudName: "east"},
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("east:sg:id:5c8d0f4e", "east:sg:name:web"),
+		},
+		{
+			Name:            "locality-home",
+			Description:     "Instance in the home region and zone",
+			Policy:          &VectorPolicy{HomeRegion: "east", HomeAvailabilityZones: []string{"nova"}},
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("locality:home", "sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "locality-foreign-downscoped",
+			Description:     "Instance outside of the home region admitted with the foreign locality",
+			Policy:          &VectorPolicy{HomeRegion: "west", DownscopeForeign: true},
+			AttestationData: vectorInstanceID,
+			Expected:        admitted("locality:foreign", "sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:            "locality-foreign-denied",
+			Description:     "Instance outside of the home zones",
+			Policy:          &VectorPolicy{HomeAvailabilityZones: []string{"az-2"}},
+			AttestationData: vectorInstanceID,
+			Expected:        denied(ReasonLocalityNotAllowed),
+		},
+		{
+			Name:        "signed-identity",
+			Description: "Identity signed with Ed25519 by a trusted key",
+			Policy:      &VectorPolicy{SigningKeys: signingKeys, RequireSignedIdentity: true},
+			payload:     signed,
+			Expected:    admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:        "signed-identity-missing",
+			Description: "Signed identity required but not sent",
+			Policy:      &VectorPolicy{SigningKeys: signingKeys, RequireSignedIdentity: true},
+			payload:     payload(),
+			Expected:    denied(ReasonSignedIdentityMissing),
+		},
+		{
+			Name:        "signed-identity-other-instance",
+			Description: "Authentic identity of another instance",
+			Policy:      &VectorPolicy{SigningKeys: signingKeys},
+			payload:     signedOther,
+			Expected:    denied(ReasonSignedIdentityInvalid),
+		},
+		{
+			Name:        "signed-identity-bad-signature",
+			Description: "Identity whose signature is of another document",
+			Policy:      &VectorPolicy{SigningKeys: signingKeys},
+			payload:     forged,
+			Expected:    denied(ReasonSignedIdentityInvalid),
+		},
+		{
+			Name:        "signed-identity-unknown-key",
+			Description: "Identity signed with a key not trusted",
+			Policy:      &VectorPolicy{SigningKeys: map[string]string{"signer-0": signingKeys[vectorKeyID]}},
+			payload:     signed,
+			Expected:    denied(ReasonSignedIdentityInvalid),
+		},
+		{
+			Name:        "payload-hmac",
+			Description: "Payload authenticated with HMAC-SHA256",
+			Policy:      &VectorPolicy{HMACSecrets: hmacSecrets, RequirePayloadHMAC: true},
+			payload:     hmacSigned,
+			Expected:    admitted("sg:id:5c8d0f4e", "sg:name:web"),
+		},
+		{
+			Name:        "payload-hmac-missing",
+			Description: "Payload HMAC required but not sent",
+			Policy:      &VectorPolicy{HMACSecrets: hmacSecrets, RequirePayloadHMAC: true},
+			payload:     payload(),
+			Expected:    denied(ReasonPayloadHMACMissing),
+		},
+		{
+			Name:        "payload-hmac-tampered",
+			Description: "Project ID changed after the MAC was computed",
+			Policy:      &VectorPolicy{HMACSecrets: hmacSecrets},
+			payload:     hmacTampered,
+			Expected:    denied(ReasonPayloadHMACInvalid),
+		},
+		{
+			Name:        "payload-hmac-expired",
+			Description: "MAC issued before the maximum age of 300 seconds",
+			Policy:      &VectorPolicy{HMACSecrets: hmacSecrets, HMACMaxAgeSeconds: 300},
+			payload:     hmacExpired,
+			Expected:    denied(ReasonPayloadHMACInvalid),
+		},
+		{
+			Name:        "payload-hmac-unknown-key",
+			Description: "MAC of a key ID not known",
+			Policy:      &VectorPolicy{HMACSecrets: hmacSecrets},
+			payload:     hmacUnknown,
+			Expected:    denied(ReasonPayloadHMACInvalid),
+		},
+	}
+
+	for _, v := range vectors {
+		v.Now = now.Unix()
+		v.Server = server()
+		if v.Policy == nil {
+			v.Policy = &VectorPolicy{}
+		}
+		if v.Policy.TrustDomain == "" {
+			v.Policy.TrustDomain = vectorTrustDomain
+		}
+		if v.Policy.ProjectIDs == nil {
+			v.Policy.ProjectIDs = []string{vectorProjectID}
+		}
+
+		data := []byte(v.AttestationData)
+		if v.payload != nil {
+			if data, err = v.payload.Marshal(); err != nil {
+				return nil, err
+			}
+		}
+		if v.compress {
+			if data, err = common.CompressPayload(data); err != nil {
+				return nil, err
+			}
+		}
+		v.AttestationData = base64.StdEncoding.EncodeToString(data)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+const vectorsFile = "testdata/vectors.json"
+
+var update = flag.Bool("update", false, "regenerate "+vectorsFile)
+
+func TestGenerateVectors(t *testing.T) {
+	vectors, err := GenerateVectors()
+	if err != nil {
+		t.Fatalf("unexpected error from GenerateVectors(): %v", err)
+	}
+	again, err := GenerateVectors()
+	if err != nil {
+		t.Fatalf("unexpected error from GenerateVectors(): %v", err)
+	}
+	if !reflect.DeepEqual(vectors, again) {
+		t.Error("vectors differ between calls")
+	}
+
+	names := make(map[string]bool)
+	for _, v := range vectors {
+		if names[v.Name] {
+			t.Errorf("%v: duplicated name", v.Name)
+		}
+		names[v.Name] = true
+
+		got, err := v.Verify(context.Background())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", v.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.Expected) {
+			t.Errorf("%v: got %+v, want %+v", v.Name, got, v.Expected)
+		}
+	}
+}
+
+// TestShippedVectors checks that the shipped vectors still hold, so that changes breaking the wire format are caught.
+// Run "go test ./pkg/verify -update" to regenerate them once the vectors are changed on purpose.
+func TestShippedVectors(t *testing.T) {
+	generated, err := GenerateVectors()
+	if err != nil {
+		t.Fatalf("unexpected error from GenerateVectors(): %v", err)
+	}
+	if *update {
+		b, err := json.MarshalIndent(generated, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(vectorsFile, append(b, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shipped []*Vector
+	if err := json.Unmarshal(b, &shipped); err != nil {
+		t.Fatalf("malformed %v: %v", vectorsFile, err)
+	}
+	if len(shipped) != len(generated) {
+		t.Fatalf("%v has %v vectors, want %v; run go test -update", vectorsFile, len(shipped), len(generated))
+	}
+
+	for i, v := range shipped {
+		if v.Name != generated[i].Name || !reflect.DeepEqual(v.Expected, generated[i].Expected) {
+			t.Errorf("#%v: %v differs from the generated vector %v; run go test -update", i, v.Name, generated[i].Name)
+			continue
+		}
+		got, err := v.Verify(context.Background())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", v.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.Expected) {
+			t.Errorf("%v: got %+v, want %+v", v.Name, got, v.Expected)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package verify verifies the identity of OpenStack instances from the attestation data of their agents, as the
+// server plugin does, so that other tools, e.g. admission webhooks or CI checks, can verify instances without
+// running SPIRE. It covers the parsing of the payload, the lookup of the instance in Nova, the policy shared by all
+// deployments and the selectors. State kept by the server across attestations, e.g. whether the instance attested
+// before, quotas or instance fingerprints, is left to the caller.
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/vendordata"
+)
+
+// DefaultHMACMaxAge is the maximum age of payload HMACs if the policy doesn't set one
+const DefaultHMACMaxAge = 5 * time.Minute
+
+// DefaultSignedIdentityMaxAge is the maximum age of signed identities if the policy doesn't set one
+const DefaultSignedIdentityMaxAge = 5 * time.Minute
+
+// Policy is what verified instances must satisfy
+type Policy struct {
+	// TrustDomain of the agent IDs. Required.
+	TrustDomain string
+	// ProjectIDs whose instances are admitted. Required.
+	ProjectIDs []string
+	// AttestationWindow denies instances created longer ago, if not zero
+	AttestationWindow time.Duration
+
+	// HomeRegion and HomeAvailabilityZones deny instances outside of them if set, or admit them with the
+	// "locality:foreign" selector if DownscopeForeign is true
+	HomeRegion            string
+	HomeAvailabilityZones []string
+	DownscopeForeign      bool
+
+	// MetadataSelectors adds the "meta:<key>:<value>" selectors of the instance metadata of MetadataKeys, or of
+	// all keys if MetadataKeys is nil
+	MetadataSelectors bool
+	MetadataKeys      []string
+	// SelectorNamespace prefixes selector values, common.SelectorNamespaceCloud with CloudName or
+	// common.SelectorNamespaceProject, if set
+	SelectorNamespace string
+	CloudName         string
+	// SelectorSanitization drops selectors of security group names and instance metadata violating it, if set
+	SelectorSanitization *common.SelectorSanitization
+
+	// SignedIdentityVerifier verifies signed identities sent by agents, if set. They are required if
+	// RequireSignedIdentity is true. Identities signed more than SignedIdentityMaxAge before or after now are
+	// invalid; it defaults to DefaultSignedIdentityMaxAge.
+	SignedIdentityVerifier *vendordata.Verifier
+	RequireSignedIdentity  bool
+	SignedIdentityMaxAge   time.Duration
+
+	// HMACSecret returns the secret of the key ID provisioned to the project, or nil if there is none. Payload HMACs
+	// are verified if it is set, and required if RequirePayloadHMAC is true. HMACMaxAge defaults to
+	// DefaultHMACMaxAge.
+	HMACSecret         func(keyID, projectID string) []byte
+	RequirePayloadHMAC bool
+	HMACMaxAge         time.Duration
+
+	// LogicalNodePattern admits the logical nodes agents attest as, which get agent IDs of their own. Logical nodes
+	// are denied if it is nil.
+	LogicalNodePattern *regexp.Regexp
+}
+
+// Result is a verified instance
+type Result struct {
+	InstanceID string
+	ProjectID  string
+	// AgentID is the SPIFFE ID the server plugin issues to the agent of the instance
+	AgentID string
+	// Selectors are the sorted values of the selectors of the type "openstack_iid"
+	Selectors []string
+	// Server is the Nova server document of the instance
+	Server *openstack.Server
+}
+
+// Verifier verifies instances against a policy
+type Verifier struct {
+	instance openstack.InstanceClient
+	policy   Policy
+	now      func() time.Time
+}
+
+// New returns a verifier looking instances up with the instance client
+func New(instance openstack.InstanceClient, policy *Policy) (*Verifier, error) {
+	if instance == nil {
+		return nil, errors.New("instance client is required")
+	}
+	if policy.TrustDomain == "" {
+		return nil, errors.New("trust domain is required")
+	}
+	if len(policy.ProjectIDs) == 0 {
+		return nil, errors.New("project IDs are required")
+	}
+	if err := common.ValidateSelectorNamespace(policy.SelectorNamespace, policy.CloudName); err != nil {
+		return nil, err
+	}
+	if err := policy.SelectorSanitization.Validate(); err != nil {
+		return nil, err
+	}
+	if policy.RequireSignedIdentity && policy.SignedIdentityVerifier == nil {
+		return nil, errors.New("requiring signed identities requires a verifier")
+	}
+	if policy.RequirePayloadHMAC && policy.HMACSecret == nil {
+		return nil, errors.New("requiring payload HMACs requires secrets")
+	}
+	v := &Verifier{
+		instance: instance,
+		policy:   *policy,
+		now:      time.Now,
+	}
+	if v.policy.HMACMaxAge <= 0 {
+		v.policy.HMACMaxAge = DefaultHMACMaxAge
+	}
+	if v.policy.SignedIdentityMaxAge <= 0 {
+		v.policy.SignedIdentityMaxAge = DefaultSignedIdentityMaxAge
+	}
+	return v, nil
+}
+
+// Verify verifies the instance of the attestation data sent by an agent, either the bare instance ID or the JSON
+// payload. Errors are of the type *Error, with the reason code of the denial. Encrypted attestation data must be
+// decrypted with common.DecryptPayload first.
+func (v *Verifier) Verify(ctx context.Context, data []byte) (*Result, error) {
+	payload, err := common.ParseAttestationPayload(data)
+	if err != nil {
+		return nil, deny(ReasonInvalidPayload, err)
+	}
+	return v.VerifyPayload(ctx, payload)
+}
+
+// VerifyPayload verifies the instance of a parsed attestation payload. Payloads claiming a project which is not
+// allowed are denied without looking the instance up.
+func (v *Verifier) VerifyPayload(ctx context.Context, payload *common.AttestationPayload) (*Result, error) {
+	iid := payload.InstanceID
+	if payload.ProjectID != "" && !contains(v.policy.ProjectIDs, payload.ProjectID) {
+		return nil, deny(ReasonProjectNotAllowed, fmt.Errorf("project %v of the attestation data is not allowed", payload.ProjectID))
+	}
+	if node := payload.LogicalNode; node != "" && (v.policy.LogicalNodePattern == nil || !v.policy.LogicalNodePattern.MatchString(node)) {
+		return nil, deny(ReasonLogicalNodeNotAllowed, fmt.Errorf("logical node %q is not allowed", node))
+	}
+	s, err := v.instance.Get(openstack.WithProjectHint(ctx, payload.ProjectID), iid)
+	if err != nil {
+		if !openstack.IsNotFound(err) {
+			return nil, &Error{Reason: ReasonOpenStackUnavailable, Transient: true, Err: fmt.Errorf("failed to look up instance %v: %v", iid, err)}
+		}
+		return nil, deny(ReasonInstanceNotFound, fmt.Errorf("instance %v is not found: %v", iid, err))
+	}
+
+	p := &v.policy
+	if !contains(p.ProjectIDs, s.TenantID) {
+		return nil, deny(ReasonProjectNotAllowed, fmt.Errorf("project %v of instance %v is not allowed", s.TenantID, iid))
+	}
+	if p.AttestationWindow > 0 {
+		if age := v.now().Sub(s.Created); age > p.AttestationWindow {
+			return nil, deny(ReasonInstanceTooOld, fmt.Errorf("instance was created %v ago, outside of the attestation window", age.Round(time.Second)))
+		}
+	}
+	if err := v.checkSignedIdentity(payload, s); err != nil {
+		return nil, err
+	}
+	if err := v.checkPayloadHMAC(payload, s); err != nil {
+		return nil, err
+	}
+
+	values, err := v.selectors(s, payload.LogicalNode)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		InstanceID: iid,
+		ProjectID:  s.TenantID,
+		AgentID:    common.GenerateLogicalNodeSpiffeID(p.TrustDomain, s.TenantID, iid, payload.LogicalNode),
+		Selectors:  values,
+		Server:     s,
+	}, nil
+}
+
+// checkSignedIdentity verifies the identity signed by the vendordata signing service against the instance
+func (v *Verifier) checkSignedIdentity(payload *common.AttestationPayload, s *openstack.Server) error {
+	p := &v.policy
+	if payload.SignedIdentity == nil {
+		if p.RequireSignedIdentity {
+			return deny(ReasonSignedIdentityMissing, fmt.Errorf("instance %v sent no signed identity", s.ID))
+		}
+		return nil
+	}
+	if p.SignedIdentityVerifier == nil {
+		return nil
+	}
+	id, err := p.SignedIdentityVerifier.Verify(payload.SignedIdentity)
+	if err == nil {
+		switch {
+		case id.InstanceID != s.ID:
+			err = fmt.Errorf("identity is of instance %v", id.InstanceID)
+		case id.ProjectID != s.TenantID:
+			err = fmt.Errorf("identity is of project %v", id.ProjectID)
+		default:
+			err = v.checkIssuedAt(time.Unix(id.IssuedAt, 0))
+		}
+	}
+	if err != nil {
+		return deny(ReasonSignedIdentityInvalid, fmt.Errorf("signed identity of instance %v is invalid: %v", s.ID, err))
+	}
+	return nil
+}
+
+// checkIssuedAt returns an error if the identity was signed more than the max age before or after now
+func (v *Verifier) checkIssuedAt(issuedAt time.Time) error {
+	age, maxAge := v.now().Sub(issuedAt), v.policy.SignedIdentityMaxAge
+	switch {
+	case age > maxAge:
+		return fmt.Errorf("identity was signed at %v, more than %v ago", issuedAt.UTC().Format(time.RFC3339), maxAge)
+	case -age > maxAge:
+		return fmt.Errorf("identity was signed at %v, more than %v ahead", issuedAt.UTC().Format(time.RFC3339), maxAge)
+	}
+	return nil
+}
+
+// checkPayloadHMAC verifies the MAC of the payload with the secret of its key ID provisioned to the project
+func (v *Verifier) checkPayloadHMAC(payload *common.AttestationPayload, s *openstack.Server) error {
+	p := &v.policy
+	if payload.HMAC == nil {
+		if p.RequirePayloadHMAC {
+			return deny(ReasonPayloadHMACMissing, fmt.Errorf("instance %v sent no hmac", s.ID))
+		}
+		return nil
+	}
+	if p.HMACSecret == nil {
+		return nil
+	}
+	err := payload.VerifyHMAC(func(keyID string) []byte {
+		return p.HMACSecret(keyID, s.TenantID)
+	}, p.HMACMaxAge, v.now())
+	if err != nil {
+		return deny(ReasonPayloadHMACInvalid, fmt.Errorf("hmac of instance %v is invalid: %v", s.ID, err))
+	}
+	return nil
+}
+
+// selectors returns the sorted selector values of the instance
+func (v *Verifier) selectors(s *openstack.Server, logicalNode string) ([]string, error) {
+	p := &v.policy
+	values, err := SecurityGroupSelectors(s.SecurityGroups, p.SelectorSanitization)
+	if err != nil {
+		return nil, err
+	}
+	if p.MetadataSelectors {
+		values = append(values, MetadataSelectors(s.Metadata, p.MetadataKeys, p.SelectorSanitization)...)
+	}
+
+	if p.HomeRegion != "" || len(p.HomeAvailabilityZones) > 0 {
+		locality := LocalityHome
+		if p.HomeRegion != "" && s.Region != p.HomeRegion {
+			locality = LocalityForeign
+		}
+		if len(p.HomeAvailabilityZones) > 0 && !contains(p.HomeAvailabilityZones, s.AvailabilityZone) {
+			locality = LocalityForeign
+		}
+		if locality == LocalityForeign && !p.DownscopeForeign {
+			return nil, deny(ReasonLocalityNotAllowed, fmt.Errorf("instance is outside of the home zone: region=%q, zone=%q", s.Region, s.AvailabilityZone))
+		}
+		values = append(values, LocalitySelector(locality))
+	}
+	if logicalNode != "" {
+		values = append(values, LogicalNodeSelector(logicalNode))
+	}
+
+	for i, value := range values {
+		values[i] = common.NamespacedSelectorValue(p.SelectorNamespace, p.CloudName, s.TenantID, value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
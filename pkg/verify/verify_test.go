@@ -0,0 +1,214 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package verify
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/zlabjp/spire-openstack-plugin/pkg/common"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/openstack"
+	"github.com/zlabjp/spire-openstack-plugin/pkg/util/fake"
+)
+
+const (
+	testProjectID  = "alpha"
+	testInstanceID = "1234"
+)
+
+func TestVerify(t *testing.T) {
+	secGroups := []map[string]interface{}{{"id": "sg1", "name": "web"}}
+	meta := map[string]string{"env": "prod", "role": "db"}
+	secret := []byte("0123456789abcdef")
+
+	signed := &common.AttestationPayload{InstanceID: testInstanceID, ProjectID: testProjectID}
+	signed.SignHMAC("k1", secret, "", time.Now())
+	signedData, err := signed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tCase := []struct {
+		instance      openstack.InstanceClient
+		policy        Policy
+		data          []byte
+		wantSelectors []string
+		wantAgentID   string
+		wantReason    string
+	}{
+		// 0: security group selectors from the bare instance ID
+		{
+			instance:      fake.NewInstance(testProjectID, meta, secGroups),
+			data:          []byte(testInstanceID),
+			wantSelectors: []string{"sg:id:sg1", "sg:name:web"},
+		},
+		// 1: metadata selectors of the keys in the project namespace
+		{
+			instance:      fake.NewInstance(testProjectID, meta, secGroups),
+			policy:        Policy{MetadataSelectors: true, MetadataKeys: []string{"env"}, SelectorNamespace: common.SelectorNamespaceProject},
+			data:          []byte(testInstanceID),
+			wantSelectors: []string{"alpha:meta:env:prod", "alpha:sg:id:sg1", "alpha:sg:name:web"},
+		},
+		// 2: crafted metadata dropped by sanitization
+		{
+			instance:      fake.NewInstance(testProjectID, map[string]string{"env": "prod", "role:admin": "x", "team": "a\nb"}, nil),
+			policy:        Policy{MetadataSelectors: true, SelectorSanitization: &common.SelectorSanitization{}},
+			data:          []byte(testInstanceID),
+			wantSelectors: []string{"meta:env:prod"},
+		},
+		// 3: foreign instance downscoped
+		{
+			instance:      fake.NewInstanceWithZone(testProjectID, "west", "nova"),
+			policy:        Policy{HomeRegion: "east", DownscopeForeign: true},
+			data:          []byte(testInstanceID),
+			wantSelectors: []string{"locality:foreign"},
+		},
+		// 4: foreign instance denied
+		{
+			instance:   fake.NewInstanceWithZone(testProjectID, "west", "nova"),
+			policy:     Policy{HomeRegion: "east"},
+			data:       []byte(testInstanceID),
+			wantReason: ReasonLocalityNotAllowed,
+		},
+		// 5: project not allowed
+		{
+			instance:   fake.NewInstance("bravo", nil, nil),
+			data:       []byte(testInstanceID),
+			wantReason: ReasonProjectNotAllowed,
+		},
+		// 6: too old
+		{
+			instance:   fake.NewInstanceWithTime(testProjectID, time.Now().Add(-time.Hour)),
+			policy:     Policy{AttestationWindow: time.Minute},
+			data:       []byte(testInstanceID),
+			wantReason: ReasonInstanceTooOld,
+		},
+		// 7: OpenStack unavailable
+		{
+			instance:   fake.NewErrorInstance("connection refused"),
+			data:       []byte(testInstanceID),
+			wantReason: ReasonOpenStackUnavailable,
+		},
+		// 8: malformed payload
+		{
+			instance:   fake.NewInstance(testProjectID, nil, nil),
+			data:       []byte(`{"instance_id": ""}`),
+			wantReason: ReasonInvalidPayload,
+		},
+		// 9: payload HMAC verified
+		{
+			instance: fake.NewInstance(testProjectID, nil, nil),
+			policy: Policy{RequirePayloadHMAC: true, HMACSecret: func(keyID, projectID string) []byte {
+				if keyID == "k1" && projectID == testProjectID {
+					return secret
+				}
+				return nil
+			}},
+			data: signedData,
+		},
+		// 10: payload HMAC of a secret of another project
+		{
+			instance: fake.NewInstance(testProjectID, nil, nil),
+			policy: Policy{HMACSecret: func(keyID, projectID string) []byte {
+				if keyID == "k1" && projectID == "bravo" {
+					return secret
+				}
+				return nil
+			}},
+			data:       signedData,
+			wantReason: ReasonPayloadHMACInvalid,
+		},
+		// 11: payload HMAC required
+		{
+			instance:   fake.NewInstance(testProjectID, nil, nil),
+			policy:     Policy{RequirePayloadHMAC: true, HMACSecret: func(string, string) []byte { return secret }},
+			data:       []byte(testInstanceID),
+			wantReason: ReasonPayloadHMACMissing,
+		},
+		// 12: project hint not allowed, denied without a lookup
+		{
+			instance:   fake.NewErrorInstance("unexpected lookup"),
+			data:       []byte(`{"instance_id": "1234", "project_id": "bravo"}`),
+			wantReason: ReasonProjectNotAllowed,
+		},
+		// 13: logical node with an agent ID of its own
+		{
+			instance:      fake.NewInstance(testProjectID, nil, nil),
+			policy:        Policy{LogicalNodePattern: regexp.MustCompile(`^containerd$`)},
+			data:          []byte(`{"instance_id": "1234", "logical_node": "containerd"}`),
+			wantSelectors: []string{"logical_node:containerd"},
+			wantAgentID:   "spiffe://example.com/spire/agent/openstack_iid/alpha/1234/containerd",
+		},
+		// 14: logical node not allowed
+		{
+			instance:   fake.NewInstance(testProjectID, nil, nil),
+			data:       []byte(`{"instance_id": "1234", "logical_node": "containerd"}`),
+			wantReason: ReasonLogicalNodeNotAllowed,
+		},
+	}
+
+	for i, c := range tCase {
+		c.policy.TrustDomain = "example.com"
+		c.policy.ProjectIDs = []string{testProjectID}
+		v, err := New(c.instance, &c.policy)
+		if err != nil {
+			t.Fatalf("#%v: unexpected error from New(): %v", i, err)
+		}
+
+		r, err := v.Verify(context.Background(), c.data)
+		if c.wantReason != "" {
+			if reason := ReasonOf(err); reason != c.wantReason {
+				t.Errorf("#%v: got reason %q (%v), want %q", i, reason, err, c.wantReason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+			continue
+		}
+		wantAgentID := c.wantAgentID
+		if wantAgentID == "" {
+			wantAgentID = "spiffe://example.com/spire/agent/openstack_iid/alpha/1234"
+		}
+		if r.AgentID != wantAgentID {
+			t.Errorf("#%v: got agent ID %v, want %v", i, r.AgentID, wantAgentID)
+		}
+		if !reflect.DeepEqual(r.Selectors, c.wantSelectors) {
+			t.Errorf("#%v: got selectors %v, want %v", i, r.Selectors, c.wantSelectors)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	instance := fake.NewInstance(testProjectID, nil, nil)
+	for i, c := range []struct {
+		policy  Policy
+		wantErr bool
+	}{
+		// 0: valid
+		{policy: Policy{TrustDomain: "example.com", ProjectIDs: []string{testProjectID}}},
+		// 1: no trust domain
+		{policy: Policy{ProjectIDs: []string{testProjectID}}, wantErr: true},
+		// 2: no project
+		{policy: Policy{TrustDomain: "example.com"}, wantErr: true},
+		// 3: cloud namespace without cloud name
+		{policy: Policy{TrustDomain: "example.com", ProjectIDs: []string{testProjectID}, SelectorNamespace: common.SelectorNamespaceCloud}, wantErr: true},
+		// 4: signed identities required without a verifier
+		{policy: Policy{TrustDomain: "example.com", ProjectIDs: []string{testProjectID}, RequireSignedIdentity: true}, wantErr: true},
+	} {
+		_, err := New(instance, &c.policy)
+		if c.wantErr && err == nil {
+			t.Errorf("#%v: an error expected, got nil", i)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("#%v: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package workload is a small client of the SPIFFE Workload API exposed by the SPIRE Agent.
+package workload
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spiffe/spire/proto/spire/api/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client talks to the Workload API and keeps the latest X.509-SVID and trust bundle of the workload
+type Client struct {
+	conn   *grpc.ClientConn
+	client workload.SpiffeWorkloadAPIClient
+
+	mtx    sync.RWMutex
+	cert   *tls.Certificate
+	roots  *x509.CertPool
+	bundle []*x509.Certificate
+	ready  chan struct{}
+	once   sync.Once
+}
+
+// Dial connects to the Workload API listening on given unix socket
+func Dial(socketPath string) (*Client, error) {
+	conn, err := grpc.Dial(socketPath, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", addr)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:   conn,
+		client: workload.NewSpiffeWorkloadAPIClient(conn),
+		ready:  make(chan struct{}),
+	}, nil
+}
+
+// Close closes the connection to the Workload API
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// WatchX509 receives X.509-SVID updates until ctx is done or the stream fails
+func (c *Client) WatchX509(ctx context.Context) error {
+	stream, err := c.client.FetchX509SVID(withHeader(ctx), &workload.X509SVIDRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := c.updateX509(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// WaitX509 blocks until the first X.509-SVID is received
+func (c *Client) WaitX509(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) updateX509(resp *workload.X509SVIDResponse) error {
+	if len(resp.Svids) == 0 {
+		return errors.New("no X.509-SVID in the Workload API response")
+	}
+	svid := resp.Svids[0]
+
+	chain, err := x509.ParseCertificates(svid.X509Svid)
+	if err != nil {
+		return fmt.Errorf("failed to parse X.509-SVID: %v", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(svid.X509SvidKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse X.509-SVID key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported X.509-SVID key type: %T", key)
+	}
+	bundle, err := x509.ParseCertificates(svid.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to parse trust bundle: %v", err)
+	}
+
+	cert := &tls.Certificate{
+		PrivateKey: signer,
+		Leaf:       chain[0],
+	}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	roots := x509.NewCertPool()
+	for _, b := range bundle {
+		roots.AddCert(b)
+	}
+
+	c.mtx.Lock()
+	c.cert = cert
+	c.roots = roots
+	c.bundle = bundle
+	c.mtx.Unlock()
+	c.once.Do(func() { close(c.ready) })
+	return nil
+}
+
+// GetCertificate returns the current X.509-SVID. It can be used as tls.Config.GetCertificate.
+func (c *Client) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.cert == nil {
+		return nil, errors.New("X.509-SVID is not available yet")
+	}
+	return c.cert, nil
+}
+
+// Bundle returns the CA certificates of the current trust bundle, or nil if no X.509-SVID is received yet
+func (c *Client) Bundle() []*x509.Certificate {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.bundle
+}
+
+// VerifyX509SVID verifies given certificate chain against the trust bundle and returns its SPIFFE ID
+func (c *Client) VerifyX509SVID(chain []*x509.Certificate) (string, error) {
+	if len(chain) == 0 {
+		return "", errors.New("no client certificate")
+	}
+	c.mtx.RLock()
+	roots := c.roots
+	c.mtx.RUnlock()
+	if roots == nil {
+		return "", errors.New("trust bundle is not available yet")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, ic := range chain[1:] {
+		intermediates.AddCert(ic)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return "", fmt.Errorf("invalid X.509-SVID: %v", err)
+	}
+	return SpiffeIDFromCertificate(chain[0])
+}
+
+// WatchJWTBundles calls f with the JWKS of each trust domain whenever the JWT bundles change,
+// until ctx is done, the stream fails or f returns an error.
+func (c *Client) WatchJWTBundles(ctx context.Context, f func(bundles map[string][]byte) error) error {
+	stream, err := c.client.FetchJWTBundles(withHeader(ctx), &workload.JWTBundlesRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := f(resp.Bundles); err != nil {
+			return err
+		}
+	}
+}
+
+// ValidateJWTSVID validates given JWT-SVID for audience and returns its SPIFFE ID
+func (c *Client) ValidateJWTSVID(ctx context.Context, token, audience string) (string, error) {
+	resp, err := c.client.ValidateJWTSVID(withHeader(ctx), &workload.ValidateJWTSVIDRequest{
+		Audience: audience,
+		Svid:     token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid JWT-SVID: %v", err)
+	}
+	return resp.SpiffeId, nil
+}
+
+// SpiffeIDFromCertificate returns the SPIFFE ID in the URI SAN of cert
+func SpiffeIDFromCertificate(cert *x509.Certificate) (string, error) {
+	var ids []*url.URL
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u)
+		}
+	}
+	if len(ids) != 1 {
+		return "", fmt.Errorf("certificate must have exactly one SPIFFE ID, got %d", len(ids))
+	}
+	return ids[0].String(), nil
+}
+
+// withHeader adds the metadata header required by the Workload API
+func withHeader(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+}
@@ -0,0 +1,50 @@
+/**
+ * Copyright 2019, Z Lab Corporation. All rights reserved.
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package workload
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+)
+
+func TestSpiffeIDFromCertificate(t *testing.T) {
+	for _, c := range []struct {
+		uris    []string
+		want    string
+		wantErr bool
+	}{
+		{uris: []string{"spiffe://example.org/web"}, want: "spiffe://example.org/web"},
+		{uris: []string{"https://example.org", "spiffe://example.org/web"}, want: "spiffe://example.org/web"},
+		{uris: nil, wantErr: true},
+		{uris: []string{"spiffe://example.org/a", "spiffe://example.org/b"}, wantErr: true},
+	} {
+		cert := &x509.Certificate{}
+		for _, u := range c.uris {
+			pu, err := url.Parse(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert.URIs = append(cert.URIs, pu)
+		}
+
+		got, err := SpiffeIDFromCertificate(cert)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("expected error for %v", c.uris)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got != c.want {
+			t.Errorf("unexpected SPIFFE ID: got %v, want %v", got, c.want)
+		}
+	}
+}